@@ -39,7 +39,6 @@ import (
 	connect "github.com/bufbuild/connect-go"
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/telekom/canary-bot/data"
 	h "github.com/telekom/canary-bot/helper"
 	"github.com/telekom/canary-bot/metric"
@@ -85,7 +84,7 @@ func StartApi(data data.Database, metrics metric.Metrics, config *Configuration,
 	// just load it if TLS is activated, not considered for edge-terminated TLS
 	var tlsClientCredentials credentials.TransportCredentials
 	if tlsCredentials != nil {
-		tlsClientCredentials, err = h.LoadClientTLSCredentials(config.CaCertPath, config.CaCert)
+		tlsClientCredentials, err = h.LoadClientTLSCredentials(config.CaCertPath, config.CaCert, config.CaCertSystem, "")
 	}
 
 	if err != nil {
@@ -135,17 +134,22 @@ func StartApi(data data.Database, metrics metric.Metrics, config *Configuration,
 	mux.Handle(apiv1connect.NewApiServiceHandler(a, interceptors))
 	mux.Handle("/api/v1/", gwmux)
 	mux.Handle("/metrics",
-		a.NewAuthHandler(
-			metrics.Handler(a.data,
-				promhttp.HandlerFor(
-					metrics.GetRegistry(),
-					promhttp.HandlerOpts{
-						EnableOpenMetrics: true,
-					},
-				),
-			),
-		),
+		a.NewAuthHandler(newMetricsHandler(a.data, metrics)),
 	)
+	mux.Handle("/api/v1/export/samples",
+		a.NewAuthHandler(newExportHandler(a.data, a.config.Units)),
+	)
+	mux.Handle("/api/v1/history/samples",
+		a.NewAuthHandler(newHistoryHandler(a.data, a.config.Units)),
+	)
+	mux.Handle("/ready", newReadyHandler(a.config.Pauser, a.config.Readiness))
+	if a.config.Pauser != nil {
+		mux.Handle("/api/v1/pause", a.NewAuthHandler(newPauseHandler(a.config.Pauser, true)))
+		mux.Handle("/api/v1/resume", a.NewAuthHandler(newPauseHandler(a.config.Pauser, false)))
+	}
+	if a.config.JoinTracer != nil {
+		mux.Handle("/api/v1/join/traces", a.NewAuthHandler(newJoinTraceHandler(a.config.JoinTracer)))
+	}
 	server := &http.Server{
 		Addr:              addr,
 		Handler:           h2c.NewHandler(mux, &http2.Server{}),
@@ -0,0 +1,79 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+// Rows written before the CSV export is flushed to the client
+const EXPORT_FLUSH_ROWS = 1000
+
+// Header of the CSV export
+var exportHeader = []string{"from", "to", "key", "value", "ts", "unit"}
+
+// Handler exporting all samples, streamed row by row.
+// Supported formats (query parameter format): csv
+func newExportHandler(db data.Database, units data.UnitNormalizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if format := r.URL.Query().Get("format"); format != "" && format != "csv" {
+			http.Error(w, "Unsupported export format "+format+", supported: csv", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="samples.csv"`)
+		flusher, _ := w.(http.Flusher)
+		writer := csv.NewWriter(w)
+		if err := writer.Write(exportHeader); err != nil {
+			return
+		}
+
+		rows := 0
+		db.ForEachSample(func(sample *data.Sample) bool {
+			err := writer.Write([]string{
+				sample.From,
+				sample.To,
+				strconv.FormatInt(sample.Key, 10),
+				units.Value(sample.Key, sample.Value),
+				strconv.FormatInt(sample.Ts, 10),
+				units.Unit(sample.Key),
+			})
+			if err != nil {
+				return false
+			}
+			rows++
+			if rows%EXPORT_FLUSH_ROWS == 0 {
+				writer.Flush()
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			return writer.Error() == nil
+		})
+		writer.Flush()
+	})
+}
@@ -0,0 +1,105 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+func Test_exportHandler(t *testing.T) {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1000000", Ts: 1})
+	units, err := data.NewUnitNormalizer(map[string]string{"rtt_total": "ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		units    data.UnitNormalizer
+		code     int
+		expected [][]string
+	}{
+		{name: "default format", code: http.StatusOK, expected: [][]string{exportHeader, {"a", "b", "2", "1000000", "1", "ns"}}},
+		{name: "csv format", query: "?format=csv", code: http.StatusOK, expected: [][]string{exportHeader, {"a", "b", "2", "1000000", "1", "ns"}}},
+		{name: "normalized unit", units: units, code: http.StatusOK, expected: [][]string{exportHeader, {"a", "b", "2", "1", "1", "ms"}}},
+		{name: "unsupported format", query: "?format=json", code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newExportHandler(db, tt.units).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/export/samples"+tt.query, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("Expected status %v, got %v", tt.code, rec.Code)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			if rec.Header().Get("Content-Type") != "text/csv" {
+				t.Errorf("Expected content type text/csv, got %v", rec.Header().Get("Content-Type"))
+			}
+			records, err := csv.NewReader(rec.Body).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, records)
+			}
+		})
+	}
+}
+
+func Test_exportHandlerFlushes(t *testing.T) {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= EXPORT_FLUSH_ROWS; i++ {
+		db.SetSample(&data.Sample{From: "a", To: strconv.Itoa(i), Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	}
+
+	rec := httptest.NewRecorder()
+	newExportHandler(db, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/export/samples", nil))
+	if !rec.Flushed {
+		t.Error("Expected the export to be flushed while streaming")
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != EXPORT_FLUSH_ROWS+2 {
+		t.Errorf("Expected %v rows, got %v", EXPORT_FLUSH_ROWS+2, len(records))
+	}
+}
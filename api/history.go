@@ -0,0 +1,88 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+// History of a sample with the retained values ordered by timestamp,
+// seen is the amount of values recorded including the dropped values
+type sampleHistory struct {
+	From   string         `json:"from"`
+	To     string         `json:"to"`
+	Type   string         `json:"type"`
+	Unit   string         `json:"unit"`
+	Mode   string         `json:"mode"`
+	Seen   int64          `json:"seen"`
+	Values []historyValue `json:"values"`
+}
+
+type historyValue struct {
+	Value string `json:"value"`
+	Ts    int64  `json:"ts"`
+}
+
+// Handler of the sample histories, just the samples with a history.
+// The samples can be filtered by the query parameters type, from and to.
+func newHistoryHandler(db data.Database, units data.UnitNormalizer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed, please use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		histories := []sampleHistory{}
+		db.ForEachSample(func(sample *data.Sample) bool {
+			name := data.SampleName(sample.Key)
+			if (query.Has("type") && query.Get("type") != name) ||
+				(query.Has("from") && query.Get("from") != sample.From) ||
+				(query.Has("to") && query.Get("to") != sample.To) {
+				return true
+			}
+			series, ok := db.GetSampleHistory(sample.Id)
+			if !ok {
+				return true
+			}
+			history := sampleHistory{
+				From:   sample.From,
+				To:     sample.To,
+				Type:   name,
+				Unit:   units.Unit(sample.Key),
+				Mode:   series.Mode,
+				Seen:   series.Seen,
+				Values: make([]historyValue, 0, len(series.Values)),
+			}
+			for _, v := range series.Values {
+				history.Values = append(history.Values, historyValue{Value: units.Value(sample.Key, v.Value), Ts: v.Ts})
+			}
+			histories = append(histories, history)
+			return true
+		})
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(histories)
+	})
+}
@@ -0,0 +1,114 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+func Test_historyHandler(t *testing.T) {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSampleHistory(data.SampleHistory{data.RTT_TOTAL: {Mode: data.HISTORY_LAST, Size: 2}})
+	for ts := int64(1); ts <= 3; ts++ {
+		db.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1000000", Ts: ts})
+	}
+	db.SetSample(&data.Sample{From: "a", To: "c", Key: data.RTT_TOTAL, Value: "2000000", Ts: 1})
+	// no retention of the key
+	db.SetSample(&data.Sample{From: "a", To: "b", Key: data.STATE, Value: "1", Ts: 1})
+	units, err := data.NewUnitNormalizer(map[string]string{"rtt_total": "ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newHistoryHandler(db, units)
+
+	tests := []struct {
+		name     string
+		method   string
+		query    string
+		code     int
+		expected []sampleHistory
+	}{
+		{name: "all histories", method: http.MethodGet, code: http.StatusOK, expected: []sampleHistory{
+			{From: "a", To: "b", Type: "rtt_total", Unit: "ms", Mode: data.HISTORY_LAST, Seen: 3, Values: []historyValue{{Value: "1", Ts: 2}, {Value: "1", Ts: 3}}},
+			{From: "a", To: "c", Type: "rtt_total", Unit: "ms", Mode: data.HISTORY_LAST, Seen: 1, Values: []historyValue{{Value: "2", Ts: 1}}},
+		}},
+		{name: "filtered by to", method: http.MethodGet, query: "?type=rtt_total&to=c", code: http.StatusOK, expected: []sampleHistory{
+			{From: "a", To: "c", Type: "rtt_total", Unit: "ms", Mode: data.HISTORY_LAST, Seen: 1, Values: []historyValue{{Value: "2", Ts: 1}}},
+		}},
+		{name: "filtered by from", method: http.MethodGet, query: "?from=a&to=b", code: http.StatusOK, expected: []sampleHistory{
+			{From: "a", To: "b", Type: "rtt_total", Unit: "ms", Mode: data.HISTORY_LAST, Seen: 3, Values: []historyValue{{Value: "1", Ts: 2}, {Value: "1", Ts: 3}}},
+		}},
+		{name: "unknown from", method: http.MethodGet, query: "?from=b", code: http.StatusOK, expected: []sampleHistory{}},
+		{name: "key without history", method: http.MethodGet, query: "?type=state", code: http.StatusOK, expected: []sampleHistory{}},
+		{name: "method not allowed", method: http.MethodPost, code: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/v1/history/samples"+tt.query, nil))
+			if rec.Code != tt.code {
+				t.Fatalf("Expected status %v, got %v", tt.code, rec.Code)
+			}
+			if tt.code != http.StatusOK {
+				if rec.Header().Get("Allow") != http.MethodGet {
+					t.Errorf("Expected allowed method GET, got %v", rec.Header().Get("Allow"))
+				}
+				return
+			}
+			var histories []sampleHistory
+			if err := json.NewDecoder(rec.Body).Decode(&histories); err != nil {
+				t.Fatal(err)
+			}
+			if len(histories) != len(tt.expected) {
+				t.Fatalf("Expected histories %+v, got %+v", tt.expected, histories)
+			}
+			// the samples are ordered by id, match them by the to-node
+			for _, expected := range tt.expected {
+				found := false
+				for _, history := range histories {
+					if history.To != expected.To {
+						continue
+					}
+					found = true
+					got, _ := json.Marshal(history)
+					want, _ := json.Marshal(expected)
+					if string(got) != string(want) {
+						t.Errorf("Expected history %s, got %s", want, got)
+					}
+				}
+				if !found {
+					t.Errorf("Expected the history of %v, got %+v", expected.To, histories)
+				}
+			}
+		})
+	}
+}
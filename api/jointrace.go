@@ -0,0 +1,74 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Outcomes of a target in a join trace
+const (
+	JOIN_TRACE_JOINED    = "joined"
+	JOIN_TRACE_FAILED    = "failed"
+	JOIN_TRACE_COLLISION = "name-collision"
+	JOIN_TRACE_SKIPPED   = "skipped"
+)
+
+// Provides the decision traces of the last joins, e.g. the mesh
+type JoinTracer interface {
+	JoinTraces() []JoinTrace
+}
+
+// Decision trace of a join, the targets in the order tried.
+// Joined is the target the node joined by, empty if the join failed.
+// The reason is set if the join stopped before all targets were tried.
+type JoinTrace struct {
+	Start    time.Time         `json:"start"`
+	Duration string            `json:"duration"`
+	Joined   string            `json:"joined,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+	Targets  []JoinTraceTarget `json:"targets"`
+}
+
+// A target of a join trace with the outcome, the reason
+// of a failed or skipped target and the duration of the attempt
+type JoinTraceTarget struct {
+	Target   string `json:"target"`
+	Outcome  string `json:"outcome"`
+	Reason   string `json:"reason,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// Handler of the decision traces of the last joins, oldest first
+func newJoinTraceHandler(tracer JoinTracer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "Method not allowed, please use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(tracer.JoinTraces())
+	})
+}
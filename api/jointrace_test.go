@@ -0,0 +1,82 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testJoinTracer []JoinTrace
+
+func (t testJoinTracer) JoinTraces() []JoinTrace {
+	return t
+}
+
+func Test_joinTraceHandler(t *testing.T) {
+	traces := testJoinTracer{{
+		Start:    time.Unix(1, 0).UTC(),
+		Duration: "2s",
+		Joined:   "b:8080",
+		Targets: []JoinTraceTarget{
+			{Target: "a:8080", Outcome: JOIN_TRACE_FAILED, Reason: "connection refused", Duration: "1s"},
+			{Target: "b:8080", Outcome: JOIN_TRACE_JOINED, Duration: "1s"},
+		},
+	}}
+	handler := newJoinTraceHandler(traces)
+
+	tests := []struct {
+		name   string
+		method string
+		code   int
+	}{
+		{name: "list traces", method: http.MethodGet, code: http.StatusOK},
+		{name: "method not allowed", method: http.MethodPost, code: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/v1/join/traces", nil))
+			if rec.Code != tt.code {
+				t.Fatalf("Expected status %v, got %v", tt.code, rec.Code)
+			}
+			if tt.code != http.StatusOK {
+				if rec.Header().Get("Allow") != http.MethodGet {
+					t.Errorf("Expected allowed method GET, got %v", rec.Header().Get("Allow"))
+				}
+				return
+			}
+			var got []JoinTrace
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, []JoinTrace(traces)) {
+				t.Errorf("Expected %v, got %v", traces, got)
+			}
+		})
+	}
+}
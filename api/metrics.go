@@ -0,0 +1,123 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/telekom/canary-bot/data"
+	h "github.com/telekom/canary-bot/helper"
+	"github.com/telekom/canary-bot/metric"
+	"go.uber.org/zap"
+)
+
+// Configuration of the dedicated metrics server
+type MetricsConfiguration struct {
+	Address string
+	Port    int64
+	// TLS
+	CertPath string
+	KeyPath  string
+	// Auth: basic auth in the format user:password and/or bearer tokens
+	BasicAuth string
+	Tokens    []string
+}
+
+// Handler serving the prometheus metrics
+func newMetricsHandler(data data.Database, metrics metric.Metrics) http.Handler {
+	return metrics.Handler(data,
+		promhttp.HandlerFor(
+			metrics.GetRegistry(),
+			promhttp.HandlerOpts{
+				EnableOpenMetrics: true,
+			},
+		),
+	)
+}
+
+// Start a dedicated server for the prometheus metrics.
+// Plain HTTP without auth is used if neither TLS nor auth is configured.
+func StartMetricsServer(data data.Database, metrics metric.Metrics, config *MetricsConfiguration, log *zap.SugaredLogger) error {
+	var handler http.Handler = newMetricsHandler(data, metrics)
+	if config.BasicAuth != "" || len(config.Tokens) > 0 {
+		handler = newMetricsAuthHandler(handler, config, log)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+
+	addr := config.Address + ":" + strconv.FormatInt(config.Port, 10)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Minute,
+	}
+
+	if config.CertPath != "" || config.KeyPath != "" {
+		tlsConfig, err := h.LoadServerTLSCredentials(config.CertPath, config.KeyPath, nil, nil)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConfig
+		log.Info("Serving metrics with TLS on ", addr)
+		return server.ListenAndServeTLS("", "")
+	}
+
+	log.Info("Serving metrics on ", addr)
+	return server.ListenAndServe()
+}
+
+// http auth handler of the metrics server, accepts basic auth or a bearer token
+func newMetricsAuthHandler(h http.Handler, config *MetricsConfiguration, log *zap.SugaredLogger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.BasicAuth != "" {
+			if user, password, ok := r.BasicAuth(); ok {
+				if subtle.ConstantTimeCompare([]byte(user+":"+password), []byte(config.BasicAuth)) == 1 {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer")
+		if len(splitToken) == 2 {
+			authToken := strings.TrimSpace(splitToken[1])
+			for _, t := range config.Tokens {
+				if subtle.ConstantTimeCompare([]byte(authToken), []byte(t)) == 1 {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+
+		log.Warnw("Metrics request", "host", r.Header.Get("X-Forwarded-Host"), "auth", "failed")
+		if config.BasicAuth != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
@@ -0,0 +1,92 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	"go.uber.org/zap"
+)
+
+func Test_newMetricsHandler(t *testing.T) {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := metric.InitMetrics()
+	metrics.GetProbesInFlight().Set(2)
+
+	rec := httptest.NewRecorder()
+	newMetricsHandler(db, metrics).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %v, got %v", http.StatusOK, rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "probes_in_flight 2") {
+		t.Errorf("Expected the registered metrics, got %v", body)
+	}
+}
+
+func Test_newMetricsAuthHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	config := &MetricsConfiguration{BasicAuth: "scraper:secret", Tokens: []string{"token"}}
+	handler := newMetricsAuthHandler(next, config, zap.NewNop().Sugar())
+
+	tests := []struct {
+		name      string
+		basicAuth []string
+		bearer    string
+		code      int
+	}{
+		{name: "basic auth", basicAuth: []string{"scraper", "secret"}, code: http.StatusOK},
+		{name: "wrong password", basicAuth: []string{"scraper", "wrong"}, code: http.StatusUnauthorized},
+		{name: "bearer token", bearer: "token", code: http.StatusOK},
+		{name: "wrong bearer token", bearer: "other", code: http.StatusUnauthorized},
+		{name: "no auth", code: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.basicAuth != nil {
+				req.SetBasicAuth(tt.basicAuth[0], tt.basicAuth[1])
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.code {
+				t.Fatalf("Expected status %v, got %v", tt.code, rec.Code)
+			}
+			if tt.code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != `Basic realm="metrics"` {
+				t.Errorf("Expected the basic auth challenge, got %q", rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Pauses & resumes the outbound probes of the node, e.g. the mesh
+type Pauser interface {
+	Pause() error
+	Resume() error
+	Paused() bool
+}
+
+// Paused state of the pause & resume endpoints
+type pauseResponse struct {
+	Paused bool `json:"paused"`
+}
+
+// Handler pausing (or resuming) the outbound probes by a POST request
+func newPauseHandler(pauser Pauser, pause bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed, please use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		set := pauser.Resume
+		if pause {
+			set = pauser.Pause
+		}
+		if err := set(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(pauseResponse{Paused: pauser.Paused()})
+	})
+}
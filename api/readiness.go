@@ -0,0 +1,68 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Evaluates the readiness policy of the node, e.g. the mesh
+type ReadinessChecker interface {
+	Readiness() Readiness
+}
+
+// Readiness of the node by its policy, ready if all conditions are met
+type Readiness struct {
+	Ready      bool                 `json:"ready"`
+	Policy     string               `json:"policy"`
+	Conditions []ReadinessCondition `json:"conditions"`
+}
+
+// A condition of the readiness policy with the evaluated & required values
+type ReadinessCondition struct {
+	Name    string `json:"name"`
+	Ok      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// Handler of the readiness. Without readiness checker the node is ready or
+// paused (503) if the outbound probes are paused, with a checker the evaluated
+// conditions are returned as JSON, 503 if a condition failed.
+func newReadyHandler(pauser Pauser, checker ReadinessChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if checker != nil {
+			readiness := checker.Readiness()
+			w.Header().Set("Content-Type", "application/json")
+			if !readiness.Ready {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			_ = json.NewEncoder(w).Encode(readiness)
+			return
+		}
+		if pauser != nil && pauser.Paused() {
+			http.Error(w, "paused", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ready\n"))
+	})
+}
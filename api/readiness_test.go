@@ -0,0 +1,81 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type testPauser bool
+
+func (p *testPauser) Pause() error  { *p = true; return nil }
+func (p *testPauser) Resume() error { *p = false; return nil }
+func (p *testPauser) Paused() bool  { return bool(*p) }
+
+type testReadiness Readiness
+
+func (r testReadiness) Readiness() Readiness {
+	return Readiness(r)
+}
+
+func Test_readyHandler(t *testing.T) {
+	paused := testPauser(true)
+	ready := testReadiness{Ready: true, Policy: "peers", Conditions: []ReadinessCondition{{Name: "peers", Ok: true, Message: "2 >= 1"}}}
+	notReady := testReadiness{Ready: false, Policy: "peers", Conditions: []ReadinessCondition{{Name: "peers", Ok: false, Message: "0 < 1"}}}
+
+	tests := []struct {
+		name     string
+		pauser   Pauser
+		checker  ReadinessChecker
+		code     int
+		expected *Readiness
+	}{
+		{name: "ready", code: http.StatusOK},
+		{name: "paused", pauser: &paused, code: http.StatusServiceUnavailable},
+		{name: "policy met", pauser: &paused, checker: ready, code: http.StatusOK, expected: (*Readiness)(&ready)},
+		{name: "policy not met", checker: notReady, code: http.StatusServiceUnavailable, expected: (*Readiness)(&notReady)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newReadyHandler(tt.pauser, tt.checker).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+			if rec.Code != tt.code {
+				t.Fatalf("Expected status %v, got %v", tt.code, rec.Code)
+			}
+			if tt.expected == nil {
+				return
+			}
+			var readiness Readiness
+			if err := json.NewDecoder(rec.Body).Decode(&readiness); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(readiness, *tt.expected) {
+				t.Errorf("Expected %v, got %v", *tt.expected, readiness)
+			}
+		})
+	}
+}
@@ -23,6 +23,7 @@ package api
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"github.com/telekom/canary-bot/data"
@@ -44,6 +45,8 @@ type Api struct {
 
 type Configuration struct {
 	NodeName       string
+	NodeTarget     string
+	NodeLabels     map[string]string
 	Address        string
 	Port           int64
 	Tokens         []string
@@ -54,6 +57,19 @@ type Configuration struct {
 	ServerKey      []byte
 	CaCertPath     []string
 	CaCert         []byte
+	CaCertSystem   bool
+	// Age after which a sample is flagged as stale, 0 disables staleness
+	SampleStaleAfter time.Duration
+	// Units of the exported sample values per sample key
+	Units data.UnitNormalizer
+	// Pauses & resumes the outbound probes, the endpoints are disabled if not set
+	Pauser Pauser
+	// Decision traces of the last joins, the endpoint is disabled if not set
+	JoinTracer JoinTracer
+	// Readiness policy of the node, ready unless paused if not set
+	Readiness ReadinessChecker
+	// Current name of a node renamed at runtime, NodeName is used if not set
+	NodeNamer func() string
 }
 
 // List all measured samples
@@ -64,9 +80,11 @@ func (b *Api) ListSamples(ctx context.Context, req *connect.Request[apiv1.ListSa
 		samples = append(samples, &apiv1.Sample{
 			From:  sample.From,
 			To:    sample.To,
-			Type:  data.SampleName[sample.Key],
-			Value: sample.Value,
+			Type:  data.SampleName(sample.Key),
+			Value: b.config.Units.Value(sample.Key, sample.Value),
 			Ts:    time.Unix(sample.Ts, 0).String(),
+			Age:   int64(sample.Age().Seconds()),
+			Stale: sample.IsStale(b.config.SampleStaleAfter),
 		})
 	}
 
@@ -77,13 +95,88 @@ func (b *Api) ListSamples(ctx context.Context, req *connect.Request[apiv1.ListSa
 
 // List all known nodes in mesh
 func (b *Api) ListNodes(ctx context.Context, req *connect.Request[apiv1.ListNodesRequest]) (*connect.Response[apiv1.ListNodesResponse], error) {
-	nodes := []string{b.config.NodeName}
+	name := b.config.NodeName
+	if b.config.NodeNamer != nil {
+		name = b.config.NodeNamer()
+	}
+	nodes := []string{name}
+	nodeDetails := []*apiv1.Node{{Name: name, Target: b.config.NodeTarget, Labels: b.config.NodeLabels}}
 
 	for _, node := range b.data.GetNodeList() {
 		nodes = append(nodes, node.Name)
+		lastSeen := ""
+		if node.LastSeen > 0 {
+			lastSeen = time.Unix(node.LastSeen, 0).String()
+		}
+		nodeDetails = append(nodeDetails, &apiv1.Node{Name: node.Name, Target: node.Target, Labels: node.Labels, LastSeen: lastSeen})
 	}
 
 	return connect.NewResponse(&apiv1.ListNodesResponse{
-		Nodes: nodes,
+		Nodes:       nodes,
+		NodeDetails: nodeDetails,
+	}), nil
+}
+
+// List all registered sample types
+func (b *Api) ListSampleTypes(ctx context.Context, req *connect.Request[apiv1.ListSampleTypesRequest]) (*connect.Response[apiv1.ListSampleTypesResponse], error) {
+	sampleTypes := []*apiv1.SampleType{}
+
+	for _, t := range data.ListSampleTypes() {
+		sampleTypes = append(sampleTypes, &apiv1.SampleType{
+			Key:  t.Key,
+			Name: t.Name,
+			Unit: b.config.Units.Unit(t.Key),
+		})
+	}
+
+	return connect.NewResponse(&apiv1.ListSampleTypesResponse{
+		SampleTypes: sampleTypes,
+	}), nil
+}
+
+// List the health scores of the nodes
+func (b *Api) ListHealthScores(ctx context.Context, req *connect.Request[apiv1.ListHealthScoresRequest]) (*connect.Response[apiv1.ListHealthScoresResponse], error) {
+	healthScores := []*apiv1.HealthScore{}
+
+	for _, sample := range b.data.GetSampleList() {
+		if sample.Key != data.HEALTH_SCORE {
+			continue
+		}
+		score, err := strconv.ParseFloat(sample.Value, 64)
+		if err != nil {
+			continue
+		}
+		healthScores = append(healthScores, &apiv1.HealthScore{
+			From:  sample.From,
+			To:    sample.To,
+			Score: score,
+			Ts:    time.Unix(sample.Ts, 0).String(),
+			Stale: sample.IsStale(b.config.SampleStaleAfter),
+		})
+	}
+
+	return connect.NewResponse(&apiv1.ListHealthScoresResponse{
+		HealthScores: healthScores,
+	}), nil
+}
+
+// List the last mesh events, filtered by type and node
+func (b *Api) ListEvents(ctx context.Context, req *connect.Request[apiv1.ListEventsRequest]) (*connect.Response[apiv1.ListEventsResponse], error) {
+	events := []*apiv1.Event{}
+
+	for _, event := range b.data.GetEventList() {
+		if (req.Msg.Type != "" && event.Type != req.Msg.Type) || (req.Msg.Node != "" && event.Node != req.Msg.Node) {
+			continue
+		}
+		events = append(events, &apiv1.Event{
+			Ts:      time.Unix(0, event.Ts).String(),
+			Type:    event.Type,
+			Node:    event.Node,
+			Message: event.Message,
+		})
+	}
+
+	return connect.NewResponse(&apiv1.ListEventsResponse{
+		Events: events,
 	}), nil
 }
@@ -0,0 +1,133 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/telekom/canary-bot/data"
+	apiv1 "github.com/telekom/canary-bot/proto/api/v1"
+
+	connect "github.com/bufbuild/connect-go"
+	"go.uber.org/zap"
+)
+
+func testApi(t *testing.T) *Api {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Api{data: db, config: &Configuration{}, log: zap.NewNop().Sugar()}
+}
+
+func Test_ListHealthScores(t *testing.T) {
+	api := testApi(t)
+	api.data.SetSample(&data.Sample{From: "a", To: "b", Key: data.HEALTH_SCORE, Value: "0.75", Ts: 1})
+	api.data.SetSample(&data.Sample{From: "a", To: "c", Key: data.HEALTH_SCORE, Value: "invalid", Ts: 1})
+	api.data.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1000", Ts: 1})
+
+	res, err := api.ListHealthScores(context.Background(), connect.NewRequest(&apiv1.ListHealthScoresRequest{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := res.Msg.HealthScores
+	if len(scores) != 1 {
+		t.Fatalf("Expected just the valid health score, got %v", scores)
+	}
+	if scores[0].From != "a" || scores[0].To != "b" || scores[0].Score != 0.75 {
+		t.Errorf("Expected the health score 0.75 of a to b, got %v", scores[0])
+	}
+}
+
+func Test_ListEvents(t *testing.T) {
+	api := testApi(t)
+	api.data.AddEvent(data.Event{Ts: 1, Type: data.EVENT_JOIN, Node: "a"})
+	api.data.AddEvent(data.Event{Ts: 2, Type: data.EVENT_JOIN, Node: "b"})
+	api.data.AddEvent(data.Event{Ts: 3, Type: data.EVENT_LEAVE, Node: "a"})
+
+	tests := []struct {
+		name     string
+		req      *apiv1.ListEventsRequest
+		expected []string
+	}{
+		{name: "all events", req: &apiv1.ListEventsRequest{}, expected: []string{"join a", "join b", "leave a"}},
+		{name: "filtered by type", req: &apiv1.ListEventsRequest{Type: data.EVENT_JOIN}, expected: []string{"join a", "join b"}},
+		{name: "filtered by node", req: &apiv1.ListEventsRequest{Node: "a"}, expected: []string{"join a", "leave a"}},
+		{name: "filtered by type & node", req: &apiv1.ListEventsRequest{Type: data.EVENT_LEAVE, Node: "b"}, expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := api.ListEvents(context.Background(), connect.NewRequest(tt.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			events := []string{}
+			for _, event := range res.Msg.Events {
+				events = append(events, event.Type+" "+event.Node)
+			}
+			if !reflect.DeepEqual(events, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, events)
+			}
+		})
+	}
+}
+
+func Test_ListSamplesUnits(t *testing.T) {
+	api := testApi(t)
+	units, err := data.NewUnitNormalizer(map[string]string{"rtt_total": "ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.config.Units = units
+	api.data.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1500000", Ts: 1})
+	api.data.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_REQUEST, Value: "1000", Ts: 1})
+
+	res, err := api.ListSamples(context.Background(), connect.NewRequest(&apiv1.ListSampleRequest{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{}
+	for _, sample := range res.Msg.Samples {
+		values[sample.Type] = sample.Value
+	}
+	expected := map[string]string{"rtt_total": "1.5", "rtt_request": "1000"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, got %v", expected, values)
+	}
+
+	types, err := api.ListSampleTypes(context.Background(), connect.NewRequest(&apiv1.ListSampleTypesRequest{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sampleType := range types.Msg.SampleTypes {
+		expected := data.UnitNormalizer{}.Unit(sampleType.Key)
+		if sampleType.Key == data.RTT_TOTAL {
+			expected = "ms"
+		}
+		if sampleType.Unit != expected {
+			t.Errorf("Expected unit %v of %v, got %v", expected, sampleType.Name, sampleType.Unit)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Version of the config file schema.
+// Raise it if flags are renamed or their values change
+// and register a migration from the previous version.
+const CONFIG_VERSION = 1
+
+// Key of the schema version in the config file
+const configVersionKey = "configVersion"
+
+// Migration of the config file settings to the next version.
+// The changes are returned to be logged.
+type configMigration func(settings map[string]interface{}) []string
+
+// Migrations of the config file by the version they upgrade from,
+// e.g. the migration 1 upgrades a config of version 1 to version 2
+var configMigrations = map[int]configMigration{}
+
+// Rename a key of the config file settings, the changes are returned
+func renameConfigKey(settings map[string]interface{}, from string, to string) []string {
+	value, ok := settings[from]
+	if !ok {
+		return nil
+	}
+	delete(settings, from)
+	if _, ok := settings[to]; ok {
+		return []string{fmt.Sprintf("removed %v, %v is set", from, to)}
+	}
+	settings[to] = value
+	return []string{fmt.Sprintf("renamed %v to %v", from, to)}
+}
+
+// Upgrade the config file settings to the current version by the migrations.
+// A config without version is of the first version.
+// The version & the applied changes are returned.
+func migrateConfig(settings map[string]interface{}, current int, migrations map[int]configMigration) (int, []string, error) {
+	version := 1
+	if raw, ok := settings[configVersionKey]; ok {
+		v, ok := raw.(int)
+		if !ok || v < 1 {
+			return 0, nil, fmt.Errorf("invalid %v %v, has to be a positive number", configVersionKey, raw)
+		}
+		version = v
+		delete(settings, configVersionKey)
+	}
+
+	var changes []string
+	for ; version < current; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return version, changes, fmt.Errorf("no migration of config version %v", version)
+		}
+		for _, change := range migrate(settings) {
+			changes = append(changes, fmt.Sprintf("v%v -> v%v: %v", version, version+1, change))
+		}
+	}
+	return version, changes, nil
+}
+
+// Format a config file value as flag value.
+// Lists are joined comma-separated, maps as comma-separated KEY=VALUE pairs.
+func configValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = configValue(item)
+		}
+		return strings.Join(values, ",")
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			pairs = append(pairs, key+"="+configValue(item))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// Apply a YAML or JSON config file to the flags not set by the command line
+// or the environment. The keys are the flag names, an older config is migrated
+// to the current version and unknown keys are ignored with a warning.
+func applyConfigFile(path string, flags *pflag.FlagSet) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	settings := map[string]interface{}{}
+	if err := yaml.Unmarshal(raw, &settings); err != nil {
+		return fmt.Errorf("parse config %v: %w", path, err)
+	}
+
+	version, changes, err := migrateConfig(settings, CONFIG_VERSION, configMigrations)
+	if err != nil {
+		return fmt.Errorf("migrate config %v: %w", path, err)
+	}
+	if version > CONFIG_VERSION {
+		log.Printf("Config %v is of version %v, newer than the supported version %v - unknown keys are ignored", path, version, CONFIG_VERSION)
+	}
+	for _, change := range changes {
+		log.Printf("Migrated config %v: %v", path, change)
+	}
+	if len(changes) > 0 {
+		log.Printf("Please update config %v to version %v", path, CONFIG_VERSION)
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f := flags.Lookup(key)
+		if f == nil || key == configFlag {
+			log.Printf("Unknown config key %v in %v - ignored", key, path)
+			continue
+		}
+		if f.Changed {
+			continue
+		}
+		if err := flags.Set(key, configValue(settings[key])); err != nil {
+			return fmt.Errorf("config %v: invalid value of %v: %w", path, key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/spf13/pflag"
+)
+
+// Flags of a test config
+type testConfig struct {
+	name     string
+	port     int64
+	targets  []string
+	interval time.Duration
+	pools    map[string]int
+}
+
+func testConfigFlags(c *testConfig) *pflag.FlagSet {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVar(&c.name, "name", "", "")
+	flags.Int64Var(&c.port, "listen-port", 8081, "")
+	flags.StringSliceVar(&c.targets, "target", []string{}, "")
+	flags.DurationVar(&c.interval, "probe-interval", time.Second, "")
+	flags.StringToIntVar(&c.pools, "probe-pool", map[string]int{}, "")
+	flags.String(configFlag, "", "")
+	return flags
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_applyConfigFile(t *testing.T) {
+	c := &testConfig{}
+	flags := testConfigFlags(c)
+	if err := flags.Parse([]string{"--name", "owl"}); err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfig(t, `
+configVersion: 1
+name: swan
+listen-port: 9090
+target: [goose:8081, eagle:8081]
+probe-interval: 5s
+probe-pool:
+  rtt: 8
+  push: 4
+unknown-key: true
+`)
+	if err := applyConfigFile(path, flags); err != nil {
+		t.Fatal(err)
+	}
+	// the flag takes precedence, unknown keys are ignored
+	expected := &testConfig{
+		name:     "owl",
+		port:     9090,
+		targets:  []string{"goose:8081", "eagle:8081"},
+		interval: 5 * time.Second,
+		pools:    map[string]int{"rtt": 8, "push": 4},
+	}
+	if diff := deep.Equal(c, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	if err := applyConfigFile(writeConfig(t, "listen-port: abc"), testConfigFlags(&testConfig{})); err == nil {
+		t.Error("Expected an error of an invalid value")
+	}
+}
+
+func Test_migrateConfig(t *testing.T) {
+	// version 3 renamed the port of version 1 and the interval of version 2
+	migrations := map[int]configMigration{
+		1: func(settings map[string]interface{}) []string {
+			return renameConfigKey(settings, "port", "listen-port")
+		},
+		2: func(settings map[string]interface{}) []string {
+			return renameConfigKey(settings, "interval", "probe-interval")
+		},
+	}
+
+	tests := []struct {
+		name            string
+		settings        map[string]interface{}
+		expected        map[string]interface{}
+		expectedVersion int
+		expectedChanges int
+		expectErr       bool
+	}{
+		{name: "current version", settings: map[string]interface{}{configVersionKey: 3, "port": 1}, expected: map[string]interface{}{"port": 1}, expectedVersion: 3},
+		{name: "without version", settings: map[string]interface{}{"port": 1, "interval": "1s"}, expected: map[string]interface{}{"listen-port": 1, "probe-interval": "1s"}, expectedVersion: 3, expectedChanges: 2},
+		{name: "older version", settings: map[string]interface{}{configVersionKey: 2, "port": 1, "interval": "1s"}, expected: map[string]interface{}{"port": 1, "probe-interval": "1s"}, expectedVersion: 3, expectedChanges: 1},
+		{name: "newer version", settings: map[string]interface{}{configVersionKey: 4}, expected: map[string]interface{}{}, expectedVersion: 4},
+		{name: "invalid version", settings: map[string]interface{}{configVersionKey: "v1"}, expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, changes, err := migrateConfig(tt.settings, 3, migrations)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("error is %v, but expected error is %v", err, tt.expectErr)
+			}
+			if tt.expectErr {
+				return
+			}
+			if version != tt.expectedVersion || len(changes) != tt.expectedChanges {
+				t.Errorf("got version %v with changes %v", version, changes)
+			}
+			if diff := deep.Equal(tt.settings, tt.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+
+	if _, _, err := migrateConfig(map[string]interface{}{}, 3, map[int]configMigration{}); err == nil {
+		t.Error("Expected an error without migration")
+	}
+}
+
+func Test_renameConfigKey(t *testing.T) {
+	settings := map[string]interface{}{"port": 1}
+	if changes := renameConfigKey(settings, "port", "listen-port"); len(changes) != 1 {
+		t.Errorf("Expected one change, got %v", changes)
+	}
+	if diff := deep.Equal(settings, map[string]interface{}{"listen-port": 1}); diff != nil {
+		t.Error(diff)
+	}
+
+	// the new key takes precedence
+	settings = map[string]interface{}{"port": 1, "listen-port": 2}
+	renameConfigKey(settings, "port", "listen-port")
+	if diff := deep.Equal(settings, map[string]interface{}{"listen-port": 2}); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -33,27 +33,51 @@ import (
 	"go.uber.org/zap"
 )
 
-// Sample keys
+// Core sample keys, see the sample type registry for their names
 const (
-	STATE       = 1
-	RTT_TOTAL   = 2
-	RTT_REQUEST = 3
+	STATE        = 1
+	RTT_TOTAL    = 2
+	RTT_REQUEST  = 3
+	CLOCK_SKEW   = 4
+	HEALTH_SCORE = 5
+	HEARTBEAT    = 6
+	// One-way delay from the from-node to the to-node, measured by the to-node
+	ONE_WAY_DELAY = 7
+	// Throughput of a probe streamed to the to-node
+	THROUGHPUT = 8
 )
 
-// Sample keys map for mapping back to string
-var SampleName = map[int64]string{
-	STATE:       "state",
-	RTT_TOTAL:   "rtt_total",
-	RTT_REQUEST: "rtt_request",
-}
+// Sample keys of the RTT with a payload are RTT_PAYLOAD + payload size in bytes,
+// the payload size is limited to MAX_RTT_PAYLOAD_SIZE.
+const (
+	RTT_PAYLOAD          = 1 << 20
+	MAX_RTT_PAYLOAD_SIZE = 64 << 10
+)
 
 // Database that is used by the mesh.
 // It will hold node and sample data.
 // It is a in-memory database. A logger
-// is provided.
+// is provided. The node names of samples
+// are interned in the name table. The last
+// mesh events are kept in the event log.
+// The sample hook is called for every stored sample.
+// Old samples are spilled to disk, if enabled.
+// The sample values are rounded before stored, if set.
+// Self-referential samples are dropped, if set.
+// Local samples are stamped with the probe group, if set.
+// The history of the samples is kept by sample key, if set.
 type Database struct {
 	*memdb.MemDB
-	log *zap.SugaredLogger
+	log            *zap.SugaredLogger
+	names          *nameTable
+	events         *eventLog
+	sampleHook     func(*Sample)
+	spill          *spillStore
+	rounding       SampleRounding
+	selfSampleDrop func(*Sample)
+	selfId         uint32
+	group          string
+	history        *historyStore
 }
 
 // A database node will have an Id
@@ -63,13 +87,20 @@ type Database struct {
 // of the node. The state is the status of
 // the node. LastSampleTs will be updated if
 // a new sample gets measured. Is used by
-// the clean up routine.
+// the clean up routine. Labels are used to
+// group nodes e.g. by region, zone or role.
+// LastSeen is the last successful contact.
+// The sample filter defines the samples
+// pushed to the node.
 type Node struct {
 	Id            uint32
 	Name          string
 	Target        string
 	State         int
 	StateChangeTs int64
+	Labels        map[string]string
+	LastSeen      int64
+	SampleFilter  *SampleFilter
 }
 
 // A sample represents a measurement
@@ -83,15 +114,54 @@ type Sample struct {
 	Key   int64
 	Value string
 	Ts    int64
+	// Forwards until the sample was received, 0 for local samples
+	Hops uint32
+	// Stable ids (target hashes) of the from & to node, the names are
+	// metadata. 0 if unknown, e.g. an external target or an older node.
+	FromId uint32
+	ToId   uint32
+	// Sub-fields of a multi-field sample by sub-key, e.g. the status & size
+	// of a HTTP probe; nil for single-value samples. Not modified after stored.
+	Fields map[string]string
+	// Probe group of the from-node, e.g. edge; empty if not set
+	Group string
+}
+
+// A sample as stored in the database.
+// From & to are indexes of the name table
+// to not duplicate the node names per sample.
+type storedSample struct {
+	Id    uint32
+	From  uint32
+	To    uint32
+	Key   int64
+	Value string
+	Ts    int64
+	Hops  uint32
+	// Stable ids of the from & to node
+	FromId uint32
+	ToId   uint32
+	Fields map[string]string
+	Group  string
+}
+
+// A tombstone of a node that left the mesh.
+// It prevents a re-add of the node by stale
+// node lists and discoveries. Ts is the time
+// the node left.
+type Tombstone struct {
+	Id   uint32
+	Name string
+	Ts   int64
 }
 
 // Will create a in-memory database and
 // a looger. The database will be created with
-// 2 schemas: node, sample
+// 3 schemas: node, sample, tombstone
 func NewMemDB(logger *zap.SugaredLogger) (Database, error) {
 	defer logger.Sync()
 
-	// 2 tables: node, sample
+	// 3 tables: node, sample, tombstone
 	schema := &memdb.DBSchema{
 		Tables: map[string]*memdb.TableSchema{
 			"node": {
@@ -138,13 +208,13 @@ func NewMemDB(logger *zap.SugaredLogger) (Database, error) {
 						Name:         "from",
 						Unique:       false,
 						AllowMissing: false,
-						Indexer:      &memdb.StringFieldIndex{Field: "From"},
+						Indexer:      &memdb.UintFieldIndex{Field: "From"},
 					},
 					"to": {
 						Name:         "to",
 						Unique:       false,
 						AllowMissing: false,
-						Indexer:      &memdb.StringFieldIndex{Field: "To"},
+						Indexer:      &memdb.UintFieldIndex{Field: "To"},
 					},
 					"key": {
 						Name:         "key",
@@ -166,25 +236,37 @@ func NewMemDB(logger *zap.SugaredLogger) (Database, error) {
 					},
 				},
 			},
+			"tombstone": {
+				Name: "tombstone",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: &memdb.UintFieldIndex{Field: "Id"},
+					},
+				},
+			},
 		},
 	}
 	// Create new database
 	db, err := memdb.NewMemDB(schema)
-	return Database{db, logger}, err
+	return Database{db, logger, newNameTable(), newEventLog(DEFAULT_EVENT_LOG_SIZE), nil, nil, nil, nil, 0, "", newHistoryStore()}, err
 }
 
 // Convert a given database node to a mesh node
 func (n *Node) Convert() *meshv1.Node {
 	return &meshv1.Node{
-		Name:   n.Name,
-		Target: n.Target,
+		Name:         n.Name,
+		Target:       n.Target,
+		Labels:       copyLabels(n.Labels),
+		SampleFilter: n.SampleFilter.Convert(),
 	}
 }
 
 // Convert a given mesh node to a database node
 // with a given state of the node
 func Convert(n *meshv1.Node, state int) *Node {
-	id, err := h.Hash(n.Target)
+	id, err := h.Hash(h.NormalizeTarget(n.Target))
 	if err != nil {
 		l.Printf("Could not get the hash value of the ID, please check the hash function")
 	}
@@ -195,7 +277,21 @@ func Convert(n *meshv1.Node, state int) *Node {
 		Target:        n.Target,
 		State:         state,
 		StateChangeTs: time.Now().Unix(),
+		Labels:        copyLabels(n.Labels),
+		SampleFilter:  ConvertSampleFilter(n.SampleFilter),
+	}
+}
+
+// Copy node labels, all labels are kept - also unknown ones
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
 	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
 }
 
 // Get the id of a database node.
@@ -210,8 +306,27 @@ func GetId(n *Node) uint32 {
 }
 
 // Get the id of a given sample.
-// The id is a hash integer
+// The id is a hash integer of the stable node ids and the key,
+// so a renamed node keeps the ids of its samples. The name of a node
+// without id (an external target) is hashed instead, samples without
+// from id (older nodes) keep the legacy id of the names.
 func GetSampleId(p *Sample) uint32 {
+	if p.FromId == 0 {
+		return LegacySampleId(p)
+	}
+	to := p.To
+	if p.ToId != 0 {
+		to = "#" + strconv.FormatUint(uint64(p.ToId), 10)
+	}
+	id, err := h.Hash("#" + strconv.FormatUint(uint64(p.FromId), 10) + "/" + to + "/" + strconv.FormatInt(p.Key, 10))
+	if err != nil {
+		l.Printf("Could not get the hash value of the sample, please check the hash function")
+	}
+	return id
+}
+
+// Get the legacy id of a sample by the names of the from & to node
+func LegacySampleId(p *Sample) uint32 {
 	id, err := h.Hash(p.From + p.To + strconv.FormatInt(p.Key, 10))
 	if err != nil {
 		l.Printf("Could not get the hash value of the sample, please check the hash function")
@@ -0,0 +1,84 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// Buckets of the sample digest, samples are assigned by id
+const DIGEST_BUCKETS = 256
+
+// Get the digest bucket of a sample id
+func DigestBucket(id uint32) uint32 {
+	return id % DIGEST_BUCKETS
+}
+
+// Hash of a sample in the digest, a sample is newer by its timestamp
+func digestHash(id uint32, ts int64) uint64 {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b, id)
+	binary.BigEndian.PutUint64(b[4:], uint64(ts))
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// Get the digest of the sample store, the hashes of the buckets.
+// A bucket hash is the xor of the hashes of id & timestamp of its samples,
+// so the digest does not depend on the order of the samples.
+func (db *Database) GetSampleDigest() []uint64 {
+	digest := make([]uint64, DIGEST_BUCKETS)
+	db.ForEachSample(func(sample *Sample) bool {
+		digest[DigestBucket(sample.Id)] ^= digestHash(sample.Id, sample.Ts)
+		return true
+	})
+	return digest
+}
+
+// Get the samples of digest buckets
+func (db *Database) GetDigestBucketSamples(buckets []uint32) []*Sample {
+	selected := make(map[uint32]bool, len(buckets))
+	for _, bucket := range buckets {
+		selected[bucket] = true
+	}
+	var samples []*Sample
+	db.ForEachSample(func(sample *Sample) bool {
+		if selected[DigestBucket(sample.Id)] {
+			samples = append(samples, sample)
+		}
+		return true
+	})
+	return samples
+}
+
+// Get the differing buckets of two digests
+func DigestDiff(a []uint64, b []uint64) []uint32 {
+	var buckets []uint32
+	for i := range a {
+		if i >= len(b) || a[i] != b[i] {
+			buckets = append(buckets, uint32(i))
+		}
+	}
+	return buckets
+}
@@ -0,0 +1,79 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func Test_GetSampleDigest(t *testing.T) {
+	a, _ := NewMemDB(log)
+	b, _ := NewMemDB(log)
+	a.SetSample(&Sample{From: "node_1", To: "node_2", Key: 1, Value: "1", Ts: 1})
+	a.SetSample(&Sample{From: "node_1", To: "node_3", Key: 1, Value: "2", Ts: 2})
+	// the digest does not depend on the order of the samples
+	b.SetSample(&Sample{From: "node_1", To: "node_3", Key: 1, Value: "2", Ts: 2})
+	b.SetSample(&Sample{From: "node_1", To: "node_2", Key: 1, Value: "1", Ts: 1})
+
+	if diff := DigestDiff(a.GetSampleDigest(), b.GetSampleDigest()); len(diff) != 0 {
+		t.Errorf("Expected equal digests, got differing buckets %v", diff)
+	}
+
+	newer := &Sample{From: "node_1", To: "node_2", Key: 1, Value: "3", Ts: 3}
+	b.SetSample(newer)
+	expected := []uint32{DigestBucket(newer.Id)}
+	if diff := deep.Equal(DigestDiff(a.GetSampleDigest(), b.GetSampleDigest()), expected); diff != nil {
+		t.Error(diff)
+	}
+
+	bucketSamples := b.GetDigestBucketSamples(expected)
+	if len(bucketSamples) == 0 {
+		t.Fatal("Expected the samples of the differing bucket")
+	}
+	for _, sample := range bucketSamples {
+		if DigestBucket(sample.Id) != expected[0] {
+			t.Errorf("Sample %v is not in bucket %v", sample.Id, expected[0])
+		}
+	}
+}
+
+func Test_DigestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		a        []uint64
+		b        []uint64
+		expected []uint32
+	}{
+		{name: "equal", a: []uint64{1, 2}, b: []uint64{1, 2}, expected: nil},
+		{name: "different", a: []uint64{1, 2, 3}, b: []uint64{1, 5, 4}, expected: []uint32{1, 2}},
+		{name: "shorter", a: []uint64{1, 2}, b: []uint64{1}, expected: []uint32{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := deep.Equal(DigestDiff(tt.a, tt.b), tt.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
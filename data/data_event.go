@@ -0,0 +1,114 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import "sync"
+
+// Types of mesh events
+const (
+	EVENT_JOIN         = "join"
+	EVENT_JOIN_FAILED  = "join-failed"
+	EVENT_LEAVE        = "leave"
+	EVENT_STATE_CHANGE = "state-change"
+	EVENT_EVICTION     = "eviction"
+	EVENT_PAUSE        = "pause"
+	EVENT_RESUME       = "resume"
+)
+
+// Default amount of events kept in the event log
+const DEFAULT_EVENT_LOG_SIZE = 1000
+
+// A mesh event, e.g. a node joined or changed its state.
+// The timestamp is in unix nanoseconds.
+type Event struct {
+	Ts      int64
+	Type    string
+	Node    string
+	Message string
+}
+
+// Ring buffer of the last mesh events, the oldest
+// events are overwritten if the buffer is full.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]Event, size)}
+}
+
+// Resize the event log, the last events are kept
+func (l *eventLog) resize(size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.list()
+	if len(events) > size {
+		events = events[len(events)-size:]
+	}
+	l.events = make([]Event, size)
+	l.next = copy(l.events, events)
+	l.full = size > 0 && l.next == size
+	if l.full {
+		l.next = 0
+	}
+}
+
+func (l *eventLog) add(event Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) == 0 {
+		return
+	}
+	l.events[l.next] = event
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// List the events oldest first, the lock has to be held
+func (l *eventLog) list() []Event {
+	if !l.full {
+		return append([]Event{}, l.events[:l.next]...)
+	}
+	return append(append([]Event{}, l.events[l.next:]...), l.events[:l.next]...)
+}
+
+// Add an event to the event log
+func (db *Database) AddEvent(event Event) {
+	db.events.add(event)
+}
+
+// Get the events of the event log, oldest first
+func (db *Database) GetEventList() []Event {
+	db.events.mu.Lock()
+	defer db.events.mu.Unlock()
+	return db.events.list()
+}
+
+// Set the amount of events kept in the event log, 0 disables the event log
+func (db *Database) SetEventLogSize(size int) {
+	db.events.resize(size)
+}
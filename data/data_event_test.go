@@ -0,0 +1,96 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"go.uber.org/zap"
+)
+
+func Test_eventLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		add      []string
+		expected []string
+	}{
+		{name: "empty", size: 3, add: nil, expected: []string{}},
+		{name: "not full", size: 3, add: []string{"a", "b"}, expected: []string{"a", "b"}},
+		{name: "full", size: 3, add: []string{"a", "b", "c"}, expected: []string{"a", "b", "c"}},
+		{name: "overwritten", size: 3, add: []string{"a", "b", "c", "d", "e"}, expected: []string{"c", "d", "e"}},
+		{name: "disabled", size: 0, add: []string{"a"}, expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newEventLog(tt.size)
+			for _, node := range tt.add {
+				l.add(Event{Node: node})
+			}
+			if diff := deep.Equal(eventNodes(l.list()), tt.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func Test_SetEventLogSize(t *testing.T) {
+	db, err := NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range []string{"a", "b", "c", "d"} {
+		db.AddEvent(Event{Type: EVENT_JOIN, Node: node})
+	}
+
+	db.SetEventLogSize(2)
+	if diff := deep.Equal(eventNodes(db.GetEventList()), []string{"c", "d"}); diff != nil {
+		t.Error(diff)
+	}
+	db.AddEvent(Event{Type: EVENT_LEAVE, Node: "e"})
+	if diff := deep.Equal(eventNodes(db.GetEventList()), []string{"d", "e"}); diff != nil {
+		t.Error(diff)
+	}
+
+	db.SetEventLogSize(4)
+	db.AddEvent(Event{Type: EVENT_LEAVE, Node: "f"})
+	if diff := deep.Equal(eventNodes(db.GetEventList()), []string{"d", "e", "f"}); diff != nil {
+		t.Error(diff)
+	}
+
+	// 0 disables the event log
+	db.SetEventLogSize(0)
+	db.AddEvent(Event{Type: EVENT_LEAVE, Node: "g"})
+	if events := db.GetEventList(); len(events) != 0 {
+		t.Errorf("Expected no events in the disabled event log, got %v", events)
+	}
+}
+
+func eventNodes(events []Event) []string {
+	nodes := []string{}
+	for _, event := range events {
+		nodes = append(nodes, event.Node)
+	}
+	return nodes
+}
@@ -0,0 +1,181 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Retention modes of the sample history: the last values (last) or
+// a uniform random subset of all values (reservoir)
+const (
+	HISTORY_LAST      = "last"
+	HISTORY_RESERVOIR = "reservoir"
+)
+
+// Retention of the history of a sample key, at most size values are kept
+type HistoryRetention struct {
+	Mode string
+	Size int
+}
+
+// Retention of the sample history by sample key,
+// samples of keys without retention have no history
+type SampleHistory map[int64]HistoryRetention
+
+// Create the history retention of sample type names in the format MODE:SIZE,
+// e.g. rtt_total=reservoir:1000,state=last:100
+func NewSampleHistory(config map[string]string) (SampleHistory, error) {
+	h := SampleHistory{}
+	for name, retention := range config {
+		key, ok := SampleKey(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown sample type %v", name)
+		}
+		mode, size, ok := strings.Cut(retention, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid history %q of sample type %v, format: MODE:SIZE", retention, name)
+		}
+		if mode != HISTORY_LAST && mode != HISTORY_RESERVOIR {
+			return nil, fmt.Errorf("unknown history mode %v of sample type %v, please use last or reservoir", mode, name)
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid history size %q of sample type %v, please use a positive number", size, name)
+		}
+		h[key] = HistoryRetention{Mode: mode, Size: n}
+	}
+	return h, nil
+}
+
+// A value of the sample history
+type HistoryValue struct {
+	Value string
+	Ts    int64
+}
+
+// History of a sample, the retained values ordered by timestamp.
+// Seen is the amount of values recorded, including the dropped values.
+type HistorySeries struct {
+	Mode   string
+	Seen   int64
+	Values []HistoryValue
+}
+
+// Bounded history of the samples by sample id. The last values are
+// kept in a ring, the reservoir keeps a uniform random subset of
+// all values recorded (algorithm R).
+type historyStore struct {
+	mu        sync.Mutex
+	retention SampleHistory
+	series    map[uint32]*historySeries
+	rand      *rand.Rand
+}
+
+type historySeries struct {
+	mode   string
+	values []HistoryValue
+	// next value to overwrite of the last values
+	next int
+	seen int64
+}
+
+func newHistoryStore() *historyStore {
+	return &historyStore{
+		series: map[uint32]*historySeries{},
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Record a sample value, if the sample key has a retention. Just the
+// samples measured by this node are recorded, the histories are bound
+// by the own samples and not by the samples received from the mesh.
+func (s *historyStore) add(sample *Sample) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.retention[sample.Key]
+	if !ok || sample.Hops > 0 {
+		return
+	}
+	series, ok := s.series[sample.Id]
+	if !ok {
+		series = &historySeries{mode: r.Mode}
+		s.series[sample.Id] = series
+	}
+	series.seen++
+	value := HistoryValue{Value: sample.Value, Ts: sample.Ts}
+	if len(series.values) < r.Size {
+		series.values = append(series.values, value)
+		return
+	}
+	if r.Mode == HISTORY_LAST {
+		series.values[series.next] = value
+		series.next = (series.next + 1) % r.Size
+		return
+	}
+	// the n-th value replaces a random value with probability size/n
+	if i := s.rand.Int63n(series.seen); i < int64(r.Size) {
+		series.values[i] = value
+	}
+}
+
+func (s *historyStore) delete(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.series, id)
+}
+
+func (s *historyStore) get(id uint32) (HistorySeries, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	series, ok := s.series[id]
+	if !ok {
+		return HistorySeries{}, false
+	}
+	// the ring of the last values is unrolled from the oldest value,
+	// values of the same timestamp stay in the recorded order
+	values := make([]HistoryValue, 0, len(series.values))
+	values = append(values, series.values[series.next:]...)
+	values = append(values, series.values[:series.next]...)
+	sort.SliceStable(values, func(i, j int) bool { return values[i].Ts < values[j].Ts })
+	return HistorySeries{Mode: series.mode, Seen: series.seen, Values: values}, true
+}
+
+// Set the history retention of the samples stored by SetSample,
+// it has to be set before the database is shared. Samples of keys
+// without retention have no history, the default.
+func (db *Database) SetSampleHistory(history SampleHistory) {
+	db.history.mu.Lock()
+	defer db.history.mu.Unlock()
+	db.history.retention = history
+}
+
+// Get the history of a sample by id, false if the sample has no history
+func (db *Database) GetSampleHistory(id uint32) (HistorySeries, bool) {
+	return db.history.get(id)
+}
@@ -0,0 +1,184 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func Test_NewSampleHistory(t *testing.T) {
+	h, err := NewSampleHistory(map[string]string{"rtt_total": "reservoir:1000", "state": "last:100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := h[RTT_TOTAL]; r.Mode != HISTORY_RESERVOIR || r.Size != 1000 {
+		t.Errorf("Expected a reservoir of 1000 values, got %+v", r)
+	}
+	if r := h[STATE]; r.Mode != HISTORY_LAST || r.Size != 100 {
+		t.Errorf("Expected the last 100 values, got %+v", r)
+	}
+
+	for _, config := range []map[string]string{
+		{"unknown": "last:10"},
+		{"rtt_total": "last"},
+		{"rtt_total": "all:10"},
+		{"rtt_total": "last:0"},
+		{"rtt_total": "reservoir:-1"},
+		{"rtt_total": "reservoir:many"},
+	} {
+		if _, err := NewSampleHistory(config); err == nil {
+			t.Errorf("Expected an error for history %v", config)
+		}
+	}
+}
+
+func Test_SampleHistoryLast(t *testing.T) {
+	db, err := NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSampleHistory(SampleHistory{RTT_TOTAL: {Mode: HISTORY_LAST, Size: 3}})
+
+	var id uint32
+	for ts := int64(1); ts <= 5; ts++ {
+		sample := &Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: strconv.FormatInt(ts*100, 10), Ts: ts}
+		db.SetSample(sample)
+		id = sample.Id
+	}
+	// an older received sample is not stored and not recorded
+	db.SetReceivedSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1})
+	db.SetSampleNaN(id)
+
+	series, ok := db.GetSampleHistory(id)
+	if !ok {
+		t.Fatal("Expected a history of the sample")
+	}
+	if series.Mode != HISTORY_LAST || series.Seen != 6 {
+		t.Errorf("Expected 6 values seen in mode last, got %v in mode %v", series.Seen, series.Mode)
+	}
+	expected := []string{"400", "500", "NaN"}
+	if len(series.Values) != len(expected) {
+		t.Fatalf("Expected values %v, got %+v", expected, series.Values)
+	}
+	for i, v := range series.Values {
+		if v.Value != expected[i] {
+			t.Errorf("Expected value %v at %v, got %v", expected[i], i, v.Value)
+		}
+	}
+
+	// samples of keys without retention have no history
+	other := &Sample{From: "a", To: "b", Key: STATE, Value: "1", Ts: 1}
+	db.SetSample(other)
+	if _, ok := db.GetSampleHistory(other.Id); ok {
+		t.Error("Expected no history of a key without retention")
+	}
+
+	db.DeleteSample(id)
+	if _, ok := db.GetSampleHistory(id); ok {
+		t.Error("Expected the history to be deleted with the sample")
+	}
+}
+
+func Test_SampleHistoryOrder(t *testing.T) {
+	db, err := NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSampleHistory(SampleHistory{RTT_TOTAL: {Mode: HISTORY_LAST, Size: 3}})
+
+	// values of the same second keep the recorded order
+	sample := &Sample{From: "a", To: "b", Key: RTT_TOTAL, Ts: 1}
+	for v := 1; v <= 5; v++ {
+		sample.Value = strconv.Itoa(v)
+		db.SetSample(sample)
+	}
+	series, _ := db.GetSampleHistory(sample.Id)
+	expected := []string{"3", "4", "5"}
+	if len(series.Values) != len(expected) {
+		t.Fatalf("Expected values %v, got %+v", expected, series.Values)
+	}
+	for i, v := range series.Values {
+		if v.Value != expected[i] {
+			t.Errorf("Expected value %v at %v, got %v", expected[i], i, v.Value)
+		}
+	}
+
+	// samples received from the mesh have no history
+	received := &Sample{From: "c", To: "d", Key: RTT_TOTAL, Value: "1", Ts: 1, Hops: 1}
+	db.SetReceivedSample(received)
+	if _, ok := db.GetSampleHistory(received.Id); ok {
+		t.Error("Expected no history of a received sample")
+	}
+}
+
+func Test_SampleHistoryReservoir(t *testing.T) {
+	const (
+		size   = 10
+		values = 100
+		trials = 5000
+	)
+	// every value is retained with probability size/values
+	retained := make([]int, values)
+	rnd := rand.New(rand.NewSource(1))
+	for trial := 0; trial < trials; trial++ {
+		s := newHistoryStore()
+		s.rand = rnd
+		s.retention = SampleHistory{RTT_TOTAL: {Mode: HISTORY_RESERVOIR, Size: size}}
+		for i := 0; i < values; i++ {
+			s.add(&Sample{Id: 1, Key: RTT_TOTAL, Value: strconv.Itoa(i), Ts: int64(i)})
+		}
+		series, _ := s.get(1)
+		if len(series.Values) != size || series.Seen != values {
+			t.Fatalf("Expected %v of %v values, got %v of %v", size, values, len(series.Values), series.Seen)
+		}
+		for i, v := range series.Values {
+			if i > 0 && series.Values[i-1].Ts > v.Ts {
+				t.Fatalf("Expected the values ordered by timestamp, got %+v", series.Values)
+			}
+			retained[v.Ts]++
+		}
+	}
+
+	// expected 500 per value, the standard deviation is ~21
+	expected := float64(trials) * size / values
+	for i, n := range retained {
+		if diff := float64(n) - expected; diff > 100 || diff < -100 {
+			t.Errorf("Expected value %v to be retained ~%v times, got %v", i, expected, n)
+		}
+	}
+	// the first and the last half are retained alike
+	var first, last int
+	for i, n := range retained {
+		if i < values/2 {
+			first += n
+		} else {
+			last += n
+		}
+	}
+	if diff := first - last; diff > trials*size/20 || diff < -trials*size/20 {
+		t.Errorf("Expected the halves to be retained alike, got %v and %v", first, last)
+	}
+}
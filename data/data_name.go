@@ -0,0 +1,65 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import "sync"
+
+// Table of interned node names. Samples store the index
+// of their from & to names instead of duplicating the
+// strings. Names are never removed, the table grows with
+// the distinct names ever seen by the node.
+type nameTable struct {
+	mu    sync.RWMutex
+	index map[string]uint32
+	names []string
+}
+
+func newNameTable() *nameTable {
+	return &nameTable{index: map[string]uint32{}}
+}
+
+// Get the index of a name, the name is added if unknown
+func (t *nameTable) intern(name string) uint32 {
+	t.mu.RLock()
+	i, ok := t.index[name]
+	t.mu.RUnlock()
+	if ok {
+		return i
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if i, ok := t.index[name]; ok {
+		return i
+	}
+	i = uint32(len(t.names))
+	t.names = append(t.names, name)
+	t.index[name] = i
+	return i
+}
+
+// Get the name of an index
+func (t *nameTable) name(i uint32) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.names[i]
+}
@@ -0,0 +1,54 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import "testing"
+
+func Test_nameTable(t *testing.T) {
+	names := newNameTable()
+	a := names.intern("node_1")
+	b := names.intern("node_2")
+	if a == b {
+		t.Errorf("different names have the same index %v", a)
+	}
+	if again := names.intern("node_1"); again != a {
+		t.Errorf("interned name has index %v, expected %v", again, a)
+	}
+	if name := names.name(b); name != "node_2" {
+		t.Errorf("got name %v, expected node_2", name)
+	}
+}
+
+func Test_SampleNamesInterned(t *testing.T) {
+	db, _ := NewMemDB(log)
+	for _, sample := range samples {
+		db.SetSample(sample)
+	}
+	if len(db.names.names) != 3 {
+		t.Errorf("name table has %v names, expected 3", len(db.names.names))
+	}
+	for _, sample := range db.GetSampleList() {
+		if db.GetSample(sample.Id).From != sample.From {
+			t.Errorf("from name of sample %v is not restored", sample.Id)
+		}
+	}
+}
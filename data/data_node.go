@@ -24,6 +24,8 @@ package data
 import (
 	"math/rand"
 	"time"
+
+	"github.com/hashicorp/go-memdb"
 )
 
 // Insert node in database
@@ -32,10 +34,45 @@ func (db *Database) SetNode(node *Node) {
 	txn := db.Txn(true)
 	defer txn.Abort()
 
-	err := txn.Insert("node", node)
+	insertNode(txn, node)
+
+	// Commit the transaction
+	txn.Commit()
+}
+
+// Insert a copy of a node within a write transaction, the node of the caller
+// is not changed. The last seen timestamp of a known node is kept if not set.
+func insertNode(txn *memdb.Txn, node *Node) {
+	stored := *node
+	stored.Labels = copyLabels(node.Labels)
+	if stored.LastSeen == 0 {
+		raw, err := txn.First("node", "id", stored.Id)
+		if err != nil {
+			panic(err)
+		}
+		if raw != nil {
+			stored.LastSeen = raw.(*Node).LastSeen
+		}
+	}
+
+	err := txn.Insert("node", &stored)
 	if err != nil {
 		panic(err)
 	}
+}
+
+// Insert multiple nodes in database within one transaction
+func (db *Database) SetNodes(nodes []*Node) {
+	if len(nodes) == 0 {
+		return
+	}
+	// Create a write transaction
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	for _, node := range nodes {
+		insertNode(txn, node)
+	}
 
 	// Commit the transaction
 	txn.Commit()
@@ -47,13 +84,13 @@ func (db *Database) SetNodeTsNow(id uint32) {
 	txn := db.Txn(true)
 	defer txn.Abort()
 
-	node := *db.GetNode(id)
-	if node.Id == 0 {
+	node, ok := db.GetNode(id)
+	if !ok {
 		return
 	}
 
 	node.StateChangeTs = time.Now().Unix()
-	err := txn.Insert("node", &node)
+	err := txn.Insert("node", node)
 	if err != nil {
 		panic(err)
 	}
@@ -62,47 +99,85 @@ func (db *Database) SetNodeTsNow(id uint32) {
 	txn.Commit()
 }
 
-// Delete a node by its id
-func (db *Database) DeleteNode(id uint32) {
+// Set the last successful contact of a node to now.
+// The node will be selected by id. The node is just updated
+// once per second to not write on every probe.
+func (db *Database) SetNodeLastSeen(id uint32) {
 	txn := db.Txn(true)
 	defer txn.Abort()
 
-	err := txn.Delete("node", db.GetNode(id))
+	raw, err := txn.First("node", "id", id)
 	if err != nil {
-		db.log.Debugf("Could not delete Node")
+		panic(err)
 	}
+	if raw == nil {
+		return
+	}
+
+	now := time.Now().Unix()
+	if raw.(*Node).LastSeen >= now {
+		return
+	}
+	node := *raw.(*Node)
+	node.LastSeen = now
+	err = txn.Insert("node", &node)
+	if err != nil {
+		panic(err)
+	}
+
 	// Commit the transaction
 	txn.Commit()
 }
 
-// Get a node by its id
-func (db *Database) GetNode(id uint32) *Node {
-	txn := db.Txn(false)
+// Delete a node by its id
+func (db *Database) DeleteNode(id uint32) {
+	txn := db.Txn(true)
 	defer txn.Abort()
 
-	raw, err := txn.First("node", "id", id)
-	if err != nil {
-		panic(err)
+	node, ok := db.GetNode(id)
+	if !ok {
+		db.log.Debugf("Could not delete Node, node not found")
+		return
 	}
-	if raw == nil {
-		return &Node{}
+
+	err := txn.Delete("node", node)
+	if err != nil {
+		db.log.Debugf("Could not delete Node")
 	}
-	return raw.(*Node)
+	// Commit the transaction
+	txn.Commit()
+}
+
+// Get a node by its id.
+// A copy of the stored node is returned,
+// the bool is false if no node was found.
+func (db *Database) GetNode(id uint32) (*Node, bool) {
+	return db.getNodeBy("id", id)
+}
+
+// Get a node by its name.
+// A copy of the stored node is returned,
+// the bool is false if no node was found.
+func (db *Database) GetNodeByName(name string) (*Node, bool) {
+	return db.getNodeBy("name", name)
 }
 
-//G Get a node by its name
-func (db *Database) GetNodeByName(name string) *Node {
+// Get a copy of the first node matching the index value,
+// the labels are copied as well
+func (db *Database) getNodeBy(index string, value interface{}) (*Node, bool) {
 	txn := db.Txn(false)
 	defer txn.Abort()
 
-	raw, err := txn.First("node", "name", name)
+	raw, err := txn.First("node", index, value)
 	if err != nil {
 		panic(err)
 	}
 	if raw == nil {
-		return &Node{}
+		return &Node{}, false
 	}
-	return raw.(*Node)
+	node := *raw.(*Node)
+	node.Labels = copyLabels(node.Labels)
+	return &node, true
 }
 
 // Get all nodes
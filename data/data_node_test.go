@@ -22,6 +22,7 @@
 package data
 
 import (
+	"strconv"
 	"testing"
 	"time"
 
@@ -42,6 +43,53 @@ func Test_SetNode(t *testing.T) {
 	}
 }
 
+func Test_SetNodes(t *testing.T) {
+	db, _ := NewMemDB(log)
+	db.SetNodes(nodes)
+	result := db.GetNodeList()
+	if len(result) != len(nodes) {
+		t.Errorf("the amount of nodes is incorrect: %v but expected %v", len(result), len(nodes))
+	}
+	for i, node := range result {
+		if diff := deep.Equal(node, nodes[i]); diff != nil {
+			t.Error(diff)
+		}
+	}
+
+	// empty list
+	db.SetNodes(nil)
+	if len(db.GetNodeList()) != len(nodes) {
+		t.Errorf("empty node list changed the db")
+	}
+}
+
+func benchmarkNodes(amount int) []*Node {
+	var benchNodes []*Node
+	for i := 0; i < amount; i++ {
+		id := strconv.Itoa(i)
+		benchNodes = append(benchNodes, &Node{Id: uint32(i), Name: "node_" + id, Target: "target_" + id, State: 1})
+	}
+	return benchNodes
+}
+
+func Benchmark_SetNode(b *testing.B) {
+	benchNodes := benchmarkNodes(1000)
+	for i := 0; i < b.N; i++ {
+		db, _ := NewMemDB(log)
+		for _, node := range benchNodes {
+			db.SetNode(node)
+		}
+	}
+}
+
+func Benchmark_SetNodes(b *testing.B) {
+	benchNodes := benchmarkNodes(1000)
+	for i := 0; i < b.N; i++ {
+		db, _ := NewMemDB(log)
+		db.SetNodes(benchNodes)
+	}
+}
+
 func Test_GetNode(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -73,11 +121,17 @@ func Test_GetNode(t *testing.T) {
 			}
 			if tt.expected != nil {
 				// GetNode(ByName)
-				result := db.GetNode(nodes[0].Id)
+				result, ok := db.GetNode(nodes[0].Id)
+				if ok == tt.emptyDb {
+					t.Errorf("found flag is %v, but db empty is %v", ok, tt.emptyDb)
+				}
 				if diff := deep.Equal(result, tt.expected); diff != nil {
 					t.Error(diff)
 				}
-				result = db.GetNodeByName(nodes[0].Name)
+				result, ok = db.GetNodeByName(nodes[0].Name)
+				if ok == tt.emptyDb {
+					t.Errorf("found flag is %v, but db empty is %v", ok, tt.emptyDb)
+				}
 				if diff := deep.Equal(result, tt.expected); diff != nil {
 					t.Error(diff)
 				}
@@ -115,6 +169,42 @@ func Test_GetNode(t *testing.T) {
 	}
 }
 
+func Test_GetNodeNotFound(t *testing.T) {
+	db, _ := NewMemDB(log)
+	for _, node := range nodes {
+		db.SetNode(node)
+	}
+
+	if _, ok := db.GetNode(42); ok {
+		t.Errorf("node with unknown id found")
+	}
+	if _, ok := db.GetNodeByName("node_unknown"); ok {
+		t.Errorf("node with unknown name found")
+	}
+}
+
+func Test_GetNodeReturnsCopy(t *testing.T) {
+	db, _ := NewMemDB(log)
+	labels := map[string]string{"zone": "a"}
+	db.SetNode(&Node{Id: 1, Name: "node_1", Target: "target_1", State: 1, StateChangeTs: 0, Labels: labels})
+	labels["zone"] = "b"
+
+	result, _ := db.GetNode(1)
+	result.State = 3
+	result.Labels["zone"] = "c"
+	result, _ = db.GetNodeByName("node_1")
+	result.StateChangeTs = 42
+	result.Labels["role"] = "edge"
+
+	stored, _ := db.GetNode(1)
+	if stored.State != 1 || stored.StateChangeTs != 0 {
+		t.Errorf("stored node was changed by caller: %+v", stored)
+	}
+	if diff := deep.Equal(stored.Labels, map[string]string{"zone": "a"}); diff != nil {
+		t.Errorf("stored labels were changed by caller: %v", diff)
+	}
+}
+
 func Test_shuffleNodes(t *testing.T) {
 	maxTries := 20
 	firstId := nodes[0].Id
@@ -195,6 +285,41 @@ func Test_DeleteNode(t *testing.T) {
 	}
 }
 
+func Test_SetNodeLastSeen(t *testing.T) {
+	db, _ := NewMemDB(log)
+	db.SetNode(&Node{Id: 1, Name: "node_1", Target: "target_1", State: 1})
+
+	db.SetNodeLastSeen(1)
+	node, _ := db.GetNode(1)
+	if node.LastSeen < time.Now().Unix()-1 {
+		t.Errorf("last seen not set: %v", node.LastSeen)
+	}
+
+	// last seen is kept if the node is set again
+	db.SetNode(&Node{Id: 1, Name: "node_1", Target: "target_1", State: 2})
+	result, _ := db.GetNode(1)
+	if result.LastSeen != node.LastSeen || result.State != 2 {
+		t.Errorf("last seen not kept: %+v", result)
+	}
+
+	// the node of the caller is neither changed nor stored
+	update := &Node{Id: 1, Name: "node_1", Target: "target_1", State: 1}
+	db.SetNode(update)
+	update.State = 3
+	if update.LastSeen != 0 {
+		t.Errorf("last seen of the caller's node was set: %+v", update)
+	}
+	if result, _ := db.GetNode(1); result.State != 1 || result.LastSeen != node.LastSeen {
+		t.Errorf("stored node was changed by the caller: %+v", result)
+	}
+
+	// unknown node is ignored
+	db.SetNodeLastSeen(42)
+	if _, ok := db.GetNode(42); ok {
+		t.Errorf("unknown node was created")
+	}
+}
+
 func Test_SetNodeTsNow(t *testing.T) {
 	db, _ := NewMemDB(log)
 	for _, node := range nodes {
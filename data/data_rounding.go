@@ -0,0 +1,100 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rounding granularities of sample values by sample key,
+// in the unit of the sample type. Samples of keys without
+// a granularity are not rounded.
+type SampleRounding map[int64]float64
+
+// Create the rounding of sample type names to granularities, e.g. rtt_total=1us.
+// The granularity of time sample types is a duration or a number in the unit
+// of the sample type, of other sample types a number, e.g. health_score=0.01.
+func NewSampleRounding(config map[string]string) (SampleRounding, error) {
+	r := SampleRounding{}
+	for name, granularity := range config {
+		key, ok := SampleKey(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown sample type %v", name)
+		}
+		t, _ := GetSampleType(key)
+		g, err := strconv.ParseFloat(granularity, 64)
+		if scale, isTime := timeUnits[t.Unit]; err != nil && isTime {
+			var d time.Duration
+			d, err = time.ParseDuration(granularity)
+			g = float64(d) / scale
+		}
+		if err != nil || !(g > 0) || math.IsInf(g, 0) {
+			return nil, fmt.Errorf("invalid rounding %q of sample type %v, please use a positive number or duration", granularity, name)
+		}
+		r[key] = g
+	}
+	return r, nil
+}
+
+// Round a value of a sample key to the nearest multiple of its granularity,
+// halfway values are rounded away from zero
+func (r SampleRounding) Float(key int64, value float64) float64 {
+	g, ok := r[key]
+	if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	rounded, _ := strconv.ParseFloat(r.format(g, value), 64)
+	return rounded
+}
+
+// Round a sample value to its granularity.
+// Non-numeric values (e.g. NaN, states) are kept.
+func (r SampleRounding) Value(key int64, value string) string {
+	g, ok := r[key]
+	if !ok {
+		return value
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return value
+	}
+	return r.format(g, f)
+}
+
+// Format the rounded value with the decimals of the granularity,
+// e.g. 0.46 instead of 0.46000000000000002
+func (r SampleRounding) format(g float64, value float64) string {
+	rounded := math.Round(value/g) * g
+	if rounded == 0 {
+		// no negative zero
+		rounded = 0
+	}
+	decimals := 0
+	if s := strconv.FormatFloat(g, 'f', -1, 64); strings.Contains(s, ".") {
+		decimals = len(s) - strings.IndexByte(s, '.') - 1
+	}
+	return strconv.FormatFloat(rounded, 'f', decimals, 64)
+}
@@ -0,0 +1,102 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func Test_SampleRounding(t *testing.T) {
+	r, err := NewSampleRounding(map[string]string{"rtt_total": "1us", "rtt_request": "500", "health_score": "0.01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      int64
+		value    string
+		expected string
+	}{
+		{name: "below half", key: RTT_TOTAL, value: "1499", expected: "1000"},
+		{name: "half rounds up", key: RTT_TOTAL, value: "1500", expected: "2000"},
+		{name: "exact multiple", key: RTT_TOTAL, value: "3000", expected: "3000"},
+		{name: "below granularity", key: RTT_TOTAL, value: "499", expected: "0"},
+		{name: "negative half rounds away from zero", key: RTT_TOTAL, value: "-1500", expected: "-2000"},
+		{name: "negative to zero", key: RTT_TOTAL, value: "-400", expected: "0"},
+		{name: "number granularity", key: RTT_REQUEST, value: "1249", expected: "1000"},
+		{name: "number granularity half", key: RTT_REQUEST, value: "1250", expected: "1500"},
+		{name: "decimal granularity", key: HEALTH_SCORE, value: "0.456", expected: "0.46"},
+		{name: "decimal granularity without float noise", key: HEALTH_SCORE, value: "0.3", expected: "0.30"},
+		{name: "not rounded key", key: CLOCK_SKEW, value: "1499", expected: "1499"},
+		{name: "non-numeric value", key: RTT_TOTAL, value: "NaN", expected: "NaN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if value := r.Value(tt.key, tt.value); value != tt.expected {
+				t.Errorf("Expected value %v, got %v", tt.expected, value)
+			}
+		})
+	}
+
+	if value := r.Float(HEALTH_SCORE, 0.123); value != 0.12 {
+		t.Errorf("Expected 0.12, got %v", value)
+	}
+	var empty SampleRounding
+	if value := empty.Value(RTT_TOTAL, "1499"); value != "1499" {
+		t.Errorf("Expected the raw value without rounding, got %v", value)
+	}
+}
+
+func Test_NewSampleRoundingErrors(t *testing.T) {
+	for _, config := range []map[string]string{
+		{"unknown": "1us"},
+		{"rtt_total": "fast"},
+		{"rtt_total": "0"},
+		{"rtt_total": "-1us"},
+		{"health_score": "1us"},
+	} {
+		if _, err := NewSampleRounding(config); err == nil {
+			t.Errorf("Expected an error for rounding %v", config)
+		}
+	}
+}
+
+func Test_SetSampleRounding(t *testing.T) {
+	db, err := NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewSampleRounding(map[string]string{"rtt_total": "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSampleRounding(r)
+
+	sample := &Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1500000", Ts: 1}
+	db.SetSample(sample)
+	if stored := db.GetSample(sample.Id); stored == nil || stored.Value != "2000000" {
+		t.Errorf("Expected the rounded value to be stored, got %+v", stored)
+	}
+}
@@ -21,22 +21,148 @@
 
 package data
 
-import "time"
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
 
-// Insert a measurement sample in the db
-func (db *Database) SetSample(sample *Sample) {
+	"github.com/hashicorp/go-memdb"
+)
+
+// Insert a measurement sample in the db, a known sample with the same id
+// is replaced. Reports if the sample was stored, the sample hook is called
+// for stored samples only. Self-referential samples are dropped if set by
+// SetSelfSampleDrop, local samples without group get the group set by
+// SetLocalSampleGroup. The stored value is recorded in the history set by
+// SetSampleHistory.
+func (db *Database) SetSample(sample *Sample) bool {
+	return db.setSample(sample, false)
+}
+
+// Insert a sample received from another node like SetSample. A known sample
+// with the same id is just replaced by a newer sample, older conflicting
+// samples are ignored, see replaces.
+func (db *Database) SetReceivedSample(sample *Sample) bool {
+	return db.setSample(sample, true)
+}
+
+func (db *Database) setSample(sample *Sample, received bool) bool {
+	if db.selfSampleDrop != nil && sample.IsSelfOf(db.selfId) {
+		db.selfSampleDrop(sample)
+		return false
+	}
+	if sample.Hops == 0 && sample.Group == "" {
+		sample.Group = db.group
+	}
 	// Create a write transaction
 	txn := db.Txn(true)
 	defer txn.Abort()
+	defer db.lockSpill()()
 
 	sample.Id = GetSampleId(sample)
-	err := txn.Insert("sample", sample)
+	sample.Value = db.rounding.Value(sample.Key, sample.Value)
+	raw, err := txn.First("sample", "id", sample.Id)
+	if err != nil {
+		panic(err)
+	}
+	if received && raw != nil && !replaces(sample, raw.(*storedSample)) {
+		return false
+	}
+	if spilled, ok := db.spilledSample(sample.Id); received && raw == nil && ok && !replaces(sample, spilled) {
+		return false
+	}
+	// the sample replaces its copy by the legacy id
+	if sample.FromId != 0 {
+		if legacy := LegacySampleId(sample); legacy != sample.Id {
+			db.deleteStored(txn, legacy)
+		}
+	}
+	db.unspill(txn, sample.Id)
+	err = txn.Insert("sample", db.store(sample))
 	if err != nil {
 		panic(err)
 	}
+	if db.spill != nil && db.spill.memSamples > db.spill.threshold {
+		db.spillOldest(txn)
+	}
 
 	// Commit the transaction
 	txn.Commit()
+	db.history.add(sample)
+
+	if db.sampleHook != nil {
+		db.sampleHook(sample)
+	}
+	return true
+}
+
+// Check if a received sample replaces the known sample with the same id,
+// independent of the order the samples arrive in: the newer timestamp wins.
+// Samples of the same timestamp are ordered by the greater value, so all
+// nodes converge to the same value, then by fewer hops to keep the shortest
+// path.
+func replaces(sample *Sample, known *storedSample) bool {
+	if sample.Ts != known.Ts {
+		return sample.Ts > known.Ts
+	}
+	if c := compareValues(sample.Value, known.Value); c != 0 {
+		return c > 0
+	}
+	return sample.Hops < known.Hops
+}
+
+// Compare two sample values, numeric values are compared by their number
+// and are greater than NaN and non-numeric values (e.g. states), which
+// are compared as strings
+func compareValues(a string, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	numA := errA == nil && !math.IsNaN(fa)
+	numB := errB == nil && !math.IsNaN(fb)
+	switch {
+	case numA && numB:
+		if fa < fb {
+			return -1
+		}
+		if fa > fb {
+			return 1
+		}
+		return 0
+	case numA:
+		return 1
+	case numB:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// Set the hook called for every sample stored by SetSample,
+// it has to be set before the database is shared.
+// The hook must not block.
+func (db *Database) SetSampleHook(hook func(*Sample)) {
+	db.sampleHook = hook
+}
+
+// Drop the self-referential samples and the samples about the node of the id
+// in SetSample, see Sample.IsSelfOf. The hook is called for every dropped sample,
+// it has to be set before the database is shared. The hook must not block.
+func (db *Database) SetSelfSampleDrop(id uint32, hook func(*Sample)) {
+	db.selfId = id
+	db.selfSampleDrop = hook
+}
+
+// Set the probe group of the local samples (0 hops) stored by SetSample,
+// it has to be set before the database is shared.
+func (db *Database) SetLocalSampleGroup(group string) {
+	db.group = group
+}
+
+// Set the rounding of the sample values stored by SetSample,
+// it has to be set before the database is shared.
+// The raw values of rounded sample keys are not kept.
+func (db *Database) SetSampleRounding(rounding SampleRounding) {
+	db.rounding = rounding
 }
 
 // Set a sample to not a number "NaN"
@@ -46,25 +172,40 @@ func (db *Database) SetSampleNaN(id uint32) {
 	// Create a write transaction
 	txn := db.Txn(true)
 	defer txn.Abort()
+	defer db.lockSpill()()
 
-	sample := *db.GetSample(id)
-	if sample.Id == 0 {
-		return
+	raw, err := txn.First("sample", "id", id)
+	if err != nil {
+		panic(err)
+	}
+	if raw == nil {
+		// a spilled sample returns to memory
+		spilled, ok := db.spilledSample(id)
+		if !ok {
+			return
+		}
+		db.unspill(txn, id)
+		raw = spilled
 	}
 
+	sample := *raw.(*storedSample)
 	sample.Value = "NaN"
 	sample.Ts = time.Now().Unix()
-	err := txn.Insert("sample", &sample)
+	// the sub-fields belong to the replaced value
+	sample.Fields = nil
+	err = txn.Insert("sample", &sample)
 	if err != nil {
 		panic(err)
 	}
 
 	// Commit the transaction
 	txn.Commit()
+	db.history.add(&Sample{Id: id, Key: sample.Key, Value: sample.Value, Ts: sample.Ts})
 }
 
 // Get a measurement sample by id
 func (db *Database) GetSample(id uint32) *Sample {
+	defer db.lockSpill()()
 	txn := db.Txn(false)
 	defer txn.Abort()
 
@@ -73,19 +214,39 @@ func (db *Database) GetSample(id uint32) *Sample {
 		panic(err)
 	}
 	if raw == nil {
+		if spilled, ok := db.spilledSample(id); ok {
+			return db.load(spilled)
+		}
 		return &Sample{}
 	}
-	return raw.(*Sample)
+	return db.load(raw.(*storedSample))
 }
 
-// Delete a measurement sample by id
+// Delete a measurement sample by id with its history
 func (db *Database) DeleteSample(id uint32) {
 	txn := db.Txn(true)
 	defer txn.Abort()
+	defer db.lockSpill()()
+	db.history.delete(id)
+
+	raw, err := txn.First("sample", "id", id)
+	if err != nil {
+		panic(err)
+	}
+	if raw == nil {
+		if _, ok := db.spilledSample(id); ok {
+			delete(db.spill.index, id)
+			return
+		}
+		db.log.Debugf("Could not delete sample, sample not found")
+		return
+	}
 
-	err := txn.Delete("sample", db.GetSample(id))
+	err = txn.Delete("sample", raw)
 	if err != nil {
 		db.log.Debugf("Could not delete sample")
+	} else if db.spill != nil {
+		db.spill.memSamples--
 	}
 	// Commit the transaction
 	txn.Commit()
@@ -93,6 +254,7 @@ func (db *Database) DeleteSample(id uint32) {
 
 // Get the timestamp from a measurment sample by id
 func (db *Database) GetSampleTs(id uint32) int64 {
+	defer db.lockSpill()()
 	txn := db.Txn(false)
 	defer txn.Abort()
 
@@ -101,23 +263,187 @@ func (db *Database) GetSampleTs(id uint32) int64 {
 		panic(err)
 	}
 	if raw == nil {
+		if spilled, ok := db.spilledSample(id); ok {
+			return spilled.Ts
+		}
 		return 0
 	}
-	return raw.(*Sample).Ts
+	return raw.(*storedSample).Ts
 }
 
 // Get all measurement samples in db
 func (db *Database) GetSampleList() []*Sample {
+	var samples []*Sample
+	db.ForEachSample(func(sample *Sample) bool {
+		samples = append(samples, sample)
+		return true
+	})
+	return samples
+}
+
+// Call fn for every measurement sample in db without building a list,
+// e.g. to stream a large sample store. The samples are read from a
+// snapshot of the db, spilled samples after the samples in memory.
+// The iteration stops if fn returns false.
+func (db *Database) ForEachSample(fn func(*Sample) bool) {
+	// the spilled samples are read with the snapshot
+	unlock := db.lockSpill()
 	txn := db.Txn(false)
 	defer txn.Abort()
+	spilled := db.spilledSamples()
+	unlock()
 
 	it, err := txn.Get("sample", "id")
 	if err != nil {
 		panic(err)
 	}
-	var samples []*Sample
 	for obj := it.Next(); obj != nil; obj = it.Next() {
-		samples = append(samples, obj.(*Sample))
+		if !fn(db.load(obj.(*storedSample))) {
+			return
+		}
 	}
-	return samples
+	for _, sample := range spilled {
+		if !fn(db.load(sample)) {
+			return
+		}
+	}
+}
+
+// Delete a stored sample in memory or spilled with its history within
+// a write transaction, the spill log has to be locked
+func (db *Database) deleteStored(txn *memdb.Txn, id uint32) {
+	db.history.delete(id)
+	raw, err := txn.First("sample", "id", id)
+	if err != nil {
+		panic(err)
+	}
+	if raw == nil {
+		if db.spill != nil {
+			delete(db.spill.index, id)
+		}
+		return
+	}
+	if err := txn.Delete("sample", raw); err != nil {
+		panic(err)
+	}
+	if db.spill != nil {
+		db.spill.memSamples--
+	}
+}
+
+// Migrate the samples with a legacy id to the id of the stable node ids.
+// The ids of the nodes are looked up by name, samples of unknown names keep
+// the legacy id. A migrated sample is dropped if a newer sample of the id is
+// stored. The amount of migrated samples is returned.
+func (db *Database) MigrateSampleIds(ids map[string]uint32) int {
+	var legacy []uint32
+	db.ForEachSample(func(sample *Sample) bool {
+		if _, ok := ids[sample.From]; ok && sample.FromId == 0 {
+			legacy = append(legacy, sample.Id)
+		}
+		return true
+	})
+	if len(legacy) == 0 {
+		return 0
+	}
+
+	txn := db.Txn(true)
+	defer txn.Abort()
+	defer db.lockSpill()()
+	migrated := 0
+	for _, id := range legacy {
+		// the sample may be changed since the snapshot
+		var sample *Sample
+		if raw, err := txn.First("sample", "id", id); err != nil {
+			panic(err)
+		} else if raw != nil {
+			sample = db.load(raw.(*storedSample))
+		} else if spilled, ok := db.spilledSample(id); ok {
+			sample = db.load(spilled)
+		}
+		if sample == nil || sample.FromId != 0 {
+			continue
+		}
+		db.deleteStored(txn, id)
+		migrated++
+		sample.FromId = ids[sample.From]
+		sample.ToId = ids[sample.To]
+		sample.Id = GetSampleId(sample)
+		raw, err := txn.First("sample", "id", sample.Id)
+		if err != nil {
+			panic(err)
+		}
+		if raw != nil && raw.(*storedSample).Ts >= sample.Ts {
+			continue
+		}
+		if spilled, ok := db.spilledSample(sample.Id); raw == nil && ok && spilled.Ts >= sample.Ts {
+			continue
+		}
+		db.unspill(txn, sample.Id)
+		if err := txn.Insert("sample", db.store(sample)); err != nil {
+			panic(err)
+		}
+	}
+	txn.Commit()
+	return migrated
+}
+
+// Convert a sample to its stored form, the node names are interned
+func (db *Database) store(s *Sample) *storedSample {
+	return &storedSample{
+		Id:     s.Id,
+		From:   db.names.intern(s.From),
+		To:     db.names.intern(s.To),
+		Key:    s.Key,
+		Value:  s.Value,
+		Ts:     s.Ts,
+		Hops:   s.Hops,
+		FromId: s.FromId,
+		ToId:   s.ToId,
+		Fields: s.Fields,
+		Group:  s.Group,
+	}
+}
+
+// Convert a stored sample back to a sample
+func (db *Database) load(s *storedSample) *Sample {
+	return &Sample{
+		Id:     s.Id,
+		From:   db.names.name(s.From),
+		To:     db.names.name(s.To),
+		Key:    s.Key,
+		Value:  s.Value,
+		Ts:     s.Ts,
+		Hops:   s.Hops,
+		FromId: s.FromId,
+		ToId:   s.ToId,
+		Fields: s.Fields,
+		Group:  s.Group,
+	}
+}
+
+// Age of the sample since it was measured
+func (s *Sample) Age() time.Duration {
+	return time.Since(time.Unix(s.Ts, 0))
+}
+
+// A sample is stale if it is older than staleAfter.
+// Staleness is disabled if staleAfter is 0.
+func (s *Sample) IsStale(staleAfter time.Duration) bool {
+	return staleAfter > 0 && s.Age() > staleAfter
+}
+
+// A sample is self-referential if the measuring node is the measured node,
+// identified by the node ids, e.g. a node probing its own address in a loopback
+// setup. Samples without node ids, e.g. of older nodes, are never self-referential.
+// The heartbeat of a node is about the node itself by design and is excluded.
+func (s *Sample) IsSelf() bool {
+	return s.FromId != 0 && s.FromId == s.ToId && s.Key != HEARTBEAT
+}
+
+// A sample is self-referential for the node of the id if it is self-referential
+// or the node is the measured node, e.g. a sample of a peer about the node.
+// The heartbeat is excluded, an id of 0 just checks IsSelf.
+func (s *Sample) IsSelfOf(id uint32) bool {
+	return s.IsSelf() || (id != 0 && s.ToId == id && s.Key != HEARTBEAT)
 }
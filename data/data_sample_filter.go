@@ -0,0 +1,85 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+// Sample keys a node wants to receive by push.
+// Keys that are not known to the node pass the filter,
+// so sample types of newer nodes are not dropped.
+// A nil filter or a filter without keys accepts all samples.
+type SampleFilter struct {
+	Keys      []int64
+	KnownKeys []int64
+}
+
+// Create a filter accepting the keys,
+// the registered sample types are the known keys
+func NewSampleFilter(keys []int64) *SampleFilter {
+	if len(keys) == 0 {
+		return nil
+	}
+	filter := &SampleFilter{Keys: append([]int64{}, keys...)}
+	for _, t := range ListSampleTypes() {
+		filter.KnownKeys = append(filter.KnownKeys, t.Key)
+	}
+	return filter
+}
+
+// Check if a sample key passes the filter
+func (f *SampleFilter) Accepts(key int64) bool {
+	if f == nil || len(f.Keys) == 0 {
+		return true
+	}
+	return containsKey(f.Keys, key) || !containsKey(f.KnownKeys, key)
+}
+
+// Convert the filter to a mesh sample filter
+func (f *SampleFilter) Convert() *meshv1.SampleFilter {
+	if f == nil {
+		return nil
+	}
+	return &meshv1.SampleFilter{
+		Keys:      append([]int64{}, f.Keys...),
+		KnownKeys: append([]int64{}, f.KnownKeys...),
+	}
+}
+
+// Convert a mesh sample filter, nil if no keys are set
+func ConvertSampleFilter(f *meshv1.SampleFilter) *SampleFilter {
+	if f == nil || len(f.Keys) == 0 {
+		return nil
+	}
+	return &SampleFilter{
+		Keys:      append([]int64{}, f.Keys...),
+		KnownKeys: append([]int64{}, f.KnownKeys...),
+	}
+}
+
+func containsKey(keys []int64, key int64) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func Test_SampleFilterAccepts(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   *SampleFilter
+		key      int64
+		expected bool
+	}{
+		{name: "no filter", filter: nil, key: RTT_TOTAL, expected: true},
+		{name: "no keys", filter: &SampleFilter{}, key: RTT_TOTAL, expected: true},
+		{name: "accepted key", filter: NewSampleFilter([]int64{RTT_TOTAL}), key: RTT_TOTAL, expected: true},
+		{name: "filtered key", filter: NewSampleFilter([]int64{RTT_TOTAL}), key: RTT_REQUEST, expected: false},
+		{name: "unknown key", filter: NewSampleFilter([]int64{RTT_TOTAL}), key: 999, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if accepts := tt.filter.Accepts(tt.key); accepts != tt.expected {
+				t.Errorf("filter accepts key %v is %v, expected %v", tt.key, accepts, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_ConvertSampleFilter(t *testing.T) {
+	filter := NewSampleFilter([]int64{RTT_TOTAL, HEALTH_SCORE})
+	if diff := deep.Equal(ConvertSampleFilter(filter.Convert()), filter); diff != nil {
+		t.Error(diff)
+	}
+	if converted := ConvertSampleFilter(nil); converted != nil {
+		t.Errorf("nil filter converted to %v", converted)
+	}
+	if filter := NewSampleFilter(nil); filter != nil {
+		t.Errorf("filter without keys is %v, expected nil", filter)
+	}
+}
@@ -22,10 +22,13 @@
 package data
 
 import (
+	"fmt"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/go-test/deep"
+	"github.com/hashicorp/go-memdb"
 )
 
 func Test_SetSample(t *testing.T) {
@@ -44,6 +47,84 @@ func Test_SetSample(t *testing.T) {
 	}
 }
 
+func Test_SetSampleOutOfOrder(t *testing.T) {
+	newer := Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "2", Ts: 2, Hops: 1}
+	older := Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1}
+	tieLow := Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "10", Ts: 3, Hops: 1}
+	tieHigh := Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "20", Ts: 3, Hops: 2}
+	shorter := Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "20", Ts: 3, Hops: 1}
+	tieDigits := Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "9", Ts: 3, Hops: 1}
+	tieNaN := Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "NaN", Ts: 3, Hops: 1}
+
+	tests := []struct {
+		name     string
+		arrivals []Sample
+		expected Sample
+	}{
+		{name: "in order", arrivals: []Sample{older, newer}, expected: newer},
+		{name: "out of order", arrivals: []Sample{newer, older}, expected: newer},
+		{name: "tie by value", arrivals: []Sample{tieLow, tieHigh}, expected: tieHigh},
+		{name: "tie by value reversed", arrivals: []Sample{tieHigh, tieLow}, expected: tieHigh},
+		{name: "tie by hops", arrivals: []Sample{tieHigh, shorter}, expected: shorter},
+		{name: "tie by hops reversed", arrivals: []Sample{shorter, tieHigh}, expected: shorter},
+		{name: "tie by numeric value", arrivals: []Sample{tieLow, tieDigits}, expected: tieLow},
+		{name: "tie by numeric value reversed", arrivals: []Sample{tieDigits, tieLow}, expected: tieLow},
+		{name: "tie of NaN", arrivals: []Sample{tieNaN, tieDigits}, expected: tieDigits},
+		{name: "tie of NaN reversed", arrivals: []Sample{tieDigits, tieNaN}, expected: tieDigits},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := NewMemDB(log)
+			for _, arrival := range tt.arrivals {
+				sample := arrival
+				db.SetReceivedSample(&sample)
+			}
+			got := db.GetSample(GetSampleId(&tt.expected))
+			if got.Value != tt.expected.Value || got.Ts != tt.expected.Ts || got.Hops != tt.expected.Hops {
+				t.Errorf("Expected sample %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+
+	// an older received sample is not stored and not hooked
+	db, _ := NewMemDB(log)
+	hooked := 0
+	db.SetSampleHook(func(sample *Sample) { hooked++ })
+	if !db.SetReceivedSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "2", Ts: 2}) {
+		t.Error("Expected the first sample to be stored")
+	}
+	if db.SetReceivedSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1}) {
+		t.Error("Expected the older sample to be ignored")
+	}
+	if hooked != 1 {
+		t.Errorf("Expected just the stored sample to be hooked, got %v", hooked)
+	}
+
+	// a local sample always replaces the known sample, e.g. after a clock step
+	// or a measurement in the same second as a failed ping (NaN)
+	db.SetSampleNaN(GetSampleId(&Sample{From: "a", To: "b", Key: RTT_TOTAL}))
+	if !db.SetSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "123", Ts: 1}) {
+		t.Error("Expected the local sample to be stored")
+	}
+	if got := db.GetSample(GetSampleId(&Sample{From: "a", To: "b", Key: RTT_TOTAL})); got.Value != "123" || got.Ts != 1 {
+		t.Errorf("Expected the local sample to replace the known sample, got %+v", got)
+	}
+}
+
+func Test_SetSampleHook(t *testing.T) {
+	db, _ := NewMemDB(log)
+	var hooked []*Sample
+	db.SetSampleHook(func(sample *Sample) {
+		hooked = append(hooked, sample)
+	})
+	for _, sample := range samples {
+		db.SetSample(sample)
+	}
+	if len(hooked) != len(samples) {
+		t.Errorf("the amount of hooked samples (amount: %v) is not as expected: %v", len(hooked), len(samples))
+	}
+}
+
 func Test_SetSampleNaN(t *testing.T) {
 	db, _ := NewMemDB(log)
 	for _, sample := range samples {
@@ -53,12 +134,27 @@ func Test_SetSampleNaN(t *testing.T) {
 	txn := db.Txn(false)
 	raw, _ := txn.Get("sample", "id")
 	for obj := raw.Next(); obj != nil; obj = raw.Next() {
-		if obj.(*Sample).Value != "NaN" {
-			t.Errorf("The sample value is not Nan as expected. Sample value: %v", obj.(*Sample).Value)
+		if obj.(*storedSample).Value != "NaN" {
+			t.Errorf("The sample value is not Nan as expected. Sample value: %v", obj.(*storedSample).Value)
 		}
 	}
 }
 
+func Test_SetSampleFields(t *testing.T) {
+	db, _ := NewMemDB(log)
+	sample := &Sample{From: "a", To: "http://example.com", Key: RTT_TOTAL, Value: "1", Ts: 1, Fields: map[string]string{"status": "200", "size": "42"}}
+	db.SetSample(sample)
+	if stored := db.GetSample(GetSampleId(sample)); stored.Fields["status"] != "200" || stored.Fields["size"] != "42" {
+		t.Errorf("Expected the sub-fields to be stored, got %+v", stored.Fields)
+	}
+
+	// the sub-fields belong to the replaced value
+	db.SetSampleNaN(GetSampleId(sample))
+	if stored := db.GetSample(GetSampleId(sample)); stored.Fields != nil {
+		t.Errorf("Expected no sub-fields of a NaN sample, got %+v", stored.Fields)
+	}
+}
+
 func Test_GetSample(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -149,3 +245,274 @@ func Test_SetSampleTsNow(t *testing.T) {
 		t.Errorf("no nodes set in db, %v nodes should be set", len(nodes))
 	}
 }
+
+func Test_ForEachSample(t *testing.T) {
+	db, _ := NewMemDB(log)
+	for _, sample := range samples {
+		db.SetSample(sample)
+	}
+
+	var result []*Sample
+	db.ForEachSample(func(sample *Sample) bool {
+		result = append(result, sample)
+		return true
+	})
+	if diff := deep.Equal(result, db.GetSampleList()); diff != nil {
+		t.Error(diff)
+	}
+
+	count := 0
+	db.ForEachSample(func(sample *Sample) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("iteration did not stop, %v samples visited", count)
+	}
+}
+
+func Test_SampleIdRename(t *testing.T) {
+	db, _ := NewMemDB(log)
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1, FromId: 1, ToId: 2})
+	db.SetSample(&Sample{From: "a-renamed", To: "b", Key: RTT_TOTAL, Value: "2", Ts: 2, FromId: 1, ToId: 2})
+
+	list := db.GetSampleList()
+	if len(list) != 1 {
+		t.Fatalf("Expected one sample after the rename, got %+v", list)
+	}
+	if list[0].From != "a-renamed" || list[0].Value != "2" {
+		t.Errorf("Expected the sample of the renamed node, got %+v", list[0])
+	}
+}
+
+func Test_SetSampleReplacesLegacy(t *testing.T) {
+	db, _ := NewMemDB(log)
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1})
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "2", Ts: 2, FromId: 1, ToId: 2})
+
+	list := db.GetSampleList()
+	if len(list) != 1 || list[0].Value != "2" {
+		t.Errorf("Expected the legacy sample to be replaced, got %+v", list)
+	}
+}
+
+func Test_MigrateSampleIds(t *testing.T) {
+	db, _ := NewMemDB(log)
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1})
+	db.SetSample(&Sample{From: "a", To: "https://example.com", Key: CLOCK_SKEW, Value: "2", Ts: 1})
+	// a newer sample of the new id is kept
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_REQUEST, Value: "3", Ts: 1})
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_REQUEST, Value: "4", Ts: 2, FromId: 1, ToId: 2})
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_REQUEST, Value: "3", Ts: 1})
+	// samples of unknown nodes keep the legacy id
+	db.SetSample(&Sample{From: "c", To: "b", Key: RTT_TOTAL, Value: "5", Ts: 1})
+
+	if migrated := db.MigrateSampleIds(map[string]uint32{"a": 1, "b": 2}); migrated != 3 {
+		t.Errorf("Expected 3 migrated samples, got %v", migrated)
+	}
+
+	tests := []struct {
+		sample *Sample
+		value  string
+	}{
+		{sample: &Sample{From: "a", To: "b", Key: RTT_TOTAL, FromId: 1, ToId: 2}, value: "1"},
+		{sample: &Sample{From: "a", To: "https://example.com", Key: CLOCK_SKEW, FromId: 1}, value: "2"},
+		{sample: &Sample{From: "a", To: "b", Key: RTT_REQUEST, FromId: 1, ToId: 2}, value: "4"},
+		{sample: &Sample{From: "c", To: "b", Key: RTT_TOTAL}, value: "5"},
+	}
+	for _, tt := range tests {
+		if sample := db.GetSample(GetSampleId(tt.sample)); sample.Value != tt.value {
+			t.Errorf("Expected value %v of sample %+v, got %+v", tt.value, tt.sample, sample)
+		}
+	}
+	if list := db.GetSampleList(); len(list) != len(tests) {
+		t.Errorf("Expected %v samples after the migration, got %+v", len(tests), list)
+	}
+	if migrated := db.MigrateSampleIds(map[string]uint32{"a": 1, "b": 2}); migrated != 0 {
+		t.Errorf("Expected no samples to migrate twice, got %v", migrated)
+	}
+}
+
+func Test_SampleIsStale(t *testing.T) {
+	tests := []struct {
+		name       string
+		ts         int64
+		staleAfter time.Duration
+		expected   bool
+	}{
+		{name: "fresh sample", ts: time.Now().Unix(), staleAfter: time.Minute, expected: false},
+		{name: "stale sample", ts: time.Now().Add(-time.Hour).Unix(), staleAfter: time.Minute, expected: true},
+		{name: "staleness disabled", ts: time.Now().Add(-time.Hour).Unix(), staleAfter: 0, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sample := &Sample{Ts: tt.ts}
+			if stale := sample.IsStale(tt.staleAfter); stale != tt.expected {
+				t.Errorf("sample stale is %v, but expected %v", stale, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_SampleIsSelf(t *testing.T) {
+	tests := []struct {
+		name     string
+		sample   *Sample
+		expected bool
+	}{
+		{name: "loopback sample", sample: &Sample{From: "a", To: "a", Key: RTT_TOTAL, FromId: 1, ToId: 1}, expected: true},
+		{name: "same name other id", sample: &Sample{From: "a", To: "a", Key: RTT_TOTAL, FromId: 1, ToId: 2}, expected: false},
+		{name: "without node ids", sample: &Sample{From: "a", To: "a", Key: RTT_TOTAL}, expected: false},
+		{name: "heartbeat", sample: &Sample{From: "a", To: "a", Key: HEARTBEAT, FromId: 1, ToId: 1}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if self := tt.sample.IsSelf(); self != tt.expected {
+				t.Errorf("sample self is %v, but expected %v", self, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_SampleIsSelfOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		sample   *Sample
+		id       uint32
+		expected bool
+	}{
+		{name: "loopback sample", sample: &Sample{From: "a", To: "a", Key: RTT_TOTAL, FromId: 1, ToId: 1}, id: 2, expected: true},
+		{name: "peer sample about the node", sample: &Sample{From: "b", To: "a", Key: RTT_TOTAL, FromId: 2, ToId: 1}, id: 1, expected: true},
+		{name: "sample of the node", sample: &Sample{From: "a", To: "b", Key: RTT_TOTAL, FromId: 1, ToId: 2}, id: 1, expected: false},
+		{name: "peer heartbeat", sample: &Sample{From: "b", To: "a", Key: HEARTBEAT, FromId: 2, ToId: 1}, id: 1, expected: false},
+		{name: "unknown id", sample: &Sample{From: "b", To: "a", Key: RTT_TOTAL, FromId: 2}, id: 0, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if self := tt.sample.IsSelfOf(tt.id); self != tt.expected {
+				t.Errorf("sample self of %v is %v, but expected %v", tt.id, self, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_SetSelfSampleDrop(t *testing.T) {
+	db, _ := NewMemDB(log)
+	dropped := 0
+	db.SetSelfSampleDrop(1, func(*Sample) { dropped++ })
+
+	if db.SetSample(&Sample{From: "a", To: "a", Key: RTT_TOTAL, Value: "1", Ts: 1, FromId: 1, ToId: 1}) {
+		t.Error("Expected the self-referential sample to be dropped")
+	}
+	if db.SetSample(&Sample{From: "b", To: "a", Key: RTT_TOTAL, Value: "1", Ts: 1, FromId: 2, ToId: 1, Hops: 1}) {
+		t.Error("Expected the sample of a peer about the node to be dropped")
+	}
+	if !db.SetSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1, FromId: 1, ToId: 2}) {
+		t.Error("Expected the sample to be stored")
+	}
+	if dropped != 2 || len(db.GetSampleList()) != 1 {
+		t.Errorf("Expected 2 dropped & 1 stored sample, got %v %+v", dropped, db.GetSampleList())
+	}
+}
+
+func Test_SetLocalSampleGroup(t *testing.T) {
+	db, _ := NewMemDB(log)
+	db.SetLocalSampleGroup("edge")
+
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1})
+	db.SetSample(&Sample{From: "c", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1, Hops: 1, Group: "core"})
+	db.SetSample(&Sample{From: "d", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1, Hops: 1})
+
+	groups := map[string]string{}
+	for _, sample := range db.GetSampleList() {
+		groups[sample.From] = sample.Group
+	}
+	// local samples get the group, received samples keep the group of the measuring node
+	if groups["a"] != "edge" || groups["c"] != "core" || groups["d"] != "" {
+		t.Errorf("Unexpected groups of the samples %v", groups)
+	}
+}
+
+// Synthetic samples of a full mesh, every name is a separate
+// string like the names of samples received from other nodes
+func benchmarkSamples(amountOfNodes int) []*Sample {
+	var benchSamples []*Sample
+	for from := 0; from < amountOfNodes; from++ {
+		for to := 0; to < amountOfNodes; to++ {
+			for key := int64(RTT_TOTAL); key <= RTT_REQUEST; key++ {
+				benchSamples = append(benchSamples, &Sample{
+					Id:    uint32(len(benchSamples) + 1),
+					From:  fmt.Sprintf("canary-bot-node-%d.example.com", from),
+					To:    fmt.Sprintf("canary-bot-node-%d.example.com", to),
+					Key:   key,
+					Value: "123456",
+					Ts:    time.Now().Unix(),
+				})
+			}
+		}
+	}
+	return benchSamples
+}
+
+// Heap bytes per sample that are retained after inserting the samples
+// in a new database. The names are copied to get separate strings.
+func reportHeapPerSample(b *testing.B, benchSamples []*Sample, newDb func() func(*Sample)) {
+	var before, after runtime.MemStats
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		insert := newDb()
+		for _, sample := range benchSamples {
+			copied := *sample
+			copied.From = string([]byte(sample.From))
+			copied.To = string([]byte(sample.To))
+			insert(&copied)
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		runtime.KeepAlive(insert)
+	}
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(len(benchSamples)), "B/sample")
+}
+
+// Memory of the interned sample storage compared to storing
+// the samples with their node names in the database
+func Benchmark_SampleMemory(b *testing.B) {
+	benchSamples := benchmarkSamples(100)
+
+	b.Run("interned", func(b *testing.B) {
+		reportHeapPerSample(b, benchSamples, func() func(*Sample) {
+			db, _ := NewMemDB(log)
+			return func(sample *Sample) { db.SetSample(sample) }
+		})
+	})
+
+	b.Run("plain", func(b *testing.B) {
+		schema := &memdb.DBSchema{Tables: map[string]*memdb.TableSchema{
+			"sample": {
+				Name: "sample",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id":    {Name: "id", Unique: true, Indexer: &memdb.UintFieldIndex{Field: "Id"}},
+					"from":  {Name: "from", Indexer: &memdb.StringFieldIndex{Field: "From"}},
+					"to":    {Name: "to", Indexer: &memdb.StringFieldIndex{Field: "To"}},
+					"key":   {Name: "key", Indexer: &memdb.IntFieldIndex{Field: "Key"}},
+					"value": {Name: "value", Indexer: &memdb.StringFieldIndex{Field: "Value"}},
+					"ts":    {Name: "ts", Indexer: &memdb.IntFieldIndex{Field: "Ts"}},
+				},
+			},
+		}}
+		reportHeapPerSample(b, benchSamples, func() func(*Sample) {
+			db, _ := memdb.NewMemDB(schema)
+			return func(sample *Sample) {
+				txn := db.Txn(true)
+				if err := txn.Insert("sample", sample); err != nil {
+					b.Fatal(err)
+				}
+				txn.Commit()
+			}
+		})
+	})
+}
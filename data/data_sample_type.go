@@ -0,0 +1,151 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A sample type describes a measurement sample key
+// with its name and the unit of the sample value.
+type SampleType struct {
+	Key  int64
+	Name string
+	Unit string
+}
+
+// Registry of all known sample types
+var sampleTypes = struct {
+	sync.RWMutex
+	m map[int64]SampleType
+}{m: map[int64]SampleType{}}
+
+// Register the core sample types
+func init() {
+	MustRegisterSampleType(STATE, "state", "")
+	MustRegisterSampleType(RTT_TOTAL, "rtt_total", "ns")
+	MustRegisterSampleType(RTT_REQUEST, "rtt_request", "ns")
+	MustRegisterSampleType(CLOCK_SKEW, "clock_skew", "ns")
+	MustRegisterSampleType(HEALTH_SCORE, "health_score", "score")
+	MustRegisterSampleType(HEARTBEAT, "heartbeat", "count")
+	MustRegisterSampleType(ONE_WAY_DELAY, "one_way_delay", "ns")
+	MustRegisterSampleType(THROUGHPUT, "throughput", "B/s")
+}
+
+// Register a new sample type.
+// An error is returned if the key or the name is already registered.
+func RegisterSampleType(key int64, name string, unit string) error {
+	sampleTypes.Lock()
+	defer sampleTypes.Unlock()
+
+	for _, t := range sampleTypes.m {
+		if t.Key == key || t.Name == name {
+			return fmt.Errorf("sample type already registered: key %v, name %v", t.Key, t.Name)
+		}
+	}
+	sampleTypes.m[key] = SampleType{Key: key, Name: name, Unit: unit}
+	return nil
+}
+
+// Register a new sample type, panics if it is already registered
+func MustRegisterSampleType(key int64, name string, unit string) {
+	if err := RegisterSampleType(key, name, unit); err != nil {
+		panic(err)
+	}
+}
+
+// Get the registered sample type of a key.
+// The RTT with a payload is typed by the payload size of the key.
+func GetSampleType(key int64) (SampleType, bool) {
+	if size, ok := RttPayloadSize(key); ok {
+		return SampleType{Key: key, Name: rttPayloadName(size), Unit: "ns"}, true
+	}
+	sampleTypes.RLock()
+	defer sampleTypes.RUnlock()
+	t, ok := sampleTypes.m[key]
+	return t, ok
+}
+
+// Get the sample key of the RTT with a payload of size bytes
+func RttPayloadKey(size int) int64 {
+	return RTT_PAYLOAD + int64(size)
+}
+
+// Get the payload size of a RTT sample key, false if it is no RTT payload key
+func RttPayloadSize(key int64) (int, bool) {
+	if key <= RTT_PAYLOAD || key > RTT_PAYLOAD+MAX_RTT_PAYLOAD_SIZE {
+		return 0, false
+	}
+	return int(key - RTT_PAYLOAD), true
+}
+
+func rttPayloadName(size int) string {
+	return "rtt_payload_" + strconv.Itoa(size)
+}
+
+// Get the name of a sample key.
+// Unknown keys (e.g. from nodes with a newer version) will be named by the key.
+func SampleName(key int64) string {
+	if t, ok := GetSampleType(key); ok {
+		return t.Name
+	}
+	return "unknown_" + strconv.FormatInt(key, 10)
+}
+
+// Get the key of a registered sample type by its name
+func SampleKey(name string) (int64, bool) {
+	if strings.HasPrefix(name, "rtt_payload_") {
+		size, err := strconv.Atoi(strings.TrimPrefix(name, "rtt_payload_"))
+		if err != nil || rttPayloadName(size) != name {
+			return 0, false
+		}
+		key := RttPayloadKey(size)
+		_, ok := RttPayloadSize(key)
+		return key, ok
+	}
+	sampleTypes.RLock()
+	defer sampleTypes.RUnlock()
+
+	for _, t := range sampleTypes.m {
+		if t.Name == name {
+			return t.Key, true
+		}
+	}
+	return 0, false
+}
+
+// List all registered sample types ordered by key
+func ListSampleTypes() []SampleType {
+	sampleTypes.RLock()
+	defer sampleTypes.RUnlock()
+
+	types := make([]SampleType, 0, len(sampleTypes.m))
+	for _, t := range sampleTypes.m {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Key < types[j].Key })
+	return types
+}
@@ -0,0 +1,92 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"testing"
+)
+
+func Test_RegisterSampleType(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       int64
+		typeName  string
+		expectErr bool
+	}{
+		{name: "new sample type", key: 100, typeName: "test_type", expectErr: false},
+		{name: "key already registered", key: RTT_TOTAL, typeName: "other_type", expectErr: true},
+		{name: "name already registered", key: 101, typeName: "rtt_total", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RegisterSampleType(tt.key, tt.typeName, "")
+			if (err != nil) != tt.expectErr {
+				t.Errorf("error is %v, but expected error is %v", err, tt.expectErr)
+			}
+			if err == nil {
+				// keep the global registry clean for the other tests
+				t.Cleanup(func() {
+					sampleTypes.Lock()
+					defer sampleTypes.Unlock()
+					delete(sampleTypes.m, tt.key)
+				})
+			}
+		})
+	}
+}
+
+func Test_SampleName(t *testing.T) {
+	if name := SampleName(RTT_REQUEST); name != "rtt_request" {
+		t.Errorf("sample name is %v, expected rtt_request", name)
+	}
+	if name := SampleName(999); name != "unknown_999" {
+		t.Errorf("sample name is %v, expected unknown_999", name)
+	}
+}
+
+func Test_RttPayloadSampleType(t *testing.T) {
+	key := RttPayloadKey(1024)
+	if name := SampleName(key); name != "rtt_payload_1024" {
+		t.Errorf("sample name is %v, expected rtt_payload_1024", name)
+	}
+	if k, ok := SampleKey("rtt_payload_1024"); !ok || k != key {
+		t.Errorf("sample key is %v, expected %v", k, key)
+	}
+	for _, name := range []string{"rtt_payload_0", "rtt_payload_01", "rtt_payload_x", "rtt_payload_70000"} {
+		if _, ok := SampleKey(name); ok {
+			t.Errorf("sample key of %v found, expected unknown", name)
+		}
+	}
+}
+
+func Test_ListSampleTypes(t *testing.T) {
+	types := ListSampleTypes()
+	if len(types) < 3 {
+		t.Errorf("the core sample types are not registered, amount of types: %v", len(types))
+	}
+	for i := 1; i < len(types); i++ {
+		if types[i-1].Key >= types[i].Key {
+			t.Errorf("sample types are not ordered by key: %+v", types)
+		}
+	}
+}
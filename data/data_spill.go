@@ -0,0 +1,289 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/go-memdb"
+)
+
+// Min. records of the spill log before it is compacted
+const SPILL_COMPACT_MIN_RECORDS = 1000
+
+// Disk overflow of the sample store. If the samples in memory
+// exceed the threshold, the oldest samples are appended to the
+// spill log; the latest record per sample is indexed. A spilled
+// sample returns to memory if it is set again. The log is compacted
+// in the background if more than half of its records are overwritten.
+type spillStore struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	size       int64
+	records    int
+	index      map[uint32]spillRecord
+	memSamples int
+	threshold  int
+	compacting bool
+}
+
+// Position of a spilled sample in the spill log
+type spillRecord struct {
+	offset int64
+	length int
+}
+
+// Create the spill log at the path, an existing log is truncated
+func newSpillStore(path string, threshold int) (*spillStore, error) {
+	if threshold <= 0 {
+		return nil, errors.New("spill threshold has to be greater than 0")
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &spillStore{path: path, file: file, index: map[uint32]spillRecord{}, threshold: threshold}, nil
+}
+
+// Append a sample to the spill log, the lock has to be held
+func (s *spillStore) append(sample *storedSample) error {
+	b, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if _, err := s.file.WriteAt(b, s.size); err != nil {
+		return err
+	}
+	s.index[sample.Id] = spillRecord{offset: s.size, length: len(b)}
+	s.size += int64(len(b))
+	s.records++
+	return nil
+}
+
+// Read a spilled sample, the lock has to be held
+func (s *spillStore) read(id uint32) (*storedSample, bool) {
+	r, ok := s.index[id]
+	if !ok {
+		return nil, false
+	}
+	b := make([]byte, r.length)
+	if _, err := s.file.ReadAt(b, r.offset); err != nil {
+		return nil, false
+	}
+	var sample storedSample
+	if err := json.Unmarshal(b, &sample); err != nil {
+		return nil, false
+	}
+	return &sample, true
+}
+
+// Start a compaction if more than half of the records of the spill
+// log are overwritten, the lock has to be held
+func (s *spillStore) startCompaction() bool {
+	if s.compacting || s.records < SPILL_COMPACT_MIN_RECORDS || s.records < 2*len(s.index) {
+		return false
+	}
+	s.compacting = true
+	return true
+}
+
+// Rewrite the spill log with the indexed records of a started compaction,
+// the lock must not be held. The records are copied to a temp file without
+// the lock, the log is swapped after the temp file is renamed over it.
+// On failure the log is kept as it is.
+func (s *spillStore) compact() error {
+	defer func() {
+		s.mu.Lock()
+		s.compacting = false
+		s.mu.Unlock()
+	}()
+
+	s.mu.Lock()
+	old, end := s.file, s.size
+	snapshot := make(map[uint32]spillRecord, len(s.index))
+	for id, r := range s.index {
+		snapshot[id] = r
+	}
+	s.mu.Unlock()
+
+	tmp, err := os.OpenFile(s.path+".compact", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// written records are not modified, the log is only appended
+	var size int64
+	index := make(map[uint32]spillRecord, len(snapshot))
+	for id, r := range snapshot {
+		if index[id], err = copyRecord(old, tmp, r, &size); err != nil {
+			return fail(err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// an index entry before the end of the snapshot is the copied record,
+	// records appended meanwhile are copied under the lock
+	compacted := make(map[uint32]spillRecord, len(s.index))
+	for id, r := range s.index {
+		if r.offset < end {
+			compacted[id] = index[id]
+			continue
+		}
+		if compacted[id], err = copyRecord(s.file, tmp, r, &size); err != nil {
+			return fail(err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fail(err)
+	}
+	s.file.Close()
+	s.file, s.size, s.records, s.index = tmp, size, len(compacted), compacted
+	return nil
+}
+
+// Copy a record to the end of the destination log of the size
+func copyRecord(src, dst *os.File, r spillRecord, size *int64) (spillRecord, error) {
+	b := make([]byte, r.length)
+	if _, err := src.ReadAt(b, r.offset); err != nil {
+		return spillRecord{}, err
+	}
+	if _, err := dst.WriteAt(b, *size); err != nil {
+		return spillRecord{}, err
+	}
+	copied := spillRecord{offset: *size, length: r.length}
+	*size += int64(r.length)
+	return copied, nil
+}
+
+// Spill the oldest samples in memory within a write transaction until the
+// samples in memory are 10% below the threshold, the lock has to be held
+func (db *Database) spillOldest(txn *memdb.Txn) {
+	s := db.spill
+	target := s.threshold - s.threshold/10
+	it, err := txn.Get("sample", "ts")
+	if err != nil {
+		panic(err)
+	}
+	var oldest []*storedSample
+	for obj := it.Next(); obj != nil && s.memSamples-len(oldest) > target; obj = it.Next() {
+		oldest = append(oldest, obj.(*storedSample))
+	}
+	for _, sample := range oldest {
+		if err := s.append(sample); err != nil {
+			db.log.Warnw("Could not spill sample to disk - keeping it in memory", "error", err)
+			break
+		}
+		if err := txn.Delete("sample", sample); err != nil {
+			panic(err)
+		}
+		s.memSamples--
+	}
+	if s.startCompaction() {
+		go func() {
+			if err := s.compact(); err != nil {
+				db.log.Warnw("Could not compact the spill log", "error", err)
+			}
+		}()
+	}
+}
+
+// Spill the oldest samples to the disk-backed log at the path if the samples
+// in memory exceed the threshold. The spilled samples are merged back
+// transparently on reads. It has to be enabled before samples are set.
+func (db *Database) EnableSpill(path string, threshold int) error {
+	spill, err := newSpillStore(path, threshold)
+	if err != nil {
+		return err
+	}
+	db.spill = spill
+	return nil
+}
+
+// Get the amount of samples spilled to disk
+func (db *Database) GetSpilledSampleCount() int {
+	if db.spill == nil {
+		return 0
+	}
+	db.spill.mu.Lock()
+	defer db.spill.mu.Unlock()
+	return len(db.spill.index)
+}
+
+// Lock the spill log, if enabled. Samples moving between memory and disk
+// are read consistently. Writers have to lock after the write transaction
+// is created, readers before the read transaction.
+func (db *Database) lockSpill() func() {
+	if db.spill == nil {
+		return func() {}
+	}
+	db.spill.mu.Lock()
+	return db.spill.mu.Unlock
+}
+
+// Get a spilled sample by id, the spill log has to be locked
+func (db *Database) spilledSample(id uint32) (*storedSample, bool) {
+	if db.spill == nil {
+		return nil, false
+	}
+	return db.spill.read(id)
+}
+
+// Read all spilled samples, the spill log has to be locked
+func (db *Database) spilledSamples() []*storedSample {
+	if db.spill == nil {
+		return nil
+	}
+	var samples []*storedSample
+	for id := range db.spill.index {
+		if sample, ok := db.spill.read(id); ok {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// Count a sample set within a write transaction, a spilled sample returns
+// to memory. The spill log has to be locked.
+func (db *Database) unspill(txn *memdb.Txn, id uint32) {
+	if db.spill == nil {
+		return
+	}
+	raw, err := txn.First("sample", "id", id)
+	if err != nil {
+		panic(err)
+	}
+	if raw == nil {
+		db.spill.memSamples++
+		delete(db.spill.index, id)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func testSpillDB(t *testing.T, threshold int) Database {
+	db, err := NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.EnableSpill(filepath.Join(t.TempDir(), "samples.spill"), threshold); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func Test_SpillOldestSamples(t *testing.T) {
+	db := testSpillDB(t, 10)
+	for i := 0; i < 11; i++ {
+		db.SetSample(&Sample{From: "a", To: string(rune('b' + i)), Key: RTT_TOTAL, Value: "1", Ts: int64(i + 1)})
+	}
+
+	// spilled down to 10% below the threshold
+	if spilled := db.GetSpilledSampleCount(); spilled != 2 {
+		t.Errorf("Expected 2 spilled samples, got %v", spilled)
+	}
+	if samples := db.GetSampleList(); len(samples) != 11 {
+		t.Errorf("Expected 11 samples incl. the spilled samples, got %v", len(samples))
+	}
+	oldest := GetSampleId(&Sample{From: "a", To: "b", Key: RTT_TOTAL})
+	if sample := db.GetSample(oldest); sample.Ts != 1 || sample.To != "b" {
+		t.Errorf("Expected the oldest sample to be read from disk, got %+v", sample)
+	}
+	if ts := db.GetSampleTs(oldest); ts != 1 {
+		t.Errorf("Expected the timestamp of the spilled sample, got %v", ts)
+	}
+
+	// a spilled sample returns to memory if it is set again
+	db.SetSample(&Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "2", Ts: 20})
+	if sample := db.GetSample(oldest); sample.Value != "2" {
+		t.Errorf("Expected the updated sample, got %+v", sample)
+	}
+	if samples := db.GetSampleList(); len(samples) != 11 {
+		t.Errorf("Expected 11 samples after the update, got %v", len(samples))
+	}
+}
+
+func Test_SpilledSampleNaNAndDelete(t *testing.T) {
+	db := testSpillDB(t, 1)
+	first := &Sample{From: "a", To: "b", Key: RTT_TOTAL, Value: "1", Ts: 1}
+	second := &Sample{From: "a", To: "c", Key: RTT_TOTAL, Value: "1", Ts: 2}
+	db.SetSample(first)
+	db.SetSample(second)
+	if spilled := db.GetSpilledSampleCount(); spilled != 1 {
+		t.Fatalf("Expected 1 spilled sample, got %v", spilled)
+	}
+
+	db.SetSampleNaN(first.Id)
+	if sample := db.GetSample(first.Id); sample.Value != "NaN" {
+		t.Errorf("Expected the spilled sample to be set NaN, got %+v", sample)
+	}
+
+	db.DeleteSample(first.Id)
+	db.DeleteSample(second.Id)
+	if samples := db.GetSampleList(); len(samples) != 0 {
+		t.Errorf("Expected all samples to be deleted, got %+v", samples)
+	}
+}
+
+func Test_spillStoreCompact(t *testing.T) {
+	s, err := newSpillStore(filepath.Join(t.TempDir(), "samples.spill"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < SPILL_COMPACT_MIN_RECORDS; i++ {
+		if err := s.append(&storedSample{Id: uint32(i % 10), Ts: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.startCompaction() {
+		t.Fatal("Expected the compaction to start")
+	}
+	if err := s.compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.records != 10 || len(s.index) != 10 {
+		t.Errorf("Expected 10 records after compaction, got %v", s.records)
+	}
+	if sample, ok := s.read(9); !ok || sample.Ts != SPILL_COMPACT_MIN_RECORDS-1 {
+		t.Errorf("Expected the latest record of sample 9, got %+v", sample)
+	}
+}
+
+func Test_spillStoreCompactFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "samples.spill")
+	s, err := newSpillStore(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < SPILL_COMPACT_MIN_RECORDS; i++ {
+		if err := s.append(&storedSample{Id: uint32(i % 10), Ts: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the temp file can't be created
+	if err := os.Mkdir(path+".compact", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if !s.startCompaction() {
+		t.Fatal("Expected the compaction to start")
+	}
+	if err := s.compact(); err == nil {
+		t.Fatal("Expected the compaction to fail")
+	}
+	if s.records != SPILL_COMPACT_MIN_RECORDS || len(s.index) != 10 || s.compacting {
+		t.Errorf("Expected the spill log to be kept, got %v records", s.records)
+	}
+	if sample, ok := s.read(9); !ok || sample.Ts != SPILL_COMPACT_MIN_RECORDS-1 {
+		t.Errorf("Expected the latest record of sample 9, got %+v", sample)
+	}
+}
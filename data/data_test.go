@@ -99,6 +99,38 @@ func Test_Convert(t *testing.T) {
 				StateChangeTs: 0,
 			},
 		},
+		{
+			name: "MeshNode with labels to Node",
+			inputMeshNode: &meshv1.Node{
+				Name:   "test",
+				Target: "tegraT",
+				Labels: map[string]string{"region": "eu", "unknown": "kept"},
+			},
+			state: 1,
+			expectedNode: &Node{
+				Id:            value(h.Hash("tegraT")),
+				Name:          "test",
+				State:         1,
+				Target:        "tegraT",
+				StateChangeTs: 0,
+				Labels:        map[string]string{"region": "eu", "unknown": "kept"},
+			},
+		},
+		{
+			name: "Node with labels to MeshNode",
+			inputNode: &Node{
+				Id:     value(h.Hash("tegraT")),
+				Name:   "test",
+				State:  1,
+				Target: "tegraT",
+				Labels: map[string]string{"zone": "a"},
+			},
+			expectedMeshNode: &meshv1.Node{
+				Name:   "test",
+				Target: "tegraT",
+				Labels: map[string]string{"zone": "a"},
+			},
+		},
 		{
 			name: "Node to MeshNode",
 			inputNode: &Node{
@@ -122,7 +154,8 @@ func Test_Convert(t *testing.T) {
 				if result.Id != tt.expectedNode.Id ||
 					result.Name != tt.expectedNode.Name ||
 					result.Target != tt.expectedNode.Target ||
-					result.State != tt.expectedNode.State {
+					result.State != tt.expectedNode.State ||
+					deep.Equal(result.Labels, tt.expectedNode.Labels) != nil {
 					diff := deep.Equal(result, tt.expectedNode)
 					t.Error(diff)
 				}
@@ -185,6 +218,27 @@ func Test_GetSampleId(t *testing.T) {
 			sample:     &Sample{},
 			expectedId: value(h.Hash("0")),
 		},
+		{
+			name: "Sample with node ids",
+			sample: &Sample{
+				From:   "Eagle",
+				To:     "Gose",
+				Key:    1,
+				FromId: 7,
+				ToId:   8,
+			},
+			expectedId: value(h.Hash("#7/#8/1")),
+		},
+		{
+			name: "Sample to an external target",
+			sample: &Sample{
+				From:   "Eagle",
+				To:     "https://example.com",
+				Key:    1,
+				FromId: 7,
+			},
+			expectedId: value(h.Hash("#7/https://example.com/1")),
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,113 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import "time"
+
+// Insert a tombstone for a node that left the mesh
+func (db *Database) SetTombstone(id uint32, name string) {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	err := txn.Insert("tombstone", &Tombstone{Id: id, Name: name, Ts: time.Now().Unix()})
+	if err != nil {
+		panic(err)
+	}
+
+	// Commit the transaction
+	txn.Commit()
+}
+
+// Check if a node left the mesh within the ttl
+func (db *Database) IsTombstoned(id uint32, ttl time.Duration) bool {
+	txn := db.Txn(false)
+	defer txn.Abort()
+
+	raw, err := txn.First("tombstone", "id", id)
+	if err != nil {
+		panic(err)
+	}
+	if raw == nil {
+		return false
+	}
+	return time.Unix(raw.(*Tombstone).Ts, 0).After(time.Now().Add(-1 * ttl))
+}
+
+// Delete the tombstone of a node, e.g. if the node re-joins
+func (db *Database) DeleteTombstone(id uint32) {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	_, err := txn.DeleteAll("tombstone", "id", id)
+	if err != nil {
+		db.log.Debugf("Could not delete tombstone")
+	}
+	// Commit the transaction
+	txn.Commit()
+}
+
+// Delete the tombstone of a node that left before ts in unix seconds,
+// e.g. the node was seen again after it left. True if it was deleted.
+func (db *Database) DeleteTombstoneBefore(id uint32, ts int64) bool {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	raw, err := txn.First("tombstone", "id", id)
+	if err != nil {
+		panic(err)
+	}
+	if raw == nil || raw.(*Tombstone).Ts >= ts {
+		return false
+	}
+	if err := txn.Delete("tombstone", raw); err != nil {
+		db.log.Debugf("Could not delete tombstone")
+		return false
+	}
+	// Commit the transaction
+	txn.Commit()
+	return true
+}
+
+// Delete all tombstones older than the ttl
+func (db *Database) DeleteExpiredTombstones(ttl time.Duration) {
+	txn := db.Txn(true)
+	defer txn.Abort()
+
+	it, err := txn.Get("tombstone", "id")
+	if err != nil {
+		panic(err)
+	}
+	expired := time.Now().Add(-1 * ttl)
+	var tombstones []*Tombstone
+	for obj := it.Next(); obj != nil; obj = it.Next() {
+		if time.Unix(obj.(*Tombstone).Ts, 0).Before(expired) {
+			tombstones = append(tombstones, obj.(*Tombstone))
+		}
+	}
+	for _, tombstone := range tombstones {
+		if err := txn.Delete("tombstone", tombstone); err != nil {
+			db.log.Debugf("Could not delete tombstone")
+		}
+	}
+	// Commit the transaction
+	txn.Commit()
+}
@@ -0,0 +1,92 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_Tombstone(t *testing.T) {
+	db, _ := NewMemDB(log)
+	if db.IsTombstoned(nodes[1].Id, time.Minute) {
+		t.Errorf("node is tombstoned without tombstone")
+	}
+
+	db.SetTombstone(nodes[1].Id, nodes[1].Name)
+	if !db.IsTombstoned(nodes[1].Id, time.Minute) {
+		t.Errorf("node is not tombstoned")
+	}
+	if db.IsTombstoned(nodes[1].Id, -time.Second) {
+		t.Errorf("node is tombstoned after the ttl")
+	}
+
+	db.DeleteTombstone(nodes[1].Id)
+	if db.IsTombstoned(nodes[1].Id, time.Minute) {
+		t.Errorf("node is tombstoned after delete")
+	}
+}
+
+func Test_DeleteTombstoneBefore(t *testing.T) {
+	db, _ := NewMemDB(log)
+	if db.DeleteTombstoneBefore(nodes[1].Id, time.Now().Unix()+1) {
+		t.Errorf("deleted a tombstone without tombstone")
+	}
+
+	db.SetTombstone(nodes[1].Id, nodes[1].Name)
+	// a contact before or at the leave keeps the tombstone
+	if db.DeleteTombstoneBefore(nodes[1].Id, 0) || db.DeleteTombstoneBefore(nodes[1].Id, time.Now().Add(-time.Minute).Unix()) {
+		t.Errorf("deleted a tombstone by an older contact")
+	}
+	if !db.IsTombstoned(nodes[1].Id, time.Minute) {
+		t.Errorf("node is not tombstoned")
+	}
+
+	if !db.DeleteTombstoneBefore(nodes[1].Id, time.Now().Add(time.Minute).Unix()) {
+		t.Errorf("tombstone not deleted by a newer contact")
+	}
+	if db.IsTombstoned(nodes[1].Id, time.Minute) {
+		t.Errorf("node is tombstoned after a newer contact")
+	}
+}
+
+func Test_DeleteExpiredTombstones(t *testing.T) {
+	db, _ := NewMemDB(log)
+	db.SetTombstone(nodes[1].Id, nodes[1].Name)
+	db.SetTombstone(nodes[2].Id, nodes[2].Name)
+
+	db.DeleteExpiredTombstones(time.Minute)
+	if !db.IsTombstoned(nodes[1].Id, time.Minute) || !db.IsTombstoned(nodes[2].Id, time.Minute) {
+		t.Errorf("tombstones deleted within the ttl")
+	}
+
+	db.DeleteExpiredTombstones(-time.Second)
+	txn := db.Txn(false)
+	defer txn.Abort()
+	raw, err := txn.First("tombstone", "id")
+	if err != nil {
+		t.Errorf("error occured: %v", err)
+	}
+	if raw != nil {
+		t.Errorf("expired tombstones not deleted")
+	}
+}
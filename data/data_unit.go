@@ -0,0 +1,111 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Time units of sample values with their scale in nanoseconds
+var timeUnits = map[string]float64{
+	"ns": 1,
+	"us": 1e3,
+	"ms": 1e6,
+	"s":  1e9,
+}
+
+// Units sample values are exported in by sample key.
+// Samples of keys without a unit are exported in the unit of their sample type,
+// the stored values are not changed.
+type UnitNormalizer map[int64]string
+
+// Create a normalizer of sample type names to units, e.g. rtt_total=ms.
+// The units of later configurations take precedence, just units
+// convertible from the unit of the sample type are supported.
+func NewUnitNormalizer(configs ...map[string]string) (UnitNormalizer, error) {
+	n := UnitNormalizer{}
+	for _, config := range configs {
+		for name, unit := range config {
+			key, ok := SampleKey(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown sample type %v", name)
+			}
+			t, _ := GetSampleType(key)
+			if _, err := unitScale(t.Unit, unit); err != nil {
+				return nil, fmt.Errorf("sample type %v: %w", name, err)
+			}
+			n[key] = unit
+		}
+	}
+	return n, nil
+}
+
+// Factor converting a value of a unit to another unit
+func unitScale(from string, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	fromScale, okFrom := timeUnits[from]
+	toScale, okTo := timeUnits[to]
+	if !okFrom || !okTo {
+		return 0, fmt.Errorf("can not convert unit %q to %q, supported: ns, us, ms, s", from, to)
+	}
+	return fromScale / toScale, nil
+}
+
+// Get the unit the values of a sample key are exported in
+func (n UnitNormalizer) Unit(key int64) string {
+	if unit, ok := n[key]; ok {
+		return unit
+	}
+	t, _ := GetSampleType(key)
+	return t.Unit
+}
+
+// Convert a value of a sample key to its export unit
+func (n UnitNormalizer) Float(key int64, value float64) float64 {
+	unit, ok := n[key]
+	if !ok {
+		return value
+	}
+	t, _ := GetSampleType(key)
+	scale, err := unitScale(t.Unit, unit)
+	if err != nil {
+		return value
+	}
+	return value * scale
+}
+
+// Convert a sample value to its export unit.
+// Non-numeric values (e.g. NaN, states) are kept.
+func (n UnitNormalizer) Value(key int64, value string) string {
+	if _, ok := n[key]; !ok {
+		return value
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) {
+		return value
+	}
+	return strconv.FormatFloat(n.Float(key, f), 'f', -1, 64)
+}
@@ -0,0 +1,76 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package data
+
+import (
+	"testing"
+)
+
+func Test_UnitNormalizer(t *testing.T) {
+	n, err := NewUnitNormalizer(
+		map[string]string{"rtt_total": "ms", "clock_skew": "s"},
+		map[string]string{"rtt_total": "us"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      int64
+		value    string
+		expected string
+		unit     string
+	}{
+		{name: "later unit takes precedence", key: RTT_TOTAL, value: "1500", expected: "1.5", unit: "us"},
+		{name: "nanoseconds to seconds", key: CLOCK_SKEW, value: "-2000000000", expected: "-2", unit: "s"},
+		{name: "unit of the sample type", key: RTT_REQUEST, value: "1500", expected: "1500", unit: "ns"},
+		{name: "non-numeric value", key: RTT_TOTAL, value: "NaN", expected: "NaN", unit: "us"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if value := n.Value(tt.key, tt.value); value != tt.expected {
+				t.Errorf("Expected value %v, got %v", tt.expected, value)
+			}
+			if unit := n.Unit(tt.key); unit != tt.unit {
+				t.Errorf("Expected unit %v, got %v", tt.unit, unit)
+			}
+		})
+	}
+
+	var empty UnitNormalizer
+	if value := empty.Value(RTT_TOTAL, "1500"); value != "1500" {
+		t.Errorf("Expected an unchanged value without units, got %v", value)
+	}
+}
+
+func Test_NewUnitNormalizerErrors(t *testing.T) {
+	for _, config := range []map[string]string{
+		{"unknown_type": "ms"},
+		{"rtt_total": "min"},
+		{"health_score": "ms"},
+	} {
+		if _, err := NewUnitNormalizer(config); err == nil {
+			t.Errorf("Expected an error for %v", config)
+		}
+	}
+}
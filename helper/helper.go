@@ -33,7 +33,11 @@ import (
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc/credentials"
 )
@@ -76,6 +80,45 @@ func ExternalIP() (string, error) {
 	return "", errors.New("Could not get outbound IP. Are you connected to the network?")
 }
 
+// Get the local IP of an IP address or interface name.
+// An IP has to be assigned to a local interface,
+// an interface returns its first IPv4 address.
+func LocalIP(addressOrInterface string) (net.IP, error) {
+	addrs, err := interfaceAddrs(addressOrInterface)
+	if err != nil {
+		return nil, err
+	}
+	want := net.ParseIP(addressOrInterface)
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if want == nil && ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+		if want != nil && ipNet.IP.Equal(want) {
+			return want, nil
+		}
+	}
+	if want == nil {
+		return nil, fmt.Errorf("interface %v has no IPv4 address", addressOrInterface)
+	}
+	return nil, fmt.Errorf("address %v is not assigned to a local interface", addressOrInterface)
+}
+
+// Addresses of the interface name, all local addresses for an IP
+func interfaceAddrs(addressOrInterface string) ([]net.Addr, error) {
+	if net.ParseIP(addressOrInterface) != nil {
+		return net.InterfaceAddrs()
+	}
+	iface, err := net.InterfaceByName(addressOrInterface)
+	if err != nil {
+		return nil, fmt.Errorf("%v is neither an IP nor a local interface: %w", addressOrInterface, err)
+	}
+	return iface.Addrs()
+}
+
 func LookupIP(url string) (string, error) {
 	ips, err := net.LookupIP(url)
 	if err != nil {
@@ -112,6 +155,39 @@ func Hash(s string) (uint32, error) {
 	return h.Sum32(), nil
 }
 
+// Scheme of unix domain socket targets
+const UNIX_SCHEME = "unix:"
+
+// Get the socket path of a unix domain socket target.
+// Supported targets are unix:///absolute/path and unix:relative/path.
+func UnixSocketPath(target string) (string, bool) {
+	if !strings.HasPrefix(target, UNIX_SCHEME) {
+		return "", false
+	}
+	path := strings.TrimPrefix(target, UNIX_SCHEME)
+	if strings.HasPrefix(path, "//") {
+		path = strings.TrimPrefix(path, "//")
+	}
+	if path == "" {
+		return "", false
+	}
+	return filepath.Clean(path), true
+}
+
+// Normalize a target, so different notations of a
+// unix domain socket target get the same id.
+// Other targets are returned unchanged.
+func NormalizeTarget(target string) string {
+	path, ok := UnixSocketPath(target)
+	if !ok {
+		return target
+	}
+	if filepath.IsAbs(path) {
+		return UNIX_SCHEME + "//" + path
+	}
+	return UNIX_SCHEME + path
+}
+
 // ------------------
 const charset = "abcdefghijklmnopqrstuvwxyz" +
 	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
@@ -152,13 +228,39 @@ func Equal(a, b []string) bool {
 }
 
 // TLS -----------------
-func LoadClientTLSCredentials(caCert_Paths []string, caCert_b64 []byte) (credentials.TransportCredentials, error) {
+func LoadClientTLSCredentials(caCert_Paths []string, caCert_b64 []byte, systemCerts bool, serverName string) (credentials.TransportCredentials, error) {
+	config, err := LoadClientTLSConfig(caCert_Paths, caCert_b64, systemCerts, serverName)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(config), nil
+}
+
+// Load the client TLS config with the given ca certs.
+// A ca cert path can be a directory, all *.pem and *.crt files will be loaded.
+// The system cert pool is appended if systemCerts is set.
+// The server name (SNI) will be overridden if set, e.g. to connect to an IP
+// or a shared load balancer with a hostname in the cert SAN.
+func LoadClientTLSConfig(caCert_Paths []string, caCert_b64 []byte, systemCerts bool, serverName string) (*tls.Config, error) {
 	// Load certificate of the CA who signed server certificate
 
 	certPool := x509.NewCertPool()
+	if systemCerts {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load system cert pool: %w", err)
+		}
+		certPool = systemPool
+	}
 
 	if len(caCert_Paths) > 0 {
 		for _, path := range caCert_Paths {
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				if err := appendCertsFromDir(certPool, path); err != nil {
+					return nil, err
+				}
+				continue
+			}
 			/* #nosec G304*/
 			pemServerCA, err := os.ReadFile(path)
 			if err != nil {
@@ -174,26 +276,87 @@ func LoadClientTLSCredentials(caCert_Paths []string, caCert_b64 []byte) (credent
 		if err != nil || !certPool.AppendCertsFromPEM(pemServerCA) {
 			return nil, fmt.Errorf("Failed to add server ca certificate")
 		}
-	} else {
+	} else if !systemCerts {
 		return nil, errors.New("Neither ca cert path nor base64 encoded ca cert set")
 	}
 
-	// Create the credentials and return it
+	// Create the config and return it
 	config := &tls.Config{
 		RootCAs:    certPool,
+		ServerName: serverName,
 		MinVersion: tls.VersionTLS12,
 	}
 
-	return credentials.NewTLS(config), nil
+	return config, nil
 }
 
+// Set the client cert & key presented to servers requesting a client cert.
+// The key pair is reloaded if the files change, like the server key pair.
+func SetClientCertificate(config *tls.Config, certPath string, keyPath string) error {
+	reloader, err := newCertReloader(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return reloader.certificate()
+	}
+	return nil
+}
+
+// Append all *.pem and *.crt files of a directory to the cert pool.
+// Malformed files are skipped with a warning.
+func appendCertsFromDir(certPool *x509.CertPool, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Failed to read ca cert directory %v: %w", dir, err)
+	}
+
+	loaded := 0
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".pem" && ext != ".crt") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		/* #nosec G304*/
+		pemServerCA, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: skipping ca certificate %v: %v\n", path, err)
+			continue
+		}
+		if !certPool.AppendCertsFromPEM(pemServerCA) {
+			log.Printf("Warning: skipping malformed ca certificate %v\n", path)
+			continue
+		}
+		loaded++
+	}
+
+	if loaded == 0 {
+		return fmt.Errorf("Failed to add server ca certificate, no valid certificate found in %v", dir)
+	}
+	return nil
+}
+
+// Load the server TLS config.
+// A cert & key loaded by path is reloaded if the files change,
+// e.g. a Kubernetes secret rotated in place by cert-manager.
 func LoadServerTLSCredentials(serverCert_path string, serverKey_path string, serverCert_b64 []byte, serverKey_b64 []byte) (*tls.Config, error) {
 	// Load server certificate and key //credentials.NewTLS(config)
 	var serverCert tls.Certificate
 	var err error
 
 	if serverCert_path != "" && serverKey_path != "" {
-		serverCert, err = tls.LoadX509KeyPair(serverCert_path, serverKey_path)
+		reloader, err := newCertReloader(serverCert_path, serverKey_path)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return reloader.certificate()
+			},
+			ClientAuth: tls.NoClientCert,
+			MinVersion: tls.VersionTLS12,
+		}, nil
 	} else if serverCert_b64 != nil && serverKey_b64 != nil {
 		var cert []byte
 		var key []byte
@@ -219,3 +382,98 @@ func LoadServerTLSCredentials(serverCert_path string, serverKey_path string, ser
 
 	return config, nil
 }
+
+// Watches files to reload them on change. The files are checked at most
+// every interval, they changed if their latest modification time changed.
+// A missing file counts as unchanged until it is created, so a failed load
+// is retried on the next change of the files and not on every check.
+// It is not safe for concurrent use, the owner has to lock it.
+type FileWatch struct {
+	paths    []string
+	interval time.Duration
+
+	modTime time.Time
+	checked time.Time
+	loaded  bool
+}
+
+// Create a watch of the files, the first check always reports a change
+func NewFileWatch(interval time.Duration, paths ...string) *FileWatch {
+	return &FileWatch{paths: paths, interval: interval}
+}
+
+// Check if the files changed since the last change reported,
+// the files have to be loaded again then
+func (w *FileWatch) Changed(now time.Time) bool {
+	if w.loaded && now.Sub(w.checked) < w.interval {
+		return false
+	}
+	w.checked = now
+	// zero if a file is missing
+	modTime, _ := latestModTime(w.paths...)
+	if w.loaded && modTime.Equal(w.modTime) {
+		return false
+	}
+	w.loaded = true
+	w.modTime = modTime
+	return true
+}
+
+// Reloads a key pair from its files on change
+type certReloader struct {
+	certPath string
+	keyPath  string
+
+	mu    sync.Mutex
+	cert  *tls.Certificate
+	watch *FileWatch
+}
+
+// Create a reloader, the key pair has to be loadable initially
+func newCertReloader(certPath string, keyPath string) (*certReloader, error) {
+	r := &certReloader{certPath: certPath, keyPath: keyPath, watch: NewFileWatch(0, certPath, keyPath)}
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Get the key pair, the files are reloaded if their modification time changed.
+// The last loaded key pair is kept if the files can not be loaded,
+// e.g. while the cert is written but the key is not yet.
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.watch.Changed(time.Now()) {
+		return r.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		if r.cert == nil {
+			return nil, err
+		}
+		log.Printf("Warning: could not reload TLS key pair %v, keeping the loaded one: %v\n", r.certPath, err)
+		return r.cert, nil
+	}
+	if r.cert != nil {
+		log.Printf("Reloaded TLS key pair %v\n", r.certPath)
+	}
+	r.cert = &cert
+	return r.cert, nil
+}
+
+// Latest modification time of the files, symlinks are followed
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
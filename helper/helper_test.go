@@ -1,8 +1,22 @@
 package helper
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func Test_stringWithCharset(t *testing.T) {
@@ -41,3 +55,370 @@ func Test_stringWithCharset(t *testing.T) {
 		})
 	}
 }
+
+// Create a CA and a server cert signed by the CA with the given DNS SAN.
+// The CA cert is written to a file, the path is returned.
+func newTestCert(t *testing.T, san string) (string, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: san},
+		DNSNames:     []string{san},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDer, err := x509.CreateCertificate(rand.Reader, serverTemplate, caTemplate, &serverKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caPath, tls.Certificate{Certificate: [][]byte{serverDer}, PrivateKey: serverKey}
+}
+
+func Test_LoadClientTLSConfigServerName(t *testing.T) {
+	caPath, serverCert := newTestCert(t, "canary.example.com")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		serverName string
+		expectErr  bool
+	}{
+		{name: "IP target without override - SAN mismatch", serverName: "", expectErr: true},
+		{name: "override matches SAN", serverName: "canary.example.com", expectErr: false},
+		{name: "override does not match SAN", serverName: "other.example.com", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadClientTLSConfig([]string{caPath}, nil, false, tt.serverName)
+			if err != nil {
+				t.Fatalf("could not load tls config: %v", err)
+			}
+			if config.ServerName != tt.serverName {
+				t.Errorf("server name is %v, expected %v", config.ServerName, tt.serverName)
+			}
+
+			conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", server.Listener.Addr().String(), config)
+			if err == nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.expectErr {
+				t.Errorf("error is %v, but expected error is %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func Test_LoadClientTLSConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	sans := []string{"old-ca.example.com", "new-ca.example.com"}
+	var servers []*httptest.Server
+	for i, san := range sans {
+		caPath, serverCert := newTestCert(t, san)
+		pemCA, err := os.ReadFile(caPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ext := []string{".pem", ".crt"}[i]
+		if err := os.WriteFile(filepath.Join(dir, "ca"+ext), pemCA, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}, MinVersion: tls.VersionTLS12}
+		server.StartTLS()
+		defer server.Close()
+		servers = append(servers, server)
+	}
+	// malformed and unrelated files are skipped
+	if err := os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("no cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README.txt"), []byte("no cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, server := range servers {
+		serverName := sans[i]
+		config, err := LoadClientTLSConfig([]string{dir}, nil, false, serverName)
+		if err != nil {
+			t.Fatalf("could not load tls config: %v", err)
+		}
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", server.Listener.Addr().String(), config)
+		if err != nil {
+			t.Errorf("could not connect to %v: %v", serverName, err)
+			continue
+		}
+		conn.Close()
+	}
+}
+
+func Test_LoadClientTLSConfigDirMalformed(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("no cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadClientTLSConfig([]string{dir}, nil, false, ""); err == nil {
+		t.Error("expected error for directory without valid ca certs")
+	}
+	if _, err := LoadClientTLSConfig([]string{filepath.Join(dir, "broken.pem")}, nil, false, ""); err == nil {
+		t.Error("expected error for malformed ca cert file")
+	}
+}
+
+func Test_LoadClientTLSConfigSystem(t *testing.T) {
+	if _, err := x509.SystemCertPool(); err != nil {
+		t.Skipf("no system cert pool: %v", err)
+	}
+	config, err := LoadClientTLSConfig(nil, nil, true, "")
+	if err != nil {
+		t.Fatalf("could not load tls config: %v", err)
+	}
+	if config.RootCAs == nil {
+		t.Error("root ca pool is nil")
+	}
+}
+
+func Test_UnixSocketPath(t *testing.T) {
+	tests := []struct {
+		target   string
+		path     string
+		expectOk bool
+	}{
+		{target: "unix:///tmp/cbot.sock", path: "/tmp/cbot.sock", expectOk: true},
+		{target: "unix:/tmp/cbot.sock", path: "/tmp/cbot.sock", expectOk: true},
+		{target: "unix:///tmp/./sub/../cbot.sock", path: "/tmp/cbot.sock", expectOk: true},
+		{target: "unix:cbot.sock", path: "cbot.sock", expectOk: true},
+		{target: "unix:", expectOk: false},
+		{target: "localhost:8081", expectOk: false},
+		{target: "bird-owl.com:443", expectOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			path, ok := UnixSocketPath(tt.target)
+			if ok != tt.expectOk || path != tt.path {
+				t.Errorf("got %v %v, expected %v %v", path, ok, tt.path, tt.expectOk)
+			}
+		})
+	}
+}
+
+func Test_NormalizeTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{target: "unix:///tmp/cbot.sock", expected: "unix:///tmp/cbot.sock"},
+		{target: "unix:/tmp/cbot.sock", expected: "unix:///tmp/cbot.sock"},
+		{target: "unix:///tmp//cbot.sock", expected: "unix:///tmp/cbot.sock"},
+		{target: "unix:./cbot.sock", expected: "unix:cbot.sock"},
+		{target: "localhost:8081", expected: "localhost:8081"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if result := NormalizeTarget(tt.target); result != tt.expected {
+				t.Errorf("got %v, expected %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_LocalIP(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected string
+		wantErr  bool
+	}{
+		{address: "127.0.0.1", expected: "127.0.0.1"},
+		{address: "192.0.2.1", wantErr: true},
+		{address: "no-such-interface", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			ip, err := LocalIP(tt.address)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && ip.String() != tt.expected {
+				t.Errorf("got %v, expected %v", ip, tt.expected)
+			}
+		})
+	}
+}
+
+// Write the key pair to cert.pem & key.pem of the directory
+func writeTestKeyPair(t *testing.T, dir string, cert tls.Certificate) (string, string) {
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+func Test_LoadServerTLSCredentialsReload(t *testing.T) {
+	dir := t.TempDir()
+	_, oldCert := newTestCert(t, "old.example.com")
+	certPath, keyPath := writeTestKeyPair(t, dir, oldCert)
+
+	config, err := LoadServerTLSCredentials(certPath, keyPath, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servedName := func() string {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if name := servedName(); name != "old.example.com" {
+		t.Fatalf("got cert %v, expected old.example.com", name)
+	}
+
+	// rotate the key pair in place
+	_, newCert := newTestCert(t, "new.example.com")
+	writeTestKeyPair(t, dir, newCert)
+	rotated := time.Now().Add(time.Minute)
+	for _, path := range []string{certPath, keyPath} {
+		if err := os.Chtimes(path, rotated, rotated); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if name := servedName(); name != "new.example.com" {
+		t.Errorf("got cert %v after rotation, expected new.example.com", name)
+	}
+
+	// a broken key pair keeps the loaded one
+	if err := os.WriteFile(keyPath, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	broken := rotated.Add(time.Minute)
+	if err := os.Chtimes(keyPath, broken, broken); err != nil {
+		t.Fatal(err)
+	}
+	if name := servedName(); name != "new.example.com" {
+		t.Errorf("got cert %v after a broken rotation, expected new.example.com", name)
+	}
+}
+
+func Test_SetClientCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	_, oldCert := newTestCert(t, "old.example.com")
+	certPath, keyPath := writeTestKeyPair(t, dir, oldCert)
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := SetClientCertificate(config, certPath, keyPath); err != nil {
+		t.Fatal(err)
+	}
+	presentedName := func() string {
+		cert, err := config.GetClientCertificate(&tls.CertificateRequestInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if name := presentedName(); name != "old.example.com" {
+		t.Fatalf("got cert %v, expected old.example.com", name)
+	}
+
+	// rotate the key pair in place
+	_, newCert := newTestCert(t, "new.example.com")
+	writeTestKeyPair(t, dir, newCert)
+	rotated := time.Now().Add(time.Minute)
+	for _, path := range []string{certPath, keyPath} {
+		if err := os.Chtimes(path, rotated, rotated); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if name := presentedName(); name != "new.example.com" {
+		t.Errorf("got cert %v after rotation, expected new.example.com", name)
+	}
+
+	// a key pair not loadable initially fails
+	if err := SetClientCertificate(&tls.Config{}, filepath.Join(dir, "missing.pem"), keyPath); err == nil {
+		t.Error("Expected an error for a missing client cert")
+	}
+}
+
+func Test_FileWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	now := time.Now()
+	w := NewFileWatch(time.Second, path)
+
+	// the first check reports a change, a missing file is reported once
+	if !w.Changed(now) {
+		t.Error("Expected the first check to report a change")
+	}
+	if w.Changed(now.Add(time.Second)) {
+		t.Error("Expected a missing file to be unchanged")
+	}
+
+	if err := os.WriteFile(path, []byte("1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if w.Changed(now.Add(time.Second + time.Millisecond)) {
+		t.Error("Expected the file to be checked after the interval")
+	}
+	if !w.Changed(now.Add(2 * time.Second)) {
+		t.Error("Expected the created file to be changed")
+	}
+	if w.Changed(now.Add(3 * time.Second)) {
+		t.Error("Expected the file to be unchanged")
+	}
+
+	if err := os.Chtimes(path, now, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Changed(now.Add(4 * time.Second)) {
+		t.Error("Expected the modified file to be changed")
+	}
+}
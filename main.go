@@ -26,6 +26,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/telekom/canary-bot/mesh"
 
@@ -36,6 +37,8 @@ import (
 
 const (
 	envPrefix = "MESH"
+	// Flag of the config file
+	configFlag = "config"
 )
 
 var cmd = &cobra.Command{
@@ -70,11 +73,120 @@ cbot --name swan -t bird-goose.com:443 -t bird-eagle.net:8080 --ca-cert-path pat
 var (
 	defaults mesh.SetupConfiguration
 	set      mesh.SetupConfiguration
+	// Path of the config file, empty if not set
+	configPath string
 )
 
 // Will create the
 func run(cmd *cobra.Command, args []string) {
-	mesh.CreateCanaryMesh(mesh.StandardProductionRoutineConfig(), &set)
+	// the CLI drains the mesh & exits on SIGINT or SIGTERM
+	set.ExitOnSignal = true
+	routine := mesh.StandardProductionRoutineConfig()
+	routine.PushSampleToAmount = pushFanout
+	mesh.CreateCanaryMesh(routine, &set)
+}
+
+// Gossip fanout of the push sample routine
+var pushFanout int
+
+// Self-test command, starts two in-process nodes
+// and verifies the sample flow between them
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Verify the end-to-end sample flow with two in-process nodes",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := mesh.SelfTest(selftestTimeout, set.Debug); err != nil {
+			fmt.Printf("selftest FAIL: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("selftest PASS")
+	},
+}
+
+var selftestTimeout time.Duration
+
+// Reconcile command, diffs the sample views of two nodes
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile TARGET_A TARGET_B",
+	Short: "Compare the known samples of two nodes and report discrepancies",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		diffs, err := mesh.Reconcile(&set, args[0], args[1], reconcileToken, reconcileTimeout)
+		if err != nil {
+			fmt.Printf("reconcile FAIL: %v\n", err)
+			os.Exit(2)
+		}
+		for _, diff := range diffs {
+			fmt.Println(diff.String())
+		}
+		if len(diffs) > 0 {
+			fmt.Printf("%v discrepancies found\n", len(diffs))
+			os.Exit(1)
+		}
+		fmt.Println("nodes are in sync")
+	},
+}
+
+var (
+	reconcileToken   string
+	reconcileTimeout time.Duration
+)
+
+// Resync command, a node pulls & merges all samples of a known node
+var resyncCmd = &cobra.Command{
+	Use:   "resync TARGET PEER",
+	Short: "Resync the samples of the node at TARGET with its known node PEER (by name)",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		res, err := mesh.ResyncNode(&set, args[0], args[1], resyncToken, resyncTimeout)
+		if err != nil {
+			fmt.Printf("resync FAIL: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("resync done: %v added, %v updated, %v unchanged, %v rejected\n", res.Added, res.Updated, res.Unchanged, res.Rejected)
+	},
+}
+
+var (
+	resyncToken   string
+	resyncTimeout time.Duration
+)
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause TARGET",
+	Short: "Pause the outbound probes of the node at TARGET, e.g. during a maintenance",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setPaused(args[0], true)
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume TARGET",
+	Short: "Resume the outbound probes of the paused node at TARGET",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setPaused(args[0], false)
+	},
+}
+
+var (
+	pauseToken   string
+	pauseTimeout time.Duration
+)
+
+// Pause or resume the node at the target and print the paused state
+func setPaused(target string, pause bool) {
+	paused, err := mesh.PauseNode(&set, target, pauseToken, pauseTimeout, pause)
+	if err != nil {
+		fmt.Printf("FAIL: %v\n", err)
+		os.Exit(1)
+	}
+	if paused {
+		fmt.Println("paused")
+		return
+	}
+	fmt.Println("resumed")
 }
 
 func main() {
@@ -90,32 +202,156 @@ func main() {
 // All cmd flags will be defined.
 func init() {
 	defaults = mesh.SetupConfiguration{
-		Targets:        []string{},
-		Name:           "",
-		JoinAddress:    "",
-		ListenAddress:  "",
-		ListenPort:     8081,
-		ApiPort:        8080,
-		ServerCertPath: "",
-		ServerKeyPath:  "",
-		ServerCert:     nil,
-		ServerKey:      nil,
-		CaCertPath:     []string{},
-		CaCert:         nil,
-		Tokens:         []string{},
-		CleanupNodes:   false,
-		CleanupSamples: false,
-		Debug:          false,
-		DebugGrpc:      false,
+		Targets:                    []string{},
+		TargetSrv:                  "",
+		TargetK8sService:           "",
+		TargetShuffle:              "none",
+		DiscoveryMulticast:         "",
+		DiscoveryMulticastInterval: time.Second * 5,
+		DiscoveryMulticastTimeout:  time.Second * 10,
+		Name:                       "",
+		JoinAddress:                "",
+		ListenAddress:              "",
+		ListenPort:                 8081,
+		ListenBacklog:              0,
+		ReusePort:                  false,
+		ListenSockets:              1,
+		MaxConcurrentStreams:       0,
+		MaxRecvMsgSize:             0,
+		MaxInboundStreams:          0,
+		MaxInboundConnections:      0,
+		Labels:                     map[string]string{},
+		AdvertiseAddress:           "",
+		AdvertisePort:              0,
+		ApiPort:                    8080,
+		ServerCertPath:             "",
+		ServerKeyPath:              "",
+		ServerCert:                 nil,
+		ServerKey:                  nil,
+		CaCertPath:                 []string{},
+		CaCert:                     nil,
+		CaCertSystem:               false,
+		ClientCertPath:             "",
+		ClientKeyPath:              "",
+		ServerNameOverride:         "",
+		RequireTLS:                 false,
+		TLSFallback:                false,
+		MeshToken:                  "",
+		MeshTokenPath:              "",
+		RequestLogSampleRate:       0,
+		RequestLogSampleRatePath:   "",
+		RefuseIncompatible:         false,
+		Tokens:                     []string{},
+		CleanupNodes:               false,
+		CleanupSamples:             false,
+		DisableNodeLabel:           false,
+		MetricsPort:                0,
+		MetricsCertPath:            "",
+		MetricsKeyPath:             "",
+		MetricsBasicAuth:           "",
+		MetricsTokens:              []string{},
+		AggregationWindow:          0,
+		AggregationOnly:            false,
+		Aggregator:                 false,
+		AggregatorExcludeSamples:   []string{},
+		AggregatorMaxSeries:        10000,
+		ExportUnits:                map[string]string{},
+		MetricUnits:                map[string]string{},
+		ApiUnits:                   map[string]string{},
+		SampleRounding:             map[string]string{},
+		SampleRoundingStorage:      false,
+		SampleHistory:              map[string]string{},
+		SelfSamples:                mesh.SELF_SAMPLES_KEEP,
+		Observer:                   false,
+		Probes:                     []string{},
+		ProbeInterval:              time.Second * 10,
+		DisableMesh:                false,
+		RttSelection:               "random",
+		ProbeIntervalOverrides:     []string{},
+		ProbeSchedules:             []string{},
+		Peering:                    []string{},
+		ReadinessPolicy:            "none",
+		ReadinessMinPeers:          1,
+		ReadinessMinFraction:       0.5,
+		ReadinessMinScore:          0.5,
+		ReadinessWeights:           []string{},
+		ProbeIntervalMin:           time.Second,
+		RttPayloadSizes:            []int{},
+		RttPayloadEcho:             false,
+		RttEdgeLabels:              false,
+		OneWayDelay:                false,
+		OneWayDelayMaxSkew:         time.Millisecond,
+		ThroughputInterval:         0,
+		ThroughputVolume:           1 << 20,
+		MaxConcurrentProbes:        0,
+		ProbePools:                 map[string]int{},
+		MaxHops:                    16,
+		JoinCoalesceWindow:         0,
+		NameCollisionLimit:         1,
+		NameCollisionAction:        mesh.NAME_COLLISION_EXIT,
+		ClientIdleTimeout:          0,
+		PauseStatePath:             "",
+		DiscoveryMaxDepth:          0,
+		DigestSync:                 false,
+		DigestFullSyncRatio:        0.5,
+		EventLogSize:               1000,
+		SampleSpillPath:            "",
+		SampleSpillThreshold:       100000,
+		AcceptSamples:              []string{},
+		ExportSamples:              []string{},
+		StatsdAddress:              "",
+		StatsdPrefix:               "canary_bot",
+		StatsdFormat:               mesh.STATSD_FORMAT_STATSD,
+		SinkKafkaBrokers:           []string{},
+		SinkTopic:                  "",
+		SinkFormat:                 mesh.SINK_FORMAT_JSON,
+		SinkBufferSize:             mesh.DEFAULT_SINK_BUFFER_SIZE,
+		SinkBatchSize:              mesh.DEFAULT_SINK_BATCH_SIZE,
+		SinkFlushInterval:          mesh.DEFAULT_SINK_FLUSH_INTERVAL,
+		MetricLabels:               map[string]string{},
+		ProbeGroup:                 "",
+		HealthWeights:              mesh.HealthWeights{Success: 0.5, Rtt: 0.3, Jitter: 0.2},
+		HealthRttBaseline:          time.Millisecond * 100,
+		RttAnomalyThreshold:        0,
+		RttAnomalyAlpha:            0.1,
+		RttAnomalyWarmup:           20,
+		RttAnomalyMinDeviation:     time.Millisecond,
+		Dscp:                       map[string]int{},
+		LocalAddress:               "",
+		TcpNoDelay:                 true,
+		SocketSendBuffer:           0,
+		SocketRecvBuffer:           0,
+		DnsCacheTTL:                0,
+		DnsCacheGrace:              time.Minute * 5,
+		Debug:                      false,
+		DebugGrpc:                  false,
+		GrpcReflection:             false,
 	}
 
 	// Targets for joining
 	cmd.Flags().StringSliceVarP(&set.Targets, "target", "t", defaults.Targets, "Comma-seperated or multi-flag list of targets for joining the mesh.\nFormat: [IP|ADDRESS]:PORT")
 
+	cmd.Flags().StringVar(&set.TargetSrv, "target-srv", defaults.TargetSrv, "DNS SRV record to resolve the targets for joining the mesh, e.g. _canary._tcp.example.com. Static targets are the fallback")
+	cmd.Flags().StringVar(&set.TargetK8sService, "target-k8s-service", defaults.TargetK8sService, "Kubernetes service [NAMESPACE/]NAME[:PORT] to join the mesh by its pods, listed by the in-cluster service account; the headless service DNS and the static targets are the fallback")
+	cmd.Flags().StringVar(&set.TargetShuffle, "target-shuffle", defaults.TargetShuffle, "Order of the join targets: none (listed order), random per join or name for a reproducible order seeded by the node name, to spread the join load over all seeds")
+	cmd.Flags().StringVar(&set.DiscoveryMulticast, "discovery-multicast", defaults.DiscoveryMulticast, "Multicast group IP:PORT to discover the peers without seeds in a flat L2 network, e.g. 239.255.77.77:8079; the discovered peers are joined in addition to the seeds (default disabled)")
+	cmd.Flags().DurationVar(&set.DiscoveryMulticastInterval, "discovery-multicast-interval", defaults.DiscoveryMulticastInterval, "Interval the node announces its name & advertise target to the multicast group, peers expire after 3 missed announcements")
+	cmd.Flags().DurationVar(&set.DiscoveryMulticastTimeout, "discovery-multicast-timeout", defaults.DiscoveryMulticastTimeout, "Time the first join waits for a peer announcement before using the seeds only, e.g. if multicast is filtered")
+
 	// ssttings for this node
 	cmd.Flags().StringVarP(&set.Name, "name", "n", defaults.Name, "Name of the node, has to be unique in mesh (mandatory)")
-	cmd.Flags().StringVar(&set.ListenAddress, "listen-address", defaults.ListenAddress, "Address or IP the server of the node will bind to; eg. 0.0.0.0, localhost (default outbound IP of the network interface)")
+	cmd.Flags().StringVar(&set.ListenAddress, "listen-address", defaults.ListenAddress, "Address or IP the server of the node will bind to; eg. 0.0.0.0, localhost or a unix domain socket unix:///path/to/sock (default outbound IP of the network interface)")
 	cmd.Flags().Int64Var(&set.ListenPort, "listen-port", defaults.ListenPort, "Listening port of this node")
+	cmd.Flags().IntVar(&set.ListenBacklog, "listen-backlog", defaults.ListenBacklog, "TCP listen backlog of the mesh server, e.g. for join storms on cluster restarts; capped by net.core.somaxconn on linux (default OS default)")
+	cmd.Flags().BoolVar(&set.ReusePort, "reuse-port", defaults.ReusePort, "Enable SO_REUSEPORT on the mesh server listener, ignored if not supported on the platform (default disabled)")
+	cmd.Flags().IntVar(&set.ListenSockets, "listen-sockets", defaults.ListenSockets, "Amount of listen sockets of the mesh server sharing the port by SO_REUSEPORT to spread the accepts, needs reuse-port")
+	cmd.Flags().Uint32Var(&set.MaxConcurrentStreams, "max-concurrent-streams", defaults.MaxConcurrentStreams, "Max. concurrent streams per inbound connection of the mesh server, further streams of a client queue (default gRPC default, unlimited)")
+	cmd.Flags().IntVar(&set.MaxRecvMsgSize, "max-recv-msg-size", defaults.MaxRecvMsgSize, "Max. size in bytes of an inbound message of the mesh server, larger messages are rejected before they are received; at least 66560 for the RTT payloads (default gRPC default, 4 MiB)")
+	cmd.Flags().IntVar(&set.MaxInboundStreams, "max-inbound-streams", defaults.MaxInboundStreams, "Max. concurrent inbound RPCs of all connections, excess RPCs are rejected with ResourceExhausted, e.g. 1000 on seed nodes (default unlimited)")
+	cmd.Flags().IntVar(&set.MaxInboundConnections, "max-inbound-connections", defaults.MaxInboundConnections, "Max. inbound connections of the mesh server, excess connections are closed, e.g. 500 on seed nodes (default unlimited)")
+	cmd.Flags().StringToStringVar(&set.Labels, "label", defaults.Labels, "Comma-seperated or multi-flag list of labels of the node, propagated in the mesh; e.g. region=eu,zone=a")
+	cmd.Flags().StringVar(&set.AdvertiseAddress, "advertise-address", defaults.AdvertiseAddress, "Address or IP of this node advertised to other nodes in discoveries and the node id derives from, e.g. behind NAT or port forwarding; a wildcard address is rejected (default host of join-address)")
+	cmd.Flags().Int64Var(&set.AdvertisePort, "advertise-port", defaults.AdvertisePort, "Port of this node advertised to other nodes in discoveries (default port of join-address or listen-port)")
 	cmd.Flags().StringVar(&set.JoinAddress, "join-address", defaults.JoinAddress, "Address of this node; nodes in the mesh will use the domain to connect; eg. test.de, localhost (default outbound IP of the network interface)")
 
 	// API
@@ -128,8 +364,19 @@ func init() {
 	cmd.Flags().BytesBase64Var(&set.ServerKey, "server-key", defaults.ServerKey, "Base64 encoded server key, use with server-cert to enable TLS")
 
 	// TLS client side
-	cmd.Flags().StringSliceVar(&set.CaCertPath, "ca-cert-path", defaults.CaCertPath, "Path to ca cert file/s to enable TLS")
+	cmd.Flags().StringSliceVar(&set.CaCertPath, "ca-cert-path", defaults.CaCertPath, "Path to ca cert file/s or directories of *.pem & *.crt files to enable TLS")
+	cmd.Flags().StringVar(&set.ClientCertPath, "client-cert-path", defaults.ClientCertPath, "Path to the client cert file presented to servers requesting a client cert, reloaded on change - use with client-key-path")
+	cmd.Flags().StringVar(&set.ClientKeyPath, "client-key-path", defaults.ClientKeyPath, "Path to the client key file, reloaded on change - use with client-cert-path")
+	cmd.Flags().StringVar(&set.ServerNameOverride, "server-name-override", defaults.ServerNameOverride, "Override the server name (SNI) of mesh connections and HTTP probes, e.g. for shared load balancers or IP targets")
+	cmd.Flags().BoolVar(&set.RequireTLS, "require-tls", defaults.RequireTLS, "Require TLS for mesh connections, fail instead of falling back to insecure connections")
+	cmd.Flags().BoolVar(&set.TLSFallback, "tls-fallback", defaults.TLSFallback, "Connect peers not speaking TLS insecure, TLS is tried first on every connection - e.g. during a TLS rollout")
+	cmd.Flags().StringVar(&set.MeshToken, "mesh-token", defaults.MeshToken, "Shared secret authenticating the mesh RPCs, sent as bearer token (default no authentication)")
+	cmd.Flags().Float64Var(&set.RequestLogSampleRate, "request-log-sample-rate", defaults.RequestLogSampleRate, "Sample rate 0-1 of the successful inbound RPCs logged with method, peer, duration & status, e.g. 0.01; failed RPCs are always logged (default none)")
+	cmd.Flags().StringVar(&set.RequestLogSampleRatePath, "request-log-sample-rate-path", defaults.RequestLogSampleRatePath, "Path of a file with the request log sample rate, reloaded on change to adjust the rate at runtime; takes precedence over request-log-sample-rate")
+	cmd.Flags().StringVar(&set.MeshTokenPath, "mesh-token-path", defaults.MeshTokenPath, "Path of a file with the mesh token, reloaded on change; one token per line is accepted, the first one is sent")
+	cmd.Flags().BoolVar(&set.RefuseIncompatible, "refuse-incompatible", defaults.RefuseIncompatible, "Refuse to join and to be joined by nodes with an incompatible mesh protocol version, otherwise the nodes are just logged (default disabled)")
 	cmd.Flags().BytesBase64Var(&set.CaCert, "ca-cert", defaults.CaCert, "Base64 encoded ca cert to enable TLS, support for multiple ca certs by ca-cert-path flag")
+	cmd.Flags().BoolVar(&set.CaCertSystem, "ca-cert-system", defaults.CaCertSystem, "Append the system cert pool to the ca certs, e.g. during a CA migration")
 
 	// Auth API
 	cmd.Flags().StringSliceVar(&set.Tokens, "token", defaults.Targets, "Comma-seperated or multi-flag list of tokens to protect the sample data API. (optional)")
@@ -138,13 +385,159 @@ func init() {
 	cmd.Flags().BoolVar(&set.CleanupNodes, "cleanup-nodes", defaults.CleanupNodes, "Enable cleanup mode for nodes (default disabled)")
 	cmd.Flags().BoolVar(&set.CleanupSamples, "cleanup-samples", defaults.CleanupSamples, "Enable cleanup mode for measurment samples (default disabled)")
 
+	// Metrics
+	cmd.Flags().BoolVar(&set.DisableNodeLabel, "disable-node-label", defaults.DisableNodeLabel, "Disable the node label of the client connection metrics to reduce cardinality")
+	cmd.Flags().Int64Var(&set.MetricsPort, "metrics-port", defaults.MetricsPort, "Port of a dedicated metrics server, metrics are served by the API if not set (optional)")
+	cmd.Flags().StringVar(&set.MetricsCertPath, "metrics-cert-path", defaults.MetricsCertPath, "Path to the cert file of the metrics server - use with metrics-key-path to enable TLS")
+	cmd.Flags().StringVar(&set.MetricsKeyPath, "metrics-key-path", defaults.MetricsKeyPath, "Path to the key file of the metrics server - use with metrics-cert-path to enable TLS")
+	cmd.Flags().StringVar(&set.MetricsBasicAuth, "metrics-basic-auth", defaults.MetricsBasicAuth, "Protect the metrics server with basic auth. Format: USER:PASSWORD (optional)")
+	cmd.Flags().StringSliceVar(&set.MetricsTokens, "metrics-token", defaults.MetricsTokens, "Comma-seperated or multi-flag list of bearer tokens to protect the metrics server (optional)")
+	cmd.Flags().DurationVar(&set.AggregationWindow, "aggregation-window", defaults.AggregationWindow, "Export min, avg & max of the samples per window, e.g. 1m (default disabled)")
+	cmd.Flags().BoolVar(&set.AggregationOnly, "aggregation-only", defaults.AggregationOnly, "Export just the aggregation window, not the raw RTT histogram")
+	cmd.Flags().BoolVar(&set.Aggregator, "aggregator", defaults.Aggregator, "Export the values of all received samples of the mesh as mesh_sample_value by from & to node, a single scrape target for the whole mesh (default disabled)")
+	cmd.Flags().StringSliceVar(&set.AggregatorExcludeSamples, "aggregator-exclude-samples", defaults.AggregatorExcludeSamples, "Comma-separated or multi-flag list of sample type names not exported by the aggregator, e.g. clock_skew,heartbeat")
+	cmd.Flags().IntVar(&set.AggregatorMaxSeries, "aggregator-max-series", defaults.AggregatorMaxSeries, "Max. series exported by the aggregator to bound the cardinality, further samples are dropped and counted")
+	cmd.Flags().StringToStringVar(&set.ExportUnits, "export-units", defaults.ExportUnits, "Units of the exported sample values by sample type name, metrics & API; time units: ns, us, ms, s; e.g. rtt_total=ms (default unit of the sample type)")
+	cmd.Flags().StringVar(&set.ProbeGroup, "probe-group", defaults.ProbeGroup, "Probe group of the node, e.g. edge; added to the samples measured by the node and as group label to all metrics, to aggregate the samples of a group of nodes (default none)")
+	cmd.Flags().StringToStringVar(&set.MetricLabels, "metric-label", defaults.MetricLabels, "Comma-seperated or multi-flag list of static labels added to all metrics, e.g. datacenter=dc1,cluster=a; must not collide with the labels of a metric, e.g. node")
+	cmd.Flags().StringVar(&set.StatsdAddress, "statsd-address", defaults.StatsdAddress, "StatsD endpoint (host:port) the samples measured by this node are sent to over UDP, in addition to the Prometheus metrics (default disabled)")
+	cmd.Flags().StringVar(&set.StatsdPrefix, "statsd-prefix", defaults.StatsdPrefix, "Prefix of the StatsD metric names")
+	cmd.Flags().StringVar(&set.StatsdFormat, "statsd-format", defaults.StatsdFormat, "Format of the StatsD metrics: statsd or dogstatsd with tags of node, peer & sample type")
+	cmd.Flags().StringSliceVar(&set.SinkKafkaBrokers, "sink-kafka-brokers", defaults.SinkKafkaBrokers, "Comma-separated or multi-flag list of Kafka brokers (host:port) the samples measured by this node are published to, in addition to the Prometheus metrics (default disabled)")
+	cmd.Flags().StringVar(&set.SinkTopic, "sink-topic", defaults.SinkTopic, "Kafka topic of the published samples, keyed by the node name; required with sink-kafka-brokers")
+	cmd.Flags().StringVar(&set.SinkFormat, "sink-format", defaults.SinkFormat, "Format of the published samples: json or protobuf (mesh.v1.Sample)")
+	cmd.Flags().IntVar(&set.SinkBufferSize, "sink-buffer-size", defaults.SinkBufferSize, "Max. samples buffered for the sink, the oldest samples are dropped on overflow")
+	cmd.Flags().IntVar(&set.SinkBatchSize, "sink-batch-size", defaults.SinkBatchSize, "Max. samples published in one batch")
+	cmd.Flags().DurationVar(&set.SinkFlushInterval, "sink-flush-interval", defaults.SinkFlushInterval, "Max. interval the buffered samples are published, if no batch is full")
+	cmd.Flags().StringSliceVar(&set.ExportSamples, "export-samples", defaults.ExportSamples, "Comma-separated or multi-flag list of sample type names exported as metrics, e.g. rtt_total,health_score; the other samples are still stored & available by the API (default all)")
+	cmd.Flags().StringToStringVar(&set.MetricUnits, "metric-units", defaults.MetricUnits, "Units of the sample values exported as metrics, take precedence over the export units; e.g. rtt_total=s")
+	cmd.Flags().StringToStringVar(&set.SampleRounding, "sample-rounding", defaults.SampleRounding, "Rounding granularities of the sample values exported as metrics by sample type name, a duration or a number in the unit of the sample type; e.g. rtt_total=1us,health_score=0.01 (default raw values)")
+	cmd.Flags().BoolVar(&set.SampleRoundingStorage, "sample-rounding-storage", defaults.SampleRoundingStorage, "Round the sample values before they are stored instead of exported as metrics, the raw values are not available by the API (default disabled)")
+	cmd.Flags().StringToStringVar(&set.SampleHistory, "sample-history", defaults.SampleHistory, "History retention of the sample values by sample type name, the last values (last) or a uniform random subset of all values (reservoir) up to a size.\nFormat: MODE:SIZE; e.g. rtt_total=reservoir:1000,state=last:100 (default no history)")
+	cmd.Flags().StringVar(&set.SelfSamples, "self-samples", defaults.SelfSamples, "Handling of self-referential samples, the measuring node is the measured node by node id, and of samples of peers about this node: keep, drop (counted by self_samples_dropped_total) or exclude from the aggregations")
+	cmd.Flags().StringToStringVar(&set.ApiUnits, "api-units", defaults.ApiUnits, "Units of the sample values exported by the API, take precedence over the export units; e.g. rtt_total=ms")
+
+	// Observer mode
+	cmd.Flags().BoolVar(&set.Observer, "observer", defaults.Observer, "Join the mesh and receive samples, but never ping, measure or push samples to other nodes (default disabled)")
+
+	// External probes
+	cmd.Flags().StringSliceVar(&set.Probes, "probe", defaults.Probes, "Comma-seperated or multi-flag list of external targets to probe.\nFormat: TYPE://TARGET[#INTERVAL], TYPE: http, https, tcp, dns, icmp, h3, grpc; e.g. tcp://example.com:443#30s")
+	cmd.Flags().DurationVar(&set.ProbeInterval, "probe-interval", defaults.ProbeInterval, "Default interval of the external probes")
+	cmd.Flags().BoolVar(&set.DisableMesh, "disable-mesh", defaults.DisableMesh, "Disable the mesh, just probe external targets and serve the API (default disabled)")
+
+	// RTT
+	cmd.Flags().StringVar(&set.RttSelection, "rtt-selection", defaults.RttSelection, "Node selection of the RTT measurement: random or consistent-hash for a predictable, balanced coverage of all peers")
+	cmd.Flags().StringSliceVar(&set.ProbeIntervalOverrides, "probe-interval-override", defaults.ProbeIntervalOverrides, "Comma-separated or multi-flag list of RTT measurement intervals of nodes by name pattern or label, overridden nodes are skipped by the default RTT routine. RTT only, the probes keep the probe interval.\nFormat: PATTERN=INTERVAL or label:KEY=VALUE=INTERVAL; e.g. api-*=1s,label:tier=best-effort=1m")
+	cmd.Flags().StringSliceVar(&set.Peering, "peering", defaults.Peering, "Comma-separated or multi-flag list of peering rules of a partial mesh, just matching nodes are probed and gossiped with; a full mesh is used if not set.\nFormat: PATTERN, label:KEY=VALUE or same:KEY; e.g. same:region,label:role=gateway")
+	cmd.Flags().StringVar(&set.ReadinessPolicy, "readiness-policy", defaults.ReadinessPolicy, "Readiness policy of the API readiness endpoint: none (ready unless paused), count, fraction or weighted")
+	cmd.Flags().IntVar(&set.ReadinessMinPeers, "readiness-min-peers", defaults.ReadinessMinPeers, "Minimum count of OK peers to be ready, with the readiness policy count")
+	cmd.Flags().Float64Var(&set.ReadinessMinFraction, "readiness-min-fraction", defaults.ReadinessMinFraction, "Minimum fraction (0-1) of OK peers to be ready, with the readiness policy fraction")
+	cmd.Flags().Float64Var(&set.ReadinessMinScore, "readiness-min-score", defaults.ReadinessMinScore, "Minimum weighted score (0-1) of OK peers to be ready, with the readiness policy weighted")
+	cmd.Flags().StringSliceVar(&set.ReadinessWeights, "readiness-weight", defaults.ReadinessWeights, "Comma-separated or multi-flag list of peer weights of the readiness policy weighted, the first matching rule wins, other peers weigh 1.\nFormat: RULE=WEIGHT with a peering rule; e.g. label:role=seed=5,gw-*=2")
+	cmd.Flags().StringArrayVar(&set.ProbeSchedules, "probe-schedule", defaults.ProbeSchedules, "Multi-flag list of schedule windows by standard cron expressions of the RTT measurement (rtt), the throughput probes (throughput), a probe type or a probe (TYPE://TARGET), probes are skipped outside of their window.\nFormat: KEY=CRON; e.g. 'http=* 9-16 * * 1-5'")
+	cmd.Flags().DurationVar(&set.ProbeIntervalMin, "probe-interval-min", defaults.ProbeIntervalMin, "Min. interval of the RTT interval overrides (probe-interval-override) to prevent flooding")
+	cmd.Flags().IntSliceVar(&set.RttPayloadSizes, "rtt-payload-sizes", defaults.RttPayloadSizes, "Comma-separated or multi-flag list of payload sizes in bytes (max. 65536) of additional RTT measurements, saved as rtt_payload_<size> samples")
+	cmd.Flags().BoolVar(&set.RttPayloadEcho, "rtt-payload-echo", defaults.RttPayloadEcho, "Echo the RTT payload by the measured node to load both directions, otherwise the payload is discarded")
+	cmd.Flags().BoolVar(&set.OneWayDelay, "one-way-delay", defaults.OneWayDelay, "Measure the one-way delay of the pings in both directions, needs synchronized clocks (NTP/PTP)")
+	cmd.Flags().DurationVar(&set.OneWayDelayMaxSkew, "one-way-delay-max-skew", defaults.OneWayDelayMaxSkew, "Max. estimated clock skew to a node to measure the one-way delay, otherwise just the RTT is measured")
+	cmd.Flags().DurationVar(&set.ThroughputInterval, "throughput-interval", defaults.ThroughputInterval, "Interval of the throughput probes streaming data to a random node, e.g. 10m (default disabled)")
+	cmd.Flags().IntVar(&set.ThroughputVolume, "throughput-volume", defaults.ThroughputVolume, "Volume in bytes streamed to a node per throughput probe, 65536-67108864")
+	cmd.Flags().BoolVar(&set.RttEdgeLabels, "rtt-edge-labels", defaults.RttEdgeLabels, "Label the rtt metric by the measuring node (from) as well as the measured node (to) for a latency matrix of the mesh; adds a label per node to every rtt series (default disabled)")
+
+	cmd.Flags().Uint32Var(&set.MaxHops, "max-hops", defaults.MaxHops, "Max. forwards of a sample in the mesh, 0 is unlimited")
+	cmd.Flags().BoolVar(&set.DigestSync, "digest-sync", defaults.DigestSync, "Sync just the differing samples with the nodes by digests of the sample stores (anti-entropy) instead of pushing all samples (default disabled)")
+	cmd.Flags().Float64Var(&set.DigestFullSyncRatio, "digest-full-sync-ratio", defaults.DigestFullSyncRatio, "Ratio 0-1 of differing digest buckets above all samples are pushed")
+	cmd.Flags().IntVar(&pushFanout, "push-fanout", mesh.StandardProductionRoutineConfig().PushSampleToAmount, "Amount of random healthy nodes the samples are pushed to per push round, a smaller fanout trades convergence speed for bandwidth")
+	cmd.Flags().StringVar(&set.PauseStatePath, "pause-state-path", defaults.PauseStatePath, "File persisting the paused state of the outbound probes across restarts, the node starts paused if the file exists (default not persisted)")
+	cmd.Flags().DurationVar(&set.ClientIdleTimeout, "client-idle-timeout", defaults.ClientIdleTimeout, "Close the client connection of a node not used within the timeout, dialed again on the next use; has to be longer than the request timeout (default disabled)")
+	cmd.Flags().DurationVar(&set.JoinCoalesceWindow, "join-coalesce-window", defaults.JoinCoalesceWindow, "Window a seed node coalesces the discovery broadcasts of joining nodes in, e.g. 500ms; has to be shorter than the join settle timeout (default disabled)")
+	cmd.Flags().IntVar(&set.NameCollisionLimit, "name-collision-limit", defaults.NameCollisionLimit, "Consecutive joins rejected by a name collision until the name collision action, each collision is logged with the name")
+	cmd.Flags().StringVar(&set.NameCollisionAction, "name-collision-action", defaults.NameCollisionAction, "Reaction to repeated name collisions: exit non-zero or suffix the name randomly (NAME-xxxxxx) and join again")
+	cmd.Flags().Uint32Var(&set.DiscoveryMaxDepth, "discovery-max-depth", defaults.DiscoveryMaxDepth, "Max. depth a discovery of a new node propagates, 1 informs just the nodes of the broadcast of the joined node; 0 is unlimited")
+	cmd.Flags().StringVar(&set.SampleSpillPath, "sample-spill-path", defaults.SampleSpillPath, "Log file the oldest samples are spilled to if the samples in memory exceed the threshold, e.g. on edge nodes with little memory (default disabled)")
+	cmd.Flags().IntVar(&set.SampleSpillThreshold, "sample-spill-threshold", defaults.SampleSpillThreshold, "Max. samples in memory before the oldest samples are spilled to the sample spill path")
+	cmd.Flags().IntVar(&set.EventLogSize, "event-log-size", defaults.EventLogSize, "Amount of mesh events (join, leave, state-change, eviction) kept in memory for /api/v1/events, 0 disables the event log")
+	cmd.Flags().StringSliceVar(&set.AcceptSamples, "accept-samples", defaults.AcceptSamples, "Comma-separated or multi-flag list of sample type names pushed to this node, e.g. rtt_total,health_score; unknown types of newer nodes are accepted (default all)")
+
+	cmd.Flags().IntVar(&set.MaxConcurrentProbes, "max-concurrent-probes", defaults.MaxConcurrentProbes, "Max. concurrent outbound probes (ping, rtt, push samples), further probes will queue (default 16 per GOMAXPROCS)")
+	cmd.Flags().StringToIntVar(&set.ProbePools, "probe-pool", defaults.ProbePools, "Sizes of separate probe pools per routine: ping, rtt, discovery, push; e.g. rtt=8,push=4 (default shared max. concurrent probes)")
+
+	// Health score
+	cmd.Flags().Float64Var(&set.HealthWeights.Success, "health-weight-success", defaults.HealthWeights.Success, "Weight of the RTT success ratio in the node health score")
+	cmd.Flags().Float64Var(&set.HealthWeights.Rtt, "health-weight-rtt", defaults.HealthWeights.Rtt, "Weight of the RTT relative to the baseline in the node health score")
+	cmd.Flags().Float64Var(&set.HealthWeights.Jitter, "health-weight-jitter", defaults.HealthWeights.Jitter, "Weight of the RTT jitter relative to the baseline in the node health score")
+	cmd.Flags().DurationVar(&set.HealthRttBaseline, "health-rtt-baseline", defaults.HealthRttBaseline, "RTT baseline of the node health score, a RTT at or below the baseline scores best")
+
+	// RTT anomalies
+	cmd.Flags().Float64Var(&set.RttAnomalyThreshold, "rtt-anomaly-threshold", defaults.RttAnomalyThreshold, "Standard deviations a RTT has to deviate from the rolling baseline of the node to be an anomaly, e.g. 3 (default disabled)")
+	cmd.Flags().Float64Var(&set.RttAnomalyAlpha, "rtt-anomaly-alpha", defaults.RttAnomalyAlpha, "Weight (0-1] of a new RTT in the rolling baseline, higher values adapt faster to changed links")
+	cmd.Flags().IntVar(&set.RttAnomalyWarmup, "rtt-anomaly-warmup", defaults.RttAnomalyWarmup, "RTT measurements of a node building the baseline before anomalies are flagged")
+	cmd.Flags().DurationVar(&set.RttAnomalyMinDeviation, "rtt-anomaly-min-deviation", defaults.RttAnomalyMinDeviation, "Min. deviation of a RTT from the baseline to be an anomaly, so the jitter of very stable links is not flagged")
+
+	// QoS
+	cmd.Flags().BoolVar(&set.TcpNoDelay, "tcp-nodelay", defaults.TcpNoDelay, "TCP_NODELAY of the outbound connections & probes, if disabled Nagle's algorithm delays small writes and adds to the measured RTT")
+	cmd.Flags().IntVar(&set.SocketSendBuffer, "socket-send-buffer", defaults.SocketSendBuffer, "Send buffer size in bytes (SO_SNDBUF) of the outbound connections & probes, ignored if not supported on the platform (default OS default)")
+	cmd.Flags().IntVar(&set.SocketRecvBuffer, "socket-recv-buffer", defaults.SocketRecvBuffer, "Receive buffer size in bytes (SO_RCVBUF) of the outbound connections & probes, ignored if not supported on the platform (default OS default)")
+	cmd.Flags().StringVar(&set.LocalAddress, "local-address", defaults.LocalAddress, "Local IP or interface all outbound connections and probes originate from, e.g. on multi-homed hosts (default any)")
+	cmd.Flags().DurationVar(&set.DnsCacheTTL, "dns-cache-ttl", defaults.DnsCacheTTL, "TTL of the in-process DNS cache of the mesh connections, not the probes, 0 disables the cache (default disabled)")
+	cmd.Flags().DurationVar(&set.DnsCacheGrace, "dns-cache-grace", defaults.DnsCacheGrace, "Period after the DNS cache TTL the last good answer is used if the resolution fails")
+	cmd.Flags().StringToIntVar(&set.Dscp, "dscp", defaults.Dscp, "DSCP values (0-63) to mark connections per traffic type: mesh, rtt, http, tcp, dns, icmp; e.g. rtt=46,tcp=10 (default unmarked)")
+
+	// Self-test
+	selftestCmd.Flags().DurationVar(&selftestTimeout, "timeout", time.Second*30, "Timeout of the self-test")
+	selftestCmd.Flags().BoolVar(&set.Debug, "debug", defaults.Debug, "Set logging to debug mode")
+	cmd.AddCommand(selftestCmd)
+
+	// Reconcile
+	reconcileCmd.Flags().StringVar(&reconcileToken, "token", "", "API token of the nodes")
+	reconcileCmd.Flags().DurationVar(&reconcileTimeout, "timeout", time.Second*30, "Timeout of the reconciliation")
+	reconcileCmd.Flags().StringSliceVar(&set.CaCertPath, "ca-cert-path", defaults.CaCertPath, "Path to ca cert file/s or directories of *.pem & *.crt files to enable TLS")
+	reconcileCmd.Flags().BytesBase64Var(&set.CaCert, "ca-cert", defaults.CaCert, "Base64 encoded ca cert to enable TLS")
+	reconcileCmd.Flags().BoolVar(&set.CaCertSystem, "ca-cert-system", defaults.CaCertSystem, "Append the system cert pool to the ca certs")
+	reconcileCmd.Flags().StringVar(&set.ServerNameOverride, "server-name-override", defaults.ServerNameOverride, "Override the server name (SNI) of the connections")
+	reconcileCmd.Flags().BoolVar(&set.RequireTLS, "require-tls", defaults.RequireTLS, "Require TLS, fail instead of falling back to insecure connections")
+	reconcileCmd.Flags().StringVar(&set.MeshToken, "mesh-token", defaults.MeshToken, "Mesh token of the nodes, if the mesh RPCs are authenticated")
+	reconcileCmd.Flags().StringVar(&set.MeshTokenPath, "mesh-token-path", defaults.MeshTokenPath, "Path of a file with the mesh token, the first one is sent")
+	cmd.AddCommand(reconcileCmd)
+
+	// Resync
+	resyncCmd.Flags().StringVar(&resyncToken, "token", "", "API token of the nodes")
+	resyncCmd.Flags().DurationVar(&resyncTimeout, "timeout", time.Second*30, "Timeout of the resync")
+	resyncCmd.Flags().StringSliceVar(&set.CaCertPath, "ca-cert-path", defaults.CaCertPath, "Path to ca cert file/s or directories of *.pem & *.crt files to enable TLS")
+	resyncCmd.Flags().BytesBase64Var(&set.CaCert, "ca-cert", defaults.CaCert, "Base64 encoded ca cert to enable TLS")
+	resyncCmd.Flags().BoolVar(&set.CaCertSystem, "ca-cert-system", defaults.CaCertSystem, "Append the system cert pool to the ca certs")
+	resyncCmd.Flags().StringVar(&set.ServerNameOverride, "server-name-override", defaults.ServerNameOverride, "Override the server name (SNI) of the connections")
+	resyncCmd.Flags().BoolVar(&set.RequireTLS, "require-tls", defaults.RequireTLS, "Require TLS, fail instead of falling back to insecure connections")
+	resyncCmd.Flags().StringVar(&set.MeshToken, "mesh-token", defaults.MeshToken, "Mesh token of the node, if the mesh RPCs are authenticated")
+	resyncCmd.Flags().StringVar(&set.MeshTokenPath, "mesh-token-path", defaults.MeshTokenPath, "Path of a file with the mesh token, the first one is sent")
+	cmd.AddCommand(resyncCmd)
+
+	// Pause & resume
+	for _, c := range []*cobra.Command{pauseCmd, resumeCmd} {
+		c.Flags().StringVar(&pauseToken, "token", "", "API token of the node")
+		c.Flags().DurationVar(&pauseTimeout, "timeout", time.Second*10, "Timeout of the request")
+		c.Flags().StringSliceVar(&set.CaCertPath, "ca-cert-path", defaults.CaCertPath, "Path to ca cert file/s or directories of *.pem & *.crt files to enable TLS")
+		c.Flags().BytesBase64Var(&set.CaCert, "ca-cert", defaults.CaCert, "Base64 encoded ca cert to enable TLS")
+		c.Flags().BoolVar(&set.CaCertSystem, "ca-cert-system", defaults.CaCertSystem, "Append the system cert pool to the ca certs")
+		c.Flags().StringVar(&set.ServerNameOverride, "server-name-override", defaults.ServerNameOverride, "Override the server name (SNI) of the connections")
+		c.Flags().BoolVar(&set.RequireTLS, "require-tls", defaults.RequireTLS, "Require TLS, fail instead of falling back to insecure connections")
+		c.Flags().StringVar(&set.MeshToken, "mesh-token", defaults.MeshToken, "Mesh token of the node, if the mesh RPCs are authenticated")
+		c.Flags().StringVar(&set.MeshTokenPath, "mesh-token-path", defaults.MeshTokenPath, "Path of a file with the mesh token, the first one is sent")
+		cmd.AddCommand(c)
+	}
+
+	// Config file
+	cmd.PersistentFlags().StringVar(&configPath, configFlag, "", "Path of a YAML or JSON config file with the flag names as keys, flags & env variables take precedence; older config versions are migrated")
+
 	// Logging mode
 	cmd.Flags().BoolVar(&set.Debug, "debug", defaults.Debug, "Set logging to debug mode")
 	cmd.Flags().BoolVar(&set.DebugGrpc, "debug-grpc", defaults.DebugGrpc, "Enable more logging for grpc")
+	cmd.Flags().BoolVar(&set.GrpcReflection, "grpc-reflection", defaults.GrpcReflection, "Enable gRPC server reflection of the mesh server, e.g. for grpcurl (default disabled)")
 }
 
 // Before the run function gets executed
-// all env variables need to be loaded (bindEnvToFlags).
+// all env variables need to be loaded (bindEnvToFlags),
+// followed by the config file for the flags still not set.
 // Viper gets initialised.
 func initSettings(cmd *cobra.Command, args []string) {
 	v := viper.New()
@@ -154,6 +547,12 @@ func initSettings(cmd *cobra.Command, args []string) {
 	v.AutomaticEnv()
 	// Bind the current command's flags to viper
 	bindEnvToFlags(cmd, v)
+
+	if configPath != "" {
+		if err := applyConfigFile(configPath, cmd.Flags()); err != nil {
+			log.Fatalf("Could not load config file: %v", err)
+		}
+	}
 }
 
 // Environment variables will be bind to cmd flags,
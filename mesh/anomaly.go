@@ -0,0 +1,143 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sub-fields of a RTT sample after the warmup of the baseline:
+// the anomaly flag (0, 1) and the baseline RTT in nanoseconds
+const (
+	RTT_FIELD_ANOMALY  = "anomaly"
+	RTT_FIELD_BASELINE = "baseline"
+)
+
+// Rolling baseline of the RTT of a node: the exponentially
+// weighted moving average & variance of the measurements
+type rttBaseline struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// Rolling RTT baselines per node name. A measurement deviating from the
+// baseline by more than threshold standard deviations and by at least the
+// min. deviation is an anomaly, so jitter of a very stable link is not flagged.
+// The first warmup measurements of a node build the baseline and are not flagged.
+type anomalyTracker struct {
+	mu           sync.Mutex
+	alpha        float64
+	threshold    float64
+	warmup       int
+	minDeviation time.Duration
+	baselines    map[string]*rttBaseline
+}
+
+func newAnomalyTracker(alpha float64, threshold float64, warmup int, minDeviation time.Duration) *anomalyTracker {
+	return &anomalyTracker{alpha: alpha, threshold: threshold, warmup: warmup, minDeviation: minDeviation, baselines: map[string]*rttBaseline{}}
+}
+
+// Validate the anomaly settings, a threshold of 0 disables the anomaly detection
+func validateRttAnomaly(alpha float64, threshold float64, warmup int, minDeviation time.Duration) error {
+	if threshold < 0 {
+		return errors.New("RTT anomaly threshold can not be negative")
+	}
+	if minDeviation < 0 {
+		return errors.New("RTT anomaly min. deviation can not be negative")
+	}
+	if alpha <= 0 || alpha > 1 {
+		return errors.New("RTT anomaly alpha has to be greater than 0 and at most 1")
+	}
+	if warmup < 1 {
+		return errors.New("RTT anomaly warmup has to be at least 1 measurement")
+	}
+	return nil
+}
+
+// Add a measurement of a node to its baseline. The baseline before the
+// measurement is returned, ok is false during the warmup of the baseline.
+func (t *anomalyTracker) add(node string, rtt time.Duration) (mean float64, anomaly bool, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, found := t.baselines[node]
+	if !found {
+		b = &rttBaseline{}
+		t.baselines[node] = b
+	}
+	x := float64(rtt)
+	if b.samples >= t.warmup {
+		mean, ok = b.mean, true
+		deviation := math.Abs(x - b.mean)
+		anomaly = deviation > t.threshold*math.Sqrt(b.variance) && deviation >= float64(t.minDeviation)
+	}
+
+	// the first measurement starts the baseline
+	if b.samples == 0 {
+		b.mean = x
+	} else {
+		diff := x - b.mean
+		incr := t.alpha * diff
+		b.mean += incr
+		b.variance = (1 - t.alpha) * (b.variance + diff*incr)
+	}
+	b.samples++
+	return mean, anomaly, ok
+}
+
+// Forget the baseline of a node, e.g. after it left the mesh
+func (t *anomalyTracker) delete(node string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.baselines, node)
+}
+
+// Check the RTT of a node against its baseline. The anomaly flag & the
+// baseline are returned as sub-fields of the RTT sample, nil if the anomaly
+// detection is disabled or the baseline is in warmup. Anomalies are counted & logged.
+func (m *Mesh) observeRttAnomaly(node string, rtt time.Duration) map[string]string {
+	if m.anomalies == nil {
+		return nil
+	}
+	mean, anomaly, ok := m.anomalies.add(node, rtt)
+	if !ok {
+		return nil
+	}
+	flag := "0"
+	if anomaly {
+		flag = "1"
+		m.metrics.GetRttAnomalies().WithLabelValues(node).Inc()
+		m.logger.Named("rtt-anomaly").Infow("RTT deviates from the baseline", "node", node, "rtt", rtt.String(), "baseline", time.Duration(mean).String())
+	}
+	return map[string]string{
+		RTT_FIELD_ANOMALY:  flag,
+		RTT_FIELD_BASELINE: strconv.FormatInt(int64(mean), 10),
+	}
+}
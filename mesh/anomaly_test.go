@@ -0,0 +1,129 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+)
+
+func Test_validateRttAnomaly(t *testing.T) {
+	tests := []struct {
+		name         string
+		alpha        float64
+		threshold    float64
+		warmup       int
+		minDeviation time.Duration
+		wantErr      bool
+	}{
+		{name: "valid", alpha: 0.1, threshold: 3, warmup: 20},
+		{name: "alpha of 1", alpha: 1, threshold: 3, warmup: 1},
+		{name: "negative threshold", alpha: 0.1, threshold: -1, warmup: 20, wantErr: true},
+		{name: "alpha of 0", alpha: 0, threshold: 3, warmup: 20, wantErr: true},
+		{name: "alpha above 1", alpha: 1.5, threshold: 3, warmup: 20, wantErr: true},
+		{name: "no warmup", alpha: 0.1, threshold: 3, warmup: 0, wantErr: true},
+		{name: "negative min. deviation", alpha: 0.1, threshold: 3, warmup: 20, minDeviation: -time.Millisecond, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRttAnomaly(tt.alpha, tt.threshold, tt.warmup, tt.minDeviation); (err != nil) != tt.wantErr {
+				t.Errorf("validateRttAnomaly() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_anomalyTracker(t *testing.T) {
+	tracker := newAnomalyTracker(0.1, 3, 4, 0)
+
+	// the warmup builds the baseline without flags
+	for _, rtt := range []time.Duration{10, 12, 8, 10} {
+		if _, anomaly, ok := tracker.add("node", rtt*time.Millisecond); ok || anomaly {
+			t.Fatalf("Expected no flag during the warmup, got anomaly %v, ok %v", anomaly, ok)
+		}
+	}
+
+	// a RTT within the deviation of the baseline
+	mean, anomaly, ok := tracker.add("node", 11*time.Millisecond)
+	if !ok || anomaly {
+		t.Errorf("Expected a RTT within the baseline, got anomaly %v, ok %v", anomaly, ok)
+	}
+	if mean < float64(8*time.Millisecond) || mean > float64(12*time.Millisecond) {
+		t.Errorf("Expected a baseline of about 10ms, got %v", time.Duration(mean))
+	}
+
+	// a RTT far from the baseline
+	if _, anomaly, _ := tracker.add("node", 100*time.Millisecond); !anomaly {
+		t.Error("Expected an anomaly of a RTT far from the baseline")
+	}
+
+	// the baselines are per node
+	if _, _, ok := tracker.add("other", 100*time.Millisecond); ok {
+		t.Error("Expected the warmup of a new node")
+	}
+}
+
+func Test_anomalyTrackerMinDeviation(t *testing.T) {
+	tracker := newAnomalyTracker(0.1, 3, 4, time.Millisecond)
+
+	// a very stable link
+	for i := 0; i < 4; i++ {
+		tracker.add("node", 10*time.Millisecond)
+	}
+	if _, anomaly, _ := tracker.add("node", 10*time.Millisecond+100*time.Microsecond); anomaly {
+		t.Error("Expected no anomaly of a deviation below the min. deviation")
+	}
+	if _, anomaly, _ := tracker.add("node", 20*time.Millisecond); !anomaly {
+		t.Error("Expected an anomaly of a deviation above the min. deviation")
+	}
+}
+
+func Test_observeRttAnomaly(t *testing.T) {
+	m := testMesh(time.Second)
+	if fields := m.observeRttAnomaly("node", time.Millisecond); fields != nil {
+		t.Errorf("Expected no fields if disabled, got %v", fields)
+	}
+
+	m.anomalies = newAnomalyTracker(0.5, 2, 2, 0)
+	m.observeRttAnomaly("node", 10*time.Millisecond)
+	if fields := m.observeRttAnomaly("node", 11*time.Millisecond); fields != nil {
+		t.Errorf("Expected no fields during the warmup, got %v", fields)
+	}
+	fields := m.observeRttAnomaly("node", time.Second)
+	if fields[RTT_FIELD_ANOMALY] != "1" || fields[RTT_FIELD_BASELINE] == "" {
+		t.Errorf("Expected a flagged RTT with the baseline, got %v", fields)
+	}
+	if count := gatherValue(t, m.metrics, "rtt_anomaly_total"); count != 1 {
+		t.Errorf("Expected 1 counted anomaly, got %v", count)
+	}
+
+	// the baseline & the series are deleted after the node left
+	m.forgetNode(&meshv1.Node{Name: "node"})
+	if fields := m.observeRttAnomaly("node", 10*time.Millisecond); fields != nil {
+		t.Errorf("Expected the warmup of a new baseline, got %v", fields)
+	}
+	if series := gatherValues(t, m.metrics, "rtt_anomaly_total"); len(series) != 0 {
+		t.Errorf("Expected no anomaly series after the node left, got %v", series)
+	}
+}
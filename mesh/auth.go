@@ -0,0 +1,204 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	h "github.com/telekom/canary-bot/helper"
+	"github.com/telekom/canary-bot/metric"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata key of the mesh token, apart from the authorization
+// of the API tokens sent to the admin RPCs, e.g. resync & pause
+const authMetadataKey = "x-mesh-token"
+
+// Min. interval the token file is checked for changes
+const meshTokenCheckInterval = time.Second
+
+// Methods reachable without a mesh token, e.g. for gRPC health probes
+const healthMethodPrefix = "/grpc.health.v1.Health/"
+
+// Shared secret authenticating the nodes of the mesh.
+// A token loaded from a file is reloaded if the file changes, checked at
+// most every meshTokenCheckInterval. One token per line is accepted and
+// the first one is sent.
+type meshToken struct {
+	path string
+	log  *zap.SugaredLogger
+
+	mu     sync.Mutex
+	tokens []string
+	watch  *h.FileWatch
+}
+
+// Create the mesh token, nil if neither a token nor a token file is set.
+// The token file has to be loadable initially.
+func newMeshToken(token string, path string, log *zap.SugaredLogger) (*meshToken, error) {
+	if path == "" {
+		if token == "" {
+			return nil, nil
+		}
+		return &meshToken{tokens: []string{token}, log: log}, nil
+	}
+	t := &meshToken{path: path, log: log, watch: h.NewFileWatch(meshTokenCheckInterval, path)}
+	if _, err := t.load(time.Now()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Get the accepted tokens, the file is reloaded if its modification time changed.
+// The loaded tokens are kept if the file can not be loaded.
+func (t *meshToken) load(now time.Time) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.path == "" || !t.watch.Changed(now) {
+		return t.tokens, nil
+	}
+	tokens, err := readTokens(t.path)
+	if err != nil {
+		if t.tokens == nil {
+			return nil, err
+		}
+		t.log.Warnw("Could not reload mesh token, keeping the loaded one", "path", t.path, "error", err)
+		return t.tokens, nil
+	}
+	if t.tokens != nil {
+		t.log.Infow("Reloaded mesh token", "path", t.path)
+	}
+	t.tokens = tokens
+	return t.tokens, nil
+}
+
+// Read the tokens of a file, one per line
+func readTokens(path string) ([]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		if token := strings.TrimSpace(line); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("no mesh token in file " + path)
+	}
+	return tokens, nil
+}
+
+// Check a token against the accepted tokens in constant time
+func (t *meshToken) valid(token string) bool {
+	tokens, err := t.load(time.Now())
+	if err != nil {
+		return false
+	}
+	ok := 0
+	for _, accepted := range tokens {
+		ok |= subtle.ConstantTimeCompare([]byte(token), []byte(accepted))
+	}
+	return ok == 1
+}
+
+// Error of an RPC on a connection downgraded by the TLS fallback, the token is not sent
+var errDowngradedToken = errors.New("mesh token is not sent on a connection downgraded by the TLS fallback")
+
+// GetRequestMetadata attaches the first token to the RPCs of a client.
+// The token is never sent on a connection downgraded by the TLS fallback.
+func (t *meshToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if downgraded(ctx) {
+		return nil, errDowngradedToken
+	}
+	tokens, err := t.load(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{authMetadataKey: tokens[0]}, nil
+}
+
+// RequireTransportSecurity is false, the token is meant for meshes without mTLS
+func (t *meshToken) RequireTransportSecurity() bool {
+	return false
+}
+
+// Verify the mesh token of an incoming RPC, any of the sent tokens has to be valid.
+// The reason is empty if the RPC is authenticated.
+func (m *Mesh) authenticate(ctx context.Context, method string) (string, error) {
+	if strings.HasPrefix(method, healthMethodPrefix) {
+		return "", nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(authMetadataKey)
+	if len(values) == 0 {
+		return metric.AUTH_MISSING, status.Error(codes.Unauthenticated, "missing mesh token")
+	}
+	for _, value := range values {
+		if m.meshToken.valid(value) {
+			return "", nil
+		}
+	}
+	return metric.AUTH_INVALID, status.Error(codes.Unauthenticated, "invalid mesh token")
+}
+
+// Server interceptor rejecting unary RPCs without a valid mesh token
+func (m *Mesh) authUnaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if reason, err := m.authenticate(ctx, info.FullMethod); err != nil {
+		m.metrics.GetUnauthenticatedRequests().WithLabelValues(reason).Inc()
+		m.logger.Named("server").Debugw("Unauthenticated RPC", "method", info.FullMethod, "reason", reason)
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// Server interceptor rejecting streams without a valid mesh token
+func (m *Mesh) authStreamInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if reason, err := m.authenticate(ss.Context(), info.FullMethod); err != nil {
+		m.metrics.GetUnauthenticatedRequests().WithLabelValues(reason).Inc()
+		m.logger.Named("server").Debugw("Unauthenticated stream", "method", info.FullMethod, "reason", reason)
+		return err
+	}
+	return handler(srv, ss)
+}
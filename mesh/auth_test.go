@@ -0,0 +1,149 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_meshTokenReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	token, err := newMeshToken("", path, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.valid("old") || token.valid("new") {
+		t.Error("Expected just the old token to be valid")
+	}
+
+	// rotate the token, both are accepted and the new one is sent
+	if err := os.WriteFile(path, []byte("new\nold\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	// the file is checked once per check interval
+	if !token.valid("old") || token.valid("new") {
+		t.Error("Expected the file to be checked after the check interval")
+	}
+	now := time.Now()
+	token.load(now.Add(meshTokenCheckInterval))
+	if !token.valid("old") || !token.valid("new") {
+		t.Error("Expected both tokens to be valid")
+	}
+	md, err := token.GetRequestMetadata(context.Background())
+	if err != nil || md[authMetadataKey] != "new" {
+		t.Errorf("Expected the new token to be sent, got %v, error %v", md, err)
+	}
+
+	// an empty file keeps the loaded tokens
+	if err := os.WriteFile(path, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	future = future.Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	token.load(now.Add(2 * meshTokenCheckInterval))
+	if !token.valid("new") {
+		t.Error("Expected the loaded tokens to be kept")
+	}
+
+	if _, err := newMeshToken("", filepath.Join(t.TempDir(), "missing"), zap.NewNop().Sugar()); err == nil {
+		t.Error("Expected an error for a missing token file")
+	}
+	if token, _ := newMeshToken("", "", zap.NewNop().Sugar()); token != nil {
+		t.Error("Expected no token if neither a token nor a file is set")
+	}
+}
+
+func Test_authInterceptor(t *testing.T) {
+	m := testMesh(time.Second)
+	m.meshToken, _ = newMeshToken("secret", "", zap.NewNop().Sugar())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(m.authUnaryInterceptor))
+	meshv1.RegisterMeshServiceServer(server, &MeshServer{})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	rtt := func(token *meshToken, md ...string) error {
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+		if token != nil {
+			opts = append(opts, grpc.WithPerRPCCredentials(token))
+		}
+		conn, err := grpc.Dial(lis.Addr().String(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		ctx := metadata.AppendToOutgoingContext(context.Background(), md...)
+		_, err = meshv1.NewMeshServiceClient(conn).Rtt(ctx, &meshv1.RttRequest{})
+		return err
+	}
+
+	if err := rtt(m.meshToken); err != nil {
+		t.Errorf("Expected an authenticated RPC, got %v", err)
+	}
+	// the API token of the admin RPCs is sent apart from the mesh token
+	if err := rtt(m.meshToken, "authorization", "Bearer api-token"); err != nil {
+		t.Errorf("Expected an authenticated RPC with an API token, got %v", err)
+	}
+	if err := rtt(nil, authMetadataKey, "wrong", authMetadataKey, "secret"); err != nil {
+		t.Errorf("Expected an authenticated RPC by any of the tokens, got %v", err)
+	}
+	if err := rtt(nil, "authorization", "Bearer secret"); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected unauthenticated with the token as authorization, got %v", err)
+	}
+	wrong, _ := newMeshToken("wrong", "", zap.NewNop().Sugar())
+	if err := rtt(wrong); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected unauthenticated with a wrong token, got %v", err)
+	}
+
+	for _, reason := range []string{metric.AUTH_MISSING, metric.AUTH_INVALID} {
+		if value := gatherValue(t, m.metrics, "unauthenticated_requests_total", "reason", reason); value != 1 {
+			t.Errorf("Expected 1 %v request, got %v", reason, value)
+		}
+	}
+}
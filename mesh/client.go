@@ -23,23 +23,34 @@ package mesh
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/telekom/canary-bot/api"
 	"github.com/telekom/canary-bot/data"
 	h "github.com/telekom/canary-bot/helper"
+	"github.com/telekom/canary-bot/metric"
 	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
 
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/grpc/metadata"
 )
 
 // MeshClient is the client for the mesh service
 type MeshClient struct {
 	conn   *grpc.ClientConn
 	client meshv1.MeshServiceClient
+	// Node of the client and last use by initClient, guarded by mu
+	node     *meshv1.Node
+	lastUsed time.Time
 }
 
 // Join is used by the node to join the mesh network
@@ -47,99 +58,215 @@ func (m *Mesh) Join(targets []string) (bool, bool) {
 	log := m.logger.Named("join-routine")
 	var res *meshv1.JoinMeshResponse
 	log.Debugw("Starting")
+	trace := api.JoinTrace{Start: time.Now()}
+	defer func() {
+		trace.Duration = time.Since(trace.Start).String()
+		m.addJoinTrace(trace)
+	}()
+
+	// time-to-join is measured from the first attempt
+	m.metrics.GetJoinAttempts().Inc()
+	if m.joinStart.IsZero() {
+		m.joinStart = time.Now()
+	}
+	if len(targets) == 0 {
+		log.Debugw("No targets to join")
+		trace.Reason = "no join targets"
+		m.metrics.GetJoinOutcomes().WithLabelValues(metric.JOIN_FAILURE, "").Inc()
+		return false, true
+	}
+
+	// the join attempt to all targets is bound by the join settle timeout, if enabled
+	ctx, cancel := context.WithCancel(context.Background())
+	if m.routineConfig.JoinSettleTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, m.routineConfig.JoinSettleTimeout)
+	}
+	defer cancel()
 
 	// try to connect to one node in targets
 	for index, target := range targets {
+		if ctx.Err() != nil {
+			log.Infow("Join settle timeout reached - stop trying targets", "timeout", m.routineConfig.JoinSettleTimeout.String())
+			trace.Reason = "join settle timeout " + m.routineConfig.JoinSettleTimeout.String() + " reached"
+			skipJoinTargets(&trace, targets[index:], trace.Reason)
+			m.metrics.GetJoinOutcomes().WithLabelValues(metric.JOIN_FAILURE, "").Inc()
+			return false, true
+		}
 		log.Debugf("Index %+v Targets: %+v", index, targets)
 		node := &meshv1.Node{Name: "", Target: target}
 
-		err := m.initClient(node)
+		var err error
+		start := time.Now()
+		res, err = m.joinTarget(ctx, node)
+		traced := api.JoinTraceTarget{Target: target, Duration: time.Since(start).String()}
 		if err != nil {
-			m.logger.Debug("Could not connect to client, joinMesh request failed")
-			if index != len(targets)-1 {
-				log.Debugw("Trying next node", "error", err)
-				continue
+			traced.Outcome, traced.Reason = api.JOIN_TRACE_FAILED, err.Error()
+			trace.Targets = append(trace.Targets, traced)
+			if errors.Is(err, ErrDial) {
+				log.Debug("Could not connect to client, joinMesh request failed")
+			} else {
+				log.Debug("Client connected, but joinMesh request failed")
 			}
-			return false, true
-		}
-
-		// send join mesh request
-		res, err = m.clients[GetId(node)].client.JoinMesh(
-			context.Background(),
-			&meshv1.Node{
-				Name:   m.setupConfig.Name,
-				Target: m.setupConfig.JoinAddress,
-			})
-
-		if err != nil {
-			m.logger.Debug("Client connected, but joinMesh request failed")
 			if index != len(targets)-1 {
 				log.Debugw("Trying next node", "error", err)
 				continue
 			}
+			m.metrics.GetJoinOutcomes().WithLabelValues(metric.JOIN_FAILURE, "").Inc()
 			return false, true
 		}
 
 		// check if name of node is unique in mesh response
 		if !res.NameUnique {
-			log.Debugw("Node name is not unique in mesh")
+			log.Warnw("Node name is not unique in mesh", "name", m.name.get(), "target", target, "seed", res.MyName)
+			traced.Outcome, traced.Reason = api.JOIN_TRACE_COLLISION, "name "+m.name.get()+" is not unique in mesh"
+			trace.Targets = append(trace.Targets, traced)
+			m.metrics.GetJoinOutcomes().WithLabelValues(metric.JOIN_NAME_COLLISION, target).Inc()
 			return true, false
 		}
 
 		// save join-requested node as node in mesh
 		node.Name = res.MyName
+		node.Labels = res.MyLabels
+		node.SampleFilter = res.MySampleFilter
 		m.database.SetNode(data.Convert(node, NODE_OK))
 
 		log.Infow("Joined mesh", "name", node.Name, "target", node.Target)
+		m.recordEvent(data.EVENT_JOIN, node.Name, "joined the mesh by "+node.Target)
+		m.metrics.GetJoinOutcomes().WithLabelValues(metric.JOIN_SUCCESS, target).Inc()
+		m.metrics.GetJoinDuration().Observe(time.Since(m.joinStart).Seconds())
+		m.joinStart = time.Time{}
+		traced.Outcome = api.JOIN_TRACE_JOINED
+		trace.Targets = append(trace.Targets, traced)
+		trace.Joined = target
+		skipJoinTargets(&trace, targets[index+1:], "joined by "+target)
 		break
 	}
+	var nodes []*data.Node
 	for _, node := range res.Nodes {
+		// skip this node and nodes that left the mesh recently
 		if GetId(node) != GetId(&meshv1.Node{
-			Name:   m.setupConfig.Name,
-			Target: m.setupConfig.JoinAddress,
-		}) {
-			m.database.SetNode(data.Convert(node, NODE_OK))
+			Name:   m.name.get(),
+			Target: m.setupConfig.advertiseTarget(),
+		}) && !m.database.IsTombstoned(GetId(node), m.routineConfig.TombstoneTTL) {
+			nodes = append(nodes, data.Convert(node, m.discoveredState(node)))
 		}
 	}
+	m.database.SetNodes(nodes)
 	return true, true
 }
 
+// Send a join mesh request to a node
+func (m *Mesh) joinTarget(ctx context.Context, node *meshv1.Node) (*meshv1.JoinMeshResponse, error) {
+	c, err := m.initClient(node)
+	if err != nil {
+		return nil, fmt.Errorf("join %v: %w", node.Target, classifyError(err, ErrJoinFailed))
+	}
+
+	res, err := c.client.JoinMesh(
+		ctx,
+		&meshv1.Node{
+			Name:         m.name.get(),
+			Target:       m.setupConfig.advertiseTarget(),
+			Labels:       m.setupConfig.Labels,
+			SampleFilter: m.setupConfig.sampleFilter().Convert(),
+			Info:         m.info(),
+		})
+	if err != nil {
+		return nil, fmt.Errorf("join %v: %w", node.Target, classifyError(err, ErrJoinFailed))
+	}
+	// check the protocol version of the seed node
+	if err := m.setPeerInfo(node, res.MyInfo); err != nil {
+		return nil, fmt.Errorf("join %v: %w", node.Target, classifyError(err, ErrJoinFailed))
+	}
+	return res, nil
+}
+
 func (m *Mesh) ping(node *meshv1.Node) error {
+	return m.pingContext(context.Background(), node)
+}
+
+// Ping a node, the request is bound by the deadline of the given context
+func (m *Mesh) pingContext(ctx context.Context, node *meshv1.Node) error {
 	log := m.logger.Named("ping-routine")
-	err := m.initClient(node)
+	m.acquireProbe(PROBE_POOL_PING)
+	defer m.releaseProbe(PROBE_POOL_PING)
+	c, err := m.initClient(node)
 	if err != nil {
 		log.Debugw("Could not connect to client")
-		return err
+		return fmt.Errorf("ping %v: %w", node.Target, err)
 	}
-	_, err = m.clients[GetId(node)].client.Ping(
-		context.Background(),
+	start := time.Now()
+	ctx, oneWayDelay := m.pingSendContext(ctx, node, start)
+	res, err := c.client.Ping(
+		ctx,
 		&meshv1.Node{
-			Name:   m.setupConfig.Name,
-			Target: m.setupConfig.JoinAddress,
+			Name:         m.name.get(),
+			Target:       m.setupConfig.advertiseTarget(),
+			Labels:       m.setupConfig.Labels,
+			SampleFilter: m.setupConfig.sampleFilter().Convert(),
 		})
+	end := time.Now()
 	if err != nil {
 		log.Debugw("Ping failed")
-		return err
+		return fmt.Errorf("ping %v: %w", node.Target, classifyError(err))
+	}
+	m.database.SetNodeLastSeen(GetId(node))
+	m.observeClockSkew(node, start, end, res.Time)
+	if oneWayDelay {
+		m.observeResponseDelay(node, end, res.Time)
 	}
 
 	return nil
 }
 
-func (m *Mesh) NodeDiscovery(toNode *meshv1.Node, newNode *meshv1.Node) {
+// Ask a node to ping the suspect node on behalf of this node.
+// An error is returned if the node could not be asked,
+// the bool is true if the suspect node responded to the node.
+func (m *Mesh) pingIndirect(helper *meshv1.Node, suspect *meshv1.Node) (bool, error) {
+	c, err := m.initClient(helper)
+	if err != nil {
+		return false, fmt.Errorf("indirect ping %v: %w", helper.Target, err)
+	}
+	res, err := c.client.PingIndirect(
+		context.Background(),
+		&meshv1.PingIndirectRequest{Target: suspect},
+	)
+	if err != nil {
+		return false, fmt.Errorf("indirect ping %v: %w", helper.Target, classifyError(err))
+	}
+	return res.Reachable, nil
+}
+
+// Inform a node about a new node in the mesh and the last contact with it.
+// The request is bound by the deadline of the given context.
+// The discovery is sent as broadcast of this node with the depth 1.
+func (m *Mesh) NodeDiscovery(ctx context.Context, toNode *meshv1.Node, newNode *meshv1.Node, lastSeen int64) {
+	m.NodeDiscoveryDepth(ctx, toNode, newNode, lastSeen, 1)
+}
+
+// Inform a node about a new node in the mesh with the depth of the discovery,
+// 1 for the broadcast of the node the new node joined
+func (m *Mesh) NodeDiscoveryDepth(ctx context.Context, toNode *meshv1.Node, newNode *meshv1.Node, lastSeen int64, depth uint32) {
 	log := m.logger.Named("discovery-routine")
-	err := m.initClient(toNode)
+	m.acquireProbe(PROBE_POOL_DISCOVERY)
+	defer m.releaseProbe(PROBE_POOL_DISCOVERY)
+	c, err := m.initClient(toNode)
 	if err != nil {
 		log.Warnw("Could not connect to client - skip Node Discover Request", "node", toNode.Name)
 		return
 	}
-	_, err = m.clients[GetId(toNode)].client.NodeDiscovery(
-		context.Background(),
+	_, err = c.client.NodeDiscovery(
+		ctx,
 		&meshv1.NodeDiscoveryRequest{
 			NewNode: newNode,
 			IAmNode: &meshv1.Node{
-				Name:   m.setupConfig.Name,
-				Target: m.setupConfig.ListenAddress + ":" + strconv.FormatInt(m.setupConfig.ListenPort, 10),
+				Name:   m.name.get(),
+				Target: m.setupConfig.advertiseTarget(),
+				Labels: m.setupConfig.Labels,
 			},
+			LastSeen:    lastSeen,
+			LastSeenAge: lastSeenAge(lastSeen, time.Now()),
+			Depth:       depth,
 		})
 	if err != nil {
 		log.Warnf("Could not start request to client - skip Node Discover Request", "node", toNode.Name, "error", err)
@@ -147,33 +274,166 @@ func (m *Mesh) NodeDiscovery(toNode *meshv1.Node, newNode *meshv1.Node) {
 	return
 }
 
+// Notify a node that this node is leaving the mesh
+func (m *Mesh) LeaveMesh(ctx context.Context, toNode *meshv1.Node) error {
+	c, err := m.initClient(toNode)
+	if err != nil {
+		return fmt.Errorf("leave %v: %w", toNode.Target, err)
+	}
+	_, err = c.client.LeaveMesh(
+		ctx,
+		&meshv1.Node{
+			Name:   m.name.get(),
+			Target: m.setupConfig.advertiseTarget(),
+			Labels: m.setupConfig.Labels,
+		})
+	if err != nil {
+		return fmt.Errorf("leave %v: %w", toNode.Target, classifyError(err))
+	}
+	return nil
+}
+
+// Broadcast the leave to all known nodes.
+// Returns after all nodes are notified or the leave timeout is reached.
+func (m *Mesh) leave() {
+	log := m.logger.Named("leave-routine")
+	nodes := m.database.GetNodeList()
+	if len(nodes) == 0 {
+		return
+	}
+
+	log.Infow("Notifying nodes about the leave", "amount", len(nodes), "timeout", m.routineConfig.LeaveTimeout.String())
+	ctx, cancel := context.WithTimeout(context.Background(), m.routineConfig.LeaveTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *meshv1.Node) {
+			defer wg.Done()
+			if err := m.LeaveMesh(ctx, node); err != nil {
+				log.Debugw("Could not notify node about the leave", "node", node.Name, "error", err)
+			}
+		}(node.Convert())
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info("Nodes notified about the leave")
+	case <-ctx.Done():
+		log.Warn("Leave timeout exceeded - not all nodes are notified")
+	}
+}
+
 func (m *Mesh) pushSamples(node *meshv1.Node) error {
 	log := m.logger.Named("sample-routine")
-	err := m.initClient(node)
+	m.acquireProbe(PROBE_POOL_PUSH)
+	defer m.releaseProbe(PROBE_POOL_PUSH)
+	c, err := m.initClient(node)
 	if err != nil {
 		log.Debugw("Could not connect to client")
-		return err
+		return fmt.Errorf("push samples to %v: %w", node.Target, err)
 	}
 
+	return m.sendSamples(c.client, node, m.database.GetSampleList())
+}
+
+// Push the samples to a node by its client.
+// Samples that reached the max. hops or are not accepted by the node are skipped.
+func (m *Mesh) sendSamples(client meshv1.MeshServiceClient, node *meshv1.Node, databaseSamples []*data.Sample) error {
+	log := m.logger.Named("sample-routine")
 	var samples []*meshv1.Sample
-	databaseSamples := m.database.GetSampleList()
 	if len(databaseSamples) == 0 {
 		log.Debugw("No samples found for push - will not push")
 		return nil
 	}
-	for _, sample := range m.database.GetSampleList() {
-		samples = append(samples, &meshv1.Sample{From: sample.From, To: sample.To, Key: sample.Key, Value: sample.Value, Ts: sample.Ts})
+	filter := data.ConvertSampleFilter(node.SampleFilter)
+	for _, sample := range databaseSamples {
+		// samples that reached the max. hops are not forwarded anymore
+		if m.setupConfig.MaxHops > 0 && sample.Hops >= m.setupConfig.MaxHops {
+			continue
+		}
+		// samples not accepted by the node are not pushed
+		if !filter.Accepts(sample.Key) {
+			continue
+		}
+		samples = append(samples, &meshv1.Sample{From: sample.From, To: sample.To, Key: sample.Key, Value: sample.Value, Ts: sample.Ts, Hops: sample.Hops, FromId: sample.FromId, ToId: sample.ToId, Fields: sample.Fields, Group: sample.Group})
+	}
+	if len(samples) == 0 {
+		log.Debugw("All samples reached the max. hops or are filtered - will not push")
+		return nil
 	}
 
-	_, err = m.clients[GetId(node)].client.PushSamples(context.Background(), &meshv1.Samples{Samples: samples})
+	_, err := client.PushSamples(context.Background(), &meshv1.Samples{Samples: samples})
 	if err != nil {
 		log.Debugw("Could not send samples", "error", err)
-		return err
+		return fmt.Errorf("push samples to %v: %w", node.Target, classifyError(err))
 	}
+	m.database.SetNodeLastSeen(GetId(node))
 	return nil
 }
 
-func (m *Mesh) initClient(to *meshv1.Node) error {
+// Get all known samples of a node.
+// The token has to be an API token of the node, or empty if
+// the request is authorized by the mesh token.
+func (m *Mesh) GetSamples(ctx context.Context, node *meshv1.Node, token string) ([]*meshv1.Sample, error) {
+	c, err := m.initClient(node)
+	if err != nil {
+		return nil, fmt.Errorf("get samples from %v: %w", node.Target, err)
+	}
+
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+	stream, err := c.client.GetSamples(ctx, &meshv1.GetSamplesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("get samples from %v: %w", node.Target, classifyError(err))
+	}
+
+	var samples []*meshv1.Sample
+	for {
+		page, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("get samples from %v: %w", node.Target, classifyError(err))
+		}
+		samples = append(samples, page.Samples...)
+	}
+}
+
+// Load the client TLS credentials, the connection is insecure if no CA cert is set.
+// If the credentials can not be loaded, the error is returned unless
+// the TLS fallback allows insecure connections.
+func (m *Mesh) clientCredentials(log *zap.SugaredLogger) (credentials.TransportCredentials, error) {
+	if len(m.setupConfig.CaCertPath) == 0 && len(m.setupConfig.CaCert) == 0 && !m.setupConfig.CaCertSystem {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig, err := h.LoadClientTLSConfig(m.setupConfig.CaCertPath, m.setupConfig.CaCert, m.setupConfig.CaCertSystem, m.setupConfig.ServerNameOverride)
+	if err == nil && m.setupConfig.ClientCertPath != "" {
+		err = h.SetClientCertificate(tlsConfig, m.setupConfig.ClientCertPath, m.setupConfig.ClientKeyPath)
+	}
+	if err != nil {
+		if !m.setupConfig.TLSFallback {
+			log.Errorw("Cannot load TLS credentials - connection refused", "error", err.Error())
+			return nil, fmt.Errorf("load TLS credentials: %w", err)
+		}
+		log.Warnw("Cannot load TLS credentials - falling back to INSECURE connection", "error", err.Error())
+		return insecure.NewCredentials(), nil
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// Get the client of a node, the client is dialed if not known yet.
+// The returned client stays usable if it is evicted or closed meanwhile,
+// the RPCs fail with a closed connection then.
+func (m *Mesh) initClient(to *meshv1.Node) (*MeshClient, error) {
 	nodeId := GetId(to)
 	log := m.logger.Named("client")
 	log.Debugw("Init client")
@@ -182,40 +442,107 @@ func (m *Mesh) initClient(to *meshv1.Node) error {
 		grpc_zap.ReplaceGrpcLoggerV2(log.Named("grpc").Desugar())
 	}
 
-	if _, exists := m.clients[nodeId]; !exists {
-		var opts []grpc.DialOption
+	m.mu.Lock()
+	if c, ok := m.clients[nodeId]; ok {
+		c.lastUsed = time.Now()
+		m.mu.Unlock()
+		log.Debugw("Client already existed")
+		m.countConnection(metric.CONN_REUSE, to)
+		return c, nil
+	}
+	m.mu.Unlock()
 
-		// TLS
-		tlsCredentials, err := h.LoadClientTLSCredentials(m.setupConfig.CaCertPath, m.setupConfig.CaCert)
-		if err != nil {
-			log.Debugw("Cannot load TLS credentials - starting insecure connection", "error", err.Error())
-			opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-		} else {
-			opts = append(opts, grpc.WithTransportCredentials(tlsCredentials))
-		}
+	var opts []grpc.DialOption
+
+	// TLS
+	creds, err := m.peerCredentials(log, to, DSCP_MESH)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, grpc.WithTransportCredentials(creds))
+	// the overridden server name is the authority of the requests as well,
+	// e.g. for the routing of a shared load balancer
+	if m.setupConfig.ServerNameOverride != "" {
+		opts = append(opts, grpc.WithAuthority(m.setupConfig.ServerNameOverride))
+	}
+	if m.meshToken != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(m.meshToken))
+	}
 
-		// Timeout interceptor
-		opts = append(opts, grpc.WithUnaryInterceptor(m.timeoutInterceptor))
+	// DSCP marking
+	opts = append(opts, grpc.WithContextDialer(m.grpcDialer(DSCP_MESH)))
 
-		// dial
-		conn, err := grpc.Dial(to.Target, opts...)
-		if err != nil {
-			log.Debugw("Dial error", "error", err)
-			return err
-		}
+	// Timeout interceptor, followed by the custom interceptors
+	unaryInterceptors := append([]grpc.UnaryClientInterceptor{m.timeoutInterceptor}, m.setupConfig.ClientUnaryInterceptors...)
+	opts = append(opts, grpc.WithChainUnaryInterceptor(unaryInterceptors...))
+	if len(m.setupConfig.ClientStreamInterceptors) > 0 {
+		opts = append(opts, grpc.WithChainStreamInterceptor(m.setupConfig.ClientStreamInterceptors...))
+	}
+
+	// dial
+	conn, err := grpc.Dial(to.Target, opts...)
+	if err != nil {
+		log.Debugw("Dial error", "error", err)
+		m.countConnection(metric.CONN_DIAL_FAILURE, to)
+		return nil, classifyError(err, ErrDial)
+	}
 
-		client := meshv1.NewMeshServiceClient(conn)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// the client was dialed concurrently, keep the first one
+	if c, ok := m.clients[nodeId]; ok {
+		c.lastUsed = time.Now()
+		_ = conn.Close()
+		m.countConnection(metric.CONN_REUSE, to)
+		return c, nil
+	}
+	c := &MeshClient{
+		client:   meshv1.NewMeshServiceClient(conn),
+		conn:     conn,
+		node:     to,
+		lastUsed: time.Now(),
+	}
+	m.clients[nodeId] = c
+	m.countConnection(metric.CONN_DIAL, to)
+	return c, nil
+}
 
-		m.mu.Lock() // TODO has to be moved to the top?
-		m.clients[nodeId] = &MeshClient{
-			client: client,
-			conn:   conn,
+// Count a client connection event of a node.
+// The node label is left empty if disabled in the setup configuration
+// to keep the cardinality of the metric low.
+func (m *Mesh) countConnection(event string, to *meshv1.Node) {
+	node := ""
+	if !m.setupConfig.DisableNodeLabel {
+		node = to.Name
+		if node == "" {
+			// name of the node is not known before joining
+			node = to.Target
 		}
-		m.mu.Unlock()
-	} else {
-		log.Debugw("Client already existed")
 	}
-	return nil
+	m.metrics.GetClientConnections().WithLabelValues(event, node).Inc()
+}
+
+// Methods of the failure detector, bound by the probe timeout
+const (
+	pingMethod         = "/mesh.v1.MeshService/Ping"
+	pingIndirectMethod = "/mesh.v1.MeshService/PingIndirect"
+)
+
+// Get the timeout of a RPC and the name of its source.
+// The pings of the failure detector are bound by the probe timeout,
+// an indirect ping by twice the probe timeout.
+func (m *Mesh) rpcTimeout(method string) (time.Duration, string) {
+	states := m.routineConfig.NodeStates
+	if states.ProbeTimeout <= 0 {
+		return m.routineConfig.RequestTimeout, "request-timeout"
+	}
+	switch method {
+	case pingMethod:
+		return states.ProbeTimeout, "probe-timeout"
+	case pingIndirectMethod:
+		return 2 * states.ProbeTimeout, "probe-timeout"
+	}
+	return m.routineConfig.RequestTimeout, "request-timeout"
 }
 
 func (m *Mesh) timeoutInterceptor(
@@ -227,65 +554,123 @@ func (m *Mesh) timeoutInterceptor(
 	invoker grpc.UnaryInvoker,
 	opts ...grpc.CallOption,
 ) error {
-	ctx, close := context.WithTimeout(context.Background(), m.routineConfig.RequestTimeout)
+	start := time.Now()
+	timeout, source := m.rpcTimeout(method)
+	// keep the deadline of the parent context if it is earlier
+	_, parentDeadline := ctx.Deadline()
+	ctx, close := context.WithTimeout(ctx, timeout)
 	defer close()
 	// Calls the invoker to execute RPC
 	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil {
+		// the parent deadline is effective if it is earlier than the request timeout
+		if deadline, _ := ctx.Deadline(); parentDeadline && deadline.Sub(start) < timeout {
+			source = "parent-context"
+		}
+		fields := append(rpcFailureFields(ctx, method, start, err), "deadlineSource", source)
+		m.logger.Named("client").Infow("RPC failed", fields...)
+	}
 	return err
 }
 
 func (m *Mesh) closeClient(to *meshv1.Node) error {
 	m.mu.Lock()
-	err := m.clients[GetId(to)].conn.Close()
+	defer m.mu.Unlock()
+	c, ok := m.clients[GetId(to)]
+	if !ok {
+		// evicted as idle client
+		return nil
+	}
+	err := c.conn.Close()
 	if err != nil {
-		return err
+		return fmt.Errorf("close client %v: %w", to.Target, err)
 	}
 	// remove client
 	delete(m.clients, GetId(to))
-	m.mu.Unlock()
+	m.countConnection(metric.CONN_CLOSE, to)
 	return nil
 }
 
+// Close and remove the clients not used within the client idle timeout,
+// a client is dialed again by initClient on its next use
+func (m *Mesh) evictIdleClients(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, c := range m.clients {
+		if now.Sub(c.lastUsed) < m.setupConfig.ClientIdleTimeout {
+			continue
+		}
+		if err := c.conn.Close(); err != nil {
+			m.logger.Named("client").Debugw("Could not close idle client", "node", c.node.Name, "error", err)
+		}
+		delete(m.clients, id)
+		m.countConnection(metric.CONN_IDLE_EVICT, c.node)
+	}
+}
+
 func (m *Mesh) Rtt() {
 	log := m.logger.Named("rtt")
 	log.Debugw("Starting RTT measurement")
-	var opts []grpc.DialOption
-	var rttStartH, rttStart, rttEnd time.Time
 
-	nodes := m.database.GetRandomNodeListByState(NODE_OK, 1)
-	if nodes == nil {
+	// select node for RTT measurement
+	node := m.rttNode()
+	if node == nil {
 		log.Debugw("No Node suitable for RTT measurement")
 		return
 	}
-	// select random node for RTT measurement
-	node := nodes[0]
 	log.Debugw("Node selected", "node", node.Name)
+	m.rtt(node)
+}
+
+// Measure the RTT of a node
+func (m *Mesh) rtt(node *data.Node) {
+	log := m.logger.Named("rtt")
+	var opts []grpc.DialOption
+	var rttStartH, rttStart, rttEnd time.Time
+
+	m.acquireProbe(PROBE_POOL_RTT)
+	defer m.releaseProbe(PROBE_POOL_RTT)
 	// grpc logging
 	if m.setupConfig.DebugGrpc {
 		grpc_zap.ReplaceGrpcLoggerV2(log.Named("grpc").Desugar())
 	}
 
 	// TLS
-	tlsCredentials, err := h.LoadClientTLSCredentials(m.setupConfig.CaCertPath, m.setupConfig.CaCert)
+	creds, err := m.peerCredentials(log, node.Convert(), DSCP_RTT)
 	if err != nil {
-		log.Debugw("Cannot load TLS credentials - starting insecure connection", "error", err.Error())
-		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	} else {
-		opts = append(opts, grpc.WithTransportCredentials(tlsCredentials))
+		return
+	}
+	opts = append(opts, grpc.WithTransportCredentials(creds))
+	// the overridden server name is the authority of the requests as well,
+	// e.g. for the routing of a shared load balancer
+	if m.setupConfig.ServerNameOverride != "" {
+		opts = append(opts, grpc.WithAuthority(m.setupConfig.ServerNameOverride))
+	}
+	if m.meshToken != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(m.meshToken))
 	}
 
 	// blocking
 	opts = append(opts, grpc.WithBlock())
+	// DSCP marking
+	opts = append(opts, grpc.WithContextDialer(m.grpcDialer(DSCP_RTT)))
+
+	// the blocking dial is bounded by the request timeout,
+	// an unreachable node must not hold the probe slot
+	dialCtx, cancelDial := context.WithTimeout(context.Background(), m.routineConfig.RequestTimeout)
+	defer cancelDial()
 
 	// start RTT with TCP handshake
 	rttStartH = time.Now()
 	// dial
-	conn, err := grpc.Dial(node.Target, opts...)
-	defer conn.Close()
+	conn, err := grpc.DialContext(dialCtx, node.Target, opts...)
 	if err != nil {
 		log.Debugw("Dial error", "error", err)
+		m.metrics.ObserveProbe(PROBE_RTT, err)
+		m.observeHealth(node, healthFailed)
 		return
 	}
+	defer conn.Close()
 
 	client := meshv1.NewMeshServiceClient(conn)
 	if err != nil {
@@ -293,48 +678,103 @@ func (m *Mesh) Rtt() {
 		return
 	}
 
+	// trace context of the exemplars, created before the measurement
+	ctx, trace := m.rttContext(node.Name)
+	ctx, cancel := context.WithTimeout(ctx, m.routineConfig.RequestTimeout)
+	defer cancel()
+
 	// start RTT without TCP handshake
 	rttStart = time.Now()
 
 	// send request
-	_, err = client.Rtt(context.Background(), &emptypb.Empty{})
+	_, err = client.Rtt(ctx, &meshv1.RttRequest{})
 	// end RTT
 	rttEnd = time.Now()
+	trace.finish(err)
+	m.metrics.ObserveProbe(PROBE_RTT, err)
 
 	if err != nil {
 		log.Debugw("RTT failed")
+		m.observeHealth(node, healthFailed)
 		return
 	}
-	log.Debugw("RTT succeeded")
+	log.Debugw("RTT succeeded", traceFields(trace)...)
+	m.database.SetNodeLastSeen(node.Id)
 	// RTT with handshake
 	rttH := rttEnd.Sub(rttStartH)
 	// RTT without handshake
 	rtt := rttEnd.Sub(rttStart)
 
-	// save metrics
-	m.metrics.GetRtt().WithLabelValues(data.SampleName[data.RTT_TOTAL], node.Name).Observe(rttH.Seconds())
-	m.metrics.GetRtt().WithLabelValues(data.SampleName[data.RTT_REQUEST], node.Name).Observe(rtt.Seconds())
+	// save metrics, if not just aggregated
+	if !m.setupConfig.AggregationOnly {
+		m.observeRtt(data.RTT_TOTAL, node.Name, rttH, trace)
+		m.observeRtt(data.RTT_REQUEST, node.Name, rtt, trace)
+	}
 
 	// save samples
 	m.database.SetSample(
 		&data.Sample{
-			From:  m.setupConfig.Name,
-			To:    node.Name,
-			Key:   data.RTT_TOTAL,
-			Value: strconv.FormatInt(rttH.Nanoseconds(), 10),
-			Ts:    time.Now().Unix(),
+			From:   m.name.get(),
+			To:     node.Name,
+			Key:    data.RTT_TOTAL,
+			Value:  strconv.FormatInt(rttH.Nanoseconds(), 10),
+			Ts:     time.Now().Unix(),
+			FromId: m.nodeId(),
+			ToId:   node.Id,
 		},
 	)
 
 	m.database.SetSample(
 		&data.Sample{
-			From:  m.setupConfig.Name,
-			To:    node.Name,
-			Key:   data.RTT_REQUEST,
-			Value: strconv.FormatInt(rtt.Nanoseconds(), 10),
-			Ts:    time.Now().Unix(),
+			From:   m.name.get(),
+			To:     node.Name,
+			Key:    data.RTT_REQUEST,
+			Value:  strconv.FormatInt(rtt.Nanoseconds(), 10),
+			Ts:     time.Now().Unix(),
+			FromId: m.nodeId(),
+			ToId:   node.Id,
+			Fields: m.observeRttAnomaly(node.Name, rtt),
 		},
 	)
+	m.observeHealth(node, rtt)
 
+	// RTT with payloads on the established connection
+	for _, size := range m.setupConfig.RttPayloadSizes {
+		m.rttPayload(client, node, size)
+	}
 	return
 }
+
+// Measure the RTT of a request with a payload of size bytes.
+// The payload is echoed if configured, the RTT is saved as sample keyed by the size.
+func (m *Mesh) rttPayload(client meshv1.MeshServiceClient, node *data.Node, size int) {
+	req := &meshv1.RttRequest{Payload: make([]byte, size), Echo: m.setupConfig.RttPayloadEcho}
+	key := data.RttPayloadKey(size)
+
+	ctx, trace := m.rttContext(node.Name)
+	ctx, cancel := context.WithTimeout(ctx, m.routineConfig.RequestTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Rtt(ctx, req)
+	rtt := time.Since(start)
+	trace.finish(err)
+	if err != nil {
+		m.logger.Named("rtt").Debugw("RTT with payload failed", append([]interface{}{"size", size, "error", err}, traceFields(trace)...)...)
+		return
+	}
+
+	if !m.setupConfig.AggregationOnly {
+		m.observeRtt(key, node.Name, rtt, trace)
+	}
+	m.database.SetSample(
+		&data.Sample{
+			From:   m.name.get(),
+			To:     node.Name,
+			Key:    key,
+			Value:  strconv.FormatInt(rtt.Nanoseconds(), 10),
+			Ts:     time.Now().Unix(),
+			FromId: m.nodeId(),
+			ToId:   node.Id,
+		},
+	)
+}
@@ -0,0 +1,124 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+func Test_evictIdleClients(t *testing.T) {
+	m := testMesh(time.Second)
+	m.setupConfig.ClientIdleTimeout = time.Minute
+	idle := &meshv1.Node{Name: "idle", Target: "127.0.0.1:1"}
+	used := &meshv1.Node{Name: "used", Target: "127.0.0.1:2"}
+	for _, node := range []*meshv1.Node{idle, used} {
+		if _, err := m.initClient(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.clients[GetId(idle)].lastUsed = time.Now().Add(-2 * time.Minute)
+
+	m.evictIdleClients(time.Now())
+	if _, ok := m.clients[GetId(idle)]; ok {
+		t.Error("Expected the idle client to be evicted")
+	}
+	if _, ok := m.clients[GetId(used)]; !ok {
+		t.Error("Expected the used client to be kept")
+	}
+	if evicted := gatherValue(t, m.metrics, "client_connection_total", "event", metric.CONN_IDLE_EVICT); evicted != 1 {
+		t.Errorf("Expected 1 evicted client, got %v", evicted)
+	}
+	// a leave of an evicted node has no client to close
+	if err := m.closeClient(idle); err != nil {
+		t.Errorf("Expected no error closing an evicted client, got %v", err)
+	}
+
+	// the evicted client is dialed again on the next use
+	if _, err := m.initClient(idle); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.clients[GetId(idle)]; !ok {
+		t.Error("Expected the evicted client to be dialed again")
+	}
+	if dials := gatherValue(t, m.metrics, "client_connection_total", "event", metric.CONN_DIAL); dials != 3 {
+		t.Errorf("Expected 3 dials, got %v", dials)
+	}
+}
+
+// Run with -race: the clients are evicted while the nodes are pinged
+func Test_evictIdleClientsWhilePinging(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(server, &MeshServer{log: zap.NewNop().Sugar(), data: &db})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	m := testMesh(time.Second)
+	m.database, err = data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.setupConfig.ClientIdleTimeout = time.Minute
+	node := &meshv1.Node{Name: "peer", Target: lis.Addr().String()}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			m.evictIdleClients(time.Now().Add(time.Hour))
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				// a ping on an evicted client fails, but does not panic
+				_ = m.ping(node)
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+
+	if err := m.ping(node); err != nil {
+		t.Errorf("Expected the ping to dial the evicted client again, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Max. discoveries of a NodeDiscoveryBatch request, larger batches are sent
+// in multiple requests and refused by the receiving node
+const MAX_DISCOVERY_BATCH = 100
+
+// Broadcast the discoveries of the nodes joined within the coalescing window.
+// The random healthy nodes are chosen once for the batch and get the discoveries
+// in one RPC instead of one RPC per joined node; the joined nodes are candidates too,
+// so nodes joining at the same time learn of each other. The saved RPCs are counted
+// by NodeDiscoveryBatch.
+func (m *Mesh) broadcastJoins(joins map[uint32]NodeDiscovered) {
+	log := m.logger.Named("discovery-routine")
+	now := time.Now()
+	var batch []NodeDiscovered
+	var deadline time.Time
+	for _, join := range joins {
+		if settled(join.Deadline, now) {
+			log.Infow("Join settle timeout reached - skip discovery broadcast", "node", join.NewNode.Name)
+			continue
+		}
+		// the earliest deadline bounds the batch, joins without a deadline do not
+		if !join.Deadline.IsZero() && (deadline.IsZero() || join.Deadline.Before(deadline)) {
+			deadline = join.Deadline
+		}
+		batch = append(batch, join)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if len(batch) == 1 {
+		m.broadcastDiscovery(batch[0])
+		return
+	}
+
+	nodes := m.database.GetRandomNodeListByState(NODE_OK, m.routineConfig.BroadcastToAmount)
+	if len(nodes) == 0 {
+		log.Debug("Stopping routine prematurely - no more known nodes")
+		return
+	}
+	log.Infow("Sending coalesced discovery broadcast", "joins", len(batch), "amount", len(nodes))
+
+	ctx, cancel := m.discoveryContext(deadline)
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		// a node is not told about itself
+		var discoveries []NodeDiscovered
+		for _, join := range batch {
+			if GetId(join.NewNode) != node.Id {
+				discoveries = append(discoveries, join)
+			}
+		}
+		wg.Add(1)
+		go func(node *data.Node) {
+			defer wg.Done()
+			m.NodeDiscoveryBatch(ctx, node.Convert(), discoveries)
+		}(node)
+	}
+	// release the context if all broadcasts are done or the deadline is reached
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+}
+
+// Send the discoveries of multiple new nodes in one request,
+// or in requests of max. MAX_DISCOVERY_BATCH discoveries.
+// The discoveries are sent one by one if the node does not support batches,
+// the RPCs saved by a sent batch are counted.
+func (m *Mesh) NodeDiscoveryBatch(ctx context.Context, toNode *meshv1.Node, discoveries []NodeDiscovered) {
+	for len(discoveries) > MAX_DISCOVERY_BATCH {
+		if !m.nodeDiscoveryBatch(ctx, toNode, discoveries[:MAX_DISCOVERY_BATCH]) {
+			return
+		}
+		discoveries = discoveries[MAX_DISCOVERY_BATCH:]
+	}
+	m.nodeDiscoveryBatch(ctx, toNode, discoveries)
+}
+
+// Send one batch of discoveries, false if the remaining batches can not be sent
+func (m *Mesh) nodeDiscoveryBatch(ctx context.Context, toNode *meshv1.Node, discoveries []NodeDiscovered) bool {
+	log := m.logger.Named("discovery-routine")
+	m.acquireProbe(PROBE_POOL_DISCOVERY)
+	c, err := m.initClient(toNode)
+	if err != nil {
+		m.releaseProbe(PROBE_POOL_DISCOVERY)
+		log.Warnw("Could not connect to client - skip Node Discover Request", "node", toNode.Name)
+		return false
+	}
+
+	iAmNode := &meshv1.Node{
+		Name:   m.name.get(),
+		Target: m.setupConfig.advertiseTarget(),
+		Labels: m.setupConfig.Labels,
+	}
+	req := &meshv1.NodeDiscoveryBatchRequest{}
+	for _, d := range discoveries {
+		req.Discoveries = append(req.Discoveries, &meshv1.NodeDiscoveryRequest{NewNode: d.NewNode, IAmNode: iAmNode, LastSeen: d.LastSeen, LastSeenAge: lastSeenAge(d.LastSeen, time.Now()), Depth: d.Depth + 1})
+	}
+	_, err = c.client.NodeDiscoveryBatch(ctx, req)
+	m.releaseProbe(PROBE_POOL_DISCOVERY)
+	if status.Code(err) == codes.Unimplemented {
+		log.Debugw("Node does not support discovery batches - send discoveries one by one", "node", toNode.Name)
+		for _, d := range discoveries {
+			m.NodeDiscoveryDepth(ctx, toNode, d.NewNode, d.LastSeen, d.Depth+1)
+		}
+		return true
+	}
+	if err != nil {
+		log.Warnw("Could not start request to client - skip Node Discover Request", "node", toNode.Name, "error", err)
+		return false
+	}
+	m.metrics.GetSuppressedDiscoveries().Add(float64(len(discoveries) - 1))
+	return true
+}
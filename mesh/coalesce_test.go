@@ -0,0 +1,248 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Mesh server of an older version without discovery batches
+type discoveryServer struct {
+	meshv1.UnimplementedMeshServiceServer
+	discovered chan NodeDiscovered
+}
+
+func (s *discoveryServer) NodeDiscovery(ctx context.Context, req *meshv1.NodeDiscoveryRequest) (*emptypb.Empty, error) {
+	s.discovered <- NodeDiscovered{NewNode: req.NewNode, From: GetId(req.IAmNode)}
+	return &emptypb.Empty{}, nil
+}
+
+// Start a mesh server and a mesh with the server as only healthy node
+func coalesceMesh(t *testing.T, server meshv1.MeshServiceServer) (*Mesh, *data.Node) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(s, server)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	m := testMesh(time.Second)
+	m.database, err = data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.routineConfig.BroadcastToAmount = 2
+	seed := &data.Node{Name: "seed", Target: lis.Addr().String(), State: NODE_OK}
+	seed.Id = GetId(seed.Convert())
+	m.database.SetNode(seed)
+	return m, seed
+}
+
+// Receive the names of the discovered nodes
+func discoveredNames(t *testing.T, discovered chan NodeDiscovered, amount int) []string {
+	var names []string
+	for i := 0; i < amount; i++ {
+		select {
+		case d := <-discovered:
+			names = append(names, d.NewNode.Name)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %v discoveries, got %v", amount, names)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func coalescedJoins(names ...string) map[uint32]NodeDiscovered {
+	joins := map[uint32]NodeDiscovered{}
+	for _, name := range names {
+		node := &meshv1.Node{Name: name, Target: name + ":8081"}
+		joins[GetId(node)] = NodeDiscovered{NewNode: node, From: GetId(node), Deadline: time.Now().Add(time.Second)}
+	}
+	return joins
+}
+
+func Test_broadcastJoins(t *testing.T) {
+	discovered := make(chan NodeDiscovered, 10)
+	name := "seed"
+	m, _ := coalesceMesh(t, &MeshServer{
+		name:              newNodeName(name),
+		newNodeDiscovered: discovered,
+		draining:          &atomic.Bool{},
+		joinSettleTimeout: time.Second,
+	})
+
+	m.broadcastJoins(coalescedJoins("owl", "swan", "goose"))
+	if diff := deep.Equal(discoveredNames(t, discovered, 3), []string{"goose", "owl", "swan"}); diff != nil {
+		t.Error(diff)
+	}
+	// the seed is the only healthy node, one RPC instead of three
+	if suppressed := waitSuppressed(t, m, 2); suppressed != 2 {
+		t.Errorf("Expected 2 suppressed discoveries, got %v", suppressed)
+	}
+
+	// expired joins are not broadcasted
+	joins := coalescedJoins("heron")
+	for id, join := range joins {
+		join.Deadline = time.Now().Add(-time.Second)
+		joins[id] = join
+	}
+	m.broadcastJoins(joins)
+	select {
+	case d := <-discovered:
+		t.Errorf("Expected no discovery of an expired join, got %v", d.NewNode.Name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_broadcastJoinsFallback(t *testing.T) {
+	discovered := make(chan NodeDiscovered, 10)
+	m, _ := coalesceMesh(t, &discoveryServer{discovered: discovered})
+
+	m.broadcastJoins(coalescedJoins("owl", "swan"))
+	if diff := deep.Equal(discoveredNames(t, discovered, 2), []string{"owl", "swan"}); diff != nil {
+		t.Error(diff)
+	}
+	// no RPC is saved by the fallback
+	if suppressed := gatherValue(t, m.metrics, "suppressed_discoveries_total"); suppressed != 0 {
+		t.Errorf("Expected no suppressed discoveries, got %v", suppressed)
+	}
+}
+
+func Test_NodeDiscoveryBatchMax(t *testing.T) {
+	discovered := make(chan NodeDiscovered, MAX_DISCOVERY_BATCH*2)
+	m, seed := coalesceMesh(t, &MeshServer{
+		name:              newNodeName("seed"),
+		newNodeDiscovered: discovered,
+		draining:          &atomic.Bool{},
+		joinSettleTimeout: time.Second,
+	})
+
+	var discoveries []NodeDiscovered
+	for i := 0; i < MAX_DISCOVERY_BATCH+1; i++ {
+		node := &meshv1.Node{Name: fmt.Sprintf("node-%d", i), Target: fmt.Sprintf("node-%d:8081", i)}
+		discoveries = append(discoveries, NodeDiscovered{NewNode: node, From: GetId(node), Deadline: time.Now().Add(time.Second)})
+	}
+	// a batch over the max. is sent in two requests
+	m.NodeDiscoveryBatch(context.Background(), seed.Convert(), discoveries)
+	if names := discoveredNames(t, discovered, MAX_DISCOVERY_BATCH+1); len(names) != MAX_DISCOVERY_BATCH+1 {
+		t.Errorf("Expected %v discoveries, got %v", MAX_DISCOVERY_BATCH+1, len(names))
+	}
+	if suppressed := gatherValue(t, m.metrics, "suppressed_discoveries_total"); suppressed != MAX_DISCOVERY_BATCH-1 {
+		t.Errorf("Expected %v suppressed discoveries, got %v", MAX_DISCOVERY_BATCH-1, suppressed)
+	}
+
+	// a batch over the max. is refused
+	s := &MeshServer{draining: &atomic.Bool{}}
+	req := &meshv1.NodeDiscoveryBatchRequest{}
+	for _, d := range discoveries {
+		req.Discoveries = append(req.Discoveries, &meshv1.NodeDiscoveryRequest{NewNode: d.NewNode, IAmNode: d.NewNode})
+	}
+	if _, err := s.NodeDiscoveryBatch(context.Background(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for a batch over the max., got %v", err)
+	}
+}
+
+// Wait until the suppressed discoveries are counted, the RPC returns after the discoveries are received
+func waitSuppressed(t *testing.T, m *Mesh, expected float64) float64 {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && gatherValue(t, m.metrics, "suppressed_discoveries_total") != expected {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return gatherValue(t, m.metrics, "suppressed_discoveries_total")
+}
+
+func Test_broadcastDiscoveryMaxDepth(t *testing.T) {
+	discovered := make(chan NodeDiscovered, 10)
+	name := "seed"
+	m, _ := coalesceMesh(t, &MeshServer{
+		name:              newNodeName(name),
+		newNodeDiscovered: discovered,
+		draining:          &atomic.Bool{},
+		joinSettleTimeout: time.Second,
+	})
+	m.setupConfig.DiscoveryMaxDepth = 2
+	node := &meshv1.Node{Name: "owl", Target: "owl:8081"}
+
+	// the joined node and the first forward are broadcasted with the next depth
+	for _, depth := range []uint32{0, 1} {
+		m.broadcastDiscovery(NodeDiscovered{NewNode: node, From: GetId(node), Deadline: time.Now().Add(time.Second), Depth: depth})
+		select {
+		case d := <-discovered:
+			if d.Depth != depth+1 {
+				t.Errorf("Expected depth %v, got %v", depth+1, d.Depth)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a discovery of depth %v", depth+1)
+		}
+	}
+
+	// the max. depth is reached
+	m.broadcastDiscovery(NodeDiscovered{NewNode: node, From: 1, Deadline: time.Now().Add(time.Second), Depth: 2})
+	select {
+	case d := <-discovered:
+		t.Errorf("Expected no discovery above the max. depth, got depth %v", d.Depth)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if suppressed := gatherValue(t, m.metrics, "discovery_forwards_suppressed_total", "reason", "depth"); suppressed != 1 {
+		t.Errorf("Expected 1 suppressed forward, got %v", suppressed)
+	}
+
+	// the unknown depth of a discovery forwarded by an older node counts as max. depth
+	m.broadcastDiscovery(NodeDiscovered{NewNode: node, From: 1, Deadline: time.Now().Add(time.Second), Depth: 0})
+	select {
+	case d := <-discovered:
+		t.Errorf("Expected no discovery of an unknown depth, got depth %v", d.Depth)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if suppressed := gatherValue(t, m.metrics, "discovery_forwards_suppressed_total", "reason", "depth"); suppressed != 2 {
+		t.Errorf("Expected 2 suppressed forwards, got %v", suppressed)
+	}
+
+	// the discovery of an embedder is the broadcast of this node
+	m.NodeDiscovery(context.Background(), m.database.GetNodeList()[0].Convert(), node, 0)
+	select {
+	case d := <-discovered:
+		if d.Depth != 1 {
+			t.Errorf("Expected depth 1, got %v", d.Depth)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a discovery of depth 1")
+	}
+}
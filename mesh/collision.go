@@ -0,0 +1,102 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync/atomic"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+// Reactions to repeated name collisions at join
+const (
+	NAME_COLLISION_EXIT   = "exit"
+	NAME_COLLISION_SUFFIX = "suffix"
+)
+
+// Random bytes of the name suffix, appended hex encoded
+const nameSuffixBytes = 3
+
+// Name of this node, shared by the routines, the server, the sinks & the API.
+// The join routine renames the node after repeated name collisions while
+// the others read it, so the name is read at use and never copied.
+type nodeName struct {
+	name atomic.Value
+}
+
+// Create the shared name of a node
+func newNodeName(name string) *nodeName {
+	n := &nodeName{}
+	n.name.Store(name)
+	return n
+}
+
+// Current name of the node
+func (n *nodeName) get() string {
+	return n.name.Load().(string)
+}
+
+// Rename the node
+func (n *nodeName) set(name string) {
+	n.name.Store(name)
+}
+
+// Handle a join rejected by a name collision. The join is retried until the
+// consecutive collisions reach the limit, then the node exits non-zero or
+// renames itself with a random suffix of the configured name and joins again.
+// The configured name in the setup configuration is kept.
+// Called by the join routine only, before the node joined a mesh.
+func (m *Mesh) nameCollision(log *zap.SugaredLogger) {
+	limit := m.setupConfig.NameCollisionLimit
+	if limit < 1 {
+		limit = 1
+	}
+	m.nameCollisions++
+	log.Warnw("The name is not unique in the mesh", "name", m.name.get(), "collisions", m.nameCollisions, "limit", limit)
+	m.recordEvent(data.EVENT_JOIN_FAILED, m.name.get(), "name not unique in the mesh")
+	if m.nameCollisions < limit {
+		return
+	}
+
+	if m.setupConfig.NameCollisionAction != NAME_COLLISION_SUFFIX {
+		log.Fatalw("The name is not unique in the mesh, please choose another one", "name", m.name.get(), "collisions", m.nameCollisions)
+	}
+	name := m.setupConfig.Name + "-" + randomSuffix()
+	log.Warnw("Renaming the node after repeated name collisions", "name", m.name.get(), "newName", name)
+	m.name.set(name)
+	if m.setupConfig.RttEdgeLabels {
+		m.metrics.SetRttEdgeLabels(name)
+	}
+	m.nameCollisions = 0
+}
+
+// Random hex suffix of a node name
+func randomSuffix() string {
+	b := make([]byte, nameSuffixBytes)
+	if _, err := rand.Read(b); err != nil {
+		panic("Could not generate a random name suffix")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,71 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+func Test_nameCollisionSuffix(t *testing.T) {
+	m := testMesh(time.Second)
+	m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+	m.setupConfig.NameCollisionLimit = 2
+	m.setupConfig.NameCollisionAction = NAME_COLLISION_SUFFIX
+
+	// below the limit the join is retried with the name
+	m.nameCollision(m.logger)
+	if m.name.get() != "test" || m.nameCollisions != 1 {
+		t.Errorf("Expected the name to be kept below the limit, got %v after %v collisions", m.name.get(), m.nameCollisions)
+	}
+
+	// at the limit the configured name is suffixed
+	m.nameCollision(m.logger)
+	first := m.name.get()
+	if !strings.HasPrefix(first, "test-") || len(first) != len("test-")+2*nameSuffixBytes || m.nameCollisions != 0 {
+		t.Errorf("Expected a suffixed name, got %v after %v collisions", first, m.nameCollisions)
+	}
+
+	// a suffixed name is not suffixed again
+	m.nameCollision(m.logger)
+	m.nameCollision(m.logger)
+	if second := m.name.get(); !strings.HasPrefix(second, "test-") || strings.Count(second, "-") != 1 {
+		t.Errorf("Expected a new suffix of the configured name, got %v", second)
+	}
+	if m.setupConfig.Name != "test" {
+		t.Errorf("Expected the configured name to be kept, got %v", m.setupConfig.Name)
+	}
+
+	events := 0
+	for _, event := range m.database.GetEventList() {
+		if event.Type == data.EVENT_JOIN_FAILED {
+			events++
+		}
+	}
+	if events != 4 {
+		t.Errorf("Expected every collision to be recorded, got %v", events)
+	}
+}
@@ -22,26 +22,49 @@
 package mesh
 
 import (
+	"fmt"
+	"net"
+	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/telekom/canary-bot/data"
 	h "github.com/telekom/canary-bot/helper"
+	"github.com/telekom/canary-bot/metric"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 )
 
 // Configuration for the timer- and channelRoutines
 type RoutineConfiguration struct {
 	// Timeout for every grpc request
 	RequestTimeout time.Duration
+	// Max. time to finish in-flight requests on shutdown
+	DrainTimeout time.Duration
+	// Max. time to notify the nodes about the leave on shutdown
+	LeaveTimeout time.Duration
+	// Time a left node can not be re-added by node lists & discoveries
+	TombstoneTTL time.Duration
+	// Discoveries of nodes with an older last contact are rejected, 0 disables the check
+	DiscoveryMaxAge time.Duration
 
 	// Join config
 	JoinInterval time.Duration
+	// Max. time for joining and broadcasting the discovery of a joined node, 0 disables the timeout
+	JoinSettleTimeout time.Duration
+	// Delay after a successful join before probing other nodes
+	ProbeWarmup time.Duration
 
 	// Ping config
-	PingInterval    time.Duration
+	PingInterval   time.Duration
+	PingRetryDelay time.Duration
+	// Deprecated: use NodeStates.DeadAfter, failed pings until a node is dead if DeadAfter is not set
 	PingRetryAmount int
-	PingRetryDelay  time.Duration
+	// Node state transitions by failed pings, the production defaults if DeadAfter is not set
+	NodeStates NodeStateConfiguration
+	// Retry budget of the ping, push sample & join retries
+	RetryBudget RetryBudgetConfiguration
 
 	// Node discovery
 	BroadcastToAmount int
@@ -56,20 +79,66 @@ type RoutineConfiguration struct {
 	CleanupInterval time.Duration
 	CleanupMaxAge   time.Duration
 
+	// Samples older than this are flagged stale, 0 disables staleness
+	SampleStaleAfter time.Duration
+
 	// Sample: RTT
 	RttInterval time.Duration
+
+	// Health score by the last RTT measurements of a node, 0 disables the health score
+	HealthWindow     int
+	HealthMinSamples int
+
+	// Sample: heartbeat of this node with an incrementing counter seeded by the time, 0 disables the heartbeat
+	HeartbeatInterval time.Duration
 }
 
 // Configuration how the bot can connect to the mesh etc.
 type SetupConfiguration struct {
 	// remote target
 	Targets []string
+	// SRV record to resolve the targets, static targets are the fallback
+	TargetSrv string
+	// Kubernetes service [NAMESPACE/]NAME[:PORT] to join its pods, listed by the
+	// in-cluster service account; takes precedence over the SRV record
+	TargetK8sService string
+	// Order of the join targets: none, random or name (seeded by the node name)
+	TargetShuffle string
+	// Multicast group IP:PORT to discover the peers in a flat L2 network,
+	// disabled if empty. The nodes announce themselves every interval, the
+	// join waits up to the timeout for a peer before using the seeds only.
+	DiscoveryMulticast         string
+	DiscoveryMulticastInterval time.Duration
+	DiscoveryMulticastTimeout  time.Duration
 
 	// local config
 	Name          string
 	JoinAddress   string
 	ListenAddress string
 	ListenPort    int64
+	// TCP listen backlog of the mesh server, 0 uses the OS default.
+	// With SO_REUSEPORT the listen sockets share the port, the options
+	// are ignored if not supported on the platform.
+	ListenBacklog int
+	ReusePort     bool
+	ListenSockets int
+	// Max. concurrent streams per inbound connection, 0 uses the gRPC default
+	MaxConcurrentStreams uint32
+	// Max. size of an inbound message in bytes, larger messages are rejected with
+	// ResourceExhausted before they are received; 0 uses the gRPC default (4 MiB)
+	MaxRecvMsgSize int
+	// Max. concurrent inbound RPCs of all connections, excess RPCs are
+	// rejected with ResourceExhausted; 0 is unlimited
+	MaxInboundStreams int
+	// Max. inbound connections, excess connections are closed; 0 is unlimited
+	MaxInboundConnections int
+	// Labels of the node, e.g. region, zone or role
+	Labels map[string]string
+	// Address & port the node advertises in discoveries and is known by in the mesh,
+	// defaults to the host & port of the join address (the listen port if it has none).
+	// An unspecified address (e.g. 0.0.0.0) is rejected.
+	AdvertiseAddress string
+	AdvertisePort    int64
 
 	// API
 	ApiPort int64
@@ -80,29 +149,280 @@ type SetupConfiguration struct {
 	ServerCert     []byte
 	ServerKey      []byte
 	// TLS client side
+	// ca cert files or directories of *.pem & *.crt files
 	CaCertPath []string
 	CaCert     []byte
+	// Append the system cert pool to the ca certs
+	CaCertSystem bool
+	// Client cert & key presented to servers requesting a client cert,
+	// e.g. an ingress verifying client certs; reloaded on change
+	ClientCertPath string
+	ClientKeyPath  string
+	// Server name (SNI) of mesh dials and HTTP probes
+	ServerNameOverride string
+	// Fail instead of falling back to insecure connections
+	RequireTLS bool
+	// Connect peers not speaking TLS insecure, e.g. during a TLS rollout.
+	// TLS is tried first on every connection, a mesh token can not be set.
+	TLSFallback bool
 
 	//Auth API
 	Tokens []string
+	// Shared secret of the mesh RPCs, a token file is reloaded on change.
+	// RPCs without the token are rejected, disabled if neither is set.
+	MeshToken     string
+	MeshTokenPath string
+	// Sample rate 0-1 of the successful inbound RPCs logged with method, peer,
+	// duration & status, failed RPCs are always logged. A rate file is reloaded
+	// on change and takes precedence over the rate.
+	RequestLogSampleRate     float64
+	RequestLogSampleRatePath string
+	// Refuse to join and to be joined by nodes with an incompatible protocol version,
+	// otherwise the nodes are just logged
+	RefuseIncompatible bool
 
 	// Clean nodes & samples
 	CleanupNodes   bool
 	CleanupSamples bool
 
+	// Metrics
+	DisableNodeLabel bool
+	// Dedicated metrics server, disabled if port is 0
+	MetricsPort      int64
+	MetricsCertPath  string
+	MetricsKeyPath   string
+	MetricsBasicAuth string
+	MetricsTokens    []string
+	// Export min, avg & max of the samples per window, disabled if 0
+	AggregationWindow time.Duration
+	// Do not observe the raw RTT histogram, just the aggregation window
+	AggregationOnly bool
+	// Aggregator mode: export the values of all samples of the mesh received
+	// by this node, labeled by their from & to node. Samples of the excluded
+	// sample type names are not exported, the export is limited to the
+	// max. series, 0 uses the default limit.
+	Aggregator               bool
+	AggregatorExcludeSamples []string
+	AggregatorMaxSeries      int
+	// Units of the exported sample values by sample type name, e.g. rtt_total=ms.
+	// The metric & API units take precedence over the export units,
+	// the stored values are not changed.
+	ExportUnits map[string]string
+	MetricUnits map[string]string
+	ApiUnits    map[string]string
+	// Rounding granularities of the sample values by sample type name in the
+	// unit of the sample type, e.g. rtt_total=1us or health_score=0.01.
+	// The values are rounded when exported as metrics, the API keeps the raw
+	// values, if not rounded at storage (the raw values are lost).
+	SampleRounding        map[string]string
+	SampleRoundingStorage bool
+	// History retention of the sample values by sample type name in the format
+	// MODE:SIZE, the last values (last) or a uniform random subset of all values
+	// (reservoir), e.g. rtt_total=reservoir:1000. No history is kept by default.
+	SampleHistory map[string]string
+	// Handling of the self-referential samples, the measuring node is the
+	// measured node by its node id, and of the samples of peers about this node:
+	// keep, drop (counted) or exclude them from the metric aggregations. Empty keeps them.
+	SelfSamples string
+	// Static labels added to all exported metrics, e.g. datacenter=dc1.
+	// The names must not collide with the labels of a metric, e.g. node.
+	MetricLabels map[string]string
+	// Probe group of the node, e.g. edge; added to the samples measured by
+	// the node and as group label to all exported metrics. Empty if not set.
+	ProbeGroup string
+	// Names of the sample types exported as metrics, empty exports all.
+	// Not exported samples are still stored, pushed and available by the API.
+	ExportSamples []string
+
+	// Observer mode: join the mesh and receive data,
+	// but do not ping, measure or push samples to other nodes
+	Observer bool
+
+	// External probes in the format TYPE://TARGET[#INTERVAL]
+	Probes        []string
+	ProbeInterval time.Duration
+	// Disable the mesh, e.g. to just probe external targets
+	DisableMesh bool
+	// Drain the mesh & exit the process on SIGINT or SIGTERM, set by the CLI.
+	// Embedders handling the signals themselves leave it unset.
+	ExitOnSignal bool
+
+	// Node selection of the RTT measurement: random, consistent-hash
+	RttSelection string
+	// RTT measurement intervals of nodes by name pattern or label,
+	// format PATTERN=INTERVAL or label:KEY=VALUE=INTERVAL. The intervals
+	// have to be at least the min. interval. Only the RTT measurement is
+	// overridden, the probes keep their probe interval.
+	ProbeIntervalOverrides []string
+	ProbeIntervalMin       time.Duration
+	// Schedule windows of the RTT measurement (rtt), the throughput probes
+	// (throughput), a probe type or a probe (TYPE://TARGET) by standard cron
+	// expressions, format KEY=CRON. The probes run in their interval within
+	// the window and are skipped outside.
+	ProbeSchedules []string
+	// Peering rules of a partial mesh, format PATTERN, label:KEY=VALUE or
+	// same:KEY. Just the nodes matching a rule are probed and gossiped with,
+	// all nodes are still known. A full mesh is used without rules.
+	Peering []string
+	// Readiness policy of the API readiness: none (ready unless paused), count
+	// (min. OK peers), fraction (min. fraction of OK peers) or weighted (min.
+	// score of the weights of the OK peers). The weights are RULE=WEIGHT with
+	// a peering rule, e.g. label:role=seed=5; peers without weight weigh 1.
+	ReadinessPolicy      string
+	ReadinessMinPeers    int
+	ReadinessMinFraction float64
+	ReadinessMinScore    float64
+	ReadinessWeights     []string
+	// Payload sizes in bytes of additional RTT measurements, echoed if set
+	RttPayloadSizes []int
+	RttPayloadEcho  bool
+	// Tracer of the RTT requests, the trace & span id of a request (sent as
+	// traceparent) and the peer name are attached as exemplar to the RTT
+	// observations; only settable when embedding the package.
+	RttTracer RttTracer
+	// Label the RTT metric by the measuring node as well, for a latency matrix
+	RttEdgeLabels bool
+	// Measure the one-way delay of the pings in both directions, just if the
+	// clock skew to a node is below the max. skew
+	OneWayDelay        bool
+	OneWayDelayMaxSkew time.Duration
+	// Interval of the throughput probes to a random node, 0 disables the probes.
+	// Volume in bytes streamed to the node per probe.
+	ThroughputInterval time.Duration
+	ThroughputVolume   int
+	// Max. concurrent outbound probes (ping, rtt, push samples), 0 is based on GOMAXPROCS
+	MaxConcurrentProbes int
+	// Sizes of separate probe pools per routine (ping, rtt, discovery, push),
+	// routines without a pool share the max. concurrent probes
+	ProbePools map[string]int
+	// Max. forwards of a sample, 0 is unlimited
+	MaxHops uint32
+	// Window a seed node coalesces the discovery broadcasts of joining nodes in,
+	// one batch per window instead of a broadcast per join, 0 disables it
+	JoinCoalesceWindow time.Duration
+	// Consecutive joins rejected by a name collision until the action:
+	// exit non-zero (default) or suffix the name randomly and join again; 0 is the same as 1
+	NameCollisionLimit  int
+	NameCollisionAction string
+	// Clients of nodes not used within the timeout are closed and dialed
+	// again on the next use, 0 keeps the clients until the node leaves
+	ClientIdleTimeout time.Duration
+	// File persisting the paused state of the outbound probes across restarts,
+	// a node starts paused if the file exists. Not persisted if empty.
+	PauseStatePath string
+	// Max. depth a discovery of a new node propagates: 1 informs just the nodes
+	// of the broadcast of the joined node, 0 is unlimited
+	DiscoveryMaxDepth uint32
+	// Sync just the differing samples by digests of the sample stores instead of
+	// pushing all samples. All samples are pushed if the digests differ in more
+	// than the full sync ratio of the buckets.
+	DigestSync          bool
+	DigestFullSyncRatio float64
+	// Names of the sample types pushed to this node, empty accepts all
+	AcceptSamples []string
+	// Amount of mesh events kept in the event log, 0 disables the event log
+	EventLogSize int
+	// Spill the oldest samples to a log file at the path if the samples
+	// in memory exceed the threshold, disabled if no path is set
+	SampleSpillPath      string
+	SampleSpillThreshold int
+	// Weights of the node health score, a RTT at or below the baseline scores best.
+	// The weight sum has to be greater than 0 if the HealthWindow is set.
+	HealthWeights     HealthWeights
+	HealthRttBaseline time.Duration
+	// RTT anomaly detection: a RTT deviating from the rolling baseline (EWMA with
+	// the alpha) of a node by more than threshold standard deviations is an anomaly.
+	// The first warmup measurements build the baseline, a threshold of 0 disables it.
+	// A deviation below the min. deviation is never an anomaly.
+	RttAnomalyThreshold    float64
+	RttAnomalyAlpha        float64
+	RttAnomalyWarmup       int
+	RttAnomalyMinDeviation time.Duration
+
+	// DSCP values per traffic type (mesh, rtt, http, tcp, dns, icmp)
+	Dscp map[string]int
+	// Local IP or interface all outbound connections & probes originate from
+	LocalAddress string
+	// TCP_NODELAY of the outbound TCP connections & probes; if disabled,
+	// Nagle's algorithm delays small writes and adds to the measured RTT
+	TcpNoDelay bool
+	// Send & receive buffer sizes in bytes of the outbound sockets, 0 keeps the OS default
+	SocketSendBuffer int
+	SocketRecvBuffer int
+	// TTL of the DNS cache of the mesh dialer, 0 disables the cache.
+	// The probes are not covered and resolve by the OS resolver.
+	// The last good answer is used within the grace period if the resolution fails.
+	DnsCacheTTL   time.Duration
+	DnsCacheGrace time.Duration
+
 	//Logging
 	Debug     bool
 	DebugGrpc bool
+	// Register gRPC server reflection for tools like grpcurl
+	GrpcReflection bool
+
+	// Additional gRPC interceptors, only settable when embedding the package.
+	// Client interceptors are chained after the built-in timeoutInterceptor,
+	// server unary interceptors after the built-in deadlineInterceptor.
+	// RTT measurements do not use the interceptors.
+	ClientUnaryInterceptors  []grpc.UnaryClientInterceptor
+	ClientStreamInterceptors []grpc.StreamClientInterceptor
+	ServerUnaryInterceptors  []grpc.UnaryServerInterceptor
+	ServerStreamInterceptors []grpc.StreamServerInterceptor
+
+	// Sink publishing the samples measured by this node to the topic of Kafka brokers
+	// (host:port) or to a producer of the embedder, which takes precedence.
+	// Disabled if neither is set. The samples are keyed by the node name, formatted
+	// as json or protobuf. Unset buffer size, batch size & flush interval use the defaults.
+	SampleProducer    SampleProducer
+	SinkKafkaBrokers  []string
+	SinkTopic         string
+	SinkFormat        string
+	SinkBufferSize    int
+	SinkBatchSize     int
+	SinkFlushInterval time.Duration
+
+	// StatsD endpoint (host:port) the samples measured by this node are sent
+	// to over UDP, disabled if empty. The metric names are prefixed, the format
+	// is statsd or dogstatsd (tagged by node, peer & sample type).
+	StatsdAddress string
+	StatsdPrefix  string
+	StatsdFormat  string
+
+	// Transport of the HTTP/3 probes (h3://) replacing the quic-go transport,
+	// only settable when embedding the package
+	Http3Transport http.RoundTripper
 }
 
 // Use standard configuration parameters for your production
 func StandardProductionRoutineConfig() *RoutineConfiguration {
 	return &RoutineConfiguration{
-		RequestTimeout:        time.Second * 3,
-		JoinInterval:          time.Second * 3,
-		PingInterval:          time.Second * 10,
-		PingRetryAmount:       3,
-		PingRetryDelay:        time.Second * 5,
+		RequestTimeout:    time.Second * 3,
+		DrainTimeout:      time.Second * 10,
+		LeaveTimeout:      time.Second * 3,
+		TombstoneTTL:      time.Minute,
+		DiscoveryMaxAge:   time.Minute * 5,
+		JoinInterval:      time.Second * 3,
+		JoinSettleTimeout: time.Second * 10,
+		ProbeWarmup:       time.Second * 5,
+		PingInterval:      time.Second * 10,
+		PingRetryDelay:    time.Second * 5,
+		NodeStates: NodeStateConfiguration{
+			ProbeTimeout:     time.Second * 3,
+			FailureThreshold: 1,
+			TimeoutAfter:     1,
+			DeadAfter:        3,
+			RemoveAfter:      0,
+			IndirectProbes:   0,
+			SuspectTimeout:   time.Second * 15,
+
+			NeverContactedGrace: time.Minute,
+		},
+		RetryBudget: RetryBudgetConfiguration{
+			Ratio:        0.2,
+			MinPerSecond: 1,
+			Max:          10,
+		},
 		BroadcastToAmount:     2,
 		PushSampleInterval:    time.Second * 5,
 		PushSampleToAmount:    2,
@@ -110,8 +430,14 @@ func StandardProductionRoutineConfig() *RoutineConfiguration {
 		PushSampleRetryDelay:  time.Second * 10,
 		CleanupInterval:       time.Minute,
 		CleanupMaxAge:         time.Hour * 24,
+		SampleStaleAfter:      time.Minute * 5,
 
 		RttInterval: time.Second * 3,
+
+		HealthWindow:     20,
+		HealthMinSamples: 5,
+
+		HeartbeatInterval: time.Second * 10,
 	}
 }
 
@@ -129,6 +455,11 @@ func (setupConfig *SetupConfiguration) setDefaults(logger *zap.SugaredLogger) {
 		setupConfig.ListenAddress = externalIP
 	}
 
+	// a unix domain socket is joined by its address
+	if _, ok := h.UnixSocketPath(setupConfig.ListenAddress); ok && setupConfig.JoinAddress == "" {
+		setupConfig.JoinAddress = setupConfig.ListenAddress
+	}
+
 	if setupConfig.JoinAddress == "" {
 		if err != nil {
 			logger.Fatalln("Could not get external IP, please use join-address flag")
@@ -137,6 +468,15 @@ func (setupConfig *SetupConfiguration) setDefaults(logger *zap.SugaredLogger) {
 		setupConfig.JoinAddress = externalIP + ":" + strconv.FormatInt(setupConfig.ListenPort, 10)
 	}
 
+	// advertise the address other nodes join by if not set,
+	// the listen address may be a wildcard
+	if setupConfig.AdvertiseAddress == "" {
+		setupConfig.AdvertiseAddress = joinHost(setupConfig.JoinAddress)
+	}
+	if setupConfig.AdvertisePort == 0 {
+		setupConfig.AdvertisePort = joinPort(setupConfig.JoinAddress, setupConfig.ListenPort)
+	}
+
 	// get tokens; generate one if none is set
 	if len(setupConfig.Tokens) == 0 {
 		newToken := h.GenerateRandomToken(64)
@@ -147,19 +487,341 @@ func (setupConfig *SetupConfiguration) setDefaults(logger *zap.SugaredLogger) {
 	}
 }
 
-// Check the default configuration to discover TLS mode.
-// Check if name and target(s) are set in config.
-func (setupConfig *SetupConfiguration) checkDefaults(logger *zap.SugaredLogger) {
-	// check TLS mode
-	if setupConfig.CaCert != nil || len(setupConfig.CaCertPath) > 0 {
+// Get the host of the join address, a unix domain socket is kept as it is
+func joinHost(joinAddress string) string {
+	if _, ok := h.UnixSocketPath(joinAddress); ok {
+		return joinAddress
+	}
+	if host, _, err := net.SplitHostPort(joinAddress); err == nil {
+		return host
+	}
+	return joinAddress
+}
+
+// Get the port of the join address, the listen port if it has no port
+func joinPort(joinAddress string, listenPort int64) int64 {
+	if _, port, err := net.SplitHostPort(joinAddress); err == nil {
+		if p, err := strconv.ParseInt(port, 10, 64); err == nil {
+			return p
+		}
+	}
+	return listenPort
+}
+
+// Target the node advertises in discoveries and is known by in the mesh,
+// the node id derives from it. A unix domain socket is advertised without port,
+// the join address is advertised if no advertise address is set.
+func (setupConfig *SetupConfiguration) advertiseTarget() string {
+	if setupConfig.AdvertiseAddress == "" {
+		return setupConfig.JoinAddress
+	}
+	if _, ok := h.UnixSocketPath(setupConfig.AdvertiseAddress); ok {
+		return setupConfig.AdvertiseAddress
+	}
+	return net.JoinHostPort(setupConfig.AdvertiseAddress, strconv.FormatInt(setupConfig.AdvertisePort, 10))
+}
+
+// Sample filter of this node by the accepted sample type names.
+// Unknown names are skipped, nil if all samples are accepted.
+func (setupConfig *SetupConfiguration) sampleFilter() *data.SampleFilter {
+	return data.NewSampleFilter(sampleKeys(setupConfig.AcceptSamples))
+}
+
+// Get the keys of sample type names, unknown names are skipped
+func sampleKeys(names []string) []int64 {
+	var keys []int64
+	for _, name := range names {
+		if key, ok := data.SampleKey(name); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Units of the sample values exported as metrics, the metric units take precedence
+func (setupConfig *SetupConfiguration) metricUnits() (data.UnitNormalizer, error) {
+	return data.NewUnitNormalizer(setupConfig.ExportUnits, setupConfig.MetricUnits)
+}
+
+// Units of the sample values exported by the API, the API units take precedence
+func (setupConfig *SetupConfiguration) apiUnits() (data.UnitNormalizer, error) {
+	return data.NewUnitNormalizer(setupConfig.ExportUnits, setupConfig.ApiUnits)
+}
+
+// Static labels of all exported metrics, the metric labels and the probe group
+func (setupConfig *SetupConfiguration) metricLabels() (map[string]string, error) {
+	if setupConfig.ProbeGroup == "" {
+		return setupConfig.MetricLabels, nil
+	}
+	if _, ok := setupConfig.MetricLabels[metric.GROUP_LABEL]; ok {
+		return nil, fmt.Errorf("metric label %v is set by the probe group", metric.GROUP_LABEL)
+	}
+	labels := map[string]string{metric.GROUP_LABEL: setupConfig.ProbeGroup}
+	for name, value := range setupConfig.MetricLabels {
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// TCP address of the API & metrics server.
+// The servers listen on localhost if the mesh listens on a unix domain socket.
+func (setupConfig *SetupConfiguration) tcpListenAddress() string {
+	if _, ok := h.UnixSocketPath(setupConfig.ListenAddress); ok {
+		return "localhost"
+	}
+	return setupConfig.ListenAddress
+}
+
+// Check the TLS mode of the mesh connections
+func (setupConfig *SetupConfiguration) checkTLS(logger *zap.SugaredLogger) {
+	if (setupConfig.ClientCertPath == "") != (setupConfig.ClientKeyPath == "") {
+		logger.Fatal("Client cert path and client key path have to be set together")
+	}
+	if setupConfig.CaCert != nil || len(setupConfig.CaCertPath) > 0 || setupConfig.CaCertSystem {
 		if (setupConfig.ServerCert != nil || setupConfig.ServerCertPath != "") &&
 			(setupConfig.ServerKey != nil || setupConfig.ServerKeyPath != "") {
 			logger.Info("Mesh is set to mutal TLS mode")
 		} else {
 			logger.Info("Mesh is set to edge-terminated TLS mode")
 		}
+	} else if setupConfig.RequireTLS {
+		logger.Fatal("TLS is required, but no CA certificate is set")
+	} else if setupConfig.ClientCertPath != "" {
+		logger.Fatal("A client certificate is set, but no CA certificate")
 	} else {
-		logger.Info("Mesh is set to unsecure mode - no TLS used")
+		logger.Warn("Mesh is set to unsecure mode - no TLS used")
+	}
+	if setupConfig.RequireTLS && setupConfig.TLSFallback {
+		logger.Fatal("TLS is required, the TLS fallback can not be enabled")
+	}
+	// the downgrade can be forced on-path, the token would be sent in plaintext
+	if setupConfig.TLSFallback && (setupConfig.MeshToken != "" || setupConfig.MeshTokenPath != "") {
+		logger.Fatal("A mesh token is set, the TLS fallback can not be enabled")
+	}
+}
+
+// Check if name and target(s) are set in config.
+// Targets are not needed if the mesh is disabled, a SRV record or a
+// Kubernetes service is set.
+func (setupConfig *SetupConfiguration) checkDefaults(logger *zap.SugaredLogger) {
+	// check TLS mode
+	setupConfig.checkTLS(logger)
+
+	// check the mesh token
+	if setupConfig.MeshToken != "" && setupConfig.MeshTokenPath != "" {
+		logger.Warn("Mesh token and mesh token path set - using the token file")
+	}
+
+	// check the request log sample rate
+	if err := validateRequestLogRate(setupConfig.RequestLogSampleRate); err != nil {
+		logger.Fatalf("Invalid request log configuration - Error: %+v", err)
+	}
+
+	// validate if the advertise address can be resolved,
+	// other nodes can not connect to a wildcard address
+	if ip := net.ParseIP(setupConfig.AdvertiseAddress); ip != nil && ip.IsUnspecified() {
+		logger.Fatalf("Advertise address %v is unspecified, please set the advertise-address or join-address flag", setupConfig.AdvertiseAddress)
+	}
+	if _, ok := h.UnixSocketPath(setupConfig.AdvertiseAddress); ok {
+		logger.Infow("Advertising a unix domain socket - just local nodes can connect", "address", setupConfig.AdvertiseAddress)
+	} else if _, err := net.LookupHost(setupConfig.AdvertiseAddress); err != nil {
+		logger.Warnw("Advertise address can not be resolved - other nodes may not be able to connect", "address", setupConfig.AdvertiseAddress, "error", err)
+	}
+
+	// validate RTT node selection
+	if setupConfig.RttSelection != RTT_SELECTION_RANDOM && setupConfig.RttSelection != RTT_SELECTION_CONSISTENT_HASH {
+		logger.Fatalf("Unknown RTT selection %v, please use random or consistent-hash", setupConfig.RttSelection)
+	}
+
+	// validate the self-referential sample handling
+	if setupConfig.SelfSamples != SELF_SAMPLES_KEEP && setupConfig.SelfSamples != SELF_SAMPLES_DROP && setupConfig.SelfSamples != SELF_SAMPLES_EXCLUDE {
+		logger.Fatalf("Unknown self samples handling %v, please use keep, drop or exclude", setupConfig.SelfSamples)
+	}
+
+	// validate the StatsD sink
+	if setupConfig.StatsdAddress != "" && setupConfig.StatsdFormat != STATSD_FORMAT_STATSD && setupConfig.StatsdFormat != STATSD_FORMAT_DOGSTATSD {
+		logger.Fatalf("Unknown StatsD format %v, please use statsd or dogstatsd", setupConfig.StatsdFormat)
+	}
+
+	// validate the RTT payload sizes
+	for _, size := range setupConfig.RttPayloadSizes {
+		if size <= 0 || size > data.MAX_RTT_PAYLOAD_SIZE {
+			logger.Fatalf("RTT payload size %v is out of range, please use 1-%v bytes", size, data.MAX_RTT_PAYLOAD_SIZE)
+		}
+	}
+
+	// validate the probe interval overrides
+	if setupConfig.ProbeIntervalMin <= 0 {
+		logger.Fatal("Min. probe interval has to be greater than 0")
+	}
+	for _, o := range setupConfig.ProbeIntervalOverrides {
+		if _, err := ParseProbeIntervalOverride(o, setupConfig.ProbeIntervalMin); err != nil {
+			logger.Fatalf("Invalid probe interval override - Error: %+v", err)
+		}
+	}
+
+	// validate the probe schedules
+	if _, err := parseProbeSchedules(setupConfig); err != nil {
+		logger.Fatalf("Invalid probe schedule - Error: %+v", err)
+	}
+
+	// validate the peering rules
+	for _, p := range setupConfig.Peering {
+		if _, err := ParsePeeringRule(p); err != nil {
+			logger.Fatalf("Invalid peering rule - Error: %+v", err)
+		}
+	}
+
+	// validate the readiness policy
+	if _, err := newReadinessPolicy(setupConfig); err != nil {
+		logger.Fatalf("Invalid readiness policy - Error: %+v", err)
+	}
+
+	// validate sample aggregation
+	if setupConfig.AggregationOnly && setupConfig.AggregationWindow <= 0 {
+		logger.Fatal("Aggregation only is set, but no aggregation window - please set an aggregation window")
+	}
+
+	// validate the aggregator
+	for _, name := range setupConfig.AggregatorExcludeSamples {
+		if _, ok := data.SampleKey(name); !ok {
+			logger.Fatalf("Unknown sample type %v to exclude from the aggregator, see /api/v1/sample-types", name)
+		}
+	}
+	if setupConfig.AggregatorMaxSeries < 0 {
+		logger.Fatal("Aggregator max. series has to be positive")
+	}
+	// validate the static metric labels & the probe group
+	metricLabels, err := setupConfig.metricLabels()
+	if err != nil {
+		logger.Fatalf("Invalid metric labels - Error: %+v", err)
+	}
+	if err := metric.InitMetrics().SetConstLabels(metricLabels); err != nil {
+		logger.Fatalf("Invalid metric labels - Error: %+v", err)
+	}
+	// validate the exported sample types
+	for _, name := range setupConfig.ExportSamples {
+		if _, ok := data.SampleKey(name); !ok {
+			logger.Fatalf("Unknown sample type %v to export as metric, see /api/v1/sample-types", name)
+		}
+	}
+	// validate the export units
+	if _, err := setupConfig.metricUnits(); err != nil {
+		logger.Fatalf("Invalid metric units - Error: %+v, see /api/v1/sample-types", err)
+	}
+	if _, err := setupConfig.apiUnits(); err != nil {
+		logger.Fatalf("Invalid API units - Error: %+v, see /api/v1/sample-types", err)
+	}
+	// validate the sample rounding
+	if _, err := data.NewSampleRounding(setupConfig.SampleRounding); err != nil {
+		logger.Fatalf("Invalid sample rounding - Error: %+v, see /api/v1/sample-types", err)
+	}
+	// validate the sample history
+	if _, err := data.NewSampleHistory(setupConfig.SampleHistory); err != nil {
+		logger.Fatalf("Invalid sample history - Error: %+v, see /api/v1/sample-types", err)
+	}
+	if setupConfig.Aggregator && len(setupConfig.AcceptSamples) > 0 {
+		logger.Warn("Aggregator accepts just a part of the sample types - not accepted samples are not exported")
+	}
+
+	if setupConfig.RttAnomalyThreshold != 0 {
+		if err := validateRttAnomaly(setupConfig.RttAnomalyAlpha, setupConfig.RttAnomalyThreshold, setupConfig.RttAnomalyWarmup, setupConfig.RttAnomalyMinDeviation); err != nil {
+			logger.Fatalf("Invalid RTT anomaly configuration - Error: %+v", err)
+		}
+	}
+
+	// validate the accepted sample types
+	for _, name := range setupConfig.AcceptSamples {
+		if _, ok := data.SampleKey(name); !ok {
+			logger.Fatalf("Unknown sample type %v to accept, see /api/v1/sample-types", name)
+		}
+	}
+
+	// validate the DNS cache
+	if setupConfig.DnsCacheTTL < 0 || setupConfig.DnsCacheGrace < 0 {
+		logger.Fatal("DNS cache TTL and grace period have to be positive")
+	}
+
+	// validate the sample spill
+	if setupConfig.SampleSpillPath != "" && setupConfig.SampleSpillThreshold <= 0 {
+		logger.Fatal("Sample spill threshold has to be greater than 0")
+	}
+
+	// validate the digest sync
+	if setupConfig.DigestSync && (setupConfig.DigestFullSyncRatio < 0 || setupConfig.DigestFullSyncRatio > 1) {
+		logger.Fatal("Digest full sync ratio has to be 0-1")
+	}
+
+	// validate the listener
+	if setupConfig.ListenBacklog < 0 {
+		logger.Fatal("Listen backlog has to be positive")
+	}
+	if setupConfig.ListenSockets > 1 && !setupConfig.ReusePort {
+		logger.Warn("Multiple listen sockets need SO_REUSEPORT - just one socket is used")
+	}
+	if setupConfig.MaxInboundStreams < 0 || setupConfig.MaxInboundConnections < 0 {
+		logger.Fatal("Max. inbound streams & connections have to be positive")
+	}
+
+	// validate the one-way delay
+	if setupConfig.OneWayDelay && setupConfig.OneWayDelayMaxSkew <= 0 {
+		logger.Fatal("One-way delay max. skew has to be greater than 0")
+	}
+
+	// validate the throughput probe
+	if setupConfig.ThroughputInterval < 0 {
+		logger.Fatal("Throughput interval has to be positive")
+	}
+	if setupConfig.ThroughputInterval > 0 && (setupConfig.ThroughputVolume < MIN_THROUGHPUT_VOLUME || setupConfig.ThroughputVolume > MAX_THROUGHPUT_VOLUME) {
+		logger.Fatalf("Throughput volume has to be %v-%v bytes", MIN_THROUGHPUT_VOLUME, MAX_THROUGHPUT_VOLUME)
+	}
+
+	// validate the join coalesce window
+	if setupConfig.JoinCoalesceWindow < 0 {
+		logger.Fatal("Join coalesce window has to be positive")
+	}
+
+	// validate the reaction to name collisions
+	if setupConfig.NameCollisionLimit < 0 {
+		logger.Fatal("Name collision limit has to be positive")
+	}
+	if setupConfig.NameCollisionAction != "" && setupConfig.NameCollisionAction != NAME_COLLISION_EXIT && setupConfig.NameCollisionAction != NAME_COLLISION_SUFFIX {
+		logger.Fatalf("Unknown name collision action %v, please use exit or suffix", setupConfig.NameCollisionAction)
+	}
+
+	// validate the client idle timeout
+	if setupConfig.ClientIdleTimeout < 0 {
+		logger.Fatal("Client idle timeout has to be positive")
+	}
+
+	// validate the event log size
+	if setupConfig.EventLogSize < 0 {
+		logger.Fatal("Event log size has to be positive")
+	}
+
+	// validate the probe pools
+	if err := validateProbePools(setupConfig.ProbePools); err != nil {
+		logger.Fatalf("Invalid probe pool configuration - Error: %+v", err)
+	}
+
+	// validate DSCP values
+	if err := validateDscp(setupConfig.Dscp); err != nil {
+		logger.Fatalf("Invalid DSCP configuration - Error: %+v", err)
+	}
+
+	// validate the socket buffer sizes
+	if err := validateSocketBuffers(setupConfig.SocketSendBuffer, setupConfig.SocketRecvBuffer); err != nil {
+		logger.Fatalf("Invalid socket buffer configuration - Error: %+v", err)
+	}
+
+	// validate the outbound local address, an interface is resolved to its IP
+	if setupConfig.LocalAddress != "" {
+		ip, err := h.LocalIP(setupConfig.LocalAddress)
+		if err != nil {
+			logger.Fatalf("Invalid local address - Error: %+v", err)
+		}
+		logger.Infow("Binding outbound connections to local address", "address", ip.String())
+		setupConfig.LocalAddress = ip.String()
 	}
 
 	// validate if name is set
@@ -168,7 +830,23 @@ func (setupConfig *SetupConfiguration) checkDefaults(logger *zap.SugaredLogger)
 	}
 
 	// validate if target(s) is/are set
-	if len(setupConfig.Targets) == 0 {
+	if setupConfig.TargetK8sService != "" {
+		if _, err := parseK8sService(setupConfig.TargetK8sService); err != nil {
+			logger.Fatalf("Invalid Kubernetes service - Error: %+v", err)
+		}
+	}
+	if setupConfig.TargetShuffle != TARGET_SHUFFLE_NONE && setupConfig.TargetShuffle != TARGET_SHUFFLE_RANDOM && setupConfig.TargetShuffle != TARGET_SHUFFLE_NAME {
+		logger.Fatalf("Unknown target shuffle %v, please use none, random or name", setupConfig.TargetShuffle)
+	}
+	if setupConfig.DiscoveryMulticast != "" {
+		if _, err := parseMulticastGroup(setupConfig.DiscoveryMulticast); err != nil {
+			logger.Fatalf("Invalid multicast discovery - Error: %+v", err)
+		}
+		if setupConfig.DiscoveryMulticastInterval <= 0 || setupConfig.DiscoveryMulticastTimeout < 0 {
+			logger.Fatal("The multicast discovery interval has to be positive & the timeout must not be negative")
+		}
+	}
+	if len(setupConfig.Targets) == 0 && setupConfig.TargetSrv == "" && setupConfig.TargetK8sService == "" && setupConfig.DiscoveryMulticast == "" && !setupConfig.DisableMesh {
 		logger.Fatal("No target(s) set, please set to join a (future) mesh")
 	}
 }
@@ -0,0 +1,87 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"reflect"
+	"testing"
+
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+)
+
+func Test_metricLabels(t *testing.T) {
+	tests := []struct {
+		name         string
+		metricLabels map[string]string
+		probeGroup   string
+		expected     map[string]string
+		wantErr      bool
+	}{
+		{name: "no labels", expected: nil},
+		{name: "metric labels", metricLabels: map[string]string{"dc": "dc1"}, expected: map[string]string{"dc": "dc1"}},
+		{name: "probe group", metricLabels: map[string]string{"dc": "dc1"}, probeGroup: "edge", expected: map[string]string{"dc": "dc1", "group": "edge"}},
+		{name: "group label collides", metricLabels: map[string]string{"group": "a"}, probeGroup: "edge", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig := &SetupConfiguration{MetricLabels: tt.metricLabels, ProbeGroup: tt.probeGroup}
+			labels, err := setupConfig.metricLabels()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("metricLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(labels, tt.expected) {
+				t.Errorf("metricLabels() = %v, expected %v", labels, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_advertiseDefault(t *testing.T) {
+	tests := []struct {
+		name             string
+		listenAddress    string
+		joinAddress      string
+		advertiseAddress string
+		expected         string
+	}{
+		{name: "wildcard listen address", listenAddress: "0.0.0.0", joinAddress: "node-1.example.com:8081", expected: "node-1.example.com:8081"},
+		{name: "join address without port", listenAddress: "::", joinAddress: "node-1.example.com", expected: "node-1.example.com:8081"},
+		{name: "IPv6 join address", listenAddress: "::", joinAddress: "[fd00::1]:8080", expected: "[fd00::1]:8080"},
+		{name: "advertise address behind NAT", listenAddress: "0.0.0.0", joinAddress: "10.0.0.1:8081", advertiseAddress: "203.0.113.1", expected: "203.0.113.1:8081"},
+		{name: "unix domain socket", listenAddress: "unix:///tmp/cbot.sock", expected: "unix:///tmp/cbot.sock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupConfig := &SetupConfiguration{ListenAddress: tt.listenAddress, ListenPort: 8081, JoinAddress: tt.joinAddress, AdvertiseAddress: tt.advertiseAddress, Tokens: []string{"token"}}
+			setupConfig.setDefaults(zap.NewNop().Sugar())
+			if target := setupConfig.advertiseTarget(); target != tt.expected {
+				t.Errorf("advertiseTarget() = %v, expected %v", target, tt.expected)
+			}
+			// the node id derives from the advertised target
+			m := &Mesh{setupConfig: setupConfig}
+			if id := m.nodeId(); id != GetId(&meshv1.Node{Target: tt.expected}) {
+				t.Errorf("nodeId() = %v, expected the id of %v", id, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Log fields of a failed RPC: method, status code, elapsed time and
+// the effective deadline relative to the start of the RPC.
+// The deadline is missing if the context has none, the peer
+// address of an inbound RPC is added if known.
+func rpcFailureFields(ctx context.Context, method string, start time.Time, err error) []interface{} {
+	fields := []interface{}{
+		"method", method,
+		"code", status.Code(err).String(),
+		"elapsed", time.Since(start).String(),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, "peer", p.Addr.String())
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		fields = append(fields, "deadline", deadline.Sub(start).String())
+	}
+	return append(fields, "error", err)
+}
+
+// Server interceptor logging failed RPCs with the deadline set by the client.
+// An elapsed time below the deadline points to the network or the client,
+// an elapsed time at the deadline to a slow handler.
+func (m *Mesh) deadlineInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if err != nil {
+		m.logger.Named("server").Infow("RPC failed", rpcFailureFields(ctx, info.FullMethod, start, err)...)
+	}
+	return resp, err
+}
@@ -0,0 +1,146 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Test mesh with observed logs
+func testMeshObserved(requestTimeout time.Duration) (*Mesh, *observer.ObservedLogs) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	m := testMesh(requestTimeout)
+	m.logger = zap.New(core).Sugar()
+	return m, logs
+}
+
+func Test_timeoutInterceptorLogsDeadline(t *testing.T) {
+	tests := []struct {
+		name           string
+		parentTimeout  time.Duration
+		expectedSource string
+	}{
+		{name: "request timeout", parentTimeout: time.Minute, expectedSource: "request-timeout"},
+		{name: "earlier parent deadline", parentTimeout: 10 * time.Millisecond, expectedSource: "parent-context"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, logs := testMeshObserved(50 * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), tt.parentTimeout)
+			defer cancel()
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				<-ctx.Done()
+				return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+			}
+			if err := m.timeoutInterceptor(ctx, "/mesh.v1.MeshService/Ping", nil, nil, nil, invoker); err == nil {
+				t.Fatal("expected an error")
+			}
+
+			entries := logs.FilterMessage("RPC failed").All()
+			if len(entries) != 1 {
+				t.Fatalf("got %v failed RPC logs, expected 1", len(entries))
+			}
+			fields := entries[0].ContextMap()
+			if fields["method"] != "/mesh.v1.MeshService/Ping" || fields["code"] != "DeadlineExceeded" {
+				t.Errorf("unexpected log fields %v", fields)
+			}
+			if fields["deadlineSource"] != tt.expectedSource {
+				t.Errorf("got deadline source %v, expected %v", fields["deadlineSource"], tt.expectedSource)
+			}
+			if _, ok := fields["deadline"]; !ok {
+				t.Error("deadline is not logged")
+			}
+			if _, ok := fields["elapsed"]; !ok {
+				t.Error("elapsed time is not logged")
+			}
+		})
+	}
+}
+
+func Test_rpcTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		probeTimeout   time.Duration
+		method         string
+		expected       time.Duration
+		expectedSource string
+	}{
+		{name: "ping without probe timeout", method: pingMethod, expected: time.Second, expectedSource: "request-timeout"},
+		{name: "ping", probeTimeout: 5 * time.Second, method: pingMethod, expected: 5 * time.Second, expectedSource: "probe-timeout"},
+		{name: "indirect ping", probeTimeout: 5 * time.Second, method: pingIndirectMethod, expected: 10 * time.Second, expectedSource: "probe-timeout"},
+		{name: "other RPC", probeTimeout: 5 * time.Second, method: "/mesh.v1.MeshService/PushSamples", expected: time.Second, expectedSource: "request-timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMesh(time.Second)
+			m.routineConfig.NodeStates.ProbeTimeout = tt.probeTimeout
+			timeout, source := m.rpcTimeout(tt.method)
+			if timeout != tt.expected || source != tt.expectedSource {
+				t.Errorf("got timeout %v of %v, expected %v of %v", timeout, source, tt.expected, tt.expectedSource)
+			}
+		})
+	}
+}
+
+func Test_deadlineInterceptor(t *testing.T) {
+	m, logs := testMeshObserved(time.Second)
+	info := &grpc.UnaryServerInfo{FullMethod: "/mesh.v1.MeshService/PushSamples"}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	if _, err := m.deadlineInterceptor(ctx, nil, info, ok); err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("successful RPC is logged: %v", logs.All())
+	}
+
+	failed := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	if _, err := m.deadlineInterceptor(ctx, nil, info, failed); err == nil {
+		t.Fatal("expected an error")
+	}
+	entries := logs.FilterMessage("RPC failed").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %v failed RPC logs, expected 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["method"] != info.FullMethod || fields["code"] != "Unavailable" {
+		t.Errorf("unexpected log fields %v", fields)
+	}
+	if _, ok := fields["deadline"]; !ok {
+		t.Error("deadline is not logged")
+	}
+}
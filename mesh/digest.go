@@ -0,0 +1,136 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Sync the samples with a node by the digest of the sample stores (anti-entropy).
+// Just the samples of differing buckets are compared, newer samples are pushed
+// to the node and newer samples of the node are fetched. All samples are pushed
+// if the digests differ in more buckets than the full sync ratio, the node
+// does not support digests or does not authorize this node. The digest RPCs
+// are authorized by the mesh token or the first API token, shared by the mesh.
+func (m *Mesh) syncSamples(node *meshv1.Node) error {
+	log := m.logger.Named("sample-routine")
+	m.acquireProbe(PROBE_POOL_PUSH)
+	defer m.releaseProbe(PROBE_POOL_PUSH)
+	c, err := m.initClient(node)
+	if err != nil {
+		log.Debugw("Could not connect to client")
+		return fmt.Errorf("sync samples with %v: %w", node.Target, err)
+	}
+	client := c.client
+
+	ctx := context.Background()
+	if len(m.setupConfig.Tokens) > 0 {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+m.setupConfig.Tokens[0])
+	}
+	res, err := client.SampleDigest(ctx, &meshv1.SampleDigestRequest{})
+	if code := status.Code(err); code == codes.Unimplemented || code == codes.Unauthenticated {
+		log.Debugw("Node does not support or authorize digests - push all samples", "node", node.Name, "code", code.String())
+		m.metrics.GetSampleSyncs().WithLabelValues(metric.SAMPLE_SYNC_FULL).Inc()
+		return m.sendSamples(client, node, m.database.GetSampleList())
+	}
+	if err != nil {
+		return fmt.Errorf("sync samples with %v: %w", node.Target, classifyError(err))
+	}
+
+	buckets := data.DigestDiff(m.database.GetSampleDigest(), res.BucketHashes)
+	if len(buckets) == 0 && len(res.BucketHashes) == data.DIGEST_BUCKETS {
+		log.Debugw("Samples in sync", "node", node.Name)
+		m.metrics.GetSampleSyncs().WithLabelValues(metric.SAMPLE_SYNC_IN_SYNC).Inc()
+		m.database.SetNodeLastSeen(GetId(node))
+		return nil
+	}
+	// massive divergence, e.g. a new node
+	if float64(len(buckets)) > m.setupConfig.DigestFullSyncRatio*data.DIGEST_BUCKETS {
+		log.Debugw("Digests diverge - push all samples", "node", node.Name, "buckets", len(buckets))
+		m.metrics.GetSampleSyncs().WithLabelValues(metric.SAMPLE_SYNC_FULL).Inc()
+		return m.sendSamples(client, node, m.database.GetSampleList())
+	}
+
+	res, err = client.SampleDigest(ctx, &meshv1.SampleDigestRequest{Buckets: buckets})
+	if err != nil {
+		return fmt.Errorf("sync samples with %v: %w", node.Target, classifyError(err))
+	}
+	m.metrics.GetSampleSyncs().WithLabelValues(metric.SAMPLE_SYNC_DIGEST).Inc()
+	push, fetch := diffDigestEntries(m.database.GetDigestBucketSamples(buckets), res.Entries)
+	log.Debugw("Syncing differing samples", "node", node.Name, "buckets", len(buckets), "push", len(push), "fetch", len(fetch))
+
+	if err := m.sendSamples(client, node, push); err != nil {
+		return err
+	}
+	if len(fetch) == 0 {
+		return nil
+	}
+	if len(fetch) > MAX_FETCH_SAMPLES {
+		fetch = fetch[:MAX_FETCH_SAMPLES]
+	}
+	samples, err := client.FetchSamples(ctx, &meshv1.FetchSamplesRequest{Ids: fetch})
+	if err != nil {
+		return fmt.Errorf("fetch samples from %v: %w", node.Target, classifyError(err))
+	}
+	// samples that reached the max. hops are not forwarded anymore
+	var fetched []*meshv1.Sample
+	for _, sample := range samples.Samples {
+		if m.setupConfig.MaxHops == 0 || sample.Hops < m.setupConfig.MaxHops {
+			fetched = append(fetched, sample)
+		}
+	}
+	storeSamples(&m.database, m.metrics, m.setupConfig.sampleFilter(), fetched)
+	m.database.SetNodeLastSeen(GetId(node))
+	return nil
+}
+
+// Compare the local samples of the differing buckets with the entries of a node.
+// The samples newer than the entries are pushed, the ids of newer entries fetched.
+func diffDigestEntries(samples []*data.Sample, entries []*meshv1.SampleDigestEntry) ([]*data.Sample, []uint32) {
+	remote := make(map[uint32]int64, len(entries))
+	for _, entry := range entries {
+		remote[entry.Id] = entry.Ts
+	}
+	local := make(map[uint32]int64, len(samples))
+	var push []*data.Sample
+	for _, sample := range samples {
+		local[sample.Id] = sample.Ts
+		if sample.Ts > remote[sample.Id] {
+			push = append(push, sample)
+		}
+	}
+	var fetch []uint32
+	for _, entry := range entries {
+		if entry.Ts > local[entry.Id] {
+			fetch = append(fetch, entry.Id)
+		}
+	}
+	return push, fetch
+}
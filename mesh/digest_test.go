@@ -0,0 +1,129 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+func Test_diffDigestEntries(t *testing.T) {
+	samples := []*data.Sample{
+		{Id: 1, Ts: 2},
+		{Id: 2, Ts: 1},
+		{Id: 3, Ts: 1},
+	}
+	entries := []*meshv1.SampleDigestEntry{
+		{Id: 1, Ts: 1},
+		{Id: 2, Ts: 2},
+		{Id: 3, Ts: 1},
+		{Id: 4, Ts: 1},
+	}
+	push, fetch := diffDigestEntries(samples, entries)
+	if len(push) != 1 || push[0].Id != 1 {
+		t.Errorf("Expected to push sample 1, got %+v", push)
+	}
+	if len(fetch) != 2 || fetch[0] != 2 || fetch[1] != 4 {
+		t.Errorf("Expected to fetch samples 2 & 4, got %v", fetch)
+	}
+}
+
+func Test_syncSamples(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(grpcServer, &MeshServer{metrics: metric.InitMetrics(), log: zap.NewNop().Sugar(), data: &remote, tokens: []string{"secret"}})
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+	node := &meshv1.Node{Name: "remote", Target: lis.Addr().String()}
+
+	m := testMesh(time.Second)
+	m.database, err = data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.setupConfig.DigestSync = true
+	m.setupConfig.Tokens = []string{"secret"}
+	m.setupConfig.DigestFullSyncRatio = 0.5
+
+	// diverging stores are synced fully
+	for i := 0; i < 1000; i++ {
+		m.database.SetSample(&data.Sample{From: "a", To: "node_" + strconv.Itoa(i), Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	}
+	if err := m.syncSamples(node); err != nil {
+		t.Fatalf("could not sync: %v", err)
+	}
+	if count := len(remote.GetSampleList()); count != 1000 {
+		t.Errorf("Expected all 1000 samples to be pushed, got %v", count)
+	}
+
+	// just the newer samples are pushed and fetched
+	local := &data.Sample{From: "a", To: "node_1", Key: data.RTT_TOTAL, Value: "2", Ts: 2}
+	m.database.SetSample(local)
+	newer := &data.Sample{From: "a", To: "node_2", Key: data.RTT_TOTAL, Value: "3", Ts: 3}
+	remote.SetSample(newer)
+	if err := m.syncSamples(node); err != nil {
+		t.Fatalf("could not sync: %v", err)
+	}
+	if sample := remote.GetSample(local.Id); sample.Ts != 2 {
+		t.Errorf("Expected the newer local sample to be pushed, got %+v", sample)
+	}
+	if sample := m.database.GetSample(newer.Id); sample.Ts != 3 || sample.Hops != 1 {
+		t.Errorf("Expected the newer remote sample to be fetched, got %+v", sample)
+	}
+
+	if diff := data.DigestDiff(m.database.GetSampleDigest(), remote.GetSampleDigest()); len(diff) != 0 {
+		t.Errorf("Expected synced stores, got differing buckets %v", diff)
+	}
+	if err := m.syncSamples(node); err != nil {
+		t.Fatalf("could not sync: %v", err)
+	}
+
+	// a node not authorized for digests pushes all samples and fetches none
+	m.setupConfig.Tokens = []string{"other"}
+	unauthorized := &data.Sample{From: "a", To: "node_3", Key: data.RTT_TOTAL, Value: "2", Ts: 2}
+	m.database.SetSample(unauthorized)
+	remote.SetSample(&data.Sample{From: "a", To: "node_4", Key: data.RTT_TOTAL, Value: "2", Ts: 2})
+	if err := m.syncSamples(node); err != nil {
+		t.Fatalf("could not sync: %v", err)
+	}
+	if sample := remote.GetSample(unauthorized.Id); sample.Ts != 2 {
+		t.Errorf("Expected the samples to be pushed, got %+v", sample)
+	}
+	if sample := m.database.GetSample(GetSampleId(&meshv1.Sample{From: "a", To: "node_4", Key: data.RTT_TOTAL})); sample.Ts != 1 {
+		t.Errorf("Expected no sample to be fetched, got %+v", sample)
+	}
+}
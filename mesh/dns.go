@@ -0,0 +1,146 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Results of a DNS cache lookup
+const (
+	DNS_CACHE_HIT   = "hit"
+	DNS_CACHE_MISS  = "miss"
+	DNS_CACHE_STALE = "stale"
+	DNS_CACHE_ERROR = "error"
+)
+
+// In-process DNS cache of the hostnames dialed by the mesh connections,
+// the probes resolve by the OS resolver.
+// The OS resolver does not expose the record TTL, so the
+// answers are cached for the configured TTL. If the resolution
+// fails, the last good answer is served within the grace period.
+// Concurrent lookups of a hostname share one resolution.
+type dnsCache struct {
+	ttl      time.Duration
+	grace    time.Duration
+	lookup   func(ctx context.Context, host string) ([]string, error)
+	mu       sync.Mutex
+	entries  map[string]dnsEntry
+	inflight map[string]*dnsCall
+}
+
+type dnsEntry struct {
+	addrs    []string
+	resolved time.Time
+}
+
+// Resolution of a hostname in flight, done is closed when resolved
+type dnsCall struct {
+	done  chan struct{}
+	addrs []string
+	err   error
+}
+
+// Create a DNS cache resolving by the OS resolver, nil if the TTL is 0
+func newDnsCache(ttl time.Duration, grace time.Duration) *dnsCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &dnsCache{ttl: ttl, grace: grace, lookup: net.DefaultResolver.LookupHost, entries: map[string]dnsEntry{}, inflight: map[string]*dnsCall{}}
+}
+
+// Resolve a hostname to its addresses by the cache.
+// The result is a hit, a miss (resolved), stale (last good answer)
+// or an error (resolution failed without a last good answer).
+func (c *dnsCache) resolve(ctx context.Context, host string, now time.Time) ([]string, string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if ok && now.Sub(entry.resolved) < c.ttl {
+		c.mu.Unlock()
+		return entry.addrs, DNS_CACHE_HIT, nil
+	}
+	call, shared := c.inflight[host]
+	if !shared {
+		call = &dnsCall{done: make(chan struct{})}
+		c.inflight[host] = call
+	}
+	c.mu.Unlock()
+
+	if shared {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, DNS_CACHE_ERROR, ctx.Err()
+		}
+	} else {
+		call.addrs, call.err = c.lookup(ctx, host)
+		if call.err == nil && len(call.addrs) == 0 {
+			call.err = errors.New("no addresses found for " + host)
+		}
+		c.mu.Lock()
+		if call.err == nil {
+			c.entries[host] = dnsEntry{addrs: call.addrs, resolved: now}
+		}
+		delete(c.inflight, host)
+		c.mu.Unlock()
+		close(call.done)
+	}
+
+	if call.err == nil {
+		return call.addrs, DNS_CACHE_MISS, nil
+	}
+	if ok && now.Sub(entry.resolved) < c.ttl+c.grace {
+		return entry.addrs, DNS_CACHE_STALE, nil
+	}
+	return nil, DNS_CACHE_ERROR, call.err
+}
+
+// Dial an address by the DNS cache, the resolved addresses are tried in order.
+// IP addresses are dialed directly.
+func (m *Mesh) dialCached(ctx context.Context, d *socketDialer, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.DialContext(ctx, "tcp", addr)
+	}
+
+	addrs, result, err := m.dnsCache.resolve(ctx, host, time.Now())
+	m.metrics.GetDnsCacheLookups().WithLabelValues(result).Inc()
+	if err != nil {
+		return nil, err
+	}
+	if result == DNS_CACHE_STALE {
+		m.logger.Named("dns").Warnw("Could not resolve host - using last good answer", "host", host)
+	}
+
+	var conn net.Conn
+	for _, ip := range addrs {
+		conn, err = d.DialContext(ctx, "tcp", net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,130 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_dnsCache(t *testing.T) {
+	lookups := 0
+	fail := false
+	cache := newDnsCache(time.Minute, time.Minute)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if fail {
+			return nil, errors.New("resolution failed")
+		}
+		return []string{"10.0.0.1"}, nil
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name      string
+		at        time.Duration
+		fail      bool
+		expected  string
+		expectErr bool
+		lookups   int
+	}{
+		{name: "first lookup", at: 0, expected: DNS_CACHE_MISS, lookups: 1},
+		{name: "cached", at: 30 * time.Second, expected: DNS_CACHE_HIT, lookups: 1},
+		{name: "expired", at: 61 * time.Second, expected: DNS_CACHE_MISS, lookups: 2},
+		{name: "failed within grace", at: 150 * time.Second, fail: true, expected: DNS_CACHE_STALE, lookups: 3},
+		{name: "failed after grace", at: 200 * time.Second, fail: true, expected: DNS_CACHE_ERROR, expectErr: true, lookups: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fail = tt.fail
+			addrs, result, err := cache.resolve(context.Background(), "node", now.Add(tt.at))
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if result != tt.expected || lookups != tt.lookups {
+				t.Errorf("got result %v after %v lookups, expected %v after %v", result, lookups, tt.expected, tt.lookups)
+			}
+			if !tt.expectErr && (len(addrs) != 1 || addrs[0] != "10.0.0.1") {
+				t.Errorf("got addresses %v", addrs)
+			}
+		})
+	}
+}
+
+func Test_dnsCacheSharedLookup(t *testing.T) {
+	var mu sync.Mutex
+	lookups := 0
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := newDnsCache(time.Minute, time.Minute)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		lookups++
+		mu.Unlock()
+		close(started)
+		<-release
+		return []string{"10.0.0.1"}, nil
+	}
+
+	const callers = 5
+	var wg sync.WaitGroup
+	results := make(chan string, callers)
+	resolve := func() {
+		defer wg.Done()
+		addrs, result, err := cache.resolve(context.Background(), "node", time.Now())
+		if err != nil || len(addrs) != 1 {
+			t.Errorf("got addresses %v and error %v", addrs, err)
+		}
+		results <- result
+	}
+	wg.Add(1)
+	go resolve()
+	<-started
+	for i := 1; i < callers; i++ {
+		wg.Add(1)
+		go resolve()
+	}
+	// let the callers join the lookup in flight, late callers hit the cache
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if lookups != 1 {
+		t.Errorf("got %v lookups, expected 1 shared lookup", lookups)
+	}
+	for result := range results {
+		if result != DNS_CACHE_MISS && result != DNS_CACHE_HIT {
+			t.Errorf("got result %v", result)
+		}
+	}
+}
+
+func Test_newDnsCacheDisabled(t *testing.T) {
+	if cache := newDnsCache(0, time.Minute); cache != nil {
+		t.Error("expected no DNS cache with a TTL of 0")
+	}
+}
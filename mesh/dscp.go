@@ -0,0 +1,100 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	h "github.com/telekom/canary-bot/helper"
+
+	"go.uber.org/zap"
+)
+
+// Traffic types that can be marked with a DSCP value
+const (
+	DSCP_MESH       = "mesh"
+	DSCP_RTT        = "rtt"
+	DSCP_THROUGHPUT = "throughput"
+)
+
+// Validate the DSCP configuration. Keys are the traffic types
+// mesh, rtt and the external probe types, values 0-63.
+func validateDscp(dscp map[string]int) error {
+	for t, v := range dscp {
+		if _, ok := probeSampleKeys[t]; !ok && t != DSCP_MESH && t != DSCP_RTT && t != DSCP_THROUGHPUT {
+			return fmt.Errorf("unknown DSCP traffic type %v", t)
+		}
+		if v < 0 || v > 63 {
+			return fmt.Errorf("invalid DSCP value %v of %v, has to be 0-63", v, t)
+		}
+	}
+	return nil
+}
+
+// Dialer control to mark the connection with a DSCP value.
+// If marking is not supported, the connection stays unmarked.
+func dscpControl(dscp int, log *zap.SugaredLogger) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if err := setDscp(c, network, dscp); err != nil {
+			log.Warnw("Could not set DSCP - connection is unmarked", "dscp", dscp, "address", address, "error", err)
+		}
+		return nil
+	}
+}
+
+// Dialer of a traffic type, marks connections if a DSCP value is configured.
+// Connections originate from the local address if set and are tuned
+// by the TCP_NODELAY & socket buffer settings.
+func (m *Mesh) dialer(trafficType string) *socketDialer {
+	d := &socketDialer{noDelay: m.setupConfig.TcpNoDelay, log: m.logger.Named("socket")}
+	if ip := net.ParseIP(m.setupConfig.LocalAddress); ip != nil {
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	var controls []func(network, address string, c syscall.RawConn) error
+	if dscp, ok := m.setupConfig.Dscp[trafficType]; ok {
+		controls = append(controls, dscpControl(dscp, m.logger.Named("dscp")))
+	}
+	if m.setupConfig.SocketSendBuffer > 0 || m.setupConfig.SocketRecvBuffer > 0 {
+		controls = append(controls, bufferControl(m.setupConfig.SocketSendBuffer, m.setupConfig.SocketRecvBuffer, d.log))
+	}
+	d.Control = chainControls(controls...)
+	return d
+}
+
+// gRPC context dialer of a traffic type
+func (m *Mesh) grpcDialer(trafficType string) func(ctx context.Context, addr string) (net.Conn, error) {
+	d := m.dialer(trafficType)
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		// unix domain sockets are not marked
+		if path, ok := h.UnixSocketPath(addr); ok {
+			var unixDialer net.Dialer
+			return unixDialer.DialContext(ctx, "unix", path)
+		}
+		if m.dnsCache != nil {
+			return m.dialCached(ctx, d, addr)
+		}
+		return d.DialContext(ctx, "tcp", addr)
+	}
+}
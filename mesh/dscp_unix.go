@@ -0,0 +1,45 @@
+//go:build linux || darwin || freebsd
+
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"strings"
+	"syscall"
+)
+
+// Set the DSCP value as TOS (IPv4) or traffic class (IPv6) of the socket
+func setDscp(c syscall.RawConn, network string, dscp int) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+			return
+		}
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
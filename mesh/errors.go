@@ -0,0 +1,84 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sentinel errors of the mesh client, use with errors.Is
+var (
+	ErrDial       = errors.New("dial failed")
+	ErrJoinFailed = errors.New("join failed")
+	ErrTimeout    = errors.New("request timeout")
+	// Protocol version of the node is not supported
+	ErrIncompatible = errors.New("incompatible protocol version")
+)
+
+// clientError classifies an error of a client request
+// by one or more sentinel errors and keeps the original error.
+type clientError struct {
+	kinds []error
+	err   error
+}
+
+func (e *clientError) Error() string {
+	var msg []string
+	for _, kind := range e.kinds {
+		msg = append(msg, kind.Error())
+	}
+	return strings.Join(msg, ": ") + ": " + e.err.Error()
+}
+
+// Is matches all sentinel errors of the client error
+func (e *clientError) Is(target error) bool {
+	for _, kind := range e.kinds {
+		if target == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *clientError) Unwrap() error {
+	return e.err
+}
+
+// Classify an error of a client request by the given sentinel errors.
+// Exceeded deadlines will be classified as ErrTimeout.
+func classifyError(err error, kinds ...error) error {
+	if err == nil {
+		return nil
+	}
+	if status.Code(err) == codes.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		kinds = append(kinds, ErrTimeout)
+	}
+	if len(kinds) == 0 {
+		return err
+	}
+	return &clientError{kinds: kinds, err: err}
+}
@@ -0,0 +1,131 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testMesh(requestTimeout time.Duration) *Mesh {
+	return &Mesh{
+		metrics:       metric.InitMetrics(),
+		logger:        zap.NewNop().Sugar(),
+		routineConfig: &RoutineConfiguration{RequestTimeout: requestTimeout},
+		setupConfig:   &SetupConfiguration{Name: "test", JoinAddress: "localhost:0", TcpNoDelay: true},
+		name:          newNodeName("test"),
+		clients:       map[uint32]*MeshClient{},
+		probeSlots:    make(chan struct{}, 1),
+		receipts:      &receiptLog{},
+		legacySenders: &legacySenders{},
+	}
+}
+
+func Test_classifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		kinds    []error
+		expected []error
+		notIs    []error
+	}{
+		{name: "no error", err: nil, expected: nil},
+		{name: "dial error", err: errors.New("dial"), kinds: []error{ErrDial}, expected: []error{ErrDial}, notIs: []error{ErrTimeout}},
+		{name: "grpc deadline exceeded", err: status.Error(codes.DeadlineExceeded, "deadline"), expected: []error{ErrTimeout}, notIs: []error{ErrDial}},
+		{name: "context deadline exceeded", err: context.DeadlineExceeded, kinds: []error{ErrJoinFailed}, expected: []error{ErrJoinFailed, ErrTimeout}},
+		{name: "unclassified error", err: status.Error(codes.Unavailable, "unavailable"), notIs: []error{ErrDial, ErrJoinFailed, ErrTimeout}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError(tt.err, tt.kinds...)
+			if tt.err == nil && err != nil {
+				t.Errorf("expected nil error, got %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("original error is not wrapped: %v", err)
+			}
+			for _, kind := range tt.expected {
+				if !errors.Is(err, kind) {
+					t.Errorf("error %v is not classified as %v", err, kind)
+				}
+			}
+			for _, kind := range tt.notIs {
+				if errors.Is(err, kind) {
+					t.Errorf("error %v is classified as %v", err, kind)
+				}
+			}
+		})
+	}
+}
+
+func Test_joinTargetFailed(t *testing.T) {
+	// listener is closed, the connection will be refused
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := lis.Addr().String()
+	lis.Close()
+
+	m := testMesh(time.Second)
+	_, err = m.joinTarget(context.Background(), &meshv1.Node{Target: target})
+	if !errors.Is(err, ErrJoinFailed) {
+		t.Errorf("error %v is not classified as ErrJoinFailed", err)
+	}
+	if errors.Is(err, ErrTimeout) {
+		t.Errorf("refused connection is classified as ErrTimeout: %v", err)
+	}
+}
+
+func Test_pingTimeout(t *testing.T) {
+	// listener accepts connections, but never answers
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	m := testMesh(100 * time.Millisecond)
+	err = m.ping(&meshv1.Node{Target: lis.Addr().String()})
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("error %v is not classified as ErrTimeout", err)
+	}
+}
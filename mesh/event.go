@@ -0,0 +1,48 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+)
+
+// Record a mesh event in the event log
+func (m *Mesh) recordEvent(eventType string, node string, message string) {
+	m.database.AddEvent(data.Event{
+		Ts:      time.Now().UnixNano(),
+		Type:    eventType,
+		Node:    node,
+		Message: message,
+	})
+}
+
+// Set the state of a node, a changed state is recorded as event
+func (m *Mesh) setNodeState(node *meshv1.Node, state int) {
+	if stored, ok := m.database.GetNode(GetId(node)); ok && stored.State != state {
+		m.recordEvent(data.EVENT_STATE_CHANGE, node.Name, fmt.Sprintf("%v -> %v", stateName(stored.State), stateName(state)))
+	}
+	m.database.SetNode(data.Convert(node, state))
+}
@@ -0,0 +1,129 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/telekom/canary-bot/data"
+	"google.golang.org/grpc/metadata"
+)
+
+// Tracer of the RTT requests, e.g. wrapping an OpenTelemetry tracer.
+// Start starts a span of an RTT request to a peer and returns the context
+// of the span with its W3C trace & span id in hex. The span is ended by
+// end with the result of the request. A span with invalid ids, e.g. of a
+// no-op tracer, is not propagated and not attached as exemplar.
+type RttTracer interface {
+	Start(ctx context.Context, peer string) (spanCtx context.Context, traceId string, spanId string, end func(err error))
+}
+
+// W3C trace & span ids, all-zero ids are invalid
+var (
+	traceIdPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	spanIdPattern  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// Trace context of an RTT measurement, sent as W3C traceparent
+// and linked to the RTT observations as exemplar
+type rttTrace struct {
+	traceId string
+	spanId  string
+	end     func(err error)
+}
+
+// Check if the ids of the trace are valid W3C ids
+func (t *rttTrace) valid() bool {
+	return t != nil &&
+		traceIdPattern.MatchString(t.traceId) && t.traceId != "00000000000000000000000000000000" &&
+		spanIdPattern.MatchString(t.spanId) && t.spanId != "0000000000000000"
+}
+
+// Outgoing context of the RTT request with the traceparent metadata
+func (t *rttTrace) context(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "traceparent", "00-"+t.traceId+"-"+t.spanId+"-01")
+}
+
+// End the span of the trace, nothing without trace
+func (t *rttTrace) finish(err error) {
+	if t != nil && t.end != nil {
+		t.end(err)
+	}
+}
+
+// Context of an RTT request, with a span of the tracer if set.
+// The trace is nil if the span is not valid.
+func (m *Mesh) rttContext(peer string) (context.Context, *rttTrace) {
+	if m.setupConfig.RttTracer == nil {
+		return context.Background(), nil
+	}
+	ctx, traceId, spanId, end := m.setupConfig.RttTracer.Start(context.Background(), peer)
+	trace := &rttTrace{traceId: traceId, spanId: spanId, end: end}
+	if !trace.valid() {
+		trace.finish(nil)
+		return ctx, nil
+	}
+	return trace.context(ctx), trace
+}
+
+// Log fields of a trace, empty without trace
+func traceFields(t *rttTrace) []interface{} {
+	if t == nil {
+		return nil
+	}
+	return []interface{}{"traceId", t.traceId, "spanId", t.spanId}
+}
+
+// Labels of the exemplar, the peer name is shortened to the
+// max. runes of the exemplar labels
+func (t *rttTrace) exemplar(peer string) prometheus.Labels {
+	labels := prometheus.Labels{"trace_id": t.traceId, "span_id": t.spanId}
+	max := prometheus.ExemplarMaxRunes - len("peer")
+	for name, value := range labels {
+		max -= utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+	}
+	if utf8.RuneCountInString(peer) > max {
+		peer = string([]rune(peer)[:max])
+	}
+	labels["peer"] = peer
+	return labels
+}
+
+// Observe an RTT measurement of a node by the sample key.
+// The trace is added as exemplar if set, exemplars are just exposed
+// to scrapers negotiating the OpenMetrics format.
+// Measurements of sample keys not exported as metrics are skipped.
+func (m *Mesh) observeRtt(key int64, node string, rtt time.Duration, trace *rttTrace) {
+	if !m.metrics.ExportsSample(key) {
+		return
+	}
+	observer := m.metrics.GetRttObserver(data.SampleName(key), node)
+	if trace == nil {
+		observer.Observe(rtt.Seconds())
+		return
+	}
+	observer.(prometheus.ExemplarObserver).ObserveWithExemplar(rtt.Seconds(), trace.exemplar(node))
+}
@@ -0,0 +1,127 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/telekom/canary-bot/data"
+	"google.golang.org/grpc/metadata"
+)
+
+// Tracer returning fixed ids, counting the ended spans
+type testTracer struct {
+	traceId string
+	spanId  string
+	ended   int
+}
+
+func (t *testTracer) Start(ctx context.Context, peer string) (context.Context, string, string, func(err error)) {
+	return ctx, t.traceId, t.spanId, func(err error) { t.ended++ }
+}
+
+func Test_rttContext(t *testing.T) {
+	tests := []struct {
+		name        string
+		tracer      *testTracer
+		traceparent string
+	}{
+		{name: "no tracer"},
+		{name: "valid span", tracer: &testTracer{traceId: "4bf92f3577b34da6a3ce929d0e0e4736", spanId: "00f067aa0ba902b7"}, traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "no-op span", tracer: &testTracer{traceId: "00000000000000000000000000000000", spanId: "0000000000000000"}},
+		{name: "malformed ids", tracer: &testTracer{traceId: "trace", spanId: "span"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMesh(time.Second)
+			if tt.tracer != nil {
+				m.setupConfig.RttTracer = tt.tracer
+			}
+			ctx, trace := m.rttContext("node-a")
+			md, _ := metadata.FromOutgoingContext(ctx)
+			if traceparent := strings.Join(md.Get("traceparent"), ","); traceparent != tt.traceparent {
+				t.Errorf("Expected traceparent %q, got %q", tt.traceparent, traceparent)
+			}
+			if (trace != nil) != (tt.traceparent != "") {
+				t.Errorf("Expected a trace %v, got %v", tt.traceparent != "", trace)
+			}
+			trace.finish(nil)
+			if tt.tracer != nil && tt.tracer.ended != 1 {
+				t.Errorf("Expected the span to be ended once, got %v", tt.tracer.ended)
+			}
+		})
+	}
+}
+
+func Test_rttTraceExemplar(t *testing.T) {
+	trace := &rttTrace{traceId: "4bf92f3577b34da6a3ce929d0e0e4736", spanId: "00f067aa0ba902b7"}
+
+	for _, peer := range []string{"node-a", strings.Repeat("ä", 200)} {
+		runes := 0
+		for name, value := range trace.exemplar(peer) {
+			runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+		}
+		if runes > prometheus.ExemplarMaxRunes {
+			t.Errorf("Exemplar of peer %v has %v runes, max. %v", peer, runes, prometheus.ExemplarMaxRunes)
+		}
+	}
+	if peer := trace.exemplar("node-a")["peer"]; peer != "node-a" {
+		t.Errorf("Expected peer node-a, got %v", peer)
+	}
+}
+
+func Test_observeRttExemplar(t *testing.T) {
+	m := testMesh(time.Second)
+	trace := &rttTrace{traceId: "4bf92f3577b34da6a3ce929d0e0e4736", spanId: "00f067aa0ba902b7"}
+	m.observeRtt(data.RTT_TOTAL, "node-a", 10*time.Millisecond, trace)
+
+	server := httptest.NewServer(promhttp.HandlerFor(m.metrics.GetRegistry(), promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	defer server.Close()
+	scrape := func(accept string) string {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if body := scrape("application/openmetrics-text; version=0.0.1"); !strings.Contains(body, `trace_id="`+trace.traceId+`"`) {
+		t.Errorf("Expected the exemplar in the OpenMetrics format, got %v", body)
+	}
+	if body := scrape("text/plain"); strings.Contains(body, "trace_id") {
+		t.Errorf("Expected no exemplar in the text format, got %v", body)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+)
+
+// Get the share of the healthy nodes whose samples are known by this node.
+// The ratio is 1 if the samples of all healthy nodes reached this node.
+func (m *Mesh) sampleCoverage() float64 {
+	nodes := m.database.GetNodeListByState(NODE_OK)
+	if len(nodes) == 0 {
+		return 1
+	}
+	healthy := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		healthy[node.Name] = false
+	}
+
+	known := 0
+	m.database.ForEachSample(func(sample *data.Sample) bool {
+		if seen, ok := healthy[sample.From]; ok && !seen {
+			healthy[sample.From] = true
+			known++
+		}
+		return known < len(healthy)
+	})
+	return float64(known) / float64(len(healthy))
+}
+
+// Observe the convergence of the gossip after a push round
+func (m *Mesh) observeGossip(peers int) {
+	m.metrics.GetSamplePushFanout().Set(float64(peers))
+	m.metrics.GetSampleCoverage().Set(m.sampleCoverage())
+}
+
+// Max. entries of the receipt log, the oldest entries are merged beyond
+const UNSYNCED_RECEIPTS_MAX = 3600
+
+// Log of the samples stored by this node in the order of storing.
+// Every stored sample is numbered by a sequence, the receipts of the
+// same second are merged into one entry with the first sequence.
+// The samples stored after the sequence of a push start are unsynced
+// to the pushed node.
+type receiptLog struct {
+	mu      sync.Mutex
+	seq     uint64
+	entries []receipt
+}
+
+// First sequence stored in the second
+type receipt struct {
+	seq uint64
+	ts  int64
+}
+
+// Count a stored sample
+func (l *receiptLog) add(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	if n := len(l.entries); n > 0 && l.entries[n-1].ts == now.Unix() {
+		return
+	}
+	l.entries = append(l.entries, receipt{seq: l.seq, ts: now.Unix()})
+	if len(l.entries) > UNSYNCED_RECEIPTS_MAX {
+		// the oldest entry covers the merged sequences, the age is overestimated
+		l.entries = append(l.entries[:1], l.entries[2:]...)
+	}
+}
+
+// Get the sequence of the last stored sample
+func (l *receiptLog) current() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq
+}
+
+// Get the receipt time of the first sample stored after the sequence,
+// false if no sample was stored since
+func (l *receiptLog) oldestAfter(seq uint64) (int64, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq >= l.seq || len(l.entries) == 0 {
+		return 0, false
+	}
+	// last entry containing the next sequence
+	i := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].seq > seq+1 })
+	if i == 0 {
+		return l.entries[0].ts, true
+	}
+	return l.entries[i-1].ts, true
+}
+
+// Drop the entries not needed by a sequence after the sequence
+func (l *receiptLog) prune(seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	i := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].seq > seq+1 })
+	if i > 1 {
+		l.entries = append(l.entries[:0], l.entries[i-1:]...)
+	}
+}
+
+// Remember the sequence of the receipt log at the start of a successful
+// sample push to a node, the samples stored before reached the node.
+func (m *Mesh) setPushed(node *meshv1.Node, start uint64) {
+	m.mu.Lock()
+	if m.lastPushed == nil {
+		m.lastPushed = map[uint32]uint64{}
+	}
+	m.lastPushed[GetId(node)] = start
+	m.mu.Unlock()
+}
+
+// Observe the age of the oldest sample stored by this node after the start
+// of the last successful push to a peer, all samples are unsynced if never
+// pushed. The age is the time since the sample was stored, independent of
+// its measurement timestamp. The series of nodes no longer peered are deleted.
+func (m *Mesh) observeUnsynced(now time.Time) {
+	// the samples are pushed to the peers only
+	nodes := m.peers(m.database.GetNodeList())
+	peers := make(map[uint32]string, len(nodes))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unsyncedPeers == nil {
+		m.unsyncedPeers = map[uint32]string{}
+	}
+	var oldest uint64
+	for i, node := range nodes {
+		peers[node.Id] = node.Name
+		pushed := m.lastPushed[node.Id]
+		if i == 0 || pushed < oldest {
+			oldest = pushed
+		}
+		age := 0.0
+		if ts, ok := m.receipts.oldestAfter(pushed); ok && ts < now.Unix() {
+			age = float64(now.Unix() - ts)
+		}
+		if name, ok := m.unsyncedPeers[node.Id]; ok && name != node.Name {
+			m.metrics.GetUnsyncedSampleAge().DeleteLabelValues(name)
+		}
+		m.unsyncedPeers[node.Id] = node.Name
+		m.metrics.GetUnsyncedSampleAge().WithLabelValues(node.Name).Set(age)
+	}
+	for id, name := range m.unsyncedPeers {
+		if _, ok := peers[id]; !ok {
+			m.metrics.GetUnsyncedSampleAge().DeleteLabelValues(name)
+			delete(m.unsyncedPeers, id)
+			delete(m.lastPushed, id)
+		}
+	}
+	if len(nodes) > 0 {
+		m.receipts.prune(oldest)
+	}
+}
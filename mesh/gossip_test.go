@@ -0,0 +1,154 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+)
+
+func Test_newMeshPushSampleAmount(t *testing.T) {
+	routineConfig := StandardProductionRoutineConfig()
+	routineConfig.HealthWindow = 0
+	routineConfig.PushSampleToAmount = -1
+	if _, err := newMesh(routineConfig, &SetupConfiguration{Name: "test"}, zap.NewNop().Sugar()); err == nil {
+		t.Error("Expected an error with a negative push sample amount")
+	}
+}
+
+func Test_sampleCoverage(t *testing.T) {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+
+	if coverage := m.sampleCoverage(); coverage != 1 {
+		t.Errorf("Expected full coverage without nodes, got %v", coverage)
+	}
+
+	db.SetNodes([]*data.Node{
+		{Id: 1, Name: "owl", Target: "owl:8081", State: NODE_OK},
+		{Id: 2, Name: "swan", Target: "swan:8081", State: NODE_OK},
+		{Id: 3, Name: "goose", Target: "goose:8081", State: NODE_TIMEOUT},
+	})
+	db.SetSample(&data.Sample{Id: 1, From: "owl", To: "swan", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	db.SetSample(&data.Sample{Id: 2, From: "owl", To: "goose", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	db.SetSample(&data.Sample{Id: 3, From: "goose", To: "owl", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	if coverage := m.sampleCoverage(); coverage != 0.5 {
+		t.Errorf("Expected half coverage, got %v", coverage)
+	}
+
+	db.SetSample(&data.Sample{Id: 4, From: "swan", To: "owl", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	if coverage := m.sampleCoverage(); coverage != 1 {
+		t.Errorf("Expected full coverage, got %v", coverage)
+	}
+}
+
+func Test_observeUnsynced(t *testing.T) {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	now := time.Now()
+	a := &meshv1.Node{Name: "a", Target: "a:8081"}
+	b := &meshv1.Node{Name: "b", Target: "b:8081"}
+	db.SetNode(data.Convert(a, NODE_OK))
+	db.SetNode(data.Convert(b, NODE_OK))
+
+	// stored a minute ago, measured earlier
+	m.receipts.add(now.Add(-time.Minute))
+
+	// never pushed, the oldest stored sample is unsynced
+	m.observeUnsynced(now)
+	if ages := gatherValues(t, m.metrics, "unsynced_sample_age_seconds", "peer", "a"); len(ages) != 1 || ages[0] != 60 {
+		t.Errorf("Expected the age 60s of a never pushed node, got %v", ages)
+	}
+
+	// a caught up, samples stored after the push start are unsynced
+	m.setPushed(a, m.receipts.current())
+	m.receipts.add(now.Add(-time.Second * 10))
+	m.receipts.add(now.Add(-time.Second * 5))
+	m.observeUnsynced(now)
+	if ages := gatherValues(t, m.metrics, "unsynced_sample_age_seconds", "peer", "a"); len(ages) != 1 || ages[0] != 10 {
+		t.Errorf("Expected the age 10s of the sample stored after the push, got %v", ages)
+	}
+	if ages := gatherValues(t, m.metrics, "unsynced_sample_age_seconds", "peer", "b"); len(ages) != 1 || ages[0] != 60 {
+		t.Errorf("Expected b to fall behind by 60s, got %v", ages)
+	}
+
+	// pushed all samples
+	m.setPushed(a, m.receipts.current())
+	m.setPushed(b, m.receipts.current())
+	m.observeUnsynced(now)
+	if ages := gatherValues(t, m.metrics, "unsynced_sample_age_seconds", "peer", "a"); len(ages) != 1 || ages[0] != 0 {
+		t.Errorf("Expected age 0 after a push, got %v", ages)
+	}
+	if len(m.receipts.entries) != 1 {
+		t.Errorf("Expected the receipts before the pushes to be pruned, got %v", m.receipts.entries)
+	}
+
+	// removed nodes are forgotten
+	db.DeleteNode(GetId(a))
+	m.observeUnsynced(now)
+	if _, ok := m.lastPushed[GetId(a)]; ok {
+		t.Error("Expected the push of the removed node to be forgotten")
+	}
+	if ages := gatherValues(t, m.metrics, "unsynced_sample_age_seconds", "peer", "a"); len(ages) != 0 {
+		t.Errorf("Expected no series of the removed node, got %v", ages)
+	}
+	if ages := gatherValues(t, m.metrics, "unsynced_sample_age_seconds", "peer", "b"); len(ages) != 1 || ages[0] != 0 {
+		t.Errorf("Expected the series of b to be kept, got %v", ages)
+	}
+}
+
+func Test_receiptLog(t *testing.T) {
+	now := time.Now()
+	l := &receiptLog{}
+	if _, ok := l.oldestAfter(0); ok {
+		t.Error("Expected no receipt of an empty log")
+	}
+	for i := 0; i < UNSYNCED_RECEIPTS_MAX+10; i++ {
+		l.add(now.Add(time.Duration(i) * time.Second))
+		// merged into the entry of the second
+		l.add(now.Add(time.Duration(i) * time.Second))
+	}
+	if len(l.entries) != UNSYNCED_RECEIPTS_MAX {
+		t.Errorf("Expected the log to be capped, got %v entries", len(l.entries))
+	}
+	if ts, _ := l.oldestAfter(0); ts != now.Unix() {
+		t.Errorf("Expected the first receipt to be kept, got %v", ts)
+	}
+	if ts, _ := l.oldestAfter(l.current() - 1); ts != now.Unix()+UNSYNCED_RECEIPTS_MAX+9 {
+		t.Errorf("Expected the last receipt, got %v", ts)
+	}
+	if _, ok := l.oldestAfter(l.current()); ok {
+		t.Error("Expected no receipt after the current sequence")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+// Health score of a node with less RTT measurements than the minimum
+const HEALTH_SCORE_UNKNOWN = -1
+
+// RTT of a failed measurement in the health window
+const healthFailed = time.Duration(-1)
+
+// Weights of the health score components:
+// success ratio, RTT relative to the baseline and jitter
+type HealthWeights struct {
+	Success float64
+	Rtt     float64
+	Jitter  float64
+}
+
+// Weights can not be negative, at least one weight has to be set
+func (w HealthWeights) validate() error {
+	if w.Success < 0 || w.Rtt < 0 || w.Jitter < 0 {
+		return errors.New("health weights can not be negative")
+	}
+	if w.Success+w.Rtt+w.Jitter == 0 {
+		return errors.New("at least one health weight has to be set")
+	}
+	return nil
+}
+
+// Last RTT measurements per node name
+type healthTracker struct {
+	mu      sync.Mutex
+	window  int
+	results map[string][]time.Duration
+}
+
+func newHealthTracker(window int) *healthTracker {
+	return &healthTracker{window: window, results: map[string][]time.Duration{}}
+}
+
+// Add a measurement of a node, use healthFailed for a failed measurement.
+// A copy of the window of the node is returned.
+func (t *healthTracker) add(node string, rtt time.Duration) []time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	results := append(t.results[node], rtt)
+	if len(results) > t.window {
+		results = results[len(results)-t.window:]
+	}
+	t.results[node] = results
+	return append([]time.Duration{}, results...)
+}
+
+// Delete the measurements of a node, e.g. after the node left
+func (t *healthTracker) delete(node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.results, node)
+}
+
+// Health score 0-100 of the RTT measurements.
+// The success ratio, the average RTT relative to the baseline
+// and the jitter (mean difference of consecutive RTTs) relative to the
+// baseline are weighted. A RTT at or below the baseline scores 1,
+// a jitter of the baseline or more scores 0.
+// The score is HEALTH_SCORE_UNKNOWN with less than minSamples measurements
+// or without weights.
+func healthScore(results []time.Duration, minSamples int, weights HealthWeights, baseline time.Duration) float64 {
+	if len(results) == 0 || len(results) < minSamples {
+		return HEALTH_SCORE_UNKNOWN
+	}
+
+	var succeeded []time.Duration
+	for _, rtt := range results {
+		if rtt != healthFailed {
+			succeeded = append(succeeded, rtt)
+		}
+	}
+	success := float64(len(succeeded)) / float64(len(results))
+
+	var rttScore, jitterScore float64
+	if len(succeeded) > 0 {
+		var sum time.Duration
+		for _, rtt := range succeeded {
+			sum += rtt
+		}
+		avg := sum / time.Duration(len(succeeded))
+		rttScore = 1
+		if avg > baseline {
+			rttScore = float64(baseline) / float64(avg)
+		}
+
+		jitterScore = 1
+		if len(succeeded) > 1 {
+			var diff time.Duration
+			for i := 1; i < len(succeeded); i++ {
+				d := succeeded[i] - succeeded[i-1]
+				if d < 0 {
+					d = -d
+				}
+				diff += d
+			}
+			jitter := diff / time.Duration(len(succeeded)-1)
+			jitterScore = math.Max(0, 1-float64(jitter)/float64(baseline))
+		}
+	}
+
+	total := weights.Success + weights.Rtt + weights.Jitter
+	if total <= 0 {
+		return HEALTH_SCORE_UNKNOWN
+	}
+	score := (weights.Success*success + weights.Rtt*rttScore + weights.Jitter*jitterScore) / total
+	return math.Round(score*1000) / 10
+}
+
+// Save the health score of a node by the RTT measurement as sample.
+// Use healthFailed for a failed measurement.
+func (m *Mesh) observeHealth(node *data.Node, rtt time.Duration) {
+	if m.health == nil {
+		return
+	}
+	results := m.health.add(node.Name, rtt)
+	score := healthScore(results, m.routineConfig.HealthMinSamples, m.setupConfig.HealthWeights, m.setupConfig.HealthRttBaseline)
+
+	m.database.SetSample(&data.Sample{
+		From:   m.name.get(),
+		To:     node.Name,
+		Key:    data.HEALTH_SCORE,
+		Value:  strconv.FormatFloat(score, 'f', -1, 64),
+		Ts:     time.Now().Unix(),
+		FromId: m.nodeId(),
+		ToId:   node.Id,
+	})
+}
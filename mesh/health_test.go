@@ -0,0 +1,125 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+)
+
+func Test_healthScore(t *testing.T) {
+	weights := HealthWeights{Success: 0.5, Rtt: 0.3, Jitter: 0.2}
+	ms := time.Millisecond
+	tests := []struct {
+		name     string
+		results  []time.Duration
+		expected float64
+	}{
+		{name: "no measurements", results: nil, expected: HEALTH_SCORE_UNKNOWN},
+		{name: "insufficient measurements", results: []time.Duration{10 * ms, 10 * ms}, expected: HEALTH_SCORE_UNKNOWN},
+		{name: "healthy", results: []time.Duration{10 * ms, 10 * ms, 10 * ms}, expected: 100},
+		{name: "all failed", results: []time.Duration{healthFailed, healthFailed, healthFailed}, expected: 0},
+		{name: "one failed", results: []time.Duration{10 * ms, healthFailed, 10 * ms, 10 * ms}, expected: 87.5},
+		{name: "slow", results: []time.Duration{200 * ms, 200 * ms, 200 * ms}, expected: 85},
+		{name: "jitter", results: []time.Duration{0, 50 * ms, 0, 50 * ms}, expected: 90},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if score := healthScore(tt.results, 3, weights, 100*ms); score != tt.expected {
+				t.Errorf("got score %v, expected %v", score, tt.expected)
+			}
+		})
+	}
+
+	// the score of zero weights is unknown instead of NaN
+	if score := healthScore([]time.Duration{10 * ms, 10 * ms, 10 * ms}, 3, HealthWeights{}, 100*ms); score != HEALTH_SCORE_UNKNOWN {
+		t.Errorf("got score %v without weights, expected %v", score, HEALTH_SCORE_UNKNOWN)
+	}
+}
+
+func Test_healthTrackerWindow(t *testing.T) {
+	tracker := newHealthTracker(3)
+	for i := 1; i <= 5; i++ {
+		tracker.add("node", time.Duration(i))
+	}
+	results := tracker.add("node", 6)
+	if len(results) != 3 || results[0] != 4 || results[2] != 6 {
+		t.Errorf("got window %v, expected the last 3 measurements", results)
+	}
+}
+
+func Test_healthTrackerForget(t *testing.T) {
+	m := testMesh(time.Second)
+	m.health = newHealthTracker(3)
+	m.health.add("node", 1)
+	m.health.add("other", 1)
+
+	// the measurements are deleted after the node left
+	m.forgetNode(&meshv1.Node{Name: "node"})
+	if results := m.health.add("node", 2); len(results) != 1 {
+		t.Errorf("Expected a new window of the node, got %v", results)
+	}
+	if results := m.health.add("other", 2); len(results) != 2 {
+		t.Errorf("Expected the window of the other node to be kept, got %v", results)
+	}
+}
+
+func Test_newMeshHealthWeights(t *testing.T) {
+	routineConfig := StandardProductionRoutineConfig()
+	setupConfig := &SetupConfiguration{Name: "test", HealthRttBaseline: time.Millisecond}
+	if _, err := newMesh(routineConfig, setupConfig, zap.NewNop().Sugar()); err == nil {
+		t.Error("Expected an error without health weights")
+	}
+	setupConfig.HealthWeights = HealthWeights{Success: 1}
+	if _, err := newMesh(routineConfig, setupConfig, zap.NewNop().Sugar()); err != nil {
+		t.Errorf("Expected valid health weights, got %v", err)
+	}
+	// the weights are not used without the health score
+	routineConfig.HealthWindow = 0
+	if _, err := newMesh(routineConfig, &SetupConfiguration{Name: "test"}, zap.NewNop().Sugar()); err != nil {
+		t.Errorf("Expected no validation without the health score, got %v", err)
+	}
+}
+
+func Test_HealthWeightsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights HealthWeights
+		wantErr bool
+	}{
+		{name: "valid", weights: HealthWeights{Success: 1}, wantErr: false},
+		{name: "negative", weights: HealthWeights{Success: 1, Jitter: -1}, wantErr: true},
+		{name: "all zero", weights: HealthWeights{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.weights.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
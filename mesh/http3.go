@@ -0,0 +1,58 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/telekom/canary-bot/metric"
+)
+
+// Create the HTTP/3 transport of a probe over quic-go, the system roots
+// are trusted if no TLS config is set. The transport has to be closed
+// after the probe, so every probe dials a new QUIC connection.
+func newHttp3RoundTripper(tlsConfig *tls.Config, serverName string) *http3.RoundTripper {
+	config := &tls.Config{MinVersion: tls.VersionTLS13}
+	if tlsConfig != nil {
+		config = tlsConfig.Clone()
+	}
+	if serverName != "" {
+		config.ServerName = serverName
+	}
+	return &http3.RoundTripper{TLSClientConfig: config}
+}
+
+// Wrap the QUIC errors of quic-go by the errors of the probe reasons
+func quicError(err error) error {
+	var versionErr *quic.VersionNegotiationError
+	if errors.As(err, &versionErr) {
+		return fmt.Errorf("%w: %v", metric.ErrQuicVersionNegotiation, err)
+	}
+	if errors.Is(err, quic.Err0RTTRejected) {
+		return fmt.Errorf("%w: %v", metric.ErrQuic0RttRejected, err)
+	}
+	return err
+}
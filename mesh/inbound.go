@@ -0,0 +1,148 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/telekom/canary-bot/metric"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Admit an inbound RPC, false if the max. inbound streams are active.
+// Health checks are counted, but always admitted.
+// An admitted RPC has to be released by releaseStream.
+func (m *Mesh) admitStream(method string) bool {
+	active := m.inboundStreams.Add(1)
+	max := int64(m.setupConfig.MaxInboundStreams)
+	if max > 0 && active > max && !strings.HasPrefix(method, healthMethodPrefix) {
+		m.inboundStreams.Add(-1)
+		m.metrics.GetInboundRejected().WithLabelValues(metric.LIMIT_STREAMS).Inc()
+		m.logger.Named("server").Debugw("Max. inbound streams reached - RPC rejected", "method", method, "max", max)
+		return false
+	}
+	m.metrics.GetInboundActiveStreams().Inc()
+	return true
+}
+
+// Release an admitted inbound RPC
+func (m *Mesh) releaseStream() {
+	m.inboundStreams.Add(-1)
+	m.metrics.GetInboundActiveStreams().Dec()
+}
+
+// Reject unary RPCs exceeding the max. inbound streams
+func (m *Mesh) limitUnaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if !m.admitStream(info.FullMethod) {
+		return nil, status.Error(codes.ResourceExhausted, "max. inbound streams reached")
+	}
+	defer m.releaseStream()
+	return handler(ctx, req)
+}
+
+// Reject stream RPCs exceeding the max. inbound streams
+func (m *Mesh) limitStreamInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if !m.admitStream(info.FullMethod) {
+		return status.Error(codes.ResourceExhausted, "max. inbound streams reached")
+	}
+	defer m.releaseStream()
+	return handler(srv, ss)
+}
+
+// Listener closing accepted connections above the max. connections.
+// The active connections are shared by the listeners of the server.
+type limitListener struct {
+	net.Listener
+	max    int64
+	active *atomic.Int64
+	// called for every rejected connection
+	onReject func()
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.active.Add(1) > l.max {
+			l.active.Add(-1)
+			l.onReject()
+			conn.Close()
+			continue
+		}
+		return &limitConn{Conn: conn, active: l.active}, nil
+	}
+}
+
+// Connection accepted by the limit listener, released on close
+type limitConn struct {
+	net.Conn
+	active *atomic.Int64
+	once   sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(func() {
+		c.active.Add(-1)
+	})
+	return c.Conn.Close()
+}
+
+// Limit the connections of the listeners to the max. inbound connections
+func (m *Mesh) limitListeners(listeners []net.Listener) []net.Listener {
+	max := int64(m.setupConfig.MaxInboundConnections)
+	if max <= 0 {
+		return listeners
+	}
+	log := m.logger.Named("server")
+	limited := make([]net.Listener, 0, len(listeners))
+	for _, lis := range listeners {
+		limited = append(limited, &limitListener{
+			Listener: lis,
+			max:      max,
+			active:   &m.inboundConns,
+			onReject: func() {
+				m.metrics.GetInboundRejected().WithLabelValues(metric.LIMIT_CONNECTIONS).Inc()
+				log.Debugw("Max. inbound connections reached - connection closed", "max", max)
+			},
+		})
+	}
+	return limited
+}
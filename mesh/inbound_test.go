@@ -0,0 +1,139 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/metric"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_limitUnaryInterceptor(t *testing.T) {
+	m := testMesh(time.Second)
+	m.setupConfig.MaxInboundStreams = 1
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := m.limitUnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/mesh.v1.MeshService/PushSamples"},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				close(started)
+				<-release
+				return nil, nil
+			})
+		done <- err
+	}()
+	<-started
+
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil }
+	_, err := m.limitUnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/mesh.v1.MeshService/JoinMesh"}, ok)
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted above the max. inbound streams, got %v", err)
+	}
+	if _, err := m.limitUnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: healthMethodPrefix + "Check"}, ok); err != nil {
+		t.Errorf("Expected health checks to be admitted, got %v", err)
+	}
+	if rejected := gatherValue(t, m.metrics, "inbound_rejected_total", "limit", metric.LIMIT_STREAMS); rejected != 1 {
+		t.Errorf("Expected 1 rejected stream, got %v", rejected)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.limitUnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/mesh.v1.MeshService/JoinMesh"}, ok); err != nil {
+		t.Errorf("Expected the RPC to be admitted after the release, got %v", err)
+	}
+	if active := m.inboundStreams.Load(); active != 0 {
+		t.Errorf("Expected no active streams, got %v", active)
+	}
+}
+
+func Test_limitListeners(t *testing.T) {
+	m := testMesh(time.Second)
+	m.setupConfig.MaxInboundConnections = 1
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := m.limitListeners([]net.Listener{lis})[0]
+	defer limited.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := limited.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	conn := <-accepted
+
+	// the second connection is closed by the listener
+	second, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the connection above the max. to be closed")
+	}
+	if rejected := gatherValue(t, m.metrics, "inbound_rejected_total", "limit", metric.LIMIT_CONNECTIONS); rejected != 1 {
+		t.Errorf("Expected 1 rejected connection, got %v", rejected)
+	}
+
+	// a closed connection frees its slot
+	conn.Close()
+	conn.Close()
+	third, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Error("Expected the connection to be accepted after a close")
+	}
+	if active := m.inboundConns.Load(); active != 1 {
+		t.Errorf("Expected 1 active connection, got %v", active)
+	}
+}
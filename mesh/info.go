@@ -0,0 +1,255 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Version of canary-bot, set at build time with
+// -ldflags "-X github.com/telekom/canary-bot/mesh.Version=v1.2.3".
+// The module version of the build info is used if not set.
+var Version = ""
+
+// Mesh protocol versions of this node.
+// The protocol version is raised on incompatible changes of the mesh RPCs,
+// the min. protocol version is the oldest version this node interoperates with.
+const (
+	PROTOCOL_VERSION     = 1
+	MIN_PROTOCOL_VERSION = 1
+)
+
+// Version of nodes without the GetInfo RPC or not queried yet
+const VERSION_UNKNOWN = "unknown"
+
+// Get the version of canary-bot
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// Get the info of this node: version, protocol versions & enabled features
+func (m *Mesh) info() *meshv1.NodeInfo {
+	return &meshv1.NodeInfo{
+		Version:            version(),
+		ProtocolVersion:    PROTOCOL_VERSION,
+		MinProtocolVersion: MIN_PROTOCOL_VERSION,
+		Features:           m.features(),
+	}
+}
+
+// Get the enabled features of this node, sorted
+func (m *Mesh) features() []string {
+	features := []string{}
+	enabled := map[string]bool{
+		"tls":           m.setupConfig.ServerCertPath != "" || len(m.setupConfig.ServerCert) > 0,
+		"require-tls":   m.setupConfig.RequireTLS,
+		"tls-fallback":  m.setupConfig.TLSFallback,
+		"mesh-token":    m.meshToken != nil,
+		"observer":      m.setupConfig.Observer,
+		"rtt-payload":   len(m.setupConfig.RttPayloadSizes) > 0,
+		"rtt-exemplars": m.setupConfig.RttTracer != nil,
+		"one-way-delay": m.setupConfig.OneWayDelay,
+		"throughput":    m.setupConfig.ThroughputInterval > 0,
+		"digest-sync":   m.setupConfig.DigestSync,
+		"sample-spill":  m.setupConfig.SampleSpillPath != "",
+		"aggregator":    m.setupConfig.Aggregator,
+	}
+	for feature, ok := range enabled {
+		if ok {
+			features = append(features, feature)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// Check if the protocol version of a node is compatible with this node.
+// The info of nodes without the GetInfo RPC is unset and accepted.
+func compatibleInfo(info *meshv1.NodeInfo) error {
+	if info == nil {
+		return nil
+	}
+	if info.ProtocolVersion < MIN_PROTOCOL_VERSION || info.MinProtocolVersion > PROTOCOL_VERSION {
+		return fmt.Errorf("%w: node protocol %d (min. %d), this node protocol %d (min. %d)",
+			ErrIncompatible, info.ProtocolVersion, info.MinProtocolVersion, PROTOCOL_VERSION, MIN_PROTOCOL_VERSION)
+	}
+	return nil
+}
+
+// Store the info of a node and check its compatibility.
+// An incompatible node is logged, the error is just returned
+// if incompatible nodes are refused.
+func (m *Mesh) setPeerInfo(node *meshv1.Node, info *meshv1.NodeInfo) error {
+	log := m.logger.Named("info")
+	if info == nil {
+		// node without the GetInfo RPC
+		info = &meshv1.NodeInfo{Version: VERSION_UNKNOWN}
+	}
+
+	m.mu.Lock()
+	if m.peerInfo == nil {
+		m.peerInfo = map[uint32]*meshv1.NodeInfo{}
+	}
+	m.peerInfo[GetId(node)] = info
+	m.mu.Unlock()
+	m.updatePeerVersions()
+
+	err := compatibleInfo(info)
+	if err == nil {
+		return nil
+	}
+	if m.setupConfig.RefuseIncompatible {
+		log.Warnw("Refusing node with incompatible protocol version", "node", node.Name, "target", node.Target, "version", info.Version, "error", err)
+		return err
+	}
+	log.Warnw("Node with incompatible protocol version", "node", node.Name, "target", node.Target, "version", info.Version, "error", err)
+	return nil
+}
+
+// Get the stored info of a node, the bool is false if not known
+func (m *Mesh) peerInfoOf(id uint32) (*meshv1.NodeInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, ok := m.peerInfo[id]
+	return info, ok
+}
+
+// Query the info of a node by the GetInfo RPC and store it.
+// A node without the GetInfo RPC is stored with an unknown version.
+func (m *Mesh) GetPeerInfo(ctx context.Context, node *meshv1.Node) (*meshv1.NodeInfo, error) {
+	c, err := m.initClient(node)
+	if err != nil {
+		return nil, fmt.Errorf("get info %v: %w", node.Target, classifyError(err, ErrDial))
+	}
+
+	info, err := c.client.GetInfo(ctx, &emptypb.Empty{})
+	if status.Code(err) == codes.Unimplemented {
+		info, err = nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get info %v: %w", node.Target, classifyError(err))
+	}
+	return info, m.setPeerInfo(node, info)
+}
+
+// Query the info of the ok nodes not known yet,
+// e.g. nodes discovered by other nodes of the mesh.
+// The info of removed nodes is deleted. A query is skipped
+// if the last query is still running.
+func (m *Mesh) queryPeerInfos() {
+	log := m.logger.Named("info")
+	if !m.peerInfoRunning.CompareAndSwap(false, true) {
+		log.Debugw("Last info query still running - skipped")
+		return
+	}
+	defer m.peerInfoRunning.Store(false)
+
+	known := map[uint32]bool{}
+	for _, node := range m.database.GetNodeList() {
+		known[node.Id] = true
+	}
+	m.mu.Lock()
+	for id := range m.peerInfo {
+		if !known[id] {
+			delete(m.peerInfo, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, node := range m.peers(m.database.GetNodeListByState(NODE_OK)) {
+		if _, ok := m.peerInfoOf(node.Id); ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.routineConfig.RequestTimeout)
+		_, err := m.GetPeerInfo(ctx, node.Convert())
+		cancel()
+		if errors.Is(err, ErrIncompatible) {
+			// refused like an incompatible node joining this node
+			m.refuseNode(node.Convert(), err)
+			continue
+		}
+		if err != nil {
+			log.Debugw("Could not get the info of a node", "node", node.Name, "error", err)
+		}
+	}
+	m.updatePeerVersions()
+}
+
+// Remove a refused node discovered by other nodes of the mesh,
+// it is queried & refused again if it is discovered again
+func (m *Mesh) refuseNode(node *meshv1.Node, err error) {
+	m.recordEvent(data.EVENT_EVICTION, node.Name, err.Error())
+	m.forgetNode(node)
+	m.database.DeleteNode(GetId(node))
+	if err := m.closeClient(node); err != nil {
+		m.logger.Named("info").Debugw("Could not close client", "node", node.Name, "error", err)
+	}
+}
+
+// Update the number of known nodes by version.
+// The series of versions no node runs anymore are deleted.
+func (m *Mesh) updatePeerVersions() {
+	m.versionsMu.Lock()
+	defer m.versionsMu.Unlock()
+
+	versions := map[string]float64{}
+	nodes := m.database.GetNodeList()
+
+	m.mu.Lock()
+	for _, node := range nodes {
+		if info, ok := m.peerInfo[node.Id]; ok {
+			versions[info.Version]++
+		} else {
+			versions[VERSION_UNKNOWN]++
+		}
+	}
+	m.mu.Unlock()
+
+	for v, count := range versions {
+		m.metrics.GetPeerVersions().WithLabelValues(v).Set(count)
+	}
+	for v := range m.peerVersions {
+		if _, ok := versions[v]; !ok {
+			m.metrics.GetPeerVersions().DeleteLabelValues(v)
+		}
+	}
+	m.peerVersions = map[string]bool{}
+	for v := range versions {
+		m.peerVersions[v] = true
+	}
+}
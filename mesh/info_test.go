@@ -0,0 +1,221 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Mesh with a database knowing the given nodes
+func infoMesh(t *testing.T, nodes ...*meshv1.Node) *Mesh {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	for _, node := range nodes {
+		db.SetNode(data.Convert(node, NODE_OK))
+	}
+	return m
+}
+
+func Test_compatibleInfo(t *testing.T) {
+	tests := []struct {
+		name         string
+		info         *meshv1.NodeInfo
+		incompatible bool
+	}{
+		{name: "node without info", info: nil},
+		{name: "same protocol", info: &meshv1.NodeInfo{ProtocolVersion: PROTOCOL_VERSION, MinProtocolVersion: MIN_PROTOCOL_VERSION}},
+		{name: "newer compatible protocol", info: &meshv1.NodeInfo{ProtocolVersion: PROTOCOL_VERSION + 1, MinProtocolVersion: PROTOCOL_VERSION}},
+		{name: "older protocol", info: &meshv1.NodeInfo{ProtocolVersion: MIN_PROTOCOL_VERSION - 1}, incompatible: true},
+		{name: "newer incompatible protocol", info: &meshv1.NodeInfo{ProtocolVersion: PROTOCOL_VERSION + 1, MinProtocolVersion: PROTOCOL_VERSION + 1}, incompatible: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compatibleInfo(tt.info)
+			if errors.Is(err, ErrIncompatible) != tt.incompatible {
+				t.Errorf("Expected incompatible %v, got %v", tt.incompatible, err)
+			}
+		})
+	}
+}
+
+func Test_features(t *testing.T) {
+	m := testMesh(time.Second)
+	m.setupConfig.TLSFallback = true
+	m.setupConfig.DigestSync = true
+	m.setupConfig.Observer = true
+	if diff := deep.Equal(m.features(), []string{"digest-sync", "observer", "tls-fallback"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func Test_setPeerInfo(t *testing.T) {
+	peer := &meshv1.Node{Name: "peer", Target: "peer:8081"}
+	legacy := &meshv1.Node{Name: "legacy", Target: "legacy:8081"}
+	old := &meshv1.Node{Name: "old", Target: "old:8081"}
+	m := infoMesh(t, peer, legacy, old, &meshv1.Node{Name: "new", Target: "new:8081"})
+
+	if err := m.setPeerInfo(peer, &meshv1.NodeInfo{Version: "v1.2.3", ProtocolVersion: PROTOCOL_VERSION}); err != nil {
+		t.Errorf("Expected a compatible node, got %v", err)
+	}
+	if err := m.setPeerInfo(legacy, nil); err != nil {
+		t.Errorf("Expected a node without info to be accepted, got %v", err)
+	}
+	oldInfo := &meshv1.NodeInfo{Version: "v0.1.0", ProtocolVersion: MIN_PROTOCOL_VERSION - 1}
+	if err := m.setPeerInfo(old, oldInfo); err != nil {
+		t.Errorf("Expected an incompatible node to be just logged, got %v", err)
+	}
+	m.setupConfig.RefuseIncompatible = true
+	if err := m.setPeerInfo(old, oldInfo); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Expected an incompatible node to be refused, got %v", err)
+	}
+
+	if v := gatherValue(t, m.metrics, "mesh_peer_version", "version", "v1.2.3"); v != 1 {
+		t.Errorf("Expected 1 node of v1.2.3, got %v", v)
+	}
+	if v := gatherValue(t, m.metrics, "mesh_peer_version", "version", "v0.1.0"); v != 1 {
+		t.Errorf("Expected 1 node of v0.1.0, got %v", v)
+	}
+	// the legacy node and the node not queried yet
+	if v := gatherValue(t, m.metrics, "mesh_peer_version", "version", VERSION_UNKNOWN); v != 2 {
+		t.Errorf("Expected 2 nodes of an unknown version, got %v", v)
+	}
+}
+
+func Test_GetPeerInfo(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := testMesh(time.Second)
+	remote.setupConfig.Observer = true
+	server := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(server, &MeshServer{info: remote.info()})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	// legacy node without the GetInfo RPC
+	legacyLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyServer := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(legacyServer, &meshv1.UnimplementedMeshServiceServer{})
+	go legacyServer.Serve(legacyLis)
+	t.Cleanup(legacyServer.Stop)
+
+	peer := &meshv1.Node{Name: "peer", Target: lis.Addr().String()}
+	legacy := &meshv1.Node{Name: "legacy", Target: legacyLis.Addr().String()}
+	m := infoMesh(t, peer, legacy)
+
+	info, err := m.GetPeerInfo(context.Background(), peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ProtocolVersion != PROTOCOL_VERSION || info.Version != version() {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	if diff := deep.Equal(info.Features, []string{"observer"}); diff != nil {
+		t.Error(diff)
+	}
+
+	m.queryPeerInfos()
+	if info, ok := m.peerInfoOf(GetId(legacy)); !ok || info.Version != VERSION_UNKNOWN {
+		t.Errorf("Expected the legacy node with an unknown version, got %+v", info)
+	}
+}
+
+func Test_JoinMeshIncompatible(t *testing.T) {
+	m := infoMesh(t)
+	m.setupConfig.RefuseIncompatible = true
+	s := &MeshServer{
+		log:               zap.NewNop().Sugar(),
+		data:              &m.database,
+		name:              m.name,
+		newNodeDiscovered: make(chan NodeDiscovered, 1),
+		info:              m.info(),
+		peerInfo:          m.setPeerInfo,
+	}
+	s.draining = &m.draining
+
+	joining := &meshv1.Node{Name: "old", Target: "old:8081", Info: &meshv1.NodeInfo{ProtocolVersion: MIN_PROTOCOL_VERSION - 1}}
+	if _, err := s.JoinMesh(context.Background(), joining); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition for an incompatible node, got %v", err)
+	}
+
+	joining.Info = m.info()
+	res, err := s.JoinMesh(context.Background(), joining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.MyInfo.ProtocolVersion != PROTOCOL_VERSION {
+		t.Errorf("Expected the info of the seed node, got %+v", res.MyInfo)
+	}
+}
+
+func Test_queryPeerInfos(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(server, &MeshServer{info: &meshv1.NodeInfo{Version: "v0.0.1", ProtocolVersion: MIN_PROTOCOL_VERSION - 1}})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	old := &meshv1.Node{Name: "old", Target: lis.Addr().String()}
+	m := infoMesh(t, old)
+	m.setupConfig.RefuseIncompatible = true
+
+	// a running query skips the round
+	m.peerInfoRunning.Store(true)
+	m.queryPeerInfos()
+	if _, ok := m.peerInfoOf(GetId(old)); ok {
+		t.Error("Expected no query while the last query is running")
+	}
+	m.peerInfoRunning.Store(false)
+
+	// an incompatible node discovered by other nodes is refused
+	m.queryPeerInfos()
+	if _, ok := m.database.GetNode(GetId(old)); ok {
+		t.Error("Expected the incompatible node to be removed")
+	}
+	if v := gatherValue(t, m.metrics, "mesh_peer_version", "version", "v0.0.1"); v != 0 {
+		t.Errorf("Expected the series of the removed version to be deleted, got %v", v)
+	}
+}
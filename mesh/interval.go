@@ -0,0 +1,142 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+// Prefix of a probe interval override matching a node label
+const PROBE_OVERRIDE_LABEL_PREFIX = "label:"
+
+// RTT measurement interval of the nodes matching a name pattern or a label.
+// Overridden nodes are measured in their own interval instead of the
+// default RTT routine.
+type ProbeIntervalOverride struct {
+	// Glob pattern of the node names, e.g. api-*
+	NamePattern string
+	// Label of the nodes, if no name pattern is set
+	LabelKey   string
+	LabelValue string
+	Interval   time.Duration
+}
+
+// Parse a probe interval override in the format PATTERN=INTERVAL
+// or label:KEY=VALUE=INTERVAL, e.g. api-*=1s, label:tier=best-effort=1m.
+// The interval has to be at least the min. interval.
+func ParseProbeIntervalOverride(override string, minInterval time.Duration) (ProbeIntervalOverride, error) {
+	var o ProbeIntervalOverride
+	i := strings.LastIndex(override, "=")
+	if i <= 0 {
+		return o, fmt.Errorf("invalid probe interval override %v, format: PATTERN=INTERVAL or label:KEY=VALUE=INTERVAL", override)
+	}
+	match, interval := override[:i], override[i+1:]
+
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return o, fmt.Errorf("invalid interval of probe interval override %v", override)
+	}
+	if d < minInterval {
+		return o, fmt.Errorf("interval of probe interval override %v is below the min. interval %v", override, minInterval)
+	}
+	o.Interval = d
+
+	if strings.HasPrefix(match, PROBE_OVERRIDE_LABEL_PREFIX) {
+		key, value, found := strings.Cut(strings.TrimPrefix(match, PROBE_OVERRIDE_LABEL_PREFIX), "=")
+		if !found || key == "" {
+			return o, fmt.Errorf("invalid label of probe interval override %v, format: label:KEY=VALUE=INTERVAL", override)
+		}
+		o.LabelKey, o.LabelValue = key, value
+		return o, nil
+	}
+	if _, err := path.Match(match, ""); err != nil {
+		return o, fmt.Errorf("invalid name pattern of probe interval override %v", override)
+	}
+	o.NamePattern = match
+	return o, nil
+}
+
+// Check if the override matches the node by name pattern or label
+func (o ProbeIntervalOverride) matches(node *data.Node) bool {
+	if o.NamePattern != "" {
+		ok, _ := path.Match(o.NamePattern, node.Name)
+		return ok
+	}
+	value, ok := node.Labels[o.LabelKey]
+	return ok && value == o.LabelValue
+}
+
+// Get the overridden interval of a node, the first matching override wins
+func (m *Mesh) probeInterval(node *data.Node) (time.Duration, bool) {
+	for _, o := range m.probeOverrides {
+		if o.matches(node) {
+			return o.Interval, true
+		}
+	}
+	return 0, false
+}
+
+// Get the overridden nodes due for a RTT measurement
+func (m *Mesh) dueOverrideNodes(now time.Time) []*data.Node {
+	var due []*data.Node
+	known := map[uint32]bool{}
+	for _, node := range m.peers(m.database.GetNodeListByState(NODE_OK)) {
+		interval, ok := m.probeInterval(node)
+		if !ok {
+			continue
+		}
+		known[node.Id] = true
+		if last, ok := m.overrideProbed[node.Id]; ok && now.Sub(last) < interval {
+			continue
+		}
+		m.overrideProbed[node.Id] = now
+		due = append(due, node)
+	}
+	// forget removed nodes
+	for id := range m.overrideProbed {
+		if !known[id] {
+			delete(m.overrideProbed, id)
+		}
+	}
+	return due
+}
+
+// Get the nodes of the default RTT routine,
+// overridden and non-peered nodes are skipped
+func (m *Mesh) rttNodes() []*data.Node {
+	nodes := m.peers(m.database.GetNodeListByState(NODE_OK))
+	if len(m.probeOverrides) == 0 {
+		return nodes
+	}
+	var defaults []*data.Node
+	for _, node := range nodes {
+		if _, ok := m.probeInterval(node); !ok {
+			defaults = append(defaults, node)
+		}
+	}
+	return defaults
+}
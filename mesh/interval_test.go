@@ -0,0 +1,92 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+func Test_ParseProbeIntervalOverride(t *testing.T) {
+	tests := []struct {
+		name      string
+		override  string
+		expected  ProbeIntervalOverride
+		expectErr bool
+	}{
+		{name: "name pattern", override: "api-*=1s", expected: ProbeIntervalOverride{NamePattern: "api-*", Interval: time.Second}},
+		{name: "label", override: "label:tier=best-effort=1m", expected: ProbeIntervalOverride{LabelKey: "tier", LabelValue: "best-effort", Interval: time.Minute}},
+		{name: "below min", override: "api-*=100ms", expectErr: true},
+		{name: "no interval", override: "api-*", expectErr: true},
+		{name: "invalid interval", override: "api-*=fast", expectErr: true},
+		{name: "invalid label", override: "label:tier=1m", expectErr: true},
+		{name: "invalid pattern", override: "api-[=1s", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := ParseProbeIntervalOverride(tt.override, time.Second)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if !tt.expectErr && o != tt.expected {
+				t.Errorf("got override %+v, expected %+v", o, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_dueOverrideNodes(t *testing.T) {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.overrideProbed = map[uint32]time.Time{}
+	m.probeOverrides = []ProbeIntervalOverride{
+		{NamePattern: "api-*", Interval: time.Second},
+		{LabelKey: "tier", LabelValue: "best-effort", Interval: time.Minute},
+	}
+	db.SetNodes([]*data.Node{
+		{Id: 1, Name: "api-1", Target: "api-1:8081", State: NODE_OK},
+		{Id: 2, Name: "batch-1", Target: "batch-1:8081", State: NODE_OK, Labels: map[string]string{"tier": "best-effort"}},
+		{Id: 3, Name: "web-1", Target: "web-1:8081", State: NODE_OK},
+	})
+
+	now := time.Now()
+	if due := m.dueOverrideNodes(now); len(due) != 2 {
+		t.Errorf("Expected both overridden nodes to be due, got %v", len(due))
+	}
+	due := m.dueOverrideNodes(now.Add(2 * time.Second))
+	if len(due) != 1 || due[0].Name != "api-1" {
+		t.Errorf("Expected just api-1 to be due, got %+v", due)
+	}
+
+	// the default routine skips the overridden nodes
+	if nodes := m.rttNodes(); len(nodes) != 1 || nodes[0].Name != "web-1" {
+		t.Errorf("Expected just web-1 for the default RTT routine, got %+v", nodes)
+	}
+}
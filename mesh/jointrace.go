@@ -0,0 +1,55 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import "github.com/telekom/canary-bot/api"
+
+// Join traces kept for the join trace endpoint
+const JOIN_TRACE_SIZE = 10
+
+// Add the decision trace of a join, only the last JOIN_TRACE_SIZE traces are kept
+func (m *Mesh) addJoinTrace(trace api.JoinTrace) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.joinTraces = append(m.joinTraces, trace)
+	if len(m.joinTraces) > JOIN_TRACE_SIZE {
+		m.joinTraces = append([]api.JoinTrace{}, m.joinTraces[len(m.joinTraces)-JOIN_TRACE_SIZE:]...)
+	}
+}
+
+// Get the decision traces of the last joins, oldest first
+func (m *Mesh) JoinTraces() []api.JoinTrace {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]api.JoinTrace{}, m.joinTraces...)
+}
+
+// Add targets not tried by a join to the trace, e.g. after the join
+func skipJoinTargets(trace *api.JoinTrace, targets []string, reason string) {
+	for _, target := range targets {
+		trace.Targets = append(trace.Targets, api.JoinTraceTarget{
+			Target:  target,
+			Outcome: api.JOIN_TRACE_SKIPPED,
+			Reason:  reason,
+		})
+	}
+}
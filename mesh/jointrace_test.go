@@ -0,0 +1,121 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/api"
+)
+
+func Test_JoinTraces(t *testing.T) {
+	m := testMesh(time.Second)
+	m.routineConfig.JoinSettleTimeout = 100 * time.Millisecond
+
+	// a seed node accepting connections without answering
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// without targets the join is traced with the reason only
+	m.Join(nil)
+	// targets not tried in the join settle timeout are skipped
+	m.Join([]string{lis.Addr().String(), "10.0.0.2:8080", "10.0.0.3:8080"})
+
+	traces := m.JoinTraces()
+	if len(traces) != 2 {
+		t.Fatalf("Expected 2 join traces, got %v", len(traces))
+	}
+	if traces[0].Reason != "no join targets" || len(traces[0].Targets) != 0 || traces[0].Duration == "" {
+		t.Errorf("Unexpected trace of a join without targets %+v", traces[0])
+	}
+	if traces[1].Joined != "" || len(traces[1].Targets) != 3 {
+		t.Fatalf("Unexpected trace of a timed out join %+v", traces[1])
+	}
+	if target := traces[1].Targets[0]; target.Target != lis.Addr().String() || target.Outcome != api.JOIN_TRACE_FAILED {
+		t.Errorf("Unexpected traced target %+v", target)
+	}
+	for i, target := range traces[1].Targets[1:] {
+		if target.Target != "10.0.0."+strconv.Itoa(i+2)+":8080" || target.Outcome != api.JOIN_TRACE_SKIPPED || target.Reason != traces[1].Reason {
+			t.Errorf("Unexpected traced target %+v", target)
+		}
+	}
+}
+
+func Test_JoinSettleTimeoutDisabled(t *testing.T) {
+	m := testMesh(time.Second)
+	m.routineConfig.JoinSettleTimeout = 0
+
+	// all targets are tried without a join settle timeout
+	m.Join([]string{"127.0.0.1:1", "127.0.0.1:2"})
+
+	traces := m.JoinTraces()
+	if len(traces) != 1 || len(traces[0].Targets) != 2 {
+		t.Fatalf("Unexpected join traces %+v", traces)
+	}
+	for _, target := range traces[0].Targets {
+		if target.Outcome != api.JOIN_TRACE_FAILED {
+			t.Errorf("Expected the target %v to be tried, got %+v", target.Target, target)
+		}
+	}
+}
+
+func Test_settleDeadline(t *testing.T) {
+	now := time.Now()
+	if deadline := settleDeadline(now, 0); !deadline.IsZero() || settled(deadline, now.Add(time.Hour)) {
+		t.Errorf("Expected no deadline for a disabled join settle timeout, got %v", deadline)
+	}
+	deadline := settleDeadline(now, time.Second)
+	if settled(deadline, now) || !settled(deadline, now.Add(2*time.Second)) {
+		t.Errorf("Unexpected settle of the deadline %v", deadline)
+	}
+}
+
+func Test_addJoinTrace(t *testing.T) {
+	m := testMesh(time.Second)
+	for i := 0; i < JOIN_TRACE_SIZE+3; i++ {
+		m.addJoinTrace(api.JoinTrace{Joined: strconv.Itoa(i)})
+	}
+
+	traces := m.JoinTraces()
+	if len(traces) != JOIN_TRACE_SIZE {
+		t.Fatalf("Expected %v join traces, got %v", JOIN_TRACE_SIZE, len(traces))
+	}
+	// the last traces are kept, oldest first
+	if traces[0].Joined != "3" || traces[JOIN_TRACE_SIZE-1].Joined != strconv.Itoa(JOIN_TRACE_SIZE+2) {
+		t.Errorf("Unexpected join traces kept %+v", traces)
+	}
+}
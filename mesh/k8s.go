@@ -0,0 +1,254 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time until the seed targets of a Kubernetes service will be listed again
+const k8sRefresh = time.Second * 10
+
+// Directory of the in-cluster service account
+var k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Resolver of the headless service DNS names
+var k8sLookupHost = net.DefaultResolver.LookupHost
+
+// Kubernetes service to seed the mesh from, the port is 0 if not set
+type k8sService struct {
+	namespace string
+	name      string
+	port      int
+}
+
+// Parse a Kubernetes service in the format [NAMESPACE/]NAME[:PORT].
+// The namespace defaults to the namespace of the service account.
+func parseK8sService(service string) (k8sService, error) {
+	s := k8sService{}
+	if name, port, found := strings.Cut(service, ":"); found {
+		p, err := strconv.Atoi(port)
+		if err != nil || p <= 0 || p > 65535 {
+			return s, fmt.Errorf("invalid port of Kubernetes service %v", service)
+		}
+		service = name
+		s.port = p
+	}
+	if namespace, name, found := strings.Cut(service, "/"); found {
+		s.namespace = namespace
+		service = name
+	}
+	if service == "" || strings.Contains(service, "/") {
+		return s, fmt.Errorf("invalid Kubernetes service %v, format: [NAMESPACE/]NAME[:PORT]", service)
+	}
+	s.name = service
+	if s.namespace == "" {
+		namespace, err := os.ReadFile(filepath.Join(k8sServiceAccountDir, "namespace"))
+		if err != nil {
+			s.namespace = "default"
+		} else {
+			s.namespace = strings.TrimSpace(string(namespace))
+		}
+	}
+	return s, nil
+}
+
+// Client of the Kubernetes API using the in-cluster service account
+type k8sClient struct {
+	host      string
+	tokenPath string
+	client    *http.Client
+}
+
+// Create a client of the API server of the cluster the pod runs in
+func newInClusterK8sClient() (*k8sClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster")
+	}
+	ca, err := os.ReadFile(filepath.Join(k8sServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid CA cert of the service account")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &k8sClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		tokenPath: filepath.Join(k8sServiceAccountDir, "token"),
+		client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// Endpoint slices of a service, just the fields of the seed targets
+type endpointSliceList struct {
+	Items []struct {
+		Endpoints []struct {
+			Addresses  []string `json:"addresses"`
+			Conditions struct {
+				Terminating *bool `json:"terminating"`
+			} `json:"conditions"`
+		} `json:"endpoints"`
+		Ports []struct {
+			Port *int `json:"port"`
+		} `json:"ports"`
+	} `json:"items"`
+}
+
+// List the host:port targets of the pods of a service by its endpoint slices.
+// Terminating pods are skipped, pods not ready yet are listed to let
+// the first pods of the mesh join each other. The port of the service is used
+// if set, else the port of the endpoint slice or the default port.
+func (c *k8sClient) serviceTargets(ctx context.Context, service k8sService, defaultPort int) ([]string, error) {
+	u := c.host + "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(service.namespace) +
+		"/endpointslices?labelSelector=" + url.QueryEscape("kubernetes.io/service-name="+service.name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the projected token is rotated, read it per request
+	token, err := os.ReadFile(c.tokenPath)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes API status %v", res.StatusCode)
+	}
+	var slices endpointSliceList
+	if err := json.NewDecoder(res.Body).Decode(&slices); err != nil {
+		return nil, err
+	}
+
+	targets := []string{}
+	for _, slice := range slices.Items {
+		port := service.port
+		if port == 0 {
+			port = defaultPort
+			if len(slice.Ports) == 1 && slice.Ports[0].Port != nil {
+				port = *slice.Ports[0].Port
+			}
+		}
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Terminating != nil && *endpoint.Conditions.Terminating {
+				continue
+			}
+			for _, address := range endpoint.Addresses {
+				targets = append(targets, net.JoinHostPort(address, strconv.Itoa(port)))
+			}
+		}
+	}
+	return targets, nil
+}
+
+// Resolve the pod IPs of a headless service by its cluster DNS name
+func lookupHeadlessService(ctx context.Context, service k8sService, defaultPort int) ([]string, error) {
+	port := service.port
+	if port == 0 {
+		port = defaultPort
+	}
+	ips, err := k8sLookupHost(ctx, service.name+"."+service.namespace+".svc")
+	if err != nil {
+		return nil, err
+	}
+	targets := []string{}
+	for _, ip := range ips {
+		targets = append(targets, net.JoinHostPort(ip, strconv.Itoa(port)))
+	}
+	return targets, nil
+}
+
+// Get the targets to join the mesh from the pods of a Kubernetes service.
+// The pods are listed by the Kubernetes API and cached for the refresh interval,
+// the headless service DNS is the fallback if the API is unreachable
+// and the static targets if both fail. The node itself is no target.
+func (m *Mesh) k8sJoinTargets() []string {
+	if time.Now().Before(m.k8sExpiry) && len(m.k8sTargets) > 0 {
+		return m.k8sTargets
+	}
+
+	log := m.logger.Named("join-routine")
+	service, err := parseK8sService(m.setupConfig.TargetK8sService)
+	if err != nil {
+		log.Warnw("Invalid Kubernetes service - using static targets", "error", err)
+		return m.setupConfig.Targets
+	}
+	defaultPort := int(m.setupConfig.ListenPort)
+	ctx, cancel := context.WithTimeout(context.Background(), m.routineConfig.RequestTimeout)
+	defer cancel()
+
+	if m.k8sClient == nil {
+		m.k8sClient, err = newInClusterK8sClient()
+	}
+	var targets []string
+	if err == nil {
+		targets, err = m.k8sClient.serviceTargets(ctx, service, defaultPort)
+	}
+	if err != nil {
+		log.Warnw("Could not list the pods of the Kubernetes service - resolving the headless service", "service", m.setupConfig.TargetK8sService, "error", err)
+		targets, err = lookupHeadlessService(ctx, service, defaultPort)
+	}
+	targets = withoutTarget(targets, m.setupConfig.advertiseTarget())
+	if err != nil || len(targets) == 0 {
+		log.Warnw("No pods of the Kubernetes service found - using static targets", "service", m.setupConfig.TargetK8sService, "error", err)
+		return m.setupConfig.Targets
+	}
+
+	log.Debugw("Listed the pods of the Kubernetes service", "service", m.setupConfig.TargetK8sService, "targets", targets)
+	m.k8sTargets = targets
+	m.k8sExpiry = time.Now().Add(k8sRefresh)
+	return targets
+}
+
+// Remove a target from the targets, e.g. the address of the node itself
+func withoutTarget(targets []string, target string) []string {
+	filtered := []string{}
+	for _, t := range targets {
+		if t != target {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,161 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func Test_parseK8sService(t *testing.T) {
+	dir := k8sServiceAccountDir
+	t.Cleanup(func() { k8sServiceAccountDir = dir })
+	k8sServiceAccountDir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(k8sServiceAccountDir, "namespace"), []byte("canary\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		service   string
+		expected  k8sService
+		expectErr bool
+	}{
+		{service: "mesh", expected: k8sService{namespace: "canary", name: "mesh"}},
+		{service: "monitoring/mesh:8081", expected: k8sService{namespace: "monitoring", name: "mesh", port: 8081}},
+		{service: "mesh:http", expectErr: true},
+		{service: "monitoring/", expectErr: true},
+		{service: "a/b/c", expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			s, err := parseK8sService(tt.service)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("Expected error %v, got %v", tt.expectErr, err)
+			}
+			if err == nil && s != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, s)
+			}
+		})
+	}
+}
+
+func Test_serviceTargets(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("sa-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/apis/discovery.k8s.io/v1/namespaces/canary/endpointslices" ||
+			r.URL.Query().Get("labelSelector") != "kubernetes.io/service-name=mesh" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"items": [
+			{"endpoints": [
+				{"addresses": ["10.0.0.1"], "conditions": {"ready": true}},
+				{"addresses": ["10.0.0.2"], "conditions": {"ready": false}},
+				{"addresses": ["10.0.0.3"], "conditions": {"ready": false, "terminating": true}}
+			], "ports": [{"name": "grpc", "port": 9090}]},
+			{"endpoints": [{"addresses": ["fd00::1"]}], "ports": []}
+		]}`))
+	}))
+	defer server.Close()
+
+	c := &k8sClient{host: server.URL, tokenPath: tokenPath, client: server.Client()}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	targets, err := c.serviceTargets(ctx, k8sService{namespace: "canary", name: "mesh"}, 8081)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(targets, []string{"10.0.0.1:9090", "10.0.0.2:9090", "[fd00::1]:8081"}); diff != nil {
+		t.Error(diff)
+	}
+
+	targets, err = c.serviceTargets(ctx, k8sService{namespace: "canary", name: "mesh", port: 8443}, 8081)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(targets, []string{"10.0.0.1:8443", "10.0.0.2:8443", "[fd00::1]:8443"}); diff != nil {
+		t.Error(diff)
+	}
+
+	if _, err := c.serviceTargets(ctx, k8sService{namespace: "other", name: "mesh"}, 8081); err == nil {
+		t.Error("Expected an error of an unknown namespace")
+	}
+}
+
+func Test_k8sJoinTargetsFallback(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	lookup := k8sLookupHost
+	t.Cleanup(func() { k8sLookupHost = lookup })
+
+	tests := []struct {
+		name     string
+		ips      []string
+		err      error
+		expected []string
+	}{
+		{name: "headless service", ips: []string{"10.0.0.1", "10.0.0.2"}, expected: []string{"10.0.0.1:8081", "10.0.0.2:8081"}},
+		{name: "static targets", err: errors.New("no such host"), expected: []string{"static:8081"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var host string
+			k8sLookupHost = func(ctx context.Context, name string) ([]string, error) {
+				host = name
+				return tt.ips, tt.err
+			}
+			m := testMesh(time.Second)
+			m.setupConfig.TargetK8sService = "default/mesh"
+			m.setupConfig.ListenPort = 8081
+			m.setupConfig.Targets = []string{"static:8081"}
+
+			if diff := deep.Equal(m.joinTargets(), tt.expected); diff != nil {
+				t.Error(diff)
+			}
+			if host != "mesh.default.svc" {
+				t.Errorf("Expected the headless service mesh.default.svc to be resolved, got %v", host)
+			}
+		})
+	}
+}
+
+func Test_withoutTarget(t *testing.T) {
+	targets := withoutTarget([]string{"10.0.0.1:8081", "10.0.0.2:8081"}, "10.0.0.1:8081")
+	if diff := deep.Equal(targets, []string{"10.0.0.2:8081"}); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Max. time the Kafka writer waits for a partition batch to fill up,
+// the sink already batches the samples
+const KAFKA_BATCH_TIMEOUT = 10 * time.Millisecond
+
+// Producer of the sample sink publishing the messages to Kafka brokers.
+// The messages are partitioned by the hash of their key, so the samples
+// of a node are kept in order on one partition.
+type kafkaProducer struct {
+	writer *kafka.Writer
+}
+
+// Create a Kafka producer of the brokers (host:port),
+// a batch is acknowledged by the leader of the partition
+func newKafkaProducer(brokers []string, batchSize int) *kafkaProducer {
+	return &kafkaProducer{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+		BatchSize:    batchSize,
+		BatchTimeout: KAFKA_BATCH_TIMEOUT,
+	}}
+}
+
+// Produce the messages to their topic, bound by the context
+func (p *kafkaProducer) Produce(ctx context.Context, messages []SinkMessage) error {
+	records := make([]kafka.Message, 0, len(messages))
+	for _, message := range messages {
+		records = append(records, kafka.Message{Topic: message.Topic, Key: message.Key, Value: message.Value})
+	}
+	return p.writer.WriteMessages(ctx, records...)
+}
@@ -0,0 +1,118 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Default limit of concurrent outbound probes per CPU
+const defaultProbesPerCpu = 16
+
+// Routines with a separate pool of outbound probes
+const (
+	PROBE_POOL_PING       = "ping"
+	PROBE_POOL_RTT        = "rtt"
+	PROBE_POOL_DISCOVERY  = "discovery"
+	PROBE_POOL_PUSH       = "push"
+	PROBE_POOL_THROUGHPUT = "throughput"
+)
+
+var probePoolNames = []string{PROBE_POOL_PING, PROBE_POOL_RTT, PROBE_POOL_DISCOVERY, PROBE_POOL_PUSH, PROBE_POOL_THROUGHPUT}
+
+// Limit of concurrent outbound probes, defaults to a bound based on GOMAXPROCS
+func probeLimit(limit int) int {
+	if limit > 0 {
+		return limit
+	}
+	return runtime.GOMAXPROCS(0) * defaultProbesPerCpu
+}
+
+// Validate the sizes of the probe pools, keys are the routines
+func validateProbePools(pools map[string]int) error {
+	for pool, size := range pools {
+		if !isProbePool(pool) {
+			return fmt.Errorf("unknown probe pool %v, supported: ping, rtt, discovery, push, throughput", pool)
+		}
+		if size < 0 {
+			return fmt.Errorf("size of probe pool %v has to be positive", pool)
+		}
+	}
+	return nil
+}
+
+func isProbePool(pool string) bool {
+	for _, name := range probePoolNames {
+		if pool == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Create the slots of the probe pools with a configured size.
+// Routines without a pool share the slots of the concurrent probe limit.
+func newProbePools(pools map[string]int) map[string]chan struct{} {
+	slots := map[string]chan struct{}{}
+	for pool, size := range pools {
+		if size > 0 {
+			slots[pool] = make(chan struct{}, size)
+		}
+	}
+	return slots
+}
+
+// Get the slots of the pool of a routine, the shared slots if no pool is set.
+// Discoveries are not limited without a pool.
+func (m *Mesh) poolSlots(pool string) chan struct{} {
+	if slots, ok := m.probePools[pool]; ok {
+		return slots
+	}
+	if pool == PROBE_POOL_DISCOVERY {
+		return nil
+	}
+	return m.probeSlots
+}
+
+// Acquire a slot of the pool of an outbound probe (ping, rtt, discovery, push samples).
+// Blocks until a slot is free, so probes exceeding the limit will queue.
+func (m *Mesh) acquireProbe(pool string) {
+	slots := m.poolSlots(pool)
+	if slots == nil {
+		return
+	}
+	m.metrics.GetProbeQueueDepth().WithLabelValues(pool).Inc()
+	slots <- struct{}{}
+	m.metrics.GetProbeQueueDepth().WithLabelValues(pool).Dec()
+	m.metrics.GetProbesInFlight().Inc()
+}
+
+// Release the slot of an outbound probe
+func (m *Mesh) releaseProbe(pool string) {
+	slots := m.poolSlots(pool)
+	if slots == nil {
+		return
+	}
+	m.metrics.GetProbesInFlight().Dec()
+	<-slots
+}
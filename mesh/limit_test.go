@@ -0,0 +1,167 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+func Test_validateProbePools(t *testing.T) {
+	if err := validateProbePools(map[string]int{"rtt": 8, "push": 0}); err != nil {
+		t.Errorf("Expected valid pools, got %v", err)
+	}
+	if err := validateProbePools(map[string]int{"http": 2}); err == nil {
+		t.Error("Expected an error for an unknown pool")
+	}
+	if err := validateProbePools(map[string]int{"ping": -1}); err == nil {
+		t.Error("Expected an error for a negative pool size")
+	}
+}
+
+func Test_probePools(t *testing.T) {
+	m := testMesh(time.Second)
+	m.probePools = newProbePools(map[string]int{PROBE_POOL_RTT: 1, PROBE_POOL_PUSH: 0})
+
+	// the shared slot is taken, the rtt pool is still free
+	m.acquireProbe(PROBE_POOL_PING)
+	acquired := make(chan struct{})
+	go func() {
+		m.acquireProbe(PROBE_POOL_RTT)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the rtt pool not to queue behind the shared slots")
+	}
+	m.releaseProbe(PROBE_POOL_RTT)
+
+	// a pool of size 0 shares the slots
+	if m.poolSlots(PROBE_POOL_PUSH) != m.probeSlots {
+		t.Error("Expected the push probes to share the slots")
+	}
+	m.releaseProbe(PROBE_POOL_PING)
+
+	// discoveries are not limited without a pool
+	if m.poolSlots(PROBE_POOL_DISCOVERY) != nil {
+		t.Error("Expected no limit of the discoveries")
+	}
+}
+
+func Test_probeLimit(t *testing.T) {
+	if limit := probeLimit(4); limit != 4 {
+		t.Errorf("Expected the configured limit 4, got %v", limit)
+	}
+	if limit := probeLimit(0); limit != runtime.GOMAXPROCS(0)*defaultProbesPerCpu {
+		t.Errorf("Expected the default limit per CPU, got %v", limit)
+	}
+}
+
+func Test_acquireProbeQueues(t *testing.T) {
+	m := testMesh(time.Second)
+
+	m.acquireProbe(PROBE_POOL_PING)
+	if inFlight := gatherValue(t, m.metrics, "probes_in_flight"); inFlight != 1 || len(m.probeSlots) != 1 {
+		t.Fatalf("Expected 1 probe in flight, got %v with %v slots taken", inFlight, len(m.probeSlots))
+	}
+
+	// the probe exceeding the limit queues until the slot is released
+	acquired := make(chan struct{})
+	go func() {
+		m.acquireProbe(PROBE_POOL_PUSH)
+		close(acquired)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for gatherValue(t, m.metrics, "probe_queue_depth", "pool", PROBE_POOL_PUSH) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if depth := gatherValue(t, m.metrics, "probe_queue_depth", "pool", PROBE_POOL_PUSH); depth != 1 {
+		t.Fatalf("Expected 1 queued probe, got %v", depth)
+	}
+	select {
+	case <-acquired:
+		t.Fatal("Expected the probe to queue behind the taken slot")
+	default:
+	}
+
+	m.releaseProbe(PROBE_POOL_PING)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the queued probe to acquire the released slot")
+	}
+	if depth, inFlight := gatherValue(t, m.metrics, "probe_queue_depth", "pool", PROBE_POOL_PUSH), gatherValue(t, m.metrics, "probes_in_flight"); depth != 0 || inFlight != 1 {
+		t.Errorf("Expected no queued probe & 1 probe in flight, got %v queued & %v in flight", depth, inFlight)
+	}
+
+	m.releaseProbe(PROBE_POOL_PUSH)
+	if inFlight := gatherValue(t, m.metrics, "probes_in_flight"); inFlight != 0 || len(m.probeSlots) != 0 {
+		t.Errorf("Expected no probe in flight, got %v with %v slots taken", inFlight, len(m.probeSlots))
+	}
+}
+
+// The RTT dial of a node accepting connections, but never
+// answering, has to time out & release the probe slot
+func Test_rttBlackholeReleasesProbe(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	m := testMesh(100 * time.Millisecond)
+	rttDone := make(chan struct{})
+	go func() {
+		m.rtt(&data.Node{Id: 1, Name: "blackhole", Target: lis.Addr().String()})
+		close(rttDone)
+	}()
+
+	acquired := make(chan struct{})
+	go func() {
+		m.acquireProbe(PROBE_POOL_PING)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		m.releaseProbe(PROBE_POOL_PING)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the ping to acquire the slot after the RTT dial timed out")
+	}
+	<-rttDone
+	if failed := gatherValue(t, m.metrics, "probe_outcomes_total", "probe_type", PROBE_RTT); failed != 1 {
+		t.Errorf("Expected 1 failed RTT probe, got %v", failed)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build !linux && !darwin && !freebsd
+
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"errors"
+	"syscall"
+)
+
+// SO_REUSEPORT is not supported on this platform
+func setReusePort(c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT not supported on this platform")
+}
+
+// Setting the listen backlog is not supported on this platform
+func setBacklog(c syscall.RawConn, backlog int) error {
+	return errors.New("listen backlog not supported on this platform")
+}
@@ -0,0 +1,55 @@
+//go:build linux || darwin || freebsd
+
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Enable SO_REUSEPORT of the socket, multiple sockets can listen on the same port
+func setReusePort(c syscall.RawConn) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// Set the backlog of a listening socket by listening again,
+// the backlog is capped by the kernel (net.core.somaxconn on linux)
+func setBacklog(c syscall.RawConn, backlog int) error {
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.Listen(int(fd), backlog)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
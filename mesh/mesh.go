@@ -22,9 +22,16 @@
 package mesh
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/telekom/canary-bot/api"
@@ -34,6 +41,8 @@ import (
 	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 )
 
 // Mesh is the internal mesh representation
@@ -53,8 +62,18 @@ type Mesh struct {
 	clients map[uint32]*MeshClient
 	mu      sync.Mutex
 
+	// Semaphore limiting the concurrent outbound probes
+	probeSlots chan struct{}
+	// Semaphores of the routines with a separate probe pool
+	probePools map[string]chan struct{}
+	// Shared secret of the mesh RPCs, nil if disabled
+	meshToken *meshToken
+	// Sample rate of the successful inbound RPCs logged by the request log
+	requestLog *requestLogRate
+
 	// Channel if a new node is discovered in the mesh
 	newNodeDiscovered chan NodeDiscovered
+	nodeLeft          chan *meshv1.Node
 
 	// timerRoutine main functionality timers
 	pingTicker       *time.Ticker
@@ -63,17 +82,112 @@ type Mesh struct {
 
 	// timerRoutine sample measurement timers
 	rttTicker *time.Ticker
+	// Ticker of the heartbeat samples of this node
+	heartbeatTicker *time.Ticker
+	// Ticker of the throughput probes, a probe is running
+	throughputTicker  *time.Ticker
+	throughputRunning atomic.Bool
+	// Counter of the heartbeat samples
+	heartbeat atomic.Uint64
+	// Ticker of the RTT measurements of nodes with overridden intervals
+	rttOverrideTicker *time.Ticker
+	// Ticker evicting the idle clients, stopped if disabled
+	clientIdleTicker *time.Ticker
+	// Probe interval overrides by node name or label
+	probeOverrides []ProbeIntervalOverride
+	// Schedule windows of the routines and probes by cron expressions
+	probeSchedules []ProbeSchedule
+	// Peering rules of a partial mesh, all nodes are peered if empty
+	peeringRules []PeeringRule
+	// Readiness policy of the API readiness, nil if ready unless paused
+	readiness *readinessPolicy
+	// Last RTT measurement of the overridden nodes, used by the timer routines only
+	overrideProbed map[uint32]time.Time
+	// Round of the consistent-hash RTT node selection
+	rttRound atomic.Uint64
+	// Reason the one-way delay of a node was skipped by the last ping,
+	// empty if measured; guarded by mu
+	oneWayDelaySkipped map[uint32]string
+	// Info of the nodes by the GetInfo RPC or the join; guarded by mu
+	peerInfo map[uint32]*meshv1.NodeInfo
+	// Query of the info of the nodes is running
+	peerInfoRunning atomic.Bool
+	// Versions of the peer version series, guarded by versionsMu
+	versionsMu   sync.Mutex
+	peerVersions map[string]bool
+	// Pending & non-peered nodes currently pinged by the ping routine; guarded by mu
+	pendingPings map[uint32]bool
+	// First failed ping of the nodes never contacted by this node; guarded by mu
+	firstFailedPings map[uint32]time.Time
+	// Samples stored by this node, the unsynced samples of the peers are tracked by it
+	receipts *receiptLog
+	// Nodes sending samples with legacy ids, the samples are migrated once the nodes are known
+	legacySenders *legacySenders
+	// Receipt sequence at the start of the last successful sample push per node; guarded by mu
+	lastPushed map[uint32]uint64
+	// Peers with an unsynced sample age series by id; guarded by mu
+	unsyncedPeers map[uint32]string
+	// Last RTT measurements per node for the health score
+	health *healthTracker
+	// Rolling RTT baselines per node, nil if the anomaly detection is disabled
+	anomalies *anomalyTracker
+	// Retry budget shared by the routines, nil if disabled
+	retryBudget *retryBudget
+	// Sink publishing the samples of this node, nil if disabled
+	sink *sampleSink
+	// StatsD sink of the samples measured by this node, nil if disabled
+	statsd *statsdSink
+	// DNS cache of the mesh dialer, nil if disabled
+	dnsCache *dnsCache
+	// First join attempt of the current join routine, for the time-to-join
+	joinStart time.Time
+	// Name of this node, renamed after repeated name collisions
+	name *nodeName
+	// Consecutive joins rejected by a name collision; used by the join routine only
+	nameCollisions int
+	// Decision traces of the last joins, oldest first; guarded by mu
+	joinTraces []api.JoinTrace
+
+	// Seed targets resolved from a SRV record, cached until expiry
+	srvTargets []string
+	srvExpiry  time.Time
+	// Seed targets listed from a Kubernetes service, cached until expiry
+	k8sClient  *k8sClient
+	k8sTargets []string
+	k8sExpiry  time.Time
+	// Peers discovered by multicast, nil if disabled
+	multicast *multicastDiscovery
 
 	// Channels to quit and re-enter mesh joinRoutine
 	quitJoinRoutine    chan bool
 	restartJoinRoutine chan bool
 	joinRoutineDone    bool
+
+	// gRPC mesh server, guarded by mu
+	grpcServer   *grpc.Server
+	healthServer *health.Server
+	// Mesh server is draining before shutdown
+	draining atomic.Bool
+	// Context of the background routines, canceled on shutdown
+	routines     context.Context
+	stopRoutines context.CancelFunc
+	// Outbound probes are paused, e.g. during a maintenance
+	paused atomic.Bool
+	// Active inbound RPCs & connections of the mesh server
+	inboundStreams atomic.Int64
+	inboundConns   atomic.Int64
 }
 
 // NodeDiscovered represents a newly discovered node in the mesh
 type NodeDiscovered struct {
 	NewNode *meshv1.Node
 	From    uint32 // TODO change to name
+	// Deadline for the discovery broadcast to other nodes
+	Deadline time.Time
+	// Last contact with the new node in unix seconds, 0 if unknown
+	LastSeen int64
+	// Depth of the discovery, 0 at the node the new node joined
+	Depth uint32
 }
 
 // CreateCanaryMesh creates a canary bot & mesh with the desired configuration
@@ -99,48 +213,98 @@ func CreateCanaryMesh(routineConfig *RoutineConfiguration, setupConfig *SetupCon
 	// Get info from configuration combination
 	setupConfig.checkDefaults(logger)
 
-	// prepare in-memory database
-	database, err := data.NewMemDB(logger.Named("database"))
+	m, err := newMesh(routineConfig, setupConfig, logger)
 	if err != nil {
-		logger.Fatalf("Could not create Memory Database (MemDB) - Error: %+v", err)
+		logger.Fatalf("Could not create the mesh - Error: %+v", err)
 	}
+	database := m.database
+	metrics := m.metrics
 
-	// init metrics
-	metrics := metric.InitMetrics()
+	// parse external probes
+	var probes []*Probe
+	for _, p := range setupConfig.Probes {
+		probe, err := ParseProbe(p, setupConfig.ProbeInterval)
+		if err != nil {
+			logger.Fatalf("Could not parse probe - Error: %+v", err)
+		}
+		probes = append(probes, probe)
+	}
 
-	m := &Mesh{
-		database:           database,
-		metrics:            metrics,
-		logger:             logger,
-		routineConfig:      routineConfig,
-		setupConfig:        setupConfig,
-		clients:            map[uint32]*MeshClient{},
-		newNodeDiscovered:  make(chan NodeDiscovered),
-		quitJoinRoutine:    make(chan bool, 1),
-		restartJoinRoutine: make(chan bool, 1),
-		joinRoutineDone:    false,
+	if !setupConfig.DisableMesh {
+		logger.Info("Starting mesh")
+
+		// start mesh server
+		go func() {
+			logger.Info("Starting server, listening vor joining nodes")
+			err := m.StartServer()
+			if err != nil {
+				logger.Debugf("Mesh server error: %+v", err)
+				logger.Fatal("Could not start Mesh Server")
+			}
+		}()
+	} else {
+		logger.Info("Mesh disabled - just probing external targets")
 	}
-	logger.Info("Starting mesh")
 
-	// start mesh server
-	go func() {
-		logger.Info("Starting server, listening vor joining nodes")
-		err := m.StartServer()
-		if err != nil {
-			logger.Debugf("Mesh server error: %+v", err)
-			logger.Fatal("Could not start Mesh Server")
-		}
-	}()
+	// drain the mesh server on shutdown
+	if setupConfig.ExitOnSignal {
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+			<-sig
+			logger.Info("Shutting down")
+			m.Drain()
+			os.Exit(0)
+		}()
+	}
+
+	// publish the samples
+	if m.paused.Load() {
+		logger.Warnw("PAUSED - outbound probes are suspended by the persisted paused state, resume the node to probe again", "path", setupConfig.PauseStatePath)
+	}
+	m.observePaused()
+
+	if m.sink != nil {
+		logger.Infow("Publishing samples to the sink", "brokers", setupConfig.SinkKafkaBrokers, "topic", setupConfig.SinkTopic, "format", m.sink.format)
+		go m.sink.run(context.Background())
+	}
+	if m.statsd != nil {
+		logger.Infow("Sending samples to StatsD", "address", setupConfig.StatsdAddress, "format", m.statsd.format)
+		go m.statsd.run()
+	}
 
 	// start main mesh functionality
-	logger.Infow("Starting mesh routines")
-	go m.channelRoutines()
-	go m.timerRoutines()
+	if !setupConfig.DisableMesh {
+		if m.multicast != nil {
+			logger.Infow("Discovering peers by multicast", "group", setupConfig.DiscoveryMulticast, "timeout", setupConfig.DiscoveryMulticastTimeout.String())
+			go m.multicast.run()
+		}
+		logger.Infow("Starting mesh routines")
+		go m.channelRoutines()
+		go m.timerRoutines()
+	}
+
+	// aggregate the samples per window
+	if setupConfig.AggregationWindow > 0 {
+		logger.Infow("Aggregating samples", "window", setupConfig.AggregationWindow.String())
+		go metrics.StartAggregation(m.routines, database, setupConfig.AggregationWindow)
+	}
+
+	// start external probes
+	for _, probe := range probes {
+		go m.probeRoutine(probe)
+	}
 
 	// start API
+	apiUnits, err := setupConfig.apiUnits()
+	if err != nil {
+		logger.Fatalf("Invalid API units - Error: %+v", err)
+	}
 	apiConfig := &api.Configuration{
 		NodeName:       setupConfig.Name,
-		Address:        setupConfig.ListenAddress,
+		NodeTarget:     setupConfig.advertiseTarget(),
+		NodeLabels:     setupConfig.Labels,
+		Address:        setupConfig.tcpListenAddress(),
 		Port:           setupConfig.ApiPort,
 		Tokens:         setupConfig.Tokens,
 		DebugGrpc:      setupConfig.DebugGrpc,
@@ -150,6 +314,33 @@ func CreateCanaryMesh(routineConfig *RoutineConfiguration, setupConfig *SetupCon
 		ServerKey:      setupConfig.ServerKey,
 		CaCertPath:     setupConfig.CaCertPath,
 		CaCert:         setupConfig.CaCert,
+		CaCertSystem:   setupConfig.CaCertSystem,
+
+		SampleStaleAfter: routineConfig.SampleStaleAfter,
+		Units:            apiUnits,
+		Pauser:           m,
+		JoinTracer:       m,
+		NodeNamer:        m.name.get,
+	}
+	if m.readiness != nil {
+		apiConfig.Readiness = m
+	}
+
+	// start dedicated metrics server
+	if setupConfig.MetricsPort != 0 {
+		metricsConfig := &api.MetricsConfiguration{
+			Address:   setupConfig.tcpListenAddress(),
+			Port:      setupConfig.MetricsPort,
+			CertPath:  setupConfig.MetricsCertPath,
+			KeyPath:   setupConfig.MetricsKeyPath,
+			BasicAuth: setupConfig.MetricsBasicAuth,
+			Tokens:    setupConfig.MetricsTokens,
+		}
+		go func() {
+			if err := api.StartMetricsServer(database, metrics, metricsConfig, logger.Named("metrics")); err != nil {
+				logger.Fatalf("Could not start metrics server - Error: %+v", err)
+			}
+		}()
 	}
 
 	// start the mesh API
@@ -158,10 +349,229 @@ func CreateCanaryMesh(routineConfig *RoutineConfiguration, setupConfig *SetupCon
 	}
 }
 
+// Create the mesh with an in-memory database and metrics.
+// No routines or servers will be started.
+func newMesh(routineConfig *RoutineConfiguration, setupConfig *SetupConfiguration, logger *zap.SugaredLogger) (*Mesh, error) {
+	// the config of the caller is kept unchanged by the defaults
+	config := *routineConfig
+	routineConfig = &config
+	routineConfig.NodeStates = routineConfig.NodeStates.withDefaults(routineConfig.PingRetryAmount)
+	if err := routineConfig.NodeStates.validate(); err != nil {
+		return nil, err
+	}
+	if err := routineConfig.RetryBudget.validate(); err != nil {
+		return nil, err
+	}
+	if routineConfig.PushSampleToAmount < 0 {
+		return nil, errors.New("push sample amount has to be positive")
+	}
+	if routineConfig.HealthWindow > 0 {
+		if err := setupConfig.HealthWeights.validate(); err != nil {
+			return nil, err
+		}
+		if setupConfig.HealthRttBaseline <= 0 {
+			return nil, errors.New("health RTT baseline has to be greater than 0")
+		}
+	}
+	if setupConfig.JoinCoalesceWindow > 0 && routineConfig.JoinSettleTimeout > 0 && setupConfig.JoinCoalesceWindow >= routineConfig.JoinSettleTimeout {
+		return nil, errors.New("join coalesce window has to be shorter than the join settle timeout")
+	}
+	if setupConfig.MaxRecvMsgSize != 0 && setupConfig.MaxRecvMsgSize < MIN_RECV_MSG_SIZE {
+		return nil, fmt.Errorf("max. inbound message size has to be at least %v bytes", MIN_RECV_MSG_SIZE)
+	}
+	if setupConfig.ClientIdleTimeout > 0 && setupConfig.ClientIdleTimeout <= routineConfig.RequestTimeout {
+		return nil, errors.New("client idle timeout has to be longer than the request timeout")
+	}
+	var probeOverrides []ProbeIntervalOverride
+	if len(setupConfig.ProbeIntervalOverrides) > 0 && setupConfig.ProbeIntervalMin <= 0 {
+		return nil, errors.New("min. probe interval has to be greater than 0")
+	}
+	for _, o := range setupConfig.ProbeIntervalOverrides {
+		override, err := ParseProbeIntervalOverride(o, setupConfig.ProbeIntervalMin)
+		if err != nil {
+			return nil, err
+		}
+		probeOverrides = append(probeOverrides, override)
+	}
+	probeSchedules, err := parseProbeSchedules(setupConfig)
+	if err != nil {
+		return nil, err
+	}
+	paused, err := pausedState(setupConfig.PauseStatePath)
+	if err != nil {
+		return nil, err
+	}
+	var peeringRules []PeeringRule
+	for _, p := range setupConfig.Peering {
+		rule, err := ParsePeeringRule(p)
+		if err != nil {
+			return nil, err
+		}
+		peeringRules = append(peeringRules, rule)
+	}
+	readiness, err := newReadinessPolicy(setupConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare in-memory database
+	database, err := data.NewMemDB(logger.Named("database"))
+	if err != nil {
+		return nil, err
+	}
+	database.SetEventLogSize(setupConfig.EventLogSize)
+	if setupConfig.SampleSpillPath != "" {
+		if err := database.EnableSpill(setupConfig.SampleSpillPath, setupConfig.SampleSpillThreshold); err != nil {
+			return nil, err
+		}
+		logger.Infow("Spilling old samples to disk", "path", setupConfig.SampleSpillPath, "threshold", setupConfig.SampleSpillThreshold)
+	}
+
+	// init metrics
+	metrics := metric.InitMetrics()
+	metrics.SetSampleStaleAfter(routineConfig.SampleStaleAfter)
+	if setupConfig.Aggregator {
+		logger.Infow("Exporting the samples of the mesh as aggregator", "max-series", setupConfig.AggregatorMaxSeries)
+		metrics.SetAggregator(sampleKeys(setupConfig.AggregatorExcludeSamples), setupConfig.AggregatorMaxSeries)
+	}
+	metricUnits, err := setupConfig.metricUnits()
+	if err != nil {
+		return nil, err
+	}
+	metrics.SetUnits(metricUnits)
+	rounding, err := data.NewSampleRounding(setupConfig.SampleRounding)
+	if err != nil {
+		return nil, err
+	}
+	if setupConfig.SampleRoundingStorage {
+		database.SetSampleRounding(rounding)
+	} else {
+		metrics.SetRounding(rounding)
+	}
+	history, err := data.NewSampleHistory(setupConfig.SampleHistory)
+	if err != nil {
+		return nil, err
+	}
+	database.SetSampleHistory(history)
+	if err := setSelfSamples(setupConfig.SelfSamples, GetId(&meshv1.Node{Target: setupConfig.advertiseTarget()}), &database, metrics); err != nil {
+		return nil, err
+	}
+	if len(setupConfig.ExportSamples) > 0 {
+		logger.Infow("Exporting just a part of the sample types as metrics", "samples", setupConfig.ExportSamples)
+		metrics.SetExportSamples(sampleKeys(setupConfig.ExportSamples))
+	}
+	if setupConfig.RttEdgeLabels {
+		metrics.SetRttEdgeLabels(setupConfig.Name)
+	}
+	metricLabels, err := setupConfig.metricLabels()
+	if err != nil {
+		return nil, err
+	}
+	if len(metricLabels) > 0 {
+		if err := metrics.SetConstLabels(metricLabels); err != nil {
+			return nil, err
+		}
+	}
+	database.SetLocalSampleGroup(setupConfig.ProbeGroup)
+	name := newNodeName(setupConfig.Name)
+
+	// publish the samples measured by this node
+	sink, err := newSampleSink(setupConfig, name, routineConfig.RequestTimeout, metrics, logger.Named("sink"))
+	if err != nil {
+		return nil, err
+	}
+	statsd, err := newStatsdSink(setupConfig, name, metrics, logger.Named("statsd"))
+	if err != nil {
+		return nil, err
+	}
+
+	// discover the peers by multicast
+	multicast, err := newMulticastDiscovery(setupConfig, name, logger.Named("multicast"))
+	if err != nil {
+		return nil, err
+	}
+	receipts := &receiptLog{}
+	legacy := &legacySenders{}
+	database.SetSampleHook(func(sample *data.Sample) {
+		receipts.add(time.Now())
+		if sample.FromId == 0 {
+			legacy.add(sample.From)
+		}
+		if sample.From != name.get() {
+			return
+		}
+		if sink != nil {
+			sink.emit(sample)
+		}
+		if statsd != nil && metrics.ExportsSample(sample.Key) {
+			statsd.emit(sample)
+		}
+	})
+
+	// authenticate the mesh RPCs
+	token, err := newMeshToken(setupConfig.MeshToken, setupConfig.MeshTokenPath, logger.Named("auth"))
+	if err != nil {
+		return nil, err
+	}
+	// log the inbound RPCs by the sample rate
+	requestLog, err := newRequestLogRate(setupConfig.RequestLogSampleRate, setupConfig.RequestLogSampleRatePath, metrics, logger.Named("request-log"))
+	if err != nil {
+		return nil, err
+	}
+
+	// track RTT measurements for the health score
+	var health *healthTracker
+	if routineConfig.HealthWindow > 0 {
+		health = newHealthTracker(routineConfig.HealthWindow)
+	}
+	// track the RTT baselines for the anomaly detection
+	var anomalies *anomalyTracker
+	if setupConfig.RttAnomalyThreshold > 0 {
+		anomalies = newAnomalyTracker(setupConfig.RttAnomalyAlpha, setupConfig.RttAnomalyThreshold, setupConfig.RttAnomalyWarmup, setupConfig.RttAnomalyMinDeviation)
+	}
+
+	m := &Mesh{
+		database:           database,
+		metrics:            metrics,
+		logger:             logger,
+		routineConfig:      routineConfig,
+		setupConfig:        setupConfig,
+		name:               name,
+		clients:            map[uint32]*MeshClient{},
+		probeSlots:         make(chan struct{}, probeLimit(setupConfig.MaxConcurrentProbes)),
+		probePools:         newProbePools(setupConfig.ProbePools),
+		meshToken:          token,
+		requestLog:         requestLog,
+		newNodeDiscovered:  make(chan NodeDiscovered),
+		nodeLeft:           make(chan *meshv1.Node),
+		quitJoinRoutine:    make(chan bool, 1),
+		restartJoinRoutine: make(chan bool, 1),
+		joinRoutineDone:    false,
+		receipts:           receipts,
+		legacySenders:      legacy,
+		health:             health,
+		anomalies:          anomalies,
+		retryBudget:        newRetryBudget(routineConfig.RetryBudget, time.Now()),
+		dnsCache:           newDnsCache(setupConfig.DnsCacheTTL, setupConfig.DnsCacheGrace),
+		probeOverrides:     probeOverrides,
+		probeSchedules:     probeSchedules,
+		peeringRules:       peeringRules,
+		readiness:          readiness,
+		sink:               sink,
+		statsd:             statsd,
+		multicast:          multicast,
+		overrideProbed:     map[uint32]time.Time{},
+	}
+	m.routines, m.stopRoutines = context.WithCancel(context.Background())
+	m.paused.Store(paused)
+	return m, nil
+}
+
 // Routines that will be executed by timer interrupts.
 // In the startup phase, just the joinRoutine timer will run
 // After joining a mesh or a node is joining all routines
 // will be started and the join Routine will stop.
+// The timer routines stop on shutdown.
 func (m *Mesh) timerRoutines() {
 	// Timer to send ping to node
 	joinTicker := time.NewTicker(m.routineConfig.JoinInterval)
@@ -178,31 +588,79 @@ func (m *Mesh) timerRoutines() {
 	// Sample measurement: RTT
 	m.rttTicker = time.NewTicker(m.routineConfig.RttInterval)
 	m.rttTicker.Stop()
+	// Sample: heartbeat, the counter continues after a restart
+	m.heartbeatTicker = time.NewTicker(m.routineConfig.RttInterval)
+	m.heartbeatTicker.Stop()
+	m.heartbeat.Store(heartbeatSeed(time.Now(), m.routineConfig.HeartbeatInterval))
+	// Sample measurement: throughput
+	m.throughputTicker = time.NewTicker(m.routineConfig.RttInterval)
+	m.throughputTicker.Stop()
+	// Overridden intervals are checked in the min. interval
+	m.rttOverrideTicker = time.NewTicker(m.routineConfig.RttInterval)
+	m.rttOverrideTicker.Stop()
+	// Idle clients are evicted in half of the idle timeout
+	m.clientIdleTicker = time.NewTicker(time.Hour)
+	m.clientIdleTicker.Stop()
+	if m.setupConfig.ClientIdleTimeout > 0 {
+		m.clientIdleTicker.Reset(m.setupConfig.ClientIdleTimeout / 2)
+	}
+
+	// Timer to delay the probing after a join, the mesh can settle
+	warmupTimer := time.NewTimer(m.routineConfig.ProbeWarmup)
+	warmupTimer.Stop()
+
+	// Join attempts after a failed join are retries
+	joinFailed := false
 
 	for {
 		select {
 		case <-joinTicker.C:
 			joinTicker.Stop()
 			log := m.logger.Named("join-routine")
+			if !joinFailed {
+				m.countRequest()
+			} else if !m.allowRetry("join") {
+				log.Infow("Retry budget exhausted - skip join attempt")
+				joinTicker.Reset(m.routineConfig.JoinInterval)
+				break
+			}
 			// join (future) mesh
 			log.Infow("Waiting for a node to join a mesh...")
-			connected, isNameUniqueInMesh := m.Join(m.setupConfig.Targets)
+			connected, isNameUniqueInMesh := m.Join(m.withMulticastTargets(m.shuffleTargets(m.joinTargets())))
 			if !isNameUniqueInMesh {
-				log.Fatal("The name is not unique in the mesh, please choose another one.")
+				// exits or retries the join, see the name collision action
+				m.nameCollision(log)
+				connected = false
+			} else {
+				m.nameCollisions = 0
 			}
+			joinFailed = !connected
 			if connected {
 				log.Infow("Connected to a mesh")
 				m.quitJoinRoutine <- true
 			} else {
+				m.recordEvent(data.EVENT_JOIN_FAILED, "", "could not join a mesh by the targets")
 				joinTicker.Reset(m.routineConfig.JoinInterval)
 			}
 
 		case <-m.pingTicker.C:
 			log := m.logger.Named("ping-routine")
+			if m.paused.Load() {
+				log.Debugw("Paused - skipped")
+				break
+			}
+			// a ping after the leave would clear the tombstone at the peer
+			if m.draining.Load() {
+				log.Debugw("Draining - skipped")
+				break
+			}
 			log.Debugw("Starting")
 
-			// get a random healthy node
-			nodes := m.database.GetRandomNodeListByState(NODE_OK, 1)
+			// verify the discovered nodes not contacted yet
+			m.pingPendingNodes()
+
+			// get a random healthy peer
+			nodes := m.randomPeers(1)
 			if len(nodes) == 0 {
 				log.Debugw("No Node connected or all nodes in timeout")
 				break
@@ -215,8 +673,10 @@ func (m *Mesh) timerRoutines() {
 			log := m.logger.Named("sample-routine")
 			log.Debugw("Starting push sample routine to random nodes", "amount", m.routineConfig.PushSampleToAmount)
 
-			// get random, configured amount of healthy nodes
-			nodes := m.database.GetRandomNodeListByState(NODE_OK, m.routineConfig.PushSampleToAmount)
+			// get random, configured amount of healthy peers
+			nodes := m.randomPeers(m.routineConfig.PushSampleToAmount)
+			m.observeGossip(len(nodes))
+			m.observeUnsynced(time.Now())
 			if len(nodes) == 0 {
 				log.Debugw("No node connected or all nodes in timeout")
 				break
@@ -229,6 +689,28 @@ func (m *Mesh) timerRoutines() {
 			}
 
 		case <-m.cleanupTicker.C:
+			// remove expired tombstones of left nodes
+			m.database.DeleteExpiredTombstones(m.routineConfig.TombstoneTTL)
+
+			// move samples of older nodes to the node ids once the nodes are known
+			m.migrateLegacySenders()
+
+			// query the info of nodes discovered by other nodes,
+			// an observer node does not query other nodes
+			if !m.setupConfig.Observer {
+				go m.queryPeerInfos()
+			}
+
+			// check the liveness of a node not probed by the peering rules
+			if !m.paused.Load() && !m.draining.Load() {
+				m.pingNonPeer()
+			}
+
+			// remove dead nodes
+			if m.routineConfig.NodeStates.RemoveAfter > 0 {
+				m.removeDeadNodes()
+			}
+
 			// check if node is timed-out and over maxAge
 			if m.setupConfig.CleanupNodes {
 				for _, node := range m.database.GetNodeListByState(NODE_DEAD) {
@@ -242,78 +724,311 @@ func (m *Mesh) timerRoutines() {
 			if m.setupConfig.CleanupSamples {
 				for _, sample := range m.database.GetSampleList() {
 					if time.Unix(sample.Ts, 0).Before(time.Now().Add(-1 * m.routineConfig.CleanupMaxAge)) {
-						m.logger.Infow("Delete old sample", "from", sample.From, "to", sample.To, "key", data.SampleName[sample.Key], "maxAge", m.routineConfig.CleanupMaxAge.String())
+						m.logger.Infow("Delete old sample", "from", sample.From, "to", sample.To, "key", data.SampleName(sample.Key), "maxAge", m.routineConfig.CleanupMaxAge.String())
 						m.database.DeleteSample(sample.Id)
 					}
 				}
 			}
 
-		case <-m.rttTicker.C:
+		case now := <-m.rttTicker.C:
 			// measure round-trip-time samples
-			go m.Rtt()
+			if m.probing(now, SCHEDULE_RTT) {
+				go m.Rtt()
+			}
+
+		case <-m.heartbeatTicker.C:
+			m.emitHeartbeat()
+
+		case now := <-m.throughputTicker.C:
+			// measure the throughput to a node
+			if m.probing(now, SCHEDULE_THROUGHPUT) {
+				go m.Throughput()
+			}
+
+		case now := <-m.rttOverrideTicker.C:
+			// measure round-trip-time samples of nodes with overridden intervals
+			if !m.probing(now, SCHEDULE_RTT) {
+				continue
+			}
+			for _, node := range m.dueOverrideNodes(now) {
+				go m.rtt(node)
+			}
+
+		case now := <-m.clientIdleTicker.C:
+			m.evictIdleClients(now)
 
 		case <-m.restartJoinRoutine:
 			// stop ticker and re-enter joinRoutine
 			joinTicker.Reset(m.routineConfig.JoinInterval)
 			m.joinRoutineDone = false
+			m.joinStart = time.Time{}
+			joinFailed = false
 
-			m.pingTicker.Stop()
-			m.pushSampleTicker.Stop()
-			m.cleanupTicker.Stop()
-			m.rttTicker.Stop()
+			warmupTimer.Stop()
+			m.stopTimerRoutines()
 			m.logger.Debug("Start joinRoutine again, stopping all timer routines")
 		case <-m.quitJoinRoutine:
 			joinTicker.Stop()
 			m.joinRoutineDone = true
+			// move samples with legacy ids, e.g. spilled before an upgrade, to the known node ids
+			m.migrateSampleIds(m.sampleIds())
 			// starting ticker after joinRoutine
-			m.pingTicker.Reset(m.routineConfig.PingInterval)
-			m.pushSampleTicker.Reset(m.routineConfig.PushSampleInterval)
 			m.cleanupTicker.Reset(m.routineConfig.CleanupInterval)
-			m.rttTicker.Reset(m.routineConfig.RttInterval)
-			m.logger.Info("Starting pings")
-			m.logger.Debug("Stop joinRoutine, starting all timer routines")
+			// an observer node will not probe other nodes
+			if m.setupConfig.Observer {
+				m.logger.Info("Observer mode - no pings, RTT measurements and sample pushes will be sent")
+				m.logger.Debug("Stop joinRoutine, starting cleanup timer routine")
+				break
+			}
+			m.logger.Debug("Stop joinRoutine")
+			if m.routineConfig.ProbeWarmup > 0 {
+				m.logger.Infow("Warming up before starting pings", "warmup", m.routineConfig.ProbeWarmup.String())
+				warmupTimer.Reset(m.routineConfig.ProbeWarmup)
+				break
+			}
+			m.startProbing()
+		case <-warmupTimer.C:
+			m.startProbing()
+		case <-m.routines.Done():
+			joinTicker.Stop()
+			warmupTimer.Stop()
+			m.clientIdleTicker.Stop()
+			m.stopTimerRoutines()
+			m.logger.Debug("Shutdown, stopping all timer routines")
+			return
 		}
 	}
 }
 
+// Stop the timer routines started after the join
+func (m *Mesh) stopTimerRoutines() {
+	m.pingTicker.Stop()
+	m.pushSampleTicker.Stop()
+	m.cleanupTicker.Stop()
+	m.rttTicker.Stop()
+	m.rttOverrideTicker.Stop()
+	m.heartbeatTicker.Stop()
+	m.throughputTicker.Stop()
+}
+
+// Start the timer routines probing other nodes
+func (m *Mesh) startProbing() {
+	m.pingTicker.Reset(m.routineConfig.PingInterval)
+	m.pushSampleTicker.Reset(m.routineConfig.PushSampleInterval)
+	m.rttTicker.Reset(m.routineConfig.RttInterval)
+	if len(m.probeOverrides) > 0 {
+		m.rttOverrideTicker.Reset(m.setupConfig.ProbeIntervalMin)
+	}
+	if m.routineConfig.HeartbeatInterval > 0 {
+		m.heartbeatTicker.Reset(m.routineConfig.HeartbeatInterval)
+	}
+	if m.setupConfig.ThroughputInterval > 0 {
+		m.throughputTicker.Reset(m.setupConfig.ThroughputInterval)
+	}
+	m.logger.Info("Starting pings")
+	m.logger.Debug("Starting all timer routines")
+}
+
 // Routines that will be executed by event/channel interrupts.
 // Events:
 // - nodeDiscovered: A new node is discovered in the mesh
+// - nodeLeft: A node left the mesh on a clean shutdown
 func (m *Mesh) channelRoutines() {
+	// joins waiting for the end of the coalescing window
+	var joins map[uint32]NodeDiscovered
+	var flushJoins <-chan time.Time
+
 	for {
 		select {
 		case nodeDiscovered := <-m.newNodeDiscovered:
 			log := m.logger.Named("discovery-routine")
+			newNodeId := GetId(nodeDiscovered.NewNode)
+			nodeDiscovered.LastSeen = m.lastContact(newNodeId, nodeDiscovered.LastSeen)
+			if nodeDiscovered.From == newNodeId {
+				// the node itself joined, it is back in the mesh
+				m.database.DeleteTombstone(newNodeId)
+			} else if m.database.DeleteTombstoneBefore(newNodeId, nodeDiscovered.LastSeen) {
+				// the node was seen after it left, e.g. it joined again by another node
+				log.Infow("Node joined again after it left the mesh", "node", nodeDiscovered.NewNode.Name)
+			} else if m.database.IsTombstoned(newNodeId, m.routineConfig.TombstoneTTL) {
+				log.Infow("Node left the mesh recently - skip discovery", "node", nodeDiscovered.NewNode.Name)
+				break
+			} else if m.isStaleDiscovery(nodeDiscovered, time.Now()) {
+				log.Infow("Last contact with the node is too old - skip discovery", "node", nodeDiscovered.NewNode.Name, "lastSeen", time.Unix(nodeDiscovered.LastSeen, 0).String())
+				m.metrics.GetStaleDiscoveries().Inc()
+				break
+			}
 			// quit joinMesh routine if discovery is received before
 			if !m.joinRoutineDone {
 				m.quitJoinRoutine <- true
 			}
-			if m.database.GetNodeByName(nodeDiscovered.NewNode.Name).Id != 0 {
+			if _, ok := m.database.GetNodeByName(nodeDiscovered.NewNode.Name); ok {
 				log.Info("Node is rejoining node")
-				m.database.SetNode(data.Convert(nodeDiscovered.NewNode, NODE_OK))
+				// a known node is not forwarded
+				if nodeDiscovered.From != newNodeId {
+					m.metrics.GetDiscoveryForwardsSuppressed().WithLabelValues(metric.DISCOVERY_KNOWN).Inc()
+				}
+				m.recordEvent(data.EVENT_JOIN, nodeDiscovered.NewNode.Name, "node rejoined")
+				m.setNodeState(nodeDiscovered.NewNode, NODE_OK)
 				break
 			}
 
 			log.Info("Node joined - new node")
-			log.Debugw("Starting discovery broadcast routine to random nodes", "amount", m.routineConfig.BroadcastToAmount)
+			m.recordEvent(data.EVENT_JOIN, nodeDiscovered.NewNode.Name, "new node joined")
+			m.database.SetNode(data.Convert(nodeDiscovered.NewNode, m.discoveredState(nodeDiscovered.NewNode)))
 
-			nodes := m.database.GetRandomNodeListByState(NODE_OK, m.routineConfig.BroadcastToAmount, nodeDiscovered.From)
-
-			if len(nodes) == 0 {
-				log.Debug("Stopping routine prematurely - no more known nodes")
+			// coalesce the joins at this node within the window
+			if m.setupConfig.JoinCoalesceWindow > 0 && nodeDiscovered.From == newNodeId {
+				if joins == nil {
+					joins = map[uint32]NodeDiscovered{}
+					flushJoins = time.After(m.setupConfig.JoinCoalesceWindow)
+				}
+				joins[newNodeId] = nodeDiscovered
 				break
 			}
+			m.broadcastDiscovery(nodeDiscovered)
 
-			for _, node := range nodes {
-				log.Infow("Sending Discovery Broadcast", "node", node.Name)
-				go m.NodeDiscovery(node.Convert(), nodeDiscovered.NewNode)
+		case <-flushJoins:
+			m.broadcastJoins(joins)
+			joins = nil
+			flushJoins = nil
+
+		case node := <-m.nodeLeft:
+			log := m.logger.Named("leave-routine")
+			log.Infow("Node left the mesh", "node", node.Name)
+			m.recordEvent(data.EVENT_LEAVE, node.Name, "node left the mesh")
+			m.forgetNode(node)
+			m.database.DeleteNode(GetId(node))
+			m.database.SetTombstone(GetId(node), node.Name)
+			m.mu.Lock()
+			_, hasClient := m.clients[GetId(node)]
+			m.mu.Unlock()
+			if hasClient {
+				if err := m.closeClient(node); err != nil {
+					log.Debugw("Could not close client", "node", node.Name, "error", err)
+				}
 			}
 
-			m.database.SetNode(data.Convert(nodeDiscovered.NewNode, NODE_OK))
+			// Check if node was last node in mesh
+			if len(m.database.GetNodeList()) == 0 && m.joinRoutineDone {
+				select {
+				case m.restartJoinRoutine <- true:
+				default:
+				}
+			}
 		}
 	}
 }
 
+// Deadline of a discovery broadcast, zero if the join settle timeout is disabled
+func settleDeadline(now time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
+
+// Check if the join settled before the discovery broadcast, never without a deadline
+func settled(deadline time.Time, now time.Time) bool {
+	return !deadline.IsZero() && now.After(deadline)
+}
+
+// Context of a discovery broadcast bound by the deadline, if set
+func (m *Mesh) discoveryContext(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+// Broadcast the discovery of a new node to random healthy nodes,
+// except the node sending the discovery and the new node
+func (m *Mesh) broadcastDiscovery(nodeDiscovered NodeDiscovered) {
+	log := m.logger.Named("discovery-routine")
+	// the join settled before the discovery could be broadcasted
+	if settled(nodeDiscovered.Deadline, time.Now()) {
+		log.Infow("Join settle timeout reached - skip discovery broadcast", "node", nodeDiscovered.NewNode.Name)
+		return
+	}
+
+	// the discovery reached the max. depth; the depth of a discovery
+	// forwarded by an older node is unknown and counts as max. depth
+	depth := nodeDiscovered.Depth + 1
+	if nodeDiscovered.Depth == 0 && nodeDiscovered.From != GetId(nodeDiscovered.NewNode) {
+		depth = m.setupConfig.DiscoveryMaxDepth + 1
+	}
+	if m.setupConfig.DiscoveryMaxDepth > 0 && depth > m.setupConfig.DiscoveryMaxDepth {
+		log.Debugw("Discovery max. depth reached - skip discovery broadcast", "node", nodeDiscovered.NewNode.Name, "depth", nodeDiscovered.Depth)
+		m.metrics.GetDiscoveryForwardsSuppressed().WithLabelValues(metric.DISCOVERY_DEPTH).Inc()
+		return
+	}
+
+	log.Debugw("Starting discovery broadcast routine to random nodes", "amount", m.routineConfig.BroadcastToAmount)
+	nodes := m.database.GetRandomNodeListByState(NODE_OK, m.routineConfig.BroadcastToAmount, nodeDiscovered.From, GetId(nodeDiscovered.NewNode))
+
+	if len(nodes) == 0 {
+		log.Debug("Stopping routine prematurely - no more known nodes")
+		return
+	}
+
+	ctx, cancel := m.discoveryContext(nodeDiscovered.Deadline)
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		log.Infow("Sending Discovery Broadcast", "node", node.Name)
+		wg.Add(1)
+		go func(node *data.Node) {
+			defer wg.Done()
+			m.NodeDiscoveryDepth(ctx, node.Convert(), nodeDiscovered.NewNode, nodeDiscovered.LastSeen, depth)
+		}(node)
+	}
+	// release the context if all broadcasts are done or the deadline is reached
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+}
+
+// A discovery is stale if the last contact with the new node is older
+// than the discovery max. age. Discoveries without last contact are accepted.
+func (m *Mesh) isStaleDiscovery(nodeDiscovered NodeDiscovered, now time.Time) bool {
+	return isStaleContact(nodeDiscovered.LastSeen, m.routineConfig.DiscoveryMaxAge, now)
+}
+
+// A last contact by the clock of this node is stale if it is older than the max. age.
+// A max. age of 0 or an unknown contact is never stale.
+func isStaleContact(lastSeen int64, maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 || lastSeen == 0 {
+		return false
+	}
+	return now.Sub(time.Unix(lastSeen, 0)) > maxAge
+}
+
+// Last contact with a node, the later of the discovered and the own contact
+func (m *Mesh) lastContact(id uint32, lastSeen int64) int64 {
+	if node, ok := m.database.GetNode(id); ok && node.LastSeen > lastSeen {
+		return node.LastSeen
+	}
+	return lastSeen
+}
+
+// Seconds since a last contact, 0 if unknown
+func lastSeenAge(lastSeen int64, now time.Time) int64 {
+	if lastSeen == 0 || now.Unix() < lastSeen {
+		return 0
+	}
+	return now.Unix() - lastSeen
+}
+
+// Last contact of a received discovery by the clock of this node, 0 if unknown.
+// The age sent by the discovering node is dated back from the receipt,
+// so the clocks of the nodes are never compared.
+func receivedLastSeen(lastSeen int64, age int64, recv time.Time) int64 {
+	if lastSeen == 0 {
+		return 0
+	}
+	return recv.Unix() - age
+}
+
 // Will call the ping method with set retry configuration.
 // Database nodes and samples will be updated.
 func (m *Mesh) retryPing(node *meshv1.Node) {
@@ -321,34 +1036,82 @@ func (m *Mesh) retryPing(node *meshv1.Node) {
 	log.Debugw("Retry routine started", "node", node.Name)
 
 	// start retry ping logic
-	for r := 1; r <= m.routineConfig.PingRetryAmount; r++ {
-		// Ping the node
-		err := m.ping(node)
+	states := m.routineConfig.NodeStates
+	m.countRequest()
+	if states.IndirectProbes > 0 {
+		m.retryPingSuspect(node)
+		return
+	}
+	for r := 1; ; r++ {
+		// a paused node stops the retries, the node keeps its state until resumed
+		if m.Paused() {
+			log.Infow("Probes paused - retry routine stopped", "node", node.Name, "attempt", r)
+			return
+		}
+		// Retries beyond the retry budget are not sent and count as failed,
+		// so the node still moves through its states
+		err := errRetryBudget
+		if r == 1 || m.allowRetry("ping") {
+			err = m.ping(node)
+		} else {
+			log.Infow("Retry budget exhausted - retry counted as failed", "node", node.Name, "attempt", r)
+		}
 
 		// Ping ok; return
 		if err == nil {
-			m.database.SetNode(data.Convert(node, NODE_OK))
+			m.forgetFailedPings(node)
+			m.setNodeState(node, NODE_OK)
 			log.Infow("Ping ok", "node", node.Name, "attempt", r)
 			return
 		}
 
 		// Ping failed
-		log.Infow("Ping failed", "node", node.Name, "timeout", m.routineConfig.RequestTimeout.String(), "retry in", m.routineConfig.PingRetryDelay.String(), "attempt", r)
-		m.database.SetNode(data.Convert(node, NODE_TIMEOUT))
-		m.database.SetSampleNaN(GetSampleId(&meshv1.Sample{From: m.setupConfig.Name, To: node.Name, Key: data.RTT_REQUEST}))
-		m.database.SetSampleNaN(GetSampleId(&meshv1.Sample{From: m.setupConfig.Name, To: node.Name, Key: data.RTT_TOTAL}))
+		log.Infow("Ping failed", "node", node.Name, "timeout", states.pingTimeout(m.routineConfig.RequestTimeout).String(), "retry in", m.routineConfig.PingRetryDelay.String(), "attempt", r)
+		m.setRttNaN(node)
+		inGrace := m.inNeverContactedGrace(node, time.Now())
 
-		if r != m.routineConfig.PingRetryAmount {
-			// Retry delay
-			time.Sleep(m.routineConfig.PingRetryDelay)
-		} else {
-			m.database.SetNode(data.Convert(node, NODE_DEAD))
+		state := states.stateAfterFailures(r)
+		if state == NODE_DEAD {
+			if !inGrace {
+				break
+			}
+			// a never contacted node is not dead within its grace
+			log.Infow("Node never contacted - within grace", "node", node.Name, "grace", states.NeverContactedGrace.String(), "attempt", r)
+			state = NODE_TIMEOUT
+		}
+		// the node keeps its state below the failure threshold,
+		// a pending node is not ok before a successful ping
+		if states.failed(r) && state != NODE_OK {
+			m.setNodeState(node, state)
 		}
+		// Retry delay
+		time.Sleep(m.routineConfig.PingRetryDelay)
 	}
 
 	// Retry limit reached
-	log.Infow("Retry limit reached", "node", node.Name, "limit", m.routineConfig.PingRetryAmount)
+	log.Infow("Retry limit reached", "node", node.Name, "limit", states.DeadAfter)
+	m.nodeDead(node, fmt.Sprintf("no ping response after %v attempts", states.DeadAfter))
+}
+
+// Set the RTT samples to a node NaN after a failed ping
+func (m *Mesh) setRttNaN(node *meshv1.Node) {
+	m.database.SetSampleNaN(GetSampleId(&meshv1.Sample{From: m.name.get(), To: node.Name, Key: data.RTT_REQUEST, FromId: m.nodeId(), ToId: GetId(node)}))
+	m.database.SetSampleNaN(GetSampleId(&meshv1.Sample{From: m.name.get(), To: node.Name, Key: data.RTT_TOTAL, FromId: m.nodeId(), ToId: GetId(node)}))
+}
+
+// Mark a node dead or remove it immediately if dead nodes are not kept.
+// The reason is recorded with the eviction.
+func (m *Mesh) nodeDead(node *meshv1.Node, reason string) {
+	log := m.logger.Named("ping-routine")
+	states := m.routineConfig.NodeStates
+	if states.RemoveAfter > 0 {
+		log.Warnw("Node is dead", "node", node.Name, "removeAfter", states.RemoveAfter.String())
+		m.setNodeState(node, NODE_DEAD)
+		return
+	}
 	log.Warnw("Removing node from mesh", "node", node.Name)
+	m.forgetNode(node)
+	m.recordEvent(data.EVENT_EVICTION, node.Name, reason)
 	m.database.DeleteNode(GetId(node))
 
 	// Check if node was last node in mesh
@@ -357,6 +1120,27 @@ func (m *Mesh) retryPing(node *meshv1.Node) {
 	}
 }
 
+// Remove the nodes that are dead for longer than the configured time
+func (m *Mesh) removeDeadNodes() {
+	removeBefore := time.Now().Add(-1 * m.routineConfig.NodeStates.RemoveAfter)
+	for _, node := range m.database.GetNodeListByState(NODE_DEAD) {
+		if time.Unix(node.StateChangeTs, 0).Before(removeBefore) {
+			m.logger.Warnw("Removing dead node from mesh", "node", node.Name)
+			m.recordEvent(data.EVENT_EVICTION, node.Name, "dead for more than "+m.routineConfig.NodeStates.RemoveAfter.String())
+			m.forgetNode(node.Convert())
+			m.database.DeleteNode(node.Id)
+		}
+	}
+
+	// Check if node was last node in mesh, do not block the timer routines
+	if len(m.database.GetNodeList()) == 0 && m.joinRoutineDone {
+		select {
+		case m.restartJoinRoutine <- true:
+		default:
+		}
+	}
+}
+
 // Will call the pushSample method with set retry configuration.
 // Database nodes and samples will be updated.
 func (m *Mesh) retryPushSample(node *meshv1.Node) {
@@ -364,14 +1148,27 @@ func (m *Mesh) retryPushSample(node *meshv1.Node) {
 	log.Debugw("Push sample retry routine started", "node", node.Name)
 
 	// start retry pushSample logic
+	m.countRequest()
 	for r := 1; r <= m.routineConfig.PushSampleRetryAmount; r++ {
-		// Push all samples to node
-		err := m.pushSamples(node)
+		start := m.receipts.current()
+		// Retries are throttled by the retry budget
+		if r > 1 && !m.allowRetry("push-samples") {
+			log.Debugw("Retry budget exhausted - skip push retry", "node", node.Name, "attempt", r)
+			return
+		}
+		// Push all samples to node, or just the differing samples by the digests
+		var err error
+		if m.setupConfig.DigestSync {
+			err = m.syncSamples(node)
+		} else {
+			err = m.pushSamples(node)
+		}
 
 		// Push ok; return
 		if err == nil {
 			log.Debug("Push samples ok")
 			m.database.SetNodeTsNow(GetId(node))
+			m.setPushed(node, start)
 			return
 		}
 
@@ -388,21 +1185,81 @@ func (m *Mesh) retryPushSample(node *meshv1.Node) {
 // Get the ID of a node
 // Hash integer value of the target field (name of node)
 func GetId(n *meshv1.Node) uint32 {
-	id, err := h.Hash(n.Target)
+	id, err := h.Hash(h.NormalizeTarget(n.Target))
 	if err != nil {
 		log.Printf("Could not get the hash value of the sample, please check the hash function")
 	}
 	return id
 }
 
+// Get the ID of this node, like other nodes know it
+func (m *Mesh) nodeId() uint32 {
+	return GetId(&meshv1.Node{Target: m.setupConfig.advertiseTarget()})
+}
+
+// Get the node ids of this node & the known nodes by name
+func (m *Mesh) sampleIds() map[string]uint32 {
+	ids := map[string]uint32{m.name.get(): m.nodeId()}
+	for _, node := range m.database.GetNodeList() {
+		ids[node.Name] = node.Id
+	}
+	return ids
+}
+
+// Migrate the samples with a name based id, e.g. pushed by older nodes,
+// to the id of the stable node ids. The names are mapped by the ids.
+// The whole sample store is scanned.
+func (m *Mesh) migrateSampleIds(ids map[string]uint32) {
+	if migrated := m.database.MigrateSampleIds(ids); migrated > 0 {
+		m.logger.Debugw("Migrated sample ids", "samples", migrated)
+	}
+}
+
+// Migrate the samples with a name based id if a node sending them is known now,
+// the sample store is not scanned without such a node
+func (m *Mesh) migrateLegacySenders() {
+	ids := m.sampleIds()
+	if m.legacySenders.known(ids) {
+		m.migrateSampleIds(ids)
+	}
+}
+
+// Names of the nodes sending samples with a name based id,
+// not mapped to a node id yet
+type legacySenders struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// Add the sender of a sample with a name based id
+func (l *legacySenders) add(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.names == nil {
+		l.names = map[string]bool{}
+	}
+	l.names[name] = true
+}
+
+// Check if a sender is mapped by the ids, the mapped senders are removed
+func (l *legacySenders) known(ids map[string]uint32) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	known := false
+	for name := range l.names {
+		if _, ok := ids[name]; ok {
+			delete(l.names, name)
+			known = true
+		}
+	}
+	return known
+}
+
 // Get the ID of a sample
-// Hash integer value of the concatenated From, To and Key field
+// Hash integer value of the From & To node ids and the Key field,
+// see data.GetSampleId
 func GetSampleId(p *meshv1.Sample) uint32 {
-	id, err := h.Hash(p.From + p.To + strconv.FormatInt(p.Key, 10))
-	if err != nil {
-		log.Printf("Could not get the hash value of the sample, please check the hash function")
-	}
-	return id
+	return data.GetSampleId(&data.Sample{From: p.From, To: p.To, Key: p.Key, FromId: p.FromId, ToId: p.ToId})
 }
 
 // Setup the Logger
@@ -431,3 +1288,28 @@ func getLogger(debug bool, pprofAddress string) *zap.SugaredLogger {
 
 	return logger.Sugar()
 }
+
+// Seed of the heartbeat counter, the heartbeat intervals since the epoch.
+// The counter increments once per interval, so a restarted node continues
+// at least at its last counter instead of looking like a counter reset.
+func heartbeatSeed(now time.Time, interval time.Duration) uint64 {
+	if interval <= 0 {
+		return 0
+	}
+	return uint64(now.UnixNano() / int64(interval))
+}
+
+// Store the next heartbeat sample of this node, it is spread in the mesh
+// like all samples. A stalled counter shows a silent node or a broken
+// sample pipeline, even if RTT measurements succeed.
+func (m *Mesh) emitHeartbeat() {
+	m.database.SetSample(&data.Sample{
+		From:   m.name.get(),
+		To:     m.name.get(),
+		Key:    data.HEARTBEAT,
+		Value:  strconv.FormatUint(m.heartbeat.Add(1), 10),
+		Ts:     time.Now().Unix(),
+		FromId: m.nodeId(),
+		ToId:   m.nodeId(),
+	})
+}
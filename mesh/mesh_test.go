@@ -0,0 +1,96 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+func Test_timerRoutinesProbeWarmup(t *testing.T) {
+	tests := []struct {
+		name     string
+		warmup   time.Duration
+		restart  bool
+		expected bool
+	}{
+		{name: "no warmup", expected: true},
+		{name: "warmup", warmup: 200 * time.Millisecond, expected: true},
+		{name: "join restarted during the warmup", warmup: 200 * time.Millisecond, restart: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, logs := testMeshObserved(time.Second)
+			m.routineConfig = StandardProductionRoutineConfig()
+			m.routineConfig.JoinInterval = time.Hour
+			m.routineConfig.ProbeWarmup = tt.warmup
+			m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+			m.quitJoinRoutine = make(chan bool, 1)
+			m.restartJoinRoutine = make(chan bool, 1)
+			m.routines, m.stopRoutines = context.WithCancel(context.Background())
+			started := func() bool { return logs.FilterMessage("Starting pings").Len() > 0 }
+
+			stopped := make(chan struct{})
+			go func() {
+				m.timerRoutines()
+				close(stopped)
+			}()
+
+			// the join is done
+			m.quitJoinRoutine <- true
+			if tt.warmup > 0 {
+				time.Sleep(tt.warmup / 4)
+				if started() {
+					t.Fatal("Expected no probing during the warmup")
+				}
+			}
+			if tt.restart {
+				m.restartJoinRoutine <- true
+			}
+			// wait for the probing, twice the warmup if no probing is expected
+			wait := time.Second
+			if !tt.expected {
+				wait = tt.warmup
+			}
+			deadline := time.Now().Add(tt.warmup + wait)
+			for !started() && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if started() != tt.expected {
+				t.Errorf("Expected probing started %v, got %v", tt.expected, started())
+			}
+
+			// the timer routines stop on shutdown
+			m.stopRoutines()
+			select {
+			case <-stopped:
+			case <-time.After(time.Second):
+				t.Error("Expected the timer routines to stop on shutdown")
+			}
+		})
+	}
+}
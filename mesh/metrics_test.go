@@ -0,0 +1,70 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+
+	"github.com/telekom/canary-bot/metric"
+)
+
+// Gather the values of the counter & gauge series of a metric, filtered
+// by label name & value pairs, e.g. "reason", "token"
+func gatherValues(t *testing.T, metrics metric.Metrics, name string, labels ...string) []float64 {
+	families, err := metrics.GetRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []float64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, series := range family.GetMetric() {
+			seriesLabels := map[string]string{}
+			for _, label := range series.GetLabel() {
+				seriesLabels[label.GetName()] = label.GetValue()
+			}
+			for i := 0; i+1 < len(labels); i += 2 {
+				if seriesLabels[labels[i]] != labels[i+1] {
+					continue series
+				}
+			}
+			if series.GetCounter() != nil {
+				values = append(values, series.GetCounter().GetValue())
+			} else {
+				values = append(values, series.GetGauge().GetValue())
+			}
+		}
+	}
+	return values
+}
+
+// Gather the sum of the series of a metric filtered by labels, 0 without series
+func gatherValue(t *testing.T, metrics metric.Metrics, name string, labels ...string) float64 {
+	sum := 0.0
+	for _, value := range gatherValues(t, metrics, name, labels...) {
+		sum += value
+	}
+	return sum
+}
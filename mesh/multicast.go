@@ -0,0 +1,260 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Max. size of a multicast announcement
+const multicastMaxSize = 1024
+
+// Discovered peers expire after missing this many announcements
+const multicastExpiryIntervals = 3
+
+// Announcement of a node to the multicast group
+type multicastAnnouncement struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+}
+
+// Zero-config discovery of the peers in a flat L2 network.
+// Every node announces its name & advertise target to the multicast group
+// and learns the join targets of the other nodes by their announcements.
+// The discovered targets expire if a node stops announcing.
+type multicastDiscovery struct {
+	group    *net.UDPAddr
+	name     *nodeName
+	target   string
+	interval time.Duration
+	timeout  time.Duration
+	log      *zap.SugaredLogger
+
+	// discovered join targets by the last announcement; guarded by mu
+	mu      sync.Mutex
+	peers   map[string]time.Time
+	started time.Time
+	// closed after the first announcement of a peer or a failed listen
+	ready     chan struct{}
+	readyOnce sync.Once
+	// joins the group, the multicast group is joined if nil
+	listenFunc func() (*net.UDPConn, error)
+}
+
+// Create the multicast discovery of the setup configuration, nil if no group is set
+func newMulticastDiscovery(setupConfig *SetupConfiguration, name *nodeName, log *zap.SugaredLogger) (*multicastDiscovery, error) {
+	if setupConfig.DiscoveryMulticast == "" {
+		return nil, nil
+	}
+	group, err := parseMulticastGroup(setupConfig.DiscoveryMulticast)
+	if err != nil {
+		return nil, err
+	}
+	return &multicastDiscovery{
+		group:    group,
+		name:     name,
+		target:   setupConfig.advertiseTarget(),
+		interval: setupConfig.DiscoveryMulticastInterval,
+		timeout:  setupConfig.DiscoveryMulticastTimeout,
+		log:      log,
+		peers:    map[string]time.Time{},
+		ready:    make(chan struct{}),
+	}, nil
+}
+
+// Parse a multicast group in the format IP:PORT
+func parseMulticastGroup(group string) (*net.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multicast group %v: %w", group, err)
+	}
+	if !addr.IP.IsMulticast() || addr.Port == 0 {
+		return nil, fmt.Errorf("invalid multicast group %v, format: MULTICAST-IP:PORT", group)
+	}
+	return addr, nil
+}
+
+// Listen to the announcements of the peers & announce this node periodically.
+// If the group cannot be joined, e.g. multicast is not available,
+// the discovery ends and the seeds are used.
+func (d *multicastDiscovery) run() {
+	d.mu.Lock()
+	d.started = time.Now()
+	d.mu.Unlock()
+
+	conn, err := d.listenGroup()
+	if err != nil {
+		d.log.Warnw("Could not join the multicast group - using the seeds", "group", d.group.String(), "error", err)
+		d.readyOnce.Do(func() { close(d.ready) })
+		return
+	}
+	go d.listen(conn)
+	d.announce()
+}
+
+// Join the multicast group to receive the announcements
+func (d *multicastDiscovery) listenGroup() (*net.UDPConn, error) {
+	if d.listenFunc != nil {
+		return d.listenFunc()
+	}
+	return net.ListenMulticastUDP("udp", nil, d.group)
+}
+
+// Receive the announcements of the peers. After a read error the group
+// is left and joined again every interval until it succeeds.
+func (d *multicastDiscovery) listen(conn *net.UDPConn) {
+	for {
+		d.receive(conn)
+		for {
+			time.Sleep(d.interval)
+			var err error
+			if conn, err = d.listenGroup(); err == nil {
+				d.log.Infow("Joined the multicast group again", "group", d.group.String())
+				break
+			}
+			d.log.Warnw("Could not join the multicast group again", "group", d.group.String(), "error", err)
+		}
+	}
+}
+
+// Receive the announcements until a read error, the connection is closed.
+// The own announcements are skipped.
+func (d *multicastDiscovery) receive(conn *net.UDPConn) {
+	defer conn.Close()
+	buf := make([]byte, multicastMaxSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			d.log.Warnw("Could not receive multicast announcements - leaving the group", "error", err)
+			return
+		}
+		var a multicastAnnouncement
+		if err := json.Unmarshal(buf[:n], &a); err != nil || a.Name == "" || a.Target == "" {
+			d.log.Debugw("Invalid multicast announcement", "from", from.String(), "error", err)
+			continue
+		}
+		d.add(a, time.Now())
+	}
+}
+
+// Add the join target of an announcement. The own announcements are
+// skipped by the name, another node may announce the same target, e.g. behind NAT.
+func (d *multicastDiscovery) add(a multicastAnnouncement, now time.Time) {
+	if a.Name == d.name.get() {
+		return
+	}
+	d.mu.Lock()
+	if _, known := d.peers[a.Target]; !known {
+		d.log.Debugw("Discovered a peer by multicast", "name", a.Name, "target", a.Target)
+	}
+	d.peers[a.Target] = now
+	d.mu.Unlock()
+	d.readyOnce.Do(func() { close(d.ready) })
+}
+
+// Send the announcement of this node every interval,
+// with the current name of a renamed node
+func (d *multicastDiscovery) announce() {
+	conn, err := net.DialUDP("udp", nil, d.group)
+	if err != nil {
+		d.log.Warnw("Could not announce the node by multicast", "group", d.group.String(), "error", err)
+		return
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		msg, err := json.Marshal(multicastAnnouncement{Name: d.name.get(), Target: d.target})
+		if err != nil {
+			d.log.Warnw("Could not encode the multicast announcement", "error", err)
+			return
+		}
+		if _, err := conn.Write(msg); err != nil {
+			d.log.Debugw("Could not send the multicast announcement", "error", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Get the discovered join targets, sorted. Expired targets are removed.
+// Until the first peer is discovered, this waits for the rest of the
+// discovery timeout after the start, e.g. if multicast is filtered.
+func (d *multicastDiscovery) targets() []string {
+	d.mu.Lock()
+	started := d.started
+	d.mu.Unlock()
+	if wait := d.timeout - time.Since(started); !started.IsZero() && wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-d.ready:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	expiry := time.Now().Add(-d.interval * multicastExpiryIntervals)
+	targets := []string{}
+	for target, seen := range d.peers {
+		if seen.Before(expiry) {
+			delete(d.peers, target)
+			continue
+		}
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// Add the targets discovered by multicast to the seed targets.
+// The discovered targets are tried first, shuffled by the target shuffle
+// apart from the seeds. The seeds are the fallback if no peer is discovered.
+func (m *Mesh) withMulticastTargets(seeds []string) []string {
+	if m.multicast == nil {
+		return seeds
+	}
+	discovered := m.multicast.targets()
+	if len(discovered) == 0 {
+		m.logger.Named("join-routine").Debugw("No peers discovered by multicast - using the seeds", "group", m.setupConfig.DiscoveryMulticast)
+		return seeds
+	}
+	known := map[string]bool{}
+	targets := m.shuffleTargets(discovered)
+	for _, target := range discovered {
+		known[target] = true
+	}
+	for _, seed := range seeds {
+		if !known[seed] {
+			targets = append(targets, seed)
+		}
+	}
+	return targets
+}
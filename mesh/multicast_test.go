@@ -0,0 +1,178 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func Test_parseMulticastGroup(t *testing.T) {
+	tests := []struct {
+		group   string
+		wantErr bool
+	}{
+		{group: "239.255.77.77:8079", wantErr: false},
+		{group: "[ff02::1]:8079", wantErr: false},
+		{group: "10.0.0.1:8079", wantErr: true},
+		{group: "239.255.77.77", wantErr: true},
+		{group: "239.255.77.77:0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			if _, err := parseMulticastGroup(tt.group); (err != nil) != tt.wantErr {
+				t.Errorf("parseMulticastGroup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_multicastDiscoveryTargets(t *testing.T) {
+	d, err := newMulticastDiscovery(&SetupConfiguration{
+		Name:                       "self",
+		AdvertiseAddress:           "10.0.0.1",
+		AdvertisePort:              8081,
+		DiscoveryMulticast:         "239.255.77.77:8079",
+		DiscoveryMulticastInterval: time.Second,
+		DiscoveryMulticastTimeout:  time.Second,
+	}, newNodeName("self"), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	d.add(multicastAnnouncement{Name: "self", Target: "10.0.0.9:8081"}, now)
+	d.add(multicastAnnouncement{Name: "b", Target: "10.0.0.3:8081"}, now)
+	d.add(multicastAnnouncement{Name: "a", Target: "10.0.0.2:8081"}, now)
+	d.add(multicastAnnouncement{Name: "expired", Target: "10.0.0.4:8081"}, now.Add(-time.Minute))
+	// the own announcements are skipped by the current name after a rename
+	d.name.set("self-renamed")
+	d.add(multicastAnnouncement{Name: "self-renamed", Target: "10.0.0.5:8081"}, now)
+
+	// another node announcing the own target, e.g. behind NAT
+	d.add(multicastAnnouncement{Name: "c", Target: "10.0.0.1:8081"}, now)
+
+	expected := []string{"10.0.0.1:8081", "10.0.0.2:8081", "10.0.0.3:8081"}
+	if targets := d.targets(); !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected the discovered targets %v, got %v", expected, targets)
+	}
+
+	m := testMesh(time.Second)
+	m.multicast = d
+	expected = []string{"10.0.0.1:8081", "10.0.0.2:8081", "10.0.0.3:8081", "seed:8081"}
+	if targets := m.withMulticastTargets([]string{"10.0.0.2:8081", "seed:8081"}); !reflect.DeepEqual(targets, expected) {
+		t.Errorf("Expected the join targets %v, got %v", expected, targets)
+	}
+
+	// the discovered targets are shuffled apart from the seeds
+	m.setupConfig.TargetShuffle = TARGET_SHUFFLE_RANDOM
+	targets := m.withMulticastTargets([]string{"seed-a:8081", "seed-b:8081"})
+	if len(targets) != 5 || !reflect.DeepEqual(targets[3:], []string{"seed-a:8081", "seed-b:8081"}) {
+		t.Errorf("Expected the discovered targets before the seeds, got %v", targets)
+	}
+}
+
+func Test_multicastDiscoveryTimeout(t *testing.T) {
+	d, err := newMulticastDiscovery(&SetupConfiguration{
+		Name:                       "self",
+		DiscoveryMulticast:         "239.255.77.77:8079",
+		DiscoveryMulticastInterval: time.Second,
+		DiscoveryMulticastTimeout:  50 * time.Millisecond,
+	}, newNodeName("self"), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	d.started = start
+
+	m := testMesh(time.Second)
+	m.multicast = d
+	if targets := m.withMulticastTargets([]string{"seed:8081"}); !reflect.DeepEqual(targets, []string{"seed:8081"}) {
+		t.Errorf("Expected the seeds as fallback, got %v", targets)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Expected to wait for the discovery timeout, waited %v", waited)
+	}
+}
+
+func Test_multicastDiscoveryRejoin(t *testing.T) {
+	d, err := newMulticastDiscovery(&SetupConfiguration{
+		Name:                       "self",
+		DiscoveryMulticast:         "239.255.77.77:8079",
+		DiscoveryMulticastInterval: 10 * time.Millisecond,
+		DiscoveryMulticastTimeout:  time.Second,
+	}, newNodeName("self"), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the group is replaced by a unicast socket on a fixed port
+	first, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := first.LocalAddr().(*net.UDPAddr)
+	joins := make(chan *net.UDPConn, 1)
+	d.listenFunc = func() (*net.UDPConn, error) {
+		conn, err := net.ListenUDP("udp", addr)
+		if err == nil {
+			select {
+			case joins <- conn:
+			default:
+			}
+		}
+		return conn, err
+	}
+	go d.listen(first)
+
+	// a read error leaves the group, it is joined again
+	first.Close()
+	var conn *net.UDPConn
+	select {
+	case conn = <-joins:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the group to be joined again")
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	sender, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if _, err := sender.Write([]byte(`{"name":"a","target":"10.0.0.2:8081"}`)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-d.ready:
+	case <-time.After(time.Second):
+		t.Fatal("Expected an announcement to be received after joining again")
+	}
+	if targets := d.targets(); !reflect.DeepEqual(targets, []string{"10.0.0.2:8081"}) {
+		t.Errorf("Expected the announced target, got %v", targets)
+	}
+}
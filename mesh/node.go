@@ -21,8 +21,260 @@
 
 package mesh
 
+import (
+	"errors"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+)
+
 const (
 	NODE_OK      = 1
 	NODE_TIMEOUT = 2
 	NODE_DEAD    = 3
+	NODE_SUSPECT = 4
+	// Discovered, but not contacted by this node yet
+	NODE_PENDING = 5
 )
+
+// Transitions of the node states by consecutive failed pings:
+//
+//	NODE_OK --TimeoutAfter--> NODE_TIMEOUT --DeadAfter--> NODE_DEAD --RemoveAfter--> removed
+//	   ^                           |
+//	   +---------ping ok-----------+
+//
+// With indirect probes a failed ping is confirmed by random healthy nodes.
+// A node reached by one of them stays ok, TimeoutAfter & DeadAfter are not used:
+//
+//	NODE_OK --not reached--> NODE_SUSPECT --SuspectTimeout--> NODE_DEAD --RemoveAfter--> removed
+//	   ^                           |
+//	   +--ping or indirect ping ok-+
+//
+// A node is pinged again after the ping retry delay until it is dead.
+// The state is kept until FailureThreshold pings failed in a row,
+// e.g. a single slow ping of a node is not confirmed by indirect pings.
+//
+// Discovered nodes start as NODE_PENDING if set as DiscoveredState
+// and are ok after the first successful ping:
+//
+//	NODE_PENDING --ping ok--> NODE_OK
+//
+// The transitions above are the grace of a node contacted before (LastSeen set).
+// A node never contacted by this node is not dead before the NeverContactedGrace
+// since its first failed ping, it stays timeout or suspect until then:
+//
+//	never contacted --DeadAfter|SuspectTimeout & NeverContactedGrace--> NODE_DEAD
+type NodeStateConfiguration struct {
+	// State of the nodes discovered by node lists & discoveries,
+	// NODE_OK or NODE_PENDING; 0 is the same as NODE_OK
+	DiscoveredState int
+	// Timeout of the pings of the failure detector, the request timeout if 0.
+	// An indirect ping is bound by twice the timeout, so the ping of the asked node
+	// is bound by the timeout as well.
+	ProbeTimeout time.Duration
+	// Consecutive failed pings until the node state changes, 0 is the same as 1
+	FailureThreshold int
+	// Consecutive failed pings until a node is unreachable (timeout)
+	TimeoutAfter int
+	// Consecutive failed pings until a node is dead
+	DeadAfter int
+	// Time a dead node is kept before it is removed, 0 removes it immediately.
+	// Dead nodes are removed by the cleanup routine.
+	RemoveAfter time.Duration
+	// Nodes asked to ping a node after a failed ping, 0 disables the suspect state
+	IndirectProbes int
+	// Time a node is suspect until it is dead
+	SuspectTimeout time.Duration
+	// Min. time since the first failed ping until a node never contacted by this
+	// node is dead, e.g. a new discovery on a slow network; 0 uses the transitions
+	// of the contacted nodes only. Typically longer than the SuspectTimeout.
+	NeverContactedGrace time.Duration
+}
+
+// Validate that the thresholds are monotonic
+func (c NodeStateConfiguration) validate() error {
+	if c.DiscoveredState != 0 && c.DiscoveredState != NODE_OK && c.DiscoveredState != NODE_PENDING {
+		return errors.New("node state of discovered nodes has to be ok or pending")
+	}
+	if c.ProbeTimeout < 0 {
+		return errors.New("node state probe timeout has to be positive")
+	}
+	if c.FailureThreshold < 0 {
+		return errors.New("node state failure threshold has to be positive")
+	}
+	if c.TimeoutAfter < 1 {
+		return errors.New("node state timeout after has to be at least 1 failed ping")
+	}
+	if c.DeadAfter < c.TimeoutAfter {
+		return errors.New("node state dead after has to be greater or equal to timeout after")
+	}
+	if c.DeadAfter < c.FailureThreshold {
+		return errors.New("node state dead after has to be greater or equal to the failure threshold")
+	}
+	if c.RemoveAfter < 0 {
+		return errors.New("node state remove after has to be positive")
+	}
+	if c.IndirectProbes < 0 {
+		return errors.New("node state indirect probes has to be positive")
+	}
+	if c.IndirectProbes > 0 && c.SuspectTimeout <= 0 {
+		return errors.New("node state suspect timeout has to be greater than 0 with indirect probes")
+	}
+	if c.NeverContactedGrace < 0 {
+		return errors.New("node state never contacted grace has to be positive")
+	}
+	return nil
+}
+
+// Default the transitions of a configuration without DeadAfter like the ping
+// retries of older configurations: dead after the deprecated PingRetryAmount
+// failed pings if set, the timeout & dead thresholds of the production config otherwise.
+func (c NodeStateConfiguration) withDefaults(pingRetryAmount int) NodeStateConfiguration {
+	if c.DeadAfter > 0 {
+		return c
+	}
+	defaults := StandardProductionRoutineConfig().NodeStates
+	c.DeadAfter = defaults.DeadAfter
+	if pingRetryAmount > 0 {
+		c.DeadAfter = pingRetryAmount
+	}
+	if c.TimeoutAfter == 0 {
+		c.TimeoutAfter = defaults.TimeoutAfter
+	}
+	return c
+}
+
+// Get the timeout of the pings, the request timeout if not set
+func (c NodeStateConfiguration) pingTimeout(requestTimeout time.Duration) time.Duration {
+	if c.ProbeTimeout > 0 {
+		return c.ProbeTimeout
+	}
+	return requestTimeout
+}
+
+// Check if the consecutive failed pings reached the failure threshold
+func (c NodeStateConfiguration) failed(failures int) bool {
+	return failures >= c.FailureThreshold
+}
+
+// Get the node state after consecutive failed pings
+func (c NodeStateConfiguration) stateAfterFailures(failures int) int {
+	switch {
+	case failures >= c.DeadAfter:
+		return NODE_DEAD
+	case failures >= c.TimeoutAfter:
+		return NODE_TIMEOUT
+	default:
+		return NODE_OK
+	}
+}
+
+// Get the name of a node state
+func stateName(state int) string {
+	switch state {
+	case NODE_OK:
+		return "ok"
+	case NODE_TIMEOUT:
+		return "timeout"
+	case NODE_DEAD:
+		return "dead"
+	case NODE_SUSPECT:
+		return "suspect"
+	case NODE_PENDING:
+		return "pending"
+	default:
+		return "unknown"
+	}
+}
+
+// Get the state of a discovered node, not contacted by this node yet.
+// Known nodes keep their state if discovered nodes are pending.
+func (m *Mesh) discoveredState(node *meshv1.Node) int {
+	if m.routineConfig.NodeStates.DiscoveredState != NODE_PENDING {
+		return NODE_OK
+	}
+	if stored, ok := m.database.GetNode(GetId(node)); ok {
+		return stored.State
+	}
+	return NODE_PENDING
+}
+
+// Check if a node never contacted by this node is within the never contacted grace.
+// The grace starts with the first failed ping of the node, it ends with
+// the first contact. Called on every failed ping of the node.
+func (m *Mesh) inNeverContactedGrace(node *meshv1.Node, now time.Time) bool {
+	grace := m.routineConfig.NodeStates.NeverContactedGrace
+	if grace <= 0 {
+		return false
+	}
+	id := GetId(node)
+	stored, ok := m.database.GetNode(id)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !ok || stored.LastSeen != 0 {
+		delete(m.firstFailedPings, id)
+		return false
+	}
+	if m.firstFailedPings == nil {
+		m.firstFailedPings = map[uint32]time.Time{}
+	}
+	first, ok := m.firstFailedPings[id]
+	if !ok {
+		first = now
+		m.firstFailedPings[id] = now
+	}
+	return now.Sub(first) < grace
+}
+
+// Forget the state kept per node after it left or was removed from the mesh
+func (m *Mesh) forgetNode(node *meshv1.Node) {
+	m.forgetFailedPings(node)
+	m.deleteConnectionSecurity(node)
+	if m.health != nil {
+		m.health.delete(node.Name)
+	}
+	if m.anomalies != nil {
+		m.anomalies.delete(node.Name)
+		m.metrics.GetRttAnomalies().DeleteLabelValues(node.Name)
+	}
+}
+
+// Forget the first failed ping of a node, e.g. after a successful ping
+func (m *Mesh) forgetFailedPings(node *meshv1.Node) {
+	m.mu.Lock()
+	delete(m.firstFailedPings, GetId(node))
+	m.mu.Unlock()
+}
+
+// Ping the pending nodes to verify their reachability.
+// A node is pinged once at a time, the retries of the ping routine apply.
+func (m *Mesh) pingPendingNodes() {
+	for _, node := range m.peers(m.database.GetNodeListByState(NODE_PENDING)) {
+		m.pingOnce(node)
+	}
+}
+
+// Ping a node by the retry routine, unless a routine pings it already
+func (m *Mesh) pingOnce(node *data.Node) {
+	m.mu.Lock()
+	if m.pendingPings == nil {
+		m.pendingPings = map[uint32]bool{}
+	}
+	if m.pendingPings[node.Id] {
+		m.mu.Unlock()
+		return
+	}
+	m.pendingPings[node.Id] = true
+	m.mu.Unlock()
+
+	go func() {
+		defer func() {
+			m.mu.Lock()
+			delete(m.pendingPings, node.Id)
+			m.mu.Unlock()
+		}()
+		m.retryPing(node.Convert())
+	}()
+}
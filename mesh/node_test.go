@@ -0,0 +1,418 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+func Test_NodeStateConfiguration(t *testing.T) {
+	tests := []struct {
+		name      string
+		states    NodeStateConfiguration
+		expectErr bool
+		expected  []int
+	}{
+		{name: "standard", states: StandardProductionRoutineConfig().NodeStates, expected: []int{NODE_TIMEOUT, NODE_TIMEOUT, NODE_DEAD}},
+		{name: "tolerant", states: NodeStateConfiguration{TimeoutAfter: 2, DeadAfter: 4, RemoveAfter: time.Minute}, expected: []int{NODE_OK, NODE_TIMEOUT, NODE_TIMEOUT, NODE_DEAD}},
+		{name: "dead on first failure", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 1}, expected: []int{NODE_DEAD}},
+		{name: "timeout after 0", states: NodeStateConfiguration{TimeoutAfter: 0, DeadAfter: 3}, expectErr: true},
+		{name: "not monotonic", states: NodeStateConfiguration{TimeoutAfter: 3, DeadAfter: 2}, expectErr: true},
+		{name: "negative remove after", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, RemoveAfter: -time.Second}, expectErr: true},
+		{name: "negative indirect probes", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, IndirectProbes: -1}, expectErr: true},
+		{name: "indirect probes without suspect timeout", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, IndirectProbes: 3}, expectErr: true},
+		{name: "negative probe timeout", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, ProbeTimeout: -time.Second}, expectErr: true},
+		{name: "negative failure threshold", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, FailureThreshold: -1}, expectErr: true},
+		{name: "failure threshold above dead after", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, FailureThreshold: 4}, expectErr: true},
+		{name: "pending discovered nodes", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, DiscoveredState: NODE_PENDING}, expected: []int{NODE_TIMEOUT}},
+		{name: "dead discovered nodes", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, DiscoveredState: NODE_DEAD}, expectErr: true},
+		{name: "negative never contacted grace", states: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, NeverContactedGrace: -time.Second}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.states.validate()
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("error is %v, but expected error is %v", err, tt.expectErr)
+			}
+			for i, expected := range tt.expected {
+				if state := tt.states.stateAfterFailures(i + 1); state != expected {
+					t.Errorf("state after %v failures is %v, expected %v", i+1, state, expected)
+				}
+			}
+		})
+	}
+}
+
+func Test_NodeStateDefaults(t *testing.T) {
+	tests := []struct {
+		name            string
+		states          NodeStateConfiguration
+		pingRetryAmount int
+		expected        NodeStateConfiguration
+	}{
+		{name: "zero-value config", expected: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3}},
+		{name: "deprecated ping retry amount", pingRetryAmount: 5, expected: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 5}},
+		{name: "dead after wins over the ping retry amount", states: NodeStateConfiguration{TimeoutAfter: 2, DeadAfter: 4}, pingRetryAmount: 5, expected: NodeStateConfiguration{TimeoutAfter: 2, DeadAfter: 4}},
+		{name: "other fields are kept", states: NodeStateConfiguration{RemoveAfter: time.Minute}, expected: NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, RemoveAfter: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if states := tt.states.withDefaults(tt.pingRetryAmount); states != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, states)
+			}
+		})
+	}
+
+	// the mesh starts with a config without node states
+	routineConfig := StandardProductionRoutineConfig()
+	routineConfig.NodeStates = NodeStateConfiguration{}
+	routineConfig.PingRetryAmount = 2
+	routineConfig.HealthWindow = 0
+	m, err := newMesh(routineConfig, &SetupConfiguration{Name: "test"}, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.routineConfig.NodeStates.DeadAfter != 2 || routineConfig.NodeStates.DeadAfter != 0 {
+		t.Errorf("Expected the defaults on a copy of the config, got %+v", m.routineConfig.NodeStates)
+	}
+}
+
+func Test_retryPingFailureThreshold(t *testing.T) {
+	// the node refuses connections
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &meshv1.Node{Name: "a", Target: lis.Addr().String()}
+	lis.Close()
+
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.routineConfig.PingRetryDelay = time.Millisecond
+	m.routineConfig.NodeStates = NodeStateConfiguration{FailureThreshold: 2, TimeoutAfter: 1, DeadAfter: 3, RemoveAfter: time.Minute}
+	db.SetNode(data.Convert(node, NODE_OK))
+
+	m.retryPing(node)
+	var states []string
+	for _, event := range db.GetEventList() {
+		if event.Type == data.EVENT_STATE_CHANGE {
+			states = append(states, event.Message)
+		}
+	}
+	// the first failed ping is below the failure threshold
+	if len(states) != 2 || states[0] != "ok -> timeout" || states[1] != "timeout -> dead" {
+		t.Errorf("Expected the state changes ok -> timeout -> dead, got %v", states)
+	}
+}
+
+func Test_retryPingBudgetExhausted(t *testing.T) {
+	// the node refuses connections
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &meshv1.Node{Name: "a", Target: lis.Addr().String()}
+	lis.Close()
+
+	m := testMesh(time.Second)
+	m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+	m.routineConfig.PingRetryDelay = time.Millisecond
+	m.routineConfig.NodeStates = NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, RemoveAfter: time.Minute}
+	m.retryBudget = newRetryBudget(RetryBudgetConfiguration{Ratio: 0.1, Max: 1}, time.Now())
+	m.retryBudget.tokens = 0
+	m.database.SetNode(data.Convert(node, NODE_OK))
+
+	// the retries beyond the budget count as failed, the node does not keep the timeout
+	m.retryPing(node)
+	if stored, _ := m.database.GetNode(GetId(node)); stored.State != NODE_DEAD {
+		t.Errorf("Expected the node to be dead, got %v", stateName(stored.State))
+	}
+}
+
+func Test_retryPingNeverContactedGrace(t *testing.T) {
+	// the node refuses connections
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &meshv1.Node{Name: "a", Target: lis.Addr().String()}
+	lis.Close()
+
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.routineConfig.PingRetryDelay = 10 * time.Millisecond
+	m.routineConfig.NodeStates = NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 1, RemoveAfter: time.Minute, NeverContactedGrace: 100 * time.Millisecond}
+
+	// a never contacted node is dead after the grace
+	db.SetNode(data.Convert(node, NODE_PENDING))
+	start := time.Now()
+	m.retryPing(node)
+	if stored, _ := db.GetNode(GetId(node)); stored.State != NODE_DEAD {
+		t.Errorf("Expected the never contacted node to be dead, got %v", stateName(stored.State))
+	}
+	if elapsed := time.Since(start); elapsed < m.routineConfig.NodeStates.NeverContactedGrace {
+		t.Errorf("Expected the never contacted node to be dead after the grace, got %v", elapsed)
+	}
+
+	// a contacted node follows the regular transitions
+	contacted := data.Convert(node, NODE_OK)
+	contacted.LastSeen = time.Now().Unix()
+	db.SetNode(contacted)
+	start = time.Now()
+	m.retryPing(node)
+	if stored, _ := db.GetNode(GetId(node)); stored.State != NODE_DEAD {
+		t.Errorf("Expected the contacted node to be dead, got %v", stateName(stored.State))
+	}
+	if elapsed := time.Since(start); elapsed >= m.routineConfig.NodeStates.NeverContactedGrace {
+		t.Errorf("Expected the contacted node to be dead without the grace, got %v", elapsed)
+	}
+}
+
+func Test_isStaleDiscovery(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		lastSeen int64
+		expected bool
+	}{
+		{name: "fresh", maxAge: time.Minute, lastSeen: now.Add(-time.Second).Unix(), expected: false},
+		{name: "stale", maxAge: time.Minute, lastSeen: now.Add(-time.Hour).Unix(), expected: true},
+		{name: "last contact unknown", maxAge: time.Minute, lastSeen: 0, expected: false},
+		{name: "check disabled", maxAge: 0, lastSeen: now.Add(-time.Hour).Unix(), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMesh(time.Second)
+			m.routineConfig.DiscoveryMaxAge = tt.maxAge
+			if stale := m.isStaleDiscovery(NodeDiscovered{LastSeen: tt.lastSeen}, now); stale != tt.expected {
+				t.Errorf("discovery stale is %v, expected %v", stale, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_receivedLastSeen(t *testing.T) {
+	recv := time.Now()
+	tests := []struct {
+		name     string
+		lastSeen int64
+		age      int64
+		expected int64
+	}{
+		// the clock of the discovering node is an hour ahead
+		{name: "skewed clock", lastSeen: recv.Add(time.Hour - 10*time.Second).Unix(), age: 10, expected: recv.Unix() - 10},
+		{name: "last contact unknown", lastSeen: 0, age: 0, expected: 0},
+		// nodes before the age date the contact to the receipt
+		{name: "age unknown", lastSeen: recv.Add(-time.Hour).Unix(), age: 0, expected: recv.Unix()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if lastSeen := receivedLastSeen(tt.lastSeen, tt.age, recv); lastSeen != tt.expected {
+				t.Errorf("last seen is %v, expected %v", lastSeen, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_lastContact(t *testing.T) {
+	m := testMesh(time.Second)
+	db, _ := data.NewMemDB(zap.NewNop().Sugar())
+	m.database = db
+	node := &meshv1.Node{Name: "a", Target: "a:8081"}
+	db.SetNode(data.Convert(node, NODE_OK))
+	db.SetNodeLastSeen(GetId(node))
+	own, _ := db.GetNode(GetId(node))
+
+	if lastSeen := m.lastContact(GetId(node), own.LastSeen-60); lastSeen != own.LastSeen {
+		t.Errorf("Expected the own contact %v, got %v", own.LastSeen, lastSeen)
+	}
+	if lastSeen := m.lastContact(GetId(node), own.LastSeen+60); lastSeen != own.LastSeen+60 {
+		t.Errorf("Expected the discovered contact %v, got %v", own.LastSeen+60, lastSeen)
+	}
+	if lastSeen := m.lastContact(42, 0); lastSeen != 0 {
+		t.Errorf("Expected an unknown contact, got %v", lastSeen)
+	}
+}
+
+func Test_setNodeStateEvent(t *testing.T) {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	node := &meshv1.Node{Name: "a", Target: "a:8081"}
+
+	m.setNodeState(node, NODE_OK)
+	m.setNodeState(node, NODE_OK)
+	m.setNodeState(node, NODE_TIMEOUT)
+
+	events := db.GetEventList()
+	if len(events) != 1 {
+		t.Fatalf("Expected one state change event, got %+v", events)
+	}
+	if events[0].Type != data.EVENT_STATE_CHANGE || events[0].Node != "a" || events[0].Message != "ok -> timeout" {
+		t.Errorf("Unexpected event %+v", events[0])
+	}
+}
+
+func Test_emitHeartbeat(t *testing.T) {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.heartbeat.Store(40)
+
+	m.emitHeartbeat()
+	m.emitHeartbeat()
+	sample := db.GetSample(data.GetSampleId(&data.Sample{From: "test", To: "test", Key: data.HEARTBEAT, FromId: m.nodeId(), ToId: m.nodeId()}))
+	if sample.Value != "42" {
+		t.Errorf("Expected heartbeat counter 42, got %v", sample.Value)
+	}
+}
+
+func Test_heartbeatSeed(t *testing.T) {
+	start := time.Unix(1000, 0)
+	interval := 10 * time.Second
+	if seed := heartbeatSeed(start, 0); seed != 0 {
+		t.Errorf("Expected no seed without heartbeat, got %v", seed)
+	}
+	seed := heartbeatSeed(start, interval)
+	if seed != 100 {
+		t.Errorf("Expected the intervals since the epoch 100, got %v", seed)
+	}
+	// a node emitting for a minute & restarting right away does not regress
+	last := seed + uint64(time.Minute/interval)
+	if restarted := heartbeatSeed(start.Add(time.Minute), interval) + 1; restarted < last {
+		t.Errorf("Expected the restarted counter %v to continue at least at %v", restarted, last)
+	}
+}
+
+func Test_discoveredState(t *testing.T) {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	known := &meshv1.Node{Name: "a", Target: "a:8081"}
+	db.SetNode(data.Convert(known, NODE_SUSPECT))
+	unknown := &meshv1.Node{Name: "b", Target: "b:8081"}
+
+	// discovered nodes are ok by default
+	if state := m.discoveredState(unknown); state != NODE_OK {
+		t.Errorf("Expected ok by default, got %v", stateName(state))
+	}
+	m.routineConfig.NodeStates.DiscoveredState = NODE_PENDING
+	if state := m.discoveredState(unknown); state != NODE_PENDING {
+		t.Errorf("Expected an unknown node to be pending, got %v", stateName(state))
+	}
+	if state := m.discoveredState(known); state != NODE_SUSPECT {
+		t.Errorf("Expected a known node to keep its state, got %v", stateName(state))
+	}
+}
+
+func Test_pingPendingNodes(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(server, &MeshServer{metrics: metric.InitMetrics(), log: zap.NewNop().Sugar(), data: &remote})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.routineConfig.PingRetryDelay = time.Millisecond
+	m.routineConfig.NodeStates = NodeStateConfiguration{TimeoutAfter: 1, DeadAfter: 3, RemoveAfter: time.Minute, DiscoveredState: NODE_PENDING}
+	node := &meshv1.Node{Name: "a", Target: lis.Addr().String()}
+	db.SetNode(data.Convert(node, m.discoveredState(node)))
+
+	m.pingPendingNodes()
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		if stored, _ := db.GetNode(GetId(node)); stored.State == NODE_OK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the pending node to be ok after a successful ping")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func Test_migrateLegacySenders(t *testing.T) {
+	m := testMesh(time.Second)
+	m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+	legacy := &data.Sample{From: "old", To: m.name.get(), Key: data.RTT_TOTAL, Value: "1", Ts: 1}
+	m.database.SetSample(legacy)
+	m.legacySenders.add(legacy.From)
+
+	// the sender is not known yet, the sample keeps the legacy id
+	m.migrateLegacySenders()
+	if sample := m.database.GetSample(data.GetSampleId(legacy)); sample.Value != "1" {
+		t.Errorf("Expected the sample to keep the legacy id, got %+v", sample)
+	}
+
+	old := &meshv1.Node{Name: "old", Target: "old:8081"}
+	m.database.SetNode(data.Convert(old, NODE_OK))
+	m.migrateLegacySenders()
+	migrated := &data.Sample{From: "old", To: m.name.get(), Key: data.RTT_TOTAL, FromId: GetId(old), ToId: m.nodeId()}
+	if sample := m.database.GetSample(data.GetSampleId(migrated)); sample.Value != "1" {
+		t.Errorf("Expected the sample to be migrated to the node ids, got %+v", sample)
+	}
+	if m.legacySenders.known(m.sampleIds()) {
+		t.Error("Expected the migrated sender to be removed")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata key of the wall-clock send time of a ping in unix nanoseconds
+const pingSendTimeKey = "ping-send-time"
+
+// Check if the one-way delay to a node can be measured: the clock skew
+// estimated by the last ping has to be below the max. skew.
+// The reason is returned if the one-way delay is skipped.
+func (m *Mesh) oneWayDelayGate(node *meshv1.Node) string {
+	skew := m.database.GetSample(GetSampleId(&meshv1.Sample{From: m.name.get(), To: node.Name, Key: data.CLOCK_SKEW, FromId: m.nodeId(), ToId: GetId(node)}))
+	if skew.Ts == 0 {
+		return "clock skew unknown"
+	}
+	ns, err := strconv.ParseInt(skew.Value, 10, 64)
+	if err != nil {
+		return "clock skew unknown"
+	}
+	if d := time.Duration(ns); d > m.setupConfig.OneWayDelayMaxSkew || d < -m.setupConfig.OneWayDelayMaxSkew {
+		return fmt.Sprintf("clock skew %v above max. %v", d, m.setupConfig.OneWayDelayMaxSkew)
+	}
+	return ""
+}
+
+// Context of a ping, with the send time if the one-way delay is measured.
+// A change of the gate of a node is logged, the RTT is measured anyway.
+func (m *Mesh) pingSendContext(ctx context.Context, node *meshv1.Node, sendTime time.Time) (context.Context, bool) {
+	if !m.setupConfig.OneWayDelay {
+		return ctx, false
+	}
+	reason := m.oneWayDelayGate(node)
+
+	m.mu.Lock()
+	if m.oneWayDelaySkipped == nil {
+		m.oneWayDelaySkipped = map[uint32]string{}
+	}
+	last, known := m.oneWayDelaySkipped[GetId(node)]
+	m.oneWayDelaySkipped[GetId(node)] = reason
+	m.mu.Unlock()
+
+	log := m.logger.Named("ping-routine")
+	if reason != "" && (!known || last == "") {
+		log.Infow("One-way delay skipped - just RTT measured", "node", node.Name, "reason", reason)
+	} else if reason == "" && last != "" {
+		log.Infow("One-way delay measured", "node", node.Name)
+	}
+	if reason != "" {
+		return ctx, false
+	}
+	return metadata.AppendToOutgoingContext(ctx, pingSendTimeKey, strconv.FormatInt(sendTime.UnixNano(), 10)), true
+}
+
+// Save the one-way delay of the ping response, from the pinged node to this node
+func (m *Mesh) observeResponseDelay(node *meshv1.Node, end time.Time, peerTime int64) {
+	if peerTime == 0 {
+		return
+	}
+	m.setOneWayDelay(node.Name, GetId(node), m.name.get(), m.nodeId(), end.Sub(time.Unix(0, peerTime)))
+}
+
+// Save the one-way delay of a received ping, from the pinging node to this node.
+// Just pings with a send time are measured, the pinging node checked the clock skew.
+func (m *Mesh) observePingDelay(ctx context.Context, from *meshv1.Node, recv time.Time) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(pingSendTimeKey)
+	if len(values) == 0 || from == nil {
+		return
+	}
+	sendTime, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return
+	}
+	m.setOneWayDelay(from.Name, GetId(from), m.name.get(), m.nodeId(), recv.Sub(time.Unix(0, sendTime)))
+}
+
+// Save the one-way delay sample of the direction from -> to.
+// The delay can be negative if the clocks are not synchronized.
+func (m *Mesh) setOneWayDelay(from string, fromId uint32, to string, toId uint32, delay time.Duration) {
+	m.database.SetSample(&data.Sample{
+		From:   from,
+		To:     to,
+		Key:    data.ONE_WAY_DELAY,
+		Value:  strconv.FormatInt(delay.Nanoseconds(), 10),
+		Ts:     time.Now().Unix(),
+		FromId: fromId,
+		ToId:   toId,
+	})
+}
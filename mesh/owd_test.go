@@ -0,0 +1,123 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+// Test mesh with a database and the one-way delay enabled
+func testOneWayDelayMesh(t *testing.T) *Mesh {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.setupConfig.OneWayDelay = true
+	m.setupConfig.OneWayDelayMaxSkew = time.Millisecond
+	return m
+}
+
+func Test_pingSendContext(t *testing.T) {
+	node := &meshv1.Node{Name: "peer", Target: "peer:8081"}
+	tests := []struct {
+		name     string
+		disabled bool
+		skew     time.Duration
+		noSkew   bool
+		expected bool
+	}{
+		{name: "small skew", skew: 100 * time.Microsecond, expected: true},
+		{name: "negative small skew", skew: -100 * time.Microsecond, expected: true},
+		{name: "large skew", skew: 5 * time.Millisecond, expected: false},
+		{name: "unknown skew", noSkew: true, expected: false},
+		{name: "disabled", disabled: true, skew: 100 * time.Microsecond, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testOneWayDelayMesh(t)
+			m.setupConfig.OneWayDelay = !tt.disabled
+			if !tt.noSkew {
+				m.database.SetSample(&data.Sample{From: "test", To: "peer", Key: data.CLOCK_SKEW, Value: strconv.FormatInt(tt.skew.Nanoseconds(), 10), Ts: time.Now().Unix(), FromId: m.nodeId(), ToId: GetId(node)})
+			}
+
+			sendTime := time.Now()
+			ctx, measured := m.pingSendContext(context.Background(), node, sendTime)
+			if measured != tt.expected {
+				t.Fatalf("Expected one-way delay measured %v, got %v", tt.expected, measured)
+			}
+			md, _ := metadata.FromOutgoingContext(ctx)
+			values := md.Get(pingSendTimeKey)
+			if !tt.expected {
+				if len(values) != 0 {
+					t.Errorf("Expected no send time, got %v", values)
+				}
+				return
+			}
+			if len(values) != 1 || values[0] != strconv.FormatInt(sendTime.UnixNano(), 10) {
+				t.Errorf("Expected the send time %v, got %v", sendTime.UnixNano(), values)
+			}
+		})
+	}
+}
+
+func Test_observePingDelay(t *testing.T) {
+	m := testOneWayDelayMesh(t)
+	from := &meshv1.Node{Name: "peer", Target: "peer:8081"}
+	recv := time.Now()
+	id := data.GetSampleId(&data.Sample{From: "peer", To: "test", Key: data.ONE_WAY_DELAY, FromId: GetId(from), ToId: m.nodeId()})
+
+	// pings without send time are not measured
+	m.observePingDelay(context.Background(), from, recv)
+	if sample := m.database.GetSample(id); sample.Ts != 0 {
+		t.Fatalf("Expected no one-way delay without send time, got %+v", sample)
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(pingSendTimeKey, strconv.FormatInt(recv.Add(-2*time.Millisecond).UnixNano(), 10)))
+	m.observePingDelay(ctx, from, recv)
+	if sample := m.database.GetSample(id); sample.Value != strconv.FormatInt((2*time.Millisecond).Nanoseconds(), 10) {
+		t.Errorf("Expected a one-way delay of 2ms from the peer, got %+v", sample)
+	}
+}
+
+func Test_observeResponseDelay(t *testing.T) {
+	m := testOneWayDelayMesh(t)
+	node := &meshv1.Node{Name: "peer", Target: "peer:8081"}
+	end := time.Now()
+	m.observeResponseDelay(node, end, end.Add(-3*time.Millisecond).UnixNano())
+
+	sample := m.database.GetSample(data.GetSampleId(&data.Sample{From: "peer", To: "test", Key: data.ONE_WAY_DELAY, FromId: GetId(node), ToId: m.nodeId()}))
+	if sample.Value != strconv.FormatInt((3*time.Millisecond).Nanoseconds(), 10) {
+		t.Errorf("Expected a one-way delay of 3ms from the peer, got %+v", sample)
+	}
+}
@@ -0,0 +1,164 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// Health service of the outbound probes, NOT_SERVING while paused.
+// The mesh service keeps serving the inbound RPCs.
+const PROBING_HEALTH_SERVICE = "canary-bot.probing"
+
+// Pause the outbound probes (pings, RTT, throughput & external probes),
+// the node stays in the mesh and answers the inbound RPCs
+func (m *Mesh) Pause() error {
+	return m.setPaused(true)
+}
+
+// Resume the outbound probes of a paused node
+func (m *Mesh) Resume() error {
+	return m.setPaused(false)
+}
+
+// Check if the outbound probes are paused
+func (m *Mesh) Paused() bool {
+	return m.paused.Load()
+}
+
+// Set the paused state, persisted to the pause state path if set.
+// The state is not changed if it can not be persisted.
+func (m *Mesh) setPaused(paused bool) error {
+	if path := m.setupConfig.PauseStatePath; path != "" {
+		var err error
+		if paused {
+			err = os.WriteFile(path, []byte("paused\n"), 0o600)
+		} else if err = os.Remove(path); errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("persist paused state: %w", err)
+		}
+	}
+
+	if m.paused.Swap(paused) != paused {
+		if paused {
+			m.logger.Warnw("PAUSED - outbound probes are suspended until resumed", "persisted", m.setupConfig.PauseStatePath != "")
+			m.recordEvent(data.EVENT_PAUSE, m.name.get(), "outbound probes paused")
+		} else {
+			m.logger.Warnw("RESUMED - outbound probes are running again")
+			m.recordEvent(data.EVENT_RESUME, m.name.get(), "outbound probes resumed")
+		}
+	}
+	m.observePaused()
+	return nil
+}
+
+// Set the paused gauge and the health status of the probing
+func (m *Mesh) observePaused() {
+	paused := m.paused.Load()
+	if paused {
+		m.metrics.GetProbingPaused().Set(1)
+	} else {
+		m.metrics.GetProbingPaused().Set(0)
+	}
+
+	m.mu.Lock()
+	healthServer := m.healthServer
+	m.mu.Unlock()
+	if healthServer == nil {
+		return
+	}
+	status := healthv1.HealthCheckResponse_SERVING
+	if paused {
+		status = healthv1.HealthCheckResponse_NOT_SERVING
+	}
+	healthServer.SetServingStatus(PROBING_HEALTH_SERVICE, status)
+}
+
+// Check if the paused state is persisted at the path
+func pausedState(path string) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read paused state: %w", err)
+	}
+	return true, nil
+}
+
+// Check if a routine or probe has to run: not paused and within its schedule
+func (m *Mesh) probing(now time.Time, keys ...string) bool {
+	return !m.paused.Load() && m.scheduled(now, keys...)
+}
+
+// PauseNode requests a node to pause (or resume) its outbound probes
+// and returns the paused state. The token has to be an API token of the node,
+// the mesh token of the setup configuration is sent if the mesh RPCs are authenticated.
+func PauseNode(setupConfig *SetupConfiguration, target string, token string, timeout time.Duration, pause bool) (bool, error) {
+	command := "pause"
+	if !pause {
+		command = "resume"
+	}
+	routineConfig := StandardProductionRoutineConfig()
+	routineConfig.RequestTimeout = timeout
+	// no RTT is measured for the health score
+	routineConfig.HealthWindow = 0
+	m, err := newMesh(routineConfig, setupConfig, zap.NewNop().Sugar())
+	if err != nil {
+		return false, err
+	}
+
+	node := &meshv1.Node{Name: "node", Target: target}
+	c, err := m.initClient(node)
+	if err != nil {
+		return false, fmt.Errorf("%v %v: %w", command, target, err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	client := c.client
+	var res *meshv1.PauseResponse
+	if pause {
+		res, err = client.Pause(ctx, &meshv1.PauseRequest{})
+	} else {
+		res, err = client.Resume(ctx, &meshv1.ResumeRequest{})
+	}
+	if err != nil {
+		return false, fmt.Errorf("%v %v: %w", command, target, classifyError(err))
+	}
+	return res.Paused, nil
+}
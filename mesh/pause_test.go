@@ -0,0 +1,173 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_setPaused(t *testing.T) {
+	m := testMesh(time.Second)
+	m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+	m.healthServer = health.NewServer()
+	m.setupConfig.PauseStatePath = filepath.Join(t.TempDir(), "paused")
+
+	if err := m.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Paused() || m.probing(time.Now(), SCHEDULE_RTT) {
+		t.Error("Expected the probes to be paused")
+	}
+	if paused, err := pausedState(m.setupConfig.PauseStatePath); err != nil || !paused {
+		t.Errorf("Expected the paused state to be persisted, got %v %v", paused, err)
+	}
+	if value := gatherValue(t, m.metrics, "probing_paused"); value != 1 {
+		t.Errorf("Expected the paused gauge to be 1, got %v", value)
+	}
+	res, err := m.healthServer.Check(context.Background(), &healthv1.HealthCheckRequest{Service: PROBING_HEALTH_SERVICE})
+	if err != nil || res.Status != healthv1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("Expected the probing to be not serving, got %v %v", res, err)
+	}
+	if events := m.database.GetEventList(); len(events) != 1 || events[0].Type != data.EVENT_PAUSE {
+		t.Errorf("Expected a pause event, got %+v", events)
+	}
+
+	if err := m.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Paused() || !m.probing(time.Now(), SCHEDULE_RTT) {
+		t.Error("Expected the probes to be resumed")
+	}
+	if _, err := os.Stat(m.setupConfig.PauseStatePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the paused state to be removed, got %v", err)
+	}
+	if value := gatherValue(t, m.metrics, "probing_paused"); value != 0 {
+		t.Errorf("Expected the paused gauge to be 0, got %v", value)
+	}
+	// resuming a running node is a no-op
+	if err := m.Resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the state is not changed if it can not be persisted
+	m.setupConfig.PauseStatePath = filepath.Join(t.TempDir(), "missing", "paused")
+	if err := m.Pause(); err == nil || m.Paused() {
+		t.Errorf("Expected an error and no pause, got %v", err)
+	}
+}
+
+func Test_PauseNode(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := testMesh(time.Second)
+	m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+	grpcServer := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(grpcServer, &MeshServer{log: zap.NewNop().Sugar(), tokens: []string{"secret"}, pause: m.setPaused})
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+
+	setupConfig := &SetupConfiguration{Name: "cli", JoinAddress: "localhost:0"}
+	if paused, err := PauseNode(setupConfig, lis.Addr().String(), "secret", time.Second, true); err != nil || !paused || !m.Paused() {
+		t.Fatalf("Expected the node to be paused, got %v %v", paused, err)
+	}
+	if paused, err := PauseNode(setupConfig, lis.Addr().String(), "secret", time.Second, false); err != nil || paused || m.Paused() {
+		t.Fatalf("Expected the node to be resumed, got %v %v", paused, err)
+	}
+
+	// the mesh token is sent apart from the API token
+	authenticated := testMesh(time.Second)
+	authenticated.meshToken, _ = newMeshToken("mesh-secret", "", zap.NewNop().Sugar())
+	authLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authServer := grpc.NewServer(grpc.ChainUnaryInterceptor(authenticated.authUnaryInterceptor))
+	meshv1.RegisterMeshServiceServer(authServer, &MeshServer{log: zap.NewNop().Sugar(), tokens: []string{"secret"}, pause: m.setPaused})
+	go func() { _ = authServer.Serve(authLis) }()
+	defer authServer.Stop()
+	if _, err := PauseNode(setupConfig, authLis.Addr().String(), "secret", time.Second, true); err == nil || m.Paused() {
+		t.Errorf("Expected an error and no pause without the mesh token, got %v", err)
+	}
+	meshTokenConfig := &SetupConfiguration{Name: "cli", JoinAddress: "localhost:0", MeshToken: "mesh-secret"}
+	if paused, err := PauseNode(meshTokenConfig, authLis.Addr().String(), "secret", time.Second, true); err != nil || !paused || !m.Paused() {
+		t.Errorf("Expected the node to be paused with the mesh token, got %v %v", paused, err)
+	}
+
+	s := &MeshServer{log: zap.NewNop().Sugar(), tokens: []string{"secret"}, pause: m.setPaused}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer wrong"))
+	if _, err := s.Pause(ctx, &meshv1.PauseRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected unauthenticated error, got %v", err)
+	}
+}
+
+func Test_retryPingPaused(t *testing.T) {
+	tests := []struct {
+		name           string
+		indirectProbes int
+	}{
+		{name: "retry ping", indirectProbes: 0},
+		{name: "retry ping suspect", indirectProbes: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMesh(time.Second)
+			m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+			m.routineConfig.NodeStates.IndirectProbes = tt.indirectProbes
+			m.routineConfig.PingRetryDelay = time.Hour
+			node := &meshv1.Node{Name: "a", Target: "a:8081"}
+			m.database.SetNode(data.Convert(node, NODE_TIMEOUT))
+			m.paused.Store(true)
+
+			done := make(chan struct{})
+			go func() {
+				m.retryPing(node)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected the retries to stop while paused")
+			}
+			if stored, _ := m.database.GetNode(GetId(node)); stored.State != NODE_TIMEOUT {
+				t.Errorf("Expected the node to keep its state, got %v", stateName(stored.State))
+			}
+		})
+	}
+}
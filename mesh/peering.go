@@ -0,0 +1,155 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+// Prefixes of a peering rule matching a node label
+const (
+	PEERING_LABEL_PREFIX = "label:"
+	PEERING_SAME_PREFIX  = "same:"
+)
+
+// Peering rule of a partial mesh, the nodes matching a rule are probed
+// and gossiped with. Other nodes are still known, but not probed.
+type PeeringRule struct {
+	// Glob pattern of the node names, e.g. gw-*
+	NamePattern string
+	// Label of the nodes, e.g. role=gateway
+	LabelKey   string
+	LabelValue string
+	// Label the nodes share with this node, e.g. region
+	SameLabel string
+}
+
+// Parse a peering rule in the format PATTERN, label:KEY=VALUE
+// or same:KEY, e.g. gw-*, label:role=gateway, same:region
+func ParsePeeringRule(rule string) (PeeringRule, error) {
+	var r PeeringRule
+	switch {
+	case strings.HasPrefix(rule, PEERING_LABEL_PREFIX):
+		key, value, found := strings.Cut(strings.TrimPrefix(rule, PEERING_LABEL_PREFIX), "=")
+		if !found || key == "" {
+			return r, fmt.Errorf("invalid label of peering rule %v, format: label:KEY=VALUE", rule)
+		}
+		r.LabelKey, r.LabelValue = key, value
+	case strings.HasPrefix(rule, PEERING_SAME_PREFIX):
+		key := strings.TrimPrefix(rule, PEERING_SAME_PREFIX)
+		if key == "" {
+			return r, fmt.Errorf("invalid label of peering rule %v, format: same:KEY", rule)
+		}
+		r.SameLabel = key
+	default:
+		if _, err := path.Match(rule, ""); err != nil || rule == "" {
+			return r, fmt.Errorf("invalid name pattern of peering rule %v", rule)
+		}
+		r.NamePattern = rule
+	}
+	return r, nil
+}
+
+// Check if the rule matches the node by name pattern or label,
+// labels are the labels of this node
+func (r PeeringRule) matches(labels map[string]string, node *data.Node) bool {
+	switch {
+	case r.NamePattern != "":
+		ok, _ := path.Match(r.NamePattern, node.Name)
+		return ok
+	case r.SameLabel != "":
+		own, ok := labels[r.SameLabel]
+		value, found := node.Labels[r.SameLabel]
+		return ok && found && value == own
+	default:
+		value, ok := node.Labels[r.LabelKey]
+		return ok && value == r.LabelValue
+	}
+}
+
+// Check if this node peers with a node, all nodes are peered without rules
+func (m *Mesh) peered(node *data.Node) bool {
+	if len(m.peeringRules) == 0 {
+		return true
+	}
+	for _, r := range m.peeringRules {
+		if r.matches(m.setupConfig.Labels, node) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the peered nodes of a list of nodes
+func (m *Mesh) peers(nodes []*data.Node) []*data.Node {
+	if len(m.peeringRules) == 0 {
+		return nodes
+	}
+	var peers []*data.Node
+	for _, node := range nodes {
+		if m.peered(node) {
+			peers = append(peers, node)
+		}
+	}
+	return peers
+}
+
+// Get a specific amount of random healthy peered nodes, without the given nodes
+func (m *Mesh) randomPeers(amount int, without ...uint32) []*data.Node {
+	if len(m.peeringRules) == 0 {
+		return m.database.GetRandomNodeListByState(NODE_OK, amount, without...)
+	}
+	peers := m.peers(m.database.GetRandomNodeListByState(NODE_OK, math.MaxInt, without...))
+	if len(peers) > amount {
+		return peers[:amount]
+	}
+	return peers
+}
+
+// Ping the least recently seen non-peered node, healthy or pending.
+// The non-peered nodes are not probed, the low-rate liveness ping
+// detects the dead ones, so they are not kept as healthy forever.
+func (m *Mesh) pingNonPeer() {
+	if node := m.leastSeenNonPeer(); node != nil {
+		m.pingOnce(node)
+	}
+}
+
+// Get the least recently seen non-peered node, healthy or pending.
+// Nil without peering rules or if all nodes are peered.
+func (m *Mesh) leastSeenNonPeer() *data.Node {
+	if len(m.peeringRules) == 0 {
+		return nil
+	}
+	var oldest *data.Node
+	for _, node := range append(m.database.GetNodeListByState(NODE_OK), m.database.GetNodeListByState(NODE_PENDING)...) {
+		if !m.peered(node) && (oldest == nil || node.LastSeen < oldest.LastSeen) {
+			oldest = node
+		}
+	}
+	return oldest
+}
@@ -0,0 +1,121 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+func Test_ParsePeeringRule(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      string
+		expected  PeeringRule
+		expectErr bool
+	}{
+		{name: "name pattern", rule: "gw-*", expected: PeeringRule{NamePattern: "gw-*"}},
+		{name: "label", rule: "label:role=gateway", expected: PeeringRule{LabelKey: "role", LabelValue: "gateway"}},
+		{name: "same label", rule: "same:region", expected: PeeringRule{SameLabel: "region"}},
+		{name: "invalid label", rule: "label:role", expectErr: true},
+		{name: "no same label", rule: "same:", expectErr: true},
+		{name: "invalid pattern", rule: "gw-[", expectErr: true},
+		{name: "empty", rule: "", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParsePeeringRule(tt.rule)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if !tt.expectErr && r != tt.expected {
+				t.Errorf("got rule %+v, expected %+v", r, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_peers(t *testing.T) {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.setupConfig.Labels = map[string]string{"region": "eu"}
+	db.SetNodes([]*data.Node{
+		{Id: 1, Name: "eu-1", Target: "eu-1:8081", State: NODE_OK, Labels: map[string]string{"region": "eu"}},
+		{Id: 2, Name: "us-1", Target: "us-1:8081", State: NODE_OK, Labels: map[string]string{"region": "us"}},
+		{Id: 3, Name: "us-gw", Target: "us-gw:8081", State: NODE_OK, Labels: map[string]string{"region": "us", "role": "gateway"}},
+		{Id: 4, Name: "other", Target: "other:8081", State: NODE_OK},
+	})
+
+	// full mesh without rules
+	if nodes := m.rttNodes(); len(nodes) != 4 {
+		t.Errorf("Expected all nodes to be peered without rules, got %v", len(nodes))
+	}
+
+	for _, p := range []string{"same:region", "label:role=gateway"} {
+		rule, err := ParsePeeringRule(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.peeringRules = append(m.peeringRules, rule)
+	}
+	peered := map[string]bool{}
+	for _, node := range m.rttNodes() {
+		peered[node.Name] = true
+	}
+	if len(peered) != 2 || !peered["eu-1"] || !peered["us-gw"] {
+		t.Errorf("Expected eu-1 and us-gw to be peered, got %v", peered)
+	}
+	for i := 0; i < 10; i++ {
+		for _, node := range m.randomPeers(1) {
+			if !peered[node.Name] {
+				t.Errorf("Expected just peers to be selected, got %v", node.Name)
+			}
+		}
+	}
+	if nodes := m.randomPeers(5); len(nodes) != 2 {
+		t.Errorf("Expected all peers, got %v", len(nodes))
+	}
+	if nodes := m.randomPeers(5, 1); len(nodes) != 1 || nodes[0].Name != "us-gw" {
+		t.Errorf("Expected the peers without eu-1, got %v", nodes)
+	}
+
+	// the non-peered nodes are pinged by the least recent contact
+	if node := m.leastSeenNonPeer(); node == nil || node.Name != "us-1" && node.Name != "other" {
+		t.Errorf("Expected a non-peered node, got %v", node)
+	}
+	db.SetNodeLastSeen(2)
+	if node := m.leastSeenNonPeer(); node == nil || node.Name != "other" {
+		t.Errorf("Expected the least recently seen non-peered node, got %v", node)
+	}
+	// the topology is still known
+	if nodes := db.GetNodeList(); len(nodes) != 4 {
+		t.Errorf("Expected all nodes to be known, got %v", len(nodes))
+	}
+}
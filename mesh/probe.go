@@ -0,0 +1,457 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Probe types of external targets
+const (
+	PROBE_HTTP = "http"
+	// HTTP probe of an encrypted target, scheme of the target only
+	PROBE_HTTPS = "https"
+	PROBE_TCP   = "tcp"
+	PROBE_DNS   = "dns"
+	PROBE_ICMP  = "icmp"
+	// HTTP/3 over QUIC
+	PROBE_HTTP3 = "h3"
+	// gRPC health check of a service
+	PROBE_GRPC = "grpc"
+	// RTT measurement of the mesh peers
+	PROBE_RTT = "rtt"
+)
+
+// Sample keys of external probes
+const (
+	PROBE_HTTP_KEY = 10
+	PROBE_TCP_KEY  = 11
+	PROBE_DNS_KEY  = 12
+	PROBE_ICMP_KEY = 13
+	PROBE_H3_KEY   = 14
+	PROBE_GRPC_KEY = 15
+	// Serving status of the gRPC health check, NaN if the check failed
+	PROBE_GRPC_STATUS_KEY = 16
+)
+
+// Sub-fields of the HTTP probe samples
+const (
+	PROBE_FIELD_STATUS = "status"
+	PROBE_FIELD_SIZE   = "size"
+)
+
+// Map probe types to their sample keys
+var probeSampleKeys = map[string]int64{
+	PROBE_HTTP:  PROBE_HTTP_KEY,
+	PROBE_TCP:   PROBE_TCP_KEY,
+	PROBE_DNS:   PROBE_DNS_KEY,
+	PROBE_ICMP:  PROBE_ICMP_KEY,
+	PROBE_HTTP3: PROBE_H3_KEY,
+	PROBE_GRPC:  PROBE_GRPC_KEY,
+}
+
+func init() {
+	data.MustRegisterSampleType(PROBE_HTTP_KEY, "probe_http", "ns")
+	data.MustRegisterSampleType(PROBE_TCP_KEY, "probe_tcp", "ns")
+	data.MustRegisterSampleType(PROBE_DNS_KEY, "probe_dns", "ns")
+	data.MustRegisterSampleType(PROBE_ICMP_KEY, "probe_icmp", "ns")
+	data.MustRegisterSampleType(PROBE_H3_KEY, "probe_h3", "ns")
+	data.MustRegisterSampleType(PROBE_GRPC_KEY, "probe_grpc", "ns")
+	data.MustRegisterSampleType(PROBE_GRPC_STATUS_KEY, "probe_grpc_status", "")
+}
+
+// Probe of an external target unrelated to the mesh peers
+type Probe struct {
+	Type     string
+	Target   string
+	Interval time.Duration
+
+	// Probe TYPE://TARGET without interval, key of the probe schedule
+	name string
+	// Dialer of the probe, e.g. with DSCP marking
+	dialer *socketDialer
+	// Server name (SNI) of HTTP probes
+	serverName string
+	// TLS config of HTTPS probes, the system roots if nil
+	tlsConfig *tls.Config
+	// DSCP value of ICMP probes, -1 unmarked
+	icmpDscp int
+	// Local IP of ICMP probes, any address if empty
+	localAddress string
+	// Transport of HTTP/3 probes
+	http3Transport http.RoundTripper
+	// Transport credentials of gRPC probes
+	grpcCredentials credentials.TransportCredentials
+	log             *zap.SugaredLogger
+}
+
+// The serving status of a gRPC health check is not SERVING
+type grpcHealthError struct {
+	status healthv1.HealthCheckResponse_ServingStatus
+}
+
+func (e *grpcHealthError) Error() string {
+	return "grpc health status " + e.status.String()
+}
+
+// Parse a probe in the format TYPE://TARGET[#INTERVAL]
+// e.g. http://example.com/health#30s, https://example.com/health, tcp://example.com:443, dns://example.com, icmp://10.0.0.1,
+// h3://example.com/health, grpc://example.com:443/my.Service (the service is optional)
+func ParseProbe(probe string, defaultInterval time.Duration) (*Probe, error) {
+	probeType, target, found := strings.Cut(probe, "://")
+	if !found || target == "" {
+		return nil, fmt.Errorf("invalid probe %v, format: TYPE://TARGET[#INTERVAL]", probe)
+	}
+	// https targets are http probes keeping the scheme
+	scheme := probeType
+	if probeType == PROBE_HTTPS {
+		probeType = PROBE_HTTP
+	}
+	if _, ok := probeSampleKeys[probeType]; !ok {
+		return nil, fmt.Errorf("unknown probe type %v, supported: http, https, tcp, dns, icmp, h3, grpc", probeType)
+	}
+
+	p := &Probe{Type: probeType, Target: target, Interval: defaultInterval, name: probe, dialer: &socketDialer{noDelay: true}, icmpDscp: -1, log: zap.NewNop().Sugar()}
+	if target, interval, found := strings.Cut(target, "#"); found {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid interval of probe %v", probe)
+		}
+		p.Target = target
+		p.Interval = d
+		p.name = scheme + "://" + target
+	}
+
+	// http probes keep the scheme of the target
+	if p.Type == PROBE_HTTP {
+		p.Target = scheme + "://" + p.Target
+	}
+	// HTTP/3 is always encrypted
+	if p.Type == PROBE_HTTP3 {
+		p.Target = "https://" + p.Target
+	}
+	return p, nil
+}
+
+// Run the probe once and return the measured duration
+func (p *Probe) Run(ctx context.Context) (time.Duration, error) {
+	duration, _, err := p.RunFields(ctx)
+	return duration, err
+}
+
+// Run the probe once and return the measured duration and the sub-fields
+// of the result, e.g. the status & size of a HTTP probe; nil if none.
+// The sub-fields are set for a failed HTTP status as well.
+func (p *Probe) RunFields(ctx context.Context) (time.Duration, map[string]string, error) {
+	start := time.Now()
+	var fields map[string]string
+	var err error
+
+	switch p.Type {
+	case PROBE_HTTP:
+		fields, err = p.probeHttp(ctx)
+	case PROBE_TCP:
+		err = p.probeTcp(ctx)
+	case PROBE_DNS:
+		resolver := &net.Resolver{PreferGo: true, Dial: p.dialDns}
+		_, err = resolver.LookupHost(ctx, p.Target)
+	case PROBE_ICMP:
+		err = p.probeIcmp(ctx)
+	case PROBE_HTTP3:
+		fields, err = p.probeHttp3(ctx)
+	case PROBE_GRPC:
+		err = p.probeGrpc(ctx)
+	default:
+		err = fmt.Errorf("unknown probe type %v", p.Type)
+	}
+
+	return time.Since(start), fields, err
+}
+
+func (p *Probe) probeHttp(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Target, nil)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = p.dialer.DialContext
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if p.tlsConfig != nil {
+		tlsConfig = p.tlsConfig.Clone()
+	}
+	if p.serverName != "" {
+		tlsConfig.ServerName = p.serverName
+	}
+	transport.TLSClientConfig = tlsConfig
+	// a new connection for every probe
+	transport.DisableKeepAlives = true
+	res, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return httpFields(res), httpStatusError(res)
+}
+
+// Get the sub-fields of a HTTP probe: the status code and the size
+// of the response body, if the content length is known
+func httpFields(res *http.Response) map[string]string {
+	fields := map[string]string{PROBE_FIELD_STATUS: strconv.Itoa(res.StatusCode)}
+	if res.ContentLength >= 0 {
+		fields[PROBE_FIELD_SIZE] = strconv.FormatInt(res.ContentLength, 10)
+	}
+	return fields
+}
+
+// A HTTP status of 400 or above fails the probe
+func httpStatusError(res *http.Response) error {
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("http status %v", res.StatusCode)
+	}
+	return nil
+}
+
+// Request the target over HTTP/3, by the transport of the embedder if set.
+// A new QUIC connection is dialed for every probe, so every probe
+// measures the QUIC handshake and the request.
+func (p *Probe) probeHttp3(ctx context.Context) (map[string]string, error) {
+	transport := p.http3Transport
+	if transport == nil {
+		roundTripper := newHttp3RoundTripper(p.tlsConfig, p.serverName)
+		defer roundTripper.Close()
+		transport = roundTripper
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Target, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: transport}
+	defer client.CloseIdleConnections()
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, quicError(err)
+	}
+	defer res.Body.Close()
+	return httpFields(res), httpStatusError(res)
+}
+
+// Call the standard health check of the target, grpc.health.v1.Health/Check.
+// The service is the path of the target, the overall health of the server is
+// checked without a service. A new connection is dialed for every probe,
+// a serving status other than SERVING returns a grpcHealthError.
+func (p *Probe) probeGrpc(ctx context.Context) error {
+	if p.grpcCredentials == nil {
+		return errors.New("no gRPC transport credentials set")
+	}
+	address, service, _ := strings.Cut(p.Target, "/")
+	conn, err := grpc.DialContext(ctx, address,
+		grpc.WithTransportCredentials(p.grpcCredentials),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return p.dialer.DialContext(ctx, "tcp", addr)
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	res, err := healthv1.NewHealthClient(conn).Check(ctx, &healthv1.HealthCheckRequest{Service: service})
+	// the standard health server responds NotFound for unknown services
+	if status.Code(err) == codes.NotFound {
+		return &grpcHealthError{status: healthv1.HealthCheckResponse_SERVICE_UNKNOWN}
+	}
+	if err != nil {
+		return err
+	}
+	if res.Status != healthv1.HealthCheckResponse_SERVING {
+		return &grpcHealthError{status: res.Status}
+	}
+	return nil
+}
+
+// Value of the gRPC serving status sample of a probe result.
+// The status number is stored if the check responded, NaN if it failed.
+func grpcStatusValue(err error) string {
+	if err == nil {
+		return strconv.Itoa(int(healthv1.HealthCheckResponse_SERVING))
+	}
+	var healthErr *grpcHealthError
+	if errors.As(err, &healthErr) {
+		return strconv.Itoa(int(healthErr.status))
+	}
+	return "NaN"
+}
+
+func (p *Probe) probeTcp(ctx context.Context) error {
+	conn, err := p.dialer.DialContext(ctx, "tcp", p.Target)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Dial the DNS server, the local TCP address
+// of the dialer is converted for UDP dials
+func (p *Probe) dialDns(ctx context.Context, network, address string) (net.Conn, error) {
+	d := *p.dialer
+	if local, ok := d.LocalAddr.(*net.TCPAddr); ok && strings.HasPrefix(network, "udp") {
+		d.LocalAddr = &net.UDPAddr{IP: local.IP}
+	}
+	return d.DialContext(ctx, network, address)
+}
+
+// Unprivileged ICMP echo, needs net.ipv4.ping_group_range on linux
+func (p *Probe) probeIcmp(ctx context.Context) error {
+	ip, err := net.DefaultResolver.LookupIP(ctx, "ip4", p.Target)
+	if err != nil {
+		return err
+	}
+
+	localAddress := "0.0.0.0"
+	if p.localAddress != "" {
+		localAddress = p.localAddress
+	}
+	conn, err := icmp.ListenPacket("udp4", localAddress)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// like the dialed connections, the probe is sent unmarked if marking is not supported
+	if p.icmpDscp >= 0 {
+		if err := conn.IPv4PacketConn().SetTOS(p.icmpDscp << 2); err != nil {
+			p.log.Warnw("Could not set DSCP - probe is unmarked", "dscp", p.icmpDscp, "target", p.Target, "error", err)
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: 1, Data: []byte("canary-bot")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	if _, err = conn.WriteTo(b, &net.UDPAddr{IP: ip[0]}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(reply)
+	if err != nil {
+		return err
+	}
+	res, err := icmp.ParseMessage(1, reply[:n])
+	if err != nil {
+		return err
+	}
+	if res.Type != ipv4.ICMPTypeEchoReply {
+		return errors.New("no icmp echo reply")
+	}
+	return nil
+}
+
+// Routine to probe an external target in the interval of the probe.
+// The results are saved as samples from this node to the target.
+func (m *Mesh) probeRoutine(p *Probe) {
+	log := m.logger.Named("probe-routine")
+	log.Infow("Starting probe", "type", p.Type, "target", p.Target, "interval", p.Interval.String())
+	key := probeSampleKeys[p.Type]
+	p.log = log
+	p.dialer = m.dialer(p.Type)
+	p.serverName = m.setupConfig.ServerNameOverride
+	p.localAddress = m.setupConfig.LocalAddress
+	p.http3Transport = m.setupConfig.Http3Transport
+	if p.Type == PROBE_GRPC {
+		// TLS like the mesh connections, the probe fails without credentials
+		p.grpcCredentials, _ = m.clientCredentials(log)
+	}
+	if dscp, ok := m.setupConfig.Dscp[PROBE_ICMP]; ok {
+		p.icmpDscp = dscp
+	}
+
+	ticker := time.NewTicker(p.Interval)
+	for now := range ticker.C {
+		if !m.probing(now, p.name, p.Type) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), m.routineConfig.RequestTimeout)
+		duration, fields, err := p.RunFields(ctx)
+		cancel()
+		m.metrics.ObserveProbe(p.Type, err)
+		if p.Type == PROBE_GRPC {
+			m.database.SetSample(&data.Sample{
+				From:   m.name.get(),
+				To:     p.Target,
+				Key:    PROBE_GRPC_STATUS_KEY,
+				Value:  grpcStatusValue(err),
+				Ts:     time.Now().Unix(),
+				FromId: m.nodeId(),
+			})
+		}
+
+		sample := &data.Sample{
+			From: m.name.get(),
+			// an external target has no node id, the ToId is unset
+			To:     p.Target,
+			Key:    key,
+			Ts:     time.Now().Unix(),
+			FromId: m.nodeId(),
+			Fields: fields,
+		}
+		if err != nil {
+			log.Debugw("Probe failed", "type", p.Type, "target", p.Target, "error", err)
+			m.metrics.GetProbeSuccess().WithLabelValues(p.Type, p.Target).Set(0)
+			sample.Value = "NaN"
+			m.database.SetSample(sample)
+			continue
+		}
+
+		m.metrics.GetProbeSuccess().WithLabelValues(p.Type, p.Target).Set(1)
+		m.metrics.GetProbeDuration().WithLabelValues(p.Type, p.Target).Observe(duration.Seconds())
+		sample.Value = strconv.FormatInt(duration.Nanoseconds(), 10)
+		m.database.SetSample(sample)
+	}
+}
@@ -0,0 +1,255 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	h "github.com/telekom/canary-bot/helper"
+	"github.com/telekom/canary-bot/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func Test_ParseProbeHttp3(t *testing.T) {
+	p, err := ParseProbe("h3://example.com/health#30s", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Target != "https://example.com/health" || p.Interval != 30*time.Second {
+		t.Errorf("Unexpected probe %+v", p)
+	}
+	if key := probeSampleKeys[p.Type]; key != PROBE_H3_KEY {
+		t.Errorf("Expected the h3 sample key, got %v", key)
+	}
+}
+
+func Test_probeHttp3(t *testing.T) {
+	caPath, cert := newTestCert(t)
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/fail" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("ok"))
+		}),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+	target := "localhost:" + strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+	tlsConfig, err := h.LoadClientTLSConfig([]string{caPath}, nil, false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		tlsConfig *tls.Config
+		wantErr   bool
+		status    string
+	}{
+		{name: "successful probe", path: "/health", tlsConfig: tlsConfig, status: "200"},
+		{name: "failed status", path: "/fail", tlsConfig: tlsConfig, wantErr: true, status: "503"},
+		// the certificate of the test server is unknown to the system roots
+		{name: "unknown certificate", path: "/health", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseProbe("h3://"+target+tt.path, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.tlsConfig = tt.tlsConfig
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, fields, err := p.RunFields(ctx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if fields[PROBE_FIELD_STATUS] != tt.status {
+				t.Errorf("Expected status %q, got %+v", tt.status, fields)
+			}
+		})
+	}
+}
+
+func Test_probeHttp3Transport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	target := strings.TrimPrefix(server.URL, "https://")
+
+	p, err := ParseProbe("h3://"+target+"/health", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the transport of the test server stands in for the HTTP/3 transport of an embedder
+	p.http3Transport = server.Client().Transport
+	if _, err := p.Run(context.Background()); err != nil {
+		t.Errorf("Expected a successful probe, got %v", err)
+	}
+}
+
+func Test_quicError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason string
+	}{
+		{name: "version negotiation", err: &quic.VersionNegotiationError{Ours: []quic.VersionNumber{quic.Version1}}, reason: metric.PROBE_QUIC_VERSION_NEGOTIATION},
+		{name: "0-RTT rejected", err: fmt.Errorf("round trip: %w", quic.Err0RTTRejected), reason: metric.PROBE_QUIC_0RTT_REJECTED},
+		{name: "other error", err: errors.New("no route"), reason: metric.PROBE_ERROR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reason := metric.ProbeReason(quicError(tt.err)); reason != tt.reason {
+				t.Errorf("Expected reason %v, got %v", tt.reason, reason)
+			}
+		})
+	}
+}
+
+func Test_probeHttpFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	p, err := ParseProbe(server.URL+"/health", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, fields, err := p.RunFields(context.Background())
+	if err != nil || fields[PROBE_FIELD_STATUS] != "200" || fields[PROBE_FIELD_SIZE] != "2" {
+		t.Errorf("Expected the status & size sub-fields, got %+v %v", fields, err)
+	}
+
+	// the status of a failed probe is kept
+	p.Target = server.URL + "/fail"
+	_, fields, err = p.RunFields(context.Background())
+	if err == nil || fields[PROBE_FIELD_STATUS] != "503" {
+		t.Errorf("Expected the failed status sub-field, got %+v %v", fields, err)
+	}
+}
+
+func Test_probeHttps(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	p, err := ParseProbe(server.URL+"/health#30s", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type != PROBE_HTTP || p.Target != server.URL+"/health" || p.name != server.URL+"/health" {
+		t.Fatalf("Unexpected https probe %+v", p)
+	}
+	if key := probeSampleKeys[p.Type]; key != PROBE_HTTP_KEY {
+		t.Errorf("Expected the http sample key, got %v", key)
+	}
+
+	// the certificate of the test server is unknown to the system roots
+	if _, err := p.Run(context.Background()); err == nil {
+		t.Error("Expected an error of the unknown certificate")
+	}
+	p.tlsConfig = server.Client().Transport.(*http.Transport).TLSClientConfig
+	_, fields, err := p.RunFields(context.Background())
+	if err != nil || fields[PROBE_FIELD_STATUS] != "200" {
+		t.Errorf("Expected a successful https probe, got %+v %v", fields, err)
+	}
+}
+
+func Test_probeGrpc(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("serving.Service", healthv1.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus("down.Service", healthv1.HealthCheckResponse_NOT_SERVING)
+	server := grpc.NewServer()
+	healthv1.RegisterHealthServer(server, healthServer)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	run := func(target string) error {
+		p, err := ParseProbe("grpc://"+target, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.grpcCredentials = insecure.NewCredentials()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = p.Run(ctx)
+		return err
+	}
+
+	if err := run(lis.Addr().String()); err != nil || grpcStatusValue(err) != "1" {
+		t.Errorf("Expected the server to be serving, got %v", err)
+	}
+	if err := run(lis.Addr().String() + "/serving.Service"); err != nil {
+		t.Errorf("Expected the service to be serving, got %v", err)
+	}
+	if err := run(lis.Addr().String() + "/down.Service"); grpcStatusValue(err) != "2" {
+		t.Errorf("Expected the not serving status, got %v", err)
+	}
+	if err := run(lis.Addr().String() + "/unknown.Service"); grpcStatusValue(err) != "3" {
+		t.Errorf("Expected the service unknown status, got %v", err)
+	}
+
+	// connection failures are not a serving status
+	addr := lis.Addr().String()
+	server.Stop()
+	if err := run(addr); err == nil || grpcStatusValue(err) != "NaN" {
+		t.Errorf("Expected a connection failure, got %v", err)
+	}
+}
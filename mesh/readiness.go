@@ -0,0 +1,184 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/telekom/canary-bot/api"
+	"github.com/telekom/canary-bot/data"
+)
+
+// Readiness policies of the node: ready unless paused (none), by a minimum
+// count of OK peers, a minimum fraction of OK peers or a minimum weighted
+// score of the OK peers
+const (
+	READINESS_NONE     = "none"
+	READINESS_COUNT    = "count"
+	READINESS_FRACTION = "fraction"
+	READINESS_WEIGHTED = "weighted"
+)
+
+// Weight of the peers matching a peering rule in the weighted readiness
+type readinessWeight struct {
+	rule   PeeringRule
+	weight float64
+}
+
+// Readiness policy evaluated against the current states of the peers
+type readinessPolicy struct {
+	policy      string
+	minPeers    int
+	minFraction float64
+	minScore    float64
+	weights     []readinessWeight
+}
+
+// Parse a readiness weight in the format RULE=WEIGHT, the rule is a peering
+// rule; e.g. label:role=seed=5, gw-*=2
+func parseReadinessWeight(weight string) (readinessWeight, error) {
+	i := strings.LastIndex(weight, "=")
+	if i < 0 {
+		return readinessWeight{}, fmt.Errorf("invalid readiness weight %v, format: RULE=WEIGHT", weight)
+	}
+	rule, err := ParsePeeringRule(weight[:i])
+	if err != nil {
+		return readinessWeight{}, err
+	}
+	w, err := strconv.ParseFloat(weight[i+1:], 64)
+	if err != nil || w < 0 {
+		return readinessWeight{}, fmt.Errorf("invalid weight of readiness weight %v, has to be a positive number", weight)
+	}
+	return readinessWeight{rule: rule, weight: w}, nil
+}
+
+// Create the readiness policy of the setup configuration, nil for the policy none
+func newReadinessPolicy(setupConfig *SetupConfiguration) (*readinessPolicy, error) {
+	p := &readinessPolicy{
+		policy:      setupConfig.ReadinessPolicy,
+		minPeers:    setupConfig.ReadinessMinPeers,
+		minFraction: setupConfig.ReadinessMinFraction,
+		minScore:    setupConfig.ReadinessMinScore,
+	}
+	switch p.policy {
+	case "", READINESS_NONE:
+		return nil, nil
+	case READINESS_COUNT:
+		if p.minPeers < 0 {
+			return nil, fmt.Errorf("readiness min. peers has to be positive")
+		}
+	case READINESS_FRACTION:
+		if p.minFraction < 0 || p.minFraction > 1 {
+			return nil, fmt.Errorf("readiness min. fraction has to be 0-1")
+		}
+	case READINESS_WEIGHTED:
+		if p.minScore < 0 || p.minScore > 1 {
+			return nil, fmt.Errorf("readiness min. score has to be 0-1")
+		}
+		for _, w := range setupConfig.ReadinessWeights {
+			weight, err := parseReadinessWeight(w)
+			if err != nil {
+				return nil, err
+			}
+			p.weights = append(p.weights, weight)
+		}
+	default:
+		return nil, fmt.Errorf("unknown readiness policy %v, please use none, count, fraction or weighted", p.policy)
+	}
+	return p, nil
+}
+
+// Weight of a peer by the first matching weight, 1 if no weight matches
+func (p *readinessPolicy) weight(labels map[string]string, node *data.Node) float64 {
+	for _, w := range p.weights {
+		if w.rule.matches(labels, node) {
+			return w.weight
+		}
+	}
+	return 1
+}
+
+// Evaluate the condition of the policy against the peers,
+// labels are the labels of this node. A fraction or score
+// without peers fails.
+func (p *readinessPolicy) evaluate(labels map[string]string, peers []*data.Node) api.ReadinessCondition {
+	ok := 0
+	var total, okWeight float64
+	for _, node := range peers {
+		weight := p.weight(labels, node)
+		total += weight
+		if node.State == NODE_OK {
+			ok++
+			okWeight += weight
+		}
+	}
+
+	switch p.policy {
+	case READINESS_COUNT:
+		return api.ReadinessCondition{
+			Name:    "min-peers",
+			Ok:      ok >= p.minPeers,
+			Message: fmt.Sprintf("%v of %v peers OK, min. %v", ok, len(peers), p.minPeers),
+		}
+	case READINESS_FRACTION:
+		fraction := 0.0
+		if len(peers) > 0 {
+			fraction = float64(ok) / float64(len(peers))
+		}
+		return api.ReadinessCondition{
+			Name:    "min-fraction",
+			Ok:      len(peers) > 0 && fraction >= p.minFraction,
+			Message: fmt.Sprintf("%.2f of %v peers OK, min. %.2f", fraction, len(peers), p.minFraction),
+		}
+	default:
+		score := 0.0
+		if total > 0 {
+			score = okWeight / total
+		}
+		return api.ReadinessCondition{
+			Name:    "min-score",
+			Ok:      total > 0 && score >= p.minScore,
+			Message: fmt.Sprintf("weighted score %.2f of %v peers OK, min. %.2f", score, len(peers), p.minScore),
+		}
+	}
+}
+
+// Get the readiness of the node by its readiness policy against
+// the current states of the peers; a paused node is not ready
+func (m *Mesh) Readiness() api.Readiness {
+	paused := api.ReadinessCondition{Name: "probing", Ok: !m.Paused(), Message: "outbound probes running"}
+	if !paused.Ok {
+		paused.Message = "outbound probes paused"
+	}
+	readiness := api.Readiness{Ready: paused.Ok, Policy: READINESS_NONE, Conditions: []api.ReadinessCondition{paused}}
+	if m.readiness == nil {
+		return readiness
+	}
+
+	condition := m.readiness.evaluate(m.setupConfig.Labels, m.peers(m.database.GetNodeList()))
+	readiness.Policy = m.readiness.policy
+	readiness.Ready = readiness.Ready && condition.Ok
+	readiness.Conditions = append(readiness.Conditions, condition)
+	return readiness
+}
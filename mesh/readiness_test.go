@@ -0,0 +1,155 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+func Test_newReadinessPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    SetupConfiguration
+		expectNil bool
+		expectErr bool
+	}{
+		{name: "none", config: SetupConfiguration{ReadinessPolicy: READINESS_NONE}, expectNil: true},
+		{name: "empty", config: SetupConfiguration{}, expectNil: true},
+		{name: "count", config: SetupConfiguration{ReadinessPolicy: READINESS_COUNT, ReadinessMinPeers: 2}},
+		{name: "negative count", config: SetupConfiguration{ReadinessPolicy: READINESS_COUNT, ReadinessMinPeers: -1}, expectErr: true},
+		{name: "fraction", config: SetupConfiguration{ReadinessPolicy: READINESS_FRACTION, ReadinessMinFraction: 0.5}},
+		{name: "fraction above 1", config: SetupConfiguration{ReadinessPolicy: READINESS_FRACTION, ReadinessMinFraction: 1.5}, expectErr: true},
+		{name: "weighted", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED, ReadinessMinScore: 0.5, ReadinessWeights: []string{"label:role=seed=5", "gw-*=0"}}},
+		{name: "weight without value", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED, ReadinessWeights: []string{"gw-*"}}, expectErr: true},
+		{name: "negative weight", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED, ReadinessWeights: []string{"gw-*=-1"}}, expectErr: true},
+		{name: "invalid rule", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED, ReadinessWeights: []string{"label:role=2"}}, expectErr: true},
+		{name: "unknown", config: SetupConfiguration{ReadinessPolicy: "quorum"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newReadinessPolicy(&tt.config)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if !tt.expectErr && (p == nil) != tt.expectNil {
+				t.Errorf("got policy %+v, expected nil %v", p, tt.expectNil)
+			}
+		})
+	}
+}
+
+func Test_Readiness(t *testing.T) {
+	nodes := []*data.Node{
+		{Id: 1, Name: "seed-1", Target: "seed-1:8081", State: NODE_OK, Labels: map[string]string{"role": "seed"}},
+		{Id: 2, Name: "edge-1", Target: "edge-1:8081", State: NODE_OK},
+		{Id: 3, Name: "edge-2", Target: "edge-2:8081", State: NODE_TIMEOUT},
+		{Id: 4, Name: "edge-3", Target: "edge-3:8081", State: NODE_DEAD},
+	}
+	tests := []struct {
+		name     string
+		config   SetupConfiguration
+		nodes    []*data.Node
+		paused   bool
+		expected bool
+	}{
+		{name: "none", config: SetupConfiguration{ReadinessPolicy: READINESS_NONE}, nodes: nodes, expected: true},
+		{name: "none paused", config: SetupConfiguration{ReadinessPolicy: READINESS_NONE}, nodes: nodes, paused: true, expected: false},
+		{name: "count met", config: SetupConfiguration{ReadinessPolicy: READINESS_COUNT, ReadinessMinPeers: 2}, nodes: nodes, expected: true},
+		{name: "count not met", config: SetupConfiguration{ReadinessPolicy: READINESS_COUNT, ReadinessMinPeers: 3}, nodes: nodes, expected: false},
+		{name: "count paused", config: SetupConfiguration{ReadinessPolicy: READINESS_COUNT, ReadinessMinPeers: 2}, nodes: nodes, paused: true, expected: false},
+		{name: "count zero without peers", config: SetupConfiguration{ReadinessPolicy: READINESS_COUNT}, expected: true},
+		{name: "fraction met", config: SetupConfiguration{ReadinessPolicy: READINESS_FRACTION, ReadinessMinFraction: 0.5}, nodes: nodes, expected: true},
+		{name: "fraction not met", config: SetupConfiguration{ReadinessPolicy: READINESS_FRACTION, ReadinessMinFraction: 0.75}, nodes: nodes, expected: false},
+		{name: "fraction without peers", config: SetupConfiguration{ReadinessPolicy: READINESS_FRACTION}, expected: false},
+		// 2 of 5 unweighted
+		{name: "weighted unweighted", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED, ReadinessMinScore: 0.6}, nodes: nodes, expected: false},
+		// (5+1) of (5+1+1+1)
+		{name: "weighted seed", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED, ReadinessMinScore: 0.6, ReadinessWeights: []string{"label:role=seed=5"}}, nodes: nodes, expected: true},
+		// 1 of (0+1+1+1), seeds do not count
+		{name: "weighted zero", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED, ReadinessMinScore: 0.5, ReadinessWeights: []string{"seed-*=0"}}, nodes: nodes, expected: false},
+		// the first matching weight wins: (5+1) of (5+1+1+1)
+		{name: "weighted first match", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED, ReadinessMinScore: 0.6, ReadinessWeights: []string{"label:role=seed=5", "seed-*=0"}}, nodes: nodes, expected: true},
+		{name: "weighted without peers", config: SetupConfiguration{ReadinessPolicy: READINESS_WEIGHTED}, expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMesh(time.Second)
+			db, err := data.NewMemDB(zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.database = db
+			db.SetNodes(tt.nodes)
+			m.readiness, err = newReadinessPolicy(&tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.paused.Store(tt.paused)
+
+			readiness := m.Readiness()
+			if readiness.Ready != tt.expected {
+				t.Errorf("got ready %v, expected %v - conditions: %+v", readiness.Ready, tt.expected, readiness.Conditions)
+			}
+			expectedConditions := 2
+			if m.readiness == nil {
+				expectedConditions = 1
+			}
+			if len(readiness.Conditions) != expectedConditions {
+				t.Errorf("got %v conditions, expected %v", len(readiness.Conditions), expectedConditions)
+			}
+		})
+	}
+}
+
+func Test_ReadinessPeering(t *testing.T) {
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.setupConfig.Labels = map[string]string{"region": "eu"}
+	db.SetNodes([]*data.Node{
+		{Id: 1, Name: "eu-1", Target: "eu-1:8081", State: NODE_OK, Labels: map[string]string{"region": "eu"}},
+		{Id: 2, Name: "us-1", Target: "us-1:8081", State: NODE_DEAD, Labels: map[string]string{"region": "us"}},
+	})
+	rule, err := ParsePeeringRule("same:region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.peeringRules = []PeeringRule{rule}
+	m.readiness, err = newReadinessPolicy(&SetupConfiguration{ReadinessPolicy: READINESS_FRACTION, ReadinessMinFraction: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// just the peers are evaluated, the dead node in us is not peered
+	if readiness := m.Readiness(); !readiness.Ready {
+		t.Errorf("Expected to be ready with all peers OK, got %+v", readiness.Conditions)
+	}
+}
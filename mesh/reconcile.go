@@ -0,0 +1,115 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+)
+
+// A sample whose view differs between two nodes.
+// A or B is nil if the sample is unknown to the node.
+type SampleDiff struct {
+	From string
+	To   string
+	Key  int64
+	A    *meshv1.Sample
+	B    *meshv1.Sample
+}
+
+func (d SampleDiff) String() string {
+	sample := fmt.Sprintf("%v -> %v %v", d.From, d.To, data.SampleName(d.Key))
+	switch {
+	case d.A == nil:
+		return fmt.Sprintf("%v: missing on a, b has %v (ts %v)", sample, d.B.Value, d.B.Ts)
+	case d.B == nil:
+		return fmt.Sprintf("%v: missing on b, a has %v (ts %v)", sample, d.A.Value, d.A.Ts)
+	default:
+		return fmt.Sprintf("%v: a has %v (ts %v), b has %v (ts %v)", sample, d.A.Value, d.A.Ts, d.B.Value, d.B.Ts)
+	}
+}
+
+// Reconcile fetches the samples of two nodes and returns the discrepancies
+// of their views. The token has to be an API token of both nodes.
+func Reconcile(setupConfig *SetupConfiguration, targetA string, targetB string, token string, timeout time.Duration) ([]SampleDiff, error) {
+	routineConfig := StandardProductionRoutineConfig()
+	// no RTT is measured for the health score
+	routineConfig.HealthWindow = 0
+	m, err := newMesh(routineConfig, setupConfig, zap.NewNop().Sugar())
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	samplesA, err := m.GetSamples(ctx, &meshv1.Node{Name: "a", Target: targetA}, token)
+	if err != nil {
+		return nil, err
+	}
+	samplesB, err := m.GetSamples(ctx, &meshv1.Node{Name: "b", Target: targetB}, token)
+	if err != nil {
+		return nil, err
+	}
+	return diffSamples(samplesA, samplesB), nil
+}
+
+// Compare two sample sets by sample id.
+// The result is sorted by from, to and key.
+func diffSamples(a []*meshv1.Sample, b []*meshv1.Sample) []SampleDiff {
+	samplesB := map[uint32]*meshv1.Sample{}
+	for _, sample := range b {
+		samplesB[GetSampleId(sample)] = sample
+	}
+
+	diffs := []SampleDiff{}
+	for _, sampleA := range a {
+		id := GetSampleId(sampleA)
+		sampleB, ok := samplesB[id]
+		delete(samplesB, id)
+		if ok && sampleA.Value == sampleB.Value && sampleA.Ts == sampleB.Ts {
+			continue
+		}
+		diffs = append(diffs, SampleDiff{From: sampleA.From, To: sampleA.To, Key: sampleA.Key, A: sampleA, B: sampleB})
+	}
+	for _, sampleB := range samplesB {
+		diffs = append(diffs, SampleDiff{From: sampleB.From, To: sampleB.To, Key: sampleB.Key, B: sampleB})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].From != diffs[j].From {
+			return diffs[i].From < diffs[j].From
+		}
+		if diffs[i].To != diffs[j].To {
+			return diffs[i].To < diffs[j].To
+		}
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs
+}
@@ -0,0 +1,66 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+)
+
+func Test_diffSamples(t *testing.T) {
+	same := &meshv1.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Ts: 1}
+	oldValue := &meshv1.Sample{From: "a", To: "c", Key: data.RTT_TOTAL, Value: "1", Ts: 1}
+	newValue := &meshv1.Sample{From: "a", To: "c", Key: data.RTT_TOTAL, Value: "2", Ts: 2}
+	onlyA := &meshv1.Sample{From: "b", To: "a", Key: data.RTT_TOTAL, Value: "1", Ts: 1}
+	onlyB := &meshv1.Sample{From: "c", To: "a", Key: data.RTT_TOTAL, Value: "1", Ts: 1}
+
+	tests := []struct {
+		name     string
+		a        []*meshv1.Sample
+		b        []*meshv1.Sample
+		expected []SampleDiff
+	}{
+		{name: "empty", expected: []SampleDiff{}},
+		{name: "in sync", a: []*meshv1.Sample{same}, b: []*meshv1.Sample{same}, expected: []SampleDiff{}},
+		{
+			name: "discrepancies",
+			a:    []*meshv1.Sample{same, oldValue, onlyA},
+			b:    []*meshv1.Sample{onlyB, newValue, same},
+			expected: []SampleDiff{
+				{From: "a", To: "c", Key: data.RTT_TOTAL, A: oldValue, B: newValue},
+				{From: "b", To: "a", Key: data.RTT_TOTAL, A: onlyA},
+				{From: "c", To: "a", Key: data.RTT_TOTAL, B: onlyB},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := deep.Equal(diffSamples(tt.a, tt.b), tt.expected); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	h "github.com/telekom/canary-bot/helper"
+	"github.com/telekom/canary-bot/metric"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Min. interval the rate file of the request log is checked for changes
+const requestLogCheckInterval = time.Second
+
+// Sample rate 0-1 of the successful inbound RPCs logged by the request log.
+// A rate loaded from a file is reloaded if the file changes, checked at
+// most every requestLogCheckInterval. The effective rate is exposed as metric.
+type requestLogRate struct {
+	path    string
+	metrics metric.Metrics
+	log     *zap.SugaredLogger
+
+	mu    sync.Mutex
+	rate  float64
+	watch *h.FileWatch
+}
+
+// Create the sample rate of the request log.
+// The rate file has to be loadable initially if set.
+func newRequestLogRate(rate float64, path string, metrics metric.Metrics, log *zap.SugaredLogger) (*requestLogRate, error) {
+	r := &requestLogRate{path: path, metrics: metrics, log: log, rate: rate}
+	if path != "" {
+		r.watch = h.NewFileWatch(requestLogCheckInterval, path)
+		r.watch.Changed(time.Now())
+		var err error
+		if r.rate, err = readRequestLogRate(path); err != nil {
+			return nil, err
+		}
+	}
+	metrics.GetRequestLogSampleRate().Set(r.rate)
+	return r, nil
+}
+
+// Validate a sample rate of the request log
+func validateRequestLogRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return errors.New("request log sample rate has to be 0-1")
+	}
+	return nil
+}
+
+// Read the sample rate of a file
+func readRequestLogRate(path string) (float64, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(string(raw)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return rate, validateRequestLogRate(rate)
+}
+
+// Get the sample rate, the file is reloaded if its modification time changed.
+// The loaded rate is kept if the file can not be loaded.
+func (r *requestLogRate) load(now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.path == "" || !r.watch.Changed(now) {
+		return r.rate
+	}
+	rate, err := readRequestLogRate(r.path)
+	if err != nil {
+		r.log.Warnw("Could not reload request log sample rate, keeping the loaded one", "path", r.path, "rate", r.rate, "error", err)
+		return r.rate
+	}
+	if rate != r.rate {
+		r.log.Infow("Reloaded request log sample rate", "path", r.path, "rate", rate)
+		r.rate = rate
+		r.metrics.GetRequestLogSampleRate().Set(rate)
+	}
+	return r.rate
+}
+
+// Check if a successful RPC is logged by the sample rate, never without a request log
+func (r *requestLogRate) sampled(now time.Time) bool {
+	if r == nil {
+		return false
+	}
+	rate := r.load(now)
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+// Log fields of an inbound RPC: method, peer address, duration & status code
+func requestLogFields(ctx context.Context, method string, start time.Time, err error) []interface{} {
+	fields := []interface{}{
+		"method", method,
+		"duration", time.Since(start).String(),
+		"code", status.Code(err).String(),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, "peer", p.Addr.String())
+	}
+	return fields
+}
+
+// Server interceptor logging the successful unary RPCs by the sample rate,
+// the failed RPCs are always logged by the deadlineInterceptor
+func (m *Mesh) requestLogUnaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	if err == nil && m.requestLog.sampled(start) {
+		m.logger.Named("server").Infow("RPC", requestLogFields(ctx, info.FullMethod, start, nil)...)
+	}
+	return resp, err
+}
+
+// Server interceptor logging the successful streams by the sample rate
+// and all failed streams
+func (m *Mesh) requestLogStreamInterceptor(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	if err != nil {
+		m.logger.Named("server").Infow("RPC failed", rpcFailureFields(ss.Context(), info.FullMethod, start, err)...)
+	} else if m.requestLog.sampled(start) {
+		m.logger.Named("server").Infow("RPC", requestLogFields(ss.Context(), info.FullMethod, start, nil)...)
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_requestLogRateReload(t *testing.T) {
+	m := testMesh(time.Second)
+	path := filepath.Join(t.TempDir(), "rate")
+	if err := os.WriteFile(path, []byte("0.5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRequestLogRate(0, path, m.metrics, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if rate := r.load(now); rate != 0.5 {
+		t.Fatalf("Expected the rate of the file, got %v", rate)
+	}
+	if rate := gatherValue(t, m.metrics, "request_log_sample_rate"); rate != 0.5 {
+		t.Errorf("Expected the effective rate as metric, got %v", rate)
+	}
+
+	// a changed file is reloaded after the check interval
+	if err := os.WriteFile(path, []byte("1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, now, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if rate := r.load(now.Add(requestLogCheckInterval)); rate != 1 {
+		t.Errorf("Expected the reloaded rate, got %v", rate)
+	}
+
+	// an invalid rate keeps the loaded one
+	if err := os.WriteFile(path, []byte("2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, now, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if rate := r.load(now.Add(2 * requestLogCheckInterval)); rate != 1 {
+		t.Errorf("Expected the loaded rate to be kept, got %v", rate)
+	}
+	if rate := gatherValue(t, m.metrics, "request_log_sample_rate"); rate != 1 {
+		t.Errorf("Expected the effective rate as metric, got %v", rate)
+	}
+
+	// the rate file has to be loadable initially
+	if _, err := newRequestLogRate(0, filepath.Join(t.TempDir(), "missing"), m.metrics, zap.NewNop().Sugar()); err == nil {
+		t.Error("Expected an error of a missing rate file")
+	}
+}
+
+func Test_requestLogRateMissingFile(t *testing.T) {
+	m, logs := testMeshObserved(time.Second)
+	path := filepath.Join(t.TempDir(), "rate")
+	if err := os.WriteFile(path, []byte("0.5"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRequestLogRate(0, path, m.metrics, m.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a removed file keeps the loaded rate and is warned about once
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		if rate := r.load(now.Add(time.Duration(i) * requestLogCheckInterval)); rate != 0.5 {
+			t.Errorf("Expected the loaded rate to be kept, got %v", rate)
+		}
+	}
+	if warnings := logs.FilterMessageSnippet("Could not reload").Len(); warnings != 1 {
+		t.Errorf("Expected 1 warning of the missing file, got %v", warnings)
+	}
+}
+
+func Test_requestLogInterceptors(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		err      error
+		expected string
+		// field of the duration, failed streams are logged like failed unary RPCs
+		duration string
+	}{
+		{name: "success not sampled", rate: 0},
+		{name: "success sampled", rate: 1, expected: "RPC", duration: "duration"},
+		{name: "failed stream", rate: 0, err: status.Error(codes.Unavailable, "unavailable"), expected: "RPC failed", duration: "elapsed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, logs := testMeshObserved(time.Second)
+			var err error
+			m.requestLog, err = newRequestLogRate(tt.rate, "", m.metrics, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info := &grpc.StreamServerInfo{FullMethod: "/mesh.v1.MeshService/SyncSamples"}
+			handler := func(srv interface{}, stream grpc.ServerStream) error { return tt.err }
+			if err := m.requestLogStreamInterceptor(nil, &testServerStream{ctx: context.Background()}, info, handler); err != tt.err {
+				t.Fatalf("Expected the error of the handler, got %v", err)
+			}
+			if tt.expected == "" {
+				if logs.Len() != 0 {
+					t.Errorf("Expected no log, got %v", logs.All())
+				}
+				return
+			}
+			entries := logs.FilterMessage(tt.expected).All()
+			if len(entries) != 1 {
+				t.Fatalf("Expected 1 %q log, got %v", tt.expected, logs.All())
+			}
+			fields := entries[0].ContextMap()
+			if fields["method"] != info.FullMethod || fields[tt.duration] == nil || fields["code"] == nil {
+				t.Errorf("Unexpected log fields %v", fields)
+			}
+		})
+	}
+
+	// failed unary RPCs are logged by the deadline interceptor only
+	m, logs := testMeshObserved(time.Second)
+	m.requestLog, _ = newRequestLogRate(1, "", m.metrics, zap.NewNop().Sugar())
+	failed := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+	if _, err := m.requestLogUnaryInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/mesh.v1.MeshService/Ping"}, failed); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if logs.Len() != 0 {
+		t.Errorf("Expected no log of the failed unary RPC, got %v", logs.All())
+	}
+}
+
+// Server stream with a context only
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,85 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Resync pulls all samples of a known node and merges them with the known samples.
+// Samples newer than the known samples overwrite them, independent of the digest.
+// A manual recovery if the views drifted, the automatic sync is not affected.
+// The samples are pulled by the mesh token if set, else the API token of the
+// request is forwarded, so it has to be an API token of the peer as well.
+func (m *Mesh) Resync(ctx context.Context, peer string, token string) (*meshv1.ResyncResponse, error) {
+	dbnode, ok := m.database.GetNodeByName(peer)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "node %v not found", peer)
+	}
+	node := dbnode.Convert()
+
+	if m.meshToken != nil {
+		token = ""
+	}
+	samples, err := m.GetSamples(ctx, node, token)
+	if err != nil {
+		m.logger.Named("sample-routine").Warnw("Resync failed", "node", peer, "error", err)
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	added, updated, unchanged, rejected := storeSamples(&m.database, m.metrics, m.setupConfig.sampleFilter(), samples)
+	m.logger.Named("sample-routine").Infow("Resynced samples", "node", peer, "added", added, "updated", updated, "unchanged", unchanged, "rejected", rejected)
+	return &meshv1.ResyncResponse{Added: added, Updated: updated, Unchanged: unchanged, Rejected: rejected}, nil
+}
+
+// ResyncNode requests a node to resync its samples with a known node (peer).
+// The token has to be an API token of the node, and of the peer without a mesh token.
+func ResyncNode(setupConfig *SetupConfiguration, target string, peer string, token string, timeout time.Duration) (*meshv1.ResyncResponse, error) {
+	routineConfig := StandardProductionRoutineConfig()
+	routineConfig.RequestTimeout = timeout
+	// no RTT is measured for the health score
+	routineConfig.HealthWindow = 0
+	m, err := newMesh(routineConfig, setupConfig, zap.NewNop().Sugar())
+	if err != nil {
+		return nil, err
+	}
+
+	node := &meshv1.Node{Name: "node", Target: target}
+	c, err := m.initClient(node)
+	if err != nil {
+		return nil, fmt.Errorf("resync %v: %w", target, err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	res, err := c.client.Resync(ctx, &meshv1.ResyncRequest{Peer: peer})
+	if err != nil {
+		return nil, fmt.Errorf("resync %v: %w", target, classifyError(err))
+	}
+	return res, nil
+}
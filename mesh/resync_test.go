@@ -0,0 +1,151 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_Resync(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(grpcServer, &MeshServer{metrics: metric.InitMetrics(), log: zap.NewNop().Sugar(), data: &remote, tokens: []string{"secret"}})
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+	node := &meshv1.Node{Name: "remote", Target: lis.Addr().String()}
+
+	m := testMesh(time.Second)
+	m.database, err = data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database.SetNode(data.Convert(node, NODE_OK))
+
+	// stale, newer & equal local samples
+	stale := &data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Ts: 1}
+	m.database.SetSample(stale)
+	remote.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "2", Ts: 2})
+	m.database.SetSample(&data.Sample{From: "a", To: "c", Key: data.RTT_TOTAL, Value: "3", Ts: 3})
+	remote.SetSample(&data.Sample{From: "a", To: "c", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	m.database.SetSample(&data.Sample{From: "a", To: "d", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	remote.SetSample(&data.Sample{From: "a", To: "d", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	added := &data.Sample{From: "a", To: "e", Key: data.RTT_TOTAL, Value: "1", Ts: 1}
+	remote.SetSample(added)
+
+	res, err := m.Resync(context.Background(), "remote", "secret")
+	if err != nil {
+		t.Fatalf("could not resync: %v", err)
+	}
+	if res.Added != 1 || res.Updated != 1 || res.Unchanged != 1 || res.Rejected != 1 {
+		t.Errorf("Expected 1 added, 1 updated, 1 unchanged & 1 rejected sample, got %+v", res)
+	}
+	if sample := m.database.GetSample(stale.Id); sample.Value != "2" {
+		t.Errorf("Expected the stale sample to be overwritten, got %+v", sample)
+	}
+	if sample := m.database.GetSample(added.Id); sample.Ts != 1 {
+		t.Errorf("Expected the unknown sample to be added, got %+v", sample)
+	}
+
+	if _, err := m.Resync(context.Background(), "unknown", "secret"); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+	if _, err := m.Resync(context.Background(), "remote", "wrong"); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected unavailable error, got %v", err)
+	}
+}
+
+func Test_ResyncMeshToken(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := newMeshToken("mesh-secret", "", zap.NewNop().Sugar())
+	grpcServer := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(grpcServer, &MeshServer{metrics: metric.InitMetrics(), log: zap.NewNop().Sugar(), data: &remote, tokens: []string{"remote-secret"}, meshToken: token})
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+	node := &meshv1.Node{Name: "remote", Target: lis.Addr().String()}
+	remote.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+
+	// the API token of the request is not forwarded, the mesh token authorizes the pull
+	m := testMesh(time.Second)
+	m.meshToken = token
+	m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+	m.database.SetNode(data.Convert(node, NODE_OK))
+	res, err := m.Resync(context.Background(), "remote", "local-secret")
+	if err != nil || res.Added != 1 {
+		t.Errorf("Expected the sample to be pulled by the mesh token, got %+v, error %v", res, err)
+	}
+
+	m = testMesh(time.Second)
+	m.meshToken, _ = newMeshToken("wrong", "", zap.NewNop().Sugar())
+	m.database, _ = data.NewMemDB(zap.NewNop().Sugar())
+	m.database.SetNode(data.Convert(node, NODE_OK))
+	if _, err := m.Resync(context.Background(), "remote", "remote-secret"); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected unavailable error with a wrong mesh token, got %v", err)
+	}
+}
+
+func Test_ResyncAuth(t *testing.T) {
+	var token string
+	s := &MeshServer{log: zap.NewNop().Sugar(), tokens: []string{"secret"}, resync: func(ctx context.Context, peer string, t string) (*meshv1.ResyncResponse, error) {
+		token = t
+		return &meshv1.ResyncResponse{}, nil
+	}}
+
+	if _, err := s.Resync(context.Background(), &meshv1.ResyncRequest{Peer: "a"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected unauthenticated error, got %v", err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+	if _, err := s.Resync(ctx, &meshv1.ResyncRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected invalid argument error, got %v", err)
+	}
+	if _, err := s.Resync(ctx, &meshv1.ResyncRequest{Peer: "a"}); err != nil {
+		t.Fatalf("could not resync: %v", err)
+	}
+	if token != "secret" {
+		t.Errorf("Expected the token of the request to be forwarded, got %q", token)
+	}
+}
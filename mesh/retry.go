@@ -0,0 +1,139 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// Retry budget shared by the ping, push sample & join routines, token-bucket style.
+// Every request deposits Ratio tokens, every retry withdraws one token.
+// MinPerSecond tokens are refilled per second, so a few retries are always allowed.
+// If failures are widespread, retries are throttled instead of amplifying the load.
+type RetryBudgetConfiguration struct {
+	// Retries per request, 0 disables the budget
+	Ratio float64
+	// Tokens refilled per second independent of the requests
+	MinPerSecond float64
+	// Max. tokens of the budget, the budget starts full
+	Max float64
+}
+
+// Validate the budget, an enabled budget has to allow at least one retry
+func (c RetryBudgetConfiguration) validate() error {
+	if c.Ratio < 0 || c.MinPerSecond < 0 || c.Max < 0 {
+		return errors.New("retry budget values have to be positive")
+	}
+	if c.Ratio > 0 && c.Max < 1 {
+		return errors.New("retry budget max. has to be at least 1 retry")
+	}
+	return nil
+}
+
+type retryBudget struct {
+	mu     sync.Mutex
+	config RetryBudgetConfiguration
+	tokens float64
+	last   time.Time
+}
+
+// Create a full retry budget, nil if the budget is disabled
+func newRetryBudget(config RetryBudgetConfiguration, now time.Time) *retryBudget {
+	if config.Ratio <= 0 {
+		return nil
+	}
+	return &retryBudget{config: config, tokens: config.Max, last: now}
+}
+
+// Refill the tokens per second since the last refill, the lock has to be held
+func (b *retryBudget) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.config.Max, b.tokens+elapsed.Seconds()*b.config.MinPerSecond)
+		b.last = now
+	}
+}
+
+// Deposit the tokens of a request
+func (b *retryBudget) request(now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	b.tokens = math.Min(b.config.Max, b.tokens+b.config.Ratio)
+}
+
+// Withdraw a token for a retry, false if the budget is exhausted.
+// A disabled budget allows all retries.
+func (b *retryBudget) retry(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Remaining tokens of the budget, retries are allowed from 1 token on
+func (b *retryBudget) remaining(now time.Time) float64 {
+	if b == nil {
+		return math.Inf(1)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens
+}
+
+// Count a request of a routine in the retry budget
+func (m *Mesh) countRequest() {
+	m.retryBudget.request(time.Now())
+	m.setRetryBudgetMetric()
+}
+
+// Error of a retry not sent due to the exhausted retry budget
+var errRetryBudget = errors.New("retry budget exhausted")
+
+// Check if a routine can retry, a throttled retry is counted
+func (m *Mesh) allowRetry(routine string) bool {
+	allowed := m.retryBudget.retry(time.Now())
+	if !allowed {
+		m.metrics.GetRetriesThrottled().WithLabelValues(routine).Inc()
+	}
+	m.setRetryBudgetMetric()
+	return allowed
+}
+
+func (m *Mesh) setRetryBudgetMetric() {
+	if m.retryBudget != nil {
+		m.metrics.GetRetryBudget().Set(m.retryBudget.remaining(time.Now()))
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_retryBudget(t *testing.T) {
+	now := time.Now()
+	budget := newRetryBudget(RetryBudgetConfiguration{Ratio: 0.5, MinPerSecond: 0, Max: 2}, now)
+
+	// full budget allows Max retries
+	for i := 0; i < 2; i++ {
+		if !budget.retry(now) {
+			t.Fatalf("retry %v throttled, expected a full budget", i)
+		}
+	}
+	if budget.retry(now) {
+		t.Fatal("retry allowed, expected an exhausted budget")
+	}
+
+	// 2 requests deposit one retry
+	budget.request(now)
+	if budget.retry(now) {
+		t.Fatal("retry allowed after one request, expected the ratio to be applied")
+	}
+	budget.request(now)
+	if !budget.retry(now) {
+		t.Fatal("retry throttled after two requests")
+	}
+
+	// the budget is capped
+	for i := 0; i < 10; i++ {
+		budget.request(now)
+	}
+	if remaining := budget.remaining(now); remaining != 2 {
+		t.Errorf("got %v remaining tokens, expected max. 2", remaining)
+	}
+}
+
+func Test_retryBudgetRefill(t *testing.T) {
+	now := time.Now()
+	budget := newRetryBudget(RetryBudgetConfiguration{Ratio: 0.1, MinPerSecond: 1, Max: 1}, now)
+	if !budget.retry(now) || budget.retry(now) {
+		t.Fatal("expected exactly one retry of a full budget")
+	}
+	if !budget.retry(now.Add(time.Second)) {
+		t.Error("retry throttled, expected a refill after one second")
+	}
+}
+
+func Test_retryBudgetDisabled(t *testing.T) {
+	budget := newRetryBudget(RetryBudgetConfiguration{}, time.Now())
+	if budget != nil {
+		t.Fatal("expected no budget with a ratio of 0")
+	}
+	budget.request(time.Now())
+	if !budget.retry(time.Now()) {
+		t.Error("retry throttled by a disabled budget")
+	}
+}
+
+func Test_RetryBudgetConfigurationValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  RetryBudgetConfiguration
+		wantErr bool
+	}{
+		{name: "standard", config: StandardProductionRoutineConfig().RetryBudget, wantErr: false},
+		{name: "disabled", config: RetryBudgetConfiguration{}, wantErr: false},
+		{name: "negative", config: RetryBudgetConfiguration{Ratio: 0.2, MinPerSecond: -1, Max: 10}, wantErr: true},
+		{name: "no retry", config: RetryBudgetConfiguration{Ratio: 0.2, Max: 0.5}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
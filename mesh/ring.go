@@ -0,0 +1,91 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/telekom/canary-bot/data"
+	h "github.com/telekom/canary-bot/helper"
+)
+
+// Node selection modes of the RTT measurement
+const (
+	RTT_SELECTION_RANDOM          = "random"
+	RTT_SELECTION_CONSISTENT_HASH = "consistent-hash"
+)
+
+// Select a peer on a consistent-hash ring of all nodes including this node.
+// Every round the next successor of this node on the ring is selected,
+// so every node measures all peers in turn. If all nodes are in the same
+// round, every peer is measured by exactly one node.
+// The ring will rebalance if nodes join or leave the mesh.
+func selectRingNode(nodes []*data.Node, self string, round uint64) *data.Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	type ringNode struct {
+		hash uint32
+		node *data.Node
+	}
+	selfHash, _ := h.Hash(self)
+	ring := []ringNode{{hash: selfHash}}
+	for _, node := range nodes {
+		hash, _ := h.Hash(node.Name)
+		ring = append(ring, ringNode{hash: hash, node: node})
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash == ring[j].hash {
+			// nil node (self) first on hash collisions
+			return ring[i].node == nil
+		}
+		return ring[i].hash < ring[j].hash
+	})
+
+	pos := 0
+	for i, r := range ring {
+		if r.node == nil {
+			pos = i
+			break
+		}
+	}
+
+	offset := 1 + int(round%uint64(len(ring)-1))
+	return ring[(pos+offset)%len(ring)].node
+}
+
+// Get the node for the next RTT measurement by the configured selection mode.
+// Nodes with an overridden probe interval are skipped.
+func (m *Mesh) rttNode() *data.Node {
+	nodes := m.rttNodes()
+	if m.setupConfig.RttSelection == RTT_SELECTION_CONSISTENT_HASH {
+		round := m.rttRound.Add(1) - 1
+		return selectRingNode(nodes, m.name.get(), round)
+	}
+
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[rand.Intn(len(nodes))]
+}
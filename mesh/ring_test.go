@@ -0,0 +1,83 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+func ringTestNodes(amount int) []*data.Node {
+	var nodes []*data.Node
+	for i := 0; i < amount; i++ {
+		nodes = append(nodes, &data.Node{Name: "node_" + strconv.Itoa(i)})
+	}
+	return nodes
+}
+
+func withoutNode(nodes []*data.Node, name string) []*data.Node {
+	var result []*data.Node
+	for _, node := range nodes {
+		if node.Name != name {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+func Test_selectRingNode(t *testing.T) {
+	if node := selectRingNode(nil, "self", 0); node != nil {
+		t.Errorf("node selected without peers: %+v", node)
+	}
+
+	nodes := ringTestNodes(5)
+	for round := uint64(0); round < uint64(len(nodes)-1); round++ {
+		measured := map[string]int{}
+		for _, self := range nodes {
+			peer := selectRingNode(withoutNode(nodes, self.Name), self.Name, round)
+			if peer.Name == self.Name {
+				t.Errorf("node %v selected itself", self.Name)
+			}
+			measured[peer.Name]++
+		}
+		// every node is measured by exactly one node per round
+		for _, node := range nodes {
+			if measured[node.Name] != 1 {
+				t.Errorf("round %v: node %v measured %v times, expected once", round, node.Name, measured[node.Name])
+			}
+		}
+	}
+}
+
+func Test_selectRingNodeCoversAllPeers(t *testing.T) {
+	nodes := ringTestNodes(6)
+	peers := withoutNode(nodes, "node_0")
+	selected := map[string]bool{}
+	for round := uint64(0); round < uint64(len(peers)); round++ {
+		selected[selectRingNode(peers, "node_0", round).Name] = true
+	}
+	if len(selected) != len(peers) {
+		t.Errorf("not all peers selected: %v of %v", len(selected), len(peers))
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Routines with a probe schedule besides the external probes
+const (
+	SCHEDULE_RTT        = PROBE_RTT
+	SCHEDULE_THROUGHPUT = "throughput"
+)
+
+// Schedule window of a routine or probe by a cron expression.
+// A probe runs in its interval within the minutes matched by the expression,
+// e.g. */1 9-16 * * 1-5 for every weekday 9-17; it is skipped outside.
+type ProbeSchedule struct {
+	// Routine (rtt, throughput), probe type (e.g. http) or probe (TYPE://TARGET)
+	Key string
+	// Standard cron expression (minute hour day month weekday),
+	// with an optional CRON_TZ=ZONE prefix
+	Expression string
+	schedule   cron.Schedule
+}
+
+// Parse a probe schedule in the format KEY=CRON, e.g. http=* 9-16 * * 1-5
+// or http://example.com/health=CRON_TZ=Europe/Berlin * 9-16 * * 1-5.
+// The key ends at the first = followed by a valid cron expression,
+// so the key may contain = like the query of an URL.
+func ParseProbeSchedule(schedule string) (ProbeSchedule, error) {
+	var s ProbeSchedule
+	var parseErr error
+	for i := strings.Index(schedule, "="); i >= 0; {
+		key, expression := schedule[:i], strings.TrimSpace(schedule[i+1:])
+		if key != "" && !strings.HasPrefix(expression, "@every") {
+			parsed, err := cron.ParseStandard(expression)
+			if err == nil {
+				return ProbeSchedule{Key: key, Expression: expression, schedule: parsed}, nil
+			}
+			parseErr = err
+		}
+		next := strings.Index(schedule[i+1:], "=")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	if parseErr != nil {
+		return s, fmt.Errorf("invalid cron expression of probe schedule %v: %w", schedule, parseErr)
+	}
+	return s, fmt.Errorf("invalid probe schedule %v, format: KEY=CRON", schedule)
+}
+
+// Parse the probe schedules of the setup configuration,
+// the keys have to be a routine, a probe type or a configured probe
+func parseProbeSchedules(setupConfig *SetupConfiguration) ([]ProbeSchedule, error) {
+	keys := map[string]bool{SCHEDULE_RTT: true, SCHEDULE_THROUGHPUT: true}
+	for probeType := range probeSampleKeys {
+		keys[probeType] = true
+	}
+	for _, p := range setupConfig.Probes {
+		if probe, err := ParseProbe(p, time.Second); err == nil {
+			keys[probe.name] = true
+		}
+	}
+
+	var schedules []ProbeSchedule
+	for _, s := range setupConfig.ProbeSchedules {
+		schedule, err := ParseProbeSchedule(s)
+		if err != nil {
+			return nil, err
+		}
+		if !keys[schedule.Key] {
+			return nil, fmt.Errorf("unknown key %v of probe schedule, use rtt, throughput, a probe type or a probe TYPE://TARGET", schedule.Key)
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// Check if the minute of the time is matched by the cron expression
+func (s ProbeSchedule) active(t time.Time) bool {
+	minute := t.Truncate(time.Minute)
+	return s.schedule.Next(minute.Add(-time.Second)).Equal(minute)
+}
+
+// Check if a routine or probe is within its schedule, the first key with a
+// schedule decides, e.g. the probe before its type. Unscheduled keys are always active.
+func (m *Mesh) scheduled(now time.Time, keys ...string) bool {
+	for _, key := range keys {
+		for _, s := range m.probeSchedules {
+			if s.Key == key {
+				return s.active(now)
+			}
+		}
+	}
+	return true
+}
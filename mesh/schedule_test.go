@@ -0,0 +1,105 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ParseProbeSchedule(t *testing.T) {
+	tests := []struct {
+		name       string
+		schedule   string
+		key        string
+		expression string
+		expectErr  bool
+	}{
+		{name: "probe type", schedule: "http=* 9-16 * * 1-5", key: "http", expression: "* 9-16 * * 1-5"},
+		{name: "time zone", schedule: "rtt=CRON_TZ=Europe/Berlin 0 2 * * *", key: "rtt", expression: "CRON_TZ=Europe/Berlin 0 2 * * *"},
+		{name: "probe with query", schedule: "http://example.com/health?a=b=*/5 * * * *", key: "http://example.com/health?a=b", expression: "*/5 * * * *"},
+		{name: "no expression", schedule: "http", expectErr: true},
+		{name: "no key", schedule: "=* * * * *", expectErr: true},
+		{name: "invalid expression", schedule: "http=* 25 * * *", expectErr: true},
+		{name: "every", schedule: "http=@every 1m", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseProbeSchedule(tt.schedule)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("got error %v, expected error %v", err, tt.expectErr)
+			}
+			if !tt.expectErr && (s.Key != tt.key || s.Expression != tt.expression) {
+				t.Errorf("got schedule %+v, expected key %v and expression %v", s, tt.key, tt.expression)
+			}
+		})
+	}
+}
+
+func Test_scheduled(t *testing.T) {
+	m := testMesh(time.Second)
+	for _, s := range []string{"http=* 9-16 * * 1-5", "http://example.com=* * * * *", "rtt=0-4 2 * * *"} {
+		schedule, err := ParseProbeSchedule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.probeSchedules = append(m.probeSchedules, schedule)
+	}
+
+	// Wednesday
+	day := time.Date(2023, 3, 22, 0, 0, 0, 0, time.Local)
+	if !m.scheduled(day.Add(9*time.Hour+30*time.Second), "http://other.com", PROBE_HTTP) {
+		t.Error("Expected the http probe to be scheduled at 9:00:30")
+	}
+	if m.scheduled(day.Add(17*time.Hour), "http://other.com", PROBE_HTTP) {
+		t.Error("Expected the http probe to be skipped at 17:00")
+	}
+	if m.scheduled(day.Add(4*24*time.Hour+10*time.Hour), "http://other.com", PROBE_HTTP) {
+		t.Error("Expected the http probe to be skipped on sunday")
+	}
+	// the probe schedule wins over the probe type
+	if !m.scheduled(day.Add(17*time.Hour), "http://example.com", PROBE_HTTP) {
+		t.Error("Expected the schedule of the probe to win")
+	}
+	if !m.scheduled(day.Add(2*time.Hour+4*time.Minute+59*time.Second), SCHEDULE_RTT) || m.scheduled(day.Add(2*time.Hour+5*time.Minute), SCHEDULE_RTT) {
+		t.Error("Expected the rtt measurement to be scheduled from 2:00 to 2:04")
+	}
+	// unscheduled routines always run
+	if !m.scheduled(day, SCHEDULE_THROUGHPUT) {
+		t.Error("Expected the throughput probes to run without schedule")
+	}
+}
+
+func Test_parseProbeSchedules(t *testing.T) {
+	setupConfig := &SetupConfiguration{Probes: []string{"tcp://db:5432#5s"}}
+	for _, s := range []string{"rtt=* * * * *", "throughput=* * * * *", "dns=* * * * *", "tcp://db:5432=* * * * *"} {
+		setupConfig.ProbeSchedules = []string{s}
+		if _, err := parseProbeSchedules(setupConfig); err != nil {
+			t.Errorf("Expected schedule %v to be valid, got %v", s, err)
+		}
+	}
+	setupConfig.ProbeSchedules = []string{"tcp://other:5432=* * * * *"}
+	if _, err := parseProbeSchedules(setupConfig); err == nil {
+		t.Error("Expected an error for a schedule of an unknown probe")
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Transport credentials negotiating the security level of a peer on every
+// handshake. TLS is tried first, a peer not speaking TLS is redialed insecure
+// just if the fallback is allowed. Certificate errors never fall back.
+type negotiatingCredentials struct {
+	credentials.TransportCredentials
+	fallback bool
+	dialer   *socketDialer
+	log      *zap.SugaredLogger
+	// called with the security level of each established connection
+	onLevel func(level string)
+}
+
+func (c *negotiatingCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err == nil {
+		c.onLevel(metric.SECURITY_TLS)
+		return conn, info, nil
+	}
+	if !c.fallback || !plaintextPeer(err) {
+		return nil, nil, err
+	}
+
+	// the connection is consumed by the failed handshake
+	c.log.Warnw("Peer does not speak TLS - falling back to INSECURE connection", "peer", authority, "error", err)
+	addr := rawConn.RemoteAddr()
+	rawConn.Close()
+	plainConn, dialErr := c.redial(ctx, addr)
+	if dialErr != nil {
+		return nil, nil, dialErr
+	}
+	conn, info, err = insecure.NewCredentials().ClientHandshake(ctx, authority, plainConn)
+	if err != nil {
+		plainConn.Close()
+		return nil, nil, err
+	}
+	c.onLevel(metric.SECURITY_INSECURE)
+	return conn, downgradedAuthInfo{info}, nil
+}
+
+// Auth info of a connection downgraded by the TLS fallback. An on-path attacker
+// can force the downgrade by resetting the handshake, so no secrets are sent.
+type downgradedAuthInfo struct {
+	credentials.AuthInfo
+}
+
+// Check if the connection of an RPC was downgraded by the TLS fallback
+func downgraded(ctx context.Context) bool {
+	info, ok := credentials.RequestInfoFromContext(ctx)
+	if !ok {
+		return false
+	}
+	_, ok = info.AuthInfo.(downgradedAuthInfo)
+	return ok
+}
+
+// Dial the address of a failed handshake again, unix domain sockets are not marked
+func (c *negotiatingCredentials) redial(ctx context.Context, addr net.Addr) (net.Conn, error) {
+	if addr.Network() == "unix" {
+		var unixDialer net.Dialer
+		return unixDialer.DialContext(ctx, "unix", addr.String())
+	}
+	return c.dialer.DialContext(ctx, "tcp", addr.String())
+}
+
+func (c *negotiatingCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	clone.TransportCredentials = c.TransportCredentials.Clone()
+	return &clone
+}
+
+// Check if a TLS handshake failed because the peer speaks plaintext:
+// the peer answers with a non-TLS record or closes the connection
+// on the unexpected client hello.
+func plaintextPeer(err error) bool {
+	var recordErr tls.RecordHeaderError
+	return errors.As(err, &recordErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// Get the transport credentials of the connections to a peer.
+// The security level of each connection is exposed per node.
+func (m *Mesh) peerCredentials(log *zap.SugaredLogger, to *meshv1.Node, trafficType string) (credentials.TransportCredentials, error) {
+	creds, err := m.clientCredentials(log)
+	if err != nil {
+		return nil, err
+	}
+	if creds.Info().SecurityProtocol != "tls" {
+		m.setConnectionSecurity(to, metric.SECURITY_INSECURE)
+		return creds, nil
+	}
+	return &negotiatingCredentials{
+		TransportCredentials: creds,
+		fallback:             m.setupConfig.TLSFallback,
+		dialer:               m.dialer(trafficType),
+		log:                  log,
+		onLevel: func(level string) {
+			m.setConnectionSecurity(to, level)
+		},
+	}, nil
+}
+
+// Set the security level of the connection to a node, one series per node
+// is exposed with the used level
+func (m *Mesh) setConnectionSecurity(to *meshv1.Node, level string) {
+	node := to.Name
+	if node == "" {
+		// name of the node is not known before joining
+		node = to.Target
+	} else {
+		m.deleteConnectionSecurityOf(to.Target)
+	}
+	for _, l := range []string{metric.SECURITY_TLS, metric.SECURITY_INSECURE} {
+		if l != level {
+			m.metrics.GetConnectionSecurity().DeleteLabelValues(node, l)
+		}
+	}
+	m.metrics.GetConnectionSecurity().WithLabelValues(node, level).Set(1)
+}
+
+// Delete the connection security series of a node, e.g. after it left the mesh
+func (m *Mesh) deleteConnectionSecurity(to *meshv1.Node) {
+	m.deleteConnectionSecurityOf(to.Name)
+	m.deleteConnectionSecurityOf(to.Target)
+}
+
+func (m *Mesh) deleteConnectionSecurityOf(node string) {
+	if node == "" {
+		return
+	}
+	for _, l := range []string{metric.SECURITY_TLS, metric.SECURITY_INSECURE} {
+		m.metrics.GetConnectionSecurity().DeleteLabelValues(node, l)
+	}
+}
@@ -0,0 +1,304 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Create a CA and a server cert for localhost signed by the CA.
+// The CA cert is written to a file, the path is returned.
+func newTestCert(t *testing.T) (string, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	serverDer, err := x509.CreateCertificate(rand.Reader, serverTemplate, caTemplate, &serverKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caPath, tls.Certificate{Certificate: [][]byte{serverDer}, PrivateKey: serverKey}
+}
+
+// Start a mesh server without any implemented RPC, TLS if a cert is given
+func startSecurityTestServer(t *testing.T, cert *tls.Certificate) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var opts []grpc.ServerOption
+	if cert != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{*cert}, MinVersion: tls.VersionTLS12})))
+	}
+	server := grpc.NewServer(opts...)
+	meshv1.RegisterMeshServiceServer(server, &meshv1.UnimplementedMeshServiceServer{})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return "localhost:" + strconv.Itoa(lis.Addr().(*net.TCPAddr).Port)
+}
+
+func Test_peerCredentials(t *testing.T) {
+	caPath, cert := newTestCert(t)
+	otherCaPath, _ := newTestCert(t)
+
+	tests := []struct {
+		name     string
+		tls      bool
+		caPath   string
+		fallback bool
+		token    bool
+		code     codes.Code
+		level    string
+	}{
+		{name: "TLS peer", tls: true, caPath: caPath, code: codes.Unimplemented, level: metric.SECURITY_TLS},
+		{name: "TLS peer with fallback", tls: true, caPath: caPath, fallback: true, code: codes.Unimplemented, level: metric.SECURITY_TLS},
+		{name: "plaintext peer", caPath: caPath, code: codes.Unavailable},
+		{name: "plaintext peer with fallback", caPath: caPath, fallback: true, code: codes.Unimplemented, level: metric.SECURITY_INSECURE},
+		{name: "TLS peer with token", tls: true, caPath: caPath, token: true, code: codes.Unimplemented, level: metric.SECURITY_TLS},
+		// the token is not sent on the downgraded connection
+		{name: "plaintext peer with fallback & token", caPath: caPath, fallback: true, token: true, code: codes.Unauthenticated, level: metric.SECURITY_INSECURE},
+		{name: "untrusted peer with fallback", tls: true, caPath: otherCaPath, fallback: true, code: codes.Unavailable},
+		{name: "no TLS configured", code: codes.Unimplemented, level: metric.SECURITY_INSECURE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var serverCert *tls.Certificate
+			if tt.tls {
+				serverCert = &cert
+			}
+			target := startSecurityTestServer(t, serverCert)
+
+			m := testMesh(time.Second)
+			if tt.caPath != "" {
+				m.setupConfig.CaCertPath = []string{tt.caPath}
+			}
+			m.setupConfig.TLSFallback = tt.fallback
+			if tt.token {
+				m.meshToken, _ = newMeshToken("secret", "", zap.NewNop().Sugar())
+			}
+			node := &meshv1.Node{Name: "peer", Target: target}
+			c, err := m.initClient(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.closeClient(node)
+
+			_, err = c.client.Rtt(context.Background(), &meshv1.RttRequest{})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("Expected code %v, got %v", tt.code, err)
+			}
+			if tt.level == "" {
+				return
+			}
+			if levels := gatherValues(t, m.metrics, "connection_security", "node", "peer", "level", tt.level); len(levels) != 1 || levels[0] != 1 {
+				t.Errorf("Expected security level %v to be set, got %v", tt.level, levels)
+			}
+		})
+	}
+}
+
+func Test_ServerNameOverride(t *testing.T) {
+	caPath, cert := newTestCert(t)
+	// the cert is issued for localhost, not for the IP target
+	_, port, _ := net.SplitHostPort(startSecurityTestServer(t, &cert))
+	target := net.JoinHostPort("127.0.0.1", port)
+
+	tests := []struct {
+		name       string
+		serverName string
+		code       codes.Code
+	}{
+		{name: "IP target without override", code: codes.Unavailable},
+		{name: "override matches the cert", serverName: "localhost", code: codes.Unimplemented},
+		{name: "override does not match the cert", serverName: "other.example.com", code: codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMesh(time.Second)
+			m.setupConfig.CaCertPath = []string{caPath}
+			m.setupConfig.ServerNameOverride = tt.serverName
+			node := &meshv1.Node{Name: "peer", Target: target}
+			c, err := m.initClient(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.closeClient(node)
+
+			_, err = c.client.Rtt(context.Background(), &meshv1.RttRequest{})
+			if code := status.Code(err); code != tt.code {
+				t.Errorf("Expected code %v, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func Test_clientCredentials(t *testing.T) {
+	invalidCaPath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(invalidCaPath, []byte("no cert"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		caPath   string
+		fallback bool
+		require  bool
+		wantErr  bool
+		protocol string
+	}{
+		{name: "no TLS configured", protocol: "insecure"},
+		{name: "invalid CA cert", caPath: invalidCaPath, wantErr: true},
+		{name: "invalid CA cert with fallback", caPath: invalidCaPath, fallback: true, protocol: "insecure"},
+		{name: "invalid CA cert with required TLS", caPath: invalidCaPath, require: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMesh(time.Second)
+			if tt.caPath != "" {
+				m.setupConfig.CaCertPath = []string{tt.caPath}
+			}
+			m.setupConfig.TLSFallback = tt.fallback
+			m.setupConfig.RequireTLS = tt.require
+			creds, err := m.clientCredentials(m.logger)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && creds.Info().SecurityProtocol != tt.protocol {
+				t.Errorf("Expected %v credentials, got %v", tt.protocol, creds.Info().SecurityProtocol)
+			}
+		})
+	}
+}
+
+func Test_checkTLS(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    SetupConfiguration
+		wantFatal bool
+	}{
+		{name: "no TLS", config: SetupConfiguration{}},
+		{name: "required TLS with CA cert", config: SetupConfiguration{RequireTLS: true, CaCertPath: []string{"ca.pem"}}},
+		{name: "required TLS without CA cert", config: SetupConfiguration{RequireTLS: true}, wantFatal: true},
+		{name: "required TLS with fallback", config: SetupConfiguration{RequireTLS: true, TLSFallback: true, CaCertPath: []string{"ca.pem"}}, wantFatal: true},
+		{name: "fallback with mesh token", config: SetupConfiguration{TLSFallback: true, MeshToken: "secret", CaCertPath: []string{"ca.pem"}}, wantFatal: true},
+		{name: "fallback with mesh token path", config: SetupConfiguration{TLSFallback: true, MeshTokenPath: "token", CaCertPath: []string{"ca.pem"}}, wantFatal: true},
+		{name: "client cert without key", config: SetupConfiguration{ClientCertPath: "cert.pem", CaCertPath: []string{"ca.pem"}}, wantFatal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// fatal logs panic instead of exiting
+			logger := zap.New(zapcore.NewNopCore(), zap.WithFatalHook(zapcore.WriteThenPanic)).Sugar()
+			defer func() {
+				if fatal := recover() != nil; fatal != tt.wantFatal {
+					t.Errorf("Expected fatal %v, got %v", tt.wantFatal, fatal)
+				}
+			}()
+			tt.config.checkTLS(logger)
+		})
+	}
+}
+
+func Test_setConnectionSecurity(t *testing.T) {
+	m := testMesh(time.Second)
+	node := &meshv1.Node{Target: "localhost:8081"}
+	m.setConnectionSecurity(node, metric.SECURITY_TLS)
+
+	// the series by target is replaced by the series by name after joining
+	node.Name = "peer"
+	m.setConnectionSecurity(node, metric.SECURITY_TLS)
+	m.setConnectionSecurity(node, metric.SECURITY_INSECURE)
+	if levels := gatherValues(t, m.metrics, "connection_security", "node", node.Target, "level", metric.SECURITY_TLS); len(levels) != 0 {
+		t.Errorf("Expected the series by target to be deleted, got %v", levels)
+	}
+	if levels := gatherValues(t, m.metrics, "connection_security", "node", "peer", "level", metric.SECURITY_TLS); len(levels) != 0 {
+		t.Errorf("Expected the unused level to be deleted, got %v", levels)
+	}
+	if levels := gatherValues(t, m.metrics, "connection_security", "node", "peer", "level", metric.SECURITY_INSECURE); len(levels) != 1 || levels[0] != 1 {
+		t.Errorf("Expected the used level to be set, got %v", levels)
+	}
+
+	m.forgetNode(node)
+	if levels := gatherValues(t, m.metrics, "connection_security", "node", "peer", "level", metric.SECURITY_INSECURE); len(levels) != 0 {
+		t.Errorf("Expected the series to be deleted after the node left, got %v", levels)
+	}
+}
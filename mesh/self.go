@@ -0,0 +1,55 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+)
+
+// Handling of the self-referential samples, see data.Sample.IsSelf
+const (
+	SELF_SAMPLES_KEEP    = "keep"
+	SELF_SAMPLES_DROP    = "drop"
+	SELF_SAMPLES_EXCLUDE = "exclude"
+)
+
+// Set the handling of the self-referential samples of the node of the id:
+// samples of any node about itself and samples of peers about this node.
+// An empty mode keeps them. Dropped samples are neither stored nor pushed and are counted,
+// excluded samples are stored but not part of the metric aggregations.
+func setSelfSamples(mode string, id uint32, database *data.Database, metrics metric.Metrics) error {
+	switch mode {
+	case "", SELF_SAMPLES_KEEP:
+	case SELF_SAMPLES_DROP:
+		database.SetSelfSampleDrop(id, func(*data.Sample) {
+			metrics.GetSelfSamplesDropped().Inc()
+		})
+	case SELF_SAMPLES_EXCLUDE:
+		metrics.SetExcludeSelfSamples(true, id)
+	default:
+		return fmt.Errorf("unknown self samples handling %v, please use keep, drop or exclude", mode)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	"go.uber.org/zap"
+)
+
+func Test_setSelfSamples(t *testing.T) {
+	self := &data.Sample{From: "a", To: "a", Key: data.RTT_TOTAL, Value: "1", Ts: 1, FromId: 1, ToId: 1}
+	// a sample of a peer about this node
+	peer := &data.Sample{From: "b", To: "a", Key: data.RTT_TOTAL, Value: "1", Ts: 1, FromId: 2, ToId: 1, Hops: 1}
+	tests := []struct {
+		mode    string
+		stored  bool
+		dropped float64
+		wantErr bool
+	}{
+		{mode: "", stored: true},
+		{mode: SELF_SAMPLES_KEEP, stored: true},
+		{mode: SELF_SAMPLES_DROP, stored: false, dropped: 2},
+		{mode: SELF_SAMPLES_EXCLUDE, stored: true},
+		{mode: "unknown", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			db, err := data.NewMemDB(zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+			metrics := metric.InitMetrics()
+			if err := setSelfSamples(tt.mode, 1, &db, metrics); (err != nil) != tt.wantErr {
+				t.Fatalf("setSelfSamples() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if stored := db.SetSample(self); stored != tt.stored {
+				t.Errorf("Expected the sample stored %v, got %v", tt.stored, stored)
+			}
+			if stored := db.SetSample(peer); stored != tt.stored {
+				t.Errorf("Expected the peer sample stored %v, got %v", tt.stored, stored)
+			}
+			if value := gatherValue(t, metrics, "self_samples_dropped_total"); value != tt.dropped {
+				t.Errorf("Expected %v dropped self samples, got %v", tt.dropped, value)
+			}
+		})
+	}
+}
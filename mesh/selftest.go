@@ -0,0 +1,158 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+)
+
+// SelfTest starts two in-process nodes on localhost, lets them join
+// and push a sample with the real client and server paths.
+// An error is returned if the databases do not converge within the timeout.
+func SelfTest(timeout time.Duration, debug bool) error {
+	logger := zap.NewNop().Sugar()
+	if debug {
+		logger = getLogger(debug, "")
+	}
+
+	routineConfig := StandardProductionRoutineConfig()
+	routineConfig.JoinInterval = time.Millisecond * 100
+	// the sample flow is verified without the health score
+	routineConfig.HealthWindow = 0
+
+	nodeA, err := newSelfTestNode("selftest-a", routineConfig, logger)
+	if err != nil {
+		return err
+	}
+	nodeB, err := newSelfTestNode("selftest-b", routineConfig, logger)
+	if err != nil {
+		return err
+	}
+	nodeA.setupConfig.Targets = []string{nodeB.setupConfig.JoinAddress}
+	nodeB.setupConfig.Targets = []string{nodeA.setupConfig.JoinAddress}
+
+	serverErr := make(chan error, 2)
+	for _, m := range []*Mesh{nodeA, nodeB} {
+		go func(m *Mesh) {
+			serverErr <- m.StartServer()
+		}(m)
+		go m.channelRoutines()
+	}
+	defer nodeA.stopServer()
+	defer nodeB.stopServer()
+
+	// node b joins the mesh of node a
+	go nodeB.timerRoutines()
+	defer nodeB.stopRoutines()
+
+	deadline := time.Now().Add(timeout)
+	err = waitFor(deadline, serverErr, func() bool {
+		_, aKnowsB := nodeA.database.GetNodeByName(nodeB.name.get())
+		_, bKnowsA := nodeB.database.GetNodeByName(nodeA.name.get())
+		return aKnowsB && bKnowsA
+	})
+	if err != nil {
+		return fmt.Errorf("nodes did not join: %w", err)
+	}
+	logger.Info("Nodes joined")
+
+	// node a pushes a sample to node b
+	sample := &data.Sample{
+		From:   nodeA.name.get(),
+		To:     nodeB.name.get(),
+		Key:    data.RTT_TOTAL,
+		Value:  "42",
+		Ts:     time.Now().Unix(),
+		FromId: nodeA.nodeId(),
+		ToId:   nodeB.nodeId(),
+	}
+	nodeA.database.SetSample(sample)
+	if err = nodeA.pushSamples(&meshv1.Node{Name: nodeB.name.get(), Target: nodeB.setupConfig.JoinAddress}); err != nil {
+		return fmt.Errorf("push samples failed: %w", err)
+	}
+
+	err = waitFor(deadline, serverErr, func() bool {
+		return nodeB.database.GetSample(data.GetSampleId(sample)).Value == sample.Value
+	})
+	if err != nil {
+		return fmt.Errorf("samples did not converge: %w", err)
+	}
+	logger.Info("Samples converged")
+	return nil
+}
+
+// Create a node listening on a free port of localhost
+func newSelfTestNode(name string, routineConfig *RoutineConfiguration, logger *zap.SugaredLogger) (*Mesh, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := lis.Addr().(*net.TCPAddr).Port
+	lis.Close()
+
+	setupConfig := &SetupConfiguration{
+		Name:             name,
+		ListenAddress:    "127.0.0.1",
+		ListenPort:       int64(port),
+		JoinAddress:      "127.0.0.1:" + strconv.Itoa(port),
+		AdvertiseAddress: "127.0.0.1",
+		AdvertisePort:    int64(port),
+		Observer:         true,
+		TcpNoDelay:       true,
+	}
+	return newMesh(routineConfig, setupConfig, logger.Named(name))
+}
+
+// Wait until the condition is true, the deadline is reached or a server failed
+func waitFor(deadline time.Time, serverErr chan error, condition func() bool) error {
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-serverErr:
+			return fmt.Errorf("server failed: %w", err)
+		default:
+		}
+		if condition() {
+			return nil
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	return errors.New("timeout")
+}
+
+// Stop the mesh server immediately
+func (m *Mesh) stopServer() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.grpcServer != nil {
+		m.grpcServer.Stop()
+	}
+	m.removeSocket()
+}
@@ -23,8 +23,16 @@ package mesh
 
 import (
 	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
 	"net"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/telekom/canary-bot/data"
 	h "github.com/telekom/canary-bot/helper"
@@ -33,8 +41,13 @@ import (
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthv1 "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
@@ -46,64 +59,507 @@ type MeshServer struct {
 	metrics metric.Metrics
 	log     *zap.SugaredLogger
 	data    *data.Database
-	name    *string
+	name    *nodeName
+	labels  map[string]string
+	// API tokens, protecting the admin RPCs
+	tokens []string
+	// Mesh token, authorizing the nodes to pull the samples; nil if disabled
+	meshToken *meshToken
 
 	newNodeDiscovered chan NodeDiscovered
+	nodeLeft          chan *meshv1.Node
+	// Time a left node can not be re-added by pings
+	tombstoneTTL time.Duration
+	// Bounds the discovery broadcast of a joining node
+	joinSettleTimeout time.Duration
+	// Nodes without a contact within the max. age are not passed to a joining node
+	discoveryMaxAge time.Duration
+	// Server is draining before shutdown, refuse joins & discoveries
+	draining *atomic.Bool
+	// Samples accepted by this node, exchanged at join
+	sampleFilter *data.SampleFilter
+	// Ping a node on behalf of another node
+	ping func(ctx context.Context, node *meshv1.Node) error
+	// Save the one-way delay of a ping sent with its send time, set by the
+	// sending node with the one-way delay enabled; nil skips the delays
+	oneWayDelay func(ctx context.Context, from *meshv1.Node, recv time.Time)
+	// A throughput probe is received
+	throughputActive atomic.Bool
+	// Info of this node, exchanged at join
+	info *meshv1.NodeInfo
+	// Store the info of a joining node, an error refuses the node
+	peerInfo func(node *meshv1.Node, info *meshv1.NodeInfo) error
+	// Pull & merge the samples of a known node, with the token of the request
+	resync func(ctx context.Context, peer string, token string) (*meshv1.ResyncResponse, error)
+	// Pause or resume the outbound probes of the node
+	pause func(paused bool) error
 }
 
 // JoinMesh allows a node to join the mesh
 func (s *MeshServer) JoinMesh(ctx context.Context, req *meshv1.Node) (*meshv1.JoinMeshResponse, error) {
+	if s.draining.Load() {
+		return nil, status.Error(codes.Unavailable, "node is draining")
+	}
 	s.log.Infow("New join mesh request", "node", req.Name)
+	if s.peerInfo != nil {
+		if err := s.peerInfo(req, req.Info); err != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
 	// Check if name of joining node is unique in mesh, let join if state is not ok, let join if target is same
-	dbnode := s.data.GetNodeByName(req.Name)
-	if (dbnode.Id != 0 && dbnode.State == NODE_OK && dbnode.Target != req.Target) || *s.name == req.Name {
-		return &meshv1.JoinMeshResponse{NameUnique: false, MyName: *s.name, Nodes: []*meshv1.Node{}}, nil
+	dbnode, ok := s.data.GetNodeByName(req.Name)
+	if (ok && dbnode.State == NODE_OK && dbnode.Target != req.Target) || s.name.get() == req.Name {
+		return &meshv1.JoinMeshResponse{NameUnique: false, MyName: s.name.get(), MyLabels: s.labels, Nodes: []*meshv1.Node{}}, nil
 	}
-	s.newNodeDiscovered <- NodeDiscovered{req, GetId(req)}
+	s.newNodeDiscovered <- NodeDiscovered{req, GetId(req), settleDeadline(time.Now(), s.joinSettleTimeout), time.Now().Unix(), 0}
 
+	// nodes without a contact within the discovery max. age are not passed on
 	var nodes []*meshv1.Node
+	now := time.Now()
 	for _, datanode := range s.data.GetNodeList() {
-		nodes = append(nodes, &meshv1.Node{Name: datanode.Name, Target: datanode.Target})
+		if isStaleContact(datanode.LastSeen, s.discoveryMaxAge, now) {
+			continue
+		}
+		nodes = append(nodes, datanode.Convert())
 	}
-	res := meshv1.JoinMeshResponse{NameUnique: true, MyName: *s.name, Nodes: nodes}
+	res := meshv1.JoinMeshResponse{NameUnique: true, MyName: s.name.get(), MyLabels: s.labels, MySampleFilter: s.sampleFilter.Convert(), Nodes: nodes, MyInfo: s.info}
 	return &res, nil
 }
 
-// PC if node pings
-func (s *MeshServer) Ping(ctx context.Context, req *meshv1.Node) (*emptypb.Empty, error) {
+// RPC to get the version, protocol version & enabled features of the node
+func (s *MeshServer) GetInfo(ctx context.Context, req *emptypb.Empty) (*meshv1.NodeInfo, error) {
+	return s.info, nil
+}
+
+// PC if node pings.
+// The wall-clock time is returned to estimate the clock skew.
+func (s *MeshServer) Ping(ctx context.Context, req *meshv1.Node) (*meshv1.PingResponse, error) {
+	recv := time.Now()
 	if req != nil {
-		s.data.SetNode(data.Convert(req, NODE_OK))
+		// a ping after the leave, e.g. the node joined again by another node;
+		// a ping in flight at the leave is within the second of the tombstone
+		s.data.DeleteTombstoneBefore(GetId(req), recv.Unix())
+		if !s.data.IsTombstoned(GetId(req), s.tombstoneTTL) {
+			// the ping is a contact with the node by the own clock
+			s.data.SetNode(data.Convert(req, NODE_OK))
+			s.data.SetNodeLastSeen(GetId(req))
+		}
 	}
-	return &emptypb.Empty{}, nil
+	if s.oneWayDelay != nil {
+		s.oneWayDelay(ctx, req, recv)
+	}
+	return &meshv1.PingResponse{Time: time.Now().UnixNano()}, nil
+}
+
+// RPC if a node asks to ping a suspect node.
+// Just known nodes are pinged, by the target stored by this node.
+// The ping is bound by half of the remaining request time,
+// so the response reaches the requesting node in time.
+func (s *MeshServer) PingIndirect(ctx context.Context, req *meshv1.PingIndirectRequest) (*meshv1.PingIndirectResponse, error) {
+	if req.Target == nil || req.Target.Target == "" {
+		return nil, status.Error(codes.InvalidArgument, "node target is missing")
+	}
+	node, ok := s.data.GetNode(GetId(req.Target))
+	if !ok {
+		return nil, status.Error(codes.NotFound, "node is unknown")
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/2)
+		defer cancel()
+	}
+	err := s.ping(ctx, node.Convert())
+	return &meshv1.PingIndirectResponse{Reachable: err == nil}, nil
 }
 
 // RPC if new node is discovered in the mesh
 func (s *MeshServer) NodeDiscovery(ctx context.Context, req *meshv1.NodeDiscoveryRequest) (*emptypb.Empty, error) {
-	s.newNodeDiscovered <- NodeDiscovered{req.NewNode, GetId(req.IAmNode)}
+	if s.draining.Load() {
+		return nil, status.Error(codes.Unavailable, "node is draining")
+	}
+	s.newNodeDiscovered <- NodeDiscovered{req.NewNode, GetId(req.IAmNode), settleDeadline(time.Now(), s.joinSettleTimeout), receivedLastSeen(req.LastSeen, req.LastSeenAge, time.Now()), req.Depth}
 	return &emptypb.Empty{}, nil
 }
 
-// RPC if samples will be sent by node in mesh
-func (s *MeshServer) PushSamples(ctx context.Context, req *meshv1.Samples) (*emptypb.Empty, error) {
-	for _, sample := range req.Samples {
-		if sample.Ts > s.data.GetSampleTs(GetSampleId(sample)) {
-			s.data.SetSample(&data.Sample{
-				From:  sample.From,
-				To:    sample.To,
-				Key:   sample.Key,
-				Value: sample.Value,
-				Ts:    sample.Ts,
-			})
+// RPC if new nodes joined a seed node within its coalescing window
+func (s *MeshServer) NodeDiscoveryBatch(ctx context.Context, req *meshv1.NodeDiscoveryBatchRequest) (*emptypb.Empty, error) {
+	if s.draining.Load() {
+		return nil, status.Error(codes.Unavailable, "node is draining")
+	}
+	if len(req.Discoveries) > MAX_DISCOVERY_BATCH {
+		return nil, status.Errorf(codes.InvalidArgument, "more than %d discoveries", MAX_DISCOVERY_BATCH)
+	}
+	for _, d := range req.Discoveries {
+		if d.NewNode == nil || d.IAmNode == nil {
+			continue
 		}
+		s.newNodeDiscovered <- NodeDiscovered{d.NewNode, GetId(d.IAmNode), settleDeadline(time.Now(), s.joinSettleTimeout), receivedLastSeen(d.LastSeen, d.LastSeenAge, time.Now()), d.Depth}
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// RPC if a node is leaving the mesh on a clean shutdown.
+// The node is removed and a tombstone is created.
+func (s *MeshServer) LeaveMesh(ctx context.Context, req *meshv1.Node) (*emptypb.Empty, error) {
+	if req == nil || req.Target == "" {
+		return nil, status.Error(codes.InvalidArgument, "node target is missing")
 	}
+	select {
+	case s.nodeLeft <- req:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// RPC if samples will be sent by node in mesh
+func (s *MeshServer) PushSamples(ctx context.Context, req *meshv1.Samples) (*emptypb.Empty, error) {
+	storeSamples(s.data, s.metrics, s.sampleFilter, req.Samples)
 	s.log.Debugw("Safe samples", "count", len(s.data.GetSampleList()))
 	return &emptypb.Empty{}, nil
 }
 
+// Store the samples received from another node.
+// Samples not accepted by the filter or not newer than the known samples are dropped.
+// The stored samples are counted as added or updated, the samples equal to the known
+// samples as unchanged and the older samples (or losing a tie) as rejected.
+func storeSamples(db *data.Database, metrics metric.Metrics, filter *data.SampleFilter, samples []*meshv1.Sample) (added uint32, updated uint32, unchanged uint32, rejected uint32) {
+	now := time.Now().Unix()
+	for _, sample := range samples {
+		// drop samples of nodes not aware of the filter
+		if !filter.Accepts(sample.Key) {
+			continue
+		}
+		ts := db.GetSampleTs(GetSampleId(sample))
+		if sample.Ts < ts {
+			rejected++
+			continue
+		}
+		// the sample was forwarded once more to reach this node
+		hops := sample.Hops + 1
+		// the newer sample wins, ties are broken by the database
+		stored := db.SetReceivedSample(&data.Sample{
+			From:   sample.From,
+			To:     sample.To,
+			Key:    sample.Key,
+			Value:  sample.Value,
+			Ts:     sample.Ts,
+			Hops:   hops,
+			FromId: sample.FromId,
+			ToId:   sample.ToId,
+			Fields: receivedFields(sample.Fields),
+			Group:  sample.Group,
+		})
+		switch {
+		case !stored && db.GetSample(GetSampleId(sample)).Value == sample.Value:
+			unchanged++
+			continue
+		case !stored:
+			rejected++
+			continue
+		case ts == 0:
+			added++
+		default:
+			updated++
+		}
+		observePropagation(metrics, now, sample.Ts, hops)
+	}
+	return added, updated, unchanged, rejected
+}
+
+// Max. sub-fields of a received sample, the further fields are dropped
+const MAX_SAMPLE_FIELDS = 8
+
+// Valid sub-field names, the names are exported as label values
+var sampleFieldPattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Get the sub-fields of a received sample with a valid name,
+// at most MAX_SAMPLE_FIELDS by name order; nil if none is valid
+func receivedFields(fields map[string]string) map[string]string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if sampleFieldPattern.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	if len(names) > MAX_SAMPLE_FIELDS {
+		names = names[:MAX_SAMPLE_FIELDS]
+	}
+	accepted := make(map[string]string, len(names))
+	for _, name := range names {
+		accepted[name] = fields[name]
+	}
+	return accepted
+}
+
+// Observe the propagation latency of a received sample.
+// Samples from the future (clock skew) are clamped to 0 and counted.
+func observePropagation(metrics metric.Metrics, now int64, ts int64, hops uint32) {
+	latency := now - ts
+	if latency < 0 {
+		metrics.GetSampleClockSkew().Inc()
+		latency = 0
+	}
+	metrics.GetSamplePropagation().WithLabelValues(hopsLabel(hops)).Observe(float64(latency))
+}
+
+// Max. hops with an own hops label, more hops share buckets
+const HOPS_LABEL_EXACT = 3
+
+// Get the bucketed hops label of the propagation latency, so the label
+// is bounded without a max. hops: 0-3 by their value, then 4-7, 8-15 and 16+
+func hopsLabel(hops uint32) string {
+	switch {
+	case hops <= HOPS_LABEL_EXACT:
+		return strconv.FormatUint(uint64(hops), 10)
+	case hops < 8:
+		return "4-7"
+	case hops < 16:
+		return "8-15"
+	default:
+		return "16+"
+	}
+}
+
+// RPC for the digest of the sample store.
+// The bucket hashes are returned, or the samples of the requested buckets.
+// Protected by the API tokens or the mesh token like GetSamples.
+func (s *MeshServer) SampleDigest(ctx context.Context, req *meshv1.SampleDigestRequest) (*meshv1.SampleDigestResponse, error) {
+	if !s.authorized(ctx) && !s.meshAuthorized(ctx) {
+		s.log.Warnw("Request", "rpc", "SampleDigest", "auth", "failed")
+		return nil, status.Error(codes.Unauthenticated, "auth failed")
+	}
+	if len(req.Buckets) == 0 {
+		return &meshv1.SampleDigestResponse{BucketHashes: s.data.GetSampleDigest()}, nil
+	}
+	for _, bucket := range req.Buckets {
+		if bucket >= data.DIGEST_BUCKETS {
+			return nil, status.Errorf(codes.InvalidArgument, "bucket %v out of range, %v buckets", bucket, data.DIGEST_BUCKETS)
+		}
+	}
+
+	samples := s.data.GetDigestBucketSamples(req.Buckets)
+	entries := make([]*meshv1.SampleDigestEntry, 0, len(samples))
+	for _, sample := range samples {
+		entries = append(entries, &meshv1.SampleDigestEntry{Id: sample.Id, Ts: sample.Ts})
+	}
+	return &meshv1.SampleDigestResponse{Entries: entries}, nil
+}
+
+// Max. samples of a FetchSamples request
+const MAX_FETCH_SAMPLES = 10000
+
+// RPC to fetch samples by id, unknown samples are skipped.
+// Protected by the API tokens or the mesh token like GetSamples.
+func (s *MeshServer) FetchSamples(ctx context.Context, req *meshv1.FetchSamplesRequest) (*meshv1.Samples, error) {
+	if !s.authorized(ctx) && !s.meshAuthorized(ctx) {
+		s.log.Warnw("Request", "rpc", "FetchSamples", "auth", "failed")
+		return nil, status.Error(codes.Unauthenticated, "auth failed")
+	}
+	if len(req.Ids) > MAX_FETCH_SAMPLES {
+		return nil, status.Errorf(codes.InvalidArgument, "max. %v samples per fetch", MAX_FETCH_SAMPLES)
+	}
+	samples := make([]*meshv1.Sample, 0, len(req.Ids))
+	for _, id := range req.Ids {
+		sample := s.data.GetSample(id)
+		if sample.Ts == 0 {
+			continue
+		}
+		samples = append(samples, &meshv1.Sample{From: sample.From, To: sample.To, Key: sample.Key, Value: sample.Value, Ts: sample.Ts, Hops: sample.Hops, FromId: sample.FromId, ToId: sample.ToId, Fields: sample.Fields, Group: sample.Group})
+	}
+	return &meshv1.Samples{Samples: samples}, nil
+}
+
 // PRC if node measures rount-trip-time
-// Do not add any functionality that will effect the RTT
-func (s *MeshServer) Rtt(ctx context.Context, req *emptypb.Empty) (*emptypb.Empty, error) {
-	return &emptypb.Empty{}, nil
+// Do not add any functionality that will effect the RTT.
+// A payload is discarded or echoed as is, the payload size is limited.
+func (s *MeshServer) Rtt(ctx context.Context, req *meshv1.RttRequest) (*meshv1.RttResponse, error) {
+	if len(req.Payload) > data.MAX_RTT_PAYLOAD_SIZE {
+		return nil, status.Errorf(codes.InvalidArgument, "payload exceeds %v bytes", data.MAX_RTT_PAYLOAD_SIZE)
+	}
+	if req.Echo {
+		return &meshv1.RttResponse{Payload: req.Payload}, nil
+	}
+	return &meshv1.RttResponse{}, nil
+}
+
+// RPC to receive the data of a throughput probe.
+// Just one probe is received at a time, the volume is limited.
+// The throughput is measured after the first chunk to skip the stream setup.
+func (s *MeshServer) Throughput(stream meshv1.MeshService_ThroughputServer) error {
+	if !s.throughputActive.CompareAndSwap(false, true) {
+		return status.Error(codes.ResourceExhausted, "throughput probe in progress")
+	}
+	defer s.throughputActive.Store(false)
+
+	var received, first uint64
+	var start time.Time
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		received += uint64(len(chunk.Data))
+		if received > MAX_THROUGHPUT_VOLUME {
+			return status.Errorf(codes.InvalidArgument, "volume exceeds %v bytes", MAX_THROUGHPUT_VOLUME)
+		}
+		if start.IsZero() {
+			start = time.Now()
+			first = received
+		}
+	}
+
+	res := &meshv1.ThroughputResponse{Bytes: received}
+	if elapsed := time.Since(start); !start.IsZero() && received > first && elapsed > 0 {
+		res.BytesPerSecond = float64(received-first) / elapsed.Seconds()
+	}
+	return stream.SendAndClose(res)
+}
+
+// Default & max. samples per page of GetSamples
+const (
+	DEFAULT_SAMPLE_PAGE_SIZE = 500
+	MAX_SAMPLE_PAGE_SIZE     = 5000
+)
+
+// RPC to stream the known samples of this node in pages,
+// e.g. to reconcile the views of two nodes.
+// Read-only, but protected by the API tokens like the API,
+// or by the mesh token for the nodes of the mesh if set.
+func (s *MeshServer) GetSamples(req *meshv1.GetSamplesRequest, stream meshv1.MeshService_GetSamplesServer) error {
+	if !s.authorized(stream.Context()) && !s.meshAuthorized(stream.Context()) {
+		s.log.Warnw("Request", "rpc", "GetSamples", "auth", "failed")
+		return status.Error(codes.Unauthenticated, "auth failed")
+	}
+
+	pageSize := int(req.PageSize)
+	if pageSize == 0 {
+		pageSize = DEFAULT_SAMPLE_PAGE_SIZE
+	}
+	if pageSize > MAX_SAMPLE_PAGE_SIZE {
+		pageSize = MAX_SAMPLE_PAGE_SIZE
+	}
+
+	samples := s.data.GetSampleList()
+	for start := 0; start < len(samples); start += pageSize {
+		end := start + pageSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		page := make([]*meshv1.Sample, 0, end-start)
+		for _, sample := range samples[start:end] {
+			page = append(page, &meshv1.Sample{From: sample.From, To: sample.To, Key: sample.Key, Value: sample.Value, Ts: sample.Ts, Hops: sample.Hops, FromId: sample.FromId, ToId: sample.ToId, Fields: sample.Fields, Group: sample.Group})
+		}
+		if err := stream.Send(&meshv1.Samples{Samples: page}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RPC to resync the samples with a known node, e.g. if the views drifted.
+// All samples of the node are pulled with the mesh token if set, else with
+// the token of the request, so the token has to be an API token of both nodes.
+func (s *MeshServer) Resync(ctx context.Context, req *meshv1.ResyncRequest) (*meshv1.ResyncResponse, error) {
+	token, ok := s.authToken(ctx)
+	if !ok {
+		s.log.Warnw("Request", "rpc", "Resync", "auth", "failed")
+		return nil, status.Error(codes.Unauthenticated, "auth failed")
+	}
+	if req.Peer == "" {
+		return nil, status.Error(codes.InvalidArgument, "peer is missing")
+	}
+	if s.resync == nil {
+		return nil, status.Error(codes.Unimplemented, "resync not supported")
+	}
+	s.log.Infow("Resync samples", "peer", req.Peer)
+	return s.resync(ctx, req.Peer, token)
+}
+
+// Pause suspends the outbound probes of the node, the node stays in the mesh
+func (s *MeshServer) Pause(ctx context.Context, req *meshv1.PauseRequest) (*meshv1.PauseResponse, error) {
+	return s.setPaused(ctx, "Pause", true)
+}
+
+// Resume resumes the outbound probes of a paused node
+func (s *MeshServer) Resume(ctx context.Context, req *meshv1.ResumeRequest) (*meshv1.PauseResponse, error) {
+	return s.setPaused(ctx, "Resume", false)
+}
+
+// Set the paused state of the outbound probes, requires an API token
+func (s *MeshServer) setPaused(ctx context.Context, rpc string, paused bool) (*meshv1.PauseResponse, error) {
+	if !s.authorized(ctx) {
+		s.log.Warnw("Request", "rpc", rpc, "auth", "failed")
+		return nil, status.Error(codes.Unauthenticated, "auth failed")
+	}
+	if err := s.pause(paused); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &meshv1.PauseResponse{Paused: paused}, nil
+}
+
+// Check the bearer token of the request against the API tokens
+func (s *MeshServer) authorized(ctx context.Context) bool {
+	_, ok := s.authToken(ctx)
+	return ok
+}
+
+// Check the mesh token of the request, e.g. of a node pulling the samples
+func (s *MeshServer) meshAuthorized(ctx context.Context) bool {
+	if s.meshToken == nil {
+		return false
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, token := range md.Get(authMetadataKey) {
+		if s.meshToken.valid(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the bearer token of the request if it is one of the API tokens
+func (s *MeshServer) authToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for _, auth := range md.Get("authorization") {
+		splitToken := strings.Split(auth, "Bearer")
+		if len(splitToken) != 2 {
+			continue
+		}
+		authToken := strings.TrimSpace(splitToken[1])
+		for _, t := range s.tokens {
+			if subtle.ConstantTimeCompare([]byte(authToken), []byte(t)) == 1 {
+				return authToken, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Min. max. inbound message size, a RTT request with the max. payload fits
+const MIN_RECV_MSG_SIZE = data.MAX_RTT_PAYLOAD_SIZE + 1<<10
+
+// Server options limiting the streams per connection & the inbound message size
+func (m *Mesh) serverLimits() []grpc.ServerOption {
+	opts := []grpc.ServerOption{}
+	if m.setupConfig.MaxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(m.setupConfig.MaxConcurrentStreams))
+	}
+	if m.setupConfig.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(m.setupConfig.MaxRecvMsgSize))
+	}
+	return opts
 }
 
 // Start the mesh server.
@@ -113,8 +569,23 @@ func (m *Mesh) StartServer() error {
 		log:               m.logger.Named("server"),
 		metrics:           m.metrics,
 		data:              &m.database,
-		name:              &m.setupConfig.Name,
+		name:              m.name,
+		labels:            m.setupConfig.Labels,
+		tokens:            m.setupConfig.Tokens,
+		meshToken:         m.meshToken,
 		newNodeDiscovered: m.newNodeDiscovered,
+		nodeLeft:          m.nodeLeft,
+		tombstoneTTL:      m.routineConfig.TombstoneTTL,
+		discoveryMaxAge:   m.routineConfig.DiscoveryMaxAge,
+		joinSettleTimeout: m.routineConfig.JoinSettleTimeout,
+		draining:          &m.draining,
+		sampleFilter:      m.setupConfig.sampleFilter(),
+		ping:              m.pingContext,
+		oneWayDelay:       m.observePingDelay,
+		info:              m.info(),
+		peerInfo:          m.setPeerInfo,
+		resync:            m.Resync,
+		pause:             m.setPaused,
 	}
 
 	// gRPC debug mode for more logs
@@ -122,17 +593,14 @@ func (m *Mesh) StartServer() error {
 		grpc_zap.ReplaceGrpcLoggerV2(meshServer.log.Named("grpc").Desugar())
 	}
 
-	// address the server will be bound to
-	listenAdd := m.setupConfig.ListenAddress + ":" + strconv.FormatInt(m.setupConfig.ListenPort, 10)
-
-	// start TCP listener
-	meshServer.log.Infow("Start listening", "address", listenAdd)
-	lis, err := net.Listen("tcp", listenAdd)
+	// start TCP or unix domain socket listener
+	listeners, err := m.listen()
 	if err != nil {
 		return err
 	}
+	listeners = m.limitListeners(listeners)
 
-	opts := []grpc.ServerOption{}
+	opts := m.serverLimits()
 
 	// TLS
 	tlsCredentials, err := h.LoadServerTLSCredentials(
@@ -150,13 +618,94 @@ func (m *Mesh) StartServer() error {
 		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCredentials)))
 	}
 
+	// reject RPCs above the max. inbound streams first,
+	// followed by RPCs without the mesh token
+	unaryInterceptors := []grpc.UnaryServerInterceptor{m.limitUnaryInterceptor}
+	streamInterceptors := []grpc.StreamServerInterceptor{m.limitStreamInterceptor}
+	if m.meshToken != nil {
+		meshServer.log.Info("Mesh token authentication enabled")
+		unaryInterceptors = append(unaryInterceptors, m.authUnaryInterceptor)
+		streamInterceptors = append(streamInterceptors, m.authStreamInterceptor)
+	}
+	// log the sampled & failed RPCs, chained before the custom interceptors
+	unaryInterceptors = append(unaryInterceptors, m.requestLogUnaryInterceptor, m.deadlineInterceptor)
+	unaryInterceptors = append(unaryInterceptors, m.setupConfig.ServerUnaryInterceptors...)
+	opts = append(opts, grpc.ChainUnaryInterceptor(unaryInterceptors...))
+	// custom interceptors
+	streamInterceptors = append(streamInterceptors, m.requestLogStreamInterceptor)
+	streamInterceptors = append(streamInterceptors, m.setupConfig.ServerStreamInterceptors...)
+	opts = append(opts, grpc.ChainStreamInterceptor(streamInterceptors...))
+
 	// register gRPC listener
 	grpcServer := grpc.NewServer(opts...)
 	meshv1.RegisterMeshServiceServer(grpcServer, meshServer)
-	reflection.Register(grpcServer)
-	err = grpcServer.Serve(lis)
+	if m.setupConfig.GrpcReflection {
+		meshServer.log.Info("gRPC server reflection enabled")
+		reflection.Register(grpcServer)
+	}
+
+	// health service, reports NOT_SERVING while draining
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(meshv1.MeshService_ServiceDesc.ServiceName, healthv1.HealthCheckResponse_SERVING)
+	healthv1.RegisterHealthServer(grpcServer, healthServer)
+
+	m.mu.Lock()
+	m.grpcServer = grpcServer
+	m.healthServer = healthServer
+	m.mu.Unlock()
+	// the probing is NOT_SERVING while paused
+	m.observePaused()
+
+	// serve additional listeners sharing the port, the first listener blocks
+	for _, lis := range listeners[1:] {
+		go func(lis net.Listener) {
+			if err := grpcServer.Serve(lis); err != nil {
+				meshServer.log.Warnw("Listener stopped", "address", lis.Addr().String(), "error", err)
+			}
+		}(lis)
+	}
+
+	err = grpcServer.Serve(listeners[0])
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// Drain the mesh server before shutdown.
+// New joins and discoveries are refused, in-flight requests
+// will be finished until the drain timeout is exceeded.
+func (m *Mesh) Drain() {
+	log := m.logger.Named("server")
+	m.draining.Store(true)
+	defer m.stopRoutines()
+
+	m.mu.Lock()
+	grpcServer := m.grpcServer
+	healthServer := m.healthServer
+	m.mu.Unlock()
+
+	if grpcServer == nil {
+		return
+	}
+	healthServer.Shutdown()
+
+	// notify the nodes before the server stops
+	m.leave()
+
+	log.Infow("Draining server", "timeout", m.routineConfig.DrainTimeout.String())
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Info("Server drained")
+	case <-time.After(m.routineConfig.DrainTimeout):
+		log.Warn("Drain timeout exceeded - stopping server")
+		grpcServer.Stop()
+	}
+	m.removeSocket()
+}
@@ -0,0 +1,443 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func Test_PushSamplesHops(t *testing.T) {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &MeshServer{metrics: metric.InitMetrics(), log: zap.NewNop().Sugar(), data: &db}
+
+	ts := time.Now().Unix()
+	local := &meshv1.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Ts: ts}
+	forwarded := &meshv1.Sample{From: "a", To: "c", Key: data.RTT_TOTAL, Value: "1", Ts: ts, Hops: 3}
+	_, err = s.PushSamples(context.Background(), &meshv1.Samples{Samples: []*meshv1.Sample{local, forwarded}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hops := db.GetSample(GetSampleId(local)).Hops; hops != 1 {
+		t.Errorf("Expected 1 hop, got %v", hops)
+	}
+	if hops := db.GetSample(GetSampleId(forwarded)).Hops; hops != 4 {
+		t.Errorf("Expected 4 hops, got %v", hops)
+	}
+}
+
+func Test_hopsLabel(t *testing.T) {
+	tests := []struct {
+		hops     uint32
+		expected string
+	}{
+		{hops: 0, expected: "0"},
+		{hops: 3, expected: "3"},
+		{hops: 4, expected: "4-7"},
+		{hops: 15, expected: "8-15"},
+		{hops: 16, expected: "16+"},
+		{hops: 4294967295, expected: "16+"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if label := hopsLabel(tt.hops); label != tt.expected {
+				t.Errorf("hops label of %v is %v, expected %v", tt.hops, label, tt.expected)
+			}
+		})
+	}
+}
+
+func Test_PushSamplesFields(t *testing.T) {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &MeshServer{metrics: metric.InitMetrics(), log: zap.NewNop().Sugar(), data: &db}
+
+	fields := map[string]string{"status": "200", "size": "512", "invalid-name": "1", "": "1"}
+	for i := 0; i < MAX_SAMPLE_FIELDS; i++ {
+		fields["f"+strconv.Itoa(i)] = "1"
+	}
+	invalid := &meshv1.Sample{From: "a", To: "b", Key: PROBE_HTTP_KEY, Value: "1", Ts: time.Now().Unix(), Fields: map[string]string{"a b": "1"}}
+	sample := &meshv1.Sample{From: "a", To: "c", Key: PROBE_HTTP_KEY, Value: "1", Ts: time.Now().Unix(), Fields: fields}
+	_, err = s.PushSamples(context.Background(), &meshv1.Samples{Samples: []*meshv1.Sample{invalid, sample}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored := db.GetSample(GetSampleId(invalid)).Fields; stored != nil {
+		t.Errorf("Expected the invalid fields to be dropped, got %v", stored)
+	}
+	stored := db.GetSample(GetSampleId(sample)).Fields
+	if len(stored) != MAX_SAMPLE_FIELDS {
+		t.Errorf("Expected %v fields, got %v", MAX_SAMPLE_FIELDS, stored)
+	}
+	if _, ok := stored["invalid-name"]; ok {
+		t.Errorf("Expected the invalid field name to be dropped, got %v", stored)
+	}
+	// the first fields by name are kept
+	if _, ok := stored["f0"]; !ok {
+		t.Errorf("Expected the first fields by name to be kept, got %v", stored)
+	}
+}
+
+func Test_GetSamples(t *testing.T) {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, to := range []string{"b", "c", "d"} {
+		db.SetSample(&data.Sample{From: "a", To: to, Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(server, &MeshServer{metrics: metric.InitMetrics(), log: zap.NewNop().Sugar(), data: &db, tokens: []string{"secret"}})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	m := testMesh(time.Second)
+	node := &meshv1.Node{Name: "server", Target: lis.Addr().String()}
+	samples, err := m.GetSamples(context.Background(), node, "secret")
+	if err != nil {
+		t.Fatalf("could not get samples: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Errorf("Expected 3 samples, got %v", len(samples))
+	}
+
+	_, err = m.GetSamples(context.Background(), node, "wrong")
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if !errors.As(err, &grpcErr) || grpcErr.GRPCStatus().Code() != codes.Unauthenticated {
+		t.Errorf("Expected unauthenticated error, got %v", err)
+	}
+
+	// one page per sample
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	stream, err := meshv1.NewMeshServiceClient(conn).GetSamples(ctx, &meshv1.GetSamplesRequest{PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := 0
+	for {
+		_, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages, got %v", pages)
+	}
+}
+
+func Test_LeaveMesh(t *testing.T) {
+	s := &MeshServer{log: zap.NewNop().Sugar(), nodeLeft: make(chan *meshv1.Node, 1)}
+
+	_, err := s.LeaveMesh(context.Background(), &meshv1.Node{Name: "a"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected invalid argument error, got %v", err)
+	}
+
+	node := &meshv1.Node{Name: "a", Target: "a:8081"}
+	if _, err := s.LeaveMesh(context.Background(), node); err != nil {
+		t.Fatalf("could not leave: %v", err)
+	}
+	if left := <-s.nodeLeft; left != node {
+		t.Errorf("Expected node %v to leave, got %v", node, left)
+	}
+
+	// the leave is bound by the request, if the leave routine is busy
+	s.nodeLeft <- node
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.LeaveMesh(ctx, node); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded error, got %v", err)
+	}
+}
+
+func Test_JoinMeshStaleNodes(t *testing.T) {
+	db, _ := data.NewMemDB(zap.NewNop().Sugar())
+	s := &MeshServer{
+		log:               zap.NewNop().Sugar(),
+		data:              &db,
+		name:              newNodeName("seed"),
+		newNodeDiscovered: make(chan NodeDiscovered, 1),
+		discoveryMaxAge:   time.Minute,
+		draining:          &atomic.Bool{},
+	}
+	fresh := data.Convert(&meshv1.Node{Name: "fresh", Target: "fresh:8081"}, NODE_OK)
+	fresh.LastSeen = time.Now().Unix()
+	stale := data.Convert(&meshv1.Node{Name: "stale", Target: "stale:8081"}, NODE_OK)
+	stale.LastSeen = time.Now().Add(-time.Hour).Unix()
+	unknown := data.Convert(&meshv1.Node{Name: "unknown", Target: "unknown:8081"}, NODE_OK)
+	db.SetNodes([]*data.Node{fresh, stale, unknown})
+
+	res, err := s.JoinMesh(context.Background(), &meshv1.Node{Name: "joining", Target: "joining:8081"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, node := range res.Nodes {
+		names[node.Name] = true
+	}
+	if !names["fresh"] || !names["unknown"] || names["stale"] || len(names) != 2 {
+		t.Errorf("Expected the fresh & never contacted nodes, got %v", names)
+	}
+}
+
+func Test_PingSetsLastSeen(t *testing.T) {
+	db, _ := data.NewMemDB(zap.NewNop().Sugar())
+	s := &MeshServer{log: zap.NewNop().Sugar(), data: &db, tombstoneTTL: time.Minute}
+	node := &meshv1.Node{Name: "a", Target: "a:8081"}
+
+	if _, err := s.Ping(context.Background(), node); err != nil {
+		t.Fatal(err)
+	}
+	if stored, ok := db.GetNode(GetId(node)); !ok || stored.LastSeen < time.Now().Unix()-1 {
+		t.Errorf("Expected the pinging node to be seen now, got %+v", stored)
+	}
+}
+
+func Test_PingClearsTombstone(t *testing.T) {
+	db, _ := data.NewMemDB(zap.NewNop().Sugar())
+	s := &MeshServer{log: zap.NewNop().Sugar(), data: &db, tombstoneTTL: time.Minute}
+	node := &meshv1.Node{Name: "a", Target: "a:8081"}
+
+	// a ping in the second of the leave keeps the tombstone
+	db.SetTombstone(GetId(node), node.Name)
+	if _, err := s.Ping(context.Background(), node); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.GetNode(GetId(node)); ok || !db.IsTombstoned(GetId(node), time.Minute) {
+		t.Error("Expected the node to be kept out by the tombstone")
+	}
+
+	// a ping after the leave re-adds the node
+	txn := db.Txn(true)
+	if err := txn.Insert("tombstone", &data.Tombstone{Id: GetId(node), Name: node.Name, Ts: time.Now().Add(-2 * time.Second).Unix()}); err != nil {
+		t.Fatal(err)
+	}
+	txn.Commit()
+	if _, err := s.Ping(context.Background(), node); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.GetNode(GetId(node)); !ok || db.IsTombstoned(GetId(node), time.Minute) {
+		t.Error("Expected the node to be re-added by a ping after the leave")
+	}
+}
+
+func Test_RttPayload(t *testing.T) {
+	s := &MeshServer{}
+
+	res, err := s.Rtt(context.Background(), &meshv1.RttRequest{Payload: make([]byte, 1024)})
+	if err != nil || len(res.Payload) != 0 {
+		t.Errorf("Expected the payload to be discarded, got %v bytes, error %v", len(res.Payload), err)
+	}
+	res, err = s.Rtt(context.Background(), &meshv1.RttRequest{Payload: make([]byte, 1024), Echo: true})
+	if err != nil || len(res.Payload) != 1024 {
+		t.Errorf("Expected the payload to be echoed, got %v bytes, error %v", len(res.Payload), err)
+	}
+	_, err = s.Rtt(context.Background(), &meshv1.RttRequest{Payload: make([]byte, data.MAX_RTT_PAYLOAD_SIZE+1)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected invalid argument error for an oversized payload, got %v", err)
+	}
+}
+
+func Test_serverLimitsMaxRecvMsgSize(t *testing.T) {
+	m := testMesh(time.Second)
+	m.setupConfig.MaxRecvMsgSize = MIN_RECV_MSG_SIZE
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(m.serverLimits()...)
+	meshv1.RegisterMeshServiceServer(server, &MeshServer{})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := meshv1.NewMeshServiceClient(conn)
+
+	// the max. payload fits, a larger message is rejected before it reaches the handler
+	if _, err := client.Rtt(context.Background(), &meshv1.RttRequest{Payload: make([]byte, data.MAX_RTT_PAYLOAD_SIZE)}); err != nil {
+		t.Errorf("Expected the max. payload to be received, got %v", err)
+	}
+	_, err = client.Rtt(context.Background(), &meshv1.RttRequest{Payload: make([]byte, MIN_RECV_MSG_SIZE)})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected resource exhausted for an oversized message, got %v", err)
+	}
+
+	// the limit has to fit the max. RTT payload
+	m.setupConfig.MaxRecvMsgSize = data.MAX_RTT_PAYLOAD_SIZE
+	if _, err := newMesh(&RoutineConfiguration{}, m.setupConfig, zap.NewNop().Sugar()); err == nil || !strings.Contains(err.Error(), "message size") {
+		t.Errorf("Expected an error of a max. inbound message size below the RTT payload, got %v", err)
+	}
+}
+
+func Test_SampleFilterNegotiation(t *testing.T) {
+	serverDb, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "server"
+	discovered := make(chan NodeDiscovered, 1)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(server, &MeshServer{
+		metrics:           metric.InitMetrics(),
+		log:               zap.NewNop().Sugar(),
+		data:              &serverDb,
+		name:              newNodeName(name),
+		newNodeDiscovered: discovered,
+		draining:          &atomic.Bool{},
+		sampleFilter:      data.NewSampleFilter([]int64{data.RTT_REQUEST}),
+	})
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+
+	m := testMesh(time.Second)
+	m.database, err = data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.routineConfig.JoinSettleTimeout = time.Second
+	m.setupConfig.AcceptSamples = []string{"rtt_total"}
+	if joined, _ := m.Join([]string{lis.Addr().String()}); !joined {
+		t.Fatal("could not join")
+	}
+
+	// the server knows the filter of the joined node
+	joinedNode := (<-discovered).NewNode
+	if filter := data.ConvertSampleFilter(joinedNode.SampleFilter); filter.Accepts(data.RTT_REQUEST) || !filter.Accepts(data.RTT_TOTAL) {
+		t.Errorf("unexpected filter of the joined node %+v", filter)
+	}
+	// the joined node knows the filter of the server
+	serverNode, ok := m.database.GetNodeByName(name)
+	if !ok {
+		t.Fatal("server node is not stored")
+	}
+	if serverNode.SampleFilter.Accepts(data.RTT_TOTAL) || !serverNode.SampleFilter.Accepts(data.RTT_REQUEST) {
+		t.Errorf("unexpected filter of the server %+v", serverNode.SampleFilter)
+	}
+
+	// just the accepted & unknown samples are pushed
+	ts := time.Now().Unix()
+	for _, key := range []int64{data.RTT_TOTAL, data.RTT_REQUEST, 999} {
+		m.database.SetSample(&data.Sample{From: "test", To: name, Key: key, Value: "1", Ts: ts})
+	}
+	if err := m.pushSamples(serverNode.Convert()); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[int64]bool{}
+	for _, sample := range serverDb.GetSampleList() {
+		keys[sample.Key] = true
+	}
+	if keys[data.RTT_TOTAL] || !keys[data.RTT_REQUEST] || !keys[999] {
+		t.Errorf("unexpected pushed sample keys %v", keys)
+	}
+}
+
+func Test_PingIndirect(t *testing.T) {
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetNode(&data.Node{Id: GetId(&meshv1.Node{Target: "b:8081"}), Name: "b", Target: "b:8081", State: NODE_SUSPECT})
+
+	var pinged *meshv1.Node
+	var pingErr error
+	var remaining time.Duration
+	s := &MeshServer{data: &db, ping: func(ctx context.Context, node *meshv1.Node) error {
+		pinged = node
+		if deadline, ok := ctx.Deadline(); ok {
+			remaining = time.Until(deadline)
+		}
+		return pingErr
+	}}
+
+	if _, err := s.PingIndirect(context.Background(), &meshv1.PingIndirectRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected invalid argument error, got %v", err)
+	}
+	if _, err := s.PingIndirect(context.Background(), &meshv1.PingIndirectRequest{Target: &meshv1.Node{Target: "c:8081"}}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected not found error for an unknown node, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := s.PingIndirect(ctx, &meshv1.PingIndirectRequest{Target: &meshv1.Node{Name: "b", Target: "b:8081"}})
+	if err != nil || !res.Reachable {
+		t.Errorf("Expected a reachable node, got %v, error %v", res, err)
+	}
+	if pinged == nil || pinged.Target != "b:8081" {
+		t.Errorf("Expected the stored node to be pinged, got %v", pinged)
+	}
+	if remaining <= 0 || remaining > 500*time.Millisecond {
+		t.Errorf("Expected the ping bound by half of the request time, got %v", remaining)
+	}
+
+	pingErr = errors.New("unreachable")
+	res, err = s.PingIndirect(context.Background(), &meshv1.PingIndirectRequest{Target: &meshv1.Node{Target: "b:8081"}})
+	if err != nil || res.Reachable {
+		t.Errorf("Expected an unreachable node, got %v, error %v", res, err)
+	}
+}
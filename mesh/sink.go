@@ -0,0 +1,246 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// Formats of the samples published by the sample sink
+const (
+	SINK_FORMAT_JSON     = "json"
+	SINK_FORMAT_PROTOBUF = "protobuf"
+)
+
+// Defaults of the sample sink
+const (
+	DEFAULT_SINK_BUFFER_SIZE    = 10000
+	DEFAULT_SINK_BATCH_SIZE     = 100
+	DEFAULT_SINK_FLUSH_INTERVAL = time.Second
+)
+
+// A message published by the sample sink, e.g. a Kafka record
+type SinkMessage struct {
+	Topic string
+	Key   []byte
+	Value []byte
+}
+
+// Producer of the sample sink, e.g. wrapping a Kafka client.
+// Produce is called by the sink routine only, with at most
+// one batch at a time.
+type SampleProducer interface {
+	Produce(ctx context.Context, messages []SinkMessage) error
+}
+
+// JSON format of a published sample
+type sinkSample struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Key   int64  `json:"key"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Ts    int64  `json:"ts"`
+	// Sub-fields of a multi-field sample
+	Fields map[string]string `json:"fields,omitempty"`
+	// Probe group of the measuring node
+	Group string `json:"group,omitempty"`
+}
+
+// Sink publishing the samples asynchronously in batches.
+// The samples are buffered up to the buffer size, on overflow
+// the oldest samples are dropped, so the sink never blocks the
+// routines storing the samples.
+type sampleSink struct {
+	producer      SampleProducer
+	topic         string
+	key           *nodeName
+	format        string
+	bufferSize    int
+	batchSize     int
+	flushInterval time.Duration
+	timeout       time.Duration
+
+	mu     sync.Mutex
+	buffer []*data.Sample
+	notify chan struct{}
+
+	metrics metric.Metrics
+	log     *zap.SugaredLogger
+}
+
+// Create the sample sink of the setup configuration, nil if neither
+// a producer nor Kafka brokers are set. A set producer takes precedence.
+func newSampleSink(setupConfig *SetupConfiguration, name *nodeName, timeout time.Duration, metrics metric.Metrics, log *zap.SugaredLogger) (*sampleSink, error) {
+	if setupConfig.SampleProducer == nil && len(setupConfig.SinkKafkaBrokers) == 0 {
+		return nil, nil
+	}
+	s := &sampleSink{
+		producer:      setupConfig.SampleProducer,
+		topic:         setupConfig.SinkTopic,
+		key:           name,
+		format:        setupConfig.SinkFormat,
+		bufferSize:    setupConfig.SinkBufferSize,
+		batchSize:     setupConfig.SinkBatchSize,
+		flushInterval: setupConfig.SinkFlushInterval,
+		timeout:       timeout,
+		notify:        make(chan struct{}, 1),
+		metrics:       metrics,
+		log:           log,
+	}
+	if s.format == "" {
+		s.format = SINK_FORMAT_JSON
+	}
+	if s.format != SINK_FORMAT_JSON && s.format != SINK_FORMAT_PROTOBUF {
+		return nil, fmt.Errorf("unknown sink format %v, please use json or protobuf", s.format)
+	}
+	if s.bufferSize <= 0 {
+		s.bufferSize = DEFAULT_SINK_BUFFER_SIZE
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = DEFAULT_SINK_BATCH_SIZE
+	}
+	if s.flushInterval <= 0 {
+		s.flushInterval = DEFAULT_SINK_FLUSH_INTERVAL
+	}
+	if s.producer == nil {
+		if s.topic == "" {
+			return nil, errors.New("a sink topic is required to publish the samples to Kafka")
+		}
+		s.producer = newKafkaProducer(setupConfig.SinkKafkaBrokers, s.batchSize)
+	}
+	return s, nil
+}
+
+// Buffer a sample to be published, the oldest sample is dropped if the buffer is full
+func (s *sampleSink) emit(sample *data.Sample) {
+	copied := *sample
+	s.mu.Lock()
+	if len(s.buffer) >= s.bufferSize {
+		s.buffer = s.buffer[1:]
+		s.metrics.GetSinkDropped().WithLabelValues(metric.SINK_DROP_OVERFLOW).Inc()
+	}
+	s.buffer = append(s.buffer, &copied)
+	full := len(s.buffer) >= s.batchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Take the next batch of the buffer
+func (s *sampleSink) next() []*data.Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.buffer)
+	if n > s.batchSize {
+		n = s.batchSize
+	}
+	batch := s.buffer[:n:n]
+	s.buffer = s.buffer[n:]
+	return batch
+}
+
+// Publish the buffered samples in batches if a batch is full
+// or the flush interval is reached, until the context is done
+func (s *sampleSink) run(ctx context.Context) {
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.notify:
+		}
+		for batch := s.next(); len(batch) > 0; batch = s.next() {
+			s.publish(ctx, batch)
+		}
+	}
+}
+
+// Publish a batch, the samples of a failed batch are dropped
+func (s *sampleSink) publish(ctx context.Context, batch []*data.Sample) {
+	messages := make([]SinkMessage, 0, len(batch))
+	key := []byte(s.key.get())
+	for _, sample := range batch {
+		value, err := s.encode(sample)
+		if err != nil {
+			s.log.Debugw("Could not encode sample", "error", err)
+			s.metrics.GetSinkDropped().WithLabelValues(metric.SINK_DROP_ERROR).Inc()
+			continue
+		}
+		messages = append(messages, SinkMessage{Topic: s.topic, Key: key, Value: value})
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	if err := s.producer.Produce(ctx, messages); err != nil {
+		s.log.Warnw("Could not publish samples", "samples", len(messages), "error", err)
+		s.metrics.GetSinkDropped().WithLabelValues(metric.SINK_DROP_ERROR).Add(float64(len(messages)))
+		return
+	}
+	s.metrics.GetSinkPublished().Add(float64(len(messages)))
+}
+
+// Encode a sample in the format of the sink
+func (s *sampleSink) encode(sample *data.Sample) ([]byte, error) {
+	if s.format == SINK_FORMAT_PROTOBUF {
+		return proto.Marshal(&meshv1.Sample{
+			From:   sample.From,
+			To:     sample.To,
+			Key:    sample.Key,
+			Value:  sample.Value,
+			Ts:     sample.Ts,
+			Hops:   sample.Hops,
+			FromId: sample.FromId,
+			ToId:   sample.ToId,
+			Fields: sample.Fields,
+			Group:  sample.Group,
+		})
+	}
+	return json.Marshal(sinkSample{
+		From:   sample.From,
+		To:     sample.To,
+		Key:    sample.Key,
+		Type:   data.SampleName(sample.Key),
+		Value:  sample.Value,
+		Ts:     sample.Ts,
+		Fields: sample.Fields,
+		Group:  sample.Group,
+	})
+}
@@ -0,0 +1,191 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+type testProducer struct {
+	mu       sync.Mutex
+	err      error
+	messages []SinkMessage
+	produced chan struct{}
+}
+
+func (p *testProducer) Produce(ctx context.Context, messages []SinkMessage) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.messages = append(p.messages, messages...)
+	}
+	p.produced <- struct{}{}
+	return p.err
+}
+
+func testSink(t *testing.T, producer SampleProducer, format string, bufferSize int) *sampleSink {
+	setupConfig := &SetupConfiguration{
+		Name:           "a",
+		SampleProducer: producer,
+		SinkTopic:      "samples",
+		SinkFormat:     format,
+		SinkBufferSize: bufferSize,
+		SinkBatchSize:  2,
+	}
+	s, err := newSampleSink(setupConfig, newNodeName(setupConfig.Name), time.Second, metric.InitMetrics(), zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func Test_sampleSinkOverflow(t *testing.T) {
+	s := testSink(t, &testProducer{}, SINK_FORMAT_JSON, 2)
+	for _, value := range []string{"1", "2", "3"} {
+		s.emit(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: value})
+	}
+
+	batch := s.next()
+	if len(batch) != 2 || batch[0].Value != "2" || batch[1].Value != "3" {
+		t.Errorf("Expected the oldest sample to be dropped, got %+v", batch)
+	}
+	if dropped := gatherValue(t, s.metrics, "sink_dropped_samples_total", "reason", metric.SINK_DROP_OVERFLOW); dropped != 1 {
+		t.Errorf("Expected 1 dropped sample, got %v", dropped)
+	}
+}
+
+func Test_sampleSinkPublish(t *testing.T) {
+	producer := &testProducer{produced: make(chan struct{}, 1)}
+	s := testSink(t, producer, SINK_FORMAT_JSON, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.run(ctx)
+
+	// a full batch is published without waiting for the flush interval
+	s.emit(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Ts: 1})
+	s.emit(&data.Sample{From: "a", To: "c", Key: data.RTT_TOTAL, Value: "2", Ts: 1})
+	<-producer.produced
+
+	producer.mu.Lock()
+	defer producer.mu.Unlock()
+	if len(producer.messages) != 2 {
+		t.Fatalf("Expected 2 published samples, got %v", len(producer.messages))
+	}
+	message := producer.messages[0]
+	if message.Topic != "samples" || string(message.Key) != "a" {
+		t.Errorf("Expected topic samples & key a, got %v & %s", message.Topic, message.Key)
+	}
+	var sample sinkSample
+	if err := json.Unmarshal(message.Value, &sample); err != nil {
+		t.Fatal(err)
+	}
+	if sample.To != "b" || sample.Type != "rtt_total" || sample.Value != "1" {
+		t.Errorf("Unexpected published sample %+v", sample)
+	}
+}
+
+func Test_sampleSinkError(t *testing.T) {
+	producer := &testProducer{err: errors.New("broker down"), produced: make(chan struct{}, 1)}
+	s := testSink(t, producer, SINK_FORMAT_PROTOBUF, 10)
+	s.emit(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1"})
+
+	s.publish(context.Background(), s.next())
+	if dropped := gatherValue(t, s.metrics, "sink_dropped_samples_total", "reason", metric.SINK_DROP_ERROR); dropped != 1 {
+		t.Errorf("Expected 1 sample dropped by error, got %v", dropped)
+	}
+}
+
+func Test_sampleSinkProtobuf(t *testing.T) {
+	s := testSink(t, &testProducer{}, SINK_FORMAT_PROTOBUF, 10)
+	value, err := s.encode(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Hops: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sample meshv1.Sample
+	if err := proto.Unmarshal(value, &sample); err != nil {
+		t.Fatal(err)
+	}
+	if sample.To != "b" || sample.Key != data.RTT_TOTAL || sample.Hops != 1 {
+		t.Errorf("Unexpected published sample %+v", &sample)
+	}
+}
+
+func Test_newSampleSink(t *testing.T) {
+	s, err := newSampleSink(&SetupConfiguration{}, newNodeName(""), time.Second, metric.InitMetrics(), zap.NewNop().Sugar())
+	if s != nil || err != nil {
+		t.Errorf("Expected no sink without producer, got %v, error %v", s, err)
+	}
+	_, err = newSampleSink(&SetupConfiguration{SampleProducer: &testProducer{}, SinkFormat: "avro"}, newNodeName(""), time.Second, metric.InitMetrics(), zap.NewNop().Sugar())
+	if err == nil {
+		t.Error("Expected an error of an unknown format")
+	}
+}
+
+func Test_newSampleSinkKafka(t *testing.T) {
+	producer := &testProducer{}
+	tests := []struct {
+		name     string
+		config   SetupConfiguration
+		wantErr  bool
+		producer SampleProducer
+	}{
+		{name: "brokers without topic", config: SetupConfiguration{SinkKafkaBrokers: []string{"localhost:9092"}}, wantErr: true},
+		{name: "brokers with topic", config: SetupConfiguration{SinkKafkaBrokers: []string{"localhost:9092"}, SinkTopic: "samples"}},
+		{name: "producer takes precedence", config: SetupConfiguration{SinkKafkaBrokers: []string{"localhost:9092"}, SampleProducer: producer}, producer: producer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newSampleSink(&tt.config, newNodeName("a"), time.Second, metric.InitMetrics(), zap.NewNop().Sugar())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newSampleSink() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.producer != nil {
+				if s.producer != tt.producer {
+					t.Errorf("Expected the producer of the embedder, got %T", s.producer)
+				}
+				return
+			}
+			kafka, ok := s.producer.(*kafkaProducer)
+			if !ok {
+				t.Fatalf("Expected a Kafka producer, got %T", s.producer)
+			}
+			if kafka.writer.Addr.String() != "localhost:9092" || kafka.writer.BatchSize != DEFAULT_SINK_BATCH_SIZE {
+				t.Errorf("Expected the brokers & the batch size of the sink, got %v & %v", kafka.writer.Addr, kafka.writer.BatchSize)
+			}
+		})
+	}
+}
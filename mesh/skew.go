@@ -0,0 +1,66 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+)
+
+// Clock skew to a node above the threshold is logged as warning
+const CLOCK_SKEW_WARN_THRESHOLD = time.Second
+
+// Estimate the clock skew of a node by its wall-clock time
+// compared to the middle of the request.
+// The bool is false if the node did not send its time.
+func clockSkew(start time.Time, end time.Time, peerTime int64) (time.Duration, bool) {
+	if peerTime == 0 {
+		return 0, false
+	}
+	middle := start.Add(end.Sub(start) / 2)
+	return time.Unix(0, peerTime).Sub(middle), true
+}
+
+// Save the clock skew to a node as sample.
+// Diagnostic only, no timing measurement is corrected.
+func (m *Mesh) observeClockSkew(node *meshv1.Node, start time.Time, end time.Time, peerTime int64) {
+	skew, ok := clockSkew(start, end, peerTime)
+	if !ok {
+		return
+	}
+	if skew > CLOCK_SKEW_WARN_THRESHOLD || skew < -CLOCK_SKEW_WARN_THRESHOLD {
+		m.logger.Named("ping-routine").Warnw("Large clock skew to node - timestamp based logic is not reliable", "node", node.Name, "skew", skew.String())
+	}
+
+	m.database.SetSample(&data.Sample{
+		From:   m.name.get(),
+		To:     node.Name,
+		Key:    data.CLOCK_SKEW,
+		Value:  strconv.FormatInt(skew.Nanoseconds(), 10),
+		Ts:     time.Now().Unix(),
+		FromId: m.nodeId(),
+		ToId:   GetId(node),
+	})
+}
@@ -0,0 +1,52 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_clockSkew(t *testing.T) {
+	start := time.Unix(100, 0)
+	end := start.Add(time.Second * 2)
+
+	tests := []struct {
+		name     string
+		peerTime int64
+		expected time.Duration
+		expectOk bool
+	}{
+		{name: "in sync", peerTime: start.Add(time.Second).UnixNano(), expected: 0, expectOk: true},
+		{name: "peer ahead", peerTime: start.Add(time.Second * 6).UnixNano(), expected: time.Second * 5, expectOk: true},
+		{name: "peer behind", peerTime: start.Add(-time.Second).UnixNano(), expected: -time.Second * 2, expectOk: true},
+		{name: "no peer time", peerTime: 0, expectOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skew, ok := clockSkew(start, end, tt.peerTime)
+			if ok != tt.expectOk || skew != tt.expected {
+				t.Errorf("got %v %v, expected %v %v", skew, ok, tt.expected, tt.expectOk)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// Dialer of the outbound connections, TCP_NODELAY of the TCP connections is
+// disabled after the dial if not set. Go enables TCP_NODELAY on every TCP
+// connection, so small writes are not delayed by Nagle's algorithm.
+type socketDialer struct {
+	net.Dialer
+	noDelay bool
+	log     *zap.SugaredLogger
+}
+
+func (d *socketDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil || d.noDelay {
+		return conn, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		if err := tcp.SetNoDelay(false); err != nil {
+			d.log.Warnw("Could not disable TCP_NODELAY", "address", address, "error", err)
+		}
+	}
+	return conn, nil
+}
+
+// Validate the socket buffer sizes in bytes, 0 keeps the OS default
+func validateSocketBuffers(send int, recv int) error {
+	if send < 0 || recv < 0 {
+		return fmt.Errorf("invalid socket buffer sizes send %v, recv %v, have to be positive or 0", send, recv)
+	}
+	return nil
+}
+
+// Dialer control to set the send & receive buffer sizes of the socket, 0 keeps the OS default.
+// If the sizes cannot be set, the connection keeps the OS default.
+func bufferControl(send int, recv int, log *zap.SugaredLogger) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if err := setSocketBuffers(c, send, recv); err != nil {
+			log.Warnw("Could not set socket buffer sizes - using OS default", "send", send, "recv", recv, "address", address, "error", err)
+		}
+		return nil
+	}
+}
+
+// Chain the dialer controls, nil if there is no control
+func chainControls(controls ...func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	if len(controls) == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		for _, control := range controls {
+			if err := control(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_validateSocketBuffers(t *testing.T) {
+	tests := []struct {
+		name    string
+		send    int
+		recv    int
+		wantErr bool
+	}{
+		{name: "OS default", send: 0, recv: 0},
+		{name: "sizes", send: 1 << 20, recv: 1 << 20},
+		{name: "negative send buffer", send: -1, recv: 0, wantErr: true},
+		{name: "negative recv buffer", send: 0, recv: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSocketBuffers(tt.send, tt.recv); (err != nil) != tt.wantErr {
+				t.Errorf("validateSocketBuffers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_chainControls(t *testing.T) {
+	if chainControls() != nil {
+		t.Error("Expected no control without controls")
+	}
+
+	var called []int
+	failed := errors.New("failed")
+	control := chainControls(
+		func(network, address string, c syscall.RawConn) error { called = append(called, 1); return nil },
+		func(network, address string, c syscall.RawConn) error { called = append(called, 2); return failed },
+		func(network, address string, c syscall.RawConn) error { called = append(called, 3); return nil },
+	)
+	if err := control("tcp", "localhost:0", nil); !errors.Is(err, failed) {
+		t.Errorf("Expected the error of the failed control, got %v", err)
+	}
+	if len(called) != 2 || called[0] != 1 || called[1] != 2 {
+		t.Errorf("Expected the controls called in order until the error, got %v", called)
+	}
+}
+
+func Test_dialerSocketTuning(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	m := testMesh(time.Second)
+	m.setupConfig.TcpNoDelay = false
+	m.setupConfig.SocketSendBuffer = 64 << 10
+	m.setupConfig.SocketRecvBuffer = 64 << 10
+
+	// the connection is dialed with Nagle's algorithm & the buffer sizes
+	d := m.dialer(DSCP_RTT)
+	if d.noDelay || d.Control == nil {
+		t.Fatalf("Expected a tuned dialer, got %+v", d)
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not dial with the tuned dialer: %v", err)
+	}
+	conn.Close()
+
+	// nothing to control by default
+	m.setupConfig.TcpNoDelay = true
+	m.setupConfig.SocketSendBuffer = 0
+	m.setupConfig.SocketRecvBuffer = 0
+	if d := m.dialer(DSCP_RTT); !d.noDelay || d.Control != nil {
+		t.Errorf("Expected an untuned dialer, got %+v", d)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	h "github.com/telekom/canary-bot/helper"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Min. time until the seed targets of a SRV record will be resolved again
+const minSrvRefresh = time.Second * 5
+
+// Order modes of the join targets
+const (
+	TARGET_SHUFFLE_NONE   = "none"
+	TARGET_SHUFFLE_RANDOM = "random"
+	TARGET_SHUFFLE_NAME   = "name"
+)
+
+// Get the targets to join the mesh.
+// If a Kubernetes service is configured, the targets are the pods of the service.
+// If a SRV record is configured, the targets will be resolved from it
+// and cached for the TTL of the record. The static targets are used
+// if no SRV record is configured or the resolution fails.
+func (m *Mesh) joinTargets() []string {
+	if m.setupConfig.TargetK8sService != "" {
+		return m.k8sJoinTargets()
+	}
+	if m.setupConfig.TargetSrv == "" {
+		return m.setupConfig.Targets
+	}
+	if time.Now().Before(m.srvExpiry) && len(m.srvTargets) > 0 {
+		return m.srvTargets
+	}
+
+	log := m.logger.Named("join-routine")
+	ctx, cancel := context.WithTimeout(context.Background(), m.routineConfig.RequestTimeout)
+	defer cancel()
+	targets, ttl, err := lookupSrv(ctx, m.setupConfig.TargetSrv)
+	if err != nil || len(targets) == 0 {
+		log.Warnw("Could not resolve SRV record - using static targets", "srv", m.setupConfig.TargetSrv, "error", err)
+		return m.setupConfig.Targets
+	}
+	if ttl < minSrvRefresh {
+		ttl = minSrvRefresh
+	}
+
+	log.Debugw("Resolved SRV record", "srv", m.setupConfig.TargetSrv, "targets", targets, "ttl", ttl.String())
+	m.srvTargets = targets
+	m.srvExpiry = time.Now().Add(ttl)
+	return targets
+}
+
+// Get the join targets in the configured order, so not every node
+// tries the first seed first. The seed of the name mode is the hash of
+// the node name, the order of a node is the same on every join.
+// A shuffled copy is returned, the configured and cached targets are kept.
+func (m *Mesh) shuffleTargets(targets []string) []string {
+	if m.setupConfig.TargetShuffle == TARGET_SHUFFLE_NONE || m.setupConfig.TargetShuffle == "" || len(targets) < 2 {
+		return targets
+	}
+
+	seed := time.Now().UnixNano()
+	if m.setupConfig.TargetShuffle == TARGET_SHUFFLE_NAME {
+		hash, _ := h.Hash(m.name.get())
+		seed = int64(hash)
+	}
+	shuffled := append([]string{}, targets...)
+	rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
+}
+
+// Resolve a SRV record to a list of host:port targets and the min. TTL of the answers.
+// The nameserver of /etc/resolv.conf is queried directly to get the TTL,
+// the system resolver is used as fallback with a TTL of 0.
+func lookupSrv(ctx context.Context, name string) ([]string, time.Duration, error) {
+	targets, ttl, err := querySrv(ctx, nameserver(), name)
+	if err == nil {
+		return targets, ttl, nil
+	}
+
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, 0, err
+	}
+	targets = []string{}
+	for _, srv := range srvs {
+		targets = append(targets, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+	}
+	return targets, 0, nil
+}
+
+// Query a SRV record at the nameserver
+func querySrv(ctx context.Context, server string, name string) ([]string, time.Duration, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, 0, err
+		}
+	}
+	if _, err = conn.Write(packed); err != nil {
+		return nil, 0, err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	var res dnsmessage.Message
+	if err = res.Unpack(buf[:n]); err != nil {
+		return nil, 0, err
+	}
+	if res.ID != id || res.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, errors.New("invalid SRV response: " + res.RCode.String())
+	}
+	if res.Truncated {
+		return nil, 0, errors.New("truncated SRV response")
+	}
+
+	var targets []string
+	var ttl uint32
+	for _, answer := range res.Answers {
+		srv, ok := answer.Body.(*dnsmessage.SRVResource)
+		if !ok {
+			continue
+		}
+		if len(targets) == 0 || answer.Header.TTL < ttl {
+			ttl = answer.Header.TTL
+		}
+		targets = append(targets, net.JoinHostPort(strings.TrimSuffix(srv.Target.String(), "."), strconv.Itoa(int(srv.Port))))
+	}
+	if len(targets) == 0 {
+		return nil, 0, errors.New("no SRV answers")
+	}
+	return targets, time.Duration(ttl) * time.Second, nil
+}
+
+// Get the first nameserver of /etc/resolv.conf
+func nameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "127.0.0.1:53"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return "127.0.0.1:53"
+}
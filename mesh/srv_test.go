@@ -0,0 +1,122 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS server answering every query with two SRV records
+func startSrvServer(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			res := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true},
+				Questions: query.Questions,
+			}
+			for i, ttl := range []uint32{300, 60} {
+				res.Answers = append(res.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: query.Questions[0].Name, Type: dnsmessage.TypeSRV, Class: dnsmessage.ClassINET, TTL: ttl},
+					Body:   &dnsmessage.SRVResource{Port: uint16(8080 + i), Target: dnsmessage.MustNewName("node" + string(rune('a'+i)) + ".example.com.")},
+				})
+			}
+			packed, err := res.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func Test_querySrv(t *testing.T) {
+	server := startSrvServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	targets, ttl, err := querySrv(ctx, server, "_canary._tcp.example.com")
+	if err != nil {
+		t.Fatalf("error occured: %v", err)
+	}
+	if diff := deep.Equal(targets, []string{"nodea.example.com:8080", "nodeb.example.com:8081"}); diff != nil {
+		t.Error(diff)
+	}
+	if ttl != time.Minute {
+		t.Errorf("ttl is %v, expected the min. ttl of the answers %v", ttl, time.Minute)
+	}
+}
+
+func Test_shuffleTargets(t *testing.T) {
+	targets := []string{"a:8081", "b:8081", "c:8081", "d:8081", "e:8081", "f:8081"}
+	configured := append([]string{}, targets...)
+
+	m := testMesh(time.Second)
+	m.setupConfig.TargetShuffle = TARGET_SHUFFLE_NONE
+	if diff := deep.Equal(m.shuffleTargets(targets), configured); diff != nil {
+		t.Errorf("Expected the listed order: %v", diff)
+	}
+
+	m.setupConfig.TargetShuffle = TARGET_SHUFFLE_NAME
+	first := m.shuffleTargets(targets)
+	if diff := deep.Equal(m.shuffleTargets(targets), first); diff != nil {
+		t.Errorf("Expected the same order for the same name: %v", diff)
+	}
+	if diff := deep.Equal(targets, configured); diff != nil {
+		t.Errorf("Expected the configured targets to be kept: %v", diff)
+	}
+
+	differs := false
+	for i := 0; i < 10 && !differs; i++ {
+		m.name.set("node-" + string(rune('a'+i)))
+		differs = deep.Equal(m.shuffleTargets(targets), first) != nil
+	}
+	if !differs {
+		t.Error("Expected another order for other names")
+	}
+
+	m.setupConfig.TargetShuffle = TARGET_SHUFFLE_RANDOM
+	if len(m.shuffleTargets(targets)) != len(targets) {
+		t.Error("Expected all targets")
+	}
+}
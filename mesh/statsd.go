@@ -0,0 +1,157 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	"go.uber.org/zap"
+)
+
+// Formats of the StatsD sink
+const (
+	STATSD_FORMAT_STATSD    = "statsd"
+	STATSD_FORMAT_DOGSTATSD = "dogstatsd"
+)
+
+// Metrics buffered by the StatsD sink before they are dropped
+const STATSD_BUFFER_SIZE = 1000
+
+// Replaces the characters of the StatsD protocol in names & tags
+var statsdReplacer = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+// Sink sending the samples measured by this node to a StatsD endpoint over UDP.
+// The metrics are buffered and sent by the sink routine, on a full buffer
+// or a failed write the metrics are dropped, so the sink never blocks.
+type statsdSink struct {
+	conn   net.Conn
+	prefix string
+	format string
+	node   *nodeName
+	lines  chan string
+
+	metrics metric.Metrics
+	log     *zap.SugaredLogger
+}
+
+// Create the StatsD sink of the setup configuration, nil if no address is set
+func newStatsdSink(setupConfig *SetupConfiguration, name *nodeName, metrics metric.Metrics, log *zap.SugaredLogger) (*statsdSink, error) {
+	if setupConfig.StatsdAddress == "" {
+		return nil, nil
+	}
+	format := setupConfig.StatsdFormat
+	if format == "" {
+		format = STATSD_FORMAT_STATSD
+	}
+	if format != STATSD_FORMAT_STATSD && format != STATSD_FORMAT_DOGSTATSD {
+		return nil, fmt.Errorf("unknown StatsD format %v, please use statsd or dogstatsd", format)
+	}
+	// UDP is connectionless, the dial just resolves the address
+	conn, err := net.Dial("udp", setupConfig.StatsdAddress)
+	if err != nil {
+		return nil, fmt.Errorf("StatsD address %v: %w", setupConfig.StatsdAddress, err)
+	}
+	return &statsdSink{
+		conn:    conn,
+		prefix:  setupConfig.StatsdPrefix,
+		format:  format,
+		node:    name,
+		lines:   make(chan string, STATSD_BUFFER_SIZE),
+		metrics: metrics,
+		log:     log,
+	}, nil
+}
+
+// Sample types with signed values, e.g. a clock ahead or behind.
+// StatsD timings are durations, the signed types are sent as gauges.
+var statsdSignedTypes = map[int64]bool{
+	data.CLOCK_SKEW:    true,
+	data.ONE_WAY_DELAY: true,
+}
+
+// Buffer the metric of a sample, the metric is dropped if the buffer is full.
+// Values in ns are sent in ms: durations as timings, signed types as gauges.
+// Other numeric values are sent as gauges and failed probes (NaN) as
+// counter TYPE_failures. Non-numeric values are skipped.
+func (s *statsdSink) emit(sample *data.Sample) {
+	value, err := strconv.ParseFloat(sample.Value, 64)
+	if err != nil {
+		return
+	}
+	name := data.SampleName(sample.Key)
+	t, _ := data.GetSampleType(sample.Key)
+	if t.Unit == "ns" && !math.IsNaN(value) {
+		value /= 1e6
+	}
+	formatted := strconv.FormatFloat(value, 'f', -1, 64)
+
+	var lines []string
+	switch {
+	case math.IsNaN(value):
+		lines = append(lines, s.line(name+"_failures", sample.To, name, "1|c"))
+	case t.Unit == "ns" && !statsdSignedTypes[sample.Key] && value >= 0:
+		lines = append(lines, s.line(name, sample.To, name, formatted+"|ms"))
+	case value < 0 && s.format == STATSD_FORMAT_STATSD:
+		// a signed gauge is a relative change in StatsD, it is reset first
+		lines = append(lines, s.line(name, sample.To, name, "0|g"), s.line(name, sample.To, name, formatted+"|g"))
+	default:
+		lines = append(lines, s.line(name, sample.To, name, formatted+"|g"))
+	}
+
+	for _, line := range lines {
+		select {
+		case s.lines <- line:
+		default:
+			s.metrics.GetStatsdDropped().WithLabelValues(metric.SINK_DROP_OVERFLOW).Inc()
+		}
+	}
+}
+
+// Format a metric line, tagged by node, peer & sample type with DogStatsD.
+// The plain StatsD format has no tags, node & peer are part of the name.
+func (s *statsdSink) line(name string, peer string, sampleType string, value string) string {
+	node, peer := statsdReplacer.Replace(s.node.get()), statsdReplacer.Replace(peer)
+	if s.prefix != "" {
+		name = s.prefix + "." + name
+	}
+	if s.format == STATSD_FORMAT_DOGSTATSD {
+		return name + ":" + value + "|#node:" + node + ",peer:" + peer + ",type:" + sampleType
+	}
+	dots := strings.NewReplacer(".", "_")
+	return name + "." + dots.Replace(node) + "." + dots.Replace(peer) + ":" + value
+}
+
+// Send the buffered metrics, one metric per datagram
+func (s *statsdSink) run() {
+	for line := range s.lines {
+		if _, err := s.conn.Write([]byte(line)); err != nil {
+			s.log.Debugw("Could not send metric", "error", err)
+			s.metrics.GetStatsdDropped().WithLabelValues(metric.SINK_DROP_ERROR).Inc()
+		}
+	}
+}
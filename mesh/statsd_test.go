@@ -0,0 +1,117 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"github.com/telekom/canary-bot/metric"
+	"go.uber.org/zap"
+)
+
+func Test_statsdSink(t *testing.T) {
+	samples := []*data.Sample{
+		{From: "node-1", To: "node.2", Key: data.RTT_TOTAL, Value: "1500000"},
+		{From: "node-1", To: "node.2", Key: data.HEALTH_SCORE, Value: "0.5"},
+		{From: "node-1", To: "node.2", Key: data.RTT_TOTAL, Value: "NaN"},
+		{From: "node-1", To: "node.2", Key: data.CLOCK_SKEW, Value: "2500000"},
+		{From: "node-1", To: "node.2", Key: data.ONE_WAY_DELAY, Value: "-500000"},
+	}
+	tests := []struct {
+		name     string
+		format   string
+		expected []string
+	}{
+		{name: "statsd", format: STATSD_FORMAT_STATSD, expected: []string{
+			"canary.rtt_total.node-1.node_2:1.5|ms",
+			"canary.health_score.node-1.node_2:0.5|g",
+			"canary.rtt_total_failures.node-1.node_2:1|c",
+			"canary.clock_skew.node-1.node_2:2.5|g",
+			"canary.one_way_delay.node-1.node_2:0|g",
+			"canary.one_way_delay.node-1.node_2:-0.5|g",
+		}},
+		{name: "dogstatsd", format: STATSD_FORMAT_DOGSTATSD, expected: []string{
+			"canary.rtt_total:1.5|ms|#node:node-1,peer:node.2,type:rtt_total",
+			"canary.health_score:0.5|g|#node:node-1,peer:node.2,type:health_score",
+			"canary.rtt_total_failures:1|c|#node:node-1,peer:node.2,type:rtt_total",
+			"canary.clock_skew:2.5|g|#node:node-1,peer:node.2,type:clock_skew",
+			"canary.one_way_delay:-0.5|g|#node:node-1,peer:node.2,type:one_way_delay",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			s, err := newStatsdSink(&SetupConfiguration{Name: "node-1", StatsdAddress: conn.LocalAddr().String(), StatsdPrefix: "canary", StatsdFormat: tt.format}, newNodeName("node-1"), metric.InitMetrics(), zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+			go s.run()
+			defer close(s.lines)
+			for _, sample := range samples {
+				s.emit(sample)
+			}
+
+			buf := make([]byte, 1024)
+			for _, expected := range tt.expected {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := string(buf[:n]); got != expected {
+					t.Errorf("Expected metric %v, got %v", expected, got)
+				}
+			}
+		})
+	}
+}
+
+func Test_statsdSinkOverflow(t *testing.T) {
+	m := metric.InitMetrics()
+	s, err := newStatsdSink(&SetupConfiguration{Name: "node-1", StatsdAddress: "127.0.0.1:1"}, newNodeName("node-1"), m, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sink routine is not running, the emits must not block
+	for i := 0; i < STATSD_BUFFER_SIZE+10; i++ {
+		s.emit(&data.Sample{From: "node-1", To: "node-2", Key: data.RTT_TOTAL, Value: "1"})
+	}
+	// non-numeric values are skipped
+	s.emit(&data.Sample{From: "node-1", To: "node-2", Key: data.STATE, Value: "ok"})
+
+	if dropped := gatherValue(t, m, "statsd_dropped_metrics_total"); dropped != 10 {
+		t.Errorf("Expected 10 dropped metrics, got %v", dropped)
+	}
+
+	if _, err := newStatsdSink(&SetupConfiguration{StatsdAddress: "127.0.0.1:1", StatsdFormat: "graphite"}, newNodeName(""), m, zap.NewNop().Sugar()); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,146 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/telekom/canary-bot/metric"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+)
+
+// Ping retry routine with the suspect state.
+// A failed ping is confirmed by indirect pings of random healthy nodes
+// once the failure threshold is reached,
+// a node not reached by any of them is suspect until the suspect timeout.
+func (m *Mesh) retryPingSuspect(node *meshv1.Node) {
+	log := m.logger.Named("ping-routine")
+	states := m.routineConfig.NodeStates
+
+	var suspectSince time.Time
+	for r := 1; ; r++ {
+		// a paused node stops the retries, the node keeps its state until resumed
+		if m.Paused() {
+			log.Infow("Probes paused - retry routine stopped", "node", node.Name, "attempt", r)
+			return
+		}
+		// Retries beyond the retry budget are not sent and count as failed,
+		// so a suspect node still reaches the suspect timeout
+		budgeted := r == 1 || m.allowRetry("ping")
+		err := errRetryBudget
+		if budgeted {
+			err = m.ping(node)
+		} else {
+			log.Infow("Retry budget exhausted - retry counted as failed", "node", node.Name, "attempt", r)
+		}
+
+		// Ping ok; return
+		if err == nil {
+			m.forgetFailedPings(node)
+			m.setNodeState(node, NODE_OK)
+			log.Infow("Ping ok", "node", node.Name, "attempt", r)
+			return
+		}
+
+		// Ping failed
+		log.Infow("Ping failed", "node", node.Name, "timeout", states.pingTimeout(m.routineConfig.RequestTimeout).String(), "attempt", r)
+		m.setRttNaN(node)
+		inGrace := m.inNeverContactedGrace(node, time.Now())
+
+		// the node keeps its state below the failure threshold
+		if !states.failed(r) {
+			log.Infow("Failure threshold not reached", "node", node.Name, "threshold", states.FailureThreshold, "retry in", m.routineConfig.PingRetryDelay.String(), "attempt", r)
+			time.Sleep(m.routineConfig.PingRetryDelay)
+			continue
+		}
+
+		// Node is reached by another node, e.g. a local network blip
+		if budgeted && m.indirectPing(node) {
+			log.Infow("Ping failed, but node is reachable indirectly", "node", node.Name, "attempt", r)
+			m.forgetFailedPings(node)
+			m.setNodeState(node, NODE_OK)
+			return
+		}
+
+		if suspectSince.IsZero() {
+			suspectSince = time.Now()
+		}
+		if time.Since(suspectSince) >= states.SuspectTimeout {
+			if !inGrace {
+				break
+			}
+			// a never contacted node is not dead within its grace
+			log.Infow("Node never contacted - within grace", "node", node.Name, "grace", states.NeverContactedGrace.String(), "attempt", r)
+		}
+		log.Infow("Node is suspect", "node", node.Name, "retry in", m.routineConfig.PingRetryDelay.String(), "attempt", r)
+		m.setNodeState(node, NODE_SUSPECT)
+		// Retry delay
+		time.Sleep(m.routineConfig.PingRetryDelay)
+	}
+
+	log.Infow("Suspect timeout reached", "node", node.Name, "timeout", states.SuspectTimeout.String())
+	m.nodeDead(node, fmt.Sprintf("suspect for more than %v", states.SuspectTimeout))
+}
+
+// Ask the configured amount of random healthy nodes to ping the suspect node.
+// The asked nodes ping in parallel, true if one of them reached the node.
+func (m *Mesh) indirectPing(suspect *meshv1.Node) bool {
+	log := m.logger.Named("ping-routine")
+	helpers := m.randomPeers(m.routineConfig.NodeStates.IndirectProbes, GetId(suspect))
+	if len(helpers) == 0 {
+		log.Debugw("No healthy node to ping indirectly", "node", suspect.Name)
+		return false
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan bool, len(helpers))
+	for _, helper := range helpers {
+		wg.Add(1)
+		go func(helper *meshv1.Node) {
+			defer wg.Done()
+			m.acquireProbe(PROBE_POOL_PING)
+			reachable, err := m.pingIndirect(helper, suspect)
+			m.releaseProbe(PROBE_POOL_PING)
+			switch {
+			case err != nil:
+				log.Debugw("Indirect ping failed", "node", suspect.Name, "via", helper.Name, "error", err)
+				m.metrics.GetIndirectPings().WithLabelValues(metric.INDIRECT_PING_ERROR).Inc()
+			case reachable:
+				m.metrics.GetIndirectPings().WithLabelValues(metric.INDIRECT_PING_REACHABLE).Inc()
+			default:
+				m.metrics.GetIndirectPings().WithLabelValues(metric.INDIRECT_PING_UNREACHABLE).Inc()
+			}
+			results <- reachable
+		}(helper.Convert())
+	}
+	wg.Wait()
+	close(results)
+
+	for reachable := range results {
+		if reachable {
+			return true
+		}
+	}
+	return false
+}
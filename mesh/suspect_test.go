@@ -0,0 +1,134 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+func Test_retryPingSuspect(t *testing.T) {
+	// the suspect node refuses connections
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suspect := &meshv1.Node{Name: "suspect", Target: lis.Addr().String()}
+	lis.Close()
+
+	// the helper node answers the indirect pings
+	lis, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	helperDb, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	helperDb.SetNode(data.Convert(suspect, NODE_OK))
+	var reachable atomic.Bool
+	var indirectPings atomic.Int64
+	grpcServer := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(grpcServer, &MeshServer{data: &helperDb, ping: func(ctx context.Context, node *meshv1.Node) error {
+		indirectPings.Add(1)
+		if !reachable.Load() {
+			return errors.New("unreachable")
+		}
+		return nil
+	}})
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+	helper := &meshv1.Node{Name: "helper", Target: lis.Addr().String()}
+
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.routineConfig.PingRetryDelay = time.Millisecond
+	m.routineConfig.NodeStates = NodeStateConfiguration{
+		RemoveAfter:    time.Minute,
+		IndirectProbes: 3,
+		SuspectTimeout: time.Millisecond,
+	}
+	db.SetNode(data.Convert(helper, NODE_OK))
+
+	// a node reached by the helper stays ok
+	reachable.Store(true)
+	db.SetNode(data.Convert(suspect, NODE_OK))
+	m.retryPingSuspect(suspect)
+	if node, _ := db.GetNode(GetId(suspect)); node.State != NODE_OK {
+		t.Errorf("Expected the indirectly reachable node to be ok, got %v", stateName(node.State))
+	}
+
+	// a node not reached by the helper is suspect until it is dead
+	reachable.Store(false)
+	m.retryPingSuspect(suspect)
+	if node, _ := db.GetNode(GetId(suspect)); node.State != NODE_DEAD {
+		t.Errorf("Expected the unreachable node to be dead, got %v", stateName(node.State))
+	}
+	var states []string
+	for _, event := range db.GetEventList() {
+		if event.Type == data.EVENT_STATE_CHANGE {
+			states = append(states, event.Message)
+		}
+	}
+	if len(states) != 2 || states[0] != "ok -> suspect" || states[1] != "suspect -> dead" {
+		t.Errorf("Expected the state changes ok -> suspect -> dead, got %v", states)
+	}
+
+	// the indirect pings start at the failure threshold
+	reachable.Store(true)
+	indirectPings.Store(0)
+	m.routineConfig.NodeStates.FailureThreshold = 3
+	db.SetNode(data.Convert(suspect, NODE_OK))
+	m.retryPingSuspect(suspect)
+	if node, _ := db.GetNode(GetId(suspect)); node.State != NODE_OK {
+		t.Errorf("Expected the indirectly reachable node to be ok, got %v", stateName(node.State))
+	}
+	if n := indirectPings.Load(); n != 1 {
+		t.Errorf("Expected one indirect ping at the failure threshold, got %v", n)
+	}
+
+	// the retries beyond the budget count as failed without indirect pings
+	indirectPings.Store(0)
+	m.retryBudget = newRetryBudget(RetryBudgetConfiguration{Ratio: 0.1, Max: 1}, time.Now())
+	m.retryBudget.tokens = 0
+	db.SetNode(data.Convert(suspect, NODE_OK))
+	m.retryPingSuspect(suspect)
+	if node, _ := db.GetNode(GetId(suspect)); node.State != NODE_DEAD {
+		t.Errorf("Expected the node to be dead, got %v", stateName(node.State))
+	}
+	if n := indirectPings.Load(); n != 0 {
+		t.Errorf("Expected no indirect ping beyond the budget, got %v", n)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"google.golang.org/grpc"
+)
+
+// Throughput probe of the mesh peers
+const PROBE_THROUGHPUT = "throughput"
+
+// Limits of the throughput probe volume, larger probes are rejected
+// by the receiving node. The data is streamed in chunks.
+const (
+	THROUGHPUT_CHUNK_SIZE = 32 << 10
+	MIN_THROUGHPUT_VOLUME = 2 * THROUGHPUT_CHUNK_SIZE
+	MAX_THROUGHPUT_VOLUME = 64 << 20
+	// Timeout of a throughput probe, a slower probe fails
+	THROUGHPUT_TIMEOUT = 30 * time.Second
+)
+
+// Measure the throughput to a random node and save it as sample.
+// A probe is skipped if the last probe is still running.
+func (m *Mesh) Throughput() {
+	log := m.logger.Named("throughput")
+	if !m.throughputRunning.CompareAndSwap(false, true) {
+		log.Debugw("Last throughput probe still running - skipped")
+		return
+	}
+	defer m.throughputRunning.Store(false)
+
+	nodes := m.randomPeers(1)
+	if len(nodes) == 0 {
+		log.Debugw("No node suitable for a throughput probe")
+		return
+	}
+	node := nodes[0]
+
+	bytesPerSecond, err := m.throughput(node)
+	m.metrics.ObserveProbe(PROBE_THROUGHPUT, err)
+	sample := &data.Sample{
+		From:   m.name.get(),
+		To:     node.Name,
+		Key:    data.THROUGHPUT,
+		Ts:     time.Now().Unix(),
+		FromId: m.nodeId(),
+		ToId:   node.Id,
+	}
+	if err != nil {
+		log.Debugw("Throughput probe failed", "node", node.Name, "error", err)
+		sample.Value = "NaN"
+	} else {
+		log.Debugw("Throughput probe succeeded", "node", node.Name, "bytesPerSecond", bytesPerSecond)
+		sample.Value = strconv.FormatFloat(bytesPerSecond, 'f', 0, 64)
+	}
+	m.database.SetSample(sample)
+}
+
+// Stream the configured volume to a node on a separate connection,
+// so the mesh RPCs do not share the flow control window of the probe.
+// The throughput measured by the node is returned in bytes per second.
+func (m *Mesh) throughput(node *data.Node) (float64, error) {
+	log := m.logger.Named("throughput")
+	m.acquireProbe(PROBE_POOL_THROUGHPUT)
+	defer m.releaseProbe(PROBE_POOL_THROUGHPUT)
+
+	creds, err := m.peerCredentials(log, node.Convert(), DSCP_THROUGHPUT)
+	if err != nil {
+		return 0, err
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(m.grpcDialer(DSCP_THROUGHPUT)),
+	}
+	if m.meshToken != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(m.meshToken))
+	}
+	conn, err := grpc.Dial(node.Target, opts...)
+	if err != nil {
+		return 0, fmt.Errorf("throughput %v: %w", node.Target, classifyError(err, ErrDial))
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), THROUGHPUT_TIMEOUT)
+	defer cancel()
+	stream, err := meshv1.NewMeshServiceClient(conn).Throughput(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("throughput %v: %w", node.Target, classifyError(err))
+	}
+	chunk := &meshv1.ThroughputChunk{Data: make([]byte, THROUGHPUT_CHUNK_SIZE)}
+	for sent := 0; sent < m.setupConfig.ThroughputVolume; sent += len(chunk.Data) {
+		if rest := m.setupConfig.ThroughputVolume - sent; rest < len(chunk.Data) {
+			chunk.Data = chunk.Data[:rest]
+		}
+		// the status of a failed stream is returned by CloseAndRecv
+		if err := stream.Send(chunk); err != nil {
+			break
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, fmt.Errorf("throughput %v: %w", node.Target, classifyError(err))
+	}
+	if res.BytesPerSecond == 0 {
+		return 0, fmt.Errorf("throughput %v: no throughput measured by the node", node.Target)
+	}
+	return res.BytesPerSecond, nil
+}
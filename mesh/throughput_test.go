@@ -0,0 +1,96 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Start a mesh server receiving throughput probes, a mesh knowing
+// the server as node is returned
+func throughputMesh(t *testing.T, s *MeshServer) (*Mesh, *data.Node) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(server, s)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	m := testMesh(time.Second)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.database = db
+	m.setupConfig.ThroughputVolume = 256 << 10
+	node := data.Convert(&meshv1.Node{Name: "peer", Target: lis.Addr().String()}, NODE_OK)
+	db.SetNode(node)
+	return m, node
+}
+
+func Test_Throughput(t *testing.T) {
+	m, node := throughputMesh(t, &MeshServer{})
+	m.Throughput()
+
+	sample := m.database.GetSample(data.GetSampleId(&data.Sample{From: "test", To: "peer", Key: data.THROUGHPUT, FromId: m.nodeId(), ToId: node.Id}))
+	if value, err := strconv.ParseFloat(sample.Value, 64); err != nil || value <= 0 {
+		t.Errorf("Expected a positive throughput sample, got %+v", sample)
+	}
+}
+
+func Test_ThroughputLimits(t *testing.T) {
+	t.Run("one probe at a time", func(t *testing.T) {
+		s := &MeshServer{}
+		s.throughputActive.Store(true)
+		m, node := throughputMesh(t, s)
+		if _, err := m.throughput(node); status.Code(errors.Unwrap(err)) != codes.ResourceExhausted {
+			t.Errorf("Expected ResourceExhausted during a running probe, got %v", err)
+		}
+		m.Throughput()
+		sample := m.database.GetSample(data.GetSampleId(&data.Sample{From: "test", To: "peer", Key: data.THROUGHPUT, FromId: m.nodeId(), ToId: node.Id}))
+		if sample.Value != "NaN" {
+			t.Errorf("Expected a NaN sample of a failed probe, got %+v", sample)
+		}
+	})
+
+	t.Run("max. volume", func(t *testing.T) {
+		m, node := throughputMesh(t, &MeshServer{})
+		m.setupConfig.ThroughputVolume = MAX_THROUGHPUT_VOLUME + THROUGHPUT_CHUNK_SIZE
+		if _, err := m.throughput(node); status.Code(errors.Unwrap(err)) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument above the max. volume, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+
+	h "github.com/telekom/canary-bot/helper"
+)
+
+// Listen on the configured address, a TCP address with the listen port
+// or a unix domain socket (unix:///path). A stale socket file is removed.
+// Multiple TCP listeners share the port by SO_REUSEPORT.
+func (m *Mesh) listen() ([]net.Listener, error) {
+	log := m.logger.Named("server")
+	if path, ok := h.UnixSocketPath(m.setupConfig.ListenAddress); ok {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+		log.Infow("Start listening", "socket", path)
+		lis, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{lis}, nil
+	}
+
+	listenAdd := m.setupConfig.ListenAddress + ":" + strconv.FormatInt(m.setupConfig.ListenPort, 10)
+	log.Infow("Start listening", "address", listenAdd, "sockets", m.listenSockets())
+	lis, err := m.listenTcp(listenAdd)
+	if err != nil {
+		return nil, err
+	}
+	listeners := []net.Listener{lis}
+
+	// the port of the first listener is used if the listen port is 0
+	listenAdd = lis.Addr().String()
+	for i := 1; i < m.listenSockets(); i++ {
+		lis, err := m.listenTcp(listenAdd)
+		if err != nil {
+			log.Warnw("Could not open additional listener - ignored", "sockets", i, "error", err)
+			break
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
+
+// Listen on a TCP address with SO_REUSEPORT & the listen backlog if set.
+// Options not supported on this platform are ignored.
+func (m *Mesh) listenTcp(address string) (net.Listener, error) {
+	log := m.logger.Named("server")
+	lc := net.ListenConfig{}
+	if m.setupConfig.ReusePort {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			if err := setReusePort(c); err != nil {
+				log.Warnw("Could not set SO_REUSEPORT - ignored", "address", address, "error", err)
+			}
+			return nil
+		}
+	}
+	lis, err := lc.Listen(context.Background(), "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.setupConfig.ListenBacklog > 0 {
+		if err := listenerBacklog(lis, m.setupConfig.ListenBacklog); err != nil {
+			log.Warnw("Could not set listen backlog - ignored", "backlog", m.setupConfig.ListenBacklog, "error", err)
+		}
+	}
+	return lis, nil
+}
+
+// Set the backlog of a TCP listener
+func listenerBacklog(lis net.Listener, backlog int) error {
+	tcp, ok := lis.(*net.TCPListener)
+	if !ok {
+		return errors.New("no TCP listener")
+	}
+	c, err := tcp.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return setBacklog(c, backlog)
+}
+
+// Amount of TCP listeners, more than one listener needs SO_REUSEPORT
+func (m *Mesh) listenSockets() int {
+	if !m.setupConfig.ReusePort || m.setupConfig.ListenSockets < 1 {
+		return 1
+	}
+	return m.setupConfig.ListenSockets
+}
+
+// Remove the socket file of the unix domain socket listener on shutdown
+func (m *Mesh) removeSocket() {
+	if path, ok := h.UnixSocketPath(m.setupConfig.ListenAddress); ok {
+		if err := removeStaleSocket(path); err != nil {
+			m.logger.Named("server").Warnw("Could not remove socket file", "socket", path, "error", err)
+		}
+	}
+}
+
+// Remove a socket file, other files are not removed
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return errors.New("listen address " + path + " exists and is not a socket")
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,132 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package mesh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	meshv1 "github.com/telekom/canary-bot/proto/mesh/v1"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+func Test_UnixDomainSocket(t *testing.T) {
+	// short path, the socket path length is limited
+	dir, err := os.MkdirTemp("", "cbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := "unix://" + filepath.Join(dir, "mesh.sock")
+
+	server := testMesh(time.Second)
+	server.setupConfig.ListenAddress = target
+	// stale socket files are removed
+	if err := os.WriteFile(filepath.Join(dir, "mesh.sock"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.listen(); err == nil {
+		t.Fatal("Expected error for a regular file at the socket path")
+	}
+	os.Remove(filepath.Join(dir, "mesh.sock"))
+
+	listeners, err := server.listen()
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	lis := listeners[0]
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	meshv1.RegisterMeshServiceServer(grpcServer, &MeshServer{log: zap.NewNop().Sugar(), data: &db})
+	go func() { _ = grpcServer.Serve(lis) }()
+
+	client := testMesh(time.Second)
+	node := &meshv1.Node{Name: "uds", Target: target}
+	c, err := client.initClient(node)
+	if err != nil {
+		t.Fatalf("could not init client: %v", err)
+	}
+	if _, err := c.client.Rtt(context.Background(), &meshv1.RttRequest{}); err != nil {
+		t.Errorf("could not call over unix domain socket: %v", err)
+	}
+
+	// different notations of the socket are the same node
+	if GetId(node) != GetId(&meshv1.Node{Target: "unix:" + filepath.Join(dir, "mesh.sock")}) {
+		t.Error("Expected the same id for unix:// and unix: targets")
+	}
+
+	// the socket file is removed on shutdown
+	grpcServer.Stop()
+	server.removeSocket()
+	if _, err := os.Stat(filepath.Join(dir, "mesh.sock")); !os.IsNotExist(err) {
+		t.Errorf("Expected removed socket file, got %v", err)
+	}
+}
+
+func Test_listenReusePort(t *testing.T) {
+	server := testMesh(time.Second)
+	server.setupConfig.ListenAddress = "127.0.0.1"
+	server.setupConfig.ListenSockets = 3
+	server.setupConfig.ListenBacklog = 1024
+
+	// without SO_REUSEPORT a single listener is opened
+	listeners, err := server.listen()
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	if len(listeners) != 1 {
+		t.Errorf("Expected 1 listener without SO_REUSEPORT, got %v", len(listeners))
+	}
+	listeners[0].Close()
+
+	server.setupConfig.ReusePort = true
+	listeners, err = server.listen()
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer func() {
+		for _, lis := range listeners {
+			lis.Close()
+		}
+	}()
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if len(listeners) != 3 {
+		t.Fatalf("Expected 3 listeners, got %v", len(listeners))
+	}
+	for _, lis := range listeners[1:] {
+		if lis.Addr().String() != listeners[0].Addr().String() {
+			t.Errorf("Expected all listeners on %v, got %v", listeners[0].Addr(), lis.Addr())
+		}
+	}
+}
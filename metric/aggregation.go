@@ -0,0 +1,164 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+// Interval the sample store is read during an aggregation window
+const AGGREGATION_POLL_INTERVAL = time.Second
+
+// Samples of a type from a node to a node are aggregated together
+type aggregationKey struct {
+	key  int64
+	from string
+	to   string
+}
+
+// Min, sum, max & count of the samples in a window
+type aggregate struct {
+	min   float64
+	max   float64
+	sum   float64
+	count int
+}
+
+func (a *aggregate) avg() float64 {
+	return a.sum / float64(a.count)
+}
+
+// Aggregates new samples of the sample store per window.
+// Every sample is counted once by its id & timestamp.
+// Samples not accepted by the filter are skipped, if set.
+type sampleAggregator struct {
+	lastTs     map[uint32]int64
+	aggregates map[aggregationKey]*aggregate
+	filter     func(*data.Sample) bool
+}
+
+func newSampleAggregator() *sampleAggregator {
+	return &sampleAggregator{
+		lastTs:     map[uint32]int64{},
+		aggregates: map[aggregationKey]*aggregate{},
+	}
+}
+
+// Add the new samples, samples with a non-numeric value (e.g. NaN) are skipped
+func (a *sampleAggregator) add(samples []*data.Sample) {
+	for _, sample := range samples {
+		if ts, ok := a.lastTs[sample.Id]; ok && sample.Ts <= ts {
+			continue
+		}
+		a.lastTs[sample.Id] = sample.Ts
+		if a.filter != nil && !a.filter(sample) {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(sample.Value, 64)
+		if err != nil || math.IsNaN(value) {
+			continue
+		}
+		key := aggregationKey{key: sample.Key, from: sample.From, to: sample.To}
+		agg, ok := a.aggregates[key]
+		if !ok {
+			a.aggregates[key] = &aggregate{min: value, max: value, sum: value, count: 1}
+			continue
+		}
+		agg.min = math.Min(agg.min, value)
+		agg.max = math.Max(agg.max, value)
+		agg.sum += value
+		agg.count++
+	}
+}
+
+// Forget the timestamps of the samples not in the store anymore,
+// e.g. the samples of a node that left the mesh
+func (a *sampleAggregator) prune(samples []*data.Sample) {
+	stored := make(map[uint32]bool, len(samples))
+	for _, sample := range samples {
+		stored[sample.Id] = true
+	}
+	for id := range a.lastTs {
+		if !stored[id] {
+			delete(a.lastTs, id)
+		}
+	}
+}
+
+// Return the aggregates of the window and start a new window
+func (a *sampleAggregator) flush() map[aggregationKey]*aggregate {
+	aggregates := a.aggregates
+	a.aggregates = map[aggregationKey]*aggregate{}
+	return aggregates
+}
+
+// StartAggregation reads the sample store and exports the min, avg & max
+// of the samples per window. The gauges are updated at the end of every window,
+// peers without samples in the last window are removed.
+// The aggregation stops with the context, e.g. on shutdown.
+func (m *PrometheusMetrics) StartAggregation(ctx context.Context, db data.Database, window time.Duration) {
+	aggregator := newSampleAggregator()
+	aggregator.filter = m.aggregates
+	// samples in the store before the start are not part of the first window
+	aggregator.add(db.GetSampleList())
+	aggregator.flush()
+
+	poll := time.NewTicker(AGGREGATION_POLL_INTERVAL)
+	defer poll.Stop()
+	flush := time.NewTicker(window)
+	defer flush.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			aggregator.add(db.GetSampleList())
+		case <-flush.C:
+			samples := db.GetSampleList()
+			aggregator.add(samples)
+			aggregator.prune(samples)
+			m.setWindow(aggregator.flush())
+		}
+	}
+}
+
+// Set the aggregation gauges to the aggregates of a window
+func (m *PrometheusMetrics) setWindow(aggregates map[aggregationKey]*aggregate) {
+	m.sampleWindowMin.Reset()
+	m.sampleWindowAvg.Reset()
+	m.sampleWindowMax.Reset()
+	for key, agg := range aggregates {
+		if !m.ExportsSample(key.key) {
+			continue
+		}
+		name := data.SampleName(key.key)
+		m.sampleWindowMin.WithLabelValues(name, key.from, key.to).Set(m.exportValue(key.key, agg.min))
+		m.sampleWindowAvg.WithLabelValues(name, key.from, key.to).Set(m.exportValue(key.key, agg.avg()))
+		m.sampleWindowMax.WithLabelValues(name, key.from, key.to).Set(m.exportValue(key.key, agg.max))
+	}
+}
@@ -0,0 +1,142 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/telekom/canary-bot/data"
+	"go.uber.org/zap"
+)
+
+func TestSampleAggregator(t *testing.T) {
+	a := newSampleAggregator()
+	a.add([]*data.Sample{
+		{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "10", Ts: 1},
+		{Id: 2, From: "a", To: "c", Key: data.RTT_TOTAL, Value: "NaN", Ts: 1},
+	})
+	// same sample again is not counted twice
+	a.add([]*data.Sample{{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "10", Ts: 1}})
+	a.add([]*data.Sample{{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "30", Ts: 2}})
+	a.add([]*data.Sample{{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "20", Ts: 3}})
+
+	aggregates := a.flush()
+	if len(aggregates) != 1 {
+		t.Fatalf("Expected 1 aggregate, got %v", len(aggregates))
+	}
+	agg := aggregates[aggregationKey{key: data.RTT_TOTAL, from: "a", to: "b"}]
+	if agg == nil {
+		t.Fatal("aggregate is nil")
+	}
+	if agg.min != 10 || agg.max != 30 || agg.avg() != 20 || agg.count != 3 {
+		t.Errorf("Expected min 10, avg 20, max 30 of 3 samples, got min %v, avg %v, max %v of %v samples", agg.min, agg.avg(), agg.max, agg.count)
+	}
+
+	// new window
+	if len(a.flush()) != 0 {
+		t.Error("Expected empty window after flush")
+	}
+}
+
+func TestSampleAggregatorPrune(t *testing.T) {
+	a := newSampleAggregator()
+	kept := &data.Sample{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "10", Ts: 1}
+	a.add([]*data.Sample{kept, {Id: 2, From: "a", To: "c", Key: data.RTT_TOTAL, Value: "10", Ts: 1}})
+
+	// the sample of the node that left is not in the store anymore
+	a.prune([]*data.Sample{kept})
+	if _, ok := a.lastTs[2]; ok || len(a.lastTs) != 1 {
+		t.Errorf("Expected just the timestamp of the stored sample, got %v", a.lastTs)
+	}
+}
+
+func TestStartAggregationStop(t *testing.T) {
+	m := InitMetrics()
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		m.StartAggregation(ctx, db, time.Minute)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected the aggregation to stop with the context")
+	}
+}
+
+// Get the values of the counter & gauge series of a gathered metric family,
+// filtered by label name & value pairs, e.g. "reason", "ok"
+func gatherValues(t *testing.T, m *PrometheusMetrics, name string, labels ...string) []float64 {
+	families, err := m.GetRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []float64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	series:
+		for _, metric := range family.GetMetric() {
+			metricLabels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				metricLabels[label.GetName()] = label.GetValue()
+			}
+			for i := 0; i+1 < len(labels); i += 2 {
+				if metricLabels[labels[i]] != labels[i+1] {
+					continue series
+				}
+			}
+			if metric.GetCounter() != nil {
+				values = append(values, metric.GetCounter().GetValue())
+			} else {
+				values = append(values, metric.GetGauge().GetValue())
+			}
+		}
+	}
+	return values
+}
+
+func TestSetWindow(t *testing.T) {
+	m := InitMetrics()
+	m.setWindow(map[aggregationKey]*aggregate{
+		{key: data.RTT_TOTAL, from: "a", to: "b"}: {min: 1, max: 3, sum: 4, count: 2},
+	})
+	if values := gatherValues(t, m, "sample_window_avg"); len(values) != 1 || values[0] != 2 {
+		t.Errorf("Expected avg 2, got %v", values)
+	}
+
+	// peers without samples are removed
+	m.setWindow(map[aggregationKey]*aggregate{})
+	if values := gatherValues(t, m, "sample_window_max"); len(values) != 0 {
+		t.Errorf("Expected no window max metrics, got %v", values)
+	}
+}
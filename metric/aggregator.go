@@ -0,0 +1,80 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+// Default max. series of the aggregator export
+const DEFAULT_AGGREGATOR_MAX_SERIES = 10000
+
+// Aggregator mode: export the values of all samples of the mesh
+// received by this node, labeled by the measuring (from) node.
+type aggregatorExport struct {
+	exclude   map[int64]bool
+	maxSeries int
+}
+
+// SetAggregator enables the aggregator export of the samples.
+// Samples of the excluded keys are not exported, the export is
+// limited to max. series, 0 uses the default limit.
+func (m *PrometheusMetrics) SetAggregator(exclude []int64, maxSeries int) {
+	if maxSeries <= 0 {
+		maxSeries = DEFAULT_AGGREGATOR_MAX_SERIES
+	}
+	excluded := make(map[int64]bool, len(exclude))
+	for _, key := range exclude {
+		excluded[key] = true
+	}
+	m.aggregator = &aggregatorExport{exclude: excluded, maxSeries: maxSeries}
+}
+
+// Set the mesh sample values of the aggregator export.
+// Stale samples & samples with a non-numeric value (e.g. NaN) are skipped,
+// samples above the max. series are dropped and counted.
+func (m *PrometheusMetrics) setMeshSamples(samples []*data.Sample) {
+	if m.aggregator == nil {
+		return
+	}
+	m.meshSampleValue.Reset()
+	series, dropped := 0, 0
+	for _, sample := range samples {
+		if m.aggregator.exclude[sample.Key] || !m.ExportsSample(sample.Key) || !m.aggregates(sample) || sample.IsStale(m.sampleStaleAfter) {
+			continue
+		}
+		value, err := strconv.ParseFloat(sample.Value, 64)
+		if err != nil || math.IsNaN(value) {
+			continue
+		}
+		if series >= m.aggregator.maxSeries {
+			dropped++
+			continue
+		}
+		m.meshSampleValue.WithLabelValues(data.SampleName(sample.Key), sample.From, sample.To, sample.Group).Set(m.exportValue(sample.Key, value))
+		series++
+	}
+	m.aggregatorDropped.Set(float64(dropped))
+}
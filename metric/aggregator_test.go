@@ -0,0 +1,133 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/telekom/canary-bot/data"
+)
+
+func TestSetMeshSamples(t *testing.T) {
+	samples := []*data.Sample{
+		{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "10", Ts: 1},
+		{Id: 2, From: "b", To: "a", Key: data.RTT_TOTAL, Value: "20", Ts: 1},
+		{Id: 3, From: "a", To: "c", Key: data.RTT_TOTAL, Value: "NaN", Ts: 1},
+		{Id: 4, From: "a", To: "a", Key: data.HEARTBEAT, Value: "3", Ts: 1},
+	}
+
+	m := InitMetrics()
+	m.setMeshSamples(samples)
+	if values := gatherValues(t, m, "mesh_sample_value"); len(values) != 0 {
+		t.Errorf("Expected no export without aggregator, got %v", values)
+	}
+
+	m.SetAggregator([]int64{data.HEARTBEAT}, 0)
+	m.setMeshSamples(samples)
+	if values := gatherValues(t, m, "mesh_sample_value"); len(values) != 2 {
+		t.Errorf("Expected the 2 numeric RTT samples, got %v", values)
+	}
+	if dropped := gatherValues(t, m, "aggregator_dropped_series"); len(dropped) != 1 || dropped[0] != 0 {
+		t.Errorf("Expected no dropped series, got %v", dropped)
+	}
+
+	m.SetAggregator(nil, 1)
+	m.setMeshSamples(samples)
+	if values := gatherValues(t, m, "mesh_sample_value"); len(values) != 1 || values[0] != 10 {
+		t.Errorf("Expected just the first sample within the max. series, got %v", values)
+	}
+	if dropped := gatherValues(t, m, "aggregator_dropped_series"); len(dropped) != 1 || dropped[0] != 2 {
+		t.Errorf("Expected 2 dropped series, got %v", dropped)
+	}
+}
+
+func TestSetMeshSamplesUnits(t *testing.T) {
+	units, err := data.NewUnitNormalizer(map[string]string{"rtt_total": "ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := InitMetrics()
+	m.SetAggregator(nil, 0)
+	m.SetUnits(units)
+	m.setMeshSamples([]*data.Sample{{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "2500000", Ts: 1}})
+	if values := gatherValues(t, m, "mesh_sample_value"); len(values) != 1 || values[0] != 2.5 {
+		t.Errorf("Expected the RTT in milliseconds, got %v", values)
+	}
+}
+
+func TestSetMeshSamplesExport(t *testing.T) {
+	m := InitMetrics()
+	m.SetAggregator(nil, 0)
+	m.SetExportSamples([]int64{data.RTT_TOTAL})
+	m.setMeshSamples([]*data.Sample{
+		{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "10", Ts: 1},
+		{Id: 2, From: "a", To: "b", Key: data.RTT_REQUEST, Value: "20", Ts: 1},
+	})
+	if values := gatherValues(t, m, "mesh_sample_value"); len(values) != 1 || values[0] != 10 {
+		t.Errorf("Expected just the exported rtt_total sample, got %v", values)
+	}
+}
+
+func TestSetMeshSamplesRounding(t *testing.T) {
+	units, err := data.NewUnitNormalizer(map[string]string{"rtt_total": "ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rounding, err := data.NewSampleRounding(map[string]string{"rtt_total": "1ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := InitMetrics()
+	m.SetAggregator(nil, 0)
+	m.SetUnits(units)
+	m.SetRounding(rounding)
+	// rounded in the unit of the sample type before converted
+	m.setMeshSamples([]*data.Sample{{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "2500000", Ts: 1}})
+	if values := gatherValues(t, m, "mesh_sample_value"); len(values) != 1 || values[0] != 3 {
+		t.Errorf("Expected the rounded RTT in milliseconds, got %v", values)
+	}
+}
+
+func TestSetMeshSamplesGroup(t *testing.T) {
+	m := InitMetrics()
+	m.SetAggregator(nil, 0)
+	m.setMeshSamples([]*data.Sample{{Id: 1, From: "a", To: "b", Key: data.RTT_TOTAL, Value: "10", Ts: 1, Group: "edge"}})
+
+	families, err := m.GetRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := ""
+	for _, family := range families {
+		if family.GetName() != "mesh_sample_value" {
+			continue
+		}
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			if label.GetName() == FROM_GROUP_LABEL {
+				group = label.GetValue()
+			}
+		}
+	}
+	if group != "edge" {
+		t.Errorf("Expected the probe group of the measuring node as label, got %q", group)
+	}
+}
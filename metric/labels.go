@@ -0,0 +1,70 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label of the probe group of the node, set as static label of all metrics.
+// The probe group of the measuring node of a mesh sample is the from_group label.
+const (
+	GROUP_LABEL      = "group"
+	FROM_GROUP_LABEL = "from_group"
+)
+
+// Valid Prometheus label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// SetConstLabels adds static labels to all metrics, e.g. datacenter or cluster.
+// The label names have to be valid, not reserved (__ prefix) and must not collide
+// with the labels of a metric, e.g. node. Set it before the registry is used.
+func (m *PrometheusMetrics) SetConstLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if strings.HasPrefix(name, "__") {
+			return fmt.Errorf("label name %q is reserved", name)
+		}
+	}
+
+	// a collision with a label of a metric fails the registration,
+	// the RTT histograms are checked with and without edge labels
+	scratch := prometheus.WrapRegistererWith(labels, prometheus.NewRegistry())
+	for _, collector := range append(m.collectors()[1:], m.rtt) {
+		if err := scratch.Register(collector); err != nil {
+			return fmt.Errorf("labels collide with the labels of a metric: %w", err)
+		}
+	}
+	edge := prometheus.WrapRegistererWith(labels, prometheus.NewRegistry())
+	if err := edge.Register(m.rttEdge); err != nil {
+		return fmt.Errorf("labels collide with the labels of a metric: %w", err)
+	}
+
+	m.constLabels = labels
+	return nil
+}
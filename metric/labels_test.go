@@ -0,0 +1,79 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"testing"
+)
+
+func TestSetConstLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		expectErr bool
+	}{
+		{name: "static labels", labels: map[string]string{"datacenter": "dc1", "cluster": "a"}},
+		{name: "invalid name", labels: map[string]string{"data-center": "dc1"}, expectErr: true},
+		{name: "reserved name", labels: map[string]string{"__name": "a"}, expectErr: true},
+		{name: "collision with node", labels: map[string]string{"node": "a"}, expectErr: true},
+		{name: "collision with peer", labels: map[string]string{"peer": "a"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := InitMetrics()
+			err := m.SetConstLabels(tt.labels)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("error is %v, but expected error is %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestConstLabelsExported(t *testing.T) {
+	m := InitMetrics()
+	m.SetRttEdgeLabels("a")
+	if err := m.SetConstLabels(map[string]string{"cluster": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	m.GetNodes().Set(3)
+	m.GetRttObserver("rtt_total", "b").Observe(1)
+
+	families, err := m.GetRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "node_count" && family.GetName() != "rtt" {
+			continue
+		}
+		found := false
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			if label.GetName() == "cluster" && label.GetValue() == "a" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected the cluster label on %v", family.GetName())
+		}
+	}
+}
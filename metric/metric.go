@@ -22,72 +22,999 @@
 package metric
 
 import (
+	"context"
+	"math"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/telekom/canary-bot/data"
 )
 
+// Events of a mesh client connection
+const (
+	CONN_DIAL         = "dial"
+	CONN_DIAL_FAILURE = "dial_failure"
+	CONN_REUSE        = "reuse"
+	CONN_CLOSE        = "close"
+	CONN_IDLE_EVICT   = "idle_evict"
+)
+
+// Outcomes of a join attempt
+const (
+	JOIN_SUCCESS        = "success"
+	JOIN_NAME_COLLISION = "name_collision"
+	JOIN_FAILURE        = "failure"
+)
+
+// Reasons a sample is dropped by the sample sink
+const (
+	SINK_DROP_OVERFLOW = "overflow"
+	SINK_DROP_ERROR    = "error"
+)
+
+// Results of the indirect pings of suspect nodes
+const (
+	INDIRECT_PING_REACHABLE   = "reachable"
+	INDIRECT_PING_UNREACHABLE = "unreachable"
+	INDIRECT_PING_ERROR       = "error"
+)
+
+// Modes of the digest sample sync
+const (
+	SAMPLE_SYNC_IN_SYNC = "in_sync"
+	SAMPLE_SYNC_DIGEST  = "digest"
+	SAMPLE_SYNC_FULL    = "full"
+)
+
+// Reasons a mesh RPC is unauthenticated
+const (
+	AUTH_MISSING = "missing"
+	AUTH_INVALID = "invalid"
+)
+
+// Security levels of a mesh connection
+const (
+	SECURITY_TLS      = "tls"
+	SECURITY_INSECURE = "insecure"
+)
+
+// Limits of the inbound RPCs & connections of the mesh server
+const (
+	LIMIT_STREAMS     = "streams"
+	LIMIT_CONNECTIONS = "connections"
+)
+
+// Reasons a discovery is not forwarded
+const (
+	// The discovery reached the max. depth
+	DISCOVERY_DEPTH = "depth"
+	// The new node is already known
+	DISCOVERY_KNOWN = "known"
+)
+
 //go:generate moq -out metric_test_moq.go . Metrics
 type Metrics interface {
 	GetRegistry() *prometheus.Registry
 	Handler(data data.Database, h http.Handler) http.Handler
 	GetNodes() prometheus.Gauge
 	GetRtt() *prometheus.HistogramVec
+	GetRttEdge() *prometheus.HistogramVec
+	SetRttEdgeLabels(from string)
+	SetConstLabels(labels map[string]string) error
+	GetRttObserver(rttType string, to string) prometheus.Observer
+	GetClientConnections() *prometheus.CounterVec
+	GetSampleAge() *prometheus.GaugeVec
+	GetStaleSamples() prometheus.Gauge
+	SetSampleStaleAfter(staleAfter time.Duration)
+	SetUnits(units data.UnitNormalizer)
+	SetRounding(rounding data.SampleRounding)
+	SetExcludeSelfSamples(exclude bool, id uint32)
+	SetExportSamples(keys []int64)
+	ExportsSample(key int64) bool
+	SetAggregator(exclude []int64, maxSeries int)
+	GetProbeDuration() *prometheus.HistogramVec
+	GetProbeSuccess() *prometheus.GaugeVec
+	ObserveProbe(probeType string, err error)
+	GetNodeLabels() *prometheus.GaugeVec
+	GetProbesInFlight() prometheus.Gauge
+	GetNodeLastSeen() *prometheus.GaugeVec
+	GetSamplePropagation() *prometheus.HistogramVec
+	GetSampleClockSkew() prometheus.Counter
+	GetJoinAttempts() prometheus.Counter
+	GetJoinOutcomes() *prometheus.CounterVec
+	GetJoinDuration() prometheus.Histogram
+	StartAggregation(ctx context.Context, db data.Database, window time.Duration)
+	GetSampleWindowMin() *prometheus.GaugeVec
+	GetSampleWindowAvg() *prometheus.GaugeVec
+	GetSampleWindowMax() *prometheus.GaugeVec
+	GetPeerClockSkew() *prometheus.GaugeVec
+	GetNodeHealthScore() *prometheus.GaugeVec
+	GetStaleDiscoveries() prometheus.Counter
+	GetRetryBudget() prometheus.Gauge
+	GetRetriesThrottled() *prometheus.CounterVec
+	GetDnsCacheLookups() *prometheus.CounterVec
+	GetSinkDropped() *prometheus.CounterVec
+	GetSinkPublished() prometheus.Counter
+	GetHeartbeatAge() *prometheus.GaugeVec
+	GetSpilledSamples() prometheus.Gauge
+	GetSamplePushFanout() prometheus.Gauge
+	GetSampleCoverage() prometheus.Gauge
+	GetMeshSampleValue() *prometheus.GaugeVec
+	GetAggregatorDropped() prometheus.Gauge
+	GetProbeOutcomes() *prometheus.CounterVec
+	GetIndirectPings() *prometheus.CounterVec
+	GetSampleSyncs() *prometheus.CounterVec
+	GetProbeQueueDepth() *prometheus.GaugeVec
+	GetUnauthenticatedRequests() *prometheus.CounterVec
+	GetSuppressedDiscoveries() prometheus.Counter
+	GetConnectionSecurity() *prometheus.GaugeVec
+	GetInboundActiveStreams() prometheus.Gauge
+	GetInboundRejected() *prometheus.CounterVec
+	GetPeerVersions() *prometheus.GaugeVec
+	GetDiscoveryForwardsSuppressed() *prometheus.CounterVec
+	GetUnsyncedSampleAge() *prometheus.GaugeVec
+	GetStatsdDropped() *prometheus.CounterVec
+	GetProbingPaused() prometheus.Gauge
+	GetSelfSamplesDropped() prometheus.Counter
+	GetSampleFieldValue() *prometheus.GaugeVec
+	GetRttAnomalies() *prometheus.CounterVec
+	GetRequestLogSampleRate() prometheus.Gauge
 }
 
 type PrometheusMetrics struct {
-	registry *prometheus.Registry
-	nodes    prometheus.Gauge
-	rtt      *prometheus.HistogramVec
+	// Registry of the metrics, registered on the first use
+	registry                    *prometheus.Registry
+	registryOnce                sync.Once
+	constLabels                 prometheus.Labels
+	nodes                       prometheus.Gauge
+	rtt                         *prometheus.HistogramVec
+	rttEdge                     *prometheus.HistogramVec
+	clientConnections           *prometheus.CounterVec
+	sampleAge                   *prometheus.GaugeVec
+	sampleAgeMu                 sync.Mutex
+	sampleAgeSeries             map[[3]string]float64
+	staleSamples                prometheus.Gauge
+	sampleStaleAfter            time.Duration
+	units                       data.UnitNormalizer
+	rounding                    data.SampleRounding
+	excludeSelfSamples          bool
+	selfId                      uint32
+	exportSamples               map[int64]bool
+	aggregator                  *aggregatorExport
+	probeDuration               *prometheus.HistogramVec
+	probeSuccess                *prometheus.GaugeVec
+	nodeLabels                  *prometheus.GaugeVec
+	probesInFlight              prometheus.Gauge
+	nodeLastSeen                *prometheus.GaugeVec
+	samplePropagation           *prometheus.HistogramVec
+	sampleClockSkew             prometheus.Counter
+	joinAttempts                prometheus.Counter
+	joinOutcomes                *prometheus.CounterVec
+	joinDuration                prometheus.Histogram
+	sampleWindowMin             *prometheus.GaugeVec
+	sampleWindowAvg             *prometheus.GaugeVec
+	sampleWindowMax             *prometheus.GaugeVec
+	peerClockSkew               *prometheus.GaugeVec
+	nodeHealthScore             *prometheus.GaugeVec
+	staleDiscoveries            prometheus.Counter
+	retryBudget                 prometheus.Gauge
+	retriesThrottled            *prometheus.CounterVec
+	dnsCacheLookups             *prometheus.CounterVec
+	sinkDropped                 *prometheus.CounterVec
+	sinkPublished               prometheus.Counter
+	heartbeatAge                *prometheus.GaugeVec
+	spilledSamples              prometheus.Gauge
+	samplePushFanout            prometheus.Gauge
+	sampleCoverage              prometheus.Gauge
+	meshSampleValue             *prometheus.GaugeVec
+	aggregatorDropped           prometheus.Gauge
+	probeOutcomes               *prometheus.CounterVec
+	indirectPings               *prometheus.CounterVec
+	sampleSyncs                 *prometheus.CounterVec
+	probeQueueDepth             *prometheus.GaugeVec
+	unauthenticatedRequests     *prometheus.CounterVec
+	suppressedDiscoveries       prometheus.Counter
+	connectionSecurity          *prometheus.GaugeVec
+	inboundActiveStreams        prometheus.Gauge
+	inboundRejected             *prometheus.CounterVec
+	peerVersions                *prometheus.GaugeVec
+	discoveryForwardsSuppressed *prometheus.CounterVec
+	unsyncedSampleAge           *prometheus.GaugeVec
+	statsdDropped               *prometheus.CounterVec
+	probingPaused               prometheus.Gauge
+	selfSamplesDropped          prometheus.Counter
+	sampleFieldValue            *prometheus.GaugeVec
+	rttAnomalies                *prometheus.CounterVec
+	requestLogSampleRate        prometheus.Gauge
+
+	// RTT histogram with edge labels of the measuring node,
+	// the node name is updated after a rename
+	rttEdgeLabels atomic.Bool
+	rttFrom       atomic.Value
 }
 
 // InitMetrics initializes the metrics and returns the PrometheusMetrics
 func InitMetrics() *PrometheusMetrics {
 	m := &PrometheusMetrics{
-		registry: prometheus.NewRegistry(),
-		rtt: prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Name: "rtt",
-				Help: "Round-trip-time to a mesh node",
-			},
-			[]string{"type", "to"},
-		),
+		rtt:     newRttHistogram(false),
+		rttEdge: newRttHistogram(true),
 		nodes: prometheus.NewGauge(prometheus.GaugeOpts{
 			Name: "node_count",
 			Help: "Total number of nodes",
 		}),
+		clientConnections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "client_connection_total",
+				Help: "Client connection events (dial, dial_failure, reuse, close, idle_evict) to a mesh node",
+			},
+			[]string{"event", "node"},
+		),
+		sampleAge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sample_age_seconds",
+				Help: "Age of the measurement samples in the mesh, stale samples included",
+			},
+			[]string{"type", "from", "to"},
+		),
+		staleSamples: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "stale_sample_count",
+			Help: "Total number of stale samples",
+		}),
+		probeDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "probe_duration_seconds",
+				Help: "Duration of a successful probe to an external target",
+			},
+			[]string{"type", "target"},
+		),
+		probeSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "probe_success",
+				Help: "Result of the last probe to an external target (1 success, 0 failure)",
+			},
+			[]string{"type", "target"},
+		),
+		nodeLabels: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "node_label",
+				Help: "Labels of the known nodes in the mesh, always 1",
+			},
+			[]string{"node", "label", "value"},
+		),
+		probesInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probes_in_flight",
+			Help: "Number of concurrent outbound probes (ping, rtt, push samples)",
+		}),
+		nodeLastSeen: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "node_last_seen_timestamp_seconds",
+				Help: "Unix timestamp of the last successful contact with a node",
+			},
+			[]string{"node"},
+		),
+		samplePropagation: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "sample_propagation_seconds",
+				Help:    "Time from the measurement of a sample until it is received by this node",
+				Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+			},
+			[]string{"hops"},
+		),
+		sampleClockSkew: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sample_clock_skew_total",
+			Help: "Received samples with a timestamp in the future, probably caused by clock skew",
+		}),
+		joinAttempts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "join_attempts_total",
+			Help: "Attempts to join a mesh",
+		}),
+		joinOutcomes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "join_outcomes_total",
+				Help: "Outcomes of the join attempts by the seed target, the target is empty if all targets failed",
+			},
+			[]string{"outcome", "target"},
+		),
+		joinDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "join_duration_seconds",
+			Help:    "Time from the first join attempt until the node joined a mesh",
+			Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900},
+		}),
+		sampleWindowMin: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sample_window_min",
+				Help: "Min. sample value of the last aggregation window, in the unit of the sample type",
+			},
+			[]string{"type", "from", "to"},
+		),
+		sampleWindowAvg: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sample_window_avg",
+				Help: "Avg. sample value of the last aggregation window, in the unit of the sample type",
+			},
+			[]string{"type", "from", "to"},
+		),
+		sampleWindowMax: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sample_window_max",
+				Help: "Max. sample value of the last aggregation window, in the unit of the sample type",
+			},
+			[]string{"type", "from", "to"},
+		),
+		peerClockSkew: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "peer_clock_skew_seconds",
+				Help: "Estimated clock skew of a node (to) relative to the pinging node (from), stale samples are excluded",
+			},
+			[]string{"from", "to"},
+		),
+		nodeHealthScore: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "node_health_score",
+				Help: "Health score 0-100 of a node (to) measured by a node (from), -1 with insufficient RTT measurements, stale samples are excluded",
+			},
+			[]string{"from", "to"},
+		),
+		staleDiscoveries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stale_discoveries_total",
+			Help: "Rejected node discoveries with a last contact older than the discovery max. age",
+		}),
+		retryBudget: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "retry_budget_tokens",
+			Help: "Remaining tokens of the retry budget, retries are throttled below 1",
+		}),
+		retriesThrottled: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "retries_throttled_total",
+				Help: "Retries skipped by an exhausted retry budget by routine",
+			},
+			[]string{"routine"},
+		),
+		dnsCacheLookups: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dns_cache_lookups_total",
+				Help: "Lookups of the DNS cache by result: hit, miss, stale (last good answer on failure) or error",
+			},
+			[]string{"result"},
+		),
+		sinkDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sink_dropped_samples_total",
+				Help: "Samples dropped by the sample sink by reason: overflow of the buffer or error",
+			},
+			[]string{"reason"},
+		),
+		sinkPublished: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "sink_published_samples_total",
+			Help: "Samples published by the sample sink",
+		}),
+		heartbeatAge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "heartbeat_age_seconds",
+				Help: "Age of the latest seen heartbeat of a node, a growing age shows a silent node",
+			},
+			[]string{"node"},
+		),
+		spilledSamples: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "spilled_sample_count",
+			Help: "Samples spilled to the disk-backed overflow of the sample store",
+		}),
+		samplePushFanout: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sample_push_fanout",
+			Help: "Healthy nodes the samples were pushed to in the last push round",
+		}),
+		sampleCoverage: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "sample_coverage_ratio",
+			Help: "Share of the healthy nodes whose samples are known by this node",
+		}),
+		meshSampleValue: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mesh_sample_value",
+				Help: "Value of the samples of the mesh in the unit of the sample type, exported by the aggregator; from_group is the probe group of the measuring node",
+			},
+			[]string{"type", "from", "to", FROM_GROUP_LABEL},
+		),
+		aggregatorDropped: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "aggregator_dropped_series",
+			Help: "Samples not exported by the aggregator, because the max. series are exceeded",
+		}),
+		probeOutcomes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "probe_outcomes_total",
+				Help: "Outcomes of the probes by probe type & reason",
+			},
+			[]string{"probe_type", "reason"},
+		),
+		indirectPings: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "indirect_pings_total",
+				Help: "Indirect pings of suspect nodes by result",
+			},
+			[]string{"result"},
+		),
+		sampleSyncs: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "sample_syncs_total",
+				Help: "Digest sample syncs by mode: in_sync, digest or full",
+			},
+			[]string{"mode"},
+		),
+		probeQueueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "probe_queue_depth",
+				Help: "Number of outbound probes waiting for a free slot by probe pool",
+			},
+			[]string{"pool"},
+		),
+		unauthenticatedRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "unauthenticated_requests_total",
+				Help: "Number of mesh RPCs rejected without a valid mesh token by reason",
+			},
+			[]string{"reason"},
+		),
+		suppressedDiscoveries: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "suppressed_discoveries_total",
+			Help: "Number of discovery RPCs saved by coalescing the joins within the join coalesce window",
+		}),
+		connectionSecurity: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "connection_security",
+				Help: "Security level (tls, insecure) negotiated for the connection to a mesh node, 1 for the used level",
+			},
+			[]string{"node", "level"},
+		),
+		inboundActiveStreams: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "inbound_active_streams",
+				Help: "Number of active inbound RPCs of the mesh server",
+			},
+		),
+		inboundRejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "inbound_rejected_total",
+				Help: "Inbound RPCs & connections of the mesh server rejected by a limit (streams, connections)",
+			},
+			[]string{"limit"},
+		),
+		peerVersions: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "mesh_peer_version",
+				Help: "Number of known mesh nodes by canary-bot version, unknown if not queried yet or not supported by the node",
+			},
+			[]string{"version"},
+		),
+		discoveryForwardsSuppressed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "discovery_forwards_suppressed_total",
+				Help: "Discoveries of new nodes not forwarded by this node, by reason (depth, known)",
+			},
+			[]string{"reason"},
+		),
+		unsyncedSampleAge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "unsynced_sample_age_seconds",
+				Help: "Age of the oldest sample not pushed to a peer since the last successful push, 0 if the peer caught up",
+			},
+			[]string{"peer"},
+		),
+		statsdDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "statsd_dropped_metrics_total",
+				Help: "Metrics not sent to the StatsD endpoint by reason (overflow, error)",
+			},
+			[]string{"reason"},
+		),
+		probingPaused: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "probing_paused",
+			Help: "Outbound probes of the node are paused (1) or running (0)",
+		}),
+		selfSamplesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "self_samples_dropped_total",
+			Help: "Self-referential samples dropped by the node, the measuring node is the measured node",
+		}),
+		sampleFieldValue: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "sample_field_value",
+				Help: "Numeric sub-fields of the multi-field samples in the mesh, e.g. the status & size of a HTTP probe; stale samples are excluded",
+			},
+			[]string{"type", "from", "to", "field"},
+		),
+		rttAnomalies: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rtt_anomaly_total",
+				Help: "RTT measurements to a mesh node deviating from the rolling baseline of the node by more than the anomaly threshold",
+			},
+			[]string{"node"},
+		),
+		requestLogSampleRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "request_log_sample_rate",
+			Help: "Effective sample rate (0-1) of the successful inbound RPCs logged by the request log, failed RPCs are always logged",
+		}),
 	}
 
-	// register metrics
-	m.registry.MustRegister(
-		m.rtt,
-		m.nodes,
-	)
-
 	return m
 }
 
-// GetRegistry returns the registry to register prometheus metrics
+// Register the metrics in the registry, the const labels are added to all metrics
+func (m *PrometheusMetrics) register() {
+	m.registry = prometheus.NewRegistry()
+	var registerer prometheus.Registerer = m.registry
+	if len(m.constLabels) > 0 {
+		registerer = prometheus.WrapRegistererWith(m.constLabels, m.registry)
+	}
+	registerer.MustRegister(m.collectors()...)
+}
+
+// Get all metrics of the registry, the RTT histogram with or without edge labels
+func (m *PrometheusMetrics) collectors() []prometheus.Collector {
+	rtt := m.rtt
+	if m.rttEdgeLabels.Load() {
+		rtt = m.rttEdge
+	}
+	return []prometheus.Collector{
+		rtt,
+		m.nodes,
+		m.clientConnections,
+		m.sampleAge,
+		m.staleSamples,
+		m.probeDuration,
+		m.probeSuccess,
+		m.nodeLabels,
+		m.probesInFlight,
+		m.nodeLastSeen,
+		m.samplePropagation,
+		m.sampleClockSkew,
+		m.joinAttempts,
+		m.joinOutcomes,
+		m.joinDuration,
+		m.sampleWindowMin,
+		m.sampleWindowAvg,
+		m.sampleWindowMax,
+		m.peerClockSkew,
+		m.nodeHealthScore,
+		m.staleDiscoveries,
+		m.retryBudget,
+		m.retriesThrottled,
+		m.dnsCacheLookups,
+		m.sinkDropped,
+		m.sinkPublished,
+		m.heartbeatAge,
+		m.spilledSamples,
+		m.samplePushFanout,
+		m.sampleCoverage,
+		m.meshSampleValue,
+		m.aggregatorDropped,
+		m.probeOutcomes,
+		m.indirectPings,
+		m.sampleSyncs,
+		m.probeQueueDepth,
+		m.unauthenticatedRequests,
+		m.suppressedDiscoveries,
+		m.connectionSecurity,
+		m.inboundActiveStreams,
+		m.inboundRejected,
+		m.peerVersions,
+		m.discoveryForwardsSuppressed,
+		m.unsyncedSampleAge,
+		m.statsdDropped,
+		m.probingPaused,
+		m.selfSamplesDropped,
+		m.sampleFieldValue,
+		m.rttAnomalies,
+		m.requestLogSampleRate,
+	}
+}
+
+// GetRegistry returns the registry to register prometheus metrics.
+// The metrics are registered on the first call, the labels of the metrics
+// (SetRttEdgeLabels, SetConstLabels) have to be set before.
 func (m *PrometheusMetrics) GetRegistry() *prometheus.Registry {
+	m.registryOnce.Do(m.register)
 	return m.registry
 }
 
 // Handler is a middleware to collect metrics
-func (m *PrometheusMetrics) Handler(data data.Database, h http.Handler) http.Handler {
+func (m *PrometheusMetrics) Handler(db data.Database, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// set node count
-		m.nodes.Set(float64(len(data.GetNodeList())))
+		// set node count & labels
+		nodeList := db.GetNodeList()
+		m.nodes.Set(float64(len(nodeList)))
+		m.nodeLabels.Reset()
+		m.nodeLastSeen.Reset()
+		for _, node := range nodeList {
+			if node.LastSeen > 0 {
+				m.nodeLastSeen.WithLabelValues(node.Name).Set(float64(node.LastSeen))
+			}
+			for label, value := range node.Labels {
+				m.nodeLabels.WithLabelValues(node.Name, label, value).Set(1)
+			}
+		}
+
+		// set sample & heartbeat age of all samples, the age of stale samples
+		// shows how long they are stale, e.g. of silent nodes.
+		// Set clock skew & health score, exclude stale samples.
+		// Just the exported sample keys are set, stale samples are counted for all keys.
+		ages := map[[3]string]float64{}
+		m.sampleFieldValue.Reset()
+		m.peerClockSkew.Reset()
+		m.nodeHealthScore.Reset()
+		m.heartbeatAge.Reset()
+		stale := 0
+		samples := db.GetSampleList()
+		for _, sample := range samples {
+			exported := m.ExportsSample(sample.Key)
+			if sample.Key == data.HEARTBEAT && exported {
+				m.heartbeatAge.WithLabelValues(sample.From).Set(sample.Age().Seconds())
+			}
+			if exported {
+				ages[[3]string{data.SampleName(sample.Key), sample.From, sample.To}] = sample.Age().Seconds()
+			}
+			if sample.IsStale(m.sampleStaleAfter) {
+				stale++
+				continue
+			}
+			if !exported {
+				continue
+			}
+			m.setSampleFields(sample)
+			if sample.Key == data.CLOCK_SKEW {
+				if skew, err := strconv.ParseInt(sample.Value, 10, 64); err == nil {
+					m.peerClockSkew.WithLabelValues(sample.From, sample.To).Set(time.Duration(skew).Seconds())
+				}
+			}
+			if sample.Key == data.HEALTH_SCORE && m.aggregates(sample) {
+				if score, err := strconv.ParseFloat(sample.Value, 64); err == nil {
+					m.nodeHealthScore.WithLabelValues(sample.From, sample.To).Set(m.rounding.Float(sample.Key, score))
+				}
+			}
+		}
+		m.setSampleAges(ages)
+		m.staleSamples.Set(float64(stale))
+		m.setMeshSamples(samples)
+		m.spilledSamples.Set(float64(db.GetSpilledSampleCount()))
+
 		h.ServeHTTP(w, r)
 	})
 }
 
+// Set the sample age series by type, from & to. The series of removed samples are
+// deleted instead of resetting all series, so a scrape never sees a partial set.
+func (m *PrometheusMetrics) setSampleAges(ages map[[3]string]float64) {
+	m.sampleAgeMu.Lock()
+	defer m.sampleAgeMu.Unlock()
+	for series := range m.sampleAgeSeries {
+		if _, ok := ages[series]; !ok {
+			m.sampleAge.DeleteLabelValues(series[:]...)
+		}
+	}
+	for series, age := range ages {
+		m.sampleAge.WithLabelValues(series[:]...).Set(age)
+	}
+	m.sampleAgeSeries = ages
+}
+
+// Set the numeric sub-fields of a multi-field sample, one series per sub-field.
+// Non-numeric sub-fields are skipped.
+func (m *PrometheusMetrics) setSampleFields(sample *data.Sample) {
+	for field, raw := range sample.Fields {
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(value) {
+			continue
+		}
+		m.sampleFieldValue.WithLabelValues(data.SampleName(sample.Key), sample.From, sample.To, field).Set(value)
+	}
+}
+
 // GetNodes returns the node count metric
 func (m *PrometheusMetrics) GetNodes() prometheus.Gauge {
 	return m.nodes
 }
 
-// GetRtt returns the rtt metric
+// GetRtt returns the rtt metric labeled by type & to,
+// exported if the RTT edge labels are disabled
 func (m *PrometheusMetrics) GetRtt() *prometheus.HistogramVec {
 	return m.rtt
 }
+
+// GetRttEdge returns the rtt metric labeled by type, from & to,
+// exported instead of GetRtt if the RTT edge labels are enabled
+func (m *PrometheusMetrics) GetRttEdge() *prometheus.HistogramVec {
+	return m.rttEdge
+}
+
+// GetClientConnections returns the client connection events metric
+func (m *PrometheusMetrics) GetClientConnections() *prometheus.CounterVec {
+	return m.clientConnections
+}
+
+// GetSampleAge returns the sample age metric
+func (m *PrometheusMetrics) GetSampleAge() *prometheus.GaugeVec {
+	return m.sampleAge
+}
+
+// GetStaleSamples returns the stale sample count metric
+func (m *PrometheusMetrics) GetStaleSamples() prometheus.Gauge {
+	return m.staleSamples
+}
+
+// SetSampleStaleAfter sets the age after which a sample is stale, 0 disables staleness
+func (m *PrometheusMetrics) SetSampleStaleAfter(staleAfter time.Duration) {
+	m.sampleStaleAfter = staleAfter
+}
+
+// SetUnits sets the units of the exported sample values per sample key
+func (m *PrometheusMetrics) SetUnits(units data.UnitNormalizer) {
+	m.units = units
+}
+
+// SetRounding sets the rounding of the exported sample values per sample key,
+// the values are rounded before converted to their unit
+func (m *PrometheusMetrics) SetRounding(rounding data.SampleRounding) {
+	m.rounding = rounding
+}
+
+// SetExcludeSelfSamples excludes the self-referential samples of the node of the id
+// (see data.Sample.IsSelfOf) from the aggregations: the sample windows, the aggregator
+// export & the health scores. The samples are still stored and exported by their sample age.
+func (m *PrometheusMetrics) SetExcludeSelfSamples(exclude bool, id uint32) {
+	m.excludeSelfSamples = exclude
+	m.selfId = id
+}
+
+// Check if a sample is part of the aggregations
+func (m *PrometheusMetrics) aggregates(sample *data.Sample) bool {
+	return !m.excludeSelfSamples || !sample.IsSelfOf(m.selfId)
+}
+
+// Get the exported value of a sample, rounded & converted to its unit
+func (m *PrometheusMetrics) exportValue(key int64, value float64) float64 {
+	return m.units.Float(key, m.rounding.Float(key, value))
+}
+
+// SetExportSamples sets the sample keys exported as metrics, all samples are exported if empty.
+// Not exported samples are still stored and available by the API.
+func (m *PrometheusMetrics) SetExportSamples(keys []int64) {
+	if len(keys) == 0 {
+		m.exportSamples = nil
+		return
+	}
+	m.exportSamples = make(map[int64]bool, len(keys))
+	for _, key := range keys {
+		m.exportSamples[key] = true
+	}
+}
+
+// ExportsSample reports if samples of the key are exported as metrics
+func (m *PrometheusMetrics) ExportsSample(key int64) bool {
+	return m.exportSamples == nil || m.exportSamples[key]
+}
+
+// GetProbeDuration returns the external probe duration metric
+func (m *PrometheusMetrics) GetProbeDuration() *prometheus.HistogramVec {
+	return m.probeDuration
+}
+
+// GetProbeSuccess returns the external probe success metric
+func (m *PrometheusMetrics) GetProbeSuccess() *prometheus.GaugeVec {
+	return m.probeSuccess
+}
+
+// GetNodeLabels returns the node label metric
+func (m *PrometheusMetrics) GetNodeLabels() *prometheus.GaugeVec {
+	return m.nodeLabels
+}
+
+// GetProbesInFlight returns the in-flight outbound probes metric
+func (m *PrometheusMetrics) GetProbesInFlight() prometheus.Gauge {
+	return m.probesInFlight
+}
+
+// GetNodeLastSeen returns the last successful contact metric
+func (m *PrometheusMetrics) GetNodeLastSeen() *prometheus.GaugeVec {
+	return m.nodeLastSeen
+}
+
+// GetSamplePropagation returns the sample propagation latency metric
+func (m *PrometheusMetrics) GetSamplePropagation() *prometheus.HistogramVec {
+	return m.samplePropagation
+}
+
+// GetSampleClockSkew returns the sample clock skew metric
+func (m *PrometheusMetrics) GetSampleClockSkew() prometheus.Counter {
+	return m.sampleClockSkew
+}
+
+// GetJoinAttempts returns the join attempts metric
+func (m *PrometheusMetrics) GetJoinAttempts() prometheus.Counter {
+	return m.joinAttempts
+}
+
+// GetJoinOutcomes returns the join outcomes metric
+func (m *PrometheusMetrics) GetJoinOutcomes() *prometheus.CounterVec {
+	return m.joinOutcomes
+}
+
+// GetJoinDuration returns the time-to-join metric
+func (m *PrometheusMetrics) GetJoinDuration() prometheus.Histogram {
+	return m.joinDuration
+}
+
+// GetSampleWindowMin returns the min. sample value of the aggregation window metric
+func (m *PrometheusMetrics) GetSampleWindowMin() *prometheus.GaugeVec {
+	return m.sampleWindowMin
+}
+
+// GetSampleWindowAvg returns the avg. sample value of the aggregation window metric
+func (m *PrometheusMetrics) GetSampleWindowAvg() *prometheus.GaugeVec {
+	return m.sampleWindowAvg
+}
+
+// GetSampleWindowMax returns the max. sample value of the aggregation window metric
+func (m *PrometheusMetrics) GetSampleWindowMax() *prometheus.GaugeVec {
+	return m.sampleWindowMax
+}
+
+// GetPeerClockSkew returns the peer clock skew metric
+func (m *PrometheusMetrics) GetPeerClockSkew() *prometheus.GaugeVec {
+	return m.peerClockSkew
+}
+
+// GetNodeHealthScore returns the node health score metric
+func (m *PrometheusMetrics) GetNodeHealthScore() *prometheus.GaugeVec {
+	return m.nodeHealthScore
+}
+
+// GetStaleDiscoveries returns the stale discovery count metric
+func (m *PrometheusMetrics) GetStaleDiscoveries() prometheus.Counter {
+	return m.staleDiscoveries
+}
+
+// GetRetryBudget returns the retry budget metric
+func (m *PrometheusMetrics) GetRetryBudget() prometheus.Gauge {
+	return m.retryBudget
+}
+
+// GetRetriesThrottled returns the throttled retries metric
+func (m *PrometheusMetrics) GetRetriesThrottled() *prometheus.CounterVec {
+	return m.retriesThrottled
+}
+
+// GetDnsCacheLookups returns the DNS cache lookups metric
+func (m *PrometheusMetrics) GetDnsCacheLookups() *prometheus.CounterVec {
+	return m.dnsCacheLookups
+}
+
+// GetSinkDropped returns the sink dropped samples metric
+func (m *PrometheusMetrics) GetSinkDropped() *prometheus.CounterVec {
+	return m.sinkDropped
+}
+
+// GetSinkPublished returns the sink published samples metric
+func (m *PrometheusMetrics) GetSinkPublished() prometheus.Counter {
+	return m.sinkPublished
+}
+
+// GetHeartbeatAge returns the heartbeat age metric
+func (m *PrometheusMetrics) GetHeartbeatAge() *prometheus.GaugeVec {
+	return m.heartbeatAge
+}
+
+// GetSpilledSamples returns the spilled samples metric
+func (m *PrometheusMetrics) GetSpilledSamples() prometheus.Gauge {
+	return m.spilledSamples
+}
+
+// GetSamplePushFanout returns the effective gossip fanout metric
+func (m *PrometheusMetrics) GetSamplePushFanout() prometheus.Gauge {
+	return m.samplePushFanout
+}
+
+// GetSampleCoverage returns the sample coverage metric
+func (m *PrometheusMetrics) GetSampleCoverage() prometheus.Gauge {
+	return m.sampleCoverage
+}
+
+// GetMeshSampleValue returns the mesh sample value metric of the aggregator
+func (m *PrometheusMetrics) GetMeshSampleValue() *prometheus.GaugeVec {
+	return m.meshSampleValue
+}
+
+// GetAggregatorDropped returns the dropped series metric of the aggregator
+func (m *PrometheusMetrics) GetAggregatorDropped() prometheus.Gauge {
+	return m.aggregatorDropped
+}
+
+// GetProbeOutcomes returns the probe outcome metric
+func (m *PrometheusMetrics) GetProbeOutcomes() *prometheus.CounterVec {
+	return m.probeOutcomes
+}
+
+// GetIndirectPings returns the indirect ping metric
+func (m *PrometheusMetrics) GetIndirectPings() *prometheus.CounterVec {
+	return m.indirectPings
+}
+
+// GetSampleSyncs returns the sample sync metric
+func (m *PrometheusMetrics) GetSampleSyncs() *prometheus.CounterVec {
+	return m.sampleSyncs
+}
+
+// GetProbeQueueDepth returns the probe queue depth metric
+func (m *PrometheusMetrics) GetProbeQueueDepth() *prometheus.GaugeVec {
+	return m.probeQueueDepth
+}
+
+// GetUnauthenticatedRequests returns the unauthenticated requests metric
+func (m *PrometheusMetrics) GetUnauthenticatedRequests() *prometheus.CounterVec {
+	return m.unauthenticatedRequests
+}
+
+// GetSuppressedDiscoveries returns the suppressed discoveries metric
+func (m *PrometheusMetrics) GetSuppressedDiscoveries() prometheus.Counter {
+	return m.suppressedDiscoveries
+}
+
+// GetConnectionSecurity returns the connection security level gauge of the mesh nodes
+func (m *PrometheusMetrics) GetConnectionSecurity() *prometheus.GaugeVec {
+	return m.connectionSecurity
+}
+
+// GetInboundActiveStreams returns the active inbound streams gauge
+func (m *PrometheusMetrics) GetInboundActiveStreams() prometheus.Gauge {
+	return m.inboundActiveStreams
+}
+
+// GetInboundRejected returns the rejected inbound RPCs & connections counter
+func (m *PrometheusMetrics) GetInboundRejected() *prometheus.CounterVec {
+	return m.inboundRejected
+}
+
+// GetPeerVersions returns the peer version gauge of the known mesh nodes
+func (m *PrometheusMetrics) GetPeerVersions() *prometheus.GaugeVec {
+	return m.peerVersions
+}
+
+// GetDiscoveryForwardsSuppressed returns the suppressed discovery forwards metric
+func (m *PrometheusMetrics) GetDiscoveryForwardsSuppressed() *prometheus.CounterVec {
+	return m.discoveryForwardsSuppressed
+}
+
+// GetUnsyncedSampleAge returns the unsynced sample age gauge per peer
+func (m *PrometheusMetrics) GetUnsyncedSampleAge() *prometheus.GaugeVec {
+	return m.unsyncedSampleAge
+}
+
+// GetStatsdDropped returns the StatsD dropped metrics metric
+func (m *PrometheusMetrics) GetStatsdDropped() *prometheus.CounterVec {
+	return m.statsdDropped
+}
+
+// GetProbingPaused returns the probing paused metric
+func (m *PrometheusMetrics) GetProbingPaused() prometheus.Gauge {
+	return m.probingPaused
+}
+
+// GetSelfSamplesDropped returns the dropped self-referential sample metric
+func (m *PrometheusMetrics) GetSelfSamplesDropped() prometheus.Counter {
+	return m.selfSamplesDropped
+}
+
+// GetSampleFieldValue returns the sample sub-field metric
+func (m *PrometheusMetrics) GetSampleFieldValue() *prometheus.GaugeVec {
+	return m.sampleFieldValue
+}
+
+// GetRttAnomalies returns the RTT anomaly metric
+func (m *PrometheusMetrics) GetRttAnomalies() *prometheus.CounterVec {
+	return m.rttAnomalies
+}
+
+// GetRequestLogSampleRate returns the request log sample rate metric
+func (m *PrometheusMetrics) GetRequestLogSampleRate() prometheus.Gauge {
+	return m.requestLogSampleRate
+}
@@ -25,6 +25,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/telekom/canary-bot/data"
 	"go.uber.org/zap"
@@ -54,6 +55,374 @@ func TestGetRtt(t *testing.T) {
 	}
 }
 
+func TestGetClientConnections(t *testing.T) {
+	m := InitMetrics()
+	clientConnections := m.GetClientConnections()
+	if clientConnections == nil {
+		t.Error("clientConnections is nil")
+	}
+}
+
+func TestGetSampleAge(t *testing.T) {
+	m := InitMetrics()
+	sampleAge := m.GetSampleAge()
+	if sampleAge == nil {
+		t.Error("sampleAge is nil")
+	}
+}
+
+func TestGetStaleSamples(t *testing.T) {
+	m := InitMetrics()
+	staleSamples := m.GetStaleSamples()
+	if staleSamples == nil {
+		t.Error("staleSamples is nil")
+	}
+}
+
+func TestGetProbeDuration(t *testing.T) {
+	m := InitMetrics()
+	probeDuration := m.GetProbeDuration()
+	if probeDuration == nil {
+		t.Error("probeDuration is nil")
+	}
+}
+
+func TestGetProbeSuccess(t *testing.T) {
+	m := InitMetrics()
+	probeSuccess := m.GetProbeSuccess()
+	if probeSuccess == nil {
+		t.Error("probeSuccess is nil")
+	}
+}
+
+func TestGetNodeLabels(t *testing.T) {
+	m := InitMetrics()
+	nodeLabels := m.GetNodeLabels()
+	if nodeLabels == nil {
+		t.Error("nodeLabels is nil")
+	}
+}
+
+func TestGetProbesInFlight(t *testing.T) {
+	m := InitMetrics()
+	probesInFlight := m.GetProbesInFlight()
+	if probesInFlight == nil {
+		t.Error("probesInFlight is nil")
+	}
+}
+
+func TestGetNodeLastSeen(t *testing.T) {
+	m := InitMetrics()
+	nodeLastSeen := m.GetNodeLastSeen()
+	if nodeLastSeen == nil {
+		t.Error("nodeLastSeen is nil")
+	}
+}
+
+func TestGetSamplePropagation(t *testing.T) {
+	m := InitMetrics()
+	samplePropagation := m.GetSamplePropagation()
+	if samplePropagation == nil {
+		t.Error("samplePropagation is nil")
+	}
+}
+
+func TestGetSampleClockSkew(t *testing.T) {
+	m := InitMetrics()
+	sampleClockSkew := m.GetSampleClockSkew()
+	if sampleClockSkew == nil {
+		t.Error("sampleClockSkew is nil")
+	}
+}
+
+func TestGetJoinAttempts(t *testing.T) {
+	m := InitMetrics()
+	joinAttempts := m.GetJoinAttempts()
+	if joinAttempts == nil {
+		t.Error("joinAttempts is nil")
+	}
+}
+
+func TestGetJoinOutcomes(t *testing.T) {
+	m := InitMetrics()
+	joinOutcomes := m.GetJoinOutcomes()
+	if joinOutcomes == nil {
+		t.Error("joinOutcomes is nil")
+	}
+}
+
+func TestGetJoinDuration(t *testing.T) {
+	m := InitMetrics()
+	joinDuration := m.GetJoinDuration()
+	if joinDuration == nil {
+		t.Error("joinDuration is nil")
+	}
+}
+
+func TestGetSampleWindowMin(t *testing.T) {
+	m := InitMetrics()
+	sampleWindowMin := m.GetSampleWindowMin()
+	if sampleWindowMin == nil {
+		t.Error("sampleWindowMin is nil")
+	}
+}
+
+func TestGetSampleWindowAvg(t *testing.T) {
+	m := InitMetrics()
+	sampleWindowAvg := m.GetSampleWindowAvg()
+	if sampleWindowAvg == nil {
+		t.Error("sampleWindowAvg is nil")
+	}
+}
+
+func TestGetSampleWindowMax(t *testing.T) {
+	m := InitMetrics()
+	sampleWindowMax := m.GetSampleWindowMax()
+	if sampleWindowMax == nil {
+		t.Error("sampleWindowMax is nil")
+	}
+}
+
+func TestGetPeerClockSkew(t *testing.T) {
+	m := InitMetrics()
+	peerClockSkew := m.GetPeerClockSkew()
+	if peerClockSkew == nil {
+		t.Error("peerClockSkew is nil")
+	}
+}
+
+func TestGetNodeHealthScore(t *testing.T) {
+	m := InitMetrics()
+	nodeHealthScore := m.GetNodeHealthScore()
+	if nodeHealthScore == nil {
+		t.Error("nodeHealthScore is nil")
+	}
+}
+
+func TestGetStaleDiscoveries(t *testing.T) {
+	m := InitMetrics()
+	staleDiscoveries := m.GetStaleDiscoveries()
+	if staleDiscoveries == nil {
+		t.Error("staleDiscoveries is nil")
+	}
+}
+
+func TestGetRetryBudget(t *testing.T) {
+	m := InitMetrics()
+	retryBudget := m.GetRetryBudget()
+	if retryBudget == nil {
+		t.Error("retryBudget is nil")
+	}
+}
+
+func TestGetRetriesThrottled(t *testing.T) {
+	m := InitMetrics()
+	retriesThrottled := m.GetRetriesThrottled()
+	if retriesThrottled == nil {
+		t.Error("retriesThrottled is nil")
+	}
+}
+
+func TestGetDnsCacheLookups(t *testing.T) {
+	m := InitMetrics()
+	dnsCacheLookups := m.GetDnsCacheLookups()
+	if dnsCacheLookups == nil {
+		t.Error("dnsCacheLookups is nil")
+	}
+}
+
+func TestGetSinkDropped(t *testing.T) {
+	m := InitMetrics()
+	sinkDropped := m.GetSinkDropped()
+	if sinkDropped == nil {
+		t.Error("sinkDropped is nil")
+	}
+}
+
+func TestGetSinkPublished(t *testing.T) {
+	m := InitMetrics()
+	sinkPublished := m.GetSinkPublished()
+	if sinkPublished == nil {
+		t.Error("sinkPublished is nil")
+	}
+}
+
+func TestGetHeartbeatAge(t *testing.T) {
+	m := InitMetrics()
+	heartbeatAge := m.GetHeartbeatAge()
+	if heartbeatAge == nil {
+		t.Error("heartbeatAge is nil")
+	}
+}
+
+func TestGetSpilledSamples(t *testing.T) {
+	m := InitMetrics()
+	spilledSamples := m.GetSpilledSamples()
+	if spilledSamples == nil {
+		t.Error("spilledSamples is nil")
+	}
+}
+
+func TestGetSamplePushFanout(t *testing.T) {
+	m := InitMetrics()
+	samplePushFanout := m.GetSamplePushFanout()
+	if samplePushFanout == nil {
+		t.Error("samplePushFanout is nil")
+	}
+}
+
+func TestGetSampleCoverage(t *testing.T) {
+	m := InitMetrics()
+	sampleCoverage := m.GetSampleCoverage()
+	if sampleCoverage == nil {
+		t.Error("sampleCoverage is nil")
+	}
+}
+
+func TestGetMeshSampleValue(t *testing.T) {
+	m := InitMetrics()
+	meshSampleValue := m.GetMeshSampleValue()
+	if meshSampleValue == nil {
+		t.Error("meshSampleValue is nil")
+	}
+}
+
+func TestGetAggregatorDropped(t *testing.T) {
+	m := InitMetrics()
+	aggregatorDropped := m.GetAggregatorDropped()
+	if aggregatorDropped == nil {
+		t.Error("aggregatorDropped is nil")
+	}
+}
+
+func TestGetProbeOutcomes(t *testing.T) {
+	m := InitMetrics()
+	probeOutcomes := m.GetProbeOutcomes()
+	if probeOutcomes == nil {
+		t.Error("probeOutcomes is nil")
+	}
+}
+
+func TestGetIndirectPings(t *testing.T) {
+	m := InitMetrics()
+	indirectPings := m.GetIndirectPings()
+	if indirectPings == nil {
+		t.Error("indirectPings is nil")
+	}
+}
+
+func TestGetSampleSyncs(t *testing.T) {
+	m := InitMetrics()
+	sampleSyncs := m.GetSampleSyncs()
+	if sampleSyncs == nil {
+		t.Error("sampleSyncs is nil")
+	}
+}
+
+func TestGetProbeQueueDepth(t *testing.T) {
+	m := InitMetrics()
+	probeQueueDepth := m.GetProbeQueueDepth()
+	if probeQueueDepth == nil {
+		t.Error("probeQueueDepth is nil")
+	}
+}
+
+func TestGetUnauthenticatedRequests(t *testing.T) {
+	m := InitMetrics()
+	unauthenticatedRequests := m.GetUnauthenticatedRequests()
+	if unauthenticatedRequests == nil {
+		t.Error("unauthenticatedRequests is nil")
+	}
+}
+
+func TestGetSuppressedDiscoveries(t *testing.T) {
+	m := InitMetrics()
+	suppressedDiscoveries := m.GetSuppressedDiscoveries()
+	if suppressedDiscoveries == nil {
+		t.Error("suppressedDiscoveries is nil")
+	}
+}
+
+func TestGetConnectionSecurity(t *testing.T) {
+	m := InitMetrics()
+	connectionSecurity := m.GetConnectionSecurity()
+	if connectionSecurity == nil {
+		t.Error("connectionSecurity is nil")
+	}
+}
+
+func TestGetInboundActiveStreams(t *testing.T) {
+	m := InitMetrics()
+	inboundActiveStreams := m.GetInboundActiveStreams()
+	if inboundActiveStreams == nil {
+		t.Error("inboundActiveStreams is nil")
+	}
+}
+
+func TestGetInboundRejected(t *testing.T) {
+	m := InitMetrics()
+	inboundRejected := m.GetInboundRejected()
+	if inboundRejected == nil {
+		t.Error("inboundRejected is nil")
+	}
+}
+
+func TestGetPeerVersions(t *testing.T) {
+	m := InitMetrics()
+	peerVersions := m.GetPeerVersions()
+	if peerVersions == nil {
+		t.Error("peerVersions is nil")
+	}
+}
+
+func TestGetDiscoveryForwardsSuppressed(t *testing.T) {
+	m := InitMetrics()
+	discoveryForwardsSuppressed := m.GetDiscoveryForwardsSuppressed()
+	if discoveryForwardsSuppressed == nil {
+		t.Error("discoveryForwardsSuppressed is nil")
+	}
+}
+
+func TestGetUnsyncedSampleAge(t *testing.T) {
+	m := InitMetrics()
+	unsyncedSampleAge := m.GetUnsyncedSampleAge()
+	if unsyncedSampleAge == nil {
+		t.Error("unsyncedSampleAge is nil")
+	}
+}
+
+func TestGetStatsdDropped(t *testing.T) {
+	m := InitMetrics()
+	statsdDropped := m.GetStatsdDropped()
+	if statsdDropped == nil {
+		t.Error("statsdDropped is nil")
+	}
+}
+
+func TestGetProbingPaused(t *testing.T) {
+	m := InitMetrics()
+	probingPaused := m.GetProbingPaused()
+	if probingPaused == nil {
+		t.Error("probingPaused is nil")
+	}
+}
+
+func TestGetSelfSamplesDropped(t *testing.T) {
+	m := InitMetrics()
+	selfSamplesDropped := m.GetSelfSamplesDropped()
+	if selfSamplesDropped == nil {
+		t.Error("selfSamplesDropped is nil")
+	}
+}
+
+func TestGetSampleFieldValue(t *testing.T) {
+	m := InitMetrics()
+	sampleFieldValue := m.GetSampleFieldValue()
+	if sampleFieldValue == nil {
+		t.Error("sampleFieldValue is nil")
+	}
+}
+
 func TestHandler(t *testing.T) {
 	m := InitMetrics()
 	logger, err := zap.NewDevelopment()
@@ -79,3 +448,122 @@ func TestHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 }
+
+func TestHandlerHeartbeatAge(t *testing.T) {
+	m := InitMetrics()
+	m.SetSampleStaleAfter(time.Second * 10)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a stalled heartbeat is stale, but its age is still exported
+	db.SetSample(&data.Sample{From: "a", To: "a", Key: data.HEARTBEAT, Value: "3", Ts: time.Now().Add(-time.Minute).Unix()})
+
+	handler := m.Handler(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/metrics", nil))
+
+	ages := gatherValues(t, m, "heartbeat_age_seconds")
+	if len(ages) != 1 || ages[0] < 60 {
+		t.Errorf("Expected the heartbeat age of a to be at least 60s, got %v", ages)
+	}
+}
+
+func TestHandlerSampleAge(t *testing.T) {
+	m := InitMetrics()
+	m.SetSampleStaleAfter(time.Second * 10)
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := &data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Ts: time.Now().Unix()}
+	db.SetSample(fresh)
+	db.SetSample(&data.Sample{From: "a", To: "c", Key: data.RTT_TOTAL, Value: "1", Ts: time.Now().Add(-time.Minute).Unix()})
+	handler := m.Handler(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// the age of the stale sample is exported as well
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/metrics", nil))
+	ages := gatherValues(t, m, "sample_age_seconds")
+	if len(ages) != 2 {
+		t.Fatalf("Expected the age of the fresh & the stale sample, got %v", ages)
+	}
+	if stale := gatherValues(t, m, "stale_sample_count"); len(stale) != 1 || stale[0] != 1 {
+		t.Errorf("Expected 1 stale sample, got %v", stale)
+	}
+
+	// the series of a removed sample is deleted
+	db.DeleteSample(data.GetSampleId(fresh))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/metrics", nil))
+	if ages := gatherValues(t, m, "sample_age_seconds"); len(ages) != 1 || ages[0] < 60 {
+		t.Errorf("Expected the age of the stale sample only, got %v", ages)
+	}
+}
+
+func TestHandlerSampleFields(t *testing.T) {
+	m := InitMetrics()
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSample(&data.Sample{From: "a", To: "http://example.com", Key: data.RTT_TOTAL, Value: "1", Ts: time.Now().Unix(), Fields: map[string]string{"status": "200", "size": "42", "reason": "ok"}})
+	db.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Ts: time.Now().Unix()})
+
+	handler := m.Handler(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/metrics", nil))
+	// one series per numeric sub-field
+	if values := gatherValues(t, m, "sample_field_value"); len(values) != 2 {
+		t.Errorf("Expected the status & size sub-fields, got %v", values)
+	}
+}
+
+func TestHandlerExportSamples(t *testing.T) {
+	m := InitMetrics()
+	db, err := data.NewMemDB(zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetSample(&data.Sample{From: "a", To: "b", Key: data.RTT_TOTAL, Value: "1", Ts: time.Now().Unix()})
+	db.SetSample(&data.Sample{From: "a", To: "b", Key: data.HEALTH_SCORE, Value: "0.5", Ts: time.Now().Unix()})
+	db.SetSample(&data.Sample{From: "a", To: "a", Key: data.HEARTBEAT, Value: "3", Ts: time.Now().Unix()})
+	handler := m.Handler(db, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/metrics", nil))
+	if ages := gatherValues(t, m, "sample_age_seconds"); len(ages) != 3 {
+		t.Errorf("Expected the age of all 3 samples by default, got %v", ages)
+	}
+
+	m.SetExportSamples([]int64{data.RTT_TOTAL})
+	if !m.ExportsSample(data.RTT_TOTAL) || m.ExportsSample(data.HEALTH_SCORE) {
+		t.Error("Expected just rtt_total to be exported")
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/metrics", nil))
+	if ages := gatherValues(t, m, "sample_age_seconds"); len(ages) != 1 {
+		t.Errorf("Expected just the age of the rtt sample, got %v", ages)
+	}
+	if scores := gatherValues(t, m, "node_health_score"); len(scores) != 0 {
+		t.Errorf("Expected no health score export, got %v", scores)
+	}
+	if ages := gatherValues(t, m, "heartbeat_age_seconds"); len(ages) != 0 {
+		t.Errorf("Expected no heartbeat age export, got %v", ages)
+	}
+
+	m.SetExportSamples(nil)
+	if !m.ExportsSample(data.HEALTH_SCORE) {
+		t.Error("Expected all samples to be exported without a list")
+	}
+}
+
+func TestGetRttAnomalies(t *testing.T) {
+	m := InitMetrics()
+	rttAnomalies := m.GetRttAnomalies()
+	if rttAnomalies == nil {
+		t.Error("rttAnomalies is nil")
+	}
+}
+
+func TestGetRequestLogSampleRate(t *testing.T) {
+	m := InitMetrics()
+	requestLogSampleRate := m.GetRequestLogSampleRate()
+	if requestLogSampleRate == nil {
+		t.Error("requestLogSampleRate is nil")
+	}
+}
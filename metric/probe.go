@@ -0,0 +1,108 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Reasons of the probe outcomes, a fixed set to bound the cardinality
+const (
+	PROBE_OK        = "ok"
+	PROBE_TIMEOUT   = "timeout"
+	PROBE_REFUSED   = "refused"
+	PROBE_TLS_ERROR = "tls_error"
+	PROBE_DNS_ERROR = "dns_error"
+	PROBE_ERROR     = "error"
+	// QUIC failures of HTTP/3 probes
+	PROBE_QUIC_VERSION_NEGOTIATION = "quic_version_negotiation"
+	PROBE_QUIC_0RTT_REJECTED       = "quic_0rtt_rejected"
+	// gRPC health check responded with a status other than SERVING
+	PROBE_NOT_SERVING = "not_serving"
+)
+
+// QUIC errors of a HTTP/3 transport. The errors of quic-go are wrapped by the
+// probe, a transport of an embedder wraps the errors of its implementation,
+// e.g. fmt.Errorf("%w: %v", metric.ErrQuicVersionNegotiation, err).
+var (
+	ErrQuicVersionNegotiation = errors.New("quic version negotiation failed")
+	ErrQuic0RttRejected       = errors.New("quic 0-rtt rejected")
+)
+
+// ProbeReason classifies the error of a probe by the probe outcome reasons.
+// Errors of other reasons are classified as PROBE_ERROR.
+func ProbeReason(err error) string {
+	if err == nil {
+		return PROBE_OK
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || status.Code(err) == codes.DeadlineExceeded ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return PROBE_TIMEOUT
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return PROBE_DNS_ERROR
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) || errors.As(err, &hostname) {
+		return PROBE_TLS_ERROR
+	}
+
+	if errors.Is(err, ErrQuicVersionNegotiation) {
+		return PROBE_QUIC_VERSION_NEGOTIATION
+	}
+	if errors.Is(err, ErrQuic0RttRejected) {
+		return PROBE_QUIC_0RTT_REJECTED
+	}
+
+	// gRPC errors just keep the message of the cause
+	msg := err.Error()
+	if strings.HasPrefix(msg, "grpc health status") {
+		return PROBE_NOT_SERVING
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || strings.Contains(msg, "connection refused") {
+		return PROBE_REFUSED
+	}
+	if strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:") {
+		return PROBE_TLS_ERROR
+	}
+	return PROBE_ERROR
+}
+
+// ObserveProbe counts the outcome of a probe by probe type & reason
+func (m *PrometheusMetrics) ObserveProbe(probeType string, err error) {
+	m.probeOutcomes.WithLabelValues(probeType, ProbeReason(err)).Inc()
+}
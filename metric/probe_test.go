@@ -0,0 +1,83 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProbeReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "ok", err: nil, expected: PROBE_OK},
+		{name: "context deadline", err: fmt.Errorf("probe: %w", context.DeadlineExceeded), expected: PROBE_TIMEOUT},
+		{name: "grpc deadline", err: status.Error(codes.DeadlineExceeded, "deadline"), expected: PROBE_TIMEOUT},
+		{name: "net timeout", err: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, expected: PROBE_TIMEOUT},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}, expected: PROBE_REFUSED},
+		{name: "grpc refused", err: status.Error(codes.Unavailable, "dial tcp 127.0.0.1:1: connect: connection refused"), expected: PROBE_REFUSED},
+		{name: "dns", err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, expected: PROBE_DNS_ERROR},
+		{name: "unknown authority", err: fmt.Errorf("get: %w", x509.UnknownAuthorityError{}), expected: PROBE_TLS_ERROR},
+		{name: "tls alert", err: errors.New("remote error: tls: handshake failure"), expected: PROBE_TLS_ERROR},
+		{name: "quic version negotiation", err: fmt.Errorf("%w: no compatible QUIC version found", ErrQuicVersionNegotiation), expected: PROBE_QUIC_VERSION_NEGOTIATION},
+		{name: "quic 0-rtt rejected", err: fmt.Errorf("get: %w", ErrQuic0RttRejected), expected: PROBE_QUIC_0RTT_REJECTED},
+		{name: "unwrapped quic error", err: errors.New("0-RTT rejected"), expected: PROBE_ERROR},
+		{name: "grpc not serving", err: errors.New("grpc health status NOT_SERVING"), expected: PROBE_NOT_SERVING},
+		{name: "other", err: errors.New("http status 500"), expected: PROBE_ERROR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if reason := ProbeReason(tt.err); reason != tt.expected {
+				t.Errorf("Expected reason %v, got %v", tt.expected, reason)
+			}
+		})
+	}
+}
+
+func TestObserveProbe(t *testing.T) {
+	m := InitMetrics()
+	m.ObserveProbe("tcp", nil)
+	m.ObserveProbe("tcp", errors.New("http status 500"))
+	m.ObserveProbe("tcp", nil)
+
+	outcomes := map[string][]float64{}
+	for _, reason := range []string{PROBE_OK, PROBE_ERROR} {
+		outcomes[reason] = gatherValues(t, m, "probe_outcomes_total", "reason", reason)
+	}
+	if !reflect.DeepEqual(outcomes, map[string][]float64{PROBE_OK: {2}, PROBE_ERROR: {1}}) {
+		t.Errorf("Expected 2 ok & 1 error outcomes, got %v", outcomes)
+	}
+}
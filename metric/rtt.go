@@ -0,0 +1,58 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// RTT histogram labeled by the RTT type and the measured node,
+// with edge labels by the measuring node as well
+func newRttHistogram(edgeLabels bool) *prometheus.HistogramVec {
+	labels := []string{"type", "to"}
+	if edgeLabels {
+		labels = []string{"type", "from", "to"}
+	}
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "rtt",
+			Help: "Round-trip-time to a mesh node",
+		},
+		labels,
+	)
+}
+
+// SetRttEdgeLabels labels the RTT histogram by the measuring (from) node,
+// so the RTTs scraped from all nodes form a latency matrix. The histogram
+// of GetRttEdge is exported instead of GetRtt, since a registry does not
+// accept other labels of a metric name; set it before the registry is used.
+// Set it again to update the name of a renamed node.
+func (m *PrometheusMetrics) SetRttEdgeLabels(from string) {
+	m.rttFrom.Store(from)
+	m.rttEdgeLabels.Store(true)
+}
+
+// GetRttObserver returns the RTT observer of a RTT type to a node
+func (m *PrometheusMetrics) GetRttObserver(rttType string, to string) prometheus.Observer {
+	if m.rttEdgeLabels.Load() {
+		return m.rttEdge.WithLabelValues(rttType, m.rttFrom.Load().(string), to)
+	}
+	return m.rtt.WithLabelValues(rttType, to)
+}
@@ -0,0 +1,93 @@
+/*
+ * canary-bot
+ *
+ * (C) 2022, Maximilian Schubert, Deutsche Telekom IT GmbH
+ *
+ * Deutsche Telekom IT GmbH and all other contributors /
+ * copyright owners license this file to you under the Apache
+ * License, Version 2.0 (the "License"); you may not use this
+ * file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+// Get the labels of the rtt series
+func rttLabels(t *testing.T, m *PrometheusMetrics) []map[string]string {
+	families, err := m.GetRegistry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var series []map[string]string
+	for _, family := range families {
+		if family.GetName() != "rtt" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			series = append(series, labels)
+		}
+	}
+	return series
+}
+
+func TestGetRttObserver(t *testing.T) {
+	m := InitMetrics()
+	m.GetRttObserver("rtt_total", "b").Observe(0.1)
+	if diff := deep.Equal(rttLabels(t, m), []map[string]string{{"type": "rtt_total", "to": "b"}}); diff != nil {
+		t.Error(diff)
+	}
+
+	m = InitMetrics()
+	m.SetRttEdgeLabels("a")
+	m.GetRttObserver("rtt_total", "b").Observe(0.1)
+	// the labels of GetRtt are stable, the histogram is not exported
+	m.GetRtt().WithLabelValues("rtt_total", "c").Observe(0.1)
+	if diff := deep.Equal(rttLabels(t, m), []map[string]string{{"type": "rtt_total", "from": "a", "to": "b"}}); diff != nil {
+		t.Error(diff)
+	}
+	m.GetRttEdge().WithLabelValues("rtt_total", "a", "c").Observe(0.1)
+	if series := rttLabels(t, m); len(series) != 2 {
+		t.Errorf("Expected 2 series of the edge histogram, got %v", series)
+	}
+	// a renamed node observes by the new name
+	m.SetRttEdgeLabels("a-1")
+	m.GetRttObserver("rtt_total", "b").Observe(0.1)
+	if series := rttLabels(t, m); len(series) != 3 {
+		t.Errorf("Expected 3 series of the edge histogram after a rename, got %v", series)
+	}
+}
+
+func TestGetRegistryOnce(t *testing.T) {
+	m := InitMetrics()
+	m.SetRttEdgeLabels("a")
+	if err := m.SetConstLabels(map[string]string{"cluster": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	registry := m.GetRegistry()
+	if m.GetRegistry() != registry {
+		t.Error("Expected the registry to be built once")
+	}
+	// a collision with the edge labels is detected without edge labels as well
+	if err := InitMetrics().SetConstLabels(map[string]string{"from": "a"}); err == nil {
+		t.Error("Expected a collision with the from label of the RTT edge histogram")
+	}
+}
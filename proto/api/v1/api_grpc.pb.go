@@ -24,6 +24,9 @@ const _ = grpc.SupportPackageIsVersion7
 type ApiServiceClient interface {
 	ListSamples(ctx context.Context, in *ListSampleRequest, opts ...grpc.CallOption) (*ListSampleResponse, error)
 	ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error)
+	ListSampleTypes(ctx context.Context, in *ListSampleTypesRequest, opts ...grpc.CallOption) (*ListSampleTypesResponse, error)
+	ListHealthScores(ctx context.Context, in *ListHealthScoresRequest, opts ...grpc.CallOption) (*ListHealthScoresResponse, error)
+	ListEvents(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error)
 }
 
 type apiServiceClient struct {
@@ -52,12 +55,42 @@ func (c *apiServiceClient) ListNodes(ctx context.Context, in *ListNodesRequest,
 	return out, nil
 }
 
+func (c *apiServiceClient) ListSampleTypes(ctx context.Context, in *ListSampleTypesRequest, opts ...grpc.CallOption) (*ListSampleTypesResponse, error) {
+	out := new(ListSampleTypesResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.ApiService/ListSampleTypes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiServiceClient) ListHealthScores(ctx context.Context, in *ListHealthScoresRequest, opts ...grpc.CallOption) (*ListHealthScoresResponse, error) {
+	out := new(ListHealthScoresResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.ApiService/ListHealthScores", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *apiServiceClient) ListEvents(ctx context.Context, in *ListEventsRequest, opts ...grpc.CallOption) (*ListEventsResponse, error) {
+	out := new(ListEventsResponse)
+	err := c.cc.Invoke(ctx, "/api.v1.ApiService/ListEvents", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ApiServiceServer is the server API for ApiService service.
 // All implementations must embed UnimplementedApiServiceServer
 // for forward compatibility
 type ApiServiceServer interface {
 	ListSamples(context.Context, *ListSampleRequest) (*ListSampleResponse, error)
 	ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error)
+	ListSampleTypes(context.Context, *ListSampleTypesRequest) (*ListSampleTypesResponse, error)
+	ListHealthScores(context.Context, *ListHealthScoresRequest) (*ListHealthScoresResponse, error)
+	ListEvents(context.Context, *ListEventsRequest) (*ListEventsResponse, error)
 	mustEmbedUnimplementedApiServiceServer()
 }
 
@@ -71,6 +104,15 @@ func (UnimplementedApiServiceServer) ListSamples(context.Context, *ListSampleReq
 func (UnimplementedApiServiceServer) ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListNodes not implemented")
 }
+func (UnimplementedApiServiceServer) ListSampleTypes(context.Context, *ListSampleTypesRequest) (*ListSampleTypesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSampleTypes not implemented")
+}
+func (UnimplementedApiServiceServer) ListHealthScores(context.Context, *ListHealthScoresRequest) (*ListHealthScoresResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListHealthScores not implemented")
+}
+func (UnimplementedApiServiceServer) ListEvents(context.Context, *ListEventsRequest) (*ListEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListEvents not implemented")
+}
 func (UnimplementedApiServiceServer) mustEmbedUnimplementedApiServiceServer() {}
 
 // UnsafeApiServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +162,60 @@ func _ApiService_ListNodes_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ApiService_ListSampleTypes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSampleTypesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServiceServer).ListSampleTypes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.ApiService/ListSampleTypes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServiceServer).ListSampleTypes(ctx, req.(*ListSampleTypesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiService_ListHealthScores_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListHealthScoresRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServiceServer).ListHealthScores(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.ApiService/ListHealthScores",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServiceServer).ListHealthScores(ctx, req.(*ListHealthScoresRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ApiService_ListEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ApiServiceServer).ListEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.v1.ApiService/ListEvents",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ApiServiceServer).ListEvents(ctx, req.(*ListEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ApiService_ServiceDesc is the grpc.ServiceDesc for ApiService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,6 +231,18 @@ var ApiService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListNodes",
 			Handler:    _ApiService_ListNodes_Handler,
 		},
+		{
+			MethodName: "ListSampleTypes",
+			Handler:    _ApiService_ListSampleTypes_Handler,
+		},
+		{
+			MethodName: "ListHealthScores",
+			Handler:    _ApiService_ListHealthScores_Handler,
+		},
+		{
+			MethodName: "ListEvents",
+			Handler:    _ApiService_ListEvents_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "v1/api.proto",
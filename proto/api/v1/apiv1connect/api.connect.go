@@ -29,6 +29,9 @@ const (
 type ApiServiceClient interface {
 	ListSamples(context.Context, *connect_go.Request[v1.ListSampleRequest]) (*connect_go.Response[v1.ListSampleResponse], error)
 	ListNodes(context.Context, *connect_go.Request[v1.ListNodesRequest]) (*connect_go.Response[v1.ListNodesResponse], error)
+	ListSampleTypes(context.Context, *connect_go.Request[v1.ListSampleTypesRequest]) (*connect_go.Response[v1.ListSampleTypesResponse], error)
+	ListHealthScores(context.Context, *connect_go.Request[v1.ListHealthScoresRequest]) (*connect_go.Response[v1.ListHealthScoresResponse], error)
+	ListEvents(context.Context, *connect_go.Request[v1.ListEventsRequest]) (*connect_go.Response[v1.ListEventsResponse], error)
 }
 
 // NewApiServiceClient constructs a client for the api.v1.ApiService service. By default, it uses
@@ -51,13 +54,31 @@ func NewApiServiceClient(httpClient connect_go.HTTPClient, baseURL string, opts
 			baseURL+"/api.v1.ApiService/ListNodes",
 			opts...,
 		),
+		listSampleTypes: connect_go.NewClient[v1.ListSampleTypesRequest, v1.ListSampleTypesResponse](
+			httpClient,
+			baseURL+"/api.v1.ApiService/ListSampleTypes",
+			opts...,
+		),
+		listHealthScores: connect_go.NewClient[v1.ListHealthScoresRequest, v1.ListHealthScoresResponse](
+			httpClient,
+			baseURL+"/api.v1.ApiService/ListHealthScores",
+			opts...,
+		),
+		listEvents: connect_go.NewClient[v1.ListEventsRequest, v1.ListEventsResponse](
+			httpClient,
+			baseURL+"/api.v1.ApiService/ListEvents",
+			opts...,
+		),
 	}
 }
 
 // apiServiceClient implements ApiServiceClient.
 type apiServiceClient struct {
-	listSamples *connect_go.Client[v1.ListSampleRequest, v1.ListSampleResponse]
-	listNodes   *connect_go.Client[v1.ListNodesRequest, v1.ListNodesResponse]
+	listSamples      *connect_go.Client[v1.ListSampleRequest, v1.ListSampleResponse]
+	listNodes        *connect_go.Client[v1.ListNodesRequest, v1.ListNodesResponse]
+	listSampleTypes  *connect_go.Client[v1.ListSampleTypesRequest, v1.ListSampleTypesResponse]
+	listHealthScores *connect_go.Client[v1.ListHealthScoresRequest, v1.ListHealthScoresResponse]
+	listEvents       *connect_go.Client[v1.ListEventsRequest, v1.ListEventsResponse]
 }
 
 // ListSamples calls api.v1.ApiService.ListSamples.
@@ -70,10 +91,28 @@ func (c *apiServiceClient) ListNodes(ctx context.Context, req *connect_go.Reques
 	return c.listNodes.CallUnary(ctx, req)
 }
 
+// ListSampleTypes calls api.v1.ApiService.ListSampleTypes.
+func (c *apiServiceClient) ListSampleTypes(ctx context.Context, req *connect_go.Request[v1.ListSampleTypesRequest]) (*connect_go.Response[v1.ListSampleTypesResponse], error) {
+	return c.listSampleTypes.CallUnary(ctx, req)
+}
+
+// ListHealthScores calls api.v1.ApiService.ListHealthScores.
+func (c *apiServiceClient) ListHealthScores(ctx context.Context, req *connect_go.Request[v1.ListHealthScoresRequest]) (*connect_go.Response[v1.ListHealthScoresResponse], error) {
+	return c.listHealthScores.CallUnary(ctx, req)
+}
+
+// ListEvents calls api.v1.ApiService.ListEvents.
+func (c *apiServiceClient) ListEvents(ctx context.Context, req *connect_go.Request[v1.ListEventsRequest]) (*connect_go.Response[v1.ListEventsResponse], error) {
+	return c.listEvents.CallUnary(ctx, req)
+}
+
 // ApiServiceHandler is an implementation of the api.v1.ApiService service.
 type ApiServiceHandler interface {
 	ListSamples(context.Context, *connect_go.Request[v1.ListSampleRequest]) (*connect_go.Response[v1.ListSampleResponse], error)
 	ListNodes(context.Context, *connect_go.Request[v1.ListNodesRequest]) (*connect_go.Response[v1.ListNodesResponse], error)
+	ListSampleTypes(context.Context, *connect_go.Request[v1.ListSampleTypesRequest]) (*connect_go.Response[v1.ListSampleTypesResponse], error)
+	ListHealthScores(context.Context, *connect_go.Request[v1.ListHealthScoresRequest]) (*connect_go.Response[v1.ListHealthScoresResponse], error)
+	ListEvents(context.Context, *connect_go.Request[v1.ListEventsRequest]) (*connect_go.Response[v1.ListEventsResponse], error)
 }
 
 // NewApiServiceHandler builds an HTTP handler from the service implementation. It returns the path
@@ -93,6 +132,21 @@ func NewApiServiceHandler(svc ApiServiceHandler, opts ...connect_go.HandlerOptio
 		svc.ListNodes,
 		opts...,
 	))
+	mux.Handle("/api.v1.ApiService/ListSampleTypes", connect_go.NewUnaryHandler(
+		"/api.v1.ApiService/ListSampleTypes",
+		svc.ListSampleTypes,
+		opts...,
+	))
+	mux.Handle("/api.v1.ApiService/ListHealthScores", connect_go.NewUnaryHandler(
+		"/api.v1.ApiService/ListHealthScores",
+		svc.ListHealthScores,
+		opts...,
+	))
+	mux.Handle("/api.v1.ApiService/ListEvents", connect_go.NewUnaryHandler(
+		"/api.v1.ApiService/ListEvents",
+		svc.ListEvents,
+		opts...,
+	))
 	return "/api.v1.ApiService/", mux
 }
 
@@ -106,3 +160,15 @@ func (UnimplementedApiServiceHandler) ListSamples(context.Context, *connect_go.R
 func (UnimplementedApiServiceHandler) ListNodes(context.Context, *connect_go.Request[v1.ListNodesRequest]) (*connect_go.Response[v1.ListNodesResponse], error) {
 	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("api.v1.ApiService.ListNodes is not implemented"))
 }
+
+func (UnimplementedApiServiceHandler) ListSampleTypes(context.Context, *connect_go.Request[v1.ListSampleTypesRequest]) (*connect_go.Response[v1.ListSampleTypesResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("api.v1.ApiService.ListSampleTypes is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) ListHealthScores(context.Context, *connect_go.Request[v1.ListHealthScoresRequest]) (*connect_go.Response[v1.ListHealthScoresResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("api.v1.ApiService.ListHealthScores is not implemented"))
+}
+
+func (UnimplementedApiServiceHandler) ListEvents(context.Context, *connect_go.Request[v1.ListEventsRequest]) (*connect_go.Response[v1.ListEventsResponse], error) {
+	return nil, connect_go.NewError(connect_go.CodeUnimplemented, errors.New("api.v1.ApiService.ListEvents is not implemented"))
+}
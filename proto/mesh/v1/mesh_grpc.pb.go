@@ -24,10 +24,32 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type MeshServiceClient interface {
 	JoinMesh(ctx context.Context, in *Node, opts ...grpc.CallOption) (*JoinMeshResponse, error)
-	Ping(ctx context.Context, in *Node, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Ping(ctx context.Context, in *Node, opts ...grpc.CallOption) (*PingResponse, error)
 	NodeDiscovery(ctx context.Context, in *NodeDiscoveryRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Discoveries of the nodes joined within the coalescing window of a seed node
+	NodeDiscoveryBatch(ctx context.Context, in *NodeDiscoveryBatchRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	PushSamples(ctx context.Context, in *Samples, opts ...grpc.CallOption) (*emptypb.Empty, error)
-	Rtt(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Rtt(ctx context.Context, in *RttRequest, opts ...grpc.CallOption) (*RttResponse, error)
+	// Stream the data of a throughput probe, one probe is received at a time
+	Throughput(ctx context.Context, opts ...grpc.CallOption) (MeshService_ThroughputClient, error)
+	// Ping a known node on behalf of the requesting node to confirm a suspect node
+	PingIndirect(ctx context.Context, in *PingIndirectRequest, opts ...grpc.CallOption) (*PingIndirectResponse, error)
+	// Node is leaving the mesh on a clean shutdown
+	LeaveMesh(ctx context.Context, in *Node, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Stream the known samples of the node in pages, requires an API token
+	GetSamples(ctx context.Context, in *GetSamplesRequest, opts ...grpc.CallOption) (MeshService_GetSamplesClient, error)
+	// Digest of the sample store for the anti-entropy sync
+	SampleDigest(ctx context.Context, in *SampleDigestRequest, opts ...grpc.CallOption) (*SampleDigestResponse, error)
+	// Fetch the samples by id, e.g. the newer samples found by the digest
+	FetchSamples(ctx context.Context, in *FetchSamplesRequest, opts ...grpc.CallOption) (*Samples, error)
+	// Version, protocol version & enabled features of the node, also exchanged at join
+	GetInfo(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*NodeInfo, error)
+	// Pull all samples of a known node and merge them by timestamp, requires an API token
+	Resync(ctx context.Context, in *ResyncRequest, opts ...grpc.CallOption) (*ResyncResponse, error)
+	// Suspend the outbound probes of the node, e.g. during a maintenance, requires an API token
+	Pause(ctx context.Context, in *PauseRequest, opts ...grpc.CallOption) (*PauseResponse, error)
+	// Resume the outbound probes of a paused node, requires an API token
+	Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*PauseResponse, error)
 }
 
 type meshServiceClient struct {
@@ -47,8 +69,8 @@ func (c *meshServiceClient) JoinMesh(ctx context.Context, in *Node, opts ...grpc
 	return out, nil
 }
 
-func (c *meshServiceClient) Ping(ctx context.Context, in *Node, opts ...grpc.CallOption) (*emptypb.Empty, error) {
-	out := new(emptypb.Empty)
+func (c *meshServiceClient) Ping(ctx context.Context, in *Node, opts ...grpc.CallOption) (*PingResponse, error) {
+	out := new(PingResponse)
 	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/Ping", in, out, opts...)
 	if err != nil {
 		return nil, err
@@ -65,6 +87,15 @@ func (c *meshServiceClient) NodeDiscovery(ctx context.Context, in *NodeDiscovery
 	return out, nil
 }
 
+func (c *meshServiceClient) NodeDiscoveryBatch(ctx context.Context, in *NodeDiscoveryBatchRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/NodeDiscoveryBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *meshServiceClient) PushSamples(ctx context.Context, in *Samples, opts ...grpc.CallOption) (*emptypb.Empty, error) {
 	out := new(emptypb.Empty)
 	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/PushSamples", in, out, opts...)
@@ -74,8 +105,8 @@ func (c *meshServiceClient) PushSamples(ctx context.Context, in *Samples, opts .
 	return out, nil
 }
 
-func (c *meshServiceClient) Rtt(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error) {
-	out := new(emptypb.Empty)
+func (c *meshServiceClient) Rtt(ctx context.Context, in *RttRequest, opts ...grpc.CallOption) (*RttResponse, error) {
+	out := new(RttResponse)
 	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/Rtt", in, out, opts...)
 	if err != nil {
 		return nil, err
@@ -83,15 +114,175 @@ func (c *meshServiceClient) Rtt(ctx context.Context, in *emptypb.Empty, opts ...
 	return out, nil
 }
 
+func (c *meshServiceClient) Throughput(ctx context.Context, opts ...grpc.CallOption) (MeshService_ThroughputClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MeshService_ServiceDesc.Streams[0], "/mesh.v1.MeshService/Throughput", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &meshServiceThroughputClient{stream}
+	return x, nil
+}
+
+type MeshService_ThroughputClient interface {
+	Send(*ThroughputChunk) error
+	CloseAndRecv() (*ThroughputResponse, error)
+	grpc.ClientStream
+}
+
+type meshServiceThroughputClient struct {
+	grpc.ClientStream
+}
+
+func (x *meshServiceThroughputClient) Send(m *ThroughputChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *meshServiceThroughputClient) CloseAndRecv() (*ThroughputResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ThroughputResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *meshServiceClient) PingIndirect(ctx context.Context, in *PingIndirectRequest, opts ...grpc.CallOption) (*PingIndirectResponse, error) {
+	out := new(PingIndirectResponse)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/PingIndirect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) LeaveMesh(ctx context.Context, in *Node, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/LeaveMesh", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) GetSamples(ctx context.Context, in *GetSamplesRequest, opts ...grpc.CallOption) (MeshService_GetSamplesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MeshService_ServiceDesc.Streams[1], "/mesh.v1.MeshService/GetSamples", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &meshServiceGetSamplesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MeshService_GetSamplesClient interface {
+	Recv() (*Samples, error)
+	grpc.ClientStream
+}
+
+type meshServiceGetSamplesClient struct {
+	grpc.ClientStream
+}
+
+func (x *meshServiceGetSamplesClient) Recv() (*Samples, error) {
+	m := new(Samples)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *meshServiceClient) SampleDigest(ctx context.Context, in *SampleDigestRequest, opts ...grpc.CallOption) (*SampleDigestResponse, error) {
+	out := new(SampleDigestResponse)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/SampleDigest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) FetchSamples(ctx context.Context, in *FetchSamplesRequest, opts ...grpc.CallOption) (*Samples, error) {
+	out := new(Samples)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/FetchSamples", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) GetInfo(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*NodeInfo, error) {
+	out := new(NodeInfo)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/GetInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) Resync(ctx context.Context, in *ResyncRequest, opts ...grpc.CallOption) (*ResyncResponse, error) {
+	out := new(ResyncResponse)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/Resync", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) Pause(ctx context.Context, in *PauseRequest, opts ...grpc.CallOption) (*PauseResponse, error) {
+	out := new(PauseResponse)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/Pause", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshServiceClient) Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*PauseResponse, error) {
+	out := new(PauseResponse)
+	err := c.cc.Invoke(ctx, "/mesh.v1.MeshService/Resume", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MeshServiceServer is the server API for MeshService service.
 // All implementations must embed UnimplementedMeshServiceServer
 // for forward compatibility
 type MeshServiceServer interface {
 	JoinMesh(context.Context, *Node) (*JoinMeshResponse, error)
-	Ping(context.Context, *Node) (*emptypb.Empty, error)
+	Ping(context.Context, *Node) (*PingResponse, error)
 	NodeDiscovery(context.Context, *NodeDiscoveryRequest) (*emptypb.Empty, error)
+	// Discoveries of the nodes joined within the coalescing window of a seed node
+	NodeDiscoveryBatch(context.Context, *NodeDiscoveryBatchRequest) (*emptypb.Empty, error)
 	PushSamples(context.Context, *Samples) (*emptypb.Empty, error)
-	Rtt(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	Rtt(context.Context, *RttRequest) (*RttResponse, error)
+	// Stream the data of a throughput probe, one probe is received at a time
+	Throughput(MeshService_ThroughputServer) error
+	// Ping a known node on behalf of the requesting node to confirm a suspect node
+	PingIndirect(context.Context, *PingIndirectRequest) (*PingIndirectResponse, error)
+	// Node is leaving the mesh on a clean shutdown
+	LeaveMesh(context.Context, *Node) (*emptypb.Empty, error)
+	// Stream the known samples of the node in pages, requires an API token
+	GetSamples(*GetSamplesRequest, MeshService_GetSamplesServer) error
+	// Digest of the sample store for the anti-entropy sync
+	SampleDigest(context.Context, *SampleDigestRequest) (*SampleDigestResponse, error)
+	// Fetch the samples by id, e.g. the newer samples found by the digest
+	FetchSamples(context.Context, *FetchSamplesRequest) (*Samples, error)
+	// Version, protocol version & enabled features of the node, also exchanged at join
+	GetInfo(context.Context, *emptypb.Empty) (*NodeInfo, error)
+	// Pull all samples of a known node and merge them by timestamp, requires an API token
+	Resync(context.Context, *ResyncRequest) (*ResyncResponse, error)
+	// Suspend the outbound probes of the node, e.g. during a maintenance, requires an API token
+	Pause(context.Context, *PauseRequest) (*PauseResponse, error)
+	// Resume the outbound probes of a paused node, requires an API token
+	Resume(context.Context, *ResumeRequest) (*PauseResponse, error)
 	mustEmbedUnimplementedMeshServiceServer()
 }
 
@@ -102,18 +293,51 @@ type UnimplementedMeshServiceServer struct {
 func (UnimplementedMeshServiceServer) JoinMesh(context.Context, *Node) (*JoinMeshResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method JoinMesh not implemented")
 }
-func (UnimplementedMeshServiceServer) Ping(context.Context, *Node) (*emptypb.Empty, error) {
+func (UnimplementedMeshServiceServer) Ping(context.Context, *Node) (*PingResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
 }
 func (UnimplementedMeshServiceServer) NodeDiscovery(context.Context, *NodeDiscoveryRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method NodeDiscovery not implemented")
 }
+func (UnimplementedMeshServiceServer) NodeDiscoveryBatch(context.Context, *NodeDiscoveryBatchRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NodeDiscoveryBatch not implemented")
+}
 func (UnimplementedMeshServiceServer) PushSamples(context.Context, *Samples) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PushSamples not implemented")
 }
-func (UnimplementedMeshServiceServer) Rtt(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+func (UnimplementedMeshServiceServer) Rtt(context.Context, *RttRequest) (*RttResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Rtt not implemented")
 }
+func (UnimplementedMeshServiceServer) Throughput(MeshService_ThroughputServer) error {
+	return status.Errorf(codes.Unimplemented, "method Throughput not implemented")
+}
+func (UnimplementedMeshServiceServer) PingIndirect(context.Context, *PingIndirectRequest) (*PingIndirectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PingIndirect not implemented")
+}
+func (UnimplementedMeshServiceServer) LeaveMesh(context.Context, *Node) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaveMesh not implemented")
+}
+func (UnimplementedMeshServiceServer) GetSamples(*GetSamplesRequest, MeshService_GetSamplesServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetSamples not implemented")
+}
+func (UnimplementedMeshServiceServer) SampleDigest(context.Context, *SampleDigestRequest) (*SampleDigestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SampleDigest not implemented")
+}
+func (UnimplementedMeshServiceServer) FetchSamples(context.Context, *FetchSamplesRequest) (*Samples, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchSamples not implemented")
+}
+func (UnimplementedMeshServiceServer) GetInfo(context.Context, *emptypb.Empty) (*NodeInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetInfo not implemented")
+}
+func (UnimplementedMeshServiceServer) Resync(context.Context, *ResyncRequest) (*ResyncResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resync not implemented")
+}
+func (UnimplementedMeshServiceServer) Pause(context.Context, *PauseRequest) (*PauseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pause not implemented")
+}
+func (UnimplementedMeshServiceServer) Resume(context.Context, *ResumeRequest) (*PauseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resume not implemented")
+}
 func (UnimplementedMeshServiceServer) mustEmbedUnimplementedMeshServiceServer() {}
 
 // UnsafeMeshServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -181,6 +405,24 @@ func _MeshService_NodeDiscovery_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MeshService_NodeDiscoveryBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeDiscoveryBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).NodeDiscoveryBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/NodeDiscoveryBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).NodeDiscoveryBatch(ctx, req.(*NodeDiscoveryBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _MeshService_PushSamples_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(Samples)
 	if err := dec(in); err != nil {
@@ -200,7 +442,7 @@ func _MeshService_PushSamples_Handler(srv interface{}, ctx context.Context, dec
 }
 
 func _MeshService_Rtt_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(emptypb.Empty)
+	in := new(RttRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
@@ -212,7 +454,198 @@ func _MeshService_Rtt_Handler(srv interface{}, ctx context.Context, dec func(int
 		FullMethod: "/mesh.v1.MeshService/Rtt",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(MeshServiceServer).Rtt(ctx, req.(*emptypb.Empty))
+		return srv.(MeshServiceServer).Rtt(ctx, req.(*RttRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_Throughput_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MeshServiceServer).Throughput(&meshServiceThroughputServer{stream})
+}
+
+type MeshService_ThroughputServer interface {
+	SendAndClose(*ThroughputResponse) error
+	Recv() (*ThroughputChunk, error)
+	grpc.ServerStream
+}
+
+type meshServiceThroughputServer struct {
+	grpc.ServerStream
+}
+
+func (x *meshServiceThroughputServer) SendAndClose(m *ThroughputResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *meshServiceThroughputServer) Recv() (*ThroughputChunk, error) {
+	m := new(ThroughputChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _MeshService_PingIndirect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingIndirectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).PingIndirect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/PingIndirect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).PingIndirect(ctx, req.(*PingIndirectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_LeaveMesh_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Node)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).LeaveMesh(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/LeaveMesh",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).LeaveMesh(ctx, req.(*Node))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_GetSamples_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetSamplesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MeshServiceServer).GetSamples(m, &meshServiceGetSamplesServer{stream})
+}
+
+type MeshService_GetSamplesServer interface {
+	Send(*Samples) error
+	grpc.ServerStream
+}
+
+type meshServiceGetSamplesServer struct {
+	grpc.ServerStream
+}
+
+func (x *meshServiceGetSamplesServer) Send(m *Samples) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MeshService_SampleDigest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SampleDigestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).SampleDigest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/SampleDigest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).SampleDigest(ctx, req.(*SampleDigestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_FetchSamples_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchSamplesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).FetchSamples(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/FetchSamples",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).FetchSamples(ctx, req.(*FetchSamplesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_GetInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).GetInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/GetInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).GetInfo(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_Resync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResyncRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).Resync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/Resync",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).Resync(ctx, req.(*ResyncRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_Pause_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).Pause(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/Pause",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).Pause(ctx, req.(*PauseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MeshService_Resume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MeshServiceServer).Resume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mesh.v1.MeshService/Resume",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MeshServiceServer).Resume(ctx, req.(*ResumeRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -236,6 +669,10 @@ var MeshService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "NodeDiscovery",
 			Handler:    _MeshService_NodeDiscovery_Handler,
 		},
+		{
+			MethodName: "NodeDiscoveryBatch",
+			Handler:    _MeshService_NodeDiscoveryBatch_Handler,
+		},
 		{
 			MethodName: "PushSamples",
 			Handler:    _MeshService_PushSamples_Handler,
@@ -244,7 +681,50 @@ var MeshService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Rtt",
 			Handler:    _MeshService_Rtt_Handler,
 		},
+		{
+			MethodName: "PingIndirect",
+			Handler:    _MeshService_PingIndirect_Handler,
+		},
+		{
+			MethodName: "LeaveMesh",
+			Handler:    _MeshService_LeaveMesh_Handler,
+		},
+		{
+			MethodName: "SampleDigest",
+			Handler:    _MeshService_SampleDigest_Handler,
+		},
+		{
+			MethodName: "FetchSamples",
+			Handler:    _MeshService_FetchSamples_Handler,
+		},
+		{
+			MethodName: "GetInfo",
+			Handler:    _MeshService_GetInfo_Handler,
+		},
+		{
+			MethodName: "Resync",
+			Handler:    _MeshService_Resync_Handler,
+		},
+		{
+			MethodName: "Pause",
+			Handler:    _MeshService_Pause_Handler,
+		},
+		{
+			MethodName: "Resume",
+			Handler:    _MeshService_Resume_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Throughput",
+			Handler:       _MeshService_Throughput_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "GetSamples",
+			Handler:       _MeshService_GetSamples_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "v1/mesh.proto",
 }